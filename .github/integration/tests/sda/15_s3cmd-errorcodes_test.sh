@@ -20,6 +20,13 @@ if ! [[ "$bad_request" =~ "Bad Request" ]];then
     exit 1
 fi
 
+# test that files not encrypted with the archive key are rejected
+not_encrypted=$(s3cmd -c s3cfg -q put s3cfg s3://test_dummy.org/not_encrypted.c4gh 2>&1)
+if ! [[ "$not_encrypted" =~ "Bad Request" ]];then
+    echo "test with unencrypted file failed"
+    exit 1
+fi
+
 # test error message when using invalid token
 cp s3cfg bads3cfg
 sed -i "s/access_token=.*/access_token=invalid/" bads3cfg
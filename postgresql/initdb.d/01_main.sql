@@ -38,7 +38,23 @@ VALUES (0, now(), 'Created with version'),
        (21, now(), 'Drop functions set_verified, and set_archived'),
        (22, now(), 'Add file_headers_backup table for key rotation safekeeping'),
        (23, now(), 'Expand files table with storage locations'),
-       (24, now(), 'Add last_event column to files to avoid join on file_event_log');
+       (24, now(), 'Add last_event column to files to avoid join on file_event_log'),
+       (25, now(), 'Add archive_objects table for deduplication of archived files'),
+       (26, now(), 'Add ingest_checkpoints table for resumable ingestion'),
+       (27, now(), 'Add file_scrubs table and scrub role for periodic integrity checks'),
+       (28, now(), 'Add repaired file event and repair role'),
+       (29, now(), 'Add migratestorage role'),
+       (30, now(), 'Give inbox user select privilege in encryption_keys table'),
+       (31, now(), 'Add upload_checksum_states table for checksums computed by the inbox'),
+       (32, now(), 'Give inbox user delete privilege in checksums table for cancelling deleted files'),
+       (33, now(), 'Add inbox_quotas table for per user inbox quotas'),
+       (34, now(), 'Add inbox_expiry_warnings table and housekeeping role'),
+       (35, now(), 'Add sync_files table for tracking the progress of dataset syncs'),
+       (36, now(), 'Add confirmed and rejected statuses to sync_files for remote ingestion results'),
+       (37, now(), 'Add part_size to ingest_checkpoints for uploads bigger than the maximum amount of parts'),
+       (38, now(), 'Add migrated_from_location to files for removing the source copies of migrated files'),
+       (39, now(), 'Add sync_api_requests table for rejecting replayed requests to the sync-api'),
+       (40, now(), 'Add index on checksums for looking up archived files by their uploaded checksum');
 
 -- Datasets are used to group files, and permissions are set on the dataset
 -- level
@@ -76,6 +92,7 @@ VALUES ( 5, 'registered'  , 'Upload to the inbox has started'),
        (60, 'backed up'   , 'File has been backed up'),
        (70, 'ready'       , 'File is ready for access requests'),
        (80, 'downloaded'  , 'Downloaded by user'),
+       (90, 'repaired'    , 'Archived file has been restored from its backup copy'),
        ( 0, 'error'       , 'An Error occurred, check the error table'),
        ( 1, 'disabled'    , 'Disables the file for all actions'),
        ( 2, 'enabled'     , 'Reenables a disabled file');
@@ -97,6 +114,8 @@ CREATE TABLE files (
     decrypted_file_size  BIGINT,
     backup_location      TEXT,
     backup_path          TEXT,
+    -- The archive location the file was last migrated from by migrate-storage
+    migrated_from_location TEXT,
 
     header               TEXT,
     encryption_method    TEXT,
@@ -136,6 +155,7 @@ CREATE TABLE checksums (
     source              checksum_source,
     CONSTRAINT unique_checksum UNIQUE(file_id, type, source)
 );
+CREATE INDEX checksums_checksum_idx ON checksums(checksum);
 
 -- Dataset and references are identifiers used to access and reference the
 -- dataset, such as DOIs. There can be multiple identifiers for each file or
@@ -217,3 +237,103 @@ CREATE TABLE sda.file_headers_backup (
     key_hash    TEXT REFERENCES sda.encryption_keys(key_hash),
     backup_at   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT clock_timestamp()
 );
+
+-- `archive_objects` keeps track of archived objects which are shared by multiple
+-- files when ingest deduplicates identical archived (encrypted) content, the
+-- object is only removed from storage once reference_count reaches zero.
+CREATE TABLE sda.archive_objects (
+    id                  SERIAL PRIMARY KEY,
+    archive_location    TEXT NOT NULL,
+    archive_file_path   TEXT NOT NULL,
+    archive_file_size   BIGINT NOT NULL,
+    checksum            TEXT NOT NULL, -- sha256 of the archived (header stripped) content
+    reference_count     INTEGER NOT NULL DEFAULT 0,
+    created_at          TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT clock_timestamp(),
+    CONSTRAINT unique_archive_object UNIQUE(archive_location, archive_file_path)
+);
+CREATE INDEX archive_objects_checksum_idx ON archive_objects(checksum);
+
+-- `ingest_checkpoints` stores the progress of an ongoing multipart archive upload
+-- so an interrupted ingestion can be resumed from the last uploaded part, the
+-- checkpoint is removed once the file has been archived.
+CREATE TABLE sda.ingest_checkpoints (
+    file_id             UUID REFERENCES sda.files(id) PRIMARY KEY,
+    archive_location    TEXT NOT NULL,
+    archive_file_path   TEXT NOT NULL,
+    upload_id           TEXT NOT NULL,
+    parts_uploaded      INTEGER NOT NULL,
+    part_size           BIGINT NOT NULL DEFAULT 0, -- size of all but the last part, 0 for the chunk size of the archive
+    bytes_archived      BIGINT NOT NULL, -- amount of header stripped content uploaded
+    hash_state          BYTEA NOT NULL, -- marshalled state of the sha256 of the uploaded file
+    updated_at          TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT clock_timestamp()
+);
+
+-- `upload_checksum_states` stores the state of the checksums computed by the
+-- inbox while proxying a multipart upload, a state is stored for each part so
+-- the checksums can be continued by the next part, the states are removed
+-- once the upload is completed or aborted.
+CREATE TABLE sda.upload_checksum_states (
+    upload_id           TEXT NOT NULL,
+    part_number         INTEGER NOT NULL,
+    bytes_uploaded      BIGINT NOT NULL, -- total size of the parts up to and including this part
+    sha256_state        BYTEA NOT NULL, -- marshalled state of the sha256 of the uploaded parts
+    md5_state           BYTEA NOT NULL, -- marshalled state of the md5 of the uploaded parts
+    updated_at          TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT clock_timestamp(),
+    PRIMARY KEY(upload_id, part_number)
+);
+
+-- `file_scrubs` stores the outcome of the last periodic integrity check
+-- (scrub) of the archive and backup copies of each file.
+CREATE TABLE sda.file_scrubs (
+    file_id             UUID REFERENCES sda.files(id) PRIMARY KEY,
+    scrubbed_at         TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT clock_timestamp(),
+    success             BOOLEAN NOT NULL,
+    error               TEXT -- reason the scrub failed, NULL on success
+);
+CREATE INDEX file_scrubs_scrubbed_at_idx ON file_scrubs(scrubbed_at);
+
+-- `inbox_quotas` stores the quotas of users which do not use the default
+-- quota of the inbox, a limit of 0 means the user is not limited.
+CREATE TABLE sda.inbox_quotas (
+    submission_user     TEXT PRIMARY KEY,
+    max_bytes           BIGINT NOT NULL, -- total size of the files in the inbox of the user
+    max_files           BIGINT NOT NULL, -- amount of files in the inbox of the user
+    updated_at          TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT clock_timestamp()
+);
+
+-- `inbox_expiry_warnings` stores when the user was last warned that a file in
+-- their inbox is about to be removed by the housekeeping service.
+CREATE TABLE sda.inbox_expiry_warnings (
+    file_id             UUID REFERENCES sda.files(id) PRIMARY KEY,
+    warned_at           TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT clock_timestamp()
+);
+
+-- `sync_files` stores the progress of syncing files to the destinations of the
+-- sync service, so that files which have already been synced are not copied
+-- again when the sync of a dataset is retried. Files are confirmed when the
+-- remote site has ingested them with the same decrypted checksum, and rejected
+-- when the ingestion failed or the checksums did not match.
+CREATE TABLE sda.sync_files (
+    file_id             UUID REFERENCES sda.files(id),
+    destination         TEXT NOT NULL,
+    status              TEXT NOT NULL CHECK (status IN ('pending', 'copied', 'verified', 'confirmed', 'rejected')),
+    location            TEXT,          -- location of the synced file in the sync storage
+    size                BIGINT,        -- size of the synced file, including its re-encrypted header
+    checksum            TEXT,          -- decrypted sha256 checksum of the file when it was synced
+    attempts            INTEGER NOT NULL DEFAULT 0,
+    last_error          TEXT,
+    updated_at          TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT clock_timestamp(),
+    PRIMARY KEY (file_id, destination)
+);
+
+-- `sync_api_requests` stores the IDs of the requests received by the sync-api
+-- until the requests expire, shared by all replicas of the sync-api, so that
+-- replayed requests are rejected. The partner is empty for requests
+-- authenticated with the basic auth credentials.
+CREATE TABLE sda.sync_api_requests (
+    partner             TEXT NOT NULL,
+    request_id          TEXT NOT NULL,
+    expires_at          TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (partner, request_id)
+);
+CREATE INDEX sync_api_requests_expires_at_idx ON sda.sync_api_requests(expires_at);
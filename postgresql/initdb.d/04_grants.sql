@@ -23,6 +23,14 @@ GRANT USAGE ON SCHEMA sda TO inbox;
 GRANT SELECT, INSERT, UPDATE ON sda.files TO inbox;
 GRANT SELECT, INSERT ON sda.file_event_log TO inbox;
 GRANT USAGE, SELECT ON SEQUENCE sda.file_event_log_id_seq TO inbox;
+-- uses: db.ListKeyHashes for validating the crypt4gh header of uploads
+GRANT SELECT ON sda.encryption_keys TO inbox;
+-- uses: the upload checksum state functions, db.AddUploadedChecksum and db.CancelFile
+GRANT INSERT, SELECT, UPDATE, DELETE ON sda.upload_checksum_states TO inbox;
+GRANT INSERT, SELECT, UPDATE, DELETE ON sda.checksums TO inbox;
+GRANT USAGE, SELECT ON SEQUENCE sda.checksums_id_seq TO inbox;
+-- uses: db.GetInboxQuota
+GRANT SELECT ON sda.inbox_quotas TO inbox;
 
 -- legacy schema
 GRANT USAGE ON SCHEMA local_ega TO inbox;
@@ -30,7 +38,7 @@ GRANT INSERT, SELECT ON local_ega.main_to_files TO inbox;
 GRANT USAGE, SELECT ON SEQUENCE local_ega.main_to_files_main_id_seq TO inbox;
 
 CREATE ROLE ingest;
--- uses: db.InsertFile, db.StoreHeader, and db.SetArchived
+-- uses: db.InsertFile, db.StoreHeader, db.SetArchived, the archive object reference functions, and the ingest checkpoint functions
 GRANT USAGE ON SCHEMA sda TO ingest;
 GRANT INSERT ON sda.files TO ingest;
 GRANT SELECT ON sda.files TO ingest;
@@ -47,6 +55,9 @@ GRANT SELECT ON sda.file_event_log TO ingest;
 GRANT USAGE, SELECT ON SEQUENCE sda.file_event_log_id_seq TO ingest;
 GRANT SELECT ON sda.encryption_keys TO ingest;
 GRANT INSERT ON sda.encryption_keys TO ingest;
+GRANT INSERT, SELECT, UPDATE, DELETE ON sda.archive_objects TO ingest;
+GRANT USAGE, SELECT ON SEQUENCE sda.archive_objects_id_seq TO ingest;
+GRANT INSERT, SELECT, UPDATE, DELETE ON sda.ingest_checkpoints TO ingest;
 
 -- legacy schema
 GRANT USAGE ON SCHEMA local_ega TO ingest;
@@ -146,6 +157,48 @@ GRANT INSERT, SELECT, UPDATE ON sda.file_headers_backup TO rotatekey;
 
 --------------------------------------------------------------------------------
 
+CREATE ROLE repair;
+-- uses: db.GetArchived, db.GetReVerificationDataFromFileID, db.SetArchiveLocation, db.UpdateFileEventLog
+GRANT USAGE ON SCHEMA sda TO repair;
+GRANT SELECT, UPDATE ON sda.files TO repair;
+GRANT SELECT ON sda.file_dataset TO repair;
+GRANT SELECT ON sda.checksums TO repair;
+GRANT INSERT, SELECT ON sda.file_event_log TO repair;
+GRANT USAGE, SELECT ON SEQUENCE sda.file_event_log_id_seq TO repair;
+GRANT SELECT, UPDATE ON sda.archive_objects TO repair;
+--------------------------------------------------------------------------------
+
+CREATE ROLE migratestorage;
+-- uses: db.GetArchived, db.GetReVerificationDataFromFileID, db.SetArchiveLocation, db.IsArchivedObjectReferenced, db.UpdateFileEventLog
+GRANT USAGE ON SCHEMA sda TO migratestorage;
+GRANT SELECT, UPDATE ON sda.files TO migratestorage;
+GRANT SELECT ON sda.file_dataset TO migratestorage;
+GRANT SELECT ON sda.checksums TO migratestorage;
+GRANT INSERT, SELECT ON sda.file_event_log TO migratestorage;
+GRANT USAGE, SELECT ON SEQUENCE sda.file_event_log_id_seq TO migratestorage;
+GRANT SELECT, UPDATE ON sda.archive_objects TO migratestorage;
+--------------------------------------------------------------------------------
+
+CREATE ROLE scrub;
+-- uses: db.GetFilesToScrub, db.SetFileScrubbed, db.UpdateFileEventLog
+GRANT USAGE ON SCHEMA sda TO scrub;
+GRANT SELECT ON sda.files TO scrub;
+GRANT SELECT ON sda.checksums TO scrub;
+GRANT INSERT, SELECT ON sda.file_event_log TO scrub;
+GRANT USAGE, SELECT ON SEQUENCE sda.file_event_log_id_seq TO scrub;
+GRANT INSERT, SELECT, UPDATE ON sda.file_scrubs TO scrub;
+--------------------------------------------------------------------------------
+
+CREATE ROLE housekeeping;
+-- uses: db.GetStaleInboxFiles, db.SetInboxExpiryWarned, db.UpdateFileEventLog
+GRANT USAGE ON SCHEMA sda TO housekeeping;
+GRANT SELECT ON sda.files TO housekeeping;
+GRANT SELECT ON sda.file_dataset TO housekeeping;
+GRANT INSERT, SELECT ON sda.file_event_log TO housekeeping;
+GRANT USAGE, SELECT ON SEQUENCE sda.file_event_log_id_seq TO housekeeping;
+GRANT INSERT, SELECT, UPDATE ON sda.inbox_expiry_warnings TO housekeeping;
+--------------------------------------------------------------------------------
+
 CREATE ROLE sync;
 -- uses: db.GetArchived
 GRANT USAGE ON SCHEMA sda TO sync;
@@ -153,6 +206,8 @@ GRANT SELECT ON sda.files TO sync;
 GRANT SELECT ON sda.file_event_log TO sync;
 GRANT SELECT ON sda.checksums TO sync;
 GRANT SELECT ON sda.file_dataset TO sync;
+GRANT SELECT, INSERT, UPDATE ON sda.sync_files TO sync;
+GRANT SELECT, INSERT, UPDATE, DELETE ON sda.sync_api_requests TO sync;
 
 -- legacy schema
 GRANT USAGE ON SCHEMA local_ega TO sync;
@@ -193,6 +248,8 @@ GRANT SELECT ON sda.dataset_event_log TO api;
 GRANT INSERT ON sda.encryption_keys TO api;
 GRANT UPDATE ON sda.encryption_keys TO api;
 GRANT USAGE, SELECT ON SEQUENCE sda.file_event_log_id_seq TO api;
+GRANT SELECT, INSERT, UPDATE, DELETE ON sda.inbox_quotas TO api;
+GRANT SELECT ON sda.sync_files TO api;
 
 -- legacy schema
 GRANT USAGE ON SCHEMA local_ega TO api;
@@ -0,0 +1,38 @@
+
+DO
+$$
+DECLARE
+-- The version we know how to do migration from, at the end of a successful migration
+-- we will no longer be at this version.
+  sourcever INTEGER := 24;
+  changes VARCHAR := 'Add archive_objects table for deduplication of archived files';
+BEGIN
+  IF (SELECT max(version) FROM sda.dbschema_version) = sourcever THEN
+    RAISE NOTICE 'Doing migration from schema version % to %', sourcever, sourcever+1;
+    RAISE NOTICE 'Changes: %', changes;
+
+    INSERT INTO sda.dbschema_version VALUES(sourcever+1, now(), changes);
+
+    CREATE TABLE IF NOT EXISTS sda.archive_objects (
+        id                  SERIAL PRIMARY KEY,
+        archive_location    TEXT NOT NULL,
+        archive_file_path   TEXT NOT NULL,
+        archive_file_size   BIGINT NOT NULL,
+        checksum            TEXT NOT NULL,
+        reference_count     INTEGER NOT NULL DEFAULT 0,
+        created_at          TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT clock_timestamp(),
+        CONSTRAINT unique_archive_object UNIQUE(archive_location, archive_file_path)
+    );
+    CREATE INDEX IF NOT EXISTS archive_objects_checksum_idx ON sda.archive_objects(checksum);
+
+    -- Grant permissions to the ingest role
+    GRANT INSERT, SELECT, UPDATE, DELETE ON sda.archive_objects TO ingest;
+    GRANT USAGE, SELECT ON SEQUENCE sda.archive_objects_id_seq TO ingest;
+
+    RAISE NOTICE 'Migration to version % completed successfully.', sourcever+1;
+
+  ELSE
+    RAISE NOTICE 'Schema migration from % to % does not apply now, skipping', sourcever, sourcever+1;
+  END IF;
+END
+$$;
@@ -0,0 +1,36 @@
+
+DO
+$$
+DECLARE
+-- The version we know how to do migration from, at the end of a successful migration
+-- we will no longer be at this version.
+  sourcever INTEGER := 25;
+  changes VARCHAR := 'Add ingest_checkpoints table for resumable ingestion';
+BEGIN
+  IF (SELECT max(version) FROM sda.dbschema_version) = sourcever THEN
+    RAISE NOTICE 'Doing migration from schema version % to %', sourcever, sourcever+1;
+    RAISE NOTICE 'Changes: %', changes;
+
+    INSERT INTO sda.dbschema_version VALUES(sourcever+1, now(), changes);
+
+    CREATE TABLE IF NOT EXISTS sda.ingest_checkpoints (
+        file_id             UUID REFERENCES sda.files(id) PRIMARY KEY,
+        archive_location    TEXT NOT NULL,
+        archive_file_path   TEXT NOT NULL,
+        upload_id           TEXT NOT NULL,
+        parts_uploaded      INTEGER NOT NULL,
+        bytes_archived      BIGINT NOT NULL,
+        hash_state          BYTEA NOT NULL,
+        updated_at          TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT clock_timestamp()
+    );
+
+    -- Grant permissions to the ingest role
+    GRANT INSERT, SELECT, UPDATE, DELETE ON sda.ingest_checkpoints TO ingest;
+
+    RAISE NOTICE 'Migration to version % completed successfully.', sourcever+1;
+
+  ELSE
+    RAISE NOTICE 'Schema migration from % to % does not apply now, skipping', sourcever, sourcever+1;
+  END IF;
+END
+$$;
@@ -0,0 +1,55 @@
+DO
+$$
+DECLARE
+-- The version we know how to do migration from, at the end of a successful migration
+-- we will no longer be at this version.
+  sourcever INTEGER := 27;
+  changes VARCHAR := 'Add repaired file event and repair role';
+BEGIN
+  IF (SELECT max(version) FROM sda.dbschema_version) = sourcever THEN
+    RAISE NOTICE 'Doing migration from schema version % to %', sourcever, sourcever+1;
+    RAISE NOTICE 'Changes: %', changes;
+
+    INSERT INTO sda.dbschema_version VALUES(sourcever+1, now(), changes);
+
+    INSERT INTO sda.file_events(id, title, description)
+    VALUES (90, 'repaired', 'Archived file has been restored from its backup copy')
+    ON CONFLICT DO NOTHING;
+
+    -- Temporary function for creating roles if they do not already exist.
+    CREATE FUNCTION create_role_if_not_exists(role_name NAME) RETURNS void AS $created$
+    BEGIN
+        IF EXISTS (
+            SELECT FROM pg_catalog.pg_roles
+            WHERE  rolname = role_name) THEN
+                RAISE NOTICE 'Role "%" already exists. Skipping.', role_name;
+        ELSE
+            BEGIN
+                EXECUTE format('CREATE ROLE %I', role_name);
+            EXCEPTION
+                WHEN duplicate_object THEN
+                    RAISE NOTICE 'Role "%" was just created by a concurrent transaction. Skipping.', role_name;
+            END;
+        END IF;
+    END;
+    $created$ LANGUAGE plpgsql;
+
+    PERFORM create_role_if_not_exists('repair');
+
+    GRANT USAGE ON SCHEMA sda TO repair;
+    GRANT SELECT, UPDATE ON sda.files TO repair;
+    GRANT SELECT ON sda.checksums TO repair;
+    GRANT INSERT, SELECT ON sda.file_event_log TO repair;
+    GRANT USAGE, SELECT ON SEQUENCE sda.file_event_log_id_seq TO repair;
+    GRANT SELECT, UPDATE ON sda.archive_objects TO repair;
+
+    -- Drop temporary user creation function
+    DROP FUNCTION create_role_if_not_exists;
+
+    RAISE NOTICE 'Migration to version % completed successfully.', sourcever+1;
+
+  ELSE
+    RAISE NOTICE 'Schema migration from % to % does not apply now, skipping', sourcever, sourcever+1;
+  END IF;
+END
+$$;
@@ -0,0 +1,36 @@
+DO
+$$
+DECLARE
+-- The version we know how to do migration from, at the end of a successful migration
+-- we will no longer be at this version.
+  sourcever INTEGER := 30;
+  changes VARCHAR := 'Add upload_checksum_states table for checksums computed by the inbox';
+BEGIN
+  IF (SELECT max(version) FROM sda.dbschema_version) = sourcever THEN
+    RAISE NOTICE 'Doing migration from schema version % to %', sourcever, sourcever+1;
+    RAISE NOTICE 'Changes: %', changes;
+
+    INSERT INTO sda.dbschema_version VALUES(sourcever+1, now(), changes);
+
+    CREATE TABLE IF NOT EXISTS sda.upload_checksum_states (
+        upload_id           TEXT NOT NULL,
+        part_number         INTEGER NOT NULL,
+        bytes_uploaded      BIGINT NOT NULL,
+        sha256_state        BYTEA NOT NULL,
+        md5_state           BYTEA NOT NULL,
+        updated_at          TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT clock_timestamp(),
+        PRIMARY KEY(upload_id, part_number)
+    );
+
+    -- Grant permissions to the inbox role
+    GRANT INSERT, SELECT, UPDATE, DELETE ON sda.upload_checksum_states TO inbox;
+    GRANT INSERT, SELECT, UPDATE ON sda.checksums TO inbox;
+    GRANT USAGE, SELECT ON SEQUENCE sda.checksums_id_seq TO inbox;
+
+    RAISE NOTICE 'Migration to version % completed successfully.', sourcever+1;
+
+  ELSE
+    RAISE NOTICE 'Schema migration from % to % does not apply now, skipping', sourcever, sourcever+1;
+  END IF;
+END
+$$;
@@ -0,0 +1,31 @@
+DO
+$$
+DECLARE
+-- The version we know how to do migration from, at the end of a successful migration
+-- we will no longer be at this version.
+  sourcever INTEGER := 32;
+  changes VARCHAR := 'Add inbox_quotas table for per user inbox quotas';
+BEGIN
+  IF (SELECT max(version) FROM sda.dbschema_version) = sourcever THEN
+    RAISE NOTICE 'Doing migration from schema version % to %', sourcever, sourcever+1;
+    RAISE NOTICE 'Changes: %', changes;
+
+    INSERT INTO sda.dbschema_version VALUES(sourcever+1, now(), changes);
+
+    CREATE TABLE IF NOT EXISTS sda.inbox_quotas (
+        submission_user     TEXT PRIMARY KEY,
+        max_bytes           BIGINT NOT NULL,
+        max_files           BIGINT NOT NULL,
+        updated_at          TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT clock_timestamp()
+    );
+
+    GRANT SELECT ON sda.inbox_quotas TO inbox;
+    GRANT SELECT, INSERT, UPDATE, DELETE ON sda.inbox_quotas TO api;
+
+    RAISE NOTICE 'Migration to version % completed successfully.', sourcever+1;
+
+  ELSE
+    RAISE NOTICE 'Schema migration from % to % does not apply now, skipping', sourcever, sourcever+1;
+  END IF;
+END
+$$;
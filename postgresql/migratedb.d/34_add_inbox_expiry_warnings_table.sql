@@ -0,0 +1,56 @@
+DO
+$$
+DECLARE
+-- The version we know how to do migration from, at the end of a successful migration
+-- we will no longer be at this version.
+  sourcever INTEGER := 33;
+  changes VARCHAR := 'Add inbox_expiry_warnings table and housekeeping role';
+BEGIN
+  IF (SELECT max(version) FROM sda.dbschema_version) = sourcever THEN
+    RAISE NOTICE 'Doing migration from schema version % to %', sourcever, sourcever+1;
+    RAISE NOTICE 'Changes: %', changes;
+
+    INSERT INTO sda.dbschema_version VALUES(sourcever+1, now(), changes);
+
+    CREATE TABLE IF NOT EXISTS sda.inbox_expiry_warnings (
+        file_id             UUID REFERENCES sda.files(id) PRIMARY KEY,
+        warned_at           TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT clock_timestamp()
+    );
+
+    -- Temporary function for creating roles if they do not already exist.
+    CREATE FUNCTION create_role_if_not_exists(role_name NAME) RETURNS void AS $created$
+    BEGIN
+        IF EXISTS (
+            SELECT FROM pg_catalog.pg_roles
+            WHERE  rolname = role_name) THEN
+                RAISE NOTICE 'Role "%" already exists. Skipping.', role_name;
+        ELSE
+            BEGIN
+                EXECUTE format('CREATE ROLE %I', role_name);
+            EXCEPTION
+                WHEN duplicate_object THEN
+                    RAISE NOTICE 'Role "%" was just created by a concurrent transaction. Skipping.', role_name;
+            END;
+        END IF;
+    END;
+    $created$ LANGUAGE plpgsql;
+
+    PERFORM create_role_if_not_exists('housekeeping');
+
+    GRANT USAGE ON SCHEMA sda TO housekeeping;
+    GRANT SELECT ON sda.files TO housekeeping;
+    GRANT SELECT ON sda.file_dataset TO housekeeping;
+    GRANT INSERT, SELECT ON sda.file_event_log TO housekeeping;
+    GRANT USAGE, SELECT ON SEQUENCE sda.file_event_log_id_seq TO housekeeping;
+    GRANT INSERT, SELECT, UPDATE ON sda.inbox_expiry_warnings TO housekeeping;
+
+    -- Drop temporary user creation function
+    DROP FUNCTION create_role_if_not_exists;
+
+    RAISE NOTICE 'Migration to version % completed successfully.', sourcever+1;
+
+  ELSE
+    RAISE NOTICE 'Schema migration from % to % does not apply now, skipping', sourcever, sourcever+1;
+  END IF;
+END
+$$;
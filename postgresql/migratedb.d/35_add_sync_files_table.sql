@@ -0,0 +1,37 @@
+DO
+$$
+DECLARE
+-- The version we know how to do migration from, at the end of a successful migration
+-- we will no longer be at this version.
+  sourcever INTEGER := 34;
+  changes VARCHAR := 'Add sync_files table for tracking the progress of dataset syncs';
+BEGIN
+  IF (SELECT max(version) FROM sda.dbschema_version) = sourcever THEN
+    RAISE NOTICE 'Doing migration from schema version % to %', sourcever, sourcever+1;
+    RAISE NOTICE 'Changes: %', changes;
+
+    INSERT INTO sda.dbschema_version VALUES(sourcever+1, now(), changes);
+
+    CREATE TABLE IF NOT EXISTS sda.sync_files (
+        file_id             UUID REFERENCES sda.files(id),
+        destination         TEXT NOT NULL,
+        status              TEXT NOT NULL CHECK (status IN ('pending', 'copied', 'verified')),
+        location            TEXT,
+        size                BIGINT,
+        checksum            TEXT,
+        attempts            INTEGER NOT NULL DEFAULT 0,
+        last_error          TEXT,
+        updated_at          TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT clock_timestamp(),
+        PRIMARY KEY (file_id, destination)
+    );
+
+    GRANT SELECT, INSERT, UPDATE ON sda.sync_files TO sync;
+    GRANT SELECT ON sda.sync_files TO api;
+
+    RAISE NOTICE 'Migration to version % completed successfully.', sourcever+1;
+
+  ELSE
+    RAISE NOTICE 'Schema migration from % to % does not apply now, skipping', sourcever, sourcever+1;
+  END IF;
+END
+$$;
@@ -0,0 +1,25 @@
+DO
+$$
+DECLARE
+-- The version we know how to do migration from, at the end of a successful migration
+-- we will no longer be at this version.
+  sourcever INTEGER := 35;
+  changes VARCHAR := 'Add confirmed and rejected statuses to sync_files for remote ingestion results';
+BEGIN
+  IF (SELECT max(version) FROM sda.dbschema_version) = sourcever THEN
+    RAISE NOTICE 'Doing migration from schema version % to %', sourcever, sourcever+1;
+    RAISE NOTICE 'Changes: %', changes;
+
+    INSERT INTO sda.dbschema_version VALUES(sourcever+1, now(), changes);
+
+    ALTER TABLE sda.sync_files DROP CONSTRAINT IF EXISTS sync_files_status_check;
+    ALTER TABLE sda.sync_files ADD CONSTRAINT sync_files_status_check
+        CHECK (status IN ('pending', 'copied', 'verified', 'confirmed', 'rejected'));
+
+    RAISE NOTICE 'Migration to version % completed successfully.', sourcever+1;
+
+  ELSE
+    RAISE NOTICE 'Schema migration from % to % does not apply now, skipping', sourcever, sourcever+1;
+  END IF;
+END
+$$;
@@ -0,0 +1,24 @@
+DO
+$$
+DECLARE
+-- The version we know how to do migration from, at the end of a successful migration
+-- we will no longer be at this version.
+  sourcever INTEGER := 36;
+  changes VARCHAR := 'Add part_size to ingest_checkpoints for uploads bigger than the maximum amount of parts';
+BEGIN
+  IF (SELECT max(version) FROM sda.dbschema_version) = sourcever THEN
+    RAISE NOTICE 'Doing migration from schema version % to %', sourcever, sourcever+1;
+    RAISE NOTICE 'Changes: %', changes;
+
+    INSERT INTO sda.dbschema_version VALUES(sourcever+1, now(), changes);
+
+    -- Checkpoints of uploads started before the migration used the chunk size of the archive
+    ALTER TABLE sda.ingest_checkpoints ADD COLUMN IF NOT EXISTS part_size BIGINT NOT NULL DEFAULT 0;
+
+    RAISE NOTICE 'Migration to version % completed successfully.', sourcever+1;
+
+  ELSE
+    RAISE NOTICE 'Schema migration from % to % does not apply now, skipping', sourcever, sourcever+1;
+  END IF;
+END
+$$;
@@ -0,0 +1,31 @@
+DO
+$$
+DECLARE
+-- The version we know how to do migration from, at the end of a successful migration
+-- we will no longer be at this version.
+  sourcever INTEGER := 38;
+  changes VARCHAR := 'Add sync_api_requests table for rejecting replayed requests to the sync-api';
+BEGIN
+  IF (SELECT max(version) FROM sda.dbschema_version) = sourcever THEN
+    RAISE NOTICE 'Doing migration from schema version % to %', sourcever, sourcever+1;
+    RAISE NOTICE 'Changes: %', changes;
+
+    INSERT INTO sda.dbschema_version VALUES(sourcever+1, now(), changes);
+
+    CREATE TABLE IF NOT EXISTS sda.sync_api_requests (
+        partner             TEXT NOT NULL,
+        request_id          TEXT NOT NULL,
+        expires_at          TIMESTAMP WITH TIME ZONE NOT NULL,
+        PRIMARY KEY (partner, request_id)
+    );
+    CREATE INDEX IF NOT EXISTS sync_api_requests_expires_at_idx ON sda.sync_api_requests(expires_at);
+
+    GRANT SELECT, INSERT, UPDATE, DELETE ON sda.sync_api_requests TO sync;
+
+    RAISE NOTICE 'Migration to version % completed successfully.', sourcever+1;
+
+  ELSE
+    RAISE NOTICE 'Schema migration from % to % does not apply now, skipping', sourcever, sourcever+1;
+  END IF;
+END
+$$;
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"database/sql"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
@@ -50,6 +51,35 @@ type dataset struct {
 	User         string   `json:"user"`
 }
 
+type storageMigration struct {
+	SourceLocation string `json:"source_location"`
+	DatasetID      string `json:"dataset_id"`
+	User           string `json:"user"`
+}
+
+type inboxQuota struct {
+	MaxBytes *int64 `json:"max_bytes"`
+	MaxFiles *int64 `json:"max_files"`
+}
+
+// syncProgress counts the files of a dataset in each state of syncing them to a destination of the sync service
+type syncProgress struct {
+	Pending   int `json:"pending"`
+	Copied    int `json:"copied"`
+	Verified  int `json:"verified"`
+	Confirmed int `json:"confirmed"`
+	Rejected  int `json:"rejected"`
+}
+
+type fileSyncState struct {
+	AccessionID string     `json:"accession_id"`
+	Destination string     `json:"destination,omitempty"`
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	LastError   string     `json:"last_error,omitempty"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+}
+
 var (
 	Conf        *config.Config
 	err         error
@@ -82,8 +112,8 @@ func run() error {
 		return fmt.Errorf("failed to initialize sda db, due to: %v", err)
 	}
 	defer db.Close()
-	if dbSchemaVersion, err := db.SchemaVersion(); err != nil || dbSchemaVersion < 23 {
-		return errors.Join(errors.New("database schema v23 is required"), err)
+	if dbSchemaVersion, err := db.SchemaVersion(); err != nil || dbSchemaVersion < 35 {
+		return errors.Join(errors.New("database schema v35 is required"), err)
 	}
 
 	Conf.API.MQ, err = broker.NewMQ(Conf.Broker)
@@ -213,15 +243,21 @@ func setup(conf *config.Config) (*http.Server, error) {
 	r.POST("/file/accession", rbac(e), setAccession)                 // assign accession ID to a file
 	r.PUT("/file/verify/:accession", rbac(e), reVerifyFile)          // trigger reverification of a file
 	r.POST("/file/rotatekey/:fileid", rbac(e), rotateKeyFile)        // trigger key rotation for a file
+	r.POST("/file/repair/:fileid", rbac(e), repairFile)              // restore the archive copy of a file from its backup copy
+	r.POST("/storage/migrate", rbac(e), migrateStorage)              // move archived files from one archive location to another
 	r.POST("/dataset/create", rbac(e), createDataset)                // maps a set of files to a dataset
 	r.POST("/dataset/rotatekey/:dataset", rbac(e), rotateKeyDataset) // trigger key rotation for all files in a dataset
 	r.POST("/dataset/release/*dataset", rbac(e), releaseDataset)     // Releases a dataset to be accessible
 	r.PUT("/dataset/verify/*dataset", rbac(e), reVerifyDataset)      // Re-verify all files in the dataset
+	r.GET("/dataset/sync/*dataset", rbac(e), getDatasetSyncProgress) // Shows the progress of syncing the dataset
 	r.GET("/datasets/list", rbac(e), listAllDatasets)                // Lists all datasets with their status
 	r.GET("/datasets/list/:username", rbac(e), listUserDatasets)     // Lists datasets with their status for a specific user
 	r.GET("/users", rbac(e), listActiveUsers)                        // Lists all users
 	r.GET("/users/:username/files", rbac(e), listUserFiles)          // Lists all unmapped files for a user
 	r.GET("/users/:username/file/:fileid", rbac(e), downloadFile)    // Download a file from a users inbox
+	r.GET("/users/:username/quota", rbac(e), getUserQuota)           // Shows the inbox quota and usage of a user
+	r.PUT("/users/:username/quota", rbac(e), setUserQuota)           // Sets the inbox quota of a user
+	r.DELETE("/users/:username/quota", rbac(e), deleteUserQuota)     // Removes the inbox quota of a user, the default quota applies
 
 	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
 
@@ -952,6 +988,143 @@ func rotateKeyFile(c *gin.Context) {
 	c.Status(http.StatusOK)
 }
 
+// repairFile triggers restoring the archive copy of a specific file from its backup copy
+func repairFile(c *gin.Context) {
+	fileID := c.Param("fileid")
+
+	if fileID == "" {
+		c.JSON(http.StatusBadRequest, "file ID is required")
+
+		return
+	}
+
+	repairMsg := schema.RepairFile{
+		Type:   "repair",
+		FileID: fileID,
+	}
+
+	marshaledMsg, err := json.Marshal(&repairMsg)
+	if err != nil {
+		log.Errorf("failed to marshal repair message, reason: %v", err)
+		c.JSON(http.StatusInternalServerError, "failed to marshal repair message")
+
+		return
+	}
+
+	if err := schema.ValidateJSON(fmt.Sprintf("%s/repair-file.json", Conf.Broker.SchemasPath), marshaledMsg); err != nil {
+		log.Errorf("repair message validation failed, reason: %v", err)
+		c.JSON(http.StatusBadRequest, "file ID not a proper UUID")
+
+		return
+	}
+
+	status, err := db.GetFileStatus(c, fileID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		c.JSON(http.StatusNotFound, "file not found")
+
+		return
+	case err != nil:
+		log.Errorf("failed to get status of file %s, reason: %v", fileID, err)
+		c.JSON(http.StatusInternalServerError, "failed to get file status")
+
+		return
+	case status == "disabled":
+		c.JSON(http.StatusBadRequest, "file is disabled")
+
+		return
+	}
+
+	// Only a file with a backup copy can be repaired
+	archiveData, err := db.GetArchived(c, fileID)
+	if err != nil {
+		log.Errorf("failed to get archive data of file %s, reason: %v", fileID, err)
+		c.JSON(http.StatusInternalServerError, "failed to get archive data")
+
+		return
+	}
+	if archiveData == nil || archiveData.BackupLocation == "" {
+		c.JSON(http.StatusBadRequest, "file has no backup copy to repair from")
+
+		return
+	}
+
+	err = Conf.API.MQ.SendMessage(fileID, Conf.Broker.Exchange, "repair", marshaledMsg)
+	if err != nil {
+		log.Errorf("failed to send repair message to queue, reason: %v", err)
+		c.JSON(http.StatusInternalServerError, "failed to send message")
+
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// migrateStorage triggers moving the files archived at a location, optionally limited to a dataset or user, to the
+// destination storage of the migrate-storage service
+func migrateStorage(c *gin.Context) {
+	var migration storageMigration
+	if err := c.BindJSON(&migration); err != nil {
+		c.AbortWithStatusJSON(
+			http.StatusBadRequest,
+			gin.H{
+				"error":  "json decoding : " + err.Error(),
+				"status": http.StatusBadRequest,
+			},
+		)
+
+		return
+	}
+
+	if migration.SourceLocation == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, "source_location is required")
+
+		return
+	}
+
+	fileIDs, err := db.GetFileIDsInArchiveLocation(c, migration.SourceLocation, migration.DatasetID, migration.User)
+	if err != nil {
+		log.Errorf("failed to get files archived at location %s, reason: %v", migration.SourceLocation, err)
+		c.JSON(http.StatusInternalServerError, "failed to get files to migrate")
+
+		return
+	}
+
+	for _, fileID := range fileIDs {
+		migrateMsg := schema.MigrateFile{
+			Type:           "migrate",
+			FileID:         fileID,
+			SourceLocation: migration.SourceLocation,
+		}
+
+		marshaledMsg, err := json.Marshal(&migrateMsg)
+		if err != nil {
+			log.Errorf("failed to marshal migration message for file %s, reason: %v", fileID, err)
+			c.JSON(http.StatusInternalServerError, "failed to marshal migration message")
+
+			return
+		}
+
+		if err := schema.ValidateJSON(fmt.Sprintf("%s/migrate-file.json", Conf.Broker.SchemasPath), marshaledMsg); err != nil {
+			log.Errorf("migration message validation failed for file %s, reason: %v", fileID, err)
+			c.JSON(http.StatusInternalServerError, "migration message validation failed")
+
+			return
+		}
+
+		err = Conf.API.MQ.SendMessage(fileID, Conf.Broker.Exchange, "migratestorage", marshaledMsg)
+		if err != nil {
+			log.Errorf("failed to send migration message for file %s to queue, reason: %v", fileID, err)
+			c.JSON(http.StatusInternalServerError, "failed to send migration message")
+
+			return
+		}
+	}
+
+	log.Infof("migration messages sent for %d files archived at location %s", len(fileIDs), migration.SourceLocation)
+	c.JSON(http.StatusOK, gin.H{"files": len(fileIDs)})
+}
+
 // rotateKeyDataset triggers key rotation for all files in a dataset
 func rotateKeyDataset(c *gin.Context) {
 	datasetID := c.Param("dataset")
@@ -1361,3 +1534,130 @@ func reVerifyDataset(c *gin.Context) {
 
 	c.Status(http.StatusOK)
 }
+
+// getDatasetSyncProgress returns the progress of syncing the files of the dataset to each destination of the sync
+// service, files which have not been synced to a destination are pending for it
+func getDatasetSyncProgress(c *gin.Context) {
+	dataset := strings.TrimPrefix(c.Param("dataset"), "/")
+	states, err := db.GetDatasetSyncStates(c, dataset)
+	if err != nil {
+		log.Errorf("failed to get sync progress of dataset: %s, reason: %v", dataset, err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, "failed to get sync progress")
+
+		return
+	}
+	if len(states) == 0 {
+		c.AbortWithStatusJSON(http.StatusNotFound, "dataset not found")
+
+		return
+	}
+
+	files := make(map[string]bool)
+	destinations := make(map[string]*syncProgress)
+	fileStates := make([]fileSyncState, 0, len(states))
+	for _, state := range states {
+		files[state.AccessionID] = true
+		fileState := fileSyncState{
+			AccessionID: state.AccessionID,
+			Destination: state.Destination,
+			Status:      state.Status,
+			Attempts:    state.Attempts,
+			LastError:   state.LastError,
+		}
+		if state.Destination == "" {
+			fileStates = append(fileStates, fileState)
+
+			continue
+		}
+		fileState.UpdatedAt = &state.UpdatedAt
+		fileStates = append(fileStates, fileState)
+
+		if destinations[state.Destination] == nil {
+			destinations[state.Destination] = &syncProgress{}
+		}
+		switch state.Status {
+		case "copied":
+			destinations[state.Destination].Copied++
+		case "verified":
+			destinations[state.Destination].Verified++
+		case "confirmed":
+			destinations[state.Destination].Confirmed++
+		case "rejected":
+			destinations[state.Destination].Rejected++
+		}
+	}
+	for _, progress := range destinations {
+		progress.Pending = len(files) - progress.Copied - progress.Verified - progress.Confirmed - progress.Rejected
+	}
+
+	c.JSON(http.StatusOK, gin.H{"dataset": dataset, "files": len(files), "destinations": destinations, "file_states": fileStates})
+}
+
+// getUserQuota returns the inbox quota of the user together with the total size and amount of the files in the inbox
+// of the user, the quota is null when the user has the default quota of the inbox
+func getUserQuota(c *gin.Context) {
+	username := c.Param("username")
+
+	quota, err := db.GetInboxQuota(c, username)
+	if err != nil {
+		log.Errorf("failed to get inbox quota of user: %s, reason: %v", username, err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, "failed to get inbox quota")
+
+		return
+	}
+
+	usage, err := db.GetInboxUsage(c, username, "")
+	if err != nil {
+		log.Errorf("failed to get inbox usage of user: %s, reason: %v", username, err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, "failed to get inbox usage")
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user": username, "quota": quota, "usage": usage})
+}
+
+// setUserQuota sets the inbox quota of the user, which replaces the default quota of the inbox for the user
+func setUserQuota(c *gin.Context) {
+	var quota inboxQuota
+	if err := c.BindJSON(&quota); err != nil {
+		c.AbortWithStatusJSON(
+			http.StatusBadRequest,
+			gin.H{
+				"error":  "json decoding : " + err.Error(),
+				"status": http.StatusBadRequest,
+			},
+		)
+
+		return
+	}
+
+	if quota.MaxBytes == nil || quota.MaxFiles == nil || *quota.MaxBytes < 0 || *quota.MaxFiles < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, "max_bytes and max_files are required and can not be negative")
+
+		return
+	}
+
+	username := c.Param("username")
+	if err := db.SetInboxQuota(c, &database.InboxQuota{User: username, MaxBytes: *quota.MaxBytes, MaxFiles: *quota.MaxFiles}); err != nil {
+		log.Errorf("failed to set inbox quota of user: %s, reason: %v", username, err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, "failed to set inbox quota")
+
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// deleteUserQuota removes the inbox quota of the user, after which the default quota of the inbox applies to the user
+func deleteUserQuota(c *gin.Context) {
+	username := c.Param("username")
+	if err := db.DeleteInboxQuota(c, username); err != nil {
+		log.Errorf("failed to delete inbox quota of user: %s, reason: %v", username, err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, "failed to delete inbox quota")
+
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
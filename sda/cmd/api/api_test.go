@@ -26,6 +26,7 @@ import (
 	"github.com/casbin/casbin/v2"
 	"github.com/casbin/casbin/v2/model"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	_ "github.com/lib/pq"
 	"github.com/neicnordic/crypt4gh/keys"
 	"github.com/neicnordic/crypt4gh/streaming"
@@ -3114,6 +3115,194 @@ func (s *TestSuite) TestReVerifyDataset_wrongDatasetName() {
 	assert.Equal(s.T(), http.StatusNotFound, okResponse.StatusCode)
 }
 
+// archiveTestFile registers a file as archived at location, optionally with a backup copy
+func (s *TestSuite) archiveTestFile(user, filePath, location string, backedUp bool) string {
+	fileID, err := db.RegisterFile(context.Background(), nil, s.inboxDir, filePath, user)
+	if err != nil {
+		s.FailNow("failed to register file in database")
+	}
+	if err := db.UpdateFileEventLog(context.Background(), fileID, "uploaded", user, "{}", "{}"); err != nil {
+		s.FailNow("failed to update satus of file in database")
+	}
+
+	fileInfo := &database.FileInfo{
+		ArchivedChecksum:  fmt.Sprintf("%x", sha256.Sum256([]byte("Checksum"))),
+		DecryptedChecksum: fmt.Sprintf("%x", sha256.Sum256([]byte("DecryptedChecksum"))),
+		DecryptedSize:     948,
+		Path:              fileID,
+		Size:              1000,
+		UploadedChecksum:  fmt.Sprintf("%x", sha256.Sum256([]byte("Checksum"))),
+	}
+	if err := db.SetArchived(context.Background(), location, fileInfo, fileID); err != nil {
+		s.FailNow("failed to mark file as Archived")
+	}
+	if err := db.SetVerified(context.Background(), fileInfo, fileID); err != nil {
+		s.FailNow("failed to mark file as Verified")
+	}
+	if backedUp {
+		if err := db.SetBackedUp(context.Background(), "/backup", fileID, fileID); err != nil {
+			s.FailNow("failed to mark file as backed up")
+		}
+	}
+
+	return fileID
+}
+
+// bindTestQueue makes sure that the queue exists and is bound to the exchange, since the broker image used by the
+// tests might predate it, and purges it
+func (s *TestSuite) bindTestQueue(queue string) {
+	client := http.Client{Timeout: 30 * time.Second}
+	for _, request := range []struct{ method, url, body string }{
+		{http.MethodPut, "http://" + brokerAPI + "/api/queues/sda/" + queue, `{"durable":true}`},
+		{http.MethodPost, "http://" + brokerAPI + "/api/bindings/sda/e/sda/q/" + queue, fmt.Sprintf(`{"routing_key":"%s"}`, queue)},
+		{http.MethodDelete, "http://" + brokerAPI + "/api/queues/sda/" + queue + "/contents", ""},
+	} {
+		req, err := http.NewRequest(request.method, request.url, strings.NewReader(request.body))
+		assert.NoError(s.T(), err, "failed to generate query")
+		req.SetBasicAuth("guest", "guest")
+		req.Header.Set("Content-Type", "application/json")
+		res, err := client.Do(req) // #nosec G704 -- request controlled by unit test
+		if err != nil {
+			s.FailNow("failed to query broker", err)
+		}
+		_ = res.Body.Close()
+	}
+}
+
+// queuedTestMessages returns the number of messages ready in the queue
+func (s *TestSuite) queuedTestMessages(queue string) int {
+	req, _ := http.NewRequest(http.MethodGet, "http://"+brokerAPI+"/api/queues/sda/"+queue, http.NoBody)
+	req.SetBasicAuth("guest", "guest")
+	client := http.Client{Timeout: 30 * time.Second}
+	res, err := client.Do(req) // #nosec G704 -- request controlled by unit test
+	assert.NoError(s.T(), err, "failed to query broker")
+	var data struct {
+		MessagesReady int `json:"messages_ready"`
+	}
+	body, err := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	assert.NoError(s.T(), err, "failed to read response from broker")
+	assert.NoError(s.T(), json.Unmarshal(body, &data), "failed to unmarshal response")
+
+	return data.MessagesReady
+}
+
+func (s *TestSuite) TestRepairFile() {
+	s.bindTestQueue("repair")
+	fileID := s.archiveTestFile("TestRepairFile", "/TestRepairFile/file.c4gh", "/archive", true)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/file/repair/"+fileID, http.NoBody)
+	r.Header.Add("Authorization", "Bearer "+s.Token)
+
+	_, router := gin.CreateTestContext(w)
+	router.POST("/file/repair/:fileid", repairFile)
+
+	router.ServeHTTP(w, r)
+	okResponse := w.Result()
+	defer okResponse.Body.Close()
+	assert.Equal(s.T(), http.StatusOK, okResponse.StatusCode)
+
+	// verify that the message shows up in the queue
+	time.Sleep(10 * time.Second) // this is needed to ensure we don't get any false negatives
+	assert.Equal(s.T(), 1, s.queuedTestMessages("repair"))
+}
+
+func (s *TestSuite) TestRepairFile_unknownFile() {
+	for fileID, expectedStatus := range map[string]int{
+		uuid.New().String(): http.StatusNotFound,
+		"not-a-uuid":        http.StatusBadRequest,
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/file/repair/"+fileID, http.NoBody)
+		r.Header.Add("Authorization", "Bearer "+s.Token)
+
+		_, router := gin.CreateTestContext(w)
+		router.POST("/file/repair/:fileid", repairFile)
+
+		router.ServeHTTP(w, r)
+		response := w.Result()
+		assert.Equal(s.T(), expectedStatus, response.StatusCode, fileID)
+		_ = response.Body.Close()
+	}
+}
+
+func (s *TestSuite) TestRepairFile_badState() {
+	s.bindTestQueue("repair")
+
+	// A file which has not been backed up yet has nothing to repair from
+	notBackedUp := s.archiveTestFile("TestRepairFile", "/TestRepairFile/not_backed_up.c4gh", "/archive", false)
+
+	// A disabled file should not be restored
+	disabled := s.archiveTestFile("TestRepairFile", "/TestRepairFile/disabled.c4gh", "/archive", true)
+	if err := db.UpdateFileEventLog(context.Background(), disabled, "disabled", "api", "{}", "{}"); err != nil {
+		s.FailNow("failed to disable file")
+	}
+
+	for _, fileID := range []string{notBackedUp, disabled} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/file/repair/"+fileID, http.NoBody)
+		r.Header.Add("Authorization", "Bearer "+s.Token)
+
+		_, router := gin.CreateTestContext(w)
+		router.POST("/file/repair/:fileid", repairFile)
+
+		router.ServeHTTP(w, r)
+		response := w.Result()
+		assert.Equal(s.T(), http.StatusBadRequest, response.StatusCode, fileID)
+		_ = response.Body.Close()
+	}
+
+	time.Sleep(10 * time.Second) // this is needed to ensure we don't get any false negatives
+	assert.Equal(s.T(), 0, s.queuedTestMessages("repair"))
+}
+
+func (s *TestSuite) TestMigrateStorage() {
+	s.bindTestQueue("migratestorage")
+	s.archiveTestFile("TestMigrateStorage", "/TestMigrateStorage/file1.c4gh", "/archive", true)
+	s.archiveTestFile("TestMigrateStorage", "/TestMigrateStorage/file2.c4gh", "/archive", true)
+	s.archiveTestFile("TestMigrateStorage", "/TestMigrateStorage/file3.c4gh", "/archive2", true)
+	s.archiveTestFile("OtherUser", "/OtherUser/file1.c4gh", "/archive", true)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/storage/migrate", strings.NewReader(`{"source_location": "/archive", "user": "TestMigrateStorage"}`))
+	r.Header.Add("Authorization", "Bearer "+s.Token)
+
+	_, router := gin.CreateTestContext(w)
+	router.POST("/storage/migrate", migrateStorage)
+
+	router.ServeHTTP(w, r)
+	okResponse := w.Result()
+	defer okResponse.Body.Close()
+	assert.Equal(s.T(), http.StatusOK, okResponse.StatusCode)
+
+	var migration struct {
+		Files int `json:"files"`
+	}
+	assert.NoError(s.T(), json.NewDecoder(okResponse.Body).Decode(&migration))
+	assert.Equal(s.T(), 2, migration.Files)
+
+	// verify that the messages show up in the queue
+	time.Sleep(10 * time.Second) // this is needed to ensure we don't get any false negatives
+	assert.Equal(s.T(), 2, s.queuedTestMessages("migratestorage"))
+}
+
+func (s *TestSuite) TestMigrateStorage_badPayload() {
+	for _, payload := range []string{`{"dataset_id": "dataset"}`, `{"source_location": "/archive"`} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/storage/migrate", strings.NewReader(payload))
+		r.Header.Add("Authorization", "Bearer "+s.Token)
+
+		_, router := gin.CreateTestContext(w)
+		router.POST("/storage/migrate", migrateStorage)
+
+		router.ServeHTTP(w, r)
+		response := w.Result()
+		assert.Equal(s.T(), http.StatusBadRequest, response.StatusCode, payload)
+		_ = response.Body.Close()
+	}
+}
+
 func (s *TestSuite) TestDownloadFile() {
 	mockServerAddress := s.GrpcListener.Listener.Addr().String()
 	Conf.API.Grpc.Host, Conf.API.Grpc.Port, err = splitHostPort(mockServerAddress)
@@ -3303,3 +3492,102 @@ func (s *TestSuite) TestReencryptHeader_failedToConnect() {
 	assert.Equal(s.T(), newHeader, []uint8([]byte(nil)), "expected header to be nil")
 	assert.ErrorContains(s.T(), err, "connection refused")
 }
+
+func (s *TestSuite) TestUserQuota() {
+	fileID, err := db.RegisterFile(context.Background(), nil, s.inboxDir, "/quota-user/TestUserQuota.c4gh", "quota-user")
+	if err != nil {
+		s.FailNow("failed to register file in database")
+	}
+	assert.NoError(s.T(), db.SetSubmissionFileSize(context.Background(), fileID, 1234))
+	assert.NoError(s.T(), db.UpdateFileEventLog(context.Background(), fileID, "uploaded", "quota-user", "{}", "{}"))
+
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.Default()
+	r.GET("/users/:username/quota", getUserQuota)
+	r.PUT("/users/:username/quota", setUserQuota)
+	r.DELETE("/users/:username/quota", deleteUserQuota)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	getQuota := func() map[string]any {
+		resp, err := http.Get(ts.URL + "/users/quota-user/quota") // #nosec G107 -- request controlled by unit test
+		assert.NoError(s.T(), err)
+		defer resp.Body.Close()
+		assert.Equal(s.T(), http.StatusOK, resp.StatusCode)
+
+		var quota map[string]any
+		assert.NoError(s.T(), json.NewDecoder(resp.Body).Decode(&quota))
+
+		return quota
+	}
+	doRequest := func(method, body string) int {
+		req, err := http.NewRequest(method, ts.URL+"/users/quota-user/quota", strings.NewReader(body))
+		assert.NoError(s.T(), err)
+		resp, err := http.DefaultClient.Do(req) // #nosec G704 -- request controlled by unit test
+		assert.NoError(s.T(), err)
+		defer resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	// The user has the default quota
+	quota := getQuota()
+	assert.Nil(s.T(), quota["quota"])
+	assert.Equal(s.T(), map[string]any{"bytes": float64(1234), "files": float64(1)}, quota["usage"])
+
+	assert.Equal(s.T(), http.StatusOK, doRequest(http.MethodPut, `{"max_bytes": 10000, "max_files": 0}`))
+	quota = getQuota()
+	assert.Equal(s.T(), map[string]any{"user": "quota-user", "max_bytes": float64(10000), "max_files": float64(0)}, quota["quota"])
+
+	// Both limits are required
+	assert.Equal(s.T(), http.StatusBadRequest, doRequest(http.MethodPut, `{"max_bytes": 10000}`))
+	assert.Equal(s.T(), http.StatusBadRequest, doRequest(http.MethodPut, `{"max_bytes": -1, "max_files": 0}`))
+
+	assert.Equal(s.T(), http.StatusOK, doRequest(http.MethodDelete, ""))
+	quota = getQuota()
+	assert.Nil(s.T(), quota["quota"])
+}
+
+func (s *TestSuite) TestGetDatasetSyncProgress() {
+	for _, name := range []string{"confirmed", "verified", "pending", "unsynced"} {
+		fileID, err := db.RegisterFile(context.Background(), nil, s.inboxDir, "/sync-user/TestGetDatasetSyncProgress/"+name+".c4gh", "sync-user")
+		if err != nil {
+			s.FailNow("failed to register file in database")
+		}
+		assert.NoError(s.T(), db.SetAccessionID(context.Background(), "TestGetDatasetSyncProgress-"+name, fileID))
+		assert.NoError(s.T(), db.MapFileToDataset(context.Background(), "TestGetDatasetSyncProgress", fileID))
+	}
+	assert.NoError(s.T(), db.SetFileSyncState(context.Background(), &database.FileSyncState{AccessionID: "TestGetDatasetSyncProgress-confirmed", Destination: "remote", Status: "confirmed", Attempts: 1}))
+	assert.NoError(s.T(), db.SetFileSyncState(context.Background(), &database.FileSyncState{AccessionID: "TestGetDatasetSyncProgress-verified", Destination: "remote", Status: "verified", Attempts: 1}))
+	assert.NoError(s.T(), db.SetFileSyncState(context.Background(), &database.FileSyncState{AccessionID: "TestGetDatasetSyncProgress-pending", Destination: "remote", Status: "pending", Attempts: 2, LastError: "failed"}))
+
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.Default()
+	r.GET("/dataset/sync/*dataset", getDatasetSyncProgress)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/dataset/sync/TestGetDatasetSyncProgress") // #nosec G107 -- request controlled by unit test
+	assert.NoError(s.T(), err)
+	defer resp.Body.Close()
+	assert.Equal(s.T(), http.StatusOK, resp.StatusCode)
+
+	var progress struct {
+		Files        int                     `json:"files"`
+		Destinations map[string]syncProgress `json:"destinations"`
+		FileStates   []fileSyncState         `json:"file_states"`
+	}
+	assert.NoError(s.T(), json.NewDecoder(resp.Body).Decode(&progress))
+	assert.Equal(s.T(), 4, progress.Files)
+	assert.Equal(s.T(), map[string]syncProgress{"remote": {Pending: 2, Verified: 1, Confirmed: 1}}, progress.Destinations)
+	assert.Len(s.T(), progress.FileStates, 4)
+	assert.Equal(s.T(), "confirmed", progress.FileStates[0].Status)
+	assert.Equal(s.T(), "failed", progress.FileStates[1].LastError)
+	assert.Equal(s.T(), "", progress.FileStates[2].Destination)
+	assert.Nil(s.T(), progress.FileStates[2].UpdatedAt)
+
+	resp, err = http.Get(ts.URL + "/dataset/sync/missing") // #nosec G107 -- request controlled by unit test
+	assert.NoError(s.T(), err)
+	defer resp.Body.Close()
+	assert.Equal(s.T(), http.StatusNotFound, resp.StatusCode)
+}
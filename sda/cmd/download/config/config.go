@@ -93,6 +93,10 @@ var (
 
 	// Pagination configuration
 	paginationHMACSecret string
+
+	// Signed URL configuration
+	signedURLHMACSecret string
+	signedURLTTL        int
 )
 
 func init() {
@@ -684,6 +688,28 @@ func init() {
 				paginationHMACSecret = viper.GetString(flagName)
 			},
 		},
+
+		// Signed URL flags
+		&config.Flag{
+			Name: "signed-url.hmac-secret",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "HMAC secret for signing download URLs, signed URLs are disabled when not set (must be same across replicas)")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				signedURLHMACSecret = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "signed-url.ttl",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Int(flagName, 900, "Maximum lifetime of signed download URLs in seconds")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				signedURLTTL = viper.GetInt(flagName)
+			},
+		},
 	)
 }
 
@@ -968,3 +994,13 @@ func AuditRequired() bool {
 func PaginationHMACSecret() string {
 	return paginationHMACSecret
 }
+
+// SignedURLHMACSecret returns the HMAC secret for signing download URLs.
+func SignedURLHMACSecret() string {
+	return signedURLHMACSecret
+}
+
+// SignedURLTTL returns the maximum lifetime of signed download URLs in seconds.
+func SignedURLTTL() int {
+	return signedURLTTL
+}
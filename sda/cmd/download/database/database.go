@@ -30,13 +30,15 @@ const (
 	getDatasetFilesPageByPathQuery   = "getDatasetFilesPageByPath"
 	getDatasetFilesPageByPrefixQuery = "getDatasetFilesPageByPrefix"
 	getFileChecksumsQuery            = "getFileChecksums"
+	getFilesChecksumsQuery           = "getFilesChecksums"
 )
 
 // paginatedFileBase is the shared SELECT+JOIN+LATERAL block for keyset-paginated
 // dataset file queries. Each variant appends its own WHERE filter and LIMIT clause.
 const paginatedFileBase = `
 		SELECT f.stable_id, f.submission_file_path, f.archive_file_size,
-		       f.decrypted_file_size, cs.checksums
+		       f.decrypted_file_size, cs.checksums, f.created_at,
+		       COALESCE(length(f.header), 0) / 2 AS header_size
 		FROM sda.files f
 		INNER JOIN sda.file_dataset fd ON f.id = fd.file_id
 		INNER JOIN sda.datasets d ON fd.dataset_id = d.id
@@ -150,6 +152,13 @@ var queries = map[string]string{
 		INNER JOIN sda.files f ON c.file_id = f.id
 		WHERE f.stable_id = $1 AND c.source = $2`,
 
+	// getFilesChecksums returns the checksums of a page of files at once
+	getFilesChecksumsQuery: `
+		SELECT f.stable_id, c.checksum, c.type
+		FROM sda.checksums c
+		INNER JOIN sda.files f ON c.file_id = f.id
+		WHERE f.stable_id = ANY($1) AND c.source = $2`,
+
 	// Keyset-paginated file queries compose from paginatedFileBase (defined below).
 
 	// getDatasetFilesPage returns paginated files in a dataset (no path filter).
@@ -224,6 +233,10 @@ type Database interface {
 	// GetFileChecksums returns checksums for a file filtered by source (e.g., "ARCHIVED", "UNENCRYPTED").
 	GetFileChecksums(ctx context.Context, fileID string, source string) ([]Checksum, error)
 
+	// GetFilesChecksums returns checksums for several files filtered by source, by file ID.
+	// Files without checksums are left out of the result.
+	GetFilesChecksums(ctx context.Context, fileIDs []string, source string) (map[string][]Checksum, error)
+
 	// GetDatasetFilesPaginated returns files in a dataset with keyset cursor pagination.
 	// Files are returned with aggregated checksums. Use FileListOptions to filter and paginate.
 	GetDatasetFilesPaginated(ctx context.Context, datasetID string, opts FileListOptions) ([]File, error)
@@ -259,6 +272,7 @@ type File struct {
 	DecryptedChecksum     string     `json:"decryptedChecksum"`
 	DecryptedChecksumType string     `json:"decryptedChecksumType"`
 	Header                []byte     `json:"-"`
+	HeaderSize            int64      `json:"-"` // Size of the stored header (from paginated queries)
 	CreatedAt             time.Time  `json:"-"`
 	Checksums             []Checksum `json:"checksums,omitempty"` // Aggregated checksums (from paginated queries)
 }
@@ -646,6 +660,32 @@ func (p *PostgresDB) GetFileChecksums(ctx context.Context, fileID string, source
 	return checksums, nil
 }
 
+// GetFilesChecksums returns checksums for several files filtered by source, by file ID.
+func (p *PostgresDB) GetFilesChecksums(ctx context.Context, fileIDs []string, source string) (map[string][]Checksum, error) {
+	stmt := p.preparedStatements[getFilesChecksumsQuery]
+	rows, err := stmt.QueryContext(ctx, pq.Array(fileIDs), source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query files checksums: %w", err)
+	}
+	defer rows.Close()
+
+	checksums := make(map[string][]Checksum)
+	for rows.Next() {
+		var fileID string
+		var c Checksum
+		if err := rows.Scan(&fileID, &c.Checksum, &c.Type); err != nil {
+			return nil, fmt.Errorf("failed to scan checksum row: %w", err)
+		}
+		checksums[fileID] = append(checksums[fileID], c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating checksum rows: %w", err)
+	}
+
+	return checksums, nil
+}
+
 // GetDatasetFilesPaginated returns files with keyset cursor pagination and aggregated checksums.
 func (p *PostgresDB) GetDatasetFilesPaginated(ctx context.Context, datasetID string, opts FileListOptions) ([]File, error) {
 	var rows *sql.Rows
@@ -675,7 +715,7 @@ func (p *PostgresDB) GetDatasetFilesPaginated(ctx context.Context, datasetID str
 		var archiveSize, decryptedSize sql.NullInt64
 		var checksumsJSON []byte
 
-		if err := rows.Scan(&f.ID, &f.SubmittedPath, &archiveSize, &decryptedSize, &checksumsJSON); err != nil {
+		if err := rows.Scan(&f.ID, &f.SubmittedPath, &archiveSize, &decryptedSize, &checksumsJSON, &f.CreatedAt, &f.HeaderSize); err != nil {
 			return nil, fmt.Errorf("failed to scan paginated file row: %w", err)
 		}
 
@@ -609,6 +609,10 @@ func (m *mockTestDatabase) GetFileChecksums(_ context.Context, _ string, _ strin
 	return nil, nil
 }
 
+func (m *mockTestDatabase) GetFilesChecksums(_ context.Context, _ []string, _ string) (map[string][]Checksum, error) {
+	return nil, nil
+}
+
 func (m *mockTestDatabase) GetDatasetFilesPaginated(_ context.Context, _ string, _ FileListOptions) ([]File, error) {
 	return nil, nil
 }
@@ -618,10 +622,10 @@ func TestGetDatasetFilesPaginated_NoFilter(t *testing.T) {
 	defer cleanup()
 
 	rows := sqlmock.NewRows([]string{
-		"stable_id", "submission_file_path", "archive_file_size", "decrypted_file_size", "checksums",
+		"stable_id", "submission_file_path", "archive_file_size", "decrypted_file_size", "checksums", "created_at", "header_size",
 	}).
-		AddRow("file-1", "/path/a.txt", int64(1024), int64(900), nil).
-		AddRow("file-2", "/path/b.txt", int64(2048), int64(1800), nil)
+		AddRow("file-1", "/path/a.txt", int64(1024), int64(900), nil, time.Time{}, int64(124)).
+		AddRow("file-2", "/path/b.txt", int64(2048), int64(1800), nil, time.Time{}, int64(124))
 
 	mock.ExpectQuery(queries[getDatasetFilesPageQuery]).
 		WithArgs("dataset-1", "", "", 3).
@@ -634,6 +638,7 @@ func TestGetDatasetFilesPaginated_NoFilter(t *testing.T) {
 	assert.Equal(t, "file-1", files[0].ID)
 	assert.Equal(t, "/path/a.txt", files[0].SubmittedPath)
 	assert.Equal(t, int64(1024), files[0].ArchiveSize)
+	assert.Equal(t, int64(124), files[0].HeaderSize)
 	assert.Empty(t, files[0].Checksums)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
@@ -643,9 +648,9 @@ func TestGetDatasetFilesPaginated_WithCursor(t *testing.T) {
 	defer cleanup()
 
 	rows := sqlmock.NewRows([]string{
-		"stable_id", "submission_file_path", "archive_file_size", "decrypted_file_size", "checksums",
+		"stable_id", "submission_file_path", "archive_file_size", "decrypted_file_size", "checksums", "created_at", "header_size",
 	}).
-		AddRow("file-3", "/path/c.txt", int64(512), int64(400), nil)
+		AddRow("file-3", "/path/c.txt", int64(512), int64(400), nil, time.Time{}, int64(124))
 
 	mock.ExpectQuery(queries[getDatasetFilesPageQuery]).
 		WithArgs("dataset-1", "/path/b.txt", "file-2", 2).
@@ -669,9 +674,9 @@ func TestGetDatasetFilesPaginated_ByPath(t *testing.T) {
 
 	checksumJSON := []byte(`[{"type":"sha256","checksum":"abc123"}]`)
 	rows := sqlmock.NewRows([]string{
-		"stable_id", "submission_file_path", "archive_file_size", "decrypted_file_size", "checksums",
+		"stable_id", "submission_file_path", "archive_file_size", "decrypted_file_size", "checksums", "created_at", "header_size",
 	}).
-		AddRow("file-1", "/exact/path.txt", int64(1024), int64(900), checksumJSON)
+		AddRow("file-1", "/exact/path.txt", int64(1024), int64(900), checksumJSON, time.Time{}, int64(124))
 
 	mock.ExpectQuery(queries[getDatasetFilesPageByPathQuery]).
 		WithArgs("dataset-1", "/exact/path.txt", 2).
@@ -696,10 +701,10 @@ func TestGetDatasetFilesPaginated_ByPrefix(t *testing.T) {
 	defer cleanup()
 
 	rows := sqlmock.NewRows([]string{
-		"stable_id", "submission_file_path", "archive_file_size", "decrypted_file_size", "checksums",
+		"stable_id", "submission_file_path", "archive_file_size", "decrypted_file_size", "checksums", "created_at", "header_size",
 	}).
-		AddRow("file-1", "/data/sample1.txt", int64(100), int64(90), nil).
-		AddRow("file-2", "/data/sample2.txt", int64(200), int64(180), nil)
+		AddRow("file-1", "/data/sample1.txt", int64(100), int64(90), nil, time.Time{}, int64(124)).
+		AddRow("file-2", "/data/sample2.txt", int64(200), int64(180), nil, time.Time{}, int64(124))
 
 	mock.ExpectQuery(queries[getDatasetFilesPageByPrefixQuery]).
 		WithArgs("dataset-1", `/data/%`, "", "", 3).
@@ -722,9 +727,9 @@ func TestGetDatasetFilesPaginated_MultipleChecksums(t *testing.T) {
 
 	checksumJSON := []byte(`[{"type":"sha256","checksum":"abc"},{"type":"md5","checksum":"def"}]`)
 	rows := sqlmock.NewRows([]string{
-		"stable_id", "submission_file_path", "archive_file_size", "decrypted_file_size", "checksums",
+		"stable_id", "submission_file_path", "archive_file_size", "decrypted_file_size", "checksums", "created_at", "header_size",
 	}).
-		AddRow("file-1", "/path/file.txt", int64(1024), int64(900), checksumJSON)
+		AddRow("file-1", "/path/file.txt", int64(1024), int64(900), checksumJSON, time.Time{}, int64(124))
 
 	mock.ExpectQuery(queries[getDatasetFilesPageQuery]).
 		WithArgs("dataset-1", "", "", 2).
@@ -755,6 +760,30 @@ func TestGetDatasetFilesPaginated_QueryError(t *testing.T) {
 	assert.Contains(t, err.Error(), "failed to query paginated dataset files")
 }
 
+func TestGetFilesChecksums(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	rows := sqlmock.NewRows([]string{"stable_id", "checksum", "type"}).
+		AddRow("file-1", "abc", "SHA256").
+		AddRow("file-1", "def", "MD5").
+		AddRow("file-2", "ghi", "SHA256")
+
+	mock.ExpectQuery(queries[getFilesChecksumsQuery]).
+		WithArgs(pq.Array([]string{"file-1", "file-2", "file-3"}), "ARCHIVED").
+		WillReturnRows(rows)
+
+	checksums, err := db.GetFilesChecksums(context.Background(), []string{"file-1", "file-2", "file-3"}, "ARCHIVED")
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]Checksum{
+		"file-1": {{Type: "SHA256", Checksum: "abc"}, {Type: "MD5", Checksum: "def"}},
+		"file-2": {{Type: "SHA256", Checksum: "ghi"}},
+	}, checksums)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestEscapeLikePrefix(t *testing.T) {
 	tests := []struct {
 		input    string
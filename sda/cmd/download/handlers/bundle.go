@@ -0,0 +1,329 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/audit"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/database"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/middleware"
+	log "github.com/sirupsen/logrus"
+)
+
+// bundleManifestName is the name of the manifest written at the end of a bundle.
+const bundleManifestName = "manifest.json"
+
+// bundlePageSize is the amount of files read from the database at a time while bundling.
+const bundlePageSize = 1000
+
+// bundleNamePattern matches the characters replaced in the file name of a bundle.
+var bundleNamePattern = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// BundleManifest lists the files of a dataset bundle.
+type BundleManifest struct {
+	DatasetID  string                `json:"datasetId"`
+	PathPrefix string                `json:"pathPrefix,omitempty"`
+	CreatedAt  string                `json:"createdAt"`
+	Files      []BundleManifestFile  `json:"files"`
+	Errors     []BundleManifestError `json:"errors,omitempty"`
+}
+
+// BundleManifestFile is a file in a bundle. The checksums are those of the
+// decrypted file.
+type BundleManifestFile struct {
+	FileID        string              `json:"fileId"`
+	FilePath      string              `json:"filePath"`
+	Size          int64               `json:"size"`
+	DecryptedSize int64               `json:"decryptedSize"`
+	Checksums     []database.Checksum `json:"checksums"`
+}
+
+// BundleManifestError is a file which was left out of a bundle.
+type BundleManifestError struct {
+	FileID   string `json:"fileId"`
+	FilePath string `json:"filePath"`
+	Error    string `json:"error"`
+}
+
+// bundleWriter writes the entries of a bundle archive.
+type bundleWriter interface {
+	// create starts an entry of the given size, the content of the entry is
+	// written to the returned writer.
+	create(name string, size int64, modified time.Time) (io.Writer, error)
+	Close() error
+}
+
+// tarBundleWriter writes a bundle as a PAX tar archive.
+type tarBundleWriter struct {
+	*tar.Writer
+}
+
+func (w tarBundleWriter) create(name string, size int64, modified time.Time) (io.Writer, error) {
+	err := w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0o644,
+		ModTime:  modified.Truncate(time.Second),
+		Format:   tar.FormatPAX,
+	})
+
+	return w.Writer, err
+}
+
+// zipBundleWriter writes a bundle as an uncompressed zip archive, using zip64
+// for entries and archives larger than 4 GiB.
+type zipBundleWriter struct {
+	*zip.Writer
+}
+
+func (w zipBundleWriter) create(name string, size int64, modified time.Time) (io.Writer, error) {
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: modified,
+	}
+	header.SetMode(0o644)
+
+	return w.CreateHeader(header)
+}
+
+// bundleEntryName returns the name of a file within a bundle, which is the
+// submitted path without leading slashes or parent directory references.
+func bundleEntryName(submittedPath string) string {
+	name := strings.TrimPrefix(path.Clean("/"+submittedPath), "/")
+	if !strings.HasSuffix(name, ".c4gh") {
+		name += ".c4gh"
+	}
+
+	return name
+}
+
+// DownloadDatasetBundle streams the files of a dataset as a tar or zip archive,
+// each file with its header re-encrypted for the recipient public key, followed
+// by a manifest of the files and their checksums.
+// GET /datasets/:datasetId/bundle
+func (h *Handlers) DownloadDatasetBundle(c *gin.Context) {
+	datasetID := c.Param("datasetId")
+
+	authCtx, ok := middleware.GetAuthContext(c)
+	if !ok {
+		problemJSON(c, http.StatusUnauthorized, "authentication required")
+
+		return
+	}
+
+	if !hasDatasetAccess(authCtx.Datasets, datasetID) {
+		problemJSON(c, http.StatusForbidden, "access denied")
+		h.auditDenied(c)
+
+		return
+	}
+
+	exists, err := h.db.CheckDatasetExists(c.Request.Context(), datasetID)
+	if err != nil {
+		log.Errorf("failed to check dataset existence: %v", err)
+		problemJSON(c, http.StatusInternalServerError, "failed to check dataset")
+
+		return
+	}
+
+	if !exists {
+		problemJSON(c, http.StatusForbidden, "access denied")
+		h.auditDenied(c)
+
+		return
+	}
+
+	format := c.DefaultQuery("format", "tar")
+	if format != "tar" && format != "zip" {
+		problemJSONWithCode(c, http.StatusBadRequest, "format must be tar or zip", "UNSUPPORTED_FORMAT")
+
+		return
+	}
+
+	pathPrefix := c.Query("pathPrefix")
+	const maxFilterLen = 4096
+	if len(pathPrefix) > maxFilterLen {
+		problemJSON(c, http.StatusBadRequest, "filter value too long")
+
+		return
+	}
+
+	publicKey, errorCode, detail := extractPublicKey(c)
+	if errorCode != "" {
+		problemJSONWithCode(c, http.StatusBadRequest, detail, errorCode)
+
+		return
+	}
+
+	if h.reencryptClient == nil || h.storageReader == nil {
+		log.Error("reencrypt client or storage reader not configured")
+		problemJSON(c, http.StatusInternalServerError, "download not configured")
+
+		return
+	}
+
+	// The first page is read before the response is started, so that database
+	// errors can still be reported
+	opts := database.FileListOptions{PathPrefix: pathPrefix, Limit: bundlePageSize}
+	files, err := h.db.GetDatasetFilesPaginated(c.Request.Context(), datasetID, opts)
+	if err != nil {
+		log.Errorf("failed to retrieve dataset files: %v", err)
+		problemJSON(c, http.StatusInternalServerError, "failed to retrieve dataset files")
+
+		return
+	}
+
+	var archive bundleWriter
+	contentType := "application/x-tar"
+	if format == "zip" {
+		archive = zipBundleWriter{zip.NewWriter(c.Writer)}
+		contentType = "application/zip"
+	} else {
+		archive = tarBundleWriter{tar.NewWriter(c.Writer)}
+	}
+
+	bundleName := strings.Trim(bundleNamePattern.ReplaceAllString(datasetID, "_"), "_") + "." + format
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": bundleName}))
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+
+	manifest := &BundleManifest{
+		DatasetID:  datasetID,
+		PathPrefix: pathPrefix,
+		CreatedAt:  time.Now().UTC().Format(time.RFC3339),
+		Files:      []BundleManifestFile{},
+	}
+
+	// On errors the archive is left unterminated, so that clients notice the failed download
+	for {
+		for i := range files {
+			if err := h.writeBundleFile(c, archive, manifest, &files[i], publicKey, authCtx); err != nil {
+				log.Errorf("failed to write file %s to bundle: %v", files[i].ID, err)
+				h.auditFailed(c, authCtx, &files[i], "streaming error")
+
+				return
+			}
+		}
+
+		if len(files) < bundlePageSize {
+			break
+		}
+
+		last := files[len(files)-1]
+		opts.CursorPath, opts.CursorID = last.SubmittedPath, last.ID
+		files, err = h.db.GetDatasetFilesPaginated(c.Request.Context(), datasetID, opts)
+		if err != nil {
+			log.Errorf("failed to retrieve dataset files: %v", err)
+			h.auditFailed(c, authCtx, nil, "failed to retrieve dataset files")
+
+			return
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err == nil {
+		var w io.Writer
+		if w, err = archive.create(bundleManifestName, int64(len(data)), time.Now()); err == nil {
+			_, err = w.Write(data)
+		}
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil {
+		log.Errorf("failed to write bundle manifest: %v", err)
+		h.auditFailed(c, authCtx, nil, "streaming error")
+	}
+}
+
+// writeBundleFile writes a file of a dataset to a bundle. Files that cannot be
+// prepared are left out and recorded in the manifest, errors are only returned
+// when writing to the archive fails.
+func (h *Handlers) writeBundleFile(c *gin.Context, archive bundleWriter, manifest *BundleManifest, listed *database.File, publicKey string, authCtx middleware.AuthContext) error {
+	ctx := c.Request.Context()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	name := bundleEntryName(listed.SubmittedPath)
+	skip := func(reason string, err error) error {
+		log.Warnf("leaving file %s out of bundle: %s: %v", listed.ID, reason, err)
+		manifest.Errors = append(manifest.Errors, BundleManifestError{FileID: listed.ID, FilePath: name, Error: reason})
+
+		return nil
+	}
+
+	file, err := h.db.GetFileByID(ctx, listed.ID)
+	if err != nil {
+		return skip("failed to retrieve file info", err)
+	}
+	if file == nil || file.ArchivePath == "" || len(file.Header) == 0 {
+		return skip("file not in archive", errors.New("missing archive path or header"))
+	}
+
+	location, err := h.fileLocation(ctx, file)
+	if err != nil {
+		return skip("file not found in storage", err)
+	}
+
+	newHeader, err := h.reencryptClient.ReencryptHeader(ctx, file.Header, publicKey)
+	if err != nil {
+		return skip("failed to prepare file for download", err)
+	}
+
+	body, err := h.storageReader.NewFileReadSeeker(ctx, location, file.ArchivePath)
+	if err != nil {
+		return skip("failed to open file", err)
+	}
+	defer body.Close()
+
+	size := int64(len(newHeader)) + file.ArchiveSize
+	w, err := archive.create(name, size, file.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(newHeader); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(w, body, file.ArchiveSize); err != nil {
+		return fmt.Errorf("failed to copy file content: %w", err)
+	}
+
+	checksums := listed.Checksums
+	if checksums == nil {
+		checksums = []database.Checksum{}
+	}
+	manifest.Files = append(manifest.Files, BundleManifestFile{
+		FileID:        file.ID,
+		FilePath:      name,
+		Size:          size,
+		DecryptedSize: file.DecryptedSize,
+		Checksums:     checksums,
+	})
+
+	h.auditLogger.Log(ctx, audit.Event{
+		Event:         audit.EventCompleted,
+		UserID:        authCtx.Subject,
+		FileID:        file.ID,
+		DatasetID:     manifest.DatasetID,
+		CorrelationID: c.GetString("correlationId"),
+		Path:          c.Request.URL.Path,
+		HTTPStatus:    http.StatusOK,
+	})
+
+	return nil
+}
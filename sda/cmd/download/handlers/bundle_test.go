@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	c4ghstreaming "github.com/neicnordic/crypt4gh/streaming"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/audit"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newBundleTestEnv is the htsget test dataset, with a listed file that is not in the archive.
+func newBundleTestEnv(t *testing.T) (*htsgetTestEnv, *capturingLogger) {
+	t.Helper()
+	env := newHtsgetTestEnv(t)
+
+	bam := env.db.fileByID
+	bai := env.db.filesByPath["dir/sample.bam.bai.c4gh"]
+	env.db.filesByID = map[string]*database.File{bam.ID: bam, bai.ID: bai}
+	env.db.datasetFiles = []database.File{
+		{ID: "missing-file", SubmittedPath: "dir/missing.c4gh"},
+		{ID: bai.ID, SubmittedPath: bai.SubmittedPath},
+		{ID: bam.ID, SubmittedPath: bam.SubmittedPath, Checksums: []database.Checksum{{Type: "sha256", Checksum: "abc123"}}},
+	}
+
+	logger := &capturingLogger{}
+	env.handlers.auditLogger = logger
+
+	return env, logger
+}
+
+func (env *htsgetTestEnv) bundle(t *testing.T, query string) *httptest.ResponseRecorder {
+	t.Helper()
+	router := setupTestRouterWithAuth([]string{"test-dataset"})
+	router.GET("/datasets/:datasetId/bundle", env.handlers.DownloadDatasetBundle)
+
+	req, _ := http.NewRequest(http.MethodGet, "/datasets/test-dataset/bundle"+query, nil)
+	req.Header.Set("X-C4GH-Public-Key", env.clientPublicKey)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+// checkBundle decrypts the entries of a bundle and checks the manifest.
+func (env *htsgetTestEnv) checkBundle(t *testing.T, entries map[string][]byte) {
+	t.Helper()
+	require.Contains(t, entries, "dir/sample.bam.c4gh")
+	require.Contains(t, entries, "dir/sample.bam.bai.c4gh")
+	require.Contains(t, entries, bundleManifestName)
+	assert.Len(t, entries, 3)
+
+	for _, name := range []string{"dir/sample.bam.c4gh", "dir/sample.bam.bai.c4gh"} {
+		reader, err := c4ghstreaming.NewCrypt4GHReader(bytes.NewReader(entries[name]), env.clientKey, nil)
+		require.NoError(t, err)
+		decrypted, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		if name == "dir/sample.bam.c4gh" {
+			assert.Equal(t, env.plaintext, decrypted)
+		}
+	}
+
+	var manifest BundleManifest
+	require.NoError(t, json.Unmarshal(entries[bundleManifestName], &manifest))
+	assert.Equal(t, "test-dataset", manifest.DatasetID)
+	require.Len(t, manifest.Files, 2)
+	assert.Equal(t, "bam-file", manifest.Files[1].FileID)
+	assert.Equal(t, int64(len(entries["dir/sample.bam.c4gh"])), manifest.Files[1].Size)
+	assert.Equal(t, []database.Checksum{{Type: "sha256", Checksum: "abc123"}}, manifest.Files[1].Checksums)
+	assert.Equal(t, []BundleManifestError{{FileID: "missing-file", FilePath: "dir/missing.c4gh", Error: "file not in archive"}}, manifest.Errors)
+}
+
+func TestDownloadDatasetBundle_tar(t *testing.T) {
+	env, logger := newBundleTestEnv(t)
+
+	w := env.bundle(t, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "application/x-tar", w.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename=test-dataset.tar`, w.Header().Get("Content-Disposition"))
+
+	entries := map[string][]byte{}
+	reader := tar.NewReader(w.Body)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		entries[header.Name], err = io.ReadAll(reader)
+		require.NoError(t, err)
+	}
+	env.checkBundle(t, entries)
+
+	require.Len(t, logger.events, 2)
+	assert.Equal(t, audit.EventCompleted, logger.events[0].Event)
+	assert.Equal(t, "test-dataset", logger.events[0].DatasetID)
+}
+
+func TestDownloadDatasetBundle_zip(t *testing.T) {
+	env, _ := newBundleTestEnv(t)
+
+	w := env.bundle(t, "?format=zip")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+
+	reader, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	require.NoError(t, err)
+	entries := map[string][]byte{}
+	for _, f := range reader.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		entries[f.Name], err = io.ReadAll(rc)
+		require.NoError(t, err)
+		rc.Close()
+	}
+	env.checkBundle(t, entries)
+}
+
+func TestDownloadDatasetBundle_pathPrefix(t *testing.T) {
+	env, _ := newBundleTestEnv(t)
+
+	w := env.bundle(t, "?pathPrefix=dir/sample.bam.bai")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var names []string
+	reader := tar.NewReader(w.Body)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, header.Name)
+	}
+	assert.Equal(t, []string{"dir/sample.bam.bai.c4gh", bundleManifestName}, names)
+}
+
+func TestDownloadDatasetBundle_invalidRequest(t *testing.T) {
+	env, _ := newBundleTestEnv(t)
+
+	w := env.bundle(t, "?format=rar")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var response ProblemDetails
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "UNSUPPORTED_FORMAT", response.ErrorCode)
+
+	router := setupTestRouterWithAuth([]string{"test-dataset"})
+	router.GET("/datasets/:datasetId/bundle", env.handlers.DownloadDatasetBundle)
+
+	req, _ := http.NewRequest(http.MethodGet, "/datasets/test-dataset/bundle", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "KEY_MISSING", response.ErrorCode)
+
+	req, _ = http.NewRequest(http.MethodGet, "/datasets/other-dataset/bundle", nil)
+	req.Header.Set("X-C4GH-Public-Key", env.clientPublicKey)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestBundleEntryName(t *testing.T) {
+	assert.Equal(t, "dir/file.c4gh", bundleEntryName("dir/file.c4gh"))
+	assert.Equal(t, "dir/file.txt.c4gh", bundleEntryName("/dir/file.txt"))
+	assert.Equal(t, "etc/passwd.c4gh", bundleEntryName("../../etc/passwd"))
+}
@@ -1,23 +1,45 @@
 package handlers
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"path"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/config"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/database"
 	"github.com/neicnordic/sensitive-data-archive/cmd/download/middleware"
 	log "github.com/sirupsen/logrus"
 )
 
-// DrsObject represents a GA4GH DRS object response.
+// drsBulkMaxObjects is the maximum number of objects of a bulk request.
+const drsBulkMaxObjects = 1000
+
+// drsBulkMaxBodySize is the maximum size of the body of a bulk request.
+const drsBulkMaxBodySize = 1 << 20
+
+// errDrsNoChecksums is returned when a file has no ARCHIVED checksums.
+var errDrsNoChecksums = errors.New("file has no checksums")
+
+// DrsObject represents a GA4GH DRS object response. Files are single blob
+// objects with access methods, datasets are bundles with contents.
 type DrsObject struct {
-	ID            string            `json:"id"`
-	SelfURI       string            `json:"self_uri"`
-	Size          int64             `json:"size"`
-	CreatedTime   string            `json:"created_time"`
-	Checksums     []DrsChecksum     `json:"checksums"`
-	AccessMethods []DrsAccessMethod `json:"access_methods"`
+	ID            string              `json:"id"`
+	Name          string              `json:"name,omitempty"`
+	SelfURI       string              `json:"self_uri"`
+	Size          int64               `json:"size"`
+	CreatedTime   string              `json:"created_time"`
+	Description   string              `json:"description,omitempty"`
+	Checksums     []DrsChecksum       `json:"checksums"`
+	AccessMethods []DrsAccessMethod   `json:"access_methods,omitempty"`
+	Contents      []DrsContentsObject `json:"contents,omitempty"`
 }
 
 // DrsChecksum represents a checksum in a DRS object.
@@ -26,17 +48,72 @@ type DrsChecksum struct {
 	Type     string `json:"type"`
 }
 
-// DrsAccessMethod represents an access method in a DRS object.
+// DrsAccessMethod represents an access method in a DRS object. Either the
+// access URL or the access ID is set, an access ID is exchanged for an access
+// URL at /objects/{object_id}/access/{access_id}.
 type DrsAccessMethod struct {
-	Type      string       `json:"type"`
-	AccessURL DrsAccessURL `json:"access_url"`
+	Type      string        `json:"type"`
+	AccessURL *DrsAccessURL `json:"access_url,omitempty"`
+	AccessID  string        `json:"access_id,omitempty"`
 }
 
 // DrsAccessURL represents an access URL in a DRS access method.
 type DrsAccessURL struct {
-	URL string `json:"url"`
+	URL     string   `json:"url"`
+	Headers []string `json:"headers,omitempty"`
+}
+
+// DrsContentsObject represents a file in a DRS bundle.
+type DrsContentsObject struct {
+	Name   string   `json:"name"`
+	ID     string   `json:"id"`
+	DrsURI []string `json:"drs_uri"`
+}
+
+// DrsAuthorizations describes how access to a DRS object is authorized.
+type DrsAuthorizations struct {
+	DrsObjectID       string   `json:"drs_object_id,omitempty"`
+	SupportedTypes    []string `json:"supported_types"`
+	BearerAuthIssuers []string `json:"bearer_auth_issuers,omitempty"`
+}
+
+// DrsBulkRequest is the request body of bulk object and authorization requests.
+type DrsBulkRequest struct {
+	BulkObjectIDs []string `json:"bulk_object_ids"`
+}
+
+// DrsSummary summarizes the result of a bulk request.
+type DrsSummary struct {
+	Requested  int `json:"requested"`
+	Resolved   int `json:"resolved"`
+	Unresolved int `json:"unresolved"`
+}
+
+// DrsUnresolved lists the object IDs of a bulk request that could not be
+// resolved, by error code.
+type DrsUnresolved struct {
+	ErrorCode int      `json:"error_code"`
+	ObjectIDs []string `json:"object_ids"`
 }
 
+// DrsBulkObjects is the response of a bulk object request.
+type DrsBulkObjects struct {
+	Summary              DrsSummary      `json:"summary"`
+	UnresolvedDrsObjects []DrsUnresolved `json:"unresolved_drs_objects"`
+	ResolvedDrsObject    []DrsObject     `json:"resolved_drs_object"`
+}
+
+// DrsBulkAuthorizations is the response of a bulk authorization request.
+type DrsBulkAuthorizations struct {
+	Summary              DrsSummary          `json:"summary"`
+	UnresolvedDrsObjects []DrsUnresolved     `json:"unresolved_drs_objects"`
+	ResolvedDrsObject    []DrsAuthorizations `json:"resolved_drs_object"`
+}
+
+// drsSignedURLAccessID is the access ID exchanged for a signed URL to the
+// content of a file.
+const drsSignedURLAccessID = "signed-url"
+
 // drsChecksumType normalises an SDA checksum type to the DRS/GA4GH lowercase form.
 func drsChecksumType(sdaType string) string {
 	switch strings.ToLower(sdaType) {
@@ -51,20 +128,188 @@ func drsChecksumType(sdaType string) string {
 	}
 }
 
-// GetDrsObject returns a GA4GH DRS object for a file identified by dataset and path.
+// drsURI returns the DRS URI of an object served by this host.
+func drsURI(c *gin.Context, objectID string) string {
+	return fmt.Sprintf("drs://%s/%s", c.Request.Host, objectID)
+}
+
+// drsInternalError logs an error resolving a DRS object and responds with 500.
+func drsInternalError(c *gin.Context, err error) {
+	log.Errorf("failed to resolve DRS object: %v", err)
+	if errors.Is(err, errDrsNoChecksums) {
+		problemJSON(c, http.StatusInternalServerError, "file has no checksums")
+
+		return
+	}
+
+	problemJSON(c, http.StatusInternalServerError, "failed to retrieve object")
+}
+
+// drsFileObject returns the DRS object of a file. The size and checksums
+// describe the encrypted blob served by the access URL.
+func (h *Handlers) drsFileObject(c *gin.Context, file *database.File) (*DrsObject, error) {
+	// Fetch ARCHIVED checksums (over the encrypted blob, per DRS 1.5 spec)
+	archivedChecksums, err := h.db.GetFileChecksums(c.Request.Context(), file.ID, "ARCHIVED")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get checksums of file %s: %w", file.ID, err)
+	}
+
+	if len(archivedChecksums) == 0 {
+		return nil, fmt.Errorf("file %s: %w", file.ID, errDrsNoChecksums)
+	}
+
+	checksums := make([]DrsChecksum, len(archivedChecksums))
+	for i, ac := range archivedChecksums {
+		checksums[i] = DrsChecksum{
+			Checksum: ac.Checksum,
+			Type:     drsChecksumType(ac.Type),
+		}
+	}
+
+	scheme := "https"
+	if c.Request.TLS == nil {
+		scheme = "http"
+	}
+
+	obj := &DrsObject{
+		ID:          file.ID,
+		Name:        path.Base(file.SubmittedPath),
+		SelfURI:     drsURI(c, file.ID),
+		Size:        file.ArchiveSize,
+		CreatedTime: file.CreatedAt.UTC().Format("2006-01-02T15:04:05Z07:00"),
+		Checksums:   checksums,
+		AccessMethods: []DrsAccessMethod{
+			{
+				Type: scheme,
+				AccessURL: &DrsAccessURL{
+					URL: fmt.Sprintf("%s/files/%s/content", requestBaseURL(c), file.ID),
+				},
+			},
+		},
+	}
+	if h.signedURLSecret != nil {
+		obj.AccessMethods = append(obj.AccessMethods, DrsAccessMethod{Type: scheme, AccessID: drsSignedURLAccessID})
+	}
+
+	return obj, nil
+}
+
+// drsBundleObject returns the DRS bundle of a dataset, which contains all files
+// of the dataset named by their paths. The checksum of the bundle is the SHA-256
+// of the sorted and concatenated SHA-256 checksums of its files.
+func (h *Handlers) drsBundleObject(c *gin.Context, info *database.DatasetInfo) (*DrsObject, error) {
+	ctx := c.Request.Context()
+
+	obj := &DrsObject{
+		ID:          info.ID,
+		Name:        info.Title,
+		SelfURI:     drsURI(c, info.ID),
+		CreatedTime: info.CreatedAt.UTC().Format("2006-01-02T15:04:05Z07:00"),
+		Description: info.Description,
+		Contents:    []DrsContentsObject{},
+	}
+
+	var fileChecksums []string
+	opts := database.FileListOptions{Limit: bundlePageSize}
+	for {
+		files, err := h.db.GetDatasetFilesPaginated(ctx, info.ID, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve files of dataset %s: %w", info.ID, err)
+		}
+
+		// The checksums of a page of files are fetched at once, as a bundle can contain many files
+		fileIDs := make([]string, len(files))
+		for i, file := range files {
+			fileIDs[i] = file.ID
+		}
+		pageChecksums, err := h.db.GetFilesChecksums(ctx, fileIDs, "ARCHIVED")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get checksums of files of dataset %s: %w", info.ID, err)
+		}
+
+		for _, file := range files {
+			var checksum string
+			for _, cs := range pageChecksums[file.ID] {
+				if drsChecksumType(cs.Type) == "sha-256" {
+					checksum = strings.ToLower(cs.Checksum)
+				}
+			}
+			if checksum == "" {
+				return nil, fmt.Errorf("file %s: %w", file.ID, errDrsNoChecksums)
+			}
+
+			fileChecksums = append(fileChecksums, checksum)
+			obj.Size += file.ArchiveSize
+			obj.Contents = append(obj.Contents, DrsContentsObject{
+				Name:   file.SubmittedPath,
+				ID:     file.ID,
+				DrsURI: []string{drsURI(c, file.ID)},
+			})
+		}
+
+		if len(files) < bundlePageSize {
+			break
+		}
+
+		last := files[len(files)-1]
+		opts.CursorPath, opts.CursorID = last.SubmittedPath, last.ID
+	}
+
+	sort.Strings(fileChecksums)
+	sum := sha256.Sum256([]byte(strings.Join(fileChecksums, "")))
+	obj.Checksums = []DrsChecksum{{Checksum: hex.EncodeToString(sum[:]), Type: "sha-256"}}
+
+	return obj, nil
+}
+
+// resolveDrsObjectID returns the DRS object with the given ID, which is either
+// a dataset the user has access to or a file in one. Returns (nil, nil) when the
+// object does not exist or the user has no access to it.
+func (h *Handlers) resolveDrsObjectID(c *gin.Context, authCtx middleware.AuthContext, objectID string) (*DrsObject, error) {
+	ctx := c.Request.Context()
+
+	if hasDatasetAccess(authCtx.Datasets, objectID) {
+		info, err := h.db.GetDatasetInfo(ctx, objectID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve dataset %s: %w", objectID, err)
+		}
+		if info != nil {
+			return h.drsBundleObject(c, info)
+		}
+	}
+
+	file, err := h.db.GetFileByID(ctx, objectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve file %s: %w", objectID, err)
+	}
+	if file == nil || !hasDatasetAccess(authCtx.Datasets, file.DatasetID) {
+		return nil, nil
+	}
+
+	return h.drsFileObject(c, file)
+}
+
+// GetDrsObject returns a GA4GH DRS object by object ID, which is a file or
+// dataset ID, or for a file identified by dataset and path. Paths of the form
+// {objectId}/access/signed-url are access requests, which take precedence over
+// a file at that path.
+// GET /objects/{objectId}
 // GET /objects/{datasetId}/{filePath}
 func (h *Handlers) GetDrsObject(c *gin.Context) {
 	rawPath := strings.TrimPrefix(c.Param("path"), "/")
 
-	idx := strings.Index(rawPath, "/")
-	if idx <= 0 || idx == len(rawPath)-1 {
-		problemJSON(c, http.StatusBadRequest, "path must contain {datasetId}/{filePath}")
+	if parts := strings.Split(rawPath, "/"); len(parts) == 3 && parts[1] == "access" && parts[2] == drsSignedURLAccessID {
+		h.getDrsAccessURL(c, parts[0])
 
 		return
 	}
 
-	datasetID := rawPath[:idx]
-	filePath := rawPath[idx+1:]
+	idx := strings.Index(rawPath, "/")
+	if rawPath == "" || idx == 0 || idx == len(rawPath)-1 {
+		problemJSON(c, http.StatusBadRequest, "path must contain {objectId} or {datasetId}/{filePath}")
+
+		return
+	}
 
 	authCtx, ok := middleware.GetAuthContext(c)
 	if !ok {
@@ -73,74 +318,195 @@ func (h *Handlers) GetDrsObject(c *gin.Context) {
 		return
 	}
 
-	if !hasDatasetAccess(authCtx.Datasets, datasetID) {
+	var obj *DrsObject
+	var err error
+	if idx < 0 {
+		obj, err = h.resolveDrsObjectID(c, authCtx, rawPath)
+	} else {
+		obj, err = h.resolveDrsObjectPath(c, authCtx, rawPath[:idx], rawPath[idx+1:])
+	}
+	if err != nil {
+		drsInternalError(c, err)
+
+		return
+	}
+
+	if obj == nil {
 		problemJSON(c, http.StatusForbidden, "access denied")
 		h.auditDenied(c)
 
 		return
 	}
 
+	c.Header("Cache-Control", "private, max-age=60, must-revalidate")
+	c.JSON(http.StatusOK, obj)
+}
+
+// resolveDrsObjectPath returns the DRS object of a file identified by dataset
+// and path. Returns (nil, nil) when the file does not exist or the user has no
+// access to the dataset.
+func (h *Handlers) resolveDrsObjectPath(c *gin.Context, authCtx middleware.AuthContext, datasetID, filePath string) (*DrsObject, error) {
+	if !hasDatasetAccess(authCtx.Datasets, datasetID) {
+		return nil, nil
+	}
+
 	file, err := h.db.GetFileByPath(c.Request.Context(), datasetID, filePath)
 	if err != nil {
-		log.Errorf("failed to get file by path: %v", err)
-		problemJSON(c, http.StatusInternalServerError, "failed to retrieve file")
-
-		return
+		return nil, fmt.Errorf("failed to get file by path: %w", err)
 	}
 
 	if file == nil {
-		problemJSON(c, http.StatusForbidden, "access denied")
-		h.auditDenied(c)
+		return nil, nil
+	}
 
-		return
+	return h.drsFileObject(c, file)
+}
+
+// decodeDrsBulkRequest reads the object IDs of a bulk request.
+// Returns (nil, false) if an error response was already sent.
+func decodeDrsBulkRequest(c *gin.Context) ([]string, bool) {
+	var request DrsBulkRequest
+	if err := json.NewDecoder(http.MaxBytesReader(c.Writer, c.Request.Body, drsBulkMaxBodySize)).Decode(&request); err != nil || len(request.BulkObjectIDs) == 0 {
+		problemJSON(c, http.StatusBadRequest, "request body must contain a non-empty bulk_object_ids list")
+
+		return nil, false
 	}
 
-	host := c.Request.Host
-	scheme := "https"
-	if c.Request.TLS == nil {
-		scheme = "http"
+	if len(request.BulkObjectIDs) > drsBulkMaxObjects {
+		problemJSON(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("at most %d objects can be requested at once", drsBulkMaxObjects))
+
+		return nil, false
 	}
 
-	// Fetch ARCHIVED checksums (over the encrypted blob, per DRS 1.5 spec)
-	archivedChecksums, err := h.db.GetFileChecksums(c.Request.Context(), file.ID, "ARCHIVED")
-	if err != nil {
-		log.Errorf("failed to get file checksums: %v", err)
-		problemJSON(c, http.StatusInternalServerError, "failed to retrieve checksums")
+	return request.BulkObjectIDs, true
+}
+
+// GetDrsObjects returns the DRS objects of a list of object IDs. Objects which
+// do not exist or the user has no access to are returned as unresolved with
+// error code 403.
+// POST /objects
+func (h *Handlers) GetDrsObjects(c *gin.Context) {
+	authCtx, ok := middleware.GetAuthContext(c)
+	if !ok {
+		problemJSON(c, http.StatusUnauthorized, "authentication required")
 
 		return
 	}
 
-	if len(archivedChecksums) == 0 {
-		log.Errorf("file %s has no ARCHIVED checksums", file.ID)
-		problemJSON(c, http.StatusInternalServerError, "file has no checksums")
-
+	objectIDs, ok := decodeDrsBulkRequest(c)
+	if !ok {
 		return
 	}
 
-	checksums := make([]DrsChecksum, len(archivedChecksums))
-	for i, ac := range archivedChecksums {
-		checksums[i] = DrsChecksum{
-			Checksum: ac.Checksum,
-			Type:     drsChecksumType(ac.Type),
+	response := DrsBulkObjects{
+		UnresolvedDrsObjects: []DrsUnresolved{},
+		ResolvedDrsObject:    []DrsObject{},
+	}
+	var unresolved []string
+	for _, objectID := range objectIDs {
+		obj, err := h.resolveDrsObjectID(c, authCtx, objectID)
+		if err != nil {
+			drsInternalError(c, err)
+
+			return
+		}
+
+		if obj == nil {
+			unresolved = append(unresolved, objectID)
+
+			continue
 		}
+
+		response.ResolvedDrsObject = append(response.ResolvedDrsObject, *obj)
 	}
 
-	obj := DrsObject{
-		ID:          file.ID,
-		SelfURI:     fmt.Sprintf("drs://%s/%s", host, file.ID),
-		Size:        file.ArchiveSize,
-		CreatedTime: file.CreatedAt.UTC().Format("2006-01-02T15:04:05Z07:00"),
-		Checksums:   checksums,
-		AccessMethods: []DrsAccessMethod{
-			{
-				Type: scheme,
-				AccessURL: DrsAccessURL{
-					URL: fmt.Sprintf("%s://%s/files/%s/content", scheme, host, file.ID),
-				},
-			},
-		},
+	if len(unresolved) > 0 {
+		response.UnresolvedDrsObjects = append(response.UnresolvedDrsObjects, DrsUnresolved{ErrorCode: http.StatusForbidden, ObjectIDs: unresolved})
+	}
+	response.Summary = DrsSummary{
+		Requested:  len(objectIDs),
+		Resolved:   len(response.ResolvedDrsObject),
+		Unresolved: len(unresolved),
 	}
 
 	c.Header("Cache-Control", "private, max-age=60, must-revalidate")
-	c.JSON(http.StatusOK, obj)
+	c.JSON(http.StatusOK, response)
+}
+
+// drsAuthorizations returns how access to a DRS object is authorized. It is the
+// same for all objects, so that it does not reveal which objects exist.
+func drsAuthorizations(objectID string) DrsAuthorizations {
+	authorizations := DrsAuthorizations{
+		DrsObjectID:    objectID,
+		SupportedTypes: []string{"BearerAuth"},
+	}
+	if issuer := config.OIDCIssuer(); issuer != "" {
+		authorizations.BearerAuthIssuers = []string{issuer}
+	}
+
+	return authorizations
+}
+
+// OptionsDrsObject returns how access to a DRS object is authorized, so that
+// clients can discover which token to send. No authentication is required.
+// OPTIONS /objects/{objectId}
+func (h *Handlers) OptionsDrsObject(c *gin.Context) {
+	objectID := strings.TrimPrefix(c.Param("path"), "/")
+	if objectID == "" {
+		problemJSON(c, http.StatusBadRequest, "path must contain {objectId}")
+
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, drsAuthorizations(objectID))
+}
+
+// OptionsDrsObjects returns how access to a list of DRS objects is authorized.
+// No authentication is required.
+// OPTIONS /objects
+func (h *Handlers) OptionsDrsObjects(c *gin.Context) {
+	objectIDs, ok := decodeDrsBulkRequest(c)
+	if !ok {
+		return
+	}
+
+	response := DrsBulkAuthorizations{
+		Summary:              DrsSummary{Requested: len(objectIDs), Resolved: len(objectIDs)},
+		UnresolvedDrsObjects: []DrsUnresolved{},
+		ResolvedDrsObject:    make([]DrsAuthorizations, len(objectIDs)),
+	}
+	for i, objectID := range objectIDs {
+		response.ResolvedDrsObject[i] = drsAuthorizations(objectID)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// getDrsAccessURL returns a signed URL to the content of a file, which can be
+// downloaded without a bearer token.
+// GET /objects/{objectId}/access/signed-url
+func (h *Handlers) getDrsAccessURL(c *gin.Context, objectID string) {
+	if h.signedURLSecret == nil {
+		problemJSON(c, http.StatusNotFound, "access method not found")
+
+		return
+	}
+
+	publicKey, errorCode, detail := extractPublicKey(c)
+	if errorCode == "KEY_CONFLICT" {
+		problemJSONWithCode(c, http.StatusBadRequest, detail, errorCode)
+
+		return
+	}
+
+	authCtx, file, ok := h.authorizeFile(c, objectID)
+	if !ok {
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, DrsAccessURL{
+		URL: h.signFileURL(c, authCtx, file.ID, "/content", publicKey, time.Now().Add(h.signedURLTTL)),
+	})
 }
@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -125,7 +128,7 @@ func TestGetDrsObject_MalformedPath_Returns400(t *testing.T) {
 		name string
 		path string
 	}{
-		{"no slash", "/objects/just-dataset-id"},
+		{"empty", "/objects/"},
 		{"trailing slash", "/objects/dataset/"},
 		{"empty dataset", "/objects//file.bam"},
 	}
@@ -281,3 +284,166 @@ func TestDrsChecksumType(t *testing.T) {
 		})
 	}
 }
+
+func TestGetDrsObject_ByObjectID(t *testing.T) {
+	router := setupTestRouterWithAuth([]string{"EGAD00001000001"})
+	mockDB := &mockDatabase{
+		filesByID: map[string]*database.File{
+			"urn:neic:001-002-003": {
+				ID:            "urn:neic:001-002-003",
+				DatasetID:     "EGAD00001000001",
+				SubmittedPath: "samples/sample1.bam.c4gh",
+				ArchiveSize:   2097152,
+				CreatedAt:     time.Date(2026, 1, 15, 10, 30, 0, 0, time.UTC),
+			},
+			"urn:neic:other": {ID: "urn:neic:other", DatasetID: "EGAD00001000002"},
+		},
+		fileChecksums: []database.Checksum{{Type: "SHA256", Checksum: "a1b2c3d4"}},
+	}
+	h, err := New(WithDatabase(mockDB))
+	require.NoError(t, err)
+
+	router.GET("/objects/*path", h.GetDrsObject)
+
+	req, _ := http.NewRequest(http.MethodGet, "/objects/urn:neic:001-002-003", nil)
+	req.Host = "download.example.org"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp DrsObject
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "urn:neic:001-002-003", resp.ID)
+	assert.Equal(t, "sample1.bam.c4gh", resp.Name)
+	assert.Equal(t, "drs://download.example.org/urn:neic:001-002-003", resp.SelfURI)
+	require.Len(t, resp.AccessMethods, 1)
+	assert.Equal(t, "http://download.example.org/files/urn:neic:001-002-003/content", resp.AccessMethods[0].AccessURL.URL)
+	assert.Empty(t, resp.Contents)
+
+	// Files in datasets the user has no access to are hidden, as are missing files
+	for _, objectID := range []string{"urn:neic:other", "urn:neic:missing"} {
+		req, _ = http.NewRequest(http.MethodGet, "/objects/"+objectID, nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code, objectID)
+	}
+}
+
+func TestGetDrsObject_Bundle(t *testing.T) {
+	router := setupTestRouterWithAuth([]string{"EGAD00001000001"})
+	mockDB := &mockDatabase{
+		datasetInfo: &database.DatasetInfo{
+			ID:          "EGAD00001000001",
+			Title:       "Test dataset",
+			Description: "A dataset",
+			CreatedAt:   time.Date(2026, 1, 15, 10, 30, 0, 0, time.UTC),
+		},
+		datasetFiles: []database.File{
+			{ID: "file-1", SubmittedPath: "a/1.c4gh", ArchiveSize: 100},
+			{ID: "file-2", SubmittedPath: "b/2.c4gh", ArchiveSize: 200},
+		},
+		fileChecksums: []database.Checksum{{Type: "MD5", Checksum: "def"}, {Type: "SHA256", Checksum: "ABC"}},
+	}
+	h, err := New(WithDatabase(mockDB))
+	require.NoError(t, err)
+
+	router.GET("/objects/*path", h.GetDrsObject)
+
+	req, _ := http.NewRequest(http.MethodGet, "/objects/EGAD00001000001", nil)
+	req.Host = "download.example.org"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp DrsObject
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "EGAD00001000001", resp.ID)
+	assert.Equal(t, "Test dataset", resp.Name)
+	assert.Equal(t, int64(300), resp.Size)
+	assert.Empty(t, resp.AccessMethods)
+	assert.Equal(t, []DrsContentsObject{
+		{Name: "a/1.c4gh", ID: "file-1", DrsURI: []string{"drs://download.example.org/file-1"}},
+		{Name: "b/2.c4gh", ID: "file-2", DrsURI: []string{"drs://download.example.org/file-2"}},
+	}, resp.Contents)
+
+	sum := sha256.Sum256([]byte("abcabc"))
+	assert.Equal(t, []DrsChecksum{{Checksum: hex.EncodeToString(sum[:]), Type: "sha-256"}}, resp.Checksums)
+	// The checksums of both files are fetched in a single query
+	assert.Equal(t, 1, mockDB.checksumQueries)
+
+	// A file without a SHA-256 checksum cannot be part of the bundle checksum
+	mockDB.fileChecksums = []database.Checksum{{Type: "MD5", Checksum: "def"}}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestGetDrsObjects_Bulk(t *testing.T) {
+	router := setupTestRouterWithAuth([]string{"EGAD00001000001"})
+	mockDB := &mockDatabase{
+		filesByID: map[string]*database.File{
+			"file-1": {ID: "file-1", DatasetID: "EGAD00001000001", SubmittedPath: "a/1.c4gh"},
+			"file-2": {ID: "file-2", DatasetID: "EGAD00001000002", SubmittedPath: "b/2.c4gh"},
+		},
+		fileChecksums: []database.Checksum{{Type: "SHA256", Checksum: "abc"}},
+	}
+	h, err := New(WithDatabase(mockDB))
+	require.NoError(t, err)
+
+	router.POST("/objects", h.GetDrsObjects)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/objects", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		return w
+	}
+
+	w := post(`{"bulk_object_ids": ["file-1", "file-2", "missing"]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp DrsBulkObjects
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, DrsSummary{Requested: 3, Resolved: 1, Unresolved: 2}, resp.Summary)
+	require.Len(t, resp.ResolvedDrsObject, 1)
+	assert.Equal(t, "file-1", resp.ResolvedDrsObject[0].ID)
+	assert.Equal(t, []DrsUnresolved{{ErrorCode: http.StatusForbidden, ObjectIDs: []string{"file-2", "missing"}}}, resp.UnresolvedDrsObjects)
+
+	assert.Equal(t, http.StatusBadRequest, post(`{"bulk_object_ids": []}`).Code)
+	assert.Equal(t, http.StatusBadRequest, post(`not json`).Code)
+
+	ids, _ := json.Marshal(make([]string, drsBulkMaxObjects+1))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post(`{"bulk_object_ids": `+string(ids)+`}`).Code)
+}
+
+func TestOptionsDrsObject(t *testing.T) {
+	router := setupTestRouter()
+	h, err := New(WithDatabase(&mockDatabase{}))
+	require.NoError(t, err)
+
+	router.OPTIONS("/objects", h.OptionsDrsObjects)
+	router.OPTIONS("/objects/*path", h.OptionsDrsObject)
+
+	req, _ := http.NewRequest(http.MethodOptions, "/objects/urn:neic:001-002-003", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp DrsAuthorizations
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "urn:neic:001-002-003", resp.DrsObjectID)
+	assert.Equal(t, []string{"BearerAuth"}, resp.SupportedTypes)
+
+	req, _ = http.NewRequest(http.MethodOptions, "/objects", strings.NewReader(`{"bulk_object_ids": ["a", "b"]}`))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var bulk DrsBulkAuthorizations
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &bulk))
+	assert.Equal(t, DrsSummary{Requested: 2, Resolved: 2}, bulk.Summary)
+	require.Len(t, bulk.ResolvedDrsObject, 2)
+	assert.Equal(t, "b", bulk.ResolvedDrsObject[1].DrsObjectID)
+}
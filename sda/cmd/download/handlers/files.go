@@ -35,35 +35,34 @@ type resolvedFile struct {
 	etag      string
 }
 
-// resolveFileBase performs auth, permission check, file lookup,
-// and storage resolution common to both full-download and content-only endpoints.
+// authorizeFile performs auth, permission check and file lookup for a file.
+// Requests authenticated by a signed URL skip the permission check, which was
+// done when the URL was signed.
 // Returns (nil, false) if an error response was already sent.
-func (h *Handlers) resolveFileBase(c *gin.Context) (*resolvedBase, bool) {
-	fileID := c.Param("fileId")
-
+func (h *Handlers) authorizeFile(c *gin.Context, fileID string) (middleware.AuthContext, *database.File, bool) {
 	// Get auth context
 	authCtx, ok := middleware.GetAuthContext(c)
 	if !ok {
 		problemJSON(c, http.StatusUnauthorized, "authentication required")
 
-		return nil, false
+		return authCtx, nil, false
 	}
 
 	// Permission check: return 403 for both "no access" AND "not found" (no existence leakage)
-	if !config.JWTAllowAllData() {
+	if getSignedURLClaims(c) == nil && !config.JWTAllowAllData() {
 		hasPermission, err := h.db.CheckFilePermission(c.Request.Context(), fileID, authCtx.Datasets)
 		if err != nil {
 			log.Errorf("failed to check file permission: %v", err)
 			problemJSON(c, http.StatusInternalServerError, "failed to check file permission")
 
-			return nil, false
+			return authCtx, nil, false
 		}
 
 		if !hasPermission {
 			problemJSON(c, http.StatusForbidden, "access denied")
 			h.auditDenied(c)
 
-			return nil, false
+			return authCtx, nil, false
 		}
 	}
 
@@ -73,7 +72,7 @@ func (h *Handlers) resolveFileBase(c *gin.Context) (*resolvedBase, bool) {
 		log.Errorf("failed to retrieve file info: %v", err)
 		problemJSON(c, http.StatusInternalServerError, "failed to retrieve file info")
 
-		return nil, false
+		return authCtx, nil, false
 	}
 
 	if file == nil {
@@ -81,6 +80,18 @@ func (h *Handlers) resolveFileBase(c *gin.Context) (*resolvedBase, bool) {
 		problemJSON(c, http.StatusForbidden, "access denied")
 		h.auditDenied(c)
 
+		return authCtx, nil, false
+	}
+
+	return authCtx, file, true
+}
+
+// resolveFileBase performs auth, permission check, file lookup,
+// and storage resolution common to both full-download and content-only endpoints.
+// Returns (nil, false) if an error response was already sent.
+func (h *Handlers) resolveFileBase(c *gin.Context) (*resolvedBase, bool) {
+	authCtx, file, ok := h.authorizeFile(c, c.Param("fileId"))
+	if !ok {
 		return nil, false
 	}
 
@@ -105,6 +116,7 @@ func (h *Handlers) resolveFileBase(c *gin.Context) (*resolvedBase, bool) {
 
 		log.Warnf("file %s has no archive_location stored, falling back to FindFile search", file.ID)
 
+		var err error
 		location, err = h.storageReader.FindFile(c.Request.Context(), file.ArchivePath)
 		if err != nil {
 			log.Errorf("failed to find file in storage: %v", err)
@@ -267,6 +279,7 @@ func (h *Handlers) DownloadFile(c *gin.Context) {
 	h.auditLogger.Log(c.Request.Context(), audit.Event{
 		Event:         audit.EventCompleted,
 		UserID:        resolved.authCtx.Subject,
+		AuthType:      resolved.authCtx.AuthSource,
 		FileID:        file.ID,
 		DatasetID:     file.DatasetID,
 		CorrelationID: c.GetString("correlationId"),
@@ -349,6 +362,7 @@ func (h *Handlers) GetFileHeader(c *gin.Context) {
 	h.auditLogger.Log(c.Request.Context(), audit.Event{
 		Event:         audit.EventHeader,
 		UserID:        resolved.authCtx.Subject,
+		AuthType:      resolved.authCtx.AuthSource,
 		FileID:        file.ID,
 		DatasetID:     file.DatasetID,
 		CorrelationID: c.GetString("correlationId"),
@@ -450,6 +464,7 @@ func (h *Handlers) GetFileContent(c *gin.Context) {
 	h.auditLogger.Log(c.Request.Context(), audit.Event{
 		Event:         audit.EventContent,
 		UserID:        resolved.authCtx.Subject,
+		AuthType:      resolved.authCtx.AuthSource,
 		FileID:        file.ID,
 		DatasetID:     file.DatasetID,
 		CorrelationID: c.GetString("correlationId"),
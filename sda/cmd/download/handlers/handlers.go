@@ -3,8 +3,11 @@ package handlers
 
 import (
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/neicnordic/crypt4gh/keys"
 	"github.com/neicnordic/sensitive-data-archive/cmd/download/audit"
 	"github.com/neicnordic/sensitive-data-archive/cmd/download/database"
 	"github.com/neicnordic/sensitive-data-archive/cmd/download/middleware"
@@ -25,6 +28,14 @@ type Handlers struct {
 	serviceID       string
 	serviceOrgName  string
 	serviceOrgURL   string
+	// signedURLSecret signs URLs which can be used without a bearer token,
+	// signed URLs are disabled when it is not set
+	signedURLSecret []byte
+	signedURLTTL    time.Duration
+	// c4ghPublicKey and c4ghPrivateKey are generated at startup, file headers are
+	// re-encrypted for them when the service reads files itself, such as htsget indexes
+	c4ghPublicKey  [32]byte
+	c4ghPrivateKey [32]byte
 }
 
 // New creates a new Handlers instance with the given options.
@@ -42,6 +53,12 @@ func New(options ...func(*Handlers)) (*Handlers, error) {
 		return nil, errors.New("database is required")
 	}
 
+	var err error
+	h.c4ghPublicKey, h.c4ghPrivateKey, err = keys.GenerateKeyPair()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate crypt4gh key pair: %w", err)
+	}
+
 	return h, nil
 }
 
@@ -51,6 +68,7 @@ func (h *Handlers) auditDenied(c *gin.Context) {
 	h.auditLogger.Log(c.Request.Context(), audit.Event{
 		Event:         audit.EventDenied,
 		UserID:        authCtx.Subject,
+		AuthType:      authCtx.AuthSource,
 		CorrelationID: c.GetString("correlationId"),
 		Path:          c.Request.URL.Path,
 		HTTPStatus:    c.Writer.Status(),
@@ -68,6 +86,7 @@ func (h *Handlers) auditFailed(c *gin.Context, authCtx middleware.AuthContext, f
 	h.auditLogger.Log(c.Request.Context(), audit.Event{
 		Event:         audit.EventFailed,
 		UserID:        authCtx.Subject,
+		AuthType:      authCtx.AuthSource,
 		FileID:        fileID,
 		DatasetID:     datasetID,
 		CorrelationID: c.GetString("correlationId"),
@@ -99,11 +118,12 @@ func (h *Handlers) RegisterRoutes(r *gin.Engine) {
 		datasets.GET("", h.ListDatasets)
 		datasets.GET("/:datasetId", h.GetDataset)
 		datasets.GET("/:datasetId/files", h.ListDatasetFiles)
+		datasets.GET("/:datasetId/bundle", h.DownloadDatasetBundle)
 	}
 
 	// Files (auth required)
 	files := r.Group("/files")
-	files.Use(middleware.TokenMiddleware(h.db, h.visaValidator, h.auditLogger))
+	files.Use(h.signedURLMiddleware(middleware.TokenMiddleware(h.db, h.visaValidator, h.auditLogger)))
 	{
 		files.HEAD("/:fileId", h.HeadFile)
 		files.GET("/:fileId", h.DownloadFile)
@@ -111,12 +131,36 @@ func (h *Handlers) RegisterRoutes(r *gin.Engine) {
 		files.GET("/:fileId/header", h.GetFileHeader)
 		files.HEAD("/:fileId/content", h.HeadFileContent)
 		files.GET("/:fileId/content", h.GetFileContent)
+		if h.signedURLSecret != nil {
+			files.POST("/:fileId/signed-url", h.CreateSignedURL)
+		}
+	}
+
+	// htsget (auth required)
+	htsgetGroup := r.Group("/htsget")
+	htsgetGroup.Use(middleware.TokenMiddleware(h.db, h.visaValidator, h.auditLogger))
+	{
+		htsgetGroup.GET("/reads/:fileId", h.HtsgetReads)
+		htsgetGroup.GET("/variants/:fileId", h.HtsgetVariants)
 	}
 
+	// S3 compatible read API (auth required)
+	s3 := r.Group("/s3")
+	s3.Use(middleware.TokenMiddleware(h.db, h.visaValidator, h.auditLogger))
+	{
+		s3.GET("/*path", h.S3Get)
+		s3.HEAD("/*path", h.S3Head)
+	}
+
+	// DRS authorization discovery (no auth required)
+	r.OPTIONS("/objects", h.OptionsDrsObjects)
+	r.OPTIONS("/objects/*path", h.OptionsDrsObject)
+
 	// DRS objects (auth required)
 	objects := r.Group("/objects")
 	objects.Use(middleware.TokenMiddleware(h.db, h.visaValidator, h.auditLogger))
 	{
+		objects.POST("", h.GetDrsObjects)
 		objects.GET("/*path", h.GetDrsObject)
 	}
 }
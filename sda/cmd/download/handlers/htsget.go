@@ -0,0 +1,425 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	c4ghstreaming "github.com/neicnordic/crypt4gh/streaming"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/audit"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/database"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/htsget"
+	log "github.com/sirupsen/logrus"
+)
+
+// htsgetContentType is the media type of htsget tickets.
+const htsgetContentType = "application/vnd.ga4gh.htsget.v1.3.0+json"
+
+// errHtsgetNotFound indicates that the index of a file or the requested reference does not exist.
+var errHtsgetNotFound = errors.New("not found")
+
+// HtsgetTicket is the response to an htsget request, the URLs are fetched in
+// order and concatenated by the client.
+type HtsgetTicket struct {
+	Htsget HtsgetResponse `json:"htsget"`
+}
+
+// HtsgetResponse lists the URLs that make up the requested data.
+type HtsgetResponse struct {
+	Format string      `json:"format"`
+	URLs   []HtsgetURL `json:"urls"`
+}
+
+// HtsgetURL is a block of the requested data, either inlined as a data URI or
+// fetched with the given headers.
+type HtsgetURL struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Class   string            `json:"class,omitempty"`
+}
+
+// htsgetQuery holds the parsed query parameters of an htsget request.
+type htsgetQuery struct {
+	format        string
+	headerOnly    bool
+	referenceName string
+	start         int64
+	end           int64
+}
+
+// HtsgetReads returns an htsget ticket for a region of a BAM or CRAM file.
+// GET /htsget/reads/:fileId
+func (h *Handlers) HtsgetReads(c *gin.Context) {
+	h.htsgetTicket(c, []string{htsget.FormatBAM, htsget.FormatCRAM})
+}
+
+// HtsgetVariants returns an htsget ticket for a region of a VCF file.
+// GET /htsget/variants/:fileId
+func (h *Handlers) HtsgetVariants(c *gin.Context) {
+	h.htsgetTicket(c, []string{htsget.FormatVCF})
+}
+
+// htsgetTicket answers a ticket request with the crypt4gh header of the
+// requested data, re-encrypted for the client with a data edit list, followed
+// by the ranges of the file content holding the data segments of the region.
+func (h *Handlers) htsgetTicket(c *gin.Context, formats []string) {
+	query, errorCode, detail := parseHtsgetQuery(c, formats)
+	if errorCode != "" {
+		problemJSONWithCode(c, http.StatusBadRequest, detail, errorCode)
+
+		return
+	}
+
+	publicKey, errorCode, detail := extractPublicKey(c)
+	if errorCode != "" {
+		problemJSONWithCode(c, http.StatusBadRequest, detail, errorCode)
+
+		return
+	}
+
+	base, ok := h.resolveFileBase(c)
+	if !ok {
+		return
+	}
+	file := base.file
+
+	if htsgetFileFormat(file.SubmittedPath) != query.format {
+		problemJSONWithCode(c, http.StatusBadRequest, fmt.Sprintf("file is not in the %s format", query.format), "UNSUPPORTED_FORMAT")
+
+		return
+	}
+	if len(file.Header) == 0 {
+		log.Errorf("file %s has no header", file.ID)
+		problemJSON(c, http.StatusInternalServerError, "file header not available")
+
+		return
+	}
+	if h.reencryptClient == nil || h.storageReader == nil {
+		log.Error("reencrypt client or storage reader not configured")
+		problemJSON(c, http.StatusInternalServerError, "htsget not configured")
+
+		return
+	}
+
+	decryptedSize := file.DecryptedSize
+	if decryptedSize <= 0 {
+		decryptedSize = htsget.DecryptedSize(file.ArchiveSize)
+	}
+
+	ranges := []htsget.ByteRange{{Start: 0, End: decryptedSize}}
+	if query.headerOnly || query.referenceName != "" {
+		var err error
+		ranges, err = h.htsgetRanges(c.Request.Context(), file, query, decryptedSize)
+		switch {
+		case errors.Is(err, errHtsgetNotFound):
+			problemJSONWithCode(c, http.StatusNotFound, err.Error(), "NOT_FOUND")
+
+			return
+		case errors.Is(err, htsget.ErrUnsupported):
+			problemJSONWithCode(c, http.StatusBadRequest, err.Error(), "UNSUPPORTED_FORMAT")
+
+			return
+		case err != nil:
+			log.Errorf("failed to read index of file %s: %v", file.ID, err)
+			problemJSON(c, http.StatusInternalServerError, "failed to read file index")
+
+			return
+		}
+	}
+
+	encrypted, editList := htsget.Crypt4GHRanges(ranges, decryptedSize, file.ArchiveSize)
+	header, err := h.reencryptClient.ReencryptHeaderWithEditList(c.Request.Context(), file.Header, publicKey, editList)
+	if err != nil {
+		log.Errorf("failed to reencrypt header: %v", err)
+		problemJSON(c, http.StatusInternalServerError, "failed to prepare file for download")
+
+		return
+	}
+
+	class := ""
+	if query.headerOnly {
+		class = "header"
+	}
+	urls := []HtsgetURL{{
+		URL:   "data:application/octet-stream;base64," + base64.StdEncoding.EncodeToString(header),
+		Class: class,
+	}}
+	contentURL := fmt.Sprintf("%s/files/%s/content", requestBaseURL(c), url.PathEscape(file.ID))
+	for _, r := range encrypted {
+		headers := map[string]string{"Range": fmt.Sprintf("bytes=%d-%d", r.Start, r.End-1)}
+		if auth := c.GetHeader("Authorization"); auth != "" {
+			headers["Authorization"] = auth
+		}
+		urls = append(urls, HtsgetURL{URL: contentURL, Headers: headers, Class: class})
+	}
+
+	h.auditLogger.Log(c.Request.Context(), audit.Event{
+		Event:         audit.EventHeader,
+		UserID:        base.authCtx.Subject,
+		FileID:        file.ID,
+		DatasetID:     file.DatasetID,
+		CorrelationID: c.GetString("correlationId"),
+		Path:          c.Request.URL.Path,
+		HTTPStatus:    http.StatusOK,
+	})
+
+	c.Header("Content-Type", htsgetContentType)
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, HtsgetTicket{Htsget: HtsgetResponse{Format: query.format, URLs: urls}})
+}
+
+// parseHtsgetQuery validates the query parameters of an htsget request.
+// The fields, tags and notags parameters are accepted, but all fields are returned.
+// Returns the query or (nil, errorCode, detail).
+func parseHtsgetQuery(c *gin.Context, formats []string) (*htsgetQuery, string, string) {
+	query := &htsgetQuery{
+		format:        strings.ToUpper(c.DefaultQuery("format", formats[0])),
+		referenceName: c.Query("referenceName"),
+	}
+	if !slices.Contains(formats, query.format) {
+		return nil, "UNSUPPORTED_FORMAT", fmt.Sprintf("format must be one of %s", strings.Join(formats, ", "))
+	}
+
+	switch c.Query("class") {
+	case "":
+	case "header":
+		query.headerOnly = true
+	default:
+		return nil, "INVALID_INPUT", "class must be header"
+	}
+
+	startParam, endParam := c.Query("start"), c.Query("end")
+	if startParam == "" && endParam == "" {
+		return query, "", ""
+	}
+	if query.referenceName == "" || query.referenceName == "*" {
+		return nil, "INVALID_INPUT", "start and end require a referenceName"
+	}
+
+	var err error
+	if startParam != "" {
+		if query.start, err = strconv.ParseInt(startParam, 10, 64); err != nil || query.start < 0 {
+			return nil, "INVALID_INPUT", "start must be a non-negative integer"
+		}
+	}
+	if endParam != "" {
+		if query.end, err = strconv.ParseInt(endParam, 10, 64); err != nil || query.end < 0 {
+			return nil, "INVALID_INPUT", "end must be a non-negative integer"
+		}
+		if query.end <= query.start {
+			return nil, "INVALID_RANGE", "end must be greater than start"
+		}
+	}
+
+	return query, "", ""
+}
+
+// htsgetFileFormat returns the htsget format of a file from its submitted path.
+func htsgetFileFormat(submittedPath string) string {
+	path := strings.ToLower(strings.TrimSuffix(submittedPath, ".c4gh"))
+	switch {
+	case strings.HasSuffix(path, ".bam"):
+		return htsget.FormatBAM
+	case strings.HasSuffix(path, ".cram"):
+		return htsget.FormatCRAM
+	case strings.HasSuffix(path, ".vcf.gz"), strings.HasSuffix(path, ".vcf.bgz"):
+		return htsget.FormatVCF
+	default:
+		return ""
+	}
+}
+
+// htsgetIndexPaths returns the submitted paths the index of a file may have,
+// with or without the crypt4gh extension.
+func htsgetIndexPaths(submittedPath, format string) []string {
+	path := strings.TrimSuffix(submittedPath, ".c4gh")
+
+	var candidates []string
+	switch format {
+	case htsget.FormatBAM:
+		stem := path[:len(path)-len(".bam")]
+		candidates = []string{path + ".bai", stem + ".bai", path + ".csi"}
+	case htsget.FormatCRAM:
+		stem := path[:len(path)-len(".cram")]
+		candidates = []string{path + ".crai", stem + ".crai"}
+	case htsget.FormatVCF:
+		candidates = []string{path + ".tbi", path + ".csi"}
+	}
+
+	paths := make([]string, 0, 2*len(candidates))
+	for _, candidate := range candidates {
+		paths = append(paths, candidate+".c4gh", candidate)
+	}
+
+	return paths
+}
+
+// htsgetRanges reads the index stored with the file in its dataset, and
+// returns the ranges of the decrypted file holding the requested data.
+func (h *Handlers) htsgetRanges(ctx context.Context, file *database.File, query *htsgetQuery, decryptedSize int64) ([]htsget.ByteRange, error) {
+	var indexFile *database.File
+	for _, path := range htsgetIndexPaths(file.SubmittedPath, query.format) {
+		f, err := h.db.GetFileByPath(ctx, file.DatasetID, path)
+		if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			indexFile = f
+
+			break
+		}
+	}
+	if indexFile == nil {
+		return nil, fmt.Errorf("index of file %w", errHtsgetNotFound)
+	}
+
+	idx, names, err := h.readHtsgetIndex(ctx, indexFile)
+	if err != nil {
+		return nil, err
+	}
+
+	refID := -1
+	if query.referenceName != "" && query.referenceName != "*" {
+		if names == nil {
+			if names, err = h.readHtsgetReferences(ctx, file, query.format); err != nil {
+				return nil, err
+			}
+		}
+		if refID = slices.Index(names, query.referenceName); refID < 0 {
+			return nil, fmt.Errorf("reference %s %w", query.referenceName, errHtsgetNotFound)
+		}
+	}
+
+	return htsget.Query(idx, refID, query.start, query.end, query.headerOnly, decryptedSize), nil
+}
+
+// readHtsgetIndex decrypts and parses an index file, and returns the
+// reference names stored in it, if any.
+func (h *Handlers) readHtsgetIndex(ctx context.Context, indexFile *database.File) (htsget.Index, []string, error) {
+	reader, err := h.decryptFile(ctx, indexFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer reader.Close()
+
+	switch path := strings.TrimSuffix(indexFile.SubmittedPath, ".c4gh"); {
+	case strings.HasSuffix(path, ".bai"):
+		idx, err := htsget.ReadBAI(reader)
+
+		return idx, nil, err
+	case strings.HasSuffix(path, ".tbi"):
+		idx, err := htsget.ReadTBI(reader)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return idx, idx.Names(), nil
+	case strings.HasSuffix(path, ".csi"):
+		idx, err := htsget.ReadCSI(reader)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return idx, idx.Names(), nil
+	default:
+		idx, err := htsget.ReadCRAI(reader)
+
+		return idx, nil, err
+	}
+}
+
+// readHtsgetReferences reads the reference names from the header of a BAM or CRAM file.
+func (h *Handlers) readHtsgetReferences(ctx context.Context, file *database.File, format string) ([]string, error) {
+	reader, err := h.decryptFile(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	switch format {
+	case htsget.FormatBAM:
+		return htsget.ReadBAMReferences(reader)
+	case htsget.FormatCRAM:
+		return htsget.ReadCRAMReferences(reader)
+	default:
+		return nil, fmt.Errorf("%w: %s files without reference names in the index", htsget.ErrUnsupported, format)
+	}
+}
+
+// decryptedFile closes the storage reader of a decrypted file.
+type decryptedFile struct {
+	*c4ghstreaming.Crypt4GHReader
+	body io.Closer
+}
+
+func (f *decryptedFile) Close() error {
+	_ = f.Crypt4GHReader.Close()
+
+	return f.body.Close()
+}
+
+// decryptFile opens the decrypted content of an archived file, by
+// re-encrypting its header for the key pair of the service.
+func (h *Handlers) decryptFile(ctx context.Context, file *database.File) (io.ReadCloser, error) {
+	if len(file.Header) == 0 {
+		return nil, fmt.Errorf("file %s has no header", file.ID)
+	}
+
+	location, err := h.fileLocation(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := h.reencryptClient.ReencryptHeader(ctx, file.Header, base64.StdEncoding.EncodeToString(h.c4ghPublicKey[:]))
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := h.storageReader.NewFileReadSeeker(ctx, location, file.ArchivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s: %w", file.ID, err)
+	}
+
+	reader, err := c4ghstreaming.NewCrypt4GHReader(io.MultiReader(bytes.NewReader(header), body), h.c4ghPrivateKey, nil)
+	if err != nil {
+		body.Close()
+
+		return nil, fmt.Errorf("failed to decrypt file %s: %w", file.ID, err)
+	}
+
+	return &decryptedFile{Crypt4GHReader: reader, body: body}, nil
+}
+
+// fileLocation returns the storage location of an archived file, searching the
+// storage when the location is not stored.
+func (h *Handlers) fileLocation(ctx context.Context, file *database.File) (string, error) {
+	if file.ArchiveLocation != "" {
+		return file.ArchiveLocation, nil
+	}
+
+	location, err := h.storageReader.FindFile(ctx, file.ArchivePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to find file %s in storage: %w", file.ID, err)
+	}
+
+	return location, nil
+}
+
+// requestBaseURL returns the scheme and host the request was sent to.
+func requestBaseURL(c *gin.Context) string {
+	scheme := "https"
+	if c.Request.TLS == nil {
+		scheme = "http"
+	}
+
+	return fmt.Sprintf("%s://%s", scheme, c.Request.Host)
+}
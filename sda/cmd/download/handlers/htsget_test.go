@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/neicnordic/crypt4gh/keys"
+	"github.com/neicnordic/crypt4gh/model/headers"
+	c4ghstreaming "github.com/neicnordic/crypt4gh/streaming"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/database"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/reencrypt"
+	re "github.com/neicnordic/sensitive-data-archive/internal/reencrypt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+// fakeReencryptServer re-encrypts headers with the archive key, like the reencrypt service.
+type fakeReencryptServer struct {
+	re.UnimplementedReencryptServer
+	privateKey [32]byte
+}
+
+func (s *fakeReencryptServer) ReencryptHeader(_ context.Context, in *re.ReencryptRequest) (*re.ReencryptResponse, error) {
+	publicKey, err := base64.StdEncoding.DecodeString(in.GetPublickey())
+	if err != nil || len(publicKey) != 32 {
+		return nil, errors.New("invalid public key")
+	}
+
+	var extraPackets []headers.EncryptedHeaderPacket
+	if editList := in.GetDataeditlist(); len(editList) > 0 {
+		extraPackets = append(extraPackets, headers.DataEditListHeaderPacket{
+			PacketType:    headers.PacketType{PacketType: headers.DataEditList},
+			NumberLengths: uint32(len(editList)), //nolint:gosec // test data
+			Lengths:       editList,
+		})
+	}
+	header, err := headers.ReEncryptHeader(in.GetOldheader(), s.privateKey, [][32]byte{[32]byte(publicKey)}, extraPackets...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &re.ReencryptResponse{Header: header}, nil
+}
+
+// htsgetTestEnv is a BAM file with a BAI index in the archive.
+type htsgetTestEnv struct {
+	handlers        *Handlers
+	db              *mockDatabase
+	plaintext       []byte
+	headerSize      int
+	body            []byte
+	clientPublicKey string
+	clientKey       [32]byte
+}
+
+func encryptTestFile(t *testing.T, publicKey [32]byte, plaintext []byte) ([]byte, []byte) {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	writer, err := c4ghstreaming.NewCrypt4GHWriterWithoutPrivateKey(buf, [][32]byte{publicKey}, nil)
+	require.NoError(t, err)
+	_, err = writer.Write(plaintext)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	header, err := headers.ReadHeader(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+
+	return header, buf.Bytes()[len(header):]
+}
+
+func writeLittleEndian(buf *bytes.Buffer, values ...any) {
+	for _, v := range values {
+		_ = binary.Write(buf, binary.LittleEndian, v)
+	}
+}
+
+func newHtsgetTestEnv(t *testing.T) *htsgetTestEnv {
+	t.Helper()
+	env := &htsgetTestEnv{}
+
+	archivePublicKey, archivePrivateKey, err := keys.GenerateKeyPair()
+	require.NoError(t, err)
+	clientPublicKey, clientPrivateKey, err := keys.GenerateKeyPair()
+	require.NoError(t, err)
+	env.clientPublicKey = base64.StdEncoding.EncodeToString(clientPublicKey[:])
+	env.clientKey = clientPrivateKey
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	re.RegisterReencryptServer(srv, &fakeReencryptServer{privateKey: archivePrivateKey})
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+	reencryptClient := reencrypt.NewClient("localhost", lis.Addr().(*net.TCPAddr).Port)
+	t.Cleanup(func() { _ = reencryptClient.Close() })
+
+	// The BAM file is its header, the blocks of chr1 and chr2, unplaced records and the end of file marker
+	bamHeader := bytes.NewBufferString("BAM\x01")
+	writeLittleEndian(bamHeader, int32(0), int32(2), int32(5), []byte("chr1\x00"), int32(1000000), int32(5), []byte("chr2\x00"), int32(1000000))
+	compressed := new(bytes.Buffer)
+	gz := gzip.NewWriter(compressed)
+	_, err = gz.Write(bamHeader.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	env.headerSize = compressed.Len()
+	records := make([]byte, 150000+28)
+	_, err = rand.Read(records)
+	require.NoError(t, err)
+	env.plaintext = append(compressed.Bytes(), records...)
+
+	virtualOffset := func(offset int) uint64 { return uint64(env.headerSize+offset) << 16 } //nolint:gosec // test data
+	bai := bytes.NewBufferString("BAI\x01")
+	writeLittleEndian(bai, int32(2))
+	writeLittleEndian(bai, int32(1), uint32(4681), int32(1), virtualOffset(0), virtualOffset(70000), int32(1), virtualOffset(0))
+	writeLittleEndian(bai, int32(1), uint32(4681), int32(1), virtualOffset(70000), virtualOffset(140000), int32(1), virtualOffset(70000))
+
+	bamFileHeader, bamBody := encryptTestFile(t, archivePublicKey, env.plaintext)
+	baiFileHeader, baiBody := encryptTestFile(t, archivePublicKey, bai.Bytes())
+	env.body = bamBody
+
+	env.db = &mockDatabase{
+		hasPermission: true,
+		fileByID: &database.File{
+			ID:              "bam-file",
+			DatasetID:       "test-dataset",
+			SubmittedPath:   "dir/sample.bam.c4gh",
+			ArchivePath:     "bam",
+			ArchiveLocation: "/archive",
+			ArchiveSize:     int64(len(bamBody)),
+			DecryptedSize:   int64(len(env.plaintext)),
+			Header:          bamFileHeader,
+		},
+		filesByPath: map[string]*database.File{
+			"dir/sample.bam.bai.c4gh": {
+				ID:              "bai-file",
+				DatasetID:       "test-dataset",
+				SubmittedPath:   "dir/sample.bam.bai.c4gh",
+				ArchivePath:     "bai",
+				ArchiveLocation: "/archive",
+				ArchiveSize:     int64(len(baiBody)),
+				Header:          baiFileHeader,
+			},
+		},
+	}
+	storageReader := &mockStorageReader{files: map[string][]byte{"bam": bamBody, "bai": baiBody}}
+
+	env.handlers, err = New(WithDatabase(env.db), WithStorageReader(storageReader), WithReencryptClient(reencryptClient))
+	require.NoError(t, err)
+
+	return env
+}
+
+// ticket requests a ticket for the BAM file.
+func (env *htsgetTestEnv) ticket(t *testing.T, query string) *httptest.ResponseRecorder {
+	t.Helper()
+	router := setupTestRouterWithAuth([]string{"test-dataset"})
+	router.GET("/htsget/reads/:fileId", env.handlers.HtsgetReads)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/htsget/reads/bam-file?"+query, nil)
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Set("Htsget-Context-Public-Key", env.clientPublicKey)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+// fetch concatenates the blocks of the ticket, and decrypts them with the client key.
+func (env *htsgetTestEnv) fetch(t *testing.T, w *httptest.ResponseRecorder) []byte {
+	t.Helper()
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, htsgetContentType, w.Header().Get("Content-Type"))
+
+	var ticket HtsgetTicket
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &ticket))
+	assert.Equal(t, "BAM", ticket.Htsget.Format)
+	require.Greater(t, len(ticket.Htsget.URLs), 1)
+
+	header, ok := strings.CutPrefix(ticket.Htsget.URLs[0].URL, "data:application/octet-stream;base64,")
+	require.True(t, ok)
+	data, err := base64.StdEncoding.DecodeString(header)
+	require.NoError(t, err)
+
+	for _, u := range ticket.Htsget.URLs[1:] {
+		assert.Equal(t, "http://example.com/files/bam-file/content", u.URL)
+		assert.Equal(t, "Bearer token", u.Headers["Authorization"])
+
+		start, end, ok := strings.Cut(strings.TrimPrefix(u.Headers["Range"], "bytes="), "-")
+		require.True(t, ok)
+		first, err := strconv.Atoi(start)
+		require.NoError(t, err)
+		last, err := strconv.Atoi(end)
+		require.NoError(t, err)
+		data = append(data, env.body[first:last+1]...)
+	}
+
+	reader, err := c4ghstreaming.NewCrypt4GHReader(bytes.NewReader(data), env.clientKey, nil)
+	require.NoError(t, err)
+	decrypted, err := io.ReadAll(reader)
+	require.NoError(t, err)
+
+	return decrypted
+}
+
+func TestHtsgetReads_wholeFile(t *testing.T) {
+	env := newHtsgetTestEnv(t)
+
+	assert.Equal(t, env.plaintext, env.fetch(t, env.ticket(t, "")))
+}
+
+func TestHtsgetReads_region(t *testing.T) {
+	env := newHtsgetTestEnv(t)
+	p, h := env.plaintext, env.headerSize
+
+	// The header, the blocks of chr2 and the end of file marker
+	expected := append(append(append([]byte{}, p[:h]...), p[h+70000:h+140000]...), p[len(p)-28:]...)
+	assert.Equal(t, expected, env.fetch(t, env.ticket(t, "referenceName=chr2&start=0&end=1000")))
+
+	// The unplaced records follow the last placed records
+	expected = append(append([]byte{}, p[:h]...), p[h+140000:]...)
+	assert.Equal(t, expected, env.fetch(t, env.ticket(t, "referenceName=*")))
+
+	expected = append(append([]byte{}, p[:h]...), p[len(p)-28:]...)
+	assert.Equal(t, expected, env.fetch(t, env.ticket(t, "class=header")))
+}
+
+func TestHtsgetReads_notFound(t *testing.T) {
+	env := newHtsgetTestEnv(t)
+
+	w := env.ticket(t, "referenceName=chr3")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	env.db.filesByPath = map[string]*database.File{}
+	w = env.ticket(t, "referenceName=chr1")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	var response ProblemDetails
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "NOT_FOUND", response.ErrorCode)
+}
+
+func TestHtsgetReads_invalidQuery(t *testing.T) {
+	env := newHtsgetTestEnv(t)
+
+	for query, errorCode := range map[string]string{
+		"format=VCF":                          "UNSUPPORTED_FORMAT",
+		"format=CRAM":                         "UNSUPPORTED_FORMAT",
+		"class=body":                          "INVALID_INPUT",
+		"start=10":                            "INVALID_INPUT",
+		"referenceName=*&start=10":            "INVALID_INPUT",
+		"referenceName=chr1&start=-1":         "INVALID_INPUT",
+		"referenceName=chr1&start=100&end=10": "INVALID_RANGE",
+	} {
+		w := env.ticket(t, query)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+
+		var response ProblemDetails
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, errorCode, response.ErrorCode, query)
+	}
+}
+
+func TestHtsgetIndexPaths(t *testing.T) {
+	assert.Equal(t, []string{
+		"dir/sample.bam.bai.c4gh", "dir/sample.bam.bai",
+		"dir/sample.bai.c4gh", "dir/sample.bai",
+		"dir/sample.bam.csi.c4gh", "dir/sample.bam.csi",
+	}, htsgetIndexPaths("dir/sample.bam.c4gh", "BAM"))
+	assert.Equal(t, []string{"sample.vcf.gz.tbi.c4gh", "sample.vcf.gz.tbi", "sample.vcf.gz.csi.c4gh", "sample.vcf.gz.csi"}, htsgetIndexPaths("sample.vcf.gz.c4gh", "VCF"))
+
+	assert.Equal(t, "CRAM", htsgetFileFormat("dir/sample.cram.c4gh"))
+	assert.Equal(t, "VCF", htsgetFileFormat("dir/sample.vcf.bgz"))
+	assert.Empty(t, htsgetFileFormat("dir/sample.txt.c4gh"))
+}
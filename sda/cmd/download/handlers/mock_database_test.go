@@ -1,8 +1,10 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"io"
+	"strings"
 	"sync"
 
 	"github.com/neicnordic/sensitive-data-archive/cmd/download/audit"
@@ -27,13 +29,19 @@ type mockDatabase struct {
 	datasetIDs        []string
 	datasetInfo       *database.DatasetInfo
 	datasetFilesPaged []database.File
-	fileByID          *database.File
-	fileByPath        *database.File
-	hasPermission     bool
-	datasetNotFound   bool
-	fileChecksums     []database.Checksum
-	err               error
-	pingErr           error
+	// datasetFiles, when set, are filtered and paginated by GetDatasetFilesPaginated
+	datasetFiles    []database.File
+	fileByID        *database.File
+	filesByID       map[string]*database.File
+	fileByPath      *database.File
+	filesByPath     map[string]*database.File
+	hasPermission   bool
+	datasetNotFound bool
+	fileChecksums   []database.Checksum
+	// checksumQueries counts the queries for checksums of several files
+	checksumQueries int
+	err             error
+	pingErr         error
 }
 
 func (m *mockDatabase) Ping(_ context.Context) error {
@@ -76,18 +84,24 @@ func (m *mockDatabase) GetDatasetInfo(_ context.Context, _ string) (*database.Da
 	return m.datasetInfo, nil
 }
 
-func (m *mockDatabase) GetFileByID(_ context.Context, _ string) (*database.File, error) {
+func (m *mockDatabase) GetFileByID(_ context.Context, fileID string) (*database.File, error) {
 	if m.err != nil {
 		return nil, m.err
 	}
+	if m.filesByID != nil {
+		return m.filesByID[fileID], nil
+	}
 
 	return m.fileByID, nil
 }
 
-func (m *mockDatabase) GetFileByPath(_ context.Context, _, _ string) (*database.File, error) {
+func (m *mockDatabase) GetFileByPath(_ context.Context, _, filePath string) (*database.File, error) {
 	if m.err != nil {
 		return nil, m.err
 	}
+	if m.filesByPath != nil {
+		return m.filesByPath[filePath], nil
+	}
 
 	return m.fileByPath, nil
 }
@@ -116,24 +130,70 @@ func (m *mockDatabase) GetFileChecksums(_ context.Context, _ string, _ string) (
 	return m.fileChecksums, nil
 }
 
-func (m *mockDatabase) GetDatasetFilesPaginated(_ context.Context, _ string, _ database.FileListOptions) ([]database.File, error) {
+func (m *mockDatabase) GetFilesChecksums(_ context.Context, fileIDs []string, _ string) (map[string][]database.Checksum, error) {
 	if m.err != nil {
 		return nil, m.err
 	}
 
-	return m.datasetFilesPaged, nil
+	m.checksumQueries++
+	checksums := make(map[string][]database.Checksum)
+	for _, fileID := range fileIDs {
+		checksums[fileID] = m.fileChecksums
+	}
+
+	return checksums, nil
+}
+
+func (m *mockDatabase) GetDatasetFilesPaginated(_ context.Context, _ string, opts database.FileListOptions) ([]database.File, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	if m.datasetFiles == nil {
+		return m.datasetFilesPaged, nil
+	}
+
+	var files []database.File
+	for _, f := range m.datasetFiles {
+		if !strings.HasPrefix(f.SubmittedPath, opts.PathPrefix) {
+			continue
+		}
+		if opts.CursorPath != "" && (f.SubmittedPath < opts.CursorPath || (f.SubmittedPath == opts.CursorPath && f.ID <= opts.CursorID)) {
+			continue
+		}
+		if len(files) == opts.Limit {
+			break
+		}
+		files = append(files, f)
+	}
+
+	return files, nil
 }
 
 // mockStorageReader is a mock implementation of storage.Reader for testing.
 type mockStorageReader struct {
 	pingErr error
+	files   map[string][]byte
+}
+
+// nopReadSeekCloser serves the files of the mock storage reader.
+type nopReadSeekCloser struct {
+	*bytes.Reader
+}
+
+func (nopReadSeekCloser) Close() error {
+	return nil
 }
 
 func (m *mockStorageReader) NewFileReader(_ context.Context, _, _ string) (io.ReadCloser, error) {
 	return nil, nil
 }
 
-func (m *mockStorageReader) NewFileReadSeeker(_ context.Context, _, _ string) (io.ReadSeekCloser, error) {
+func (m *mockStorageReader) NewFileReadSeeker(_ context.Context, _, filePath string) (io.ReadSeekCloser, error) {
+	if data, ok := m.files[filePath]; ok {
+		return nopReadSeekCloser{bytes.NewReader(data)}, nil
+	}
+
 	return nil, nil
 }
 
@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"time"
+
 	"github.com/neicnordic/sensitive-data-archive/cmd/download/audit"
 	"github.com/neicnordic/sensitive-data-archive/cmd/download/database"
 	"github.com/neicnordic/sensitive-data-archive/cmd/download/reencrypt"
@@ -43,6 +45,14 @@ func WithAuditLogger(l audit.Logger) func(*Handlers) {
 	}
 }
 
+// WithSignedURLs enables signed URLs, signed with secret and valid for at most ttl.
+func WithSignedURLs(secret []byte, ttl time.Duration) func(*Handlers) {
+	return func(h *Handlers) {
+		h.signedURLSecret = secret
+		h.signedURLTTL = ttl
+	}
+}
+
 // WithServiceInfo sets the GA4GH service-info fields.
 func WithServiceInfo(id, orgName, orgURL string) func(*Handlers) {
 	return func(h *Handlers) {
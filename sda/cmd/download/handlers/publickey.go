@@ -3,12 +3,21 @@ package handlers
 import "github.com/gin-gonic/gin"
 
 // extractPublicKey reads the client's crypt4gh public key from request headers.
-// Exactly one of X-C4GH-Public-Key or Htsget-Context-Public-Key must be present.
+// Exactly one of X-C4GH-Public-Key or Htsget-Context-Public-Key must be present,
+// unless the request carries a signed URL bound to a public key.
 // Returns the raw header value (base64-encoded string) or ("", errorCode, detail).
 func extractPublicKey(c *gin.Context) (string, string, string) {
 	primary := c.GetHeader("X-C4GH-Public-Key")
 	secondary := c.GetHeader("Htsget-Context-Public-Key")
 
+	if claims := getSignedURLClaims(c); claims != nil && claims.PublicKey != "" {
+		if (primary != "" && primary != claims.PublicKey) || (secondary != "" && secondary != claims.PublicKey) {
+			return "", "KEY_CONFLICT", "public key does not match the key the URL was signed for"
+		}
+
+		return claims.PublicKey, "", ""
+	}
+
 	switch {
 	case primary != "" && secondary != "":
 		return "", "KEY_CONFLICT", "only one of X-C4GH-Public-Key or Htsget-Context-Public-Key may be provided"
@@ -0,0 +1,473 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/config"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/database"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/middleware"
+	log "github.com/sirupsen/logrus"
+)
+
+// s3Namespace is the XML namespace of S3 responses.
+const s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// s3TimeFormat is the format of timestamps in S3 responses.
+const s3TimeFormat = "2006-01-02T15:04:05.000Z"
+
+// s3MaxKeys is the default and maximum amount of keys in a listing, as in S3.
+const s3MaxKeys = 1000
+
+// s3ScanPageSize is the amount of files read from the database at a time
+// while listing, files rolled up into common prefixes do not count towards max-keys.
+const s3ScanPageSize = 1000
+
+// s3ProtocolPattern matches URL dataset IDs whose double slash was collapsed by the client.
+var s3ProtocolPattern = regexp.MustCompile(`^(https?:/)([^/])`)
+
+// S3Error is the error response of the S3 API.
+type S3Error struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource,omitempty"`
+	RequestID string   `xml:"RequestId,omitempty"`
+}
+
+// S3Bucket is a dataset in a ListBuckets response.
+type S3Bucket struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+// S3Owner is the owner of the buckets in a ListBuckets response.
+type S3Owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName,omitempty"`
+}
+
+// S3ListAllMyBucketsResult is the response of ListBuckets.
+type S3ListAllMyBucketsResult struct {
+	XMLName xml.Name   `xml:"ListAllMyBucketsResult"`
+	XMLNS   string     `xml:"xmlns,attr"`
+	Owner   S3Owner    `xml:"Owner"`
+	Buckets []S3Bucket `xml:"Buckets>Bucket"`
+}
+
+// S3LocationConstraint is the response of GetBucketLocation.
+type S3LocationConstraint struct {
+	XMLName  xml.Name `xml:"LocationConstraint"`
+	XMLNS    string   `xml:"xmlns,attr"`
+	Location string   `xml:",chardata"`
+}
+
+// S3Object is a file in a ListObjects response.
+type S3Object struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+// S3CommonPrefix is a rolled up prefix in a ListObjects response.
+type S3CommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// S3ListBucketResult holds the fields shared by ListObjects and ListObjectsV2 responses.
+type S3ListBucketResult struct {
+	XMLName        xml.Name         `xml:"ListBucketResult"`
+	XMLNS          string           `xml:"xmlns,attr"`
+	Name           string           `xml:"Name"`
+	Prefix         string           `xml:"Prefix"`
+	Delimiter      string           `xml:"Delimiter,omitempty"`
+	MaxKeys        int              `xml:"MaxKeys"`
+	EncodingType   string           `xml:"EncodingType,omitempty"`
+	IsTruncated    bool             `xml:"IsTruncated"`
+	Contents       []S3Object       `xml:"Contents"`
+	CommonPrefixes []S3CommonPrefix `xml:"CommonPrefixes"`
+}
+
+// S3ListObjectsResult is the response of ListObjects.
+type S3ListObjectsResult struct {
+	S3ListBucketResult
+	Marker     string `xml:"Marker"`
+	NextMarker string `xml:"NextMarker,omitempty"`
+}
+
+// S3ListObjectsV2Result is the response of ListObjectsV2.
+type S3ListObjectsV2Result struct {
+	S3ListBucketResult
+	KeyCount              int    `xml:"KeyCount"`
+	ContinuationToken     string `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string `xml:"StartAfter,omitempty"`
+}
+
+// s3XML writes an S3 XML response.
+func s3XML(c *gin.Context, status int, body any) {
+	data, err := xml.Marshal(body)
+	if err != nil {
+		log.Errorf("failed to marshal S3 response: %v", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+
+		return
+	}
+
+	c.Data(status, "application/xml", append([]byte(xml.Header), data...))
+}
+
+// s3Error writes an S3 error response and aborts the request.
+func s3Error(c *gin.Context, status int, code, message string) {
+	s3XML(c, status, S3Error{
+		Code:      code,
+		Message:   message,
+		Resource:  c.Request.URL.Path,
+		RequestID: c.GetString("correlationId"),
+	})
+	c.Abort()
+}
+
+// s3Request is a parsed path-style S3 request.
+type s3Request struct {
+	authCtx  middleware.AuthContext
+	datasets []database.Dataset
+	dataset  *database.Dataset
+	key      string
+}
+
+// parseS3Request resolves the bucket and key of a request. Dataset IDs may
+// contain slashes, so the bucket is found by matching the path against the
+// accessible datasets. Returns (nil, false) if an error response was already sent.
+func (h *Handlers) parseS3Request(c *gin.Context) (*s3Request, bool) {
+	authCtx, ok := middleware.GetAuthContext(c)
+	if !ok {
+		s3Error(c, http.StatusUnauthorized, "AccessDenied", "authentication required")
+
+		return nil, false
+	}
+
+	var datasets []database.Dataset
+	var err error
+	if config.JWTAllowAllData() {
+		datasets, err = h.db.GetAllDatasets(c.Request.Context())
+	} else {
+		datasets, err = h.db.GetUserDatasets(c.Request.Context(), authCtx.Datasets)
+	}
+	if err != nil {
+		log.Errorf("failed to retrieve datasets: %v", err)
+		s3Error(c, http.StatusInternalServerError, "InternalError", "failed to retrieve datasets")
+
+		return nil, false
+	}
+
+	request := &s3Request{authCtx: authCtx, datasets: datasets}
+
+	path := strings.TrimPrefix(c.Param("path"), "/")
+	if path == "" {
+		return request, true
+	}
+
+	// Some clients reduce double slashes to single slashes, which needs to be restored
+	path = s3ProtocolPattern.ReplaceAllString(path, "$1/$2")
+
+	// The longest match wins, so that a dataset whose ID is a prefix of another
+	// dataset ID does not shadow it
+	for i := range datasets {
+		id := datasets[i].ID
+		if !strings.HasPrefix(path, id) || (len(path) > len(id) && path[len(id)] != '/') {
+			continue
+		}
+		if request.dataset == nil || len(id) > len(request.dataset.ID) {
+			request.dataset = &datasets[i]
+			request.key = strings.TrimPrefix(path[len(id):], "/")
+		}
+	}
+
+	if request.dataset == nil {
+		s3Error(c, http.StatusForbidden, "AccessDenied", "access denied")
+		h.auditDenied(c)
+
+		return nil, false
+	}
+
+	return request, true
+}
+
+// S3Get handles S3 GET requests, which are ListBuckets, GetBucketLocation,
+// ListObjects, ListObjectsV2 and GetObject depending on the path and query.
+// GET /s3/*path
+func (h *Handlers) S3Get(c *gin.Context) {
+	request, ok := h.parseS3Request(c)
+	if !ok {
+		return
+	}
+
+	switch {
+	case request.dataset == nil:
+		h.s3ListBuckets(c, request)
+	case request.key != "":
+		h.s3Object(c, request, h.DownloadFile)
+	case c.Request.URL.Query().Has("location"):
+		s3XML(c, http.StatusOK, S3LocationConstraint{XMLNS: s3Namespace})
+	default:
+		h.s3ListObjects(c, request)
+	}
+}
+
+// S3Head handles S3 HEAD requests, which are HeadBucket and HeadObject.
+// HEAD /s3/*path
+func (h *Handlers) S3Head(c *gin.Context) {
+	request, ok := h.parseS3Request(c)
+	if !ok {
+		return
+	}
+
+	switch {
+	case request.dataset == nil:
+		c.Status(http.StatusBadRequest)
+	case request.key != "":
+		h.s3Object(c, request, h.HeadFile)
+	default:
+		c.Status(http.StatusOK)
+	}
+}
+
+// s3ListBuckets lists the accessible datasets as buckets.
+func (h *Handlers) s3ListBuckets(c *gin.Context, request *s3Request) {
+	buckets := make([]S3Bucket, len(request.datasets))
+	for i, d := range request.datasets {
+		buckets[i] = S3Bucket{Name: d.ID, CreationDate: d.CreatedAt.UTC().Format(s3TimeFormat)}
+	}
+
+	c.Header("Cache-Control", "private, max-age=60, must-revalidate")
+	s3XML(c, http.StatusOK, S3ListAllMyBucketsResult{
+		XMLNS:   s3Namespace,
+		Owner:   S3Owner{ID: request.authCtx.Subject},
+		Buckets: buckets,
+	})
+}
+
+// s3Object looks up the file of an object by its key, and serves it with the
+// given files handler as the re-encrypted crypt4gh file of /files/:fileId.
+func (h *Handlers) s3Object(c *gin.Context, request *s3Request, handler gin.HandlerFunc) {
+	file, err := h.db.GetFileByPath(c.Request.Context(), request.dataset.ID, request.key)
+	if err != nil {
+		log.Errorf("failed to get file by path: %v", err)
+		s3Error(c, http.StatusInternalServerError, "InternalError", "failed to retrieve file")
+
+		return
+	}
+
+	if file == nil {
+		s3Error(c, http.StatusNotFound, "NoSuchKey", "the specified key does not exist")
+
+		return
+	}
+
+	c.Params = append(c.Params, gin.Param{Key: "fileId", Value: file.ID})
+	c.Header("Last-Modified", file.CreatedAt.UTC().Format(http.TimeFormat))
+	handler(c)
+}
+
+// s3ListQuery holds the parameters of a ListObjects or ListObjectsV2 request.
+type s3ListQuery struct {
+	prefix     string
+	delimiter  string
+	maxKeys    int
+	cursorPath string
+	cursorID   string
+	// skipPath is a key that is excluded, for listings that start after a key
+	skipPath string
+}
+
+// commonPrefix returns the prefix a key is rolled up into, or "" if the key is listed.
+func (q *s3ListQuery) commonPrefix(key string) string {
+	if q.delimiter == "" || !strings.HasPrefix(key, q.prefix) {
+		return ""
+	}
+
+	rest := key[len(q.prefix):]
+	idx := strings.Index(rest, q.delimiter)
+	if idx < 0 {
+		return ""
+	}
+
+	return q.prefix + rest[:idx+len(q.delimiter)]
+}
+
+// s3ListObjects lists the files of a dataset, either with ListObjectsV2
+// continuation tokens or with ListObjects markers.
+func (h *Handlers) s3ListObjects(c *gin.Context, request *s3Request) {
+	v2 := c.Query("list-type") == "2"
+	query := &s3ListQuery{
+		prefix:    c.Query("prefix"),
+		delimiter: c.Query("delimiter"),
+		maxKeys:   s3MaxKeys,
+	}
+
+	encodingType := c.Query("encoding-type")
+	if encodingType != "" && encodingType != "url" {
+		s3Error(c, http.StatusBadRequest, "InvalidArgument", "encoding-type must be url")
+
+		return
+	}
+
+	if raw := c.Query("max-keys"); raw != "" {
+		maxKeys, err := strconv.Atoi(raw)
+		if err != nil || maxKeys < 0 {
+			s3Error(c, http.StatusBadRequest, "InvalidArgument", "max-keys must be a non-negative integer")
+
+			return
+		}
+		query.maxKeys = min(maxKeys, s3MaxKeys)
+	}
+
+	queryHash := queryFingerprint("s3", request.dataset.ID, query.prefix, query.delimiter)
+	continuationToken := c.Query("continuation-token")
+	startAfter := c.Query("start-after")
+	if !v2 {
+		startAfter = c.Query("marker")
+	}
+
+	switch {
+	case v2 && continuationToken != "":
+		tok, err := decodePageToken(continuationToken)
+		if err != nil {
+			s3Error(c, http.StatusBadRequest, "InvalidArgument", err.Error())
+
+			return
+		}
+
+		if tok.QueryHash != queryHash {
+			s3Error(c, http.StatusBadRequest, "InvalidArgument", "continuation token does not match this query")
+
+			return
+		}
+
+		query.cursorPath = tok.Cursor
+		query.cursorID = tok.CursorID
+	case startAfter != "":
+		query.cursorPath = startAfter
+		query.skipPath = startAfter
+	}
+
+	contents, prefixes, truncated, err := h.s3ListFiles(c, request.dataset.ID, query)
+	if err != nil {
+		log.Errorf("failed to retrieve dataset files: %v", err)
+		s3Error(c, http.StatusInternalServerError, "InternalError", "failed to retrieve dataset files")
+
+		return
+	}
+
+	encode := func(s string) string { return s }
+	if encodingType == "url" {
+		encode = url.QueryEscape
+	}
+
+	result := S3ListBucketResult{
+		XMLNS:        s3Namespace,
+		Name:         request.dataset.ID,
+		Prefix:       encode(query.prefix),
+		Delimiter:    encode(query.delimiter),
+		MaxKeys:      query.maxKeys,
+		EncodingType: encodingType,
+		IsTruncated:  truncated,
+	}
+	for _, o := range contents {
+		o.Key = encode(o.Key)
+		result.Contents = append(result.Contents, o)
+	}
+	for _, p := range prefixes {
+		result.CommonPrefixes = append(result.CommonPrefixes, S3CommonPrefix{Prefix: encode(p)})
+	}
+
+	c.Header("Cache-Control", "private, max-age=60, must-revalidate")
+
+	if !v2 {
+		response := S3ListObjectsResult{S3ListBucketResult: result, Marker: encode(startAfter)}
+		if truncated {
+			response.NextMarker = encode(query.cursorPath)
+		}
+		s3XML(c, http.StatusOK, response)
+
+		return
+	}
+
+	response := S3ListObjectsV2Result{
+		S3ListBucketResult: result,
+		KeyCount:           len(contents) + len(prefixes),
+		ContinuationToken:  continuationToken,
+		StartAfter:         encode(c.Query("start-after")),
+	}
+	if truncated {
+		response.NextContinuationToken = encodePageToken(query.cursorPath, query.cursorID, query.maxKeys, queryHash)
+	}
+	s3XML(c, http.StatusOK, response)
+}
+
+// s3ListFiles reads the files of a dataset after the cursor of the query, until
+// max-keys objects and common prefixes are found. The cursor of the query is
+// advanced to the last file that was consumed.
+func (h *Handlers) s3ListFiles(c *gin.Context, datasetID string, query *s3ListQuery) ([]S3Object, []string, bool, error) {
+	var contents []S3Object
+	var prefixes []string
+	if query.maxKeys == 0 {
+		return contents, prefixes, false, nil
+	}
+
+	// Files following the cursor that roll up into the same prefix as the
+	// cursor were already returned with that prefix
+	lastPrefix := query.commonPrefix(query.cursorPath)
+
+	for {
+		files, err := h.db.GetDatasetFilesPaginated(c.Request.Context(), datasetID, database.FileListOptions{
+			PathPrefix: query.prefix,
+			CursorPath: query.cursorPath,
+			CursorID:   query.cursorID,
+			Limit:      s3ScanPageSize,
+		})
+		if err != nil {
+			return nil, nil, false, err
+		}
+
+		for _, f := range files {
+			if f.SubmittedPath == query.skipPath {
+				query.cursorPath, query.cursorID = f.SubmittedPath, f.ID
+
+				continue
+			}
+
+			prefix := query.commonPrefix(f.SubmittedPath)
+			if prefix == "" || prefix != lastPrefix {
+				if len(contents)+len(prefixes) >= query.maxKeys {
+					return contents, prefixes, true, nil
+				}
+
+				if prefix == "" {
+					contents = append(contents, S3Object{
+						Key:          f.SubmittedPath,
+						LastModified: f.CreatedAt.UTC().Format(s3TimeFormat),
+						Size:         f.HeaderSize + f.ArchiveSize,
+						StorageClass: "STANDARD",
+					})
+				} else {
+					prefixes = append(prefixes, prefix)
+					lastPrefix = prefix
+				}
+			}
+
+			query.cursorPath, query.cursorID = f.SubmittedPath, f.ID
+		}
+
+		if len(files) < s3ScanPageSize {
+			return contents, prefixes, false, nil
+		}
+	}
+}
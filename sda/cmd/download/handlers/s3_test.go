@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	c4ghstreaming "github.com/neicnordic/crypt4gh/streaming"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// s3ListResult matches both ListObjects and ListObjectsV2 responses.
+type s3ListResult struct {
+	Name     string `xml:"Name"`
+	Contents []struct {
+		Key  string `xml:"Key"`
+		Size int64  `xml:"Size"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	KeyCount              int    `xml:"KeyCount"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	NextMarker            string `xml:"NextMarker"`
+}
+
+// keys returns the keys and common prefixes of a listing.
+func (r *s3ListResult) keys() []string {
+	var keys []string
+	for _, c := range r.Contents {
+		keys = append(keys, c.Key)
+	}
+	for _, p := range r.CommonPrefixes {
+		keys = append(keys, p.Prefix)
+	}
+
+	return keys
+}
+
+func setupS3Router(t *testing.T, db *mockDatabase, datasets []string) *gin.Engine {
+	t.Helper()
+	h, err := New(WithDatabase(db))
+	require.NoError(t, err)
+
+	router := setupTestRouterWithAuth(datasets)
+	router.GET("/s3/*path", h.S3Get)
+	router.HEAD("/s3/*path", h.S3Head)
+
+	return router
+}
+
+func doS3Request(t *testing.T, router *gin.Engine, method, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	req, _ := http.NewRequest(method, target, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+func s3List(t *testing.T, router *gin.Engine, target string) *s3ListResult {
+	t.Helper()
+	w := doS3Request(t, router, http.MethodGet, target)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "application/xml", w.Header().Get("Content-Type"))
+
+	var result s3ListResult
+	require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &result))
+
+	return &result
+}
+
+func testS3Database() *mockDatabase {
+	created := time.Date(2026, 1, 15, 10, 30, 0, 0, time.UTC)
+	var files []database.File
+	for i, path := range []string{"a/1.c4gh", "a/2.c4gh", "b/1.c4gh", "c.c4gh", "d/1.c4gh", "d/e/1.c4gh"} {
+		files = append(files, database.File{
+			ID:            "file-" + string(rune('0'+i)),
+			SubmittedPath: path,
+			ArchiveSize:   1000,
+			HeaderSize:    124,
+			CreatedAt:     created,
+		})
+	}
+
+	return &mockDatabase{
+		datasets: []database.Dataset{
+			{ID: "ds1", CreatedAt: created},
+			{ID: "ds10", CreatedAt: created},
+			{ID: "https://doi.org/10.1234/abc", CreatedAt: created},
+		},
+		datasetFiles: files,
+	}
+}
+
+func TestS3ListBuckets(t *testing.T) {
+	router := setupS3Router(t, testS3Database(), []string{"ds1", "ds10", "https://doi.org/10.1234/abc"})
+
+	w := doS3Request(t, router, http.MethodGet, "/s3/")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var result S3ListAllMyBucketsResult
+	require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &result))
+	require.Len(t, result.Buckets, 3)
+	assert.Equal(t, "ds1", result.Buckets[0].Name)
+	assert.Equal(t, "2026-01-15T10:30:00.000Z", result.Buckets[0].CreationDate)
+}
+
+func TestS3Bucket_resolution(t *testing.T) {
+	router := setupS3Router(t, testS3Database(), []string{"ds1", "ds10", "https://doi.org/10.1234/abc"})
+
+	assert.Equal(t, "ds10", s3List(t, router, "/s3/ds10").Name)
+	assert.Equal(t, "ds1", s3List(t, router, "/s3/ds1/").Name)
+	// The double slash of the URL is collapsed by some clients
+	assert.Equal(t, "https://doi.org/10.1234/abc", s3List(t, router, "/s3/https:/doi.org/10.1234/abc").Name)
+
+	w := doS3Request(t, router, http.MethodGet, "/s3/ds2")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	var s3Err S3Error
+	require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &s3Err))
+	assert.Equal(t, "AccessDenied", s3Err.Code)
+
+	assert.Equal(t, http.StatusOK, doS3Request(t, router, http.MethodHead, "/s3/ds1").Code)
+	assert.Equal(t, http.StatusForbidden, doS3Request(t, router, http.MethodHead, "/s3/ds2").Code)
+}
+
+func TestS3GetBucketLocation(t *testing.T) {
+	router := setupS3Router(t, testS3Database(), []string{"ds1"})
+
+	w := doS3Request(t, router, http.MethodGet, "/s3/ds1?location")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<LocationConstraint")
+}
+
+func TestS3ListObjectsV2(t *testing.T) {
+	router := setupS3Router(t, testS3Database(), []string{"ds1"})
+
+	result := s3List(t, router, "/s3/ds1?list-type=2")
+	assert.Equal(t, []string{"a/1.c4gh", "a/2.c4gh", "b/1.c4gh", "c.c4gh", "d/1.c4gh", "d/e/1.c4gh"}, result.keys())
+	assert.Equal(t, int64(1124), result.Contents[0].Size)
+	assert.False(t, result.IsTruncated)
+
+	result = s3List(t, router, "/s3/ds1?list-type=2&prefix=d/&delimiter=/")
+	assert.Equal(t, []string{"d/1.c4gh", "d/e/"}, result.keys())
+
+	result = s3List(t, router, "/s3/ds1?list-type=2&start-after=b/1.c4gh")
+	assert.Equal(t, []string{"c.c4gh", "d/1.c4gh", "d/e/1.c4gh"}, result.keys())
+}
+
+func TestS3ListObjectsV2_pagination(t *testing.T) {
+	router := setupS3Router(t, testS3Database(), []string{"ds1"})
+
+	// Common prefixes count towards max-keys and are not repeated on the next page
+	result := s3List(t, router, "/s3/ds1?list-type=2&delimiter=/&max-keys=2")
+	assert.Equal(t, []string{"a/", "b/"}, result.keys())
+	assert.Equal(t, 2, result.KeyCount)
+	require.True(t, result.IsTruncated)
+
+	result = s3List(t, router, "/s3/ds1?list-type=2&delimiter=/&max-keys=2&continuation-token="+result.NextContinuationToken)
+	assert.Equal(t, []string{"c.c4gh", "d/"}, result.keys())
+	assert.False(t, result.IsTruncated)
+	assert.Empty(t, result.NextContinuationToken)
+
+	result = s3List(t, router, "/s3/ds1?list-type=2&max-keys=1")
+	token := result.NextContinuationToken
+	require.NotEmpty(t, token)
+
+	// The token is bound to the prefix and delimiter
+	w := doS3Request(t, router, http.MethodGet, "/s3/ds1?list-type=2&prefix=a/&continuation-token="+token)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = doS3Request(t, router, http.MethodGet, "/s3/ds1?list-type=2&continuation-token=invalid")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = doS3Request(t, router, http.MethodGet, "/s3/ds1?list-type=2&max-keys=-1")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestS3ListObjects_marker(t *testing.T) {
+	router := setupS3Router(t, testS3Database(), []string{"ds1"})
+
+	result := s3List(t, router, "/s3/ds1?delimiter=/&max-keys=3")
+	assert.Equal(t, []string{"c.c4gh", "a/", "b/"}, result.keys())
+	require.True(t, result.IsTruncated)
+
+	result = s3List(t, router, "/s3/ds1?delimiter=/&max-keys=3&marker="+result.NextMarker)
+	assert.Equal(t, []string{"d/"}, result.keys())
+	assert.False(t, result.IsTruncated)
+}
+
+func TestS3ListObjects_urlEncoding(t *testing.T) {
+	db := testS3Database()
+	db.datasetFiles = []database.File{{ID: "file-1", SubmittedPath: "dir/with space.c4gh"}}
+	router := setupS3Router(t, db, []string{"ds1"})
+
+	result := s3List(t, router, "/s3/ds1?list-type=2&encoding-type=url")
+	assert.Equal(t, []string{"dir%2Fwith+space.c4gh"}, result.keys())
+}
+
+func TestS3GetObject_notFound(t *testing.T) {
+	router := setupS3Router(t, testS3Database(), []string{"ds1"})
+
+	w := doS3Request(t, router, http.MethodGet, "/s3/ds1/missing.c4gh")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	var s3Err S3Error
+	require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &s3Err))
+	assert.Equal(t, "NoSuchKey", s3Err.Code)
+
+	assert.Equal(t, http.StatusNotFound, doS3Request(t, router, http.MethodHead, "/s3/ds1/missing.c4gh").Code)
+}
+
+func TestS3GetObject(t *testing.T) {
+	env := newHtsgetTestEnv(t)
+	env.db.datasets = []database.Dataset{{ID: "test-dataset"}}
+	env.db.filesByPath[env.db.fileByID.SubmittedPath] = env.db.fileByID
+
+	router := setupTestRouterWithAuth([]string{"test-dataset"})
+	router.GET("/s3/*path", env.handlers.S3Get)
+	router.HEAD("/s3/*path", env.handlers.S3Head)
+
+	fetch := func(method, rangeHeader string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, "/s3/test-dataset/dir/sample.bam.c4gh", nil)
+		req.Header.Set("X-C4GH-Public-Key", env.clientPublicKey)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		return w
+	}
+
+	w := fetch(http.MethodGet, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NotEmpty(t, w.Header().Get("Last-Modified"))
+
+	reader, err := c4ghstreaming.NewCrypt4GHReader(bytes.NewReader(w.Body.Bytes()), env.clientKey, nil)
+	require.NoError(t, err)
+	decrypted, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, env.plaintext, decrypted)
+
+	w = fetch(http.MethodGet, "bytes=0-99")
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, 100, w.Body.Len())
+
+	w = fetch(http.MethodHead, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Content-Length"))
+}
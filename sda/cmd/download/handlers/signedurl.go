@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/audit"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/middleware"
+)
+
+// signedURLParam is the query parameter holding the signature of a signed URL.
+const signedURLParam = "signature"
+
+// signedURLContextKey is the gin context key of the claims of a signed URL.
+const signedURLContextKey = "signedURLClaims"
+
+// signedURLAuthSource is the AuthSource of requests authenticated by a signed URL.
+const signedURLAuthSource = "signed-url"
+
+// signedURLRoutes are the routes which accept a signed URL instead of a bearer token,
+// mapped to whether the URL must be bound to a public key. The header is re-encrypted
+// for the key, so a URL without one would re-encrypt the file for any key.
+var signedURLRoutes = map[string]bool{
+	"/files/:fileId":         true,
+	"/files/:fileId/header":  true,
+	"/files/:fileId/content": false,
+}
+
+// signedURLClaims are the signed contents of a signed URL. The identity of the
+// user who requested the URL is kept for the audit log.
+type signedURLClaims struct {
+	FileID    string `json:"f"`
+	PublicKey string `json:"k,omitempty"`
+	Issuer    string `json:"i,omitempty"`
+	Subject   string `json:"s"`
+	Exp       int64  `json:"e"`
+}
+
+// SignedURL is the response of a signed URL request.
+type SignedURL struct {
+	URL       string `json:"url"`
+	FileURL   string `json:"fileUrl,omitempty"`
+	ExpiresAt string `json:"expiresAt"`
+}
+
+// signedURLMAC returns the HMAC-SHA256 of data using the signed URL secret.
+func (h *Handlers) signedURLMAC(data []byte) []byte {
+	mac := hmac.New(sha256.New, h.signedURLSecret)
+	_, _ = mac.Write(data)
+
+	return mac.Sum(nil)
+}
+
+// encodeSignedURLClaims creates the signature parameter of a signed URL.
+// Wire format: base64(json) + "." + base64(hmac-sha256(json)).
+func (h *Handlers) encodeSignedURLClaims(claims signedURLClaims) string {
+	payload, _ := json.Marshal(claims)
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(h.signedURLMAC(payload))
+}
+
+// decodeSignedURLClaims verifies the signature of a signed URL, checks expiry,
+// and returns the signed claims.
+func (h *Handlers) decodeSignedURLClaims(signature string) (*signedURLClaims, error) {
+	parts := strings.SplitN(signature, ".", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("malformed signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+
+	if !hmac.Equal(h.signedURLMAC(payload), sig) {
+		return nil, errors.New("invalid signature")
+	}
+
+	var claims signedURLClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("malformed signature")
+	}
+
+	if time.Now().Unix() > claims.Exp {
+		return nil, errors.New("signed URL expired")
+	}
+
+	return &claims, nil
+}
+
+// getSignedURLClaims returns the claims of the signed URL the request was
+// authenticated with, or nil for requests authenticated otherwise.
+func getSignedURLClaims(c *gin.Context) *signedURLClaims {
+	val, exists := c.Get(signedURLContextKey)
+	if !exists {
+		return nil
+	}
+
+	claims, _ := val.(*signedURLClaims)
+
+	return claims
+}
+
+// signedURLMiddleware authenticates requests carrying a signed URL signature,
+// all other requests are passed on to the token middleware next.
+func (h *Handlers) signedURLMiddleware(next gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		signature := c.Query(signedURLParam)
+		if signature == "" || h.signedURLSecret == nil {
+			next(c)
+
+			return
+		}
+
+		claims, err := h.decodeSignedURLClaims(signature)
+		if err == nil {
+			requiresKey, ok := signedURLRoutes[c.FullPath()]
+			if !ok || claims.FileID != c.Param("fileId") || (requiresKey && claims.PublicKey == "") {
+				err = errors.New("signed URL not valid for this request")
+			}
+		}
+		if err != nil {
+			problemJSON(c, http.StatusUnauthorized, "invalid or expired signed URL")
+			h.auditLogger.Log(c.Request.Context(), audit.Event{
+				Event:         audit.EventDenied,
+				CorrelationID: c.GetString("correlationId"),
+				Path:          c.Request.URL.Path,
+				HTTPStatus:    http.StatusUnauthorized,
+				AuthType:      signedURLAuthSource,
+				ErrorReason:   err.Error(),
+			})
+			c.Abort()
+
+			return
+		}
+
+		c.Set(middleware.ContextKey, middleware.AuthContext{
+			Issuer:     claims.Issuer,
+			Subject:    claims.Subject,
+			AuthSource: signedURLAuthSource,
+		})
+		c.Set(signedURLContextKey, claims)
+		c.Next()
+	}
+}
+
+// signedURLExpiry parses the requested lifetime of a signed URL in seconds,
+// defaulting to and capped at the configured lifetime.
+func (h *Handlers) signedURLExpiry(raw string) (time.Duration, error) {
+	if raw == "" {
+		return h.signedURLTTL, nil
+	}
+
+	seconds, err := strconv.Atoi(raw)
+	if err != nil || seconds < 1 {
+		return 0, errors.New("expiresIn must be a positive integer")
+	}
+
+	ttl := time.Duration(seconds) * time.Second
+	if ttl > h.signedURLTTL {
+		return 0, fmt.Errorf("expiresIn must not exceed %d seconds", int(h.signedURLTTL.Seconds()))
+	}
+
+	return ttl, nil
+}
+
+// signFileURL returns a URL to the given path of a file, signed for the user
+// and public key until expires.
+func (h *Handlers) signFileURL(c *gin.Context, authCtx middleware.AuthContext, fileID, suffix, publicKey string, expires time.Time) string {
+	signature := h.encodeSignedURLClaims(signedURLClaims{
+		FileID:    fileID,
+		PublicKey: publicKey,
+		Issuer:    authCtx.Issuer,
+		Subject:   authCtx.Subject,
+		Exp:       expires.Unix(),
+	})
+
+	return fmt.Sprintf("%s/files/%s%s?%s=%s", requestBaseURL(c), url.PathEscape(fileID), suffix, signedURLParam, signature)
+}
+
+// CreateSignedURL returns a short-lived signed URL to the content of a file,
+// which can be downloaded without a bearer token. When a public key is given
+// the URL is bound to it, and a signed URL to the complete re-encrypted file is
+// returned as well.
+// POST /files/:fileId/signed-url
+func (h *Handlers) CreateSignedURL(c *gin.Context) {
+	ttl, err := h.signedURLExpiry(c.Query("expiresIn"))
+	if err != nil {
+		problemJSON(c, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	publicKey, errorCode, detail := extractPublicKey(c)
+	if errorCode == "KEY_CONFLICT" {
+		problemJSONWithCode(c, http.StatusBadRequest, detail, errorCode)
+
+		return
+	}
+
+	authCtx, file, ok := h.authorizeFile(c, c.Param("fileId"))
+	if !ok {
+		return
+	}
+
+	expires := time.Now().Add(ttl)
+	response := SignedURL{
+		URL:       h.signFileURL(c, authCtx, file.ID, "/content", publicKey, expires),
+		ExpiresAt: expires.UTC().Format(time.RFC3339),
+	}
+	if publicKey != "" {
+		response.FileURL = h.signFileURL(c, authCtx, file.ID, "", publicKey, expires)
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	c4ghstreaming "github.com/neicnordic/crypt4gh/streaming"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/audit"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/database"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSignedURLTestEnv is the htsget test dataset with signed URLs enabled, and
+// a router standing in for the registered routes, where requests with an
+// Authorization header are authenticated as user-1.
+func newSignedURLTestEnv(t *testing.T) (*htsgetTestEnv, *gin.Engine, *capturingLogger) {
+	t.Helper()
+	env := newHtsgetTestEnv(t)
+	WithSignedURLs([]byte("test-secret"), 10*time.Minute)(env.handlers)
+
+	logger := &capturingLogger{}
+	env.handlers.auditLogger = logger
+
+	tokenMiddleware := func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			problemJSON(c, http.StatusUnauthorized, "authentication required")
+			c.Abort()
+
+			return
+		}
+		c.Set(middleware.ContextKey, middleware.AuthContext{
+			Issuer:     "https://issuer.example.org",
+			Subject:    "user-1",
+			Datasets:   []string{"test-dataset"},
+			AuthSource: "jwt",
+		})
+		c.Next()
+	}
+
+	router := gin.New()
+	files := router.Group("/files")
+	files.Use(env.handlers.signedURLMiddleware(tokenMiddleware))
+	files.GET("/:fileId", env.handlers.DownloadFile)
+	files.GET("/:fileId/content", env.handlers.GetFileContent)
+	files.POST("/:fileId/signed-url", env.handlers.CreateSignedURL)
+	router.GET("/objects/*path", tokenMiddleware, env.handlers.GetDrsObject)
+
+	return env, router, logger
+}
+
+func doSignedURLRequest(router *gin.Engine, method, target string, headers map[string]string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, target, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestCreateSignedURL(t *testing.T) {
+	env, router, logger := newSignedURLTestEnv(t)
+
+	w := doSignedURLRequest(router, http.MethodPost, "http://example.com/files/bam-file/signed-url?expiresIn=60", map[string]string{
+		"Authorization":     "Bearer token",
+		"X-C4GH-Public-Key": env.clientPublicKey,
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var signed SignedURL
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &signed))
+	assert.True(t, strings.HasPrefix(signed.URL, "http://example.com/files/bam-file/content?signature="))
+	assert.True(t, strings.HasPrefix(signed.FileURL, "http://example.com/files/bam-file?signature="))
+	expiresAt, err := time.Parse(time.RFC3339, signed.ExpiresAt)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), expiresAt, 5*time.Second)
+
+	// The complete file is re-encrypted for the public key the URL was signed for
+	w = doSignedURLRequest(router, http.MethodGet, signed.FileURL, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	reader, err := c4ghstreaming.NewCrypt4GHReader(bytes.NewReader(w.Body.Bytes()), env.clientKey, nil)
+	require.NoError(t, err)
+	decrypted, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, env.plaintext, decrypted)
+
+	w = doSignedURLRequest(router, http.MethodGet, signed.URL, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, env.body, w.Body.Bytes())
+
+	// Downloads are audited with the identity of the user who signed the URL
+	require.Len(t, logger.events, 2)
+	for _, event := range logger.events {
+		assert.Equal(t, "user-1", event.UserID)
+		assert.Equal(t, signedURLAuthSource, event.AuthType)
+		assert.Equal(t, "bam-file", event.FileID)
+	}
+	assert.Equal(t, audit.EventContent, logger.events[1].Event)
+}
+
+func TestCreateSignedURL_invalidRequest(t *testing.T) {
+	_, router, _ := newSignedURLTestEnv(t)
+
+	w := doSignedURLRequest(router, http.MethodPost, "/files/bam-file/signed-url?expiresIn=3600", map[string]string{"Authorization": "Bearer token"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = doSignedURLRequest(router, http.MethodPost, "/files/bam-file/signed-url?expiresIn=soon", map[string]string{"Authorization": "Bearer token"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = doSignedURLRequest(router, http.MethodPost, "/files/bam-file/signed-url", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestSignedURL_rejected(t *testing.T) {
+	env, router, logger := newSignedURLTestEnv(t)
+
+	w := doSignedURLRequest(router, http.MethodPost, "/files/bam-file/signed-url", map[string]string{
+		"Authorization":     "Bearer token",
+		"X-C4GH-Public-Key": env.clientPublicKey,
+	})
+	require.Equal(t, http.StatusOK, w.Code)
+	var signed SignedURL
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &signed))
+	signature := signed.URL[strings.Index(signed.URL, "?"):]
+
+	expired := "?signature=" + env.handlers.encodeSignedURLClaims(signedURLClaims{
+		FileID:  "bam-file",
+		Subject: "user-1",
+		Exp:     time.Now().Add(-time.Minute).Unix(),
+	})
+
+	for name, target := range map[string]string{
+		"tampered":      signed.URL + "x",
+		"other file":    "/files/bai-file/content" + signature,
+		"signing route": "/files/bam-file/signed-url" + signature,
+		"expired":       "/files/bam-file/content" + expired,
+	} {
+		method := http.MethodGet
+		if name == "signing route" {
+			method = http.MethodPost
+		}
+		w := doSignedURLRequest(router, method, target, nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, name)
+	}
+	for _, event := range logger.events {
+		assert.Equal(t, audit.EventDenied, event.Event)
+	}
+
+	// The public key is bound to the URL
+	otherKey := "LS0tLS1CRUdJTiBDUllQVDRHSCBQVUJMSUMgS0VZLS0tLS0KQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQT0KLS0tLS1FTkQgQ1JZUFQ0R0ggUFVCTElDIEtFWS0tLS0tCg=="
+	w = doSignedURLRequest(router, http.MethodGet, signed.FileURL, map[string]string{"X-C4GH-Public-Key": otherKey})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var response ProblemDetails
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "KEY_CONFLICT", response.ErrorCode)
+}
+
+func TestSignedURL_withoutPublicKey(t *testing.T) {
+	env, router, _ := newSignedURLTestEnv(t)
+
+	w := doSignedURLRequest(router, http.MethodPost, "/files/bam-file/signed-url", map[string]string{"Authorization": "Bearer token"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var signed SignedURL
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &signed))
+	assert.Empty(t, signed.FileURL)
+	signature := signed.URL[strings.Index(signed.URL, "?"):]
+
+	// A URL which is not bound to a public key only gives access to the content
+	w = doSignedURLRequest(router, http.MethodGet, "/files/bam-file"+signature, map[string]string{"X-C4GH-Public-Key": env.clientPublicKey})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = doSignedURLRequest(router, http.MethodGet, signed.URL, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestCreateSignedURL_accessDenied(t *testing.T) {
+	env, router, _ := newSignedURLTestEnv(t)
+	env.db.hasPermission = false
+
+	w := doSignedURLRequest(router, http.MethodPost, "/files/bam-file/signed-url", map[string]string{"Authorization": "Bearer token"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = doSignedURLRequest(router, http.MethodGet, "/objects/bam-file/access/signed-url", map[string]string{"Authorization": "Bearer token"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestGetDrsObject_signedURLAccess(t *testing.T) {
+	env, router, _ := newSignedURLTestEnv(t)
+	env.db.filesByPath[env.db.fileByID.SubmittedPath] = env.db.fileByID
+	env.db.fileChecksums = []database.Checksum{{Type: "SHA256", Checksum: "a1b2c3d4"}}
+
+	w := doSignedURLRequest(router, http.MethodGet, "/objects/test-dataset/dir/sample.bam.c4gh", map[string]string{"Authorization": "Bearer token"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var obj DrsObject
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &obj))
+	require.Len(t, obj.AccessMethods, 2)
+	assert.NotNil(t, obj.AccessMethods[0].AccessURL)
+	assert.Nil(t, obj.AccessMethods[1].AccessURL)
+	assert.Equal(t, drsSignedURLAccessID, obj.AccessMethods[1].AccessID)
+
+	w = doSignedURLRequest(router, http.MethodGet, "http://example.com/objects/bam-file/access/"+obj.AccessMethods[1].AccessID, map[string]string{"Authorization": "Bearer token"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var accessURL DrsAccessURL
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &accessURL))
+
+	w = doSignedURLRequest(router, http.MethodGet, accessURL.URL, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, env.body, w.Body.Bytes())
+}
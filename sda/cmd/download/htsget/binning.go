@@ -0,0 +1,444 @@
+package htsget
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// bgzfEOFSize is the size of the empty BGZF block which marks the end of a BGZF file.
+const bgzfEOFSize = 28
+
+// Binning scheme of BAI and tabix indexes, CSI indexes store their own.
+const (
+	baiMinShift = 14
+	baiDepth    = 5
+)
+
+// maxIndexEntries bounds the counts read from an index, to fail on corrupt
+// indexes rather than allocating without limit.
+const maxIndexEntries = 1 << 24
+
+// chunk is a range of virtual file offsets, the upper 48 bits of a virtual
+// offset are the offset of a BGZF block in the file, the lower 16 bits the
+// offset within the uncompressed block.
+type chunk struct {
+	Begin uint64
+	End   uint64
+}
+
+type binningReference struct {
+	bins map[uint32][]chunk
+	// loffsets are the virtual offsets of the first record in the bins of CSI indexes
+	loffsets map[uint32]uint64
+	// linear is the linear index of BAI and tabix indexes, the virtual offset
+	// of the first record in each 16kbp window
+	linear []uint64
+}
+
+// BinningIndex is a BAI, CSI or tabix index of a BGZF compressed file.
+type BinningIndex struct {
+	minShift int
+	depth    int
+	refs     []binningReference
+	// names are the reference names stored in tabix indexes, BAM files keep
+	// them in the file header
+	names []string
+}
+
+// ReadBAI reads a BAI index of a BAM file.
+func ReadBAI(r io.Reader) (*BinningIndex, error) {
+	br := bufio.NewReader(r)
+	if err := readMagic(br, "BAI\x01"); err != nil {
+		return nil, err
+	}
+	idx := &BinningIndex{minShift: baiMinShift, depth: baiDepth}
+	if err := idx.readReferences(br, false); err != nil {
+		return nil, err
+	}
+
+	return idx, nil
+}
+
+// ReadTBI reads a tabix index.
+func ReadTBI(r io.Reader) (*BinningIndex, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress tabix index: %w", err)
+	}
+	br := bufio.NewReader(gz)
+	if err := readMagic(br, "TBI\x01"); err != nil {
+		return nil, err
+	}
+
+	var nRef int32
+	if err := binary.Read(br, binary.LittleEndian, &nRef); err != nil {
+		return nil, err
+	}
+	// format, col_seq, col_beg, col_end, meta and skip
+	if _, err := io.CopyN(io.Discard, br, 6*4); err != nil {
+		return nil, err
+	}
+	names, err := readNames(br)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) != int(nRef) {
+		return nil, fmt.Errorf("tabix index has %d names for %d references", len(names), nRef)
+	}
+
+	idx := &BinningIndex{minShift: baiMinShift, depth: baiDepth, names: names}
+	if err := idx.readBins(br, int(nRef), false); err != nil {
+		return nil, err
+	}
+
+	return idx, nil
+}
+
+// ReadCSI reads a CSI index, the reference names are read from the tabix
+// metadata of indexes of VCF files.
+func ReadCSI(r io.Reader) (*BinningIndex, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress CSI index: %w", err)
+	}
+	br := bufio.NewReader(gz)
+	if err := readMagic(br, "CSI\x01"); err != nil {
+		return nil, err
+	}
+
+	var header struct {
+		MinShift int32
+		Depth    int32
+		LAux     int32
+	}
+	if err := binary.Read(br, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+	if header.MinShift < 0 || header.MinShift > 32 || header.Depth < 0 || header.Depth > 10 || header.LAux < 0 || header.LAux > maxIndexEntries {
+		return nil, errors.New("invalid CSI index header")
+	}
+
+	idx := &BinningIndex{minShift: int(header.MinShift), depth: int(header.Depth)}
+	aux := make([]byte, header.LAux)
+	if _, err := io.ReadFull(br, aux); err != nil {
+		return nil, err
+	}
+	// The tabix metadata is format, col_seq, col_beg, col_end, meta, skip and the names
+	if len(aux) >= 7*4 {
+		names, err := readNames(bytes.NewReader(aux[6*4:]))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSI reference names: %w", err)
+		}
+		idx.names = names
+	}
+
+	if err := idx.readReferences(br, true); err != nil {
+		return nil, err
+	}
+
+	return idx, nil
+}
+
+// Names returns the reference names stored in the index, which is nil for
+// indexes of BAM files.
+func (idx *BinningIndex) Names() []string {
+	return idx.names
+}
+
+func (idx *BinningIndex) readReferences(r io.Reader, csi bool) error {
+	var nRef int32
+	if err := binary.Read(r, binary.LittleEndian, &nRef); err != nil {
+		return err
+	}
+
+	return idx.readBins(r, int(nRef), csi)
+}
+
+func (idx *BinningIndex) readBins(r io.Reader, nRef int, csi bool) error {
+	if nRef < 0 || nRef > maxIndexEntries {
+		return fmt.Errorf("invalid number of references: %d", nRef)
+	}
+
+	idx.refs = make([]binningReference, nRef)
+	for i := range idx.refs {
+		ref := binningReference{bins: make(map[uint32][]chunk)}
+		if csi {
+			ref.loffsets = make(map[uint32]uint64)
+		}
+
+		nBin, err := readCount(r)
+		if err != nil {
+			return err
+		}
+		for range nBin {
+			var bin uint32
+			if err := binary.Read(r, binary.LittleEndian, &bin); err != nil {
+				return err
+			}
+			if csi {
+				var loffset uint64
+				if err := binary.Read(r, binary.LittleEndian, &loffset); err != nil {
+					return err
+				}
+				ref.loffsets[bin] = loffset
+			}
+			nChunk, err := readCount(r)
+			if err != nil {
+				return err
+			}
+			chunks := make([]chunk, nChunk)
+			if err := binary.Read(r, binary.LittleEndian, chunks); err != nil {
+				return err
+			}
+			ref.bins[bin] = chunks
+		}
+
+		if !csi {
+			nIntv, err := readCount(r)
+			if err != nil {
+				return err
+			}
+			ref.linear = make([]uint64, nIntv)
+			if err := binary.Read(r, binary.LittleEndian, ref.linear); err != nil {
+				return err
+			}
+		}
+		idx.refs[i] = ref
+	}
+
+	return nil
+}
+
+// pseudoBin is the bin holding the statistics of a reference, rather than records.
+func (idx *BinningIndex) pseudoBin() uint32 {
+	return uint32((1<<((idx.depth+1)*3))-1)/7 + 1 // #nosec G115 -- depth is at most 10
+}
+
+// overlappingBins returns the bins which may hold records overlapping [start, end).
+func (idx *BinningIndex) overlappingBins(start, end int64) []uint32 {
+	var bins []uint32
+	end--
+	shift := idx.minShift + idx.depth*3
+	for level, offset := 0, 0; level <= idx.depth; level++ {
+		for bin := offset + int(start>>shift); bin <= offset+int(end>>shift); bin++ {
+			bins = append(bins, uint32(bin)) // #nosec G115 -- bins are bounded by the depth
+		}
+		shift -= 3
+		offset += 1 << (level * 3)
+	}
+
+	return bins
+}
+
+// minOffset returns the virtual offset before which there are no records
+// overlapping the position start, taken from the linear index or from the bins
+// of CSI indexes.
+func (idx *BinningIndex) minOffset(ref binningReference, start int64) uint64 {
+	if ref.loffsets == nil {
+		window := int(start >> baiMinShift)
+		if window >= len(ref.linear) {
+			if len(ref.linear) == 0 {
+				return 0
+			}
+			window = len(ref.linear) - 1
+		}
+
+		return ref.linear[window]
+	}
+
+	// The smallest bin holding the position, and its parents
+	offset := ((1 << (idx.depth * 3)) - 1) / 7
+	for bin := offset + int(start>>idx.minShift); bin >= 0; bin = (bin - 1) >> 3 {
+		if loffset, ok := ref.loffsets[uint32(bin)]; ok { // #nosec G115 -- bins are bounded by the depth
+			return loffset
+		}
+	}
+
+	return 0
+}
+
+// boundaries returns the sorted offsets of the BGZF blocks known from the
+// index, the end of file marker included.
+func (idx *BinningIndex) boundaries(fileSize int64) []int64 {
+	seen := map[int64]bool{fileSize - bgzfEOFSize: true}
+	pseudoBin := idx.pseudoBin()
+	for _, ref := range idx.refs {
+		for bin, chunks := range ref.bins {
+			if bin == pseudoBin {
+				continue
+			}
+			for _, c := range chunks {
+				seen[blockOffset(c.Begin)] = true
+				seen[blockOffset(c.End)] = true
+			}
+		}
+		for _, offset := range ref.linear {
+			seen[blockOffset(offset)] = true
+		}
+		for _, offset := range ref.loffsets {
+			seen[blockOffset(offset)] = true
+		}
+	}
+
+	offsets := make([]int64, 0, len(seen))
+	for offset := range seen {
+		offsets = append(offsets, offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+	return offsets
+}
+
+// blockEnd returns the end of the BGZF block starting at offset, which is the
+// start of the next known block.
+func blockEnd(boundaries []int64, offset, fileSize int64) int64 {
+	i := sort.Search(len(boundaries), func(i int) bool { return boundaries[i] > offset })
+	if i == len(boundaries) {
+		return fileSize
+	}
+
+	return boundaries[i]
+}
+
+// chunkRange returns the range of the BGZF blocks holding the chunk.
+func chunkRange(boundaries []int64, c chunk, fileSize int64) ByteRange {
+	end := blockOffset(c.End)
+	if c.End&0xffff != 0 {
+		end = blockEnd(boundaries, end, fileSize)
+	}
+
+	return ByteRange{Start: blockOffset(c.Begin), End: end}
+}
+
+// HeaderRange returns the range of the blocks before the first record, the
+// block of the first record is included when the header ends within it.
+func (idx *BinningIndex) HeaderRange(fileSize int64) ByteRange {
+	first, found := uint64(0), false
+	pseudoBin := idx.pseudoBin()
+	for _, ref := range idx.refs {
+		for bin, chunks := range ref.bins {
+			for _, c := range chunks {
+				if bin != pseudoBin && (!found || c.Begin < first) {
+					first, found = c.Begin, true
+				}
+			}
+		}
+	}
+	if !found {
+		return ByteRange{Start: 0, End: fileSize}
+	}
+	if first&0xffff == 0 {
+		return ByteRange{Start: 0, End: blockOffset(first)}
+	}
+
+	return ByteRange{Start: 0, End: blockEnd(idx.boundaries(fileSize), blockOffset(first), fileSize)}
+}
+
+// Ranges returns the ranges of the BGZF blocks holding the records of the
+// reference which overlap [start, end), an end of 0 is the end of the reference.
+func (idx *BinningIndex) Ranges(refID int, start, end, fileSize int64) []ByteRange {
+	if refID < 0 || refID >= len(idx.refs) {
+		return nil
+	}
+	if end <= 0 {
+		end = 1 << (idx.minShift + idx.depth*3)
+	}
+
+	ref := idx.refs[refID]
+	boundaries := idx.boundaries(fileSize)
+	minOffset := idx.minOffset(ref, start)
+	pseudoBin := idx.pseudoBin()
+
+	var ranges []ByteRange
+	for _, bin := range idx.overlappingBins(start, end) {
+		if bin == pseudoBin {
+			continue
+		}
+		for _, c := range ref.bins[bin] {
+			if c.End <= minOffset {
+				continue
+			}
+			ranges = append(ranges, chunkRange(boundaries, c, fileSize))
+		}
+	}
+
+	return Merge(ranges)
+}
+
+// UnplacedRanges returns the range after the last placed record, where the
+// unplaced records are stored.
+func (idx *BinningIndex) UnplacedRanges(fileSize int64) []ByteRange {
+	var last uint64
+	pseudoBin := idx.pseudoBin()
+	for _, ref := range idx.refs {
+		for bin, chunks := range ref.bins {
+			for _, c := range chunks {
+				if bin != pseudoBin && c.End > last {
+					last = c.End
+				}
+			}
+		}
+	}
+
+	return []ByteRange{{Start: blockOffset(last), End: fileSize}}
+}
+
+// EOFRange returns the range of the empty BGZF block ending the file.
+func (idx *BinningIndex) EOFRange(fileSize int64) ByteRange {
+	return ByteRange{Start: max(fileSize-bgzfEOFSize, 0), End: fileSize}
+}
+
+func blockOffset(virtualOffset uint64) int64 {
+	return int64(virtualOffset >> 16) // #nosec G115 -- block offsets are 48 bit
+}
+
+func readMagic(r io.Reader, magic string) error {
+	buf := make([]byte, len(magic))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return fmt.Errorf("failed to read magic: %w", err)
+	}
+	if string(buf) != magic {
+		return fmt.Errorf("%w: expected magic %q", ErrUnsupported, magic)
+	}
+
+	return nil
+}
+
+func readCount(r io.Reader) (int, error) {
+	var n int32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return 0, err
+	}
+	if n < 0 || n > maxIndexEntries {
+		return 0, fmt.Errorf("invalid count: %d", n)
+	}
+
+	return int(n), nil
+}
+
+// readNames reads the NUL separated names of a tabix index, prefixed by their total length.
+func readNames(r io.Reader) ([]string, error) {
+	n, err := readCount(r)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, name := range bytes.Split(bytes.TrimSuffix(buf, []byte{0}), []byte{0}) {
+		if len(name) > 0 {
+			names = append(names, string(name))
+		}
+	}
+
+	return names, nil
+}
@@ -0,0 +1,169 @@
+package htsget
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// virtualOffset builds a virtual offset from a block offset and an offset within the block.
+func virtualOffset(block int64, within uint16) uint64 {
+	return uint64(block)<<16 | uint64(within)
+}
+
+type testBin struct {
+	bin     uint32
+	loffset uint64
+	chunks  []chunk
+}
+
+type testReference struct {
+	bins   []testBin
+	linear []uint64
+}
+
+// testReferences are two references, the first with records in the first two
+// 16kbp windows and the second with records in the first window.
+var testReferences = []testReference{
+	{
+		bins: []testBin{
+			{bin: 4681, loffset: virtualOffset(1000, 0), chunks: []chunk{{Begin: virtualOffset(1000, 0), End: virtualOffset(3000, 5)}}},
+			{bin: 4682, loffset: virtualOffset(3000, 5), chunks: []chunk{{Begin: virtualOffset(3000, 5), End: virtualOffset(6000, 0)}}},
+			// The pseudo bin holds statistics
+			{bin: 37450, chunks: []chunk{{Begin: virtualOffset(1000, 0), End: virtualOffset(6000, 0)}, {Begin: 12, End: 0}}},
+		},
+		linear: []uint64{virtualOffset(1000, 0), virtualOffset(3000, 5)},
+	},
+	{
+		bins: []testBin{
+			{bin: 4681, loffset: virtualOffset(6000, 0), chunks: []chunk{{Begin: virtualOffset(6000, 0), End: virtualOffset(8000, 10)}}},
+		},
+		linear: []uint64{virtualOffset(6000, 0)},
+	},
+}
+
+const testFileSize = 10000 + bgzfEOFSize
+
+func writeLE(buf *bytes.Buffer, values ...any) {
+	for _, v := range values {
+		_ = binary.Write(buf, binary.LittleEndian, v)
+	}
+}
+
+func writeReferences(buf *bytes.Buffer, csi bool) {
+	writeLE(buf, int32(len(testReferences)))
+	for _, ref := range testReferences {
+		writeLE(buf, int32(len(ref.bins)))
+		for _, bin := range ref.bins {
+			writeLE(buf, bin.bin)
+			if csi {
+				writeLE(buf, bin.loffset)
+			}
+			writeLE(buf, int32(len(bin.chunks)), bin.chunks)
+		}
+		if !csi {
+			writeLE(buf, int32(len(ref.linear)), ref.linear)
+		}
+	}
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	gz := gzip.NewWriter(buf)
+	_, err := gz.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	return buf.Bytes()
+}
+
+func tabixMeta(names ...string) []byte {
+	buf := new(bytes.Buffer)
+	// format, col_seq, col_beg, col_end, meta and skip of a VCF file
+	writeLE(buf, int32(2), int32(1), int32(2), int32(0), int32('#'), int32(0))
+	var nameData []byte
+	for _, name := range names {
+		nameData = append(append(nameData, name...), 0)
+	}
+	writeLE(buf, int32(len(nameData)), nameData)
+
+	return buf.Bytes()
+}
+
+func testBAI() []byte {
+	buf := bytes.NewBufferString("BAI\x01")
+	writeReferences(buf, false)
+
+	return buf.Bytes()
+}
+
+func TestReadBAI(t *testing.T) {
+	idx, err := ReadBAI(bytes.NewReader(testBAI()))
+	require.NoError(t, err)
+	assert.Nil(t, idx.Names())
+
+	assert.Equal(t, ByteRange{Start: 0, End: 1000}, idx.HeaderRange(testFileSize))
+	assert.Equal(t, ByteRange{Start: 10000, End: testFileSize}, idx.EOFRange(testFileSize))
+
+	// The first chunk ends within the block at 3000, which ends at the next known block
+	assert.Equal(t, []ByteRange{{Start: 1000, End: 6000}}, idx.Ranges(0, 0, 100, testFileSize))
+	assert.Equal(t, []ByteRange{{Start: 3000, End: 6000}}, idx.Ranges(0, 20000, 20100, testFileSize))
+	assert.Equal(t, []ByteRange{{Start: 1000, End: 6000}}, idx.Ranges(0, 0, 0, testFileSize))
+	// The last block before the end of file marker ends at the marker
+	assert.Equal(t, []ByteRange{{Start: 6000, End: 10000}}, idx.Ranges(1, 0, 0, testFileSize))
+	assert.Empty(t, idx.Ranges(0, 1<<20, 1<<20+100, testFileSize))
+	assert.Empty(t, idx.Ranges(2, 0, 0, testFileSize))
+
+	assert.Equal(t, []ByteRange{{Start: 8000, End: testFileSize}}, idx.UnplacedRanges(testFileSize))
+}
+
+func TestReadBAI_invalid(t *testing.T) {
+	_, err := ReadBAI(bytes.NewReader([]byte("CSI\x01")))
+	assert.ErrorIs(t, err, ErrUnsupported)
+
+	_, err = ReadBAI(bytes.NewReader(testBAI()[:40]))
+	assert.Error(t, err)
+}
+
+func TestReadTBI(t *testing.T) {
+	buf := bytes.NewBufferString("TBI\x01")
+	writeLE(buf, int32(len(testReferences)), tabixMeta("chr1", "chr2"))
+	var refs bytes.Buffer
+	writeReferences(&refs, false)
+	buf.Write(refs.Bytes()[4:])
+
+	idx, err := ReadTBI(bytes.NewReader(gzipped(t, buf.Bytes())))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chr1", "chr2"}, idx.Names())
+	assert.Equal(t, []ByteRange{{Start: 6000, End: 10000}}, idx.Ranges(1, 0, 100, testFileSize))
+}
+
+func TestReadCSI(t *testing.T) {
+	meta := tabixMeta("chr1", "chr2")
+	buf := bytes.NewBufferString("CSI\x01")
+	writeLE(buf, int32(14), int32(5), int32(len(meta)), meta)
+	writeReferences(buf, true)
+
+	idx, err := ReadCSI(bytes.NewReader(gzipped(t, buf.Bytes())))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chr1", "chr2"}, idx.Names())
+	assert.Equal(t, ByteRange{Start: 0, End: 1000}, idx.HeaderRange(testFileSize))
+	assert.Equal(t, []ByteRange{{Start: 1000, End: 6000}}, idx.Ranges(0, 0, 100, testFileSize))
+	// The bin offsets exclude the chunks before the region
+	assert.Equal(t, []ByteRange{{Start: 3000, End: 6000}}, idx.Ranges(0, 20000, 20100, testFileSize))
+
+	_, err = ReadCSI(bytes.NewReader(gzipped(t, []byte("BAI\x01"))))
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
+
+func TestOverlappingBins(t *testing.T) {
+	idx := &BinningIndex{minShift: baiMinShift, depth: baiDepth}
+	assert.Equal(t, []uint32{0, 1, 9, 73, 585, 4681}, idx.overlappingBins(0, 100))
+	assert.Equal(t, []uint32{0, 1, 9, 73, 585, 4681, 4682}, idx.overlappingBins(16000, 17000))
+	assert.Equal(t, uint32(37450), idx.pseudoBin())
+}
@@ -0,0 +1,144 @@
+package htsget
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// cramEOFSize is the size of the empty container which marks the end of a CRAM 3 file.
+const cramEOFSize = 38
+
+// Reference IDs of CRAM slices without a position and of slices with records
+// of several references.
+const (
+	craiUnplaced = -1
+	craiMultiRef = -2
+)
+
+type craiEntry struct {
+	refID     int
+	start     int64
+	span      int64
+	container int64
+}
+
+// CRAMIndex is a CRAI index of a CRAM file.
+type CRAMIndex struct {
+	entries []craiEntry
+}
+
+// ReadCRAI reads a CRAI index, which is a gzip compressed table with a line
+// per slice of the CRAM file.
+func ReadCRAI(r io.Reader) (*CRAMIndex, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress CRAI index: %w", err)
+	}
+
+	idx := &CRAMIndex{}
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("invalid CRAI line: %q", line)
+		}
+
+		var values [4]int64
+		for i := range values {
+			values[i], err = strconv.ParseInt(fields[i], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CRAI line: %q", line)
+			}
+		}
+		idx.entries = append(idx.entries, craiEntry{
+			refID:     int(values[0]),
+			start:     values[1],
+			span:      values[2],
+			container: values[3],
+		})
+		if len(idx.entries) > maxIndexEntries {
+			return nil, fmt.Errorf("CRAI index has more than %d slices", maxIndexEntries)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read CRAI index: %w", err)
+	}
+
+	return idx, nil
+}
+
+// boundaries returns the sorted offsets of the containers, the end of file
+// container included.
+func (idx *CRAMIndex) boundaries(fileSize int64) []int64 {
+	seen := map[int64]bool{fileSize - cramEOFSize: true}
+	for _, e := range idx.entries {
+		seen[e.container] = true
+	}
+
+	offsets := make([]int64, 0, len(seen))
+	for offset := range seen {
+		offsets = append(offsets, offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+	return offsets
+}
+
+// HeaderRange returns the range of the file definition and the header
+// container, which come before the first indexed container.
+func (idx *CRAMIndex) HeaderRange(fileSize int64) ByteRange {
+	if len(idx.entries) == 0 {
+		return ByteRange{Start: 0, End: fileSize}
+	}
+
+	return ByteRange{Start: 0, End: idx.boundaries(fileSize)[0]}
+}
+
+// Ranges returns the ranges of the containers holding slices of the reference
+// which overlap [start, end), an end of 0 is the end of the reference. Slices
+// of several references are always included.
+func (idx *CRAMIndex) Ranges(refID int, start, end, fileSize int64) []ByteRange {
+	return idx.containers(fileSize, func(e craiEntry) bool {
+		if e.refID == craiMultiRef {
+			return true
+		}
+		// Alignment starts are 1-based
+		sliceStart := e.start - 1
+
+		return e.refID == refID && (end <= 0 || sliceStart < end) && sliceStart+e.span > start
+	})
+}
+
+// UnplacedRanges returns the ranges of the containers holding unplaced slices.
+func (idx *CRAMIndex) UnplacedRanges(fileSize int64) []ByteRange {
+	return idx.containers(fileSize, func(e craiEntry) bool {
+		return e.refID == craiUnplaced
+	})
+}
+
+// EOFRange returns the range of the empty container ending the file.
+func (idx *CRAMIndex) EOFRange(fileSize int64) ByteRange {
+	return ByteRange{Start: max(fileSize-cramEOFSize, 0), End: fileSize}
+}
+
+func (idx *CRAMIndex) containers(fileSize int64, include func(craiEntry) bool) []ByteRange {
+	boundaries := idx.boundaries(fileSize)
+
+	var ranges []ByteRange
+	for _, e := range idx.entries {
+		if include(e) {
+			ranges = append(ranges, ByteRange{Start: e.container, End: blockEnd(boundaries, e.container, fileSize)})
+		}
+	}
+
+	return Merge(ranges)
+}
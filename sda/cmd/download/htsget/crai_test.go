@@ -0,0 +1,49 @@
+package htsget
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testCRAMSize = 9000 + cramEOFSize
+
+func testCRAI(t *testing.T) []byte {
+	t.Helper()
+
+	return gzipped(t, []byte(
+		"0\t1\t10000\t1000\t100\t500\n"+
+			"0\t10001\t10000\t2000\t100\t500\n"+
+			"0\t20001\t5000\t2000\t600\t500\n"+
+			"1\t1\t10000\t4000\t100\t500\n"+
+			"-2\t0\t0\t6000\t100\t500\n"+
+			"-1\t0\t0\t8000\t100\t500\n",
+	))
+}
+
+func TestReadCRAI(t *testing.T) {
+	idx, err := ReadCRAI(bytes.NewReader(testCRAI(t)))
+	require.NoError(t, err)
+
+	assert.Equal(t, ByteRange{Start: 0, End: 1000}, idx.HeaderRange(testCRAMSize))
+	assert.Equal(t, ByteRange{Start: 9000, End: testCRAMSize}, idx.EOFRange(testCRAMSize))
+
+	// Slices of several references are always included
+	assert.Equal(t, []ByteRange{{Start: 1000, End: 2000}, {Start: 6000, End: 8000}}, idx.Ranges(0, 0, 100, testCRAMSize))
+	assert.Equal(t, []ByteRange{{Start: 2000, End: 4000}, {Start: 6000, End: 8000}}, idx.Ranges(0, 10000, 20000, testCRAMSize))
+	assert.Equal(t, []ByteRange{{Start: 1000, End: 4000}, {Start: 6000, End: 8000}}, idx.Ranges(0, 0, 0, testCRAMSize))
+	assert.Equal(t, []ByteRange{{Start: 6000, End: 8000}}, idx.Ranges(1, 20000, 0, testCRAMSize))
+
+	// The last container ends at the end of file container
+	assert.Equal(t, []ByteRange{{Start: 8000, End: 9000}}, idx.UnplacedRanges(testCRAMSize))
+}
+
+func TestReadCRAI_invalid(t *testing.T) {
+	_, err := ReadCRAI(bytes.NewReader(gzipped(t, []byte("0\t1\t10000\n"))))
+	assert.ErrorContains(t, err, "invalid CRAI line")
+
+	_, err = ReadCRAI(bytes.NewReader([]byte("not gzip")))
+	assert.Error(t, err)
+}
@@ -0,0 +1,141 @@
+// Package htsget computes the parts of archived BAM, CRAM and VCF files that
+// hold the records of a genomic region, using the index files stored with them.
+package htsget
+
+import (
+	"errors"
+	"sort"
+)
+
+// Formats served by htsget.
+const (
+	FormatBAM  = "BAM"
+	FormatCRAM = "CRAM"
+	FormatVCF  = "VCF"
+)
+
+// Crypt4gh data segment sizes, every encrypted segment holds a nonce and a MAC besides its data.
+const (
+	segmentSize       = 65536
+	cipherSegmentSize = segmentSize + 12 + 16
+)
+
+// ErrUnsupported indicates that a file or index uses a format version that is not supported.
+var ErrUnsupported = errors.New("unsupported file format")
+
+// ByteRange is a half-open byte range [Start, End) of a file.
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
+// Index locates the records of a region in the decrypted file it indexes.
+type Index interface {
+	// HeaderRange returns the range of the file header.
+	HeaderRange(fileSize int64) ByteRange
+	// Ranges returns the ranges holding the records of the reference which overlap [start, end).
+	Ranges(refID int, start, end, fileSize int64) []ByteRange
+	// UnplacedRanges returns the ranges holding the records without a position.
+	UnplacedRanges(fileSize int64) []ByteRange
+	// EOFRange returns the range of the end of file marker.
+	EOFRange(fileSize int64) ByteRange
+}
+
+// Query returns the ranges of the decrypted file which together make up a
+// valid file, holding the header and the records of the region. A refID of -1
+// selects the unplaced records, an end of 0 the rest of the reference.
+func Query(idx Index, refID int, start, end int64, headerOnly bool, fileSize int64) []ByteRange {
+	ranges := []ByteRange{idx.HeaderRange(fileSize)}
+	switch {
+	case headerOnly:
+	case refID < 0:
+		ranges = append(ranges, idx.UnplacedRanges(fileSize)...)
+	default:
+		ranges = append(ranges, idx.Ranges(refID, start, end, fileSize)...)
+	}
+	ranges = append(ranges, idx.EOFRange(fileSize))
+
+	return Merge(ranges)
+}
+
+// Merge sorts the ranges and joins the ranges which overlap or are adjacent,
+// empty ranges are dropped.
+func Merge(ranges []ByteRange) []ByteRange {
+	sorted := make([]ByteRange, 0, len(ranges))
+	for _, r := range ranges {
+		if r.End > r.Start {
+			sorted = append(sorted, r)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+
+	var merged []ByteRange
+	for _, r := range sorted {
+		if n := len(merged); n > 0 && r.Start <= merged[n-1].End {
+			merged[n-1].End = max(merged[n-1].End, r.End)
+
+			continue
+		}
+		merged = append(merged, r)
+	}
+
+	return merged
+}
+
+// Crypt4GHRanges maps the ranges of the decrypted file to the ranges of the
+// encrypted data segments holding them, and returns the data edit list which
+// selects the decrypted ranges from those segments. The edit list is nil when
+// the ranges cover the whole file.
+func Crypt4GHRanges(ranges []ByteRange, decryptedSize, archiveSize int64) ([]ByteRange, []uint64) {
+	ranges = Merge(ranges)
+	if len(ranges) == 1 && ranges[0].Start == 0 && ranges[0].End >= decryptedSize {
+		return []ByteRange{{Start: 0, End: archiveSize}}, nil
+	}
+
+	// The segments which are sent, and their position in the sent data
+	position := make(map[int64]int64)
+	var segments []int64
+	for _, r := range ranges {
+		for s := r.Start / segmentSize; s <= (r.End-1)/segmentSize; s++ {
+			if _, ok := position[s]; !ok {
+				position[s] = int64(len(segments))
+				segments = append(segments, s)
+			}
+		}
+	}
+	sentOffset := func(offset int64) int64 {
+		return position[offset/segmentSize]*segmentSize + offset%segmentSize
+	}
+
+	var editList []uint64
+	var cursor int64
+	for _, r := range ranges {
+		start, end := sentOffset(r.Start), sentOffset(r.End-1)+1
+		editList = append(editList, uint64(start-cursor), uint64(end-start)) // #nosec G115 -- offsets are increasing
+		cursor = end
+	}
+
+	var encrypted []ByteRange
+	for _, s := range segments {
+		r := ByteRange{Start: s * cipherSegmentSize, End: min((s+1)*cipherSegmentSize, archiveSize)}
+		if n := len(encrypted); n > 0 && encrypted[n-1].End == r.Start {
+			encrypted[n-1].End = r.End
+
+			continue
+		}
+		encrypted = append(encrypted, r)
+	}
+
+	return encrypted, editList
+}
+
+// DecryptedSize returns the size of the decrypted data of an encrypted file
+// body of the given size.
+func DecryptedSize(archiveSize int64) int64 {
+	size := archiveSize / cipherSegmentSize * segmentSize
+	if rest := archiveSize % cipherSegmentSize; rest > cipherSegmentSize-segmentSize {
+		size += rest - (cipherSegmentSize - segmentSize)
+	}
+
+	return size
+}
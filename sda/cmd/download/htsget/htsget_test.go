@@ -0,0 +1,119 @@
+package htsget
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/neicnordic/crypt4gh/keys"
+	"github.com/neicnordic/crypt4gh/model/headers"
+	"github.com/neicnordic/crypt4gh/streaming"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerge(t *testing.T) {
+	merged := Merge([]ByteRange{{Start: 50, End: 60}, {Start: 0, End: 10}, {Start: 10, End: 20}, {Start: 55, End: 70}, {Start: 30, End: 30}})
+	assert.Equal(t, []ByteRange{{Start: 0, End: 20}, {Start: 50, End: 70}}, merged)
+
+	assert.Empty(t, Merge(nil))
+}
+
+func TestDecryptedSize(t *testing.T) {
+	assert.Equal(t, int64(0), DecryptedSize(0))
+	assert.Equal(t, int64(100), DecryptedSize(128))
+	assert.Equal(t, int64(segmentSize), DecryptedSize(cipherSegmentSize))
+	assert.Equal(t, int64(2*segmentSize+100), DecryptedSize(2*cipherSegmentSize+128))
+}
+
+func TestCrypt4GHRanges_wholeFile(t *testing.T) {
+	encrypted, editList := Crypt4GHRanges([]ByteRange{{Start: 0, End: 1000}}, 1000, 1028)
+	assert.Equal(t, []ByteRange{{Start: 0, End: 1028}}, encrypted)
+	assert.Nil(t, editList)
+}
+
+func TestCrypt4GHRanges(t *testing.T) {
+	decryptedSize := int64(5*segmentSize + 1000)
+	archiveSize := int64(5*cipherSegmentSize + 1028)
+
+	ranges := []ByteRange{
+		{Start: 0, End: 100},
+		{Start: segmentSize + 10, End: 2*segmentSize + 20},
+		{Start: decryptedSize - 28, End: decryptedSize},
+	}
+	encrypted, editList := Crypt4GHRanges(ranges, decryptedSize, archiveSize)
+
+	// Segments 0 to 2 and the last segment are sent
+	assert.Equal(t, []ByteRange{{Start: 0, End: 3 * cipherSegmentSize}, {Start: 5 * cipherSegmentSize, End: archiveSize}}, encrypted)
+	assert.Equal(t, []uint64{0, 100, segmentSize + 10 - 100, segmentSize + 10, segmentSize - 20 + 1000 - 28, 28}, editList)
+}
+
+// TestCrypt4GHRanges_decrypt checks that the sent segments, decrypted with the
+// data edit list, are exactly the requested ranges.
+func TestCrypt4GHRanges_decrypt(t *testing.T) {
+	archivePublicKey, archivePrivateKey, err := keys.GenerateKeyPair()
+	require.NoError(t, err)
+	clientPublicKey, clientPrivateKey, err := keys.GenerateKeyPair()
+	require.NoError(t, err)
+
+	plaintext := make([]byte, 3*segmentSize+5000)
+	_, err = rand.Read(plaintext)
+	require.NoError(t, err)
+
+	encryptedFile := new(bytes.Buffer)
+	writer, err := streaming.NewCrypt4GHWriterWithoutPrivateKey(encryptedFile, [][32]byte{archivePublicKey}, nil)
+	require.NoError(t, err)
+	_, err = writer.Write(plaintext)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	header, err := headers.ReadHeader(bytes.NewReader(encryptedFile.Bytes()))
+	require.NoError(t, err)
+	body := encryptedFile.Bytes()[len(header):]
+	require.Equal(t, int64(len(plaintext)), DecryptedSize(int64(len(body))))
+
+	ranges := []ByteRange{{Start: 10, End: 200}, {Start: 2*segmentSize - 50, End: 2*segmentSize + 50}, {Start: int64(len(plaintext)) - 28, End: int64(len(plaintext))}}
+	encrypted, editList := Crypt4GHRanges(ranges, int64(len(plaintext)), int64(len(body)))
+
+	editListPacket := headers.DataEditListHeaderPacket{
+		PacketType:    headers.PacketType{PacketType: headers.DataEditList},
+		NumberLengths: uint32(len(editList)),
+		Lengths:       editList,
+	}
+	clientHeader, err := headers.ReEncryptHeader(header, archivePrivateKey, [][32]byte{clientPublicKey}, editListPacket)
+	require.NoError(t, err)
+
+	sent := bytes.NewBuffer(clientHeader)
+	for _, r := range encrypted {
+		sent.Write(body[r.Start:r.End])
+	}
+	reader, err := streaming.NewCrypt4GHReader(sent, clientPrivateKey, nil)
+	require.NoError(t, err)
+	decrypted, err := io.ReadAll(reader)
+	require.NoError(t, err)
+
+	var expected []byte
+	for _, r := range ranges {
+		expected = append(expected, plaintext[r.Start:r.End]...)
+	}
+	assert.Equal(t, expected, decrypted)
+}
+
+// mockIndex returns fixed ranges.
+type mockIndex struct{}
+
+func (mockIndex) HeaderRange(int64) ByteRange { return ByteRange{Start: 0, End: 100} }
+func (mockIndex) Ranges(refID int, _, _, _ int64) []ByteRange {
+	return []ByteRange{{Start: int64(refID) * 1000, End: int64(refID)*1000 + 500}}
+}
+func (mockIndex) UnplacedRanges(int64) []ByteRange { return []ByteRange{{Start: 5000, End: 9972}} }
+func (mockIndex) EOFRange(fileSize int64) ByteRange {
+	return ByteRange{Start: fileSize - 28, End: fileSize}
+}
+
+func TestQuery(t *testing.T) {
+	assert.Equal(t, []ByteRange{{Start: 0, End: 100}, {Start: 2000, End: 2500}, {Start: 9972, End: 10000}}, Query(mockIndex{}, 2, 0, 0, false, 10000))
+	assert.Equal(t, []ByteRange{{Start: 0, End: 100}, {Start: 9972, End: 10000}}, Query(mockIndex{}, 2, 0, 0, true, 10000))
+	assert.Equal(t, []ByteRange{{Start: 0, End: 100}, {Start: 5000, End: 10000}}, Query(mockIndex{}, -1, 0, 0, false, 10000))
+}
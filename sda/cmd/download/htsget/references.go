@@ -0,0 +1,244 @@
+package htsget
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxHeaderSize bounds the size of the file headers read for the reference names.
+const maxHeaderSize = 64 << 20
+
+// ReadBAMReferences reads the reference names from the header of a decrypted
+// BAM file, in the order of their reference IDs.
+func ReadBAMReferences(r io.Reader) ([]string, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress BAM header: %w", err)
+	}
+	br := bufio.NewReader(gz)
+	if err := readMagic(br, "BAM\x01"); err != nil {
+		return nil, err
+	}
+
+	textLength, err := readSize(br)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, br, int64(textLength)); err != nil {
+		return nil, fmt.Errorf("failed to read BAM header text: %w", err)
+	}
+
+	nRef, err := readCount(br)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, nRef)
+	for i := range names {
+		nameLength, err := readSize(br)
+		if err != nil {
+			return nil, err
+		}
+		// The name is NUL terminated and followed by the length of the reference
+		buf := make([]byte, nameLength+4)
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return nil, fmt.Errorf("failed to read BAM reference: %w", err)
+		}
+		names[i] = string(bytes.TrimRight(buf[:nameLength], "\x00"))
+	}
+
+	return names, nil
+}
+
+// ReadCRAMReferences reads the reference names from the @SQ lines of the SAM
+// header stored in the header container of a decrypted CRAM 3 file.
+func ReadCRAMReferences(r io.Reader) ([]string, error) {
+	br := bufio.NewReader(r)
+
+	definition := make([]byte, 26)
+	if _, err := io.ReadFull(br, definition); err != nil {
+		return nil, fmt.Errorf("failed to read CRAM file definition: %w", err)
+	}
+	if string(definition[:4]) != "CRAM" {
+		return nil, fmt.Errorf("%w: not a CRAM file", ErrUnsupported)
+	}
+	if definition[4] != 3 {
+		return nil, fmt.Errorf("%w: CRAM version %d.%d", ErrUnsupported, definition[4], definition[5])
+	}
+
+	// The container header: length, reference ID, start, span, number of
+	// records, record counter, bases, number of blocks, landmarks and CRC32
+	if _, err := io.CopyN(io.Discard, br, 4); err != nil {
+		return nil, err
+	}
+	for range 4 {
+		if _, err := readITF8(br); err != nil {
+			return nil, err
+		}
+	}
+	for range 2 {
+		if err := skipLTF8(br); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := readITF8(br); err != nil {
+		return nil, err
+	}
+	nLandmarks, err := readITF8(br)
+	if err != nil {
+		return nil, err
+	}
+	for range nLandmarks {
+		if _, err := readITF8(br); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := io.CopyN(io.Discard, br, 4); err != nil {
+		return nil, err
+	}
+
+	// The first block holds the SAM header: compression method, content
+	// type, content ID, size and raw size
+	method, err := br.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := br.ReadByte(); err != nil {
+		return nil, err
+	}
+	if _, err := readITF8(br); err != nil {
+		return nil, err
+	}
+	size, err := readITF8(br)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := readITF8(br); err != nil {
+		return nil, err
+	}
+	if size < 0 || size > maxHeaderSize {
+		return nil, fmt.Errorf("invalid CRAM header block size: %d", size)
+	}
+
+	var block io.Reader = io.LimitReader(br, int64(size))
+	switch method {
+	case 0:
+	case 1:
+		if block, err = gzip.NewReader(block); err != nil {
+			return nil, fmt.Errorf("failed to decompress CRAM header: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("%w: CRAM header compression method %d", ErrUnsupported, method)
+	}
+
+	textLength, err := readSize(block)
+	if err != nil {
+		return nil, err
+	}
+	text := make([]byte, textLength)
+	if _, err := io.ReadFull(block, text); err != nil {
+		return nil, fmt.Errorf("failed to read CRAM header text: %w", err)
+	}
+
+	var names []string
+	for _, line := range strings.Split(string(text), "\n") {
+		if !strings.HasPrefix(line, "@SQ\t") {
+			continue
+		}
+		for _, field := range strings.Split(line, "\t")[1:] {
+			if name, ok := strings.CutPrefix(field, "SN:"); ok {
+				names = append(names, name)
+
+				break
+			}
+		}
+	}
+
+	return names, nil
+}
+
+// readSize reads a little endian int32 length, bounded by maxHeaderSize.
+func readSize(r io.Reader) (int, error) {
+	var n int32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return 0, err
+	}
+	if n < 0 || n > maxHeaderSize {
+		return 0, fmt.Errorf("invalid length: %d", n)
+	}
+
+	return int(n), nil
+}
+
+// readITF8 reads a CRAM ITF8 integer, whose leading one bits in the first
+// byte give the number of bytes that follow.
+func readITF8(r io.ByteReader) (int32, error) {
+	b0, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	var extra int
+	var value uint32
+	switch {
+	case b0&0x80 == 0:
+		return int32(b0), nil
+	case b0&0x40 == 0:
+		extra, value = 1, uint32(b0&0x3f)
+	case b0&0x20 == 0:
+		extra, value = 2, uint32(b0&0x1f)
+	case b0&0x10 == 0:
+		extra, value = 3, uint32(b0&0x0f)
+	default:
+		// Five bytes, of which only the lower four bits of the last are used
+		value = uint32(b0 & 0x0f)
+		for range 3 {
+			b, err := r.ReadByte()
+			if err != nil {
+				return 0, err
+			}
+			value = value<<8 | uint32(b)
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+
+		return int32(value<<4 | uint32(b&0x0f)), nil // #nosec G115 -- ITF8 is a 32 bit integer
+	}
+
+	for range extra {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		value = value<<8 | uint32(b)
+	}
+
+	return int32(value), nil // #nosec G115 -- ITF8 is a 32 bit integer
+}
+
+// skipLTF8 skips a CRAM LTF8 integer, whose leading one bits in the first byte
+// give the number of bytes that follow.
+func skipLTF8(r io.ByteReader) error {
+	b0, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	extra := 0
+	for mask := byte(0x80); mask != 0 && b0&mask != 0; mask >>= 1 {
+		extra++
+	}
+	for range extra {
+		if _, err := r.ReadByte(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package htsget
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSAMHeader = "@HD\tVN:1.6\tSO:coordinate\n@SQ\tSN:chr1\tLN:248956422\n@SQ\tLN:242193529\tSN:chr2\n@RG\tID:sample\n"
+
+func TestReadBAMReferences(t *testing.T) {
+	buf := bytes.NewBufferString("BAM\x01")
+	writeLE(buf, int32(len(testSAMHeader)), []byte(testSAMHeader), int32(2))
+	writeLE(buf, int32(5), []byte("chr1\x00"), int32(248956422))
+	writeLE(buf, int32(5), []byte("chr2\x00"), int32(242193529))
+	// Records follow the header
+	buf.WriteString("records")
+
+	names, err := ReadBAMReferences(bytes.NewReader(gzipped(t, buf.Bytes())))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chr1", "chr2"}, names)
+
+	_, err = ReadBAMReferences(bytes.NewReader(gzipped(t, []byte("CRAM"))))
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
+
+// itf8 encodes values below 2^14.
+func itf8(v int) []byte {
+	if v < 0x80 {
+		return []byte{byte(v)}
+	}
+
+	return []byte{0x80 | byte(v>>8), byte(v)}
+}
+
+func testCRAMHeader(version byte, method byte, data []byte) []byte {
+	buf := bytes.NewBufferString("CRAM")
+	buf.Write([]byte{version, 0})
+	buf.Write(make([]byte, 20))
+
+	// Container header with a single landmark
+	writeLE(buf, int32(100))
+	buf.Write([]byte{0, 0, 0, 0, 0, 0, 1, 1})
+	buf.Write(itf8(200))
+	buf.Write([]byte{0, 0, 0, 0})
+
+	// Block header
+	buf.Write([]byte{method, 0, 0})
+	buf.Write(itf8(len(data)))
+	buf.Write(itf8(len(data)))
+	buf.Write(data)
+
+	return buf.Bytes()
+}
+
+func TestReadCRAMReferences(t *testing.T) {
+	block := new(bytes.Buffer)
+	writeLE(block, int32(len(testSAMHeader)), []byte(testSAMHeader))
+
+	names, err := ReadCRAMReferences(bytes.NewReader(testCRAMHeader(3, 0, block.Bytes())))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chr1", "chr2"}, names)
+
+	names, err = ReadCRAMReferences(bytes.NewReader(testCRAMHeader(3, 1, gzipped(t, block.Bytes()))))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chr1", "chr2"}, names)
+
+	_, err = ReadCRAMReferences(bytes.NewReader(testCRAMHeader(2, 0, block.Bytes())))
+	assert.ErrorIs(t, err, ErrUnsupported)
+	_, err = ReadCRAMReferences(bytes.NewReader(testCRAMHeader(3, 4, block.Bytes())))
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
+
+func TestReadITF8(t *testing.T) {
+	for _, tc := range []struct {
+		encoded []byte
+		value   int32
+	}{
+		{[]byte{0x05}, 5},
+		{[]byte{0x81, 0x00}, 256},
+		{[]byte{0xc1, 0x00, 0x00}, 65536},
+		{[]byte{0xe1, 0x00, 0x00, 0x00}, 1 << 24},
+		{[]byte{0xff, 0xff, 0xff, 0xff, 0x0f}, -1},
+	} {
+		value, err := readITF8(bytes.NewReader(tc.encoded))
+		require.NoError(t, err)
+		assert.Equal(t, tc.value, value)
+	}
+}
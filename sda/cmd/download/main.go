@@ -170,9 +170,10 @@ func run() error {
 	if log.IsLevelEnabled(log.InfoLevel) {
 		router.Use(gin.LoggerWithConfig(gin.LoggerConfig{
 			Formatter: func(params gin.LogFormatterParams) string {
+				// The query is left out, signed URLs carry their signature in it
 				return fmt.Sprintf(`{"level":"info","method":"%s","path":"%s","status":%d,"latency":"%v","client_ip":"%s","time":"%s"}`+"\n",
 					params.Method,
-					params.Path,
+					params.Request.URL.Path,
 					params.StatusCode,
 					params.Latency,
 					params.ClientIP,
@@ -195,6 +196,13 @@ func run() error {
 	if visaValidator != nil {
 		handlerOpts = append(handlerOpts, handlers.WithVisaValidator(visaValidator))
 	}
+	if secret := config.SignedURLHMACSecret(); secret != "" {
+		if config.SignedURLTTL() <= 0 {
+			return errors.New("signed-url.ttl must be positive")
+		}
+		handlerOpts = append(handlerOpts, handlers.WithSignedURLs([]byte(secret), time.Duration(config.SignedURLTTL())*time.Second))
+		log.Info("signed URLs enabled")
+	}
 
 	h, err := handlers.New(handlerOpts...)
 	if err != nil {
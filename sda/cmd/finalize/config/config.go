@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+
+	config "github.com/neicnordic/sensitive-data-archive/internal/config/v2"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var (
+	sourceQueue    string
+	completedQueue string
+	schemaPath     string
+)
+
+func init() {
+	config.RegisterFlags(
+		&config.Flag{
+			Name: "sourceQueue",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "accession", "The queue where the finalize service consumes accession messages from")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				sourceQueue = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "completedQueue",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "completed", "The queue where the finalize service publishes completed messages to")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				completedQueue = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "schemaType",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "isolated", "Path to JSON schemas to validate rabbitmq messages against")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				schemaType := viper.GetString("schemaType")
+				switch schemaType {
+				case "federated":
+					schemaPath = "/schemas/federated/"
+				case "isolated":
+					schemaPath = "/schemas/isolated/"
+				default:
+					panic(fmt.Sprintf("schema.type '%s' not supported, needs: <federated|isolated>", schemaType))
+				}
+			},
+		},
+	)
+}
+
+func SourceQueue() string {
+	return sourceQueue
+}
+
+func CompletedQueue() string {
+	return completedQueue
+}
+
+func SchemaPath() string {
+	return schemaPath
+}
+
+func SetSchemaPath(path string) {
+	schemaPath = path
+}
@@ -12,8 +12,9 @@ import (
 	"os/signal"
 	"syscall"
 
-	"github.com/neicnordic/sensitive-data-archive/internal/broker"
-	"github.com/neicnordic/sensitive-data-archive/internal/config"
+	finalizeconf "github.com/neicnordic/sensitive-data-archive/cmd/finalize/config"
+	brokerv2 "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/broker/v2/factory"
 	configv2 "github.com/neicnordic/sensitive-data-archive/internal/config/v2"
 	"github.com/neicnordic/sensitive-data-archive/internal/database"
 	"github.com/neicnordic/sensitive-data-archive/internal/database/postgres"
@@ -21,169 +22,129 @@ import (
 	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2"
 	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/locationbroker"
 	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
-
-	amqp "github.com/rabbitmq/amqp091-go"
 	log "github.com/sirupsen/logrus"
 )
 
-var db database.Database
-var mqBroker *broker.AMQPBroker
-var archiveReader storage.Reader
-var backupWriter storage.Writer
-
-var backupInStorage bool
+type Finalize struct {
+	ArchiveReader storage.Reader
+	BackupWriter  storage.Writer
+	Broker        brokerv2.Broker
+	db            database.Database
+}
 
 func main() {
 	if err := run(); err != nil {
 		log.Fatal(err)
 	}
 }
+
 func run() error {
+	var err error
+	app := Finalize{}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	if err := configv2.Load(); err != nil {
+	if err = configv2.Load(); err != nil {
 		return fmt.Errorf("failed to load config: %v", err)
 	}
 
-	conf, err := config.NewConfig("finalize")
+	app.Broker, err = factory.NewBroker(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to load config, due to: %v", err)
+		return fmt.Errorf("failed to initialize mq broker, due to: %v", err)
 	}
-	db, err = postgres.NewPostgresSQLDatabase()
+	defer func() {
+		if err := app.Broker.Close(); err != nil {
+			log.Errorf("could not close Broker, due to: %v", err)
+		}
+	}()
+
+	app.db, err = postgres.NewPostgresSQLDatabase()
 	if err != nil {
 		return fmt.Errorf("failed to initialize sda db, due to: %v", err)
 	}
-	defer db.Close()
-
-	if dbSchemaVersion, err := db.SchemaVersion(); err != nil || dbSchemaVersion < 23 {
+	defer app.db.Close()
+	if dbSchemaVersion, err := app.db.SchemaVersion(); err != nil || dbSchemaVersion < 23 {
 		return errors.Join(errors.New("database schema v23 is required"), err)
 	}
 
-	mqBroker, err = broker.NewMQ(conf.Broker)
-	if err != nil {
-		return fmt.Errorf("failed to initialize mq broker, due to: %v", err)
-	}
-	defer func() {
-		if mqBroker == nil {
-			return
-		}
-		if mqBroker.Channel != nil {
-			if err := mqBroker.Channel.Close(); err != nil {
-				log.Errorf("failed to close mq broker channel due to: %v", err)
-			}
-		}
-		if mqBroker.Connection != nil {
-			if err := mqBroker.Connection.Close(); err != nil {
-				log.Errorf("failed to close mq broker connection due to: %v", err)
-			}
-		}
-	}()
-
-	lb, err := locationbroker.NewLocationBroker(db)
+	lb, err := locationbroker.NewLocationBroker(app.db)
 	if err != nil {
 		return fmt.Errorf("failed to init new location broker, due to: %v", err)
 	}
-	backupWriter, err = storage.NewWriter(ctx, "backup", lb)
+	backupWriter, err := storage.NewWriter(ctx, "backup", lb)
 	if err != nil && !errors.Is(err, storageerrors.ErrorNoValidWriter) {
 		return fmt.Errorf("failed to initialize backup writer, due to: %v", err)
 	}
-	archiveReader, err = storage.NewReader(ctx, "archive")
+	archiveReader, err := storage.NewReader(ctx, "archive")
 	if err != nil && !errors.Is(err, storageerrors.ErrorNoValidReader) {
 		return fmt.Errorf("failed to initialize archive reader: %v", err)
 	}
 
 	if archiveReader != nil && backupWriter != nil {
-		backupInStorage = true
+		app.ArchiveReader = archiveReader
+		app.BackupWriter = backupWriter
 	} else {
 		log.Warn("archive or backup destination not configured, backup will not be performed.")
 	}
-
 	log.Info("Starting finalize service")
-	consumeErr := make(chan error, 1)
-	go func() {
-		consumeErr <- startConsumer(ctx)
-	}()
 
 	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc, os.Interrupt, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 
+	consumeErr := make(chan error, 1)
+	go func() {
+		consumeErr <- app.Broker.Subscribe(ctx, finalizeconf.SourceQueue(), app.handleMessage)
+	}()
+
 	select {
-	case <-sigc:
-	case err := <-mqBroker.Connection.NotifyClose(make(chan *amqp.Error)):
-		return err
-	case err := <-mqBroker.Channel.NotifyClose(make(chan *amqp.Error)):
-		return err
+	case sig := <-sigc:
+		log.Infof("recieved signal: %v, shutting down gracefully", sig)
+		cancel()
+
+		return nil
 	case err := <-consumeErr:
-		return err
-	}
+		if !errors.Is(err, context.Canceled) {
+			log.Errorf("failed to consume from %s, due to: %v", finalizeconf.SourceQueue(), err)
+			cancel()
 
-	return nil
-}
-func startConsumer(ctx context.Context) error {
-	messages, err := mqBroker.GetMessages(mqBroker.Conf.Queue)
-	if err != nil {
-		return err
-	}
-	for delivered := range messages {
-		handleMessage(ctx, delivered)
-	}
+			return err
+		}
 
-	return nil
+		return nil
+	}
 }
 
-func handleMessage(ctx context.Context, delivered amqp.Delivery) {
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-
-	log.Debugf("Received a message (correlation-id: %s, message: %s)", delivered.CorrelationId, delivered.Body)
-	if err := schema.ValidateJSON(fmt.Sprintf("%s/ingestion-accession.json", mqBroker.Conf.SchemasPath), delivered.Body); err != nil {
-		log.Errorf("validation of incoming message (ingestion-accession) failed, correlation-id: %s, reason: %v ", delivered.CorrelationId, err)
-		if err := delivered.Ack(false); err != nil {
-			log.Errorf("Failed acking canceled work, reason: %v", err)
-		}
+func (app *Finalize) handleMessage(ctx context.Context, delivered *brokerv2.Message) ([]func(), error) {
+	log.Debugf("Received a message (correlation-id: %s, message: %s)", delivered.Key, delivered.Body)
+	if err := schema.ValidateJSON(fmt.Sprintf("%s/ingestion-accession.json", finalizeconf.SchemaPath()), delivered.Body); err != nil {
+		log.Errorf("validation of incoming message (ingestion-accession) failed, correlation-id: %s, reason: %v ", delivered.Key, err)
 
-		return
+		return []func(){brokerv2.ErrorQueueCallback(app.Broker, delivered, "Message validation failed", err)}, nil
 	}
 
-	fileID := delivered.CorrelationId
+	fileID := delivered.Key
 	var message schema.IngestionAccession
 	// we unmarshal the message in the validation step so this is safe to do
 	_ = json.Unmarshal(delivered.Body, &message)
 	// If the file has been canceled by the uploader, don't spend time working on it.
-	status, err := db.GetFileStatus(ctx, fileID)
+	status, err := app.db.GetFileStatus(ctx, fileID)
 	if err != nil {
-		log.Errorf("failed to get file status, file-id: %s, reason: %v", fileID, err)
-		if err := delivered.Nack(false, true); err != nil {
-			log.Errorf("failed to Nack message, reason: %v", err)
-		}
-
-		return
+		return nil, fmt.Errorf("failed to get file status, file-id: %s, reason: %v", fileID, err)
 	}
 
 	switch status {
 	case "disabled":
 		log.Infof("file with file-id: %s is disabled, aborting work", fileID)
-		if err := delivered.Ack(false); err != nil {
-			log.Errorf("Failed acking canceled work, reason: %v", err)
-		}
 
-		return
+		return nil, nil
 	case "verified", "enabled":
 	case "ready":
 		log.Infof("File with file-id: %s is already marked as ready.", fileID)
-		if err := delivered.Ack(false); err != nil {
-			log.Errorf("Failed acking message, reason: %v", err)
-		}
 
-		return
+		return nil, nil
 	default:
-		log.Warnf("file with file-id: %s is not verified yet, aborting work", fileID)
-		if err := delivered.Nack(false, true); err != nil {
-			log.Errorf("Failed acking canceled work, reason: %v", err)
-		}
-
-		return
+		return nil, fmt.Errorf("file with file-id: %s is not verified yet, status: %s", fileID, status)
 	}
 
 	c := schema.IngestionCompletion{
@@ -194,96 +155,59 @@ func handleMessage(ctx context.Context, delivered amqp.Delivery) {
 	}
 	completeMsg, _ := json.Marshal(&c)
 
-	if err = schema.ValidateJSON(fmt.Sprintf("%s/ingestion-completion.json", mqBroker.Conf.SchemasPath), completeMsg); err != nil {
-		log.Errorf("Validation of outgoing message ingestion-completion failed, reason: (%v). Message body: %s\n", err, string(completeMsg))
+	if err = schema.ValidateJSON(fmt.Sprintf("%s/ingestion-completion.json", finalizeconf.SchemaPath()), completeMsg); err != nil {
+		log.Errorf("Validation of outgoing message ingestion-completion failed, reason: (%v). Message body: %s", err, string(completeMsg))
 
-		return
+		return []func(){brokerv2.ErrorQueueCallback(app.Broker, delivered, "Validation of outgoing message failed", err)}, nil
 	}
 
-	accessionIDExists, err := db.CheckAccessionIDExists(ctx, message.AccessionID, fileID)
+	accessionIDExists, err := app.db.CheckAccessionIDExists(ctx, message.AccessionID, fileID)
 	if err != nil {
-		log.Errorf("CheckAccessionIdExists failed, file-id: %s, reason: %v ", fileID, err)
-		if err := delivered.Nack(false, true); err != nil {
-			log.Errorf("failed to Nack message, reason: %v", err)
-		}
-
-		return
+		return nil, fmt.Errorf("CheckAccessionIdExists failed, file-id: %s, reason: %v", fileID, err)
 	}
 
 	switch accessionIDExists {
 	case "duplicate":
-		log.Errorf("accession ID already exists in the system, file-id: %s, accession-id: %s\n", fileID, message.AccessionID)
-		// Send the message to an error queue so it can be analyzed.
-		fileError := broker.InfoError{
-			Error:           "There is a conflict regarding the file accessionID",
-			Reason:          "The Accession ID already exists in the database, skipping marking it ready.",
-			OriginalMessage: message,
-		}
-		body, _ := json.Marshal(fileError)
+		log.Errorf("accession ID already exists in the system, file-id: %s, accession-id: %s", fileID, message.AccessionID)
 
 		// Send the message to an error queue so it can be analyzed.
-		if err := mqBroker.SendMessage(fileID, mqBroker.Conf.Exchange, "error", body); err != nil {
-			log.Errorf("failed to publish message, reason: %v", err)
-		}
-
-		if err := delivered.Ack(false); err != nil {
-			log.Errorf("failed to Ack message, reason: %v", err)
-		}
-
-		return
+		return []func(){brokerv2.ErrorQueueCallback(
+			app.Broker,
+			delivered,
+			"There is a conflict regarding the file accessionID",
+			errors.New("the Accession ID already exists in the database, skipping marking it ready"),
+		)}, nil
 	case "same":
 		log.Infof("file already has an accession ID, marking it as ready, file-id: %s", fileID)
 	default:
-		if backupInStorage {
-			if err = backupFile(ctx, delivered); err != nil {
-				log.Errorf("failed to backup file, file-id: %s, reason: %v", fileID, err)
-				if err := delivered.Nack(false, true); err != nil {
-					log.Errorf("failed to Nack message, reason: %v", err)
-				}
-
-				return
+		if app.BackupWriter != nil {
+			if err = app.backupFile(ctx, delivered); err != nil {
+				return nil, fmt.Errorf("failed to backup file, file-id: %s, reason: %v", fileID, err)
 			}
 		}
 
-		if err := db.SetAccessionID(ctx, message.AccessionID, fileID); err != nil {
-			log.Errorf("failed to set accessionID for file, file-id: %s, reason: %v", fileID, err)
-			if err := delivered.Nack(false, true); err != nil {
-				log.Errorf("failed to Nack message, reason: %v", err)
-			}
-
-			return
+		if err := app.db.SetAccessionID(ctx, message.AccessionID, fileID); err != nil {
+			return nil, fmt.Errorf("failed to set accessionID for file, file-id: %s, reason: %v", fileID, err)
 		}
 	}
 
 	// Mark file as "ready"
-	if err := db.UpdateFileEventLog(ctx, fileID, "ready", "finalize", "{}", string(delivered.Body)); err != nil {
-		log.Errorf("set status ready failed, file-id: %s, reason: %v", fileID, err)
-		if err := delivered.Nack(false, true); err != nil {
-			log.Errorf("failed to Nack message, reason: %v", err)
-		}
-
-		return
+	if err := app.db.UpdateFileEventLog(ctx, fileID, "ready", "finalize", "{}", string(delivered.Body)); err != nil {
+		return nil, fmt.Errorf("set status ready failed, file-id: %s, reason: %v", fileID, err)
 	}
 
-	if err := mqBroker.SendMessage(fileID, mqBroker.Conf.Exchange, mqBroker.Conf.RoutingKey, completeMsg); err != nil {
-		log.Errorf("failed to publish message, reason: %v", err)
-		if err := delivered.Nack(false, true); err != nil {
-			log.Errorf("failed to Nack message, reason: %v", err)
-		}
-
-		return
+	if err := app.Broker.Publish(ctx, finalizeconf.CompletedQueue(), brokerv2.Message{Key: fileID, Body: completeMsg}); err != nil {
+		return nil, fmt.Errorf("failed to publish message, reason: %v", err)
 	}
 
-	if err := delivered.Ack(false); err != nil {
-		log.Errorf("failed to Ack message, reason: %v", err)
-	}
+	return nil, nil
 }
 
-func backupFile(ctx context.Context, delivered amqp.Delivery) error {
+func (app *Finalize) backupFile(ctx context.Context, delivered *brokerv2.Message) error {
 	log.Debug("Backup initiated")
-	fileID := delivered.CorrelationId
+	fileID := delivered.Key
 
-	archiveData, err := db.GetArchived(ctx, fileID)
+	archiveData, err := app.db.GetArchived(ctx, fileID)
 	if err != nil {
 		return fmt.Errorf("failed to get file archive information, reason: %v", err)
 	}
@@ -292,8 +216,15 @@ func backupFile(ctx context.Context, delivered amqp.Delivery) error {
 		return fmt.Errorf("file archive data not found in database, file-id: %s", fileID)
 	}
 
+	// Files replicated to the backup storage by ingest only need to be marked as backed up
+	if archiveData.BackupLocation != "" {
+		log.Infof("file: %s is already backed up at location: %s, path: %s", fileID, archiveData.BackupLocation, archiveData.BackupFilePath)
+
+		return app.setBackedUpEvent(ctx, delivered)
+	}
+
 	// Get size on disk, will also give some time for the file to appear if it has not already
-	diskFileSize, err := archiveReader.GetFileSize(ctx, archiveData.Location, archiveData.FilePath)
+	diskFileSize, err := app.ArchiveReader.GetFileSize(ctx, archiveData.Location, archiveData.FilePath)
 	if err != nil {
 		return fmt.Errorf("failed to get size info for archived file, reason: %v", err)
 	}
@@ -302,7 +233,7 @@ func backupFile(ctx context.Context, delivered amqp.Delivery) error {
 		return fmt.Errorf("archive file size does not match registered file size, (disk size: %d, db size: %d)", diskFileSize, archiveData.FileSize)
 	}
 
-	file, err := archiveReader.NewFileReader(ctx, archiveData.Location, archiveData.FilePath)
+	file, err := app.ArchiveReader.NewFileReader(ctx, archiveData.Location, archiveData.FilePath)
 	if err != nil {
 		return fmt.Errorf("failed to open archived file, reason: %v", err)
 	}
@@ -323,7 +254,7 @@ func backupFile(ctx context.Context, delivered amqp.Delivery) error {
 		}
 	}()
 
-	backupLocation, err := backupWriter.WriteFile(ctx, archiveData.FilePath, contentReader)
+	backupLocation, err := app.BackupWriter.WriteFile(ctx, archiveData.FilePath, contentReader)
 	if err != nil {
 		_ = contentReader.Close()
 
@@ -332,15 +263,23 @@ func backupFile(ctx context.Context, delivered amqp.Delivery) error {
 	_ = contentReader.Close()
 
 	// Mark file as "backed up" and populate backup path and location
-	if err := db.SetBackedUp(ctx, backupLocation, archiveData.FilePath, fileID); err != nil {
+	if err := app.db.SetBackedUp(ctx, backupLocation, archiveData.FilePath, fileID); err != nil {
 		return fmt.Errorf("SetBackedUp failed, reason: (%v)", err)
 	}
 
-	if err := db.UpdateFileEventLog(ctx, fileID, "backed up", "finalize", "{}", string(delivered.Body)); err != nil {
-		return fmt.Errorf("UpdateFileEventLog failed, reason: (%v)", err)
+	if err := app.setBackedUpEvent(ctx, delivered); err != nil {
+		return err
 	}
 
 	log.Debug("Backup completed")
 
 	return nil
 }
+
+func (app *Finalize) setBackedUpEvent(ctx context.Context, delivered *brokerv2.Message) error {
+	if err := app.db.UpdateFileEventLog(ctx, delivered.Key, "backed up", "finalize", "{}", string(delivered.Body)); err != nil {
+		return fmt.Errorf("UpdateFileEventLog failed, reason: (%v)", err)
+	}
+
+	return nil
+}
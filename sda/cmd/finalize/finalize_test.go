@@ -1,20 +1,256 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"testing"
 
+	finalizeconf "github.com/neicnordic/sensitive-data-archive/cmd/finalize/config"
+	brokerv2 "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/broker/v2/memory"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/suite"
 )
 
+const testFileID = "c2e3c5b0-6a9d-4d1b-9d4e-3d0c6a1e9f4b"
+
 type TestSuite struct {
 	suite.Suite
+	db     *mockDatabase
+	broker *memory.Broker
+	backup *mockWriter
+	app    Finalize
 }
 
 func TestConfigTestSuite(t *testing.T) {
 	suite.Run(t, new(TestSuite))
 }
 
+// mockDatabase implements the database functions used by finalize, calling any other function panics
+type mockDatabase struct {
+	database.Database
+	status          string
+	accessionExists string
+	accessionID     string
+	backupLocation  string
+	events          []string
+}
+
+func (m *mockDatabase) GetFileStatus(_ context.Context, _ string) (string, error) {
+	return m.status, nil
+}
+
+func (m *mockDatabase) CheckAccessionIDExists(_ context.Context, _, _ string) (string, error) {
+	return m.accessionExists, nil
+}
+
+func (m *mockDatabase) SetAccessionID(_ context.Context, accessionID, _ string) error {
+	m.accessionID = accessionID
+
+	return nil
+}
+
+func (m *mockDatabase) GetArchived(_ context.Context, _ string) (*database.ArchiveData, error) {
+	archiveData := &database.ArchiveData{FilePath: "file-path", Location: "/archive", FileSize: 7}
+	if m.backupLocation != "" {
+		archiveData.BackupLocation, archiveData.BackupFilePath = m.backupLocation, "file-path"
+	}
+
+	return archiveData, nil
+}
+
+func (m *mockDatabase) SetBackedUp(_ context.Context, location, _, _ string) error {
+	m.backupLocation = location
+
+	return nil
+}
+
+// UpdateFileEventLog logs the event, which becomes the status of the file like in the database
+func (m *mockDatabase) UpdateFileEventLog(_ context.Context, _, event, _, _, _ string) error {
+	m.events = append(m.events, event)
+	m.status = event
+
+	return nil
+}
+
+type mockReader struct {
+	files map[string][]byte
+}
+
+func (r *mockReader) NewFileReader(_ context.Context, location, filePath string) (io.ReadCloser, error) {
+	content, ok := r.files[location+"/"+filePath]
+	if !ok {
+		return nil, storageerrors.ErrorFileNotFoundInLocation
+	}
+
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+func (r *mockReader) NewFileReadSeeker(_ context.Context, _, _ string) (io.ReadSeekCloser, error) {
+	return nil, errors.New("not implemented")
+}
+func (r *mockReader) FindFile(_ context.Context, _ string) (string, error) {
+	return "", errors.New("not implemented")
+}
+func (r *mockReader) GetFileSize(_ context.Context, location, filePath string) (int64, error) {
+	content, ok := r.files[location+"/"+filePath]
+	if !ok {
+		return 0, storageerrors.ErrorFileNotFoundInLocation
+	}
+
+	return int64(len(content)), nil
+}
+func (r *mockReader) Ping(_ context.Context) error { return nil }
+
+type mockWriter struct {
+	files map[string][]byte
+}
+
+func (w *mockWriter) WriteFile(_ context.Context, filePath string, fileContent io.Reader) (string, error) {
+	content, err := io.ReadAll(fileContent)
+	if err != nil {
+		return "", fmt.Errorf("failed to write file: %s, due to: %v", filePath, err)
+	}
+	w.files["/backup/"+filePath] = content
+
+	return "/backup", nil
+}
+
+func (w *mockWriter) RemoveFile(_ context.Context, location, filePath string) error {
+	delete(w.files, location+"/"+filePath)
+
+	return nil
+}
+
+func (ts *TestSuite) SetupSuite() {
+	finalizeconf.SetSchemaPath("../../schemas/isolated")
+}
+
 func (ts *TestSuite) SetupTest() {
 	viper.Set("log.level", "debug")
+
+	ts.db = &mockDatabase{status: "verified"}
+	ts.broker = memory.NewMemoryBroker()
+	ts.backup = &mockWriter{files: make(map[string][]byte)}
+	ts.app = Finalize{
+		ArchiveReader: &mockReader{files: map[string][]byte{"/archive/file-path": []byte("content")}},
+		BackupWriter:  ts.backup,
+		Broker:        ts.broker,
+		db:            ts.db,
+	}
+}
+
+func accessionMessage() *brokerv2.Message {
+	return &brokerv2.Message{
+		Key: testFileID,
+		Body: []byte(`{"type": "accession", "user": "user", "filepath": "dummy.c4gh", "accession_id": "EGAF00000000001", ` +
+			`"decrypted_checksums": [{"type": "sha256", "value": "82e4e60e7beb3db2e06a00a079788f7d71f75b61a4b75f28c4c942703dabb6d6"}]}`),
+	}
+}
+
+func runCallbacks(callbacks []func()) {
+	for _, callback := range callbacks {
+		callback()
+	}
+}
+
+func (ts *TestSuite) TestHandleMessage() {
+	callbacks, err := ts.app.handleMessage(context.TODO(), accessionMessage())
+	ts.NoError(err)
+	ts.Empty(callbacks)
+	ts.Equal("EGAF00000000001", ts.db.accessionID)
+	ts.Equal("/backup", ts.db.backupLocation)
+	ts.Equal([]byte("content"), ts.backup.files["/backup/file-path"])
+	ts.Equal([]string{"backed up", "ready"}, ts.db.events)
+	ts.Len(ts.broker.Messages(finalizeconf.CompletedQueue()), 1)
+}
+
+func (ts *TestSuite) TestHandleMessage_AfterRepair() {
+	// Repairing the archive copy of a file logs the repaired event followed by the status the file had before the
+	// repair, the same events as logged by SetRepaired in the database
+	for _, event := range []string{"repaired", "verified"} {
+		ts.NoError(ts.db.UpdateFileEventLog(context.TODO(), testFileID, event, "repair", "{}", "{}"))
+	}
+	ts.db.events = nil
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), accessionMessage())
+	ts.NoError(err)
+	ts.Empty(callbacks)
+	ts.Equal("EGAF00000000001", ts.db.accessionID)
+	ts.Equal([]string{"backed up", "ready"}, ts.db.events)
+	ts.Len(ts.broker.Messages(finalizeconf.CompletedQueue()), 1)
+}
+
+func (ts *TestSuite) TestHandleMessage_NoBackup() {
+	ts.app.ArchiveReader = nil
+	ts.app.BackupWriter = nil
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), accessionMessage())
+	ts.NoError(err)
+	ts.Empty(callbacks)
+	ts.Equal("EGAF00000000001", ts.db.accessionID)
+	ts.Equal([]string{"ready"}, ts.db.events)
+}
+
+func (ts *TestSuite) TestHandleMessage_ReplicatedByIngest() {
+	ts.db.backupLocation = "/replicated-backup"
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), accessionMessage())
+	ts.NoError(err)
+	ts.Empty(callbacks)
+	ts.Equal("EGAF00000000001", ts.db.accessionID)
+	ts.Empty(ts.backup.files, "replicated file was backed up again")
+	ts.Equal([]string{"backed up", "ready"}, ts.db.events)
+}
+
+func (ts *TestSuite) TestHandleMessage_InvalidMessage() {
+	message := accessionMessage()
+	message.Body = []byte(`{"type": "accession", "user": "user"}`)
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), message)
+	ts.NoError(err)
+	runCallbacks(callbacks)
+	ts.Len(ts.broker.Messages(brokerv2.ErrorQueue), 1)
+	ts.Empty(ts.db.accessionID)
+}
+
+func (ts *TestSuite) TestHandleMessage_NotVerified() {
+	ts.db.status = "archived"
+
+	// The message is retried until the file has been verified
+	_, err := ts.app.handleMessage(context.TODO(), accessionMessage())
+	ts.ErrorContains(err, "is not verified yet")
+	ts.Empty(ts.db.accessionID)
+}
+
+func (ts *TestSuite) TestHandleMessage_DuplicateAccessionID() {
+	ts.db.accessionExists = "duplicate"
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), accessionMessage())
+	ts.NoError(err)
+	runCallbacks(callbacks)
+	ts.Len(ts.broker.Messages(brokerv2.ErrorQueue), 1)
+	ts.Empty(ts.db.events)
+	ts.Empty(ts.broker.Messages(finalizeconf.CompletedQueue()))
+}
+
+func (ts *TestSuite) TestHandleMessage_AlreadyReady() {
+	ts.db.status = "ready"
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), accessionMessage())
+	ts.NoError(err)
+	ts.Empty(callbacks)
+	ts.Empty(ts.db.events)
+}
+
+func (ts *TestSuite) TestHandleMessage_MissingArchivedFile() {
+	ts.app.ArchiveReader = &mockReader{files: map[string][]byte{}}
+
+	_, err := ts.app.handleMessage(context.TODO(), accessionMessage())
+	ts.ErrorContains(err, "failed to backup file")
+	ts.Empty(ts.db.accessionID)
 }
@@ -0,0 +1,175 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	config "github.com/neicnordic/sensitive-data-archive/internal/config/v2"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var (
+	pollInterval     time.Duration
+	batchSize        int
+	notifyQueue      string
+	filesRetention   time.Duration
+	uploadsRetention time.Duration
+	warningPeriod    time.Duration
+)
+
+// Retention is how long files are kept in the inbox before they are removed
+type Retention struct {
+	// Files is how long files which have been uploaded but not ingested are kept, 0 keeps them forever
+	Files time.Duration
+	// Uploads is how long uploads which have been started but not completed are kept, 0 keeps them forever
+	Uploads time.Duration
+	// Warning is how long before an uploaded file is removed the user is warned, 0 removes files without warning
+	Warning time.Duration
+}
+
+// RetentionGroup applies its own retention to the users matching any of its patterns
+type RetentionGroup struct {
+	// Users are patterns, as supported by path.Match, that usernames are matched against
+	Users []string
+	Retention
+}
+
+// retentionGroupConf is a retention group as configured, retention periods which are not set fall back to the
+// default retention
+type retentionGroupConf struct {
+	Users   []string       `mapstructure:"users"`
+	Files   *time.Duration `mapstructure:"files"`
+	Uploads *time.Duration `mapstructure:"uploads"`
+	Warning *time.Duration `mapstructure:"warning"`
+}
+
+func init() {
+	config.RegisterFlags(
+		&config.Flag{
+			Name: "pollInterval",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Duration(flagName, time.Hour, "How often to look for files in the inbox which have expired. Expects a go time.Duration parsable string")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				pollInterval = viper.GetDuration(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "batchSize",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Int(flagName, 100, "Amount of files in the inbox to fetch from the database at a time")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				batchSize = viper.GetInt(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "notifyQueue",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "expiring", "The queue where the housekeeping service publishes warnings to users about files which are about to be removed from their inbox, leave empty to not warn users")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				notifyQueue = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "retention.files",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Duration(flagName, 30*24*time.Hour, "How long files which have been uploaded but not ingested are kept in the inbox, 0 keeps them forever. Expects a go time.Duration parsable string")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				filesRetention = viper.GetDuration(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "retention.uploads",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Duration(flagName, 48*time.Hour, "How long uploads which have been started but not completed are kept in the inbox, 0 keeps them forever. Expects a go time.Duration parsable string")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				uploadsRetention = viper.GetDuration(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "retention.warning",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Duration(flagName, 7*24*time.Hour, "How long before an uploaded file is removed from the inbox the user is warned, 0 removes files without warning. Expects a go time.Duration parsable string")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				warningPeriod = viper.GetDuration(flagName)
+			},
+		},
+	)
+}
+
+func PollInterval() time.Duration {
+	return pollInterval
+}
+
+func BatchSize() int {
+	return batchSize
+}
+
+func NotifyQueue() string {
+	return notifyQueue
+}
+
+// DefaultRetention returns the retention of users which are not in any retention group
+func DefaultRetention() Retention {
+	return Retention{
+		Files:   filesRetention,
+		Uploads: uploadsRetention,
+		Warning: warningPeriod,
+	}
+}
+
+// RetentionGroups returns the retention groups configured in `retention.groups`, with the retention periods which are
+// not set by a group taken from the default retention
+func RetentionGroups() ([]RetentionGroup, error) {
+	var confs []retentionGroupConf
+	if err := viper.UnmarshalKey("retention.groups", &confs); err != nil {
+		return nil, fmt.Errorf("failed to parse retention.groups, due to: %v", err)
+	}
+
+	groups := make([]RetentionGroup, 0, len(confs))
+	for i, conf := range confs {
+		if len(conf.Users) == 0 {
+			return nil, fmt.Errorf("retention group %d has no users", i)
+		}
+		for _, pattern := range conf.Users {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("retention group %d has an invalid users pattern: %s", i, pattern)
+			}
+		}
+
+		group := RetentionGroup{Users: conf.Users, Retention: DefaultRetention()}
+		if conf.Files != nil {
+			group.Files = *conf.Files
+		}
+		if conf.Uploads != nil {
+			group.Uploads = *conf.Uploads
+		}
+		if conf.Warning != nil {
+			group.Warning = *conf.Warning
+		}
+		groups = append(groups, group)
+	}
+
+	return groups, nil
+}
+
+func SetBatchSize(size int) {
+	batchSize = size
+}
+
+func SetNotifyQueue(queue string) {
+	notifyQueue = queue
+}
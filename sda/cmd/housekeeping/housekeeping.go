@@ -0,0 +1,393 @@
+// The housekeeping service periodically removes files from the inbox which
+// have been uploaded but never ingested, and aborts uploads to the inbox which
+// have been started but never completed, according to configurable retention
+// periods.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"syscall"
+	"time"
+
+	housekeepingconf "github.com/neicnordic/sensitive-data-archive/cmd/housekeeping/config"
+	brokerv2 "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/broker/v2/factory"
+	configv2 "github.com/neicnordic/sensitive-data-archive/internal/config/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/database/postgres"
+	"github.com/neicnordic/sensitive-data-archive/internal/helper"
+	"github.com/neicnordic/sensitive-data-archive/internal/schema"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/locationbroker"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	log "github.com/sirupsen/logrus"
+)
+
+type Housekeeping struct {
+	InboxWriter storage.Writer
+	// Broker is nil when users are not warned before their files are removed
+	Broker brokerv2.Broker
+	db     database.Database
+	// defaultRetention applies to the users which are not in any of the retention groups
+	defaultRetention housekeepingconf.Retention
+	retentionGroups  []housekeepingconf.RetentionGroup
+}
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run() error {
+	var err error
+	app := Housekeeping{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err = configv2.Load(); err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
+	switch {
+	case housekeepingconf.PollInterval() <= 0:
+		return errors.New("pollInterval needs to be positive")
+	case housekeepingconf.BatchSize() < 1:
+		return errors.New("batchSize needs to be at least 1")
+	}
+	app.defaultRetention = housekeepingconf.DefaultRetention()
+	app.retentionGroups, err = housekeepingconf.RetentionGroups()
+	if err != nil {
+		return err
+	}
+	for _, retention := range app.retentions() {
+		if retention.Files < 0 || retention.Uploads < 0 || retention.Warning < 0 {
+			return errors.New("retention periods can not be negative")
+		}
+	}
+
+	if housekeepingconf.NotifyQueue() != "" {
+		app.Broker, err = factory.NewBroker(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to initialize mq broker, due to: %v", err)
+		}
+		defer func() {
+			if err := app.Broker.Close(); err != nil {
+				log.Errorf("could not close Broker, due to: %v", err)
+			}
+		}()
+	} else {
+		log.Info("no notify queue configured, will NOT warn users before removing their files")
+	}
+
+	app.db, err = postgres.NewPostgresSQLDatabase()
+	if err != nil {
+		return fmt.Errorf("failed to initialize sda db due to: %v", err)
+	}
+	defer app.db.Close()
+	if dbSchemaVersion, err := app.db.SchemaVersion(); err != nil || dbSchemaVersion < 34 {
+		return errors.Join(errors.New("database schema v34 is required"), err)
+	}
+
+	storageLocationBroker, err := locationbroker.NewLocationBroker(app.db)
+	if err != nil {
+		return fmt.Errorf("failed to initialize location broker, due to: %v", err)
+	}
+	app.InboxWriter, err = storage.NewWriter(ctx, "inbox", storageLocationBroker)
+	if err != nil {
+		return fmt.Errorf("failed to initialize inbox writer, due to: %v", err)
+	}
+	log.Info("starting housekeeping service")
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+
+	go func() {
+		sig := <-sigc
+		log.Infof("recieved signal: %v, shutting down gracefully", sig)
+		cancel()
+	}()
+
+	ticker := time.NewTicker(housekeepingconf.PollInterval())
+	defer ticker.Stop()
+	for {
+		err := app.expireFiles(ctx, time.Now())
+		if err == nil {
+			err = app.expireUploads(ctx, time.Now())
+		}
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return nil
+			}
+			log.Errorf("failed to clean up inbox, will retry in %s, due to: %v", housekeepingconf.PollInterval(), err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// retentions returns the default retention followed by the retention of each retention group
+func (app *Housekeeping) retentions() []housekeepingconf.Retention {
+	retentions := []housekeepingconf.Retention{app.defaultRetention}
+	for _, group := range app.retentionGroups {
+		retentions = append(retentions, group.Retention)
+	}
+
+	return retentions
+}
+
+// retentionOf returns the retention of the first retention group the user is in, or the default retention if the
+// user is not in any retention group
+func (app *Housekeeping) retentionOf(user string) housekeepingconf.Retention {
+	for _, group := range app.retentionGroups {
+		for _, pattern := range group.Users {
+			if matched, _ := path.Match(pattern, user); matched {
+				return group.Retention
+			}
+		}
+	}
+
+	return app.defaultRetention
+}
+
+// warns returns whether users with the retention are warned before their files are removed
+func (app *Housekeeping) warns(retention housekeepingconf.Retention) bool {
+	return app.Broker != nil && housekeepingconf.NotifyQueue() != "" && retention.Warning > 0
+}
+
+// forEachStaleFile calls handle for each file in the inbox which last event is lastEvent and is older than the
+// shortest of the given ages, which are the ages at which files of each retention need to be handled.
+// Ages of 0 are ignored, as files of those retentions are kept forever
+func (app *Housekeeping) forEachStaleFile(ctx context.Context, now time.Time, lastEvent string, ages []time.Duration, handle func(context.Context, *database.InboxFile) error) error {
+	var minAge time.Duration
+	for _, age := range ages {
+		if age > 0 && (minAge == 0 || age < minAge) {
+			minAge = age
+		}
+	}
+	if minAge == 0 {
+		return nil
+	}
+
+	// Files are fetched by file id, as files which are not yet due to be removed are fetched again on each batch
+	afterFileID := ""
+	for {
+		files, err := app.db.GetStaleInboxFiles(ctx, lastEvent, now.Add(-minAge), afterFileID, housekeepingconf.BatchSize())
+		if err != nil {
+			return fmt.Errorf("failed to get %s files in the inbox, due to: %v", lastEvent, err)
+		}
+		if len(files) == 0 {
+			return nil
+		}
+
+		for _, file := range files {
+			if err := handle(ctx, file); err != nil {
+				return err
+			}
+		}
+		afterFileID = files[len(files)-1].FileID
+	}
+}
+
+// expireFiles warns users about their uploaded files which are about to expire, and removes the uploaded files which
+// have expired from the inbox
+func (app *Housekeeping) expireFiles(ctx context.Context, now time.Time) error {
+	var ages []time.Duration
+	for _, retention := range app.retentions() {
+		age := retention.Files
+		if app.warns(retention) {
+			age = max(retention.Files-retention.Warning, time.Nanosecond)
+		}
+		if retention.Files > 0 {
+			ages = append(ages, age)
+		}
+	}
+
+	removed := 0
+	err := app.forEachStaleFile(ctx, now, "uploaded", ages, func(ctx context.Context, file *database.InboxFile) error {
+		expired, err := app.expireFile(ctx, now, file)
+		if expired {
+			removed++
+		}
+
+		return err
+	})
+	if removed > 0 {
+		log.Infof("removed %d expired files from the inbox", removed)
+	}
+
+	return err
+}
+
+// expireFile removes the uploaded file from the inbox if it has expired, users are warned first when warnings are
+// enabled, and the file is kept for at least the warning period after the warning.
+// Returns whether the file was removed, and an error if the outcome could not be recorded
+func (app *Housekeeping) expireFile(ctx context.Context, now time.Time, file *database.InboxFile) (bool, error) {
+	retention := app.retentionOf(file.User)
+	if retention.Files == 0 {
+		return false, nil
+	}
+
+	expiresAt := file.LastEventAt.Add(retention.Files)
+	if app.warns(retention) {
+		// Warnings from before the last event of the file are about a previous upload of the file
+		if file.WarnedAt.Before(file.LastEventAt) {
+			if now.Before(expiresAt.Add(-retention.Warning)) {
+				return false, nil
+			}
+
+			return false, app.warnUser(ctx, file, maxTime(expiresAt, now.Add(retention.Warning)))
+		}
+		expiresAt = maxTime(expiresAt, file.WarnedAt.Add(retention.Warning))
+	}
+	if now.Before(expiresAt) {
+		return false, nil
+	}
+
+	return app.disableFile(ctx, file, "uploaded", fmt.Sprintf("file was not ingested within %s after upload", retention.Files), func() bool {
+		err := app.InboxWriter.RemoveFile(ctx, file.Location, helper.UnanonymizeFilepath(file.FilePath, file.User))
+		if err != nil && !errors.Is(err, storageerrors.ErrorFileNotFoundInLocation) {
+			// The file is removed on a later run instead
+			log.Errorf("failed to remove expired file: %s from the inbox, location: %s, path: %s, due to: %v", file.FileID, file.Location, file.FilePath, err)
+
+			return false
+		}
+		log.Debugf("removed expired file: %s from the inbox", file.FileID)
+
+		return true
+	})
+}
+
+// warnUser publishes a warning to the user that the file is about to be removed from their inbox, and records that
+// the user has been warned
+func (app *Housekeeping) warnUser(ctx context.Context, file *database.InboxFile, expiresAt time.Time) error {
+	body, err := json.Marshal(schema.InboxExpiry{
+		User:      file.User,
+		FilePath:  file.FilePath,
+		ExpiresAt: expiresAt.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal expiry warning, due to: %v", err)
+	}
+
+	// The user is warned again on the next run if the warning could not be published
+	if err := app.Broker.Publish(ctx, housekeepingconf.NotifyQueue(), brokerv2.Message{Key: file.FileID, Body: body}); err != nil {
+		log.Errorf("failed to publish expiry warning for file: %s, due to: %v", file.FileID, err)
+
+		return nil
+	}
+
+	if err := app.db.SetInboxExpiryWarned(ctx, file.FileID); err != nil {
+		return fmt.Errorf("failed to record expiry warning for file: %s, due to: %v", file.FileID, err)
+	}
+	log.Debugf("warned user: %s that file: %s expires at: %s", file.User, file.FileID, expiresAt)
+
+	return nil
+}
+
+// expireUploads aborts the uploads to the inbox which have been started but not completed within the retention
+// period, and disables their files
+func (app *Housekeeping) expireUploads(ctx context.Context, now time.Time) error {
+	var ages []time.Duration
+	for _, retention := range app.retentions() {
+		ages = append(ages, retention.Uploads)
+	}
+
+	aborted := 0
+	err := app.forEachStaleFile(ctx, now, "registered", ages, func(ctx context.Context, file *database.InboxFile) error {
+		expired, err := app.expireUpload(ctx, now, file)
+		if expired {
+			aborted++
+		}
+
+		return err
+	})
+	if aborted > 0 {
+		log.Infof("aborted %d incomplete uploads to the inbox", aborted)
+	}
+
+	return err
+}
+
+// expireUpload aborts the upload of the file if it has expired. Returns whether the upload was aborted, and an error
+// if the outcome could not be recorded
+func (app *Housekeeping) expireUpload(ctx context.Context, now time.Time, file *database.InboxFile) (bool, error) {
+	retention := app.retentionOf(file.User)
+	if retention.Uploads == 0 || now.Before(file.LastEventAt.Add(retention.Uploads)) {
+		return false, nil
+	}
+
+	return app.disableFile(ctx, file, "registered", fmt.Sprintf("upload was not completed within %s", retention.Uploads), func() bool {
+		// Storage implementations which do not keep incomplete uploads have nothing to abort
+		if uploadAborter, ok := storage.AsUploadAborter(app.InboxWriter); ok {
+			filePath := helper.UnanonymizeFilepath(file.FilePath, file.User)
+			if err := uploadAborter.AbortIncompleteUploads(ctx, file.Location, filePath, now.Add(-retention.Uploads)); err != nil {
+				// The upload is aborted on a later run instead
+				log.Errorf("failed to abort incomplete upload of file: %s, location: %s, path: %s, due to: %v", file.FileID, file.Location, file.FilePath, err)
+
+				return false
+			}
+		}
+		log.Debugf("aborted incomplete upload of file: %s", file.FileID)
+
+		return true
+	})
+}
+
+// disableFile removes the file from the inbox with remove, which returns whether the file was removed, and records
+// that the file has been removed by the housekeeping service. The file is only removed if its last event is still
+// lastEvent logged at the time it was found, the file is locked while removed so that it can not be uploaded again
+// until the removal has been recorded. Returns whether the file was removed, and an error if the outcome could not be
+// recorded
+func (app *Housekeeping) disableFile(ctx context.Context, file *database.InboxFile, lastEvent, reason string, remove func() bool) (bool, error) {
+	details, err := json.Marshal(map[string]string{"reason": reason})
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal details to JSON, due to: %v", err)
+	}
+
+	tx, err := app.db.BeginTransaction(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction, due to: %v", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	disabled, err := tx.DisableInboxFile(ctx, file.FileID, lastEvent, file.LastEventAt, string(details))
+	if err != nil {
+		return false, fmt.Errorf("failed to set disabled event for file: %s, due to: %v", file.FileID, err)
+	}
+	if !disabled {
+		log.Debugf("file: %s has changed since it was found, keeping it", file.FileID)
+
+		return false, nil
+	}
+
+	if !remove() {
+		return false, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit disabled event for file: %s, due to: %v", file.FileID, err)
+	}
+
+	return true, nil
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"sort"
+	"testing"
+	"time"
+
+	housekeepingconf "github.com/neicnordic/sensitive-data-archive/cmd/housekeeping/config"
+	brokerv2 "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/schema"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/suite"
+)
+
+type TestSuite struct {
+	suite.Suite
+	now    time.Time
+	db     *mockDatabase
+	broker *mockBroker
+	writer *mockWriter
+	app    Housekeeping
+}
+
+func TestHousekeepingTestSuite(t *testing.T) {
+	suite.Run(t, new(TestSuite))
+}
+
+// mockDatabase implements the database functions used by housekeeping, calling any other function panics
+type mockDatabase struct {
+	database.Database
+	// files holds the files in the inbox by their last event
+	files  map[string][]*database.InboxFile
+	warned map[string]bool
+	events map[string]string
+	// changed holds the files which have changed since they were found
+	changed map[string]bool
+}
+
+func (m *mockDatabase) GetStaleInboxFiles(_ context.Context, lastEvent string, before time.Time, afterFileID string, limit int) ([]*database.InboxFile, error) {
+	var files []*database.InboxFile
+	for _, file := range m.files[lastEvent] {
+		if _, ok := m.events[file.FileID]; !ok && file.LastEventAt.Before(before) && file.FileID > afterFileID {
+			files = append(files, file)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].FileID < files[j].FileID })
+	if len(files) > limit {
+		files = files[:limit]
+	}
+
+	return files, nil
+}
+
+func (m *mockDatabase) SetInboxExpiryWarned(_ context.Context, fileID string) error {
+	m.warned[fileID] = true
+
+	return nil
+}
+
+func (m *mockDatabase) BeginTransaction(_ context.Context) (database.Transaction, error) {
+	return &mockTransaction{mockDatabase: m, events: make(map[string]string)}, nil
+}
+
+// mockTransaction records the events logged in the transaction, which are only kept when committed
+type mockTransaction struct {
+	*mockDatabase
+	events map[string]string
+}
+
+func (t *mockTransaction) DisableInboxFile(_ context.Context, fileID, _ string, _ time.Time, _ string) (bool, error) {
+	if t.changed[fileID] {
+		return false, nil
+	}
+	t.events[fileID] = "disabled"
+
+	return true, nil
+}
+
+func (t *mockTransaction) Commit() error {
+	maps.Copy(t.mockDatabase.events, t.events)
+
+	return nil
+}
+
+func (t *mockTransaction) Rollback() error {
+	return nil
+}
+
+type mockBroker struct {
+	brokerv2.Broker
+	warnings []schema.InboxExpiry
+}
+
+func (m *mockBroker) Publish(_ context.Context, _ string, message brokerv2.Message) error {
+	var warning schema.InboxExpiry
+	if err := json.Unmarshal(message.Body, &warning); err != nil {
+		return err
+	}
+	m.warnings = append(m.warnings, warning)
+
+	return nil
+}
+
+// mockWriter records the files removed from the inbox, files are stored by location and file path
+type mockWriter struct {
+	removed []string
+	err     error
+}
+
+func (w *mockWriter) RemoveFile(_ context.Context, location, filePath string) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.removed = append(w.removed, location+"/"+filePath)
+
+	return nil
+}
+
+func (w *mockWriter) WriteFile(_ context.Context, _ string, _ io.Reader) (string, error) {
+	return "", errors.New("not implemented")
+}
+
+// mockUploadAborter is a writer which keeps incomplete uploads, and records the aborted uploads
+type mockUploadAborter struct {
+	mockWriter
+	aborted []string
+}
+
+func (w *mockUploadAborter) AbortIncompleteUploads(_ context.Context, location, filePath string, _ time.Time) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.aborted = append(w.aborted, location+"/"+filePath)
+
+	return nil
+}
+
+func (ts *TestSuite) SetupTest() {
+	viper.Set("log.level", "debug")
+	// Handle one file at a time to exercise fetching multiple batches
+	housekeepingconf.SetBatchSize(1)
+	housekeepingconf.SetNotifyQueue("expiring")
+
+	ts.now = time.Now()
+	ts.db = &mockDatabase{
+		files:   make(map[string][]*database.InboxFile),
+		warned:  make(map[string]bool),
+		events:  make(map[string]string),
+		changed: make(map[string]bool),
+	}
+	ts.broker = &mockBroker{}
+	ts.writer = &mockWriter{}
+	ts.app = Housekeeping{
+		InboxWriter: ts.writer,
+		Broker:      ts.broker,
+		db:          ts.db,
+		defaultRetention: housekeepingconf.Retention{
+			Files:   30 * 24 * time.Hour,
+			Uploads: 48 * time.Hour,
+			Warning: 7 * 24 * time.Hour,
+		},
+	}
+}
+
+// addFile adds a file to the inbox of the user which last event happened age ago
+func (ts *TestSuite) addFile(fileID, user, lastEvent string, age time.Duration) *database.InboxFile {
+	file := &database.InboxFile{
+		FileID:      fileID,
+		User:        user,
+		FilePath:    fileID + ".c4gh",
+		Location:    "/inbox",
+		LastEventAt: ts.now.Add(-age),
+	}
+	ts.db.files[lastEvent] = append(ts.db.files[lastEvent], file)
+
+	return file
+}
+
+func (ts *TestSuite) TestExpireFiles_withoutWarnings() {
+	ts.app.Broker = nil
+	ts.addFile("file-1", "user@example.org", "uploaded", 31*24*time.Hour)
+	ts.addFile("file-2", "user@example.org", "uploaded", 29*24*time.Hour)
+	ts.addFile("file-3", "other@example.org", "uploaded", 40*24*time.Hour)
+
+	ts.NoError(ts.app.expireFiles(context.TODO(), ts.now))
+
+	ts.Equal([]string{"/inbox/user_example.org/file-1.c4gh", "/inbox/other_example.org/file-3.c4gh"}, ts.writer.removed)
+	ts.Equal(map[string]string{"file-1": "disabled", "file-3": "disabled"}, ts.db.events)
+	ts.Empty(ts.db.warned)
+}
+
+func (ts *TestSuite) TestExpireFiles_warnsBeforeRemoving() {
+	ts.addFile("file-1", "user@example.org", "uploaded", 31*24*time.Hour)
+	ts.addFile("file-2", "user@example.org", "uploaded", 24*24*time.Hour)
+	ts.addFile("file-3", "user@example.org", "uploaded", 20*24*time.Hour)
+
+	// Files which are due to expire are not removed before the user has been warned
+	ts.NoError(ts.app.expireFiles(context.TODO(), ts.now))
+	ts.Empty(ts.writer.removed)
+	ts.Equal(map[string]bool{"file-1": true, "file-2": true}, ts.db.warned)
+	ts.Len(ts.broker.warnings, 2)
+	ts.Equal(schema.InboxExpiry{
+		User:      "user@example.org",
+		FilePath:  "file-1.c4gh",
+		ExpiresAt: ts.now.Add(7 * 24 * time.Hour).UTC().Format(time.RFC3339),
+	}, ts.broker.warnings[0])
+	// Files are kept for the whole warning period after the user has been warned
+	ts.Equal(ts.now.Add(7*24*time.Hour).UTC().Format(time.RFC3339), ts.broker.warnings[1].ExpiresAt)
+}
+
+func (ts *TestSuite) TestExpireFiles_removesAfterWarningPeriod() {
+	ts.addFile("file-1", "user@example.org", "uploaded", 40*24*time.Hour).WarnedAt = ts.now.Add(-8 * 24 * time.Hour)
+	ts.addFile("file-2", "user@example.org", "uploaded", 40*24*time.Hour).WarnedAt = ts.now.Add(-24 * time.Hour)
+	// A warning about a previous upload of the file does not count
+	ts.addFile("file-3", "user@example.org", "uploaded", 31*24*time.Hour).WarnedAt = ts.now.Add(-60 * 24 * time.Hour)
+
+	ts.NoError(ts.app.expireFiles(context.TODO(), ts.now))
+	ts.Equal([]string{"/inbox/user_example.org/file-1.c4gh"}, ts.writer.removed)
+	ts.Equal(map[string]string{"file-1": "disabled"}, ts.db.events)
+	ts.Equal(map[string]bool{"file-3": true}, ts.db.warned)
+}
+
+func (ts *TestSuite) TestExpireFiles_retentionGroups() {
+	ts.app.Broker = nil
+	ts.app.retentionGroups = []housekeepingconf.RetentionGroup{
+		{Users: []string{"*@short.org"}, Retention: housekeepingconf.Retention{Files: time.Hour}},
+		{Users: []string{"keeper@example.org"}, Retention: housekeepingconf.Retention{Files: 0}},
+	}
+	ts.addFile("file-1", "user@short.org", "uploaded", 2*time.Hour)
+	ts.addFile("file-2", "user@example.org", "uploaded", 2*time.Hour)
+	ts.addFile("file-3", "keeper@example.org", "uploaded", 365*24*time.Hour)
+
+	ts.NoError(ts.app.expireFiles(context.TODO(), ts.now))
+	ts.Equal([]string{"/inbox/user_short.org/file-1.c4gh"}, ts.writer.removed)
+	ts.Equal(map[string]string{"file-1": "disabled"}, ts.db.events)
+}
+
+func (ts *TestSuite) TestExpireFiles_removeFailed() {
+	ts.app.Broker = nil
+	ts.addFile("file-1", "user@example.org", "uploaded", 31*24*time.Hour)
+
+	// The file is kept in the inbox, and removed on a later run
+	ts.writer.err = errors.New("storage unavailable")
+	ts.NoError(ts.app.expireFiles(context.TODO(), ts.now))
+	ts.Empty(ts.db.events)
+
+	// A file which has already been removed from storage is disabled
+	ts.writer.err = storageerrors.ErrorFileNotFoundInLocation
+	ts.NoError(ts.app.expireFiles(context.TODO(), ts.now))
+	ts.Equal(map[string]string{"file-1": "disabled"}, ts.db.events)
+}
+
+func (ts *TestSuite) TestExpireFiles_changedSinceFound() {
+	ts.app.Broker = nil
+	ts.addFile("file-1", "user@example.org", "uploaded", 31*24*time.Hour)
+	ts.addFile("file-2", "user@example.org", "uploaded", 31*24*time.Hour)
+
+	// A file which has been uploaded again after it was found is kept
+	ts.db.changed["file-1"] = true
+	ts.NoError(ts.app.expireFiles(context.TODO(), ts.now))
+	ts.Equal([]string{"/inbox/user_example.org/file-2.c4gh"}, ts.writer.removed)
+	ts.Equal(map[string]string{"file-2": "disabled"}, ts.db.events)
+}
+
+func (ts *TestSuite) TestExpireUploads() {
+	aborter := &mockUploadAborter{}
+	ts.app.InboxWriter = aborter
+	ts.addFile("file-1", "user@example.org", "registered", 3*24*time.Hour)
+	ts.addFile("file-2", "user@example.org", "registered", 24*time.Hour)
+	ts.addFile("file-3", "user@example.org", "uploaded", 3*24*time.Hour)
+
+	ts.NoError(ts.app.expireUploads(context.TODO(), ts.now))
+	ts.Equal([]string{"/inbox/user_example.org/file-1.c4gh"}, aborter.aborted)
+	ts.Empty(aborter.removed)
+	ts.Equal(map[string]string{"file-1": "disabled"}, ts.db.events)
+}
+
+func (ts *TestSuite) TestExpireUploads_notAborted() {
+	ts.addFile("file-1", "user@example.org", "registered", 3*24*time.Hour)
+
+	// The upload is aborted on a later run when aborting fails
+	aborter := &mockUploadAborter{mockWriter: mockWriter{err: errors.New("storage unavailable")}}
+	ts.app.InboxWriter = aborter
+	ts.NoError(ts.app.expireUploads(context.TODO(), ts.now))
+	ts.Empty(ts.db.events)
+
+	// Storage which does not keep incomplete uploads has nothing to abort
+	ts.app.InboxWriter = ts.writer
+	ts.NoError(ts.app.expireUploads(context.TODO(), ts.now))
+	ts.Equal(map[string]string{"file-1": "disabled"}, ts.db.events)
+}
+
+func (ts *TestSuite) TestRetentionGroups() {
+	defer viper.Set("retention.groups", nil)
+
+	viper.Set("retention.groups", []map[string]any{
+		{"users": []string{"*@example.org"}, "files": "1h"},
+		{"users": []string{"user@other.org"}, "uploads": "0s", "warning": "24h"},
+	})
+	groups, err := housekeepingconf.RetentionGroups()
+	ts.NoError(err)
+	ts.Len(groups, 2)
+	defaults := housekeepingconf.DefaultRetention()
+	ts.Equal(housekeepingconf.Retention{Files: time.Hour, Uploads: defaults.Uploads, Warning: defaults.Warning}, groups[0].Retention)
+	ts.Equal(housekeepingconf.Retention{Files: defaults.Files, Uploads: 0, Warning: 24 * time.Hour}, groups[1].Retention)
+
+	viper.Set("retention.groups", []map[string]any{{"files": "1h"}})
+	_, err = housekeepingconf.RetentionGroups()
+	ts.Error(err)
+
+	viper.Set("retention.groups", []map[string]any{{"users": []string{"[user"}}})
+	_, err = housekeepingconf.RetentionGroups()
+	ts.Error(err)
+}
@@ -9,9 +9,11 @@ import (
 )
 
 var (
-	sourceQueue   string
-	archivedQueue string
-	schemaPath    string
+	sourceQueue          string
+	archivedQueue        string
+	schemaPath           string
+	archiveDeduplication bool
+	archiveReplication   bool
 )
 
 func init() {
@@ -36,6 +38,26 @@ func init() {
 				archivedQueue = viper.GetString(flagName)
 			},
 		},
+		&config.Flag{
+			Name: "archiveDeduplication",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Bool(flagName, false, "If files with identical archived content should share the same archived object instead of being archived again")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				archiveDeduplication = viper.GetBool(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "archiveReplication",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Bool(flagName, false, "If files should be written to the backup storage at the same time as to the archive, instead of being backed up by finalize")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				archiveReplication = viper.GetBool(flagName)
+			},
+		},
 		&config.Flag{
 			Name: "schemaType",
 			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
@@ -65,6 +87,14 @@ func ArchivedQueue() string {
 	return archivedQueue
 }
 
+func ArchiveDeduplication() bool {
+	return archiveDeduplication
+}
+
+func ArchiveReplication() bool {
+	return archiveReplication
+}
+
 func SchemaPath() string {
 	return schemaPath
 }
@@ -8,6 +8,7 @@ import (
 	"context"
 	"crypto/sha256"
 	"database/sql"
+	"encoding"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -18,12 +19,13 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/google/uuid"
 	"github.com/neicnordic/crypt4gh/keys"
 	"github.com/neicnordic/crypt4gh/model/headers"
 	"github.com/neicnordic/crypt4gh/streaming"
 	ingestconf "github.com/neicnordic/sensitive-data-archive/cmd/ingest/config"
 	brokerv2 "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
-	"github.com/neicnordic/sensitive-data-archive/internal/broker/v2/rabbitmq"
+	"github.com/neicnordic/sensitive-data-archive/internal/broker/v2/factory"
 	"github.com/neicnordic/sensitive-data-archive/internal/config"
 	configv2 "github.com/neicnordic/sensitive-data-archive/internal/config/v2"
 	"github.com/neicnordic/sensitive-data-archive/internal/database"
@@ -44,15 +46,57 @@ type Ingest struct {
 	db             database.Database
 	InboxReader    storage.Reader
 	Broker         brokerv2.Broker
+	// Deduplicate enables files with identical archived content to share the same archived object
+	Deduplicate bool
+	// ArchiveReplicator writes files to both the archive and backup storage when archive replication is enabled
+	ArchiveReplicator storage.ReplicatingWriter
 }
 
 type decryptResult struct {
 	keyHash    string
 	hash       hash.Hash
 	teedReader io.Reader
-	header     []byte
+	// source is the source positioned after the header, reading from it directly does not update the hash
+	source io.Reader
+	header []byte
+	// sourceSize is the size of the source, which is an upper bound of the size of the archived content
+	sourceSize int64
 }
 
+// archivedFile describes where the header stripped content of a file has been archived
+type archivedFile struct {
+	location string
+	filePath string
+	// contentChecksum, and contentSize are the sha256 checksum and size of the archived content, only set when archived
+	// with deduplication
+	contentChecksum string
+	contentSize     int64
+	// shared is set when the content had already been archived for another file
+	shared bool
+	// copyLocation, and copyFilePath are where the content was written while its checksum was calculated, the copy is
+	// removed once a shared object has been referenced instead. Not set when the shared object was found by the
+	// uploaded checksum of the file, as the content is not written then
+	copyLocation string
+	copyFilePath string
+	// backupLocation, and copyBackupLocation are where the file, and the copy, were written to in the backup storage,
+	// only set when archived with replication
+	backupLocation     string
+	copyBackupLocation string
+}
+
+// byteCounter counts the bytes written to it
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+
+	return len(p), nil
+}
+
+// errIngestionInterrupted is returned when a resumable archive upload was interrupted, the ingestion is to be resumed
+// from its checkpoint when the message is redelivered
+var errIngestionInterrupted = errors.New("ingestion interrupted")
+
 func main() {
 	if err := run(); err != nil {
 		log.Fatal(err)
@@ -70,7 +114,7 @@ func run() error {
 		return fmt.Errorf("failed to load config: %v", err)
 	}
 
-	app.Broker, err = rabbitmq.NewRabbitMQBroker(context.Background())
+	app.Broker, err = factory.NewBroker(context.Background())
 	if err != nil {
 		return fmt.Errorf("failed to initialize mq broker, due to: %v", err)
 	}
@@ -89,8 +133,8 @@ func run() error {
 		return fmt.Errorf("failed to initialize sda db due to: %v", err)
 	}
 	defer app.db.Close()
-	if dbSchemaVersion, err := app.db.SchemaVersion(); err != nil || dbSchemaVersion < 23 {
-		return errors.Join(errors.New("database schema v23 is required"), err)
+	if dbSchemaVersion, err := app.db.SchemaVersion(); err != nil || dbSchemaVersion < 37 {
+		return errors.Join(errors.New("database schema v37 is required"), err)
 	}
 
 	app.ArchiveKeyList, err = config.GetC4GHprivateKeys()
@@ -127,6 +171,17 @@ func run() error {
 	} else {
 		log.Info("no backup writer initialized, will NOT clean cancelled files from backup storage")
 	}
+	if ingestconf.ArchiveReplication() {
+		app.ArchiveReplicator, err = storage.NewReplicatingWriter(ctx, []string{"archive", "backup"}, storageLocationBroker)
+		if err != nil {
+			return fmt.Errorf("failed to initialize archive replication, due to: %v", err)
+		}
+		log.Info("archive replication enabled, files will be written to the archive and backup storage at the same time")
+	}
+	app.Deduplicate = ingestconf.ArchiveDeduplication()
+	if app.Deduplicate {
+		log.Info("archive deduplication enabled, files with identical archived content will share the archived object")
+	}
 	log.Info("starting ingest service")
 
 	sigc := make(chan os.Signal, 1)
@@ -224,35 +279,39 @@ func (app *Ingest) cancelFile(ctx context.Context, fileID string, message *broke
 		return nil, err
 	}
 
+	// Abort any interrupted archive upload of the file, so the uploaded parts do not linger in the archive
+	checkpoint, err := app.db.GetIngestCheckpoint(ctx, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query db: %v", err)
+	}
+	if checkpoint != nil {
+		app.discardCheckpoint(ctx, fileID, checkpoint)
+	}
+
 	if archiveData == nil {
 		log.Warnf("file %s not found in archive, skipping", fileID)
 
 		return nil, nil
 	}
 
-	if archiveData.Location != "" {
-		if err := app.ArchiveWriter.RemoveFile(ctx, archiveData.Location, archiveData.FilePath); err != nil {
-			log.Errorf("failed to remove file with id %s from backup due to %v", fileID, err)
-
-			return nil, nil
-		}
-	}
-
-	if app.BackupWriter != nil && archiveData.BackupFilePath != "" && archiveData.BackupLocation != "" {
-		if err := app.BackupWriter.RemoveFile(ctx, archiveData.BackupLocation, archiveData.BackupFilePath); err != nil {
-			log.Errorf("failed to remove file with id %s from backup due to %v", fileID, err)
+	// Ideally this transaction should span the whole message processing, but for now just spans the dereferencing of
+	// the archived object and the CancelFile
+	tx, err := app.db.BeginTransaction(ctx)
+	if err != nil {
+		log.Errorf("failed to begin transaction, reason: %v", err)
 
-			return nil, nil
-		}
+		return []func(){app.errorQueue(message), app.setErrorEvent(err.Error(), message)}, nil
 	}
 
-	// Ideally this transaction should span the whole message processing, but for now just spans the CancelFile
-	tx, err := app.db.BeginTransaction(ctx)
+	// The archived object is only to be removed if no other file references it
+	stillReferenced, err := tx.DereferenceArchiveObject(ctx, archiveData.Location, archiveData.FilePath)
 	if err != nil {
-		log.Errorf("failed to begin transaction, reason: %v", err)
+		log.Errorf("failed to dereference archived object of file with id %s, due to %v", fileID, err)
+		_ = tx.Rollback()
 
 		return []func(){app.errorQueue(message), app.setErrorEvent(err.Error(), message)}, nil
 	}
+
 	if err := tx.CancelFile(ctx, fileID, string(message.Body)); err != nil {
 		log.Errorf("failed to cancel file with id: %s, due to %v", fileID, err)
 
@@ -270,6 +329,27 @@ func (app *Ingest) cancelFile(ctx context.Context, fileID string, message *broke
 		return []func(){app.errorQueue(message), app.setErrorEvent(err.Error(), message)}, nil
 	}
 
+	// The archived object is only removed once the dereference has been committed, such that a failed commit can
+	// not leave a reference to a removed object. A failed removal only leaves an unreferenced object behind.
+	if stillReferenced {
+		log.Infof("archived object of file with id %s is still referenced by other files, will not be removed", fileID)
+		log.Infof("successfully canceled file: %s", fileID)
+
+		return nil, nil
+	}
+
+	if archiveData.Location != "" {
+		if err := app.ArchiveWriter.RemoveFile(ctx, archiveData.Location, archiveData.FilePath); err != nil {
+			log.Errorf("failed to remove file with id %s from archive, location: %s, path: %s is left unreferenced, due to %v", fileID, archiveData.Location, archiveData.FilePath, err)
+		}
+	}
+
+	if app.BackupWriter != nil && archiveData.BackupFilePath != "" && archiveData.BackupLocation != "" {
+		if err := app.BackupWriter.RemoveFile(ctx, archiveData.BackupLocation, archiveData.BackupFilePath); err != nil {
+			log.Errorf("failed to remove file with id %s from backup, location: %s, path: %s is left unreferenced, due to %v", fileID, archiveData.BackupLocation, archiveData.BackupFilePath, err)
+		}
+	}
+
 	log.Infof("successfully canceled and cleaned up file: %s", fileID)
 
 	return nil, nil
@@ -290,9 +370,25 @@ func (app *Ingest) ingestFile(ctx context.Context, fileID, filePath, user, archi
 		return nil, nil
 	}
 
+	checkpoint, err := app.db.GetIngestCheckpoint(ctx, fileID)
+	if err != nil {
+		log.Errorf("could not get ingest checkpoint for file: %s, due to %v", fileID, err)
+
+		return nil, nil
+	}
+
 	switch status {
 	case "uploaded", "disabled":
 
+	case "submitted":
+		// A redelivered message of an interrupted ingestion is resumed from its checkpoint
+		if checkpoint == nil {
+			log.Warnf("file: %s recieved ingestion trigger with status: %s", fileID, status)
+
+			return nil, fmt.Errorf("cannot ingest file with status: %s", status)
+		}
+		log.Infof("resuming interrupted ingestion of file: %s", fileID)
+
 	case "":
 		// Catch all for implementations inbox uploading that does not register the file in the DB, e.g. for those not using S3inbox or sftpInbox
 		// Since we dont have the submission location in storage, we need to look through all configured storage locations.
@@ -336,7 +432,13 @@ func (app *Ingest) ingestFile(ctx context.Context, fileID, filePath, user, archi
 		return nil, fmt.Errorf("cannot ingest file with status: %s", status)
 	}
 
-	sourceReader, err := app.InboxReader.NewFileReader(ctx, submissionLocation, helper.UnanonymizeFilepath(filePath, user))
+	var sourceReader io.ReadCloser
+	if checkpoint != nil {
+		// The already archived content will be skipped by seeking past it
+		sourceReader, err = app.InboxReader.NewFileReadSeeker(ctx, submissionLocation, helper.UnanonymizeFilepath(filePath, user))
+	} else {
+		sourceReader, err = app.InboxReader.NewFileReader(ctx, submissionLocation, helper.UnanonymizeFilepath(filePath, user))
+	}
 	if err != nil {
 		log.Errorf("failed to read file, due to: %v", err)
 
@@ -344,6 +446,13 @@ func (app *Ingest) ingestFile(ctx context.Context, fileID, filePath, user, archi
 	}
 	defer sourceReader.Close()
 
+	sourceSize, err := app.InboxReader.GetFileSize(ctx, submissionLocation, helper.UnanonymizeFilepath(filePath, user))
+	if err != nil {
+		log.Errorf("failed to get file size, due to: %v", err)
+
+		return []func(){app.errorQueue(message), app.setErrorEvent(err.Error(), message)}, nil
+	}
+
 	if err := app.db.UpdateFileEventLog(ctx, fileID, "submitted", "ingest", "{}", string(message.Body)); err != nil {
 		log.Errorf("failed to set ingestion status for file from message, file-id: %s, due to: %v", fileID, err)
 
@@ -356,8 +465,14 @@ func (app *Ingest) ingestFile(ctx context.Context, fileID, filePath, user, archi
 
 		return []func(){app.errorQueue(message), app.setErrorEvent(err.Error(), message)}, nil
 	}
+	decryptResult.sourceSize = sourceSize
 
-	location, err := app.archive(ctx, decryptResult.keyHash, fileID, decryptResult.header, decryptResult.teedReader)
+	archived, err := app.archive(ctx, fileID, decryptResult, checkpoint)
+	if errors.Is(err, errIngestionInterrupted) {
+		log.Warnf("archiving of file: %s was interrupted, will be resumed when redelivered, due to: %v", fileID, err)
+
+		return nil, err
+	}
 	if err != nil {
 		log.Errorf("failed to archive file: %s, due to: %v", fileID, err)
 
@@ -366,13 +481,14 @@ func (app *Ingest) ingestFile(ctx context.Context, fileID, filePath, user, archi
 
 	checksum := fmt.Sprintf("%x", decryptResult.hash.Sum(nil))
 
-	if err := app.finalizeDatabaseRecords(ctx, fileID, location, checksum, message); err != nil {
+	archived, err = app.finalizeDatabaseRecords(ctx, fileID, archived, checksum, message)
+	if err != nil {
 		log.Errorf("failed to finalize databse records for file: %s, due to: %v", fileID, err)
 
 		return []func(){app.errorQueue(message), app.setErrorEvent(err.Error(), message)}, nil
 	}
 
-	if err := app.notifyArchived(ctx, fileID, filePath, user, checksum, archivedQueue, message); err != nil {
+	if err := app.notifyArchived(ctx, fileID, filePath, user, archived.filePath, checksum, archivedQueue, message); err != nil {
 		log.Errorf("failed to send to archived message for file: %s, due to: %v", fileID, err)
 
 		return []func(){app.errorQueue(message), app.setErrorEvent(err.Error(), message)}, nil
@@ -410,65 +526,373 @@ func (app *Ingest) decrypt(source io.ReadCloser) (decryptResult, error) {
 	publicKey := keys.DerivePublicKey(*validKey)
 	keyHash := hex.EncodeToString(publicKey[:])
 
-	return decryptResult{keyHash: keyHash, hash: fileHash, teedReader: teedReader, header: header}, err
+	return decryptResult{keyHash: keyHash, hash: fileHash, teedReader: teedReader, source: source, header: header}, err
 }
 
-func (app *Ingest) archive(ctx context.Context, keyHash, fileID string, rawHeader []byte, reader io.Reader) (string, error) {
-	if err := app.db.SetKeyHash(ctx, keyHash, fileID); err != nil {
-		return "", err
+func (app *Ingest) archive(ctx context.Context, fileID string, result decryptResult, checkpoint *database.IngestCheckpoint) (archivedFile, error) {
+	if err := app.db.SetKeyHash(ctx, result.keyHash, fileID); err != nil {
+		return archivedFile{}, err
 	}
 
 	// TODO: Remember to clean up previous DB call in case next one errors (eg use transactions)
-	if err := app.db.StoreHeader(ctx, rawHeader, fileID); err != nil {
-		return "", err
+	if err := app.db.StoreHeader(ctx, result.header, fileID); err != nil {
+		return archivedFile{}, err
+	}
+
+	resumableWriter, resumable := storage.AsResumableWriter(app.ArchiveWriter)
+	// Replicated writes can not be resumed, as the content is streamed to all replicas at once
+	resumable = resumable && app.ArchiveReplicator == nil
+	if checkpoint != nil && (app.Deduplicate || !resumable) {
+		log.Warnf("file: %s can not be resumed from its checkpoint with the current configuration, archiving from the start", fileID)
+		app.discardCheckpoint(ctx, fileID, checkpoint)
+	}
+
+	if app.Deduplicate {
+		return app.archiveDeduplicated(ctx, fileID, result)
+	}
+
+	if resumable {
+		return app.archiveResumable(ctx, fileID, resumableWriter, result, checkpoint)
+	}
+
+	location, backupLocation, err := app.writeArchive(ctx, fileID, result.teedReader)
+	if err != nil {
+		return archivedFile{}, err
+	}
+
+	return archivedFile{location: location, filePath: fileID, backupLocation: backupLocation}, nil
+}
+
+// writeArchive writes the content to the archive, and to the backup storage when archive replication is enabled, in
+// which case the backup location is returned as well
+func (app *Ingest) writeArchive(ctx context.Context, filePath string, content io.Reader) (string, string, error) {
+	if app.ArchiveReplicator == nil {
+		location, err := app.ArchiveWriter.WriteFile(ctx, filePath, content)
+
+		return location, "", err
+	}
+
+	locations, err := app.ArchiveReplicator.WriteFile(ctx, filePath, content)
+	if err != nil {
+		return "", "", err
+	}
+
+	return locations["archive"], locations["backup"], nil
+}
+
+// archiveDeduplicated archives the header stripped content while calculating its checksum, and checks if the content
+// has already been archived for another file, in which case that archived object is to be referenced instead. The
+// content is archived at a new path, so that an object which may be shared by other files is never overwritten.
+// Content which was uploaded with the same checksum as an already archived file is not written at all
+func (app *Ingest) archiveDeduplicated(ctx context.Context, fileID string, result decryptResult) (archivedFile, error) {
+	archiveObject, err := app.db.GetUploadedArchiveObject(ctx, fileID)
+	if err != nil {
+		log.Warnf("failed to look up archive object by the uploaded checksum of file: %s, archiving its content, due to: %v", fileID, err)
+	}
+	if archiveObject != nil {
+		return verifyArchiveObject(fileID, result, archiveObject)
+	}
+
+	filePath := uuid.NewString()
+	contentHash := sha256.New()
+	var contentSize byteCounter
+	location, backupLocation, err := app.writeArchive(ctx, filePath, io.TeeReader(result.teedReader, io.MultiWriter(contentHash, &contentSize)))
+	if err != nil {
+		return archivedFile{}, err
+	}
+	contentChecksum := hex.EncodeToString(contentHash.Sum(nil))
+	archived := archivedFile{location: location, filePath: filePath, contentChecksum: contentChecksum, contentSize: int64(contentSize), backupLocation: backupLocation}
+
+	archiveObject, err = app.db.GetArchiveObject(ctx, contentChecksum, int64(contentSize))
+	if err != nil {
+		app.removeArchiveCopy(ctx, location, backupLocation, filePath)
+
+		return archivedFile{}, fmt.Errorf("failed to look up archive object, due to: %v", err)
+	}
+	if archiveObject != nil {
+		log.Infof("content with checksum: %s already archived at location: %s, path: %s", contentChecksum, archiveObject.Location, archiveObject.FilePath)
+		archived.copyLocation, archived.copyFilePath, archived.copyBackupLocation = location, filePath, backupLocation
+		archived.location, archived.filePath, archived.shared = archiveObject.Location, archiveObject.FilePath, true
+		// The shared object is backed up by finalize, as where it was backed up to is not known
+		archived.backupLocation = ""
+	}
+
+	return archived, nil
+}
+
+// verifyArchiveObject reads the header stripped content to calculate the checksum of the file, and verifies that the
+// content is the same as the archived content of the archive object, which is to be referenced instead of archiving
+// the content
+func verifyArchiveObject(fileID string, result decryptResult, archiveObject *database.ArchiveObject) (archivedFile, error) {
+	contentHash := sha256.New()
+	var contentSize byteCounter
+	if _, err := io.Copy(io.MultiWriter(contentHash, &contentSize), result.teedReader); err != nil {
+		return archivedFile{}, fmt.Errorf("failed to read file content, due to: %v", err)
+	}
+	contentChecksum := hex.EncodeToString(contentHash.Sum(nil))
+	if contentChecksum != archiveObject.Checksum || int64(contentSize) != archiveObject.FileSize {
+		return archivedFile{}, fmt.Errorf("content of file: %s differs from the archived content at location: %s, path: %s, uploaded with the same checksum", fileID, archiveObject.Location, archiveObject.FilePath)
+	}
+	log.Infof("content of file: %s already archived at location: %s, path: %s", fileID, archiveObject.Location, archiveObject.FilePath)
+
+	return archivedFile{
+		location:        archiveObject.Location,
+		filePath:        archiveObject.FilePath,
+		contentChecksum: contentChecksum,
+		contentSize:     int64(contentSize),
+		shared:          true,
+	}, nil
+}
+
+// removeArchiveCopy removes content which was archived but is not to be referenced, and its replica in the backup
+// storage if any, errors are only logged as the object is left unreferenced at worst
+func (app *Ingest) removeArchiveCopy(ctx context.Context, location, backupLocation, filePath string) {
+	var err error
+	if backupLocation != "" {
+		err = app.ArchiveReplicator.RemoveFile(ctx, map[string]string{"archive": location, "backup": backupLocation}, filePath)
+	} else {
+		err = app.ArchiveWriter.RemoveFile(ctx, location, filePath)
+	}
+	if err != nil {
+		log.Errorf("failed to remove unreferenced archived content, location: %s, path: %s, due to: %v", location, filePath, err)
+	}
+}
+
+// archiveResumable archives the content in parts, and checkpoints the progress after each uploaded part such that an
+// interrupted ingestion can be resumed from the last uploaded part
+func (app *Ingest) archiveResumable(ctx context.Context, fileID string, writer storage.ResumableWriter, result decryptResult, checkpoint *database.IngestCheckpoint) (archivedFile, error) {
+	if checkpoint != nil {
+		if err := resumeFromCheckpoint(checkpoint, result); err != nil {
+			app.discardCheckpoint(ctx, fileID, checkpoint)
+
+			return archivedFile{}, fmt.Errorf("failed to resume from checkpoint, due to: %v", err)
+		}
+		log.Infof("resuming archiving of file: %s after %d parts, and %d bytes", fileID, checkpoint.PartsUploaded, checkpoint.BytesArchived)
+	} else {
+		location, uploadID, err := writer.StartUpload(ctx, fileID)
+		if err != nil {
+			return archivedFile{}, err
+		}
+		checkpoint = &database.IngestCheckpoint{Location: location, FilePath: fileID, UploadID: uploadID}
+		// The part size depends on the file size, and is stored so the upload is resumed with parts of the same size
+		checkpoint.PartSize, err = writer.PartSize(location, result.sourceSize)
+		if err == nil {
+			err = app.storeCheckpoint(ctx, fileID, checkpoint, result.hash)
+		}
+		if err != nil {
+			app.discardCheckpoint(ctx, fileID, checkpoint)
+
+			return archivedFile{}, err
+		}
+	}
+
+	// Checkpoints stored before the part size was stored were uploaded with the chunk size of the archive
+	if checkpoint.PartSize == 0 {
+		var err error
+		checkpoint.PartSize, err = writer.PartSize(checkpoint.Location, 0)
+		if err != nil {
+			return archivedFile{}, err
+		}
+	}
+
+	part := make([]byte, checkpoint.PartSize)
+	for {
+		n, readErr := io.ReadFull(result.teedReader, part)
+		if readErr != nil && !errors.Is(readErr, io.EOF) && !errors.Is(readErr, io.ErrUnexpectedEOF) {
+			return archivedFile{}, fmt.Errorf("%w, failed to read file content, due to: %v", errIngestionInterrupted, readErr)
+		}
+
+		// An upload needs to consist of at least one part, even if the content is empty
+		if n > 0 || checkpoint.PartsUploaded == 0 {
+			if err := writer.UploadPart(ctx, checkpoint.Location, checkpoint.FilePath, checkpoint.UploadID, checkpoint.PartsUploaded+1, bytes.NewReader(part[:n])); err != nil {
+				// The upload can not be resumed if it no longer exists
+				if errors.Is(err, storageerrors.ErrorUploadNotFound) {
+					app.discardCheckpoint(ctx, fileID, checkpoint)
+
+					return archivedFile{}, err
+				}
+
+				return archivedFile{}, fmt.Errorf("%w, due to: %v", errIngestionInterrupted, err)
+			}
+			checkpoint.PartsUploaded++
+			checkpoint.BytesArchived += int64(n)
+
+			if err := app.storeCheckpoint(ctx, fileID, checkpoint, result.hash); err != nil {
+				return archivedFile{}, fmt.Errorf("%w, due to: %v", errIngestionInterrupted, err)
+			}
+		}
+
+		if readErr != nil {
+			break
+		}
+	}
+
+	if err := writer.CompleteUpload(ctx, checkpoint.Location, checkpoint.FilePath, checkpoint.UploadID); err != nil {
+		if errors.Is(err, storageerrors.ErrorUploadNotFound) {
+			app.discardCheckpoint(ctx, fileID, checkpoint)
+
+			return archivedFile{}, err
+		}
+
+		return archivedFile{}, fmt.Errorf("%w, due to: %v", errIngestionInterrupted, err)
+	}
+
+	return archivedFile{location: checkpoint.Location, filePath: checkpoint.FilePath}, nil
+}
+
+// resumeFromCheckpoint restores the state of the file hash, and skips past the content which has already been archived
+func resumeFromCheckpoint(checkpoint *database.IngestCheckpoint, result decryptResult) error {
+	unmarshaler, ok := result.hash.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return errors.New("file hash state can not be restored")
+	}
+	if err := unmarshaler.UnmarshalBinary(checkpoint.HashState); err != nil {
+		return fmt.Errorf("failed to restore file hash state, due to: %v", err)
+	}
+
+	// The archived content is skipped in the source directly, as it is already included in the restored hash state
+	if seeker, ok := result.source.(io.Seeker); ok {
+		if _, err := seeker.Seek(checkpoint.BytesArchived, io.SeekCurrent); err != nil {
+			return fmt.Errorf("failed to seek past archived content, due to: %v", err)
+		}
+
+		return nil
 	}
+	if _, err := io.CopyN(io.Discard, result.source, checkpoint.BytesArchived); err != nil {
+		return fmt.Errorf("failed to skip archived content, due to: %v", err)
+	}
+
+	return nil
+}
 
-	location, err := app.ArchiveWriter.WriteFile(ctx, fileID, reader)
+// storeCheckpoint stores the checkpoint together with the current state of the file hash
+func (app *Ingest) storeCheckpoint(ctx context.Context, fileID string, checkpoint *database.IngestCheckpoint, fileHash hash.Hash) error {
+	marshaler, ok := fileHash.(encoding.BinaryMarshaler)
+	if !ok {
+		return errors.New("file hash state can not be stored")
+	}
+	hashState, err := marshaler.MarshalBinary()
 	if err != nil {
-		return "", err
+		return fmt.Errorf("failed to marshal file hash state, due to: %v", err)
+	}
+	checkpoint.HashState = hashState
+
+	if err := app.db.SetIngestCheckpoint(ctx, fileID, checkpoint); err != nil {
+		return fmt.Errorf("failed to store ingest checkpoint, due to: %v", err)
+	}
+
+	return nil
+}
+
+// discardCheckpoint aborts the upload of the checkpoint and removes the checkpoint, such that the next ingestion of the
+// file starts from the beginning, errors are only logged as the upload will be orphaned at worst
+func (app *Ingest) discardCheckpoint(ctx context.Context, fileID string, checkpoint *database.IngestCheckpoint) {
+	if resumableWriter, ok := storage.AsResumableWriter(app.ArchiveWriter); ok {
+		err := resumableWriter.AbortUpload(ctx, checkpoint.Location, checkpoint.FilePath, checkpoint.UploadID)
+		if err != nil && !errors.Is(err, storageerrors.ErrorUploadNotFound) {
+			log.Errorf("failed to abort archive upload of file: %s, due to: %v", fileID, err)
+		}
 	}
 
-	return location, nil
+	if err := app.db.DeleteIngestCheckpoint(ctx, fileID); err != nil {
+		log.Errorf("failed to delete ingest checkpoint of file: %s, due to: %v", fileID, err)
+	}
 }
 
-func (app *Ingest) finalizeDatabaseRecords(ctx context.Context, fileID, location, checksum string, message *brokerv2.Message) error {
+// finalizeDatabaseRecords marks the file as archived, and returns where the file was archived, which differs from the
+// given archived file when a shared object was removed before it could be referenced
+func (app *Ingest) finalizeDatabaseRecords(ctx context.Context, fileID string, archived archivedFile, checksum string, message *brokerv2.Message) (archivedFile, error) {
 	log.Infof("finalizeDatabaseRecords: fileID=%s checksum=%s", fileID, checksum)
-	fileSize, err := app.ArchiveReader.GetFileSize(ctx, location, fileID)
+
+	status, err := app.db.GetFileStatus(ctx, fileID)
 	if err != nil {
-		return err
+		return archived, err
+	}
+
+	tx, err := app.db.BeginTransaction(ctx)
+	if err != nil {
+		return archived, fmt.Errorf("failed to begin transaction, due to: %v", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var fileSize int64
+	if archived.shared {
+		// Lock the archive object so it can not be removed before being referenced
+		archiveObject, err := tx.GetArchiveObject(ctx, archived.contentChecksum, archived.contentSize)
+		if err != nil {
+			return archived, err
+		}
+		switch {
+		case archiveObject != nil:
+			archived.location, archived.filePath, fileSize = archiveObject.Location, archiveObject.FilePath, archiveObject.FileSize
+		case archived.copyFilePath == "":
+			return archived, fmt.Errorf("archived content of file: %s was removed before it could be referenced", fileID)
+		default:
+			log.Warnf("archived content of file: %s was removed before it could be referenced, keeping the archived copy", fileID)
+			archived.location, archived.filePath, archived.shared = archived.copyLocation, archived.copyFilePath, false
+			archived.backupLocation = archived.copyBackupLocation
+		}
+	}
+	if !archived.shared {
+		fileSize, err = app.ArchiveReader.GetFileSize(ctx, archived.location, archived.filePath)
+		if err != nil {
+			return archived, err
+		}
+	}
+
+	if archived.contentChecksum != "" {
+		if err := tx.ReferenceArchiveObject(ctx, archived.location, archived.filePath, fileSize, archived.contentChecksum); err != nil {
+			return archived, fmt.Errorf("failed to reference archive object, file-id: %s, due to: %v", fileID, err)
+		}
+	}
+
+	if err := tx.DeleteIngestCheckpoint(ctx, fileID); err != nil {
+		return archived, fmt.Errorf("failed to delete ingest checkpoint, file-id: %s, due to: %v", fileID, err)
 	}
 
 	fileInfo := new(database.FileInfo)
-	fileInfo.Path = fileID
+	fileInfo.Path = archived.filePath
 	fileInfo.Size = fileSize
 	fileInfo.UploadedChecksum = checksum
 
-	status, err := app.db.GetFileStatus(ctx, fileID)
-	if err != nil {
-		return err
+	if err := tx.SetArchived(ctx, archived.location, fileInfo, fileID); err != nil {
+		return archived, fmt.Errorf("failed to mark file as archived, file-id: %s, due to: %v", fileID, err)
+	}
+
+	// A file replicated to the backup storage does not need to be backed up by finalize
+	if archived.backupLocation != "" {
+		if err := tx.SetBackedUp(ctx, archived.backupLocation, archived.filePath, fileID); err != nil {
+			return archived, fmt.Errorf("failed to set backup location, file-id: %s, due to: %v", fileID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return archived, fmt.Errorf("failed to commit transaction, file-id: %s, due to: %v", fileID, err)
 	}
 
-	if err := app.db.SetArchived(ctx, location, fileInfo, fileID); err != nil {
-		return fmt.Errorf("failed to mark file as archived, file-id: %s, due to: %v", fileID, err)
+	if archived.shared && archived.copyFilePath != "" {
+		app.removeArchiveCopy(ctx, archived.copyLocation, archived.copyBackupLocation, archived.copyFilePath)
 	}
 
 	if status == "disabled" {
-		return nil
+		return archived, nil
 	}
 
 	if err := app.db.UpdateFileEventLog(ctx, fileID, "archived", "ingest", "{}", string(message.Body)); err != nil {
-		return fmt.Errorf("failed to update file event log, file-id: %s due to: %v", fileID, err)
+		return archived, fmt.Errorf("failed to update file event log, file-id: %s due to: %v", fileID, err)
 	}
 
-	return nil
+	return archived, nil
 }
 
-func (app *Ingest) notifyArchived(ctx context.Context, fileID, filePath, user, checksum, archivedQueue string, message *brokerv2.Message) error {
+func (app *Ingest) notifyArchived(ctx context.Context, fileID, filePath, user, archivePath, checksum, archivedQueue string, message *brokerv2.Message) error {
 	msg := schema.IngestionVerification{
 		User:               user,
 		FilePath:           filePath,
 		FileID:             fileID,
-		ArchivePath:        fileID,
+		ArchivePath:        archivePath,
 		EncryptedChecksums: []schema.Checksums{{Type: "sha256", Value: checksum}},
 	}
 
@@ -511,10 +935,5 @@ func (app *Ingest) setErrorEvent(details string, message *brokerv2.Message) func
 }
 
 func (app *Ingest) errorQueue(message *brokerv2.Message) func() {
-	return func() {
-		if err := app.Broker.Publish(context.Background(), "error", *message); err != nil {
-			log.Errorf("failed to publish to error queue: %v", err)
-		}
-		log.Infof("published message: %s to error queue", message.Key)
-	}
+	return brokerv2.ErrorQueueCallback(app.Broker, message, "Failed to ingest file", nil)
 }
@@ -380,6 +380,31 @@ func (ts *TestSuite) TestCancelFile_BaseCase() {
 	assert.Equal(ts.T(), err, nil)
 }
 
+func (ts *TestSuite) TestCancelFile_ArchivedObjectMissing() {
+	userName := "test-cancel"
+	file1 := fmt.Sprintf("/%v/TestCancelMessage_missingObject.c4gh", userName)
+	fileID, err := ts.ingest.db.RegisterFile(context.Background(), nil, "/inbox", file1, userName)
+	ts.NoError(err, "failed to register file in database")
+	ts.NoError(ts.ingest.db.UpdateFileEventLog(context.Background(), fileID, "uploaded", userName, "{}", "{}"))
+
+	ts.NoError(ts.ingest.db.SetArchived(context.Background(), ts.archiveDir, &database.FileInfo{
+		ArchivedChecksum:  "123",
+		Size:              500,
+		Path:              fileID,
+		DecryptedChecksum: "321",
+		DecryptedSize:     550,
+		UploadedChecksum:  "abc",
+	}, fileID))
+
+	// The file is cancelled even though its archived object can not be removed
+	_, err = ts.ingest.handleMessage(context.Background(), createMessage("cancel", file1, userName, fileID))
+	ts.NoError(err)
+
+	var archivePath string
+	ts.NoError(ts.verificationDB.QueryRow("SELECT archive_file_path FROM sda.files WHERE id = $1;", fileID).Scan(&archivePath))
+	ts.Equal("", archivePath)
+}
+
 func (ts *TestSuite) TestCancelFile_NotArchived() {
 	userName := "test-cancel"
 	file1 := fmt.Sprintf("/%v/TestCancelMessage.c4gh", userName)
@@ -652,6 +677,262 @@ func (ts *TestSuite) TestIngestFile_IngestVerifiedCancelledFileNewChecksum() {
 	assert.NotEqual(ts.T(), dbChecksum, firstDbChecksum)
 }
 
+// ingestDuplicatedFiles ingests the test file, and a copy of it, the files are registered with their uploaded checksum
+// like done by the inbox when uploadedChecksums is set
+func (ts *TestSuite) ingestDuplicatedFiles(uploadedChecksums bool) []string {
+	content, err := os.ReadFile(path.Join(ts.inboxDir, ts.UserName, ts.filePath))
+	if err != nil {
+		ts.FailNow("failed to read test file")
+	}
+	duplicatePath := "duplicate-" + ts.filePath
+	if err := os.WriteFile(path.Join(ts.inboxDir, ts.UserName, duplicatePath), content, 0600); err != nil {
+		ts.FailNow("failed to write duplicated test file")
+	}
+
+	var fileIDs []string
+	for _, filePath := range []string{ts.filePath, duplicatePath} {
+		fileID, err := ts.ingest.db.RegisterFile(context.Background(), nil, ts.inboxDir, filePath, ts.UserName)
+		ts.NoError(err, "failed to register file in database")
+		ts.NoError(ts.ingest.db.UpdateFileEventLog(context.Background(), fileID, "uploaded", ts.UserName, "{}", "{}"))
+		if uploadedChecksums {
+			ts.NoError(ts.ingest.db.AddUploadedChecksum(context.Background(), fileID, fmt.Sprintf("%x", sha256.Sum256(content)), "SHA256"))
+		}
+
+		_, err = ts.ingest.handleMessage(context.Background(), createMessage("ingest", filePath, ts.UserName, fileID))
+		ts.NoError(err, "unexpected error when ingesting file")
+		fileIDs = append(fileIDs, fileID)
+	}
+
+	return fileIDs
+}
+
+func (ts *TestSuite) TestIngestFile_Deduplicated() {
+	ts.ingest.Deduplicate = true
+	defer func() { ts.ingest.Deduplicate = false }()
+
+	fileIDs := ts.ingestDuplicatedFiles(false)
+
+	var archivePaths []string
+	for _, fileID := range fileIDs {
+		archiveData, err := ts.ingest.db.GetArchived(context.Background(), fileID)
+		ts.NoError(err)
+		if archiveData == nil {
+			ts.FailNow("archive data not found")
+
+			return
+		}
+		archivePaths = append(archivePaths, archiveData.FilePath)
+	}
+	ts.Equal(archivePaths[0], archivePaths[1])
+
+	// Only a single object should have been written to the archive
+	entries, err := os.ReadDir(ts.archiveDir)
+	ts.NoError(err)
+	ts.Len(entries, 1)
+	ts.Equal(archivePaths[0], entries[0].Name())
+
+	var referenceCount int
+	ts.NoError(ts.verificationDB.QueryRow("SELECT reference_count FROM sda.archive_objects WHERE archive_file_path = $1;", archivePaths[0]).Scan(&referenceCount))
+	ts.Equal(2, referenceCount)
+}
+
+func (ts *TestSuite) TestIngestFile_DeduplicatedByUploadedChecksum() {
+	ts.ingest.Deduplicate = true
+	defer func() { ts.ingest.Deduplicate = false }()
+	writer := &CountingWriter{Writer: ts.ingest.ArchiveWriter}
+	ts.ingest.ArchiveWriter = writer
+
+	fileIDs := ts.ingestDuplicatedFiles(true)
+
+	// The content of the copy is not written, as it was uploaded with the same checksum as the archived file
+	ts.Equal(1, writer.written)
+
+	var archivePaths []string
+	for _, fileID := range fileIDs {
+		archiveData, err := ts.ingest.db.GetArchived(context.Background(), fileID)
+		ts.NoError(err)
+		if archiveData == nil {
+			ts.FailNow("archive data not found")
+
+			return
+		}
+		archivePaths = append(archivePaths, archiveData.FilePath)
+	}
+	ts.Equal(archivePaths[0], archivePaths[1])
+
+	entries, err := os.ReadDir(ts.archiveDir)
+	ts.NoError(err)
+	ts.Len(entries, 1)
+
+	var referenceCount int
+	ts.NoError(ts.verificationDB.QueryRow("SELECT reference_count FROM sda.archive_objects WHERE archive_file_path = $1;", archivePaths[0]).Scan(&referenceCount))
+	ts.Equal(2, referenceCount)
+}
+
+func (ts *TestSuite) TestCancelFile_SharedArchiveObject() {
+	ts.ingest.Deduplicate = true
+	defer func() { ts.ingest.Deduplicate = false }()
+
+	fileIDs := ts.ingestDuplicatedFiles(false)
+	archiveData, err := ts.ingest.db.GetArchived(context.Background(), fileIDs[0])
+	ts.NoError(err)
+
+	// The shared object is kept as long as any file references it
+	_, err = ts.ingest.handleMessage(context.Background(), createMessage("cancel", ts.filePath, ts.UserName, fileIDs[0]))
+	ts.NoError(err)
+	ts.FileExists(filepath.Join(ts.archiveDir, archiveData.FilePath))
+
+	_, err = ts.ingest.handleMessage(context.Background(), createMessage("cancel", "duplicate-"+ts.filePath, ts.UserName, fileIDs[1]))
+	ts.NoError(err)
+	ts.NoFileExists(filepath.Join(ts.archiveDir, archiveData.FilePath))
+
+	var count int
+	ts.NoError(ts.verificationDB.QueryRow("SELECT COUNT(*) FROM sda.archive_objects WHERE archive_file_path = $1;", archiveData.FilePath).Scan(&count))
+	ts.Equal(0, count)
+}
+
+func (ts *TestSuite) TestIngestFile_Resumable() {
+	writer := NewMockResumableWriter(ts.archiveDir, 1024*1024)
+	ts.ingest.ArchiveWriter = writer
+
+	fileID, err := ts.ingest.db.RegisterFile(context.Background(), nil, ts.inboxDir, ts.filePath, ts.UserName)
+	ts.NoError(err, "failed to register file in database")
+	ts.NoError(ts.ingest.db.UpdateFileEventLog(context.Background(), fileID, "uploaded", ts.UserName, "{}", "{}"))
+
+	_, err = ts.ingest.handleMessage(context.Background(), createMessage("ingest", ts.filePath, ts.UserName, fileID))
+	ts.NoError(err)
+
+	uploaded, err := os.ReadFile(path.Join(ts.inboxDir, ts.UserName, ts.filePath))
+	ts.NoError(err)
+	archived, err := os.ReadFile(filepath.Join(ts.archiveDir, fileID))
+	ts.NoError(err)
+	ts.True(bytes.HasSuffix(uploaded, archived), "archived content does not match the uploaded file")
+	ts.Less(len(archived), len(uploaded))
+
+	checkpoint, err := ts.ingest.db.GetIngestCheckpoint(context.Background(), fileID)
+	ts.NoError(err)
+	ts.Nil(checkpoint)
+}
+
+func (ts *TestSuite) TestIngestFile_ResumableMaxParts() {
+	writer := NewMockResumableWriter(ts.archiveDir, 1024*1024)
+	writer.maxParts = 4
+	ts.ingest.ArchiveWriter = writer
+
+	fileID, err := ts.ingest.db.RegisterFile(context.Background(), nil, ts.inboxDir, ts.filePath, ts.UserName)
+	ts.NoError(err, "failed to register file in database")
+	ts.NoError(ts.ingest.db.UpdateFileEventLog(context.Background(), fileID, "uploaded", ts.UserName, "{}", "{}"))
+
+	_, err = ts.ingest.handleMessage(context.Background(), createMessage("ingest", ts.filePath, ts.UserName, fileID))
+	ts.NoError(err)
+
+	// The 10mb file needs bigger parts than 1mb to fit in 4 parts
+	ts.LessOrEqual(writer.partsUploaded, 4)
+	uploaded, err := os.ReadFile(path.Join(ts.inboxDir, ts.UserName, ts.filePath))
+	ts.NoError(err)
+	archived, err := os.ReadFile(filepath.Join(ts.archiveDir, fileID))
+	ts.NoError(err)
+	ts.True(bytes.HasSuffix(uploaded, archived), "archived content does not match the uploaded file")
+}
+
+func (ts *TestSuite) TestIngestFile_ResumeInterrupted() {
+	partSize := int64(1024 * 1024)
+	writer := NewMockResumableWriter(ts.archiveDir, partSize)
+	writer.failAtPart = 3
+	ts.ingest.ArchiveWriter = writer
+
+	fileID, err := ts.ingest.db.RegisterFile(context.Background(), nil, ts.inboxDir, ts.filePath, ts.UserName)
+	ts.NoError(err, "failed to register file in database")
+	ts.NoError(ts.ingest.db.UpdateFileEventLog(context.Background(), fileID, "uploaded", ts.UserName, "{}", "{}"))
+
+	// The message is to be redelivered when the ingestion is interrupted
+	_, err = ts.ingest.handleMessage(context.Background(), createMessage("ingest", ts.filePath, ts.UserName, fileID))
+	ts.ErrorIs(err, errIngestionInterrupted)
+
+	checkpoint, err := ts.ingest.db.GetIngestCheckpoint(context.Background(), fileID)
+	ts.NoError(err)
+	if checkpoint == nil {
+		ts.FailNow("ingest checkpoint not found")
+
+		return
+	}
+	ts.Equal(int32(2), checkpoint.PartsUploaded)
+	ts.Equal(2*partSize, checkpoint.BytesArchived)
+	ts.Equal(partSize, checkpoint.PartSize)
+
+	status, err := ts.ingest.db.GetFileStatus(context.Background(), fileID)
+	ts.NoError(err)
+	ts.Equal("submitted", status)
+
+	writer.failAtPart = 0
+	_, err = ts.ingest.handleMessage(context.Background(), createMessage("ingest", ts.filePath, ts.UserName, fileID))
+	ts.NoError(err)
+
+	uploaded, err := os.ReadFile(path.Join(ts.inboxDir, ts.UserName, ts.filePath))
+	ts.NoError(err)
+	archived, err := os.ReadFile(filepath.Join(ts.archiveDir, fileID))
+	ts.NoError(err)
+	ts.True(bytes.HasSuffix(uploaded, archived), "archived content does not match the uploaded file")
+
+	// Parts uploaded before the interruption should not have been uploaded again
+	ts.Equal(int((int64(len(archived))+partSize-1)/partSize), writer.partsUploaded)
+
+	var uploadedChecksum string
+	ts.NoError(ts.verificationDB.QueryRow("SELECT checksum FROM sda.checksums WHERE file_id = $1 AND source = 'UPLOADED';", fileID).Scan(&uploadedChecksum))
+	ts.Equal(fmt.Sprintf("%x", sha256.Sum256(uploaded)), uploadedChecksum)
+
+	checkpoint, err = ts.ingest.db.GetIngestCheckpoint(context.Background(), fileID)
+	ts.NoError(err)
+	ts.Nil(checkpoint)
+}
+
+func (ts *TestSuite) TestIngestFile_Replicated() {
+	backupDir := ts.T().TempDir()
+	viper.Set("storage.backup.posix", []map[string]any{{"path": backupDir}})
+	defer viper.Set("storage.backup.posix", nil)
+
+	lb, err := locationbroker.NewLocationBroker(ts.ingest.db)
+	ts.NoError(err)
+	ts.ingest.ArchiveReplicator, err = storage.NewReplicatingWriter(context.Background(), []string{"archive", "backup"}, lb)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.ingest.BackupWriter, err = storage.NewWriter(context.Background(), "backup", lb)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	defer func() { ts.ingest.ArchiveReplicator, ts.ingest.BackupWriter = nil, nil }()
+
+	fileID, err := ts.ingest.db.RegisterFile(context.Background(), nil, ts.inboxDir, ts.filePath, ts.UserName)
+	ts.NoError(err, "failed to register file in database")
+	ts.NoError(ts.ingest.db.UpdateFileEventLog(context.Background(), fileID, "uploaded", ts.UserName, "{}", "{}"))
+
+	_, err = ts.ingest.handleMessage(context.Background(), createMessage("ingest", ts.filePath, ts.UserName, fileID))
+	ts.NoError(err)
+
+	// The file is written to both the archive and backup in the same pass
+	archived, err := os.ReadFile(filepath.Join(ts.archiveDir, fileID))
+	ts.NoError(err)
+	backedUp, err := os.ReadFile(filepath.Join(backupDir, fileID))
+	ts.NoError(err)
+	ts.Equal(archived, backedUp)
+
+	archiveData, err := ts.ingest.db.GetArchived(context.Background(), fileID)
+	ts.NoError(err)
+	if archiveData == nil {
+		ts.FailNow("archive data not found")
+
+		return
+	}
+	ts.Equal(backupDir, archiveData.BackupLocation)
+	ts.Equal(fileID, archiveData.BackupFilePath)
+
+	_, err = ts.ingest.handleMessage(context.Background(), createMessage("cancel", ts.filePath, ts.UserName, fileID))
+	ts.NoError(err)
+	ts.NoFileExists(filepath.Join(ts.archiveDir, fileID))
+	ts.NoFileExists(filepath.Join(backupDir, fileID))
+}
+
 func (ts *TestSuite) TestIngestFile_MissingFile() {
 	basepath := filepath.Dir(ts.filePath)
 	fileID := uuid.NewString()
@@ -4,9 +4,16 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
 
 	broker "github.com/neicnordic/sensitive-data-archive/internal/broker/v2" //nolint: revive
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
 )
 
 type MockBroker struct{}
@@ -44,3 +51,95 @@ func (r *MockReader) GetFileSize(_ context.Context, _, _ string) (int64, error)
 	return int64(len(r.data)), nil
 }
 func (r *MockReader) Ping(_ context.Context) error { return nil }
+
+// MockResumableWriter writes completed uploads to a posix directory, keeping uploaded parts in memory until the upload is
+// completed. Uploading the part with number failAtPart fails
+type MockResumableWriter struct {
+	dir        string
+	partSize   int64
+	failAtPart int32
+	// maxParts is the maximum amount of parts of an upload, unlimited if 0
+	maxParts int64
+
+	uploads       map[string]map[int32][]byte
+	partsUploaded int
+}
+
+func NewMockResumableWriter(dir string, partSize int64) *MockResumableWriter {
+	return &MockResumableWriter{dir: dir, partSize: partSize, uploads: make(map[string]map[int32][]byte)}
+}
+
+func (w *MockResumableWriter) WriteFile(_ context.Context, filePath string, fileContent io.Reader) (string, error) {
+	content, err := io.ReadAll(fileContent)
+	if err != nil {
+		return "", err
+	}
+
+	return w.dir, os.WriteFile(filepath.Join(w.dir, filePath), content, 0600)
+}
+func (w *MockResumableWriter) RemoveFile(_ context.Context, _, filePath string) error {
+	return os.Remove(filepath.Join(w.dir, filePath))
+}
+func (w *MockResumableWriter) StartUpload(_ context.Context, _ string) (string, string, error) {
+	uploadID := fmt.Sprintf("upload-%d", len(w.uploads)+1)
+	w.uploads[uploadID] = make(map[int32][]byte)
+
+	return w.dir, uploadID, nil
+}
+func (w *MockResumableWriter) PartSize(_ string, fileSize int64) (int64, error) {
+	if w.maxParts > 0 {
+		return max(w.partSize, (fileSize+w.maxParts-1)/w.maxParts), nil
+	}
+
+	return w.partSize, nil
+}
+func (w *MockResumableWriter) UploadPart(_ context.Context, _, _, uploadID string, partNumber int32, partContent io.ReadSeeker) error {
+	if partNumber == w.failAtPart {
+		return errors.New("mock upload part error")
+	}
+	parts, ok := w.uploads[uploadID]
+	if !ok {
+		return storageerrors.ErrorUploadNotFound
+	}
+	content, err := io.ReadAll(partContent)
+	if err != nil {
+		return err
+	}
+	parts[partNumber] = content
+	w.partsUploaded++
+
+	return nil
+}
+func (w *MockResumableWriter) CompleteUpload(_ context.Context, _, filePath, uploadID string) error {
+	parts, ok := w.uploads[uploadID]
+	if !ok {
+		return storageerrors.ErrorUploadNotFound
+	}
+	var content []byte
+	for _, partNumber := range slices.Sorted(maps.Keys(parts)) {
+		content = append(content, parts[partNumber]...)
+	}
+	delete(w.uploads, uploadID)
+
+	return os.WriteFile(filepath.Join(w.dir, filePath), content, 0600)
+}
+func (w *MockResumableWriter) AbortUpload(_ context.Context, _, _, uploadID string) error {
+	if _, ok := w.uploads[uploadID]; !ok {
+		return storageerrors.ErrorUploadNotFound
+	}
+	delete(w.uploads, uploadID)
+
+	return nil
+}
+
+// CountingWriter counts the files written through the wrapped writer
+type CountingWriter struct {
+	storage.Writer
+	written int
+}
+
+func (w *CountingWriter) WriteFile(ctx context.Context, filePath string, fileContent io.Reader) (string, error) {
+	w.written++
+
+	return w.Writer.WriteFile(ctx, filePath, fileContent)
+}
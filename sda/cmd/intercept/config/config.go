@@ -0,0 +1,30 @@
+package config
+
+import (
+	config "github.com/neicnordic/sensitive-data-archive/internal/config/v2"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var (
+	sourceQueue string
+)
+
+func init() {
+	config.RegisterFlags(
+		&config.Flag{
+			Name: "sourceQueue",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "from_cega", "The queue where the intercept service consumes messages from CentralEGA from")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				sourceQueue = viper.GetString(flagName)
+			},
+		},
+	)
+}
+
+func SourceQueue() string {
+	return sourceQueue
+}
@@ -3,12 +3,18 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
-
-	"github.com/neicnordic/sensitive-data-archive/internal/broker"
-	"github.com/neicnordic/sensitive-data-archive/internal/config"
-
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	interceptconf "github.com/neicnordic/sensitive-data-archive/cmd/intercept/config"
+	brokerv2 "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/broker/v2/factory"
+	configv2 "github.com/neicnordic/sensitive-data-archive/internal/config/v2"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -21,86 +27,99 @@ const (
 	msgDeprecate string = "deprecate"
 )
 
+// routing maps the message types to the queues they are relayed to
+var routing = map[string]string{
+	msgAccession: "accession",
+	msgCancel:    "ingest",
+	msgIngest:    "ingest",
+	msgMapping:   "mappings",
+	msgRelease:   "mappings",
+	msgDeprecate: "mappings",
+}
+
+// undeliverableQueue is the queue messages of unknown type are relayed to
+const undeliverableQueue = "undeliverable"
+
+type Intercept struct {
+	Broker brokerv2.Broker
+}
+
 func main() {
-	forever := make(chan bool)
-	conf, err := config.NewConfig("intercept")
-	if err != nil {
+	if err := run(); err != nil {
 		log.Fatal(err)
 	}
-	mq, err := broker.NewMQ(conf.Broker)
+}
+
+func run() error {
+	var err error
+	app := Intercept{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err = configv2.Load(); err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
+
+	app.Broker, err = factory.NewBroker(ctx)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("failed to initialize mq broker, due to: %v", err)
 	}
+	defer func() {
+		if err := app.Broker.Close(); err != nil {
+			log.Errorf("could not close Broker, due to: %v", err)
+		}
+	}()
+	log.Info("Starting intercept service")
 
-	defer mq.Channel.Close()
-	defer mq.Connection.Close()
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 
+	consumeErr := make(chan error, 1)
 	go func() {
-		connError := mq.ConnectionWatcher()
-		log.Error(connError)
-		forever <- false
+		consumeErr <- app.Broker.Subscribe(ctx, interceptconf.SourceQueue(), app.handleMessage)
 	}()
 
-	go func() {
-		connError := mq.ChannelWatcher()
-		log.Error(connError)
-		forever <- false
-	}()
+	select {
+	case sig := <-sigc:
+		log.Infof("recieved signal: %v, shutting down gracefully", sig)
+		cancel()
 
-	log.Info("Starting intercept service")
+		return nil
+	case err := <-consumeErr:
+		if !errors.Is(err, context.Canceled) {
+			log.Errorf("failed to consume from %s, due to: %v", interceptconf.SourceQueue(), err)
+			cancel()
 
-	go func() {
-		messages, err := mq.GetMessages(conf.Broker.Queue)
-		if err != nil {
-			log.Fatal(err)
+			return err
 		}
-		for delivered := range messages {
-			log.Debugf("Received a message: %s", delivered.Body)
-
-			msgType, err := typeFromMessage(delivered.Body)
-			if err != nil {
-				log.Errorf("Failed to get type for message (%v), reason: %v", msgType, err.Error())
-				if err := delivered.Ack(false); err != nil {
-					log.Errorf("Failed acking canceled work, reason: (%v)", err)
-				}
-				// Restart on new message
-				continue
-			}
-
-			routing := map[string]string{
-				msgAccession: "accession",
-				msgCancel:    "ingest",
-				msgIngest:    "ingest",
-				msgMapping:   "mappings",
-				msgRelease:   "mappings",
-				msgDeprecate: "mappings",
-			}
-
-			routingKey := routing[msgType]
-
-			if routingKey == "" {
-				log.Debugf("msg type: %s", msgType)
-				if err := mq.SendMessage(delivered.CorrelationId, conf.Broker.Exchange, "undeliverable", delivered.Body); err != nil {
-					log.Errorf("failed to publish message, reason: (%v)", err)
-				}
-				if err := delivered.Ack(false); err != nil {
-					log.Errorf("failed to ack message for reason: %v", err)
-				}
-
-				continue
-			}
-
-			log.Infof("Routing message (correlation-id: %s, routingkey: %s)", delivered.CorrelationId, routingKey)
-			if err := mq.SendMessage(delivered.CorrelationId, conf.Broker.Exchange, routingKey, delivered.Body); err != nil {
-				log.Errorf("failed to publish message, reason: (%v)", err)
-			}
-			if err := delivered.Ack(false); err != nil {
-				log.Errorf("failed to ack message for reason: %v", err)
-			}
-		}
-	}()
 
-	<-forever
+		return nil
+	}
+}
+
+func (app *Intercept) handleMessage(ctx context.Context, delivered *brokerv2.Message) ([]func(), error) {
+	log.Debugf("Received a message: %s", delivered.Body)
+
+	msgType, err := typeFromMessage(delivered.Body)
+	if err != nil {
+		log.Errorf("Failed to get type for message (%v), reason: %v", msgType, err.Error())
+
+		return []func(){brokerv2.ErrorQueueCallback(app.Broker, delivered, "Failed to get type of message", err)}, nil
+	}
+
+	routingKey, ok := routing[msgType]
+	if !ok {
+		log.Debugf("msg type: %s", msgType)
+		routingKey = undeliverableQueue
+	}
+
+	log.Infof("Routing message (correlation-id: %s, routingkey: %s)", delivered.Key, routingKey)
+	if err := app.Broker.Publish(ctx, routingKey, brokerv2.Message{Key: delivered.Key, Body: delivered.Body}); err != nil {
+		return nil, fmt.Errorf("failed to publish message, reason: %v", err)
+	}
+
+	return nil, nil
 }
 
 // typeFromMessage returns the type value given a JSON structure for the message
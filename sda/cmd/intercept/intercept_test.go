@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"testing"
 
+	brokerv2 "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/broker/v2/memory"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -125,3 +128,38 @@ func (ts *TestSuite) TestMessageSelection_Notype() {
 	assert.Error(ts.T(), err, "Unexpected lack of error from typeFromMessage")
 	assert.Equal(ts.T(), "", msgType, "message type from message does not match expected")
 }
+
+func (ts *TestSuite) TestHandleMessage() {
+	broker := memory.NewMemoryBroker()
+	app := Intercept{Broker: broker}
+
+	for body, queue := range map[string]string{
+		`{"type": "accession", "user": "foo"}`:            "accession",
+		`{"type": "cancel", "user": "foo"}`:               "ingest",
+		`{"type": "release", "dataset_id": "EGAD0001"}`:   "mappings",
+		`{"type": "unknown", "dataset_id": "EGAD0001"}`:   undeliverableQueue,
+		`{"type": "deprecate", "dataset_id": "EGAD0001"}`: "mappings",
+	} {
+		callbacks, err := app.handleMessage(context.TODO(), &brokerv2.Message{Key: "correlation-id", Body: []byte(body)})
+		assert.NoError(ts.T(), err)
+		assert.Empty(ts.T(), callbacks)
+
+		messages := broker.Messages(queue)
+		assert.Equal(ts.T(), []byte(body), messages[len(messages)-1].Body)
+		assert.Equal(ts.T(), "correlation-id", messages[len(messages)-1].Key)
+	}
+	assert.Len(ts.T(), broker.Messages("mappings"), 2)
+}
+
+func (ts *TestSuite) TestHandleMessage_Notype() {
+	broker := memory.NewMemoryBroker()
+	app := Intercept{Broker: broker}
+
+	callbacks, err := app.handleMessage(context.TODO(), &brokerv2.Message{Body: []byte(`{"user": "foo"}`)})
+	assert.NoError(ts.T(), err)
+	for _, callback := range callbacks {
+		callback()
+	}
+	assert.Len(ts.T(), broker.Messages(brokerv2.ErrorQueue), 1)
+	assert.Empty(ts.T(), broker.Messages(undeliverableQueue))
+}
@@ -1,20 +1,195 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"io"
 	"testing"
 
+	mapperconf "github.com/neicnordic/sensitive-data-archive/cmd/mapper/config"
+	brokerv2 "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/broker/v2/memory"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/suite"
 )
 
 type TestSuite struct {
 	suite.Suite
+	db     *mockDatabase
+	broker *memory.Broker
+	inbox  *mockWriter
+	app    Mapper
 }
 
 func TestConfigTestSuite(t *testing.T) {
 	suite.Run(t, new(TestSuite))
 }
 
+// mockDatabase implements the database functions used by mapper, calling any other function panics
+type mockDatabase struct {
+	database.Database
+	tx *mockTransaction
+}
+
+func (m *mockDatabase) BeginTransaction(_ context.Context) (database.Transaction, error) {
+	return m.tx, nil
+}
+
+type mockTransaction struct {
+	database.Transaction
+	files         map[string]*database.MappingData
+	mapped        []string
+	datasetEvents []string
+	datasetErr    error
+	committed     bool
+	rolledBack    bool
+}
+
+func (m *mockTransaction) GetMappingData(_ context.Context, accessionID string) (*database.MappingData, error) {
+	return m.files[accessionID], nil
+}
+
+func (m *mockTransaction) MapFileToDataset(_ context.Context, _, fileID string) error {
+	m.mapped = append(m.mapped, fileID)
+
+	return nil
+}
+
+func (m *mockTransaction) UpdateDatasetEvent(_ context.Context, _, status, _ string) error {
+	if m.datasetErr != nil {
+		return m.datasetErr
+	}
+	m.datasetEvents = append(m.datasetEvents, status)
+
+	return nil
+}
+
+func (m *mockTransaction) Commit() error {
+	m.committed = true
+
+	return nil
+}
+
+func (m *mockTransaction) Rollback() error {
+	m.rolledBack = !m.committed
+
+	return nil
+}
+
+type mockWriter struct {
+	removed []string
+}
+
+func (w *mockWriter) WriteFile(_ context.Context, _ string, _ io.Reader) (string, error) {
+	return "", errors.New("not implemented")
+}
+
+func (w *mockWriter) RemoveFile(_ context.Context, location, filePath string) error {
+	w.removed = append(w.removed, location+"/"+filePath)
+
+	return nil
+}
+
+func (ts *TestSuite) SetupSuite() {
+	mapperconf.SetSchemaPath("../../schemas/isolated")
+}
+
 func (ts *TestSuite) SetupTest() {
 	viper.Set("log.level", "debug")
+
+	ts.db = &mockDatabase{tx: &mockTransaction{files: map[string]*database.MappingData{
+		"EGAF00000000001": {FileID: "file-1", User: "user@example.org", SubmissionFilePath: "dummy.c4gh", SubmissionLocation: "/inbox"},
+		"EGAF00000000002": {FileID: "file-2", User: "user@example.org", SubmissionFilePath: "other.c4gh"},
+	}}}
+	ts.broker = memory.NewMemoryBroker()
+	ts.inbox = &mockWriter{}
+	ts.app = Mapper{
+		InboxWriter: ts.inbox,
+		Broker:      ts.broker,
+		db:          ts.db,
+	}
+}
+
+func runCallbacks(callbacks []func()) {
+	for _, callback := range callbacks {
+		callback()
+	}
+}
+
+func (ts *TestSuite) TestHandleMessage_Mapping() {
+	message := &brokerv2.Message{Body: []byte(`{"type": "mapping", "dataset_id": "EGAD00000000001", "accession_ids": ["EGAF00000000001", "EGAF00000000002"]}`)}
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), message)
+	ts.NoError(err)
+	ts.Empty(callbacks)
+	ts.Equal([]string{"file-1", "file-2"}, ts.db.tx.mapped)
+	ts.Equal([]string{"registered"}, ts.db.tx.datasetEvents)
+	ts.True(ts.db.tx.committed)
+	// Only files with a known submission location are removed from the inbox
+	ts.Equal([]string{"/inbox/user_example.org/dummy.c4gh"}, ts.inbox.removed)
+}
+
+func (ts *TestSuite) TestHandleMessage_Release() {
+	message := &brokerv2.Message{Body: []byte(`{"type": "release", "dataset_id": "EGAD00000000001"}`)}
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), message)
+	ts.NoError(err)
+	ts.Empty(callbacks)
+	ts.Equal([]string{"released"}, ts.db.tx.datasetEvents)
+	ts.True(ts.db.tx.committed)
+}
+
+func (ts *TestSuite) TestHandleMessage_UnknownAccessionID() {
+	message := &brokerv2.Message{Body: []byte(`{"type": "mapping", "dataset_id": "EGAD00000000001", "accession_ids": ["EGAF00000000003"]}`)}
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), message)
+	ts.NoError(err)
+	runCallbacks(callbacks)
+	ts.Len(ts.broker.Messages(brokerv2.ErrorQueue), 1)
+	ts.False(ts.db.tx.committed)
+	ts.True(ts.db.tx.rolledBack)
+}
+
+func (ts *TestSuite) TestHandleMessage_DatasetEventFailed() {
+	ts.db.tx.datasetErr = errors.New("dataset not registered")
+	message := &brokerv2.Message{Body: []byte(`{"type": "deprecate", "dataset_id": "EGAD00000000001"}`)}
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), message)
+	ts.NoError(err)
+	runCallbacks(callbacks)
+	ts.Len(ts.broker.Messages(brokerv2.ErrorQueue), 1)
+	ts.False(ts.db.tx.committed)
+}
+
+func (ts *TestSuite) TestHandleMessage_InvalidMessage() {
+	for _, body := range []string{
+		`{"type": "unknown", "dataset_id": "EGAD00000000001"}`,
+		`{"dataset_id": "EGAD00000000001"}`,
+		`{"type": "mapping", "dataset_id": "EGAD00000000001"}`,
+		`not json`,
+	} {
+		ts.SetupTest()
+
+		callbacks, err := ts.app.handleMessage(context.TODO(), &brokerv2.Message{Body: []byte(body)})
+		ts.NoError(err, body)
+		runCallbacks(callbacks)
+		ts.Len(ts.broker.Messages(brokerv2.ErrorQueue), 1, body)
+		ts.False(ts.db.tx.committed, body)
+	}
+}
+
+func (ts *TestSuite) TestSchemaFromDatasetOperation() {
+	for operation, expected := range map[string]string{
+		"mapping":   "dataset-mapping",
+		"release":   "dataset-release",
+		"deprecate": "dataset-deprecate",
+	} {
+		schemaType, err := schemaFromDatasetOperation([]byte(`{"type": "` + operation + `"}`))
+		ts.NoError(err)
+		ts.Equal(expected, schemaType)
+	}
+
+	_, err := schemaFromDatasetOperation([]byte(`{"type": 1}`))
+	ts.EqualError(err, "could not cast operation attribute to string")
 }
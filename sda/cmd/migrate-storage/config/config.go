@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+
+	config "github.com/neicnordic/sensitive-data-archive/internal/config/v2"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var (
+	sourceQueue string
+	schemaPath  string
+	rateLimit   int64
+)
+
+func init() {
+	config.RegisterFlags(
+		&config.Flag{
+			Name: "sourceQueue",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "migratestorage", "The queue where the migrate-storage service consumes migration messages from")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				sourceQueue = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "schemaType",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "isolated", "Path to JSON schemas to validate rabbitmq messages against")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				schemaType := viper.GetString("schemaType")
+				switch schemaType {
+				case "federated":
+					schemaPath = "/schemas/federated/"
+				case "isolated":
+					schemaPath = "/schemas/isolated/"
+				default:
+					panic(fmt.Sprintf("schema.type '%s' not supported, needs: <federated|isolated>", schemaType))
+				}
+			},
+		},
+		&config.Flag{
+			Name: "rateLimit",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Int64(flagName, 50*1024*1024, "Maximum amount of bytes per second to copy between archive locations, 0 disables the limit")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				rateLimit = viper.GetInt64(flagName)
+			},
+		},
+	)
+}
+
+func SourceQueue() string {
+	return sourceQueue
+}
+
+func SchemaPath() string {
+	return schemaPath
+}
+
+func RateLimit() int64 {
+	return rateLimit
+}
+
+func SetSchemaPath(path string) {
+	schemaPath = path
+}
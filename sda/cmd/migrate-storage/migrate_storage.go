@@ -0,0 +1,360 @@
+// The migrate-storage service moves the archive copies of archived files from
+// one archive location to another, to rebalance or decommission archive
+// storage.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	migrateconf "github.com/neicnordic/sensitive-data-archive/cmd/migrate-storage/config"
+	brokerv2 "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/broker/v2/factory"
+	configv2 "github.com/neicnordic/sensitive-data-archive/internal/config/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/database/postgres"
+	"github.com/neicnordic/sensitive-data-archive/internal/helper"
+	"github.com/neicnordic/sensitive-data-archive/internal/schema"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/locationbroker"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+type MigrateStorage struct {
+	// ArchiveReader reads from all archive locations, both the locations files are moved from and to
+	ArchiveReader storage.Reader
+	// ArchiveWriter removes the archive copies from the locations files are moved from
+	ArchiveWriter storage.Writer
+	// DestinationWriter writes the archive copies to the locations files are moved to
+	DestinationWriter storage.Writer
+	Broker            brokerv2.Broker
+	db                database.Database
+	// limiter limits the rate at which copies are read from storage, nil if not limited
+	limiter *rate.Limiter
+}
+
+// errCopyCorrupted is returned when a copy does not match the size and checksum of the archived file
+var errCopyCorrupted = errors.New("copy is corrupted")
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run() error {
+	var err error
+	app := MigrateStorage{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err = configv2.Load(); err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
+	if migrateconf.RateLimit() < 0 {
+		return errors.New("rateLimit can not be negative")
+	}
+
+	app.Broker, err = factory.NewBroker(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to initialize mq broker, due to: %v", err)
+	}
+	defer func() {
+		if err := app.Broker.Close(); err != nil {
+			log.Errorf("could not close Broker, due to: %v", err)
+		}
+	}()
+
+	app.db, err = postgres.NewPostgresSQLDatabase()
+	if err != nil {
+		return fmt.Errorf("failed to initialize sda db due to: %v", err)
+	}
+	defer app.db.Close()
+	if dbSchemaVersion, err := app.db.SchemaVersion(); err != nil || dbSchemaVersion < 38 {
+		return errors.Join(errors.New("database schema v38 is required"), err)
+	}
+
+	storageLocationBroker, err := locationbroker.NewLocationBroker(app.db)
+	if err != nil {
+		return fmt.Errorf("failed to initialize location broker, due to: %v", err)
+	}
+	app.ArchiveReader, err = storage.NewReader(ctx, "archive")
+	if err != nil {
+		return fmt.Errorf("failed to initialize archive reader, due to: %v", err)
+	}
+	app.ArchiveWriter, err = storage.NewWriter(ctx, "archive", storageLocationBroker)
+	if err != nil {
+		return fmt.Errorf("failed to initialize archive writer, due to: %v", err)
+	}
+	app.DestinationWriter, err = storage.NewWriter(ctx, "destination", storageLocationBroker)
+	if err != nil {
+		return fmt.Errorf("failed to initialize destination writer, due to: %v", err)
+	}
+
+	if limit := migrateconf.RateLimit(); limit > 0 {
+		app.limiter = helper.NewBandwidthLimiter(limit)
+	}
+	log.Info("starting migrate-storage service")
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+
+	consumeErr := make(chan error, 1)
+	go func() {
+		consumeErr <- app.Broker.Subscribe(ctx, migrateconf.SourceQueue(), app.handleMessage)
+	}()
+
+	select {
+	case sig := <-sigc:
+		log.Infof("recieved signal: %v, shutting down gracefully", sig)
+		cancel()
+
+		return nil
+	case err := <-consumeErr:
+		if !errors.Is(err, context.Canceled) {
+			log.Errorf("failed to consume from %s, due to: %v", migrateconf.SourceQueue(), err)
+			cancel()
+
+			return err
+		}
+
+		return nil
+	}
+}
+
+func (app *MigrateStorage) handleMessage(ctx context.Context, message *brokerv2.Message) ([]func(), error) {
+	err := schema.ValidateJSON(fmt.Sprintf("%s/migrate-file.json", migrateconf.SchemaPath()), message.Body)
+	if err != nil {
+		log.Errorf("could not validate message: %s, due to: %v", message.Key, err)
+
+		return []func(){app.errorQueue(message)}, nil
+	}
+
+	var migrateFile schema.MigrateFile
+	if err := json.Unmarshal(message.Body, &migrateFile); err != nil {
+		log.Errorf("could not unmarshall message, due to: %v", err)
+
+		return []func(){app.errorQueue(message)}, nil
+	}
+	log.Infof("received work (correlation-id: %s, file-id: %s, source-location: %s)", message.Key, migrateFile.FileID, migrateFile.SourceLocation)
+
+	return app.migrateFile(ctx, migrateFile.FileID, migrateFile.SourceLocation, message)
+}
+
+// migrateFile copies the archive copy of the file from the source location to a destination location, verifies the
+// new copy, registers the new location of the file and removes the copy at the source location.
+//
+// Every step can be repeated, so a migration which was interrupted is resumed when the message is redelivered
+func (app *MigrateStorage) migrateFile(ctx context.Context, fileID, sourceLocation string, message *brokerv2.Message) ([]func(), error) {
+	archiveData, err := app.db.GetArchived(ctx, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get archive data of file: %s, due to: %v", fileID, err)
+	}
+	if archiveData == nil {
+		log.Errorf("file: %s has not been archived, can not be migrated", fileID)
+
+		return []func(){app.errorQueue(message)}, nil
+	}
+
+	// The file has already been moved, possibly by a migration which was interrupted before the source copy was removed
+	if archiveData.Location != sourceLocation {
+		log.Infof("file: %s is no longer archived at location: %s", fileID, sourceLocation)
+		if err := app.removeSourceCopy(ctx, fileID, sourceLocation, archiveData.FilePath); err != nil {
+			return nil, err
+		}
+
+		return nil, nil
+	}
+
+	verificationData, err := app.db.GetReVerificationDataFromFileID(ctx, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get archived checksum of file: %s, due to: %v", fileID, err)
+	}
+	if verificationData.ArchivedCheckSumType != "sha256" {
+		log.Errorf("archived checksum of file: %s is of unsupported type: %s", fileID, verificationData.ArchivedCheckSumType)
+
+		return []func(){app.errorQueue(message)}, nil
+	}
+
+	location, err := app.copyFile(ctx, sourceLocation, archiveData.FilePath, archiveData.FileSize, verificationData.ArchivedCheckSum)
+	switch {
+	case errors.Is(err, errCopyCorrupted):
+		log.Errorf("archive copy of file: %s can not be migrated, reason: %v", fileID, err)
+
+		return []func(){app.errorQueue(message), app.setErrorEvent(fileID, err.Error(), message)}, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to copy file: %s, due to: %v", fileID, err)
+	}
+
+	// Removing the source copy would remove the only copy when the destination storage includes the source location
+	if location == sourceLocation {
+		log.Errorf("file: %s was written to its source location: %s, the destination storage must not include the source location", fileID, sourceLocation)
+
+		return []func(){app.errorQueue(message)}, nil
+	}
+
+	if err := app.verifyCopy(ctx, location, archiveData.FilePath, archiveData.FileSize, verificationData.ArchivedCheckSum); err != nil {
+		if !errors.Is(err, errCopyCorrupted) {
+			return nil, fmt.Errorf("failed to verify copy of file: %s at location: %s, due to: %v", fileID, location, err)
+		}
+
+		log.Errorf("copy of file: %s at location: %s could not be verified, reason: %v", fileID, location, err)
+		if err := app.DestinationWriter.RemoveFile(ctx, location, archiveData.FilePath); err != nil {
+			log.Warnf("failed to remove corrupted copy of file: %s from location: %s, due to: %v", fileID, location, err)
+		}
+
+		return []func(){app.errorQueue(message)}, nil
+	}
+
+	err = app.db.MigrateArchiveLocation(ctx, fileID, sourceLocation, location, archiveData.FilePath)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		log.Errorf("file: %s was moved from location: %s during the migration", fileID, sourceLocation)
+
+		return []func(){app.errorQueue(message)}, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to set archive location of file: %s, due to: %v", fileID, err)
+	}
+
+	if err := app.removeSourceCopy(ctx, fileID, sourceLocation, archiveData.FilePath); err != nil {
+		return nil, err
+	}
+	log.Infof("migrated file: %s from location: %s to location: %s", fileID, sourceLocation, location)
+
+	return nil, nil
+}
+
+// copyFile copies the archive copy at the source location to the destination storage, and returns the location it
+// was copied to. The copy is aborted with an error wrapping errCopyCorrupted if the source copy does not match the
+// archived file
+func (app *MigrateStorage) copyFile(ctx context.Context, sourceLocation, filePath string, size int64, checksum string) (string, error) {
+	source, err := app.ArchiveReader.NewFileReader(ctx, sourceLocation, filePath)
+	if err != nil {
+		if errors.Is(err, storageerrors.ErrorFileNotFoundInLocation) {
+			return "", fmt.Errorf("%w: %v", errCopyCorrupted, err)
+		}
+
+		return "", fmt.Errorf("failed to open source copy, due to: %v", err)
+	}
+	defer func() {
+		_ = source.Close()
+	}()
+
+	verifier := helper.NewVerifyingReader(app.rateLimited(ctx, source), size, checksum)
+	location, err := app.DestinationWriter.WriteFile(ctx, filePath, verifier)
+	if verifier.Err() != nil {
+		return "", fmt.Errorf("%w: source %v", errCopyCorrupted, verifier.Err())
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write copy, due to: %v", err)
+	}
+
+	return location, nil
+}
+
+// verifyCopy reads the copy at the location and checks that it matches the archived file. Returns an error wrapping
+// errCopyCorrupted if it does not match
+func (app *MigrateStorage) verifyCopy(ctx context.Context, location, filePath string, size int64, checksum string) error {
+	f, err := app.ArchiveReader.NewFileReader(ctx, location, filePath)
+	if err != nil {
+		if errors.Is(err, storageerrors.ErrorFileNotFoundInLocation) {
+			return fmt.Errorf("%w: %v", errCopyCorrupted, err)
+		}
+
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	verifier := helper.NewVerifyingReader(app.rateLimited(ctx, f), size, checksum)
+	if _, err := io.Copy(io.Discard, verifier); err != nil {
+		if verifier.Err() != nil {
+			return fmt.Errorf("%w: %v", errCopyCorrupted, verifier.Err())
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// removeSourceCopy removes the copy at the source location, if the file was migrated from the source location and
+// the copy is not referenced by any file
+func (app *MigrateStorage) removeSourceCopy(ctx context.Context, fileID, sourceLocation, filePath string) error {
+	// The source location of the message is only trusted when the database agrees that the file was moved from it
+	migratedFrom, err := app.db.GetMigratedFromLocation(ctx, fileID)
+	if err != nil {
+		return fmt.Errorf("failed to get the location file: %s was migrated from, due to: %v", fileID, err)
+	}
+	if migratedFrom != sourceLocation {
+		log.Warnf("file: %s was not migrated from location: %s, source copy will not be removed", fileID, sourceLocation)
+
+		return nil
+	}
+
+	referenced, err := app.db.IsArchivedObjectReferenced(ctx, sourceLocation, filePath)
+	if err != nil {
+		return fmt.Errorf("failed to check if source copy of file: %s is referenced, due to: %v", fileID, err)
+	}
+	if referenced {
+		log.Warnf("source copy of file: %s at location: %s is still referenced, will not be removed", fileID, sourceLocation)
+
+		return nil
+	}
+
+	if _, err := app.ArchiveReader.GetFileSize(ctx, sourceLocation, filePath); err != nil {
+		if errors.Is(err, storageerrors.ErrorFileNotFoundInLocation) {
+			return nil
+		}
+
+		return fmt.Errorf("failed to check if source copy of file: %s exists, due to: %v", fileID, err)
+	}
+
+	if err := app.ArchiveWriter.RemoveFile(ctx, sourceLocation, filePath); err != nil {
+		return fmt.Errorf("failed to remove source copy of file: %s from location: %s, due to: %v", fileID, sourceLocation, err)
+	}
+	log.Debugf("removed source copy of file: %s from location: %s", fileID, sourceLocation)
+
+	return nil
+}
+
+func (app *MigrateStorage) rateLimited(ctx context.Context, reader io.Reader) io.Reader {
+	if app.limiter == nil {
+		return reader
+	}
+
+	return helper.NewRateLimitedReader(ctx, reader, app.limiter)
+}
+
+func (app *MigrateStorage) setErrorEvent(fileID, details string, message *brokerv2.Message) func() {
+	return func() {
+		detailsMap := map[string]string{
+			"error": details,
+		}
+
+		detailsJSON, err := json.Marshal(detailsMap)
+		if err != nil {
+			log.Errorf("failed to marshal details to JSON, due to: %v", err)
+			detailsJSON = []byte("{}")
+		}
+		err = app.db.UpdateFileEventLog(context.Background(), fileID, "error", "migrate-storage", string(detailsJSON), string(message.Body))
+		if err != nil {
+			log.Errorf("error from database when setting error event, due to: %v", err)
+		}
+	}
+}
+
+func (app *MigrateStorage) errorQueue(message *brokerv2.Message) func() {
+	return brokerv2.ErrorQueueCallback(app.Broker, message, "Failed to migrate file", nil)
+}
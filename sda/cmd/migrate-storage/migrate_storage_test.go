@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	migrateconf "github.com/neicnordic/sensitive-data-archive/cmd/migrate-storage/config"
+	brokerv2 "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/suite"
+)
+
+const testFileID = "c2e3c5b0-6a9d-4d1b-9d4e-3d0c6a1e9f4b"
+
+type TestSuite struct {
+	suite.Suite
+	content []byte
+	db      *mockDatabase
+	broker  *mockBroker
+	storage *mockStorage
+	app     MigrateStorage
+}
+
+func TestMigrateStorageTestSuite(t *testing.T) {
+	suite.Run(t, new(TestSuite))
+}
+
+// mockDatabase implements the database functions used by migrate-storage, calling any other function panics
+type mockDatabase struct {
+	database.Database
+	archiveData      *database.ArchiveData
+	archivedChecksum string
+	migratedFrom     string
+	events           []string
+}
+
+func (m *mockDatabase) GetArchived(_ context.Context, _ string) (*database.ArchiveData, error) {
+	return m.archiveData, nil
+}
+
+func (m *mockDatabase) GetReVerificationDataFromFileID(_ context.Context, fileID string) (*database.ReVerificationData, error) {
+	return &database.ReVerificationData{
+		FileID:               fileID,
+		ArchivedCheckSumType: "sha256",
+		ArchivedCheckSum:     m.archivedChecksum,
+	}, nil
+}
+
+func (m *mockDatabase) MigrateArchiveLocation(_ context.Context, _, sourceLocation, location, filePath string) error {
+	if m.archiveData.Location != sourceLocation {
+		return sql.ErrNoRows
+	}
+	m.migratedFrom = sourceLocation
+	m.archiveData.Location = location
+	m.archiveData.FilePath = filePath
+
+	return nil
+}
+
+func (m *mockDatabase) GetMigratedFromLocation(_ context.Context, _ string) (string, error) {
+	return m.migratedFrom, nil
+}
+
+func (m *mockDatabase) IsArchivedObjectReferenced(_ context.Context, location, filePath string) (bool, error) {
+	return m.archiveData.Location == location && m.archiveData.FilePath == filePath, nil
+}
+
+func (m *mockDatabase) UpdateFileEventLog(_ context.Context, _, event, _, _, _ string) error {
+	m.events = append(m.events, event)
+
+	return nil
+}
+
+type mockBroker struct {
+	brokerv2.Broker
+	published map[string]int
+}
+
+func (m *mockBroker) Publish(_ context.Context, destination string, _ brokerv2.Message) error {
+	m.published[destination]++
+
+	return nil
+}
+
+// mockStorage stores files in memory by location and file path, shared by the mock readers and writers
+type mockStorage struct {
+	files map[string][]byte
+	// corruptWrites corrupts the content of written files
+	corruptWrites bool
+}
+
+func (s *mockStorage) file(location, filePath string) ([]byte, error) {
+	content, ok := s.files[location+"/"+filePath]
+	if !ok {
+		return nil, storageerrors.ErrorFileNotFoundInLocation
+	}
+
+	return content, nil
+}
+
+type mockReader struct {
+	storage *mockStorage
+}
+
+func (r *mockReader) NewFileReader(_ context.Context, location, filePath string) (io.ReadCloser, error) {
+	content, err := r.storage.file(location, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+func (r *mockReader) NewFileReadSeeker(_ context.Context, _, _ string) (io.ReadSeekCloser, error) {
+	return nil, errors.New("not implemented")
+}
+func (r *mockReader) FindFile(_ context.Context, _ string) (string, error) {
+	return "", errors.New("not implemented")
+}
+func (r *mockReader) GetFileSize(_ context.Context, location, filePath string) (int64, error) {
+	content, err := r.storage.file(location, filePath)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(content)), nil
+}
+func (r *mockReader) Ping(_ context.Context) error { return nil }
+
+// mockWriter writes files to its location of the mock storage, a failed write leaves no file behind
+type mockWriter struct {
+	storage  *mockStorage
+	location string
+}
+
+func (w *mockWriter) WriteFile(_ context.Context, filePath string, fileContent io.Reader) (string, error) {
+	content, err := io.ReadAll(fileContent)
+	if err != nil {
+		return "", fmt.Errorf("failed to write file: %s, due to: %v", filePath, err)
+	}
+	if w.storage.corruptWrites {
+		content[0] ^= 0xff
+	}
+	w.storage.files[w.location+"/"+filePath] = content
+
+	return w.location, nil
+}
+
+func (w *mockWriter) RemoveFile(_ context.Context, location, filePath string) error {
+	delete(w.storage.files, location+"/"+filePath)
+
+	return nil
+}
+
+func (ts *TestSuite) SetupSuite() {
+	migrateconf.SetSchemaPath("../../schemas/isolated")
+}
+
+func (ts *TestSuite) SetupTest() {
+	viper.Set("log.level", "debug")
+
+	ts.content = bytes.Repeat([]byte("archived content"), 1000)
+	ts.db = &mockDatabase{
+		archiveData: &database.ArchiveData{
+			FilePath: "file-path",
+			Location: "/old-archive",
+			FileSize: int64(len(ts.content)),
+		},
+		archivedChecksum: fmt.Sprintf("%x", sha256.Sum256(ts.content)),
+	}
+	ts.broker = &mockBroker{published: make(map[string]int)}
+	ts.storage = &mockStorage{files: map[string][]byte{"/old-archive/file-path": ts.content}}
+	ts.app = MigrateStorage{
+		ArchiveReader:     &mockReader{storage: ts.storage},
+		ArchiveWriter:     &mockWriter{storage: ts.storage, location: "/old-archive"},
+		DestinationWriter: &mockWriter{storage: ts.storage, location: "/new-archive"},
+		Broker:            ts.broker,
+		db:                ts.db,
+	}
+}
+
+func (ts *TestSuite) migrateMessage() *brokerv2.Message {
+	return &brokerv2.Message{
+		Key:  testFileID,
+		Body: []byte(`{"type": "migrate", "file_id": "` + testFileID + `", "source_location": "/old-archive"}`),
+	}
+}
+
+func runCallbacks(callbacks []func()) {
+	for _, callback := range callbacks {
+		callback()
+	}
+}
+
+func (ts *TestSuite) TestHandleMessage() {
+	callbacks, err := ts.app.handleMessage(context.TODO(), ts.migrateMessage())
+	ts.NoError(err)
+	ts.Empty(callbacks)
+	ts.Equal("/new-archive", ts.db.archiveData.Location)
+	ts.Equal(map[string][]byte{"/new-archive/file-path": ts.content}, ts.storage.files)
+}
+
+func (ts *TestSuite) TestHandleMessage_AlreadyMigrated() {
+	ts.db.archiveData.Location = "/new-archive"
+	ts.db.migratedFrom = "/old-archive"
+	ts.storage.files["/new-archive/file-path"] = ts.content
+
+	// The source copy left behind by an interrupted migration is removed
+	callbacks, err := ts.app.handleMessage(context.TODO(), ts.migrateMessage())
+	ts.NoError(err)
+	ts.Empty(callbacks)
+	ts.Equal(map[string][]byte{"/new-archive/file-path": ts.content}, ts.storage.files)
+
+	// Nothing is left to do when the message is delivered again
+	callbacks, err = ts.app.handleMessage(context.TODO(), ts.migrateMessage())
+	ts.NoError(err)
+	ts.Empty(callbacks)
+	ts.Equal(map[string][]byte{"/new-archive/file-path": ts.content}, ts.storage.files)
+}
+
+func (ts *TestSuite) TestHandleMessage_NotMigratedFromSourceLocation() {
+	// The file was moved from the source location by something else than a migration, the copy at the source
+	// location is not known to be a copy of the file
+	ts.db.archiveData.Location = "/new-archive"
+	ts.storage.files["/new-archive/file-path"] = ts.content
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), ts.migrateMessage())
+	ts.NoError(err)
+	ts.Empty(callbacks)
+	ts.Equal(map[string][]byte{"/old-archive/file-path": ts.content, "/new-archive/file-path": ts.content}, ts.storage.files)
+
+	// The file was migrated from another location than the source location of the message
+	ts.db.migratedFrom = "/other-archive"
+
+	callbacks, err = ts.app.handleMessage(context.TODO(), ts.migrateMessage())
+	ts.NoError(err)
+	ts.Empty(callbacks)
+	ts.Equal(map[string][]byte{"/old-archive/file-path": ts.content, "/new-archive/file-path": ts.content}, ts.storage.files)
+}
+
+func (ts *TestSuite) TestHandleMessage_InvalidMessage() {
+	message := ts.migrateMessage()
+	message.Body = []byte(`{"type": "migrate", "file_id": "` + testFileID + `"}`)
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), message)
+	ts.NoError(err)
+	runCallbacks(callbacks)
+	ts.Equal(1, ts.broker.published["error"])
+	ts.Equal("/old-archive", ts.db.archiveData.Location)
+}
+
+func (ts *TestSuite) TestHandleMessage_CorruptedSourceCopy() {
+	corrupted := bytes.Clone(ts.content)
+	corrupted[100] ^= 0xff
+	ts.storage.files["/old-archive/file-path"] = corrupted
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), ts.migrateMessage())
+	ts.NoError(err)
+	runCallbacks(callbacks)
+	ts.Equal(1, ts.broker.published["error"])
+	ts.Equal([]string{"error"}, ts.db.events)
+	ts.Equal("/old-archive", ts.db.archiveData.Location)
+	ts.Equal(map[string][]byte{"/old-archive/file-path": corrupted}, ts.storage.files)
+}
+
+func (ts *TestSuite) TestHandleMessage_CorruptedDestinationCopy() {
+	ts.storage.corruptWrites = true
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), ts.migrateMessage())
+	ts.NoError(err)
+	runCallbacks(callbacks)
+	ts.Equal(1, ts.broker.published["error"])
+	ts.Empty(ts.db.events)
+	ts.Equal("/old-archive", ts.db.archiveData.Location)
+	ts.Equal(map[string][]byte{"/old-archive/file-path": ts.content}, ts.storage.files)
+}
+
+func (ts *TestSuite) TestHandleMessage_DestinationIsSource() {
+	ts.app.DestinationWriter = &mockWriter{storage: ts.storage, location: "/old-archive"}
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), ts.migrateMessage())
+	ts.NoError(err)
+	runCallbacks(callbacks)
+	ts.Equal(1, ts.broker.published["error"])
+	ts.Equal("/old-archive", ts.db.archiveData.Location)
+	ts.Equal(map[string][]byte{"/old-archive/file-path": ts.content}, ts.storage.files)
+}
+
+func (ts *TestSuite) TestHandleMessage_NotArchived() {
+	ts.db.archiveData = nil
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), ts.migrateMessage())
+	ts.NoError(err)
+	runCallbacks(callbacks)
+	ts.Equal(1, ts.broker.published["error"])
+}
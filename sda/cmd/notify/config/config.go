@@ -0,0 +1,123 @@
+package config
+
+import (
+	"fmt"
+
+	config "github.com/neicnordic/sensitive-data-archive/internal/config/v2"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var (
+	sourceQueue  string
+	schemaPath   string
+	smtpHost     string
+	smtpPort     int
+	smtpPassword string
+	smtpFrom     string
+)
+
+func init() {
+	config.RegisterFlags(
+		&config.Flag{
+			Name: "sourceQueue",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "The queue where the notify service consumes messages from, supported queues: <error|ready>")
+			},
+			Required: true,
+			AssignFunc: func(flagName string) {
+				sourceQueue = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "schemaType",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "isolated", "Path to JSON schemas to validate rabbitmq messages against")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				schemaType := viper.GetString("schemaType")
+				switch schemaType {
+				case "federated":
+					schemaPath = "/schemas/federated/"
+				case "isolated":
+					schemaPath = "/schemas/isolated/"
+				default:
+					panic(fmt.Sprintf("schema.type '%s' not supported, needs: <federated|isolated>", schemaType))
+				}
+			},
+		},
+		&config.Flag{
+			Name: "smtp.host",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "Hostname of the SMTP server emails are sent through")
+			},
+			Required: true,
+			AssignFunc: func(flagName string) {
+				smtpHost = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "smtp.port",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Int(flagName, 587, "Port of the SMTP server")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				smtpPort = viper.GetInt(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "smtp.password",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "Password to authenticate to the SMTP server with")
+			},
+			Required: true,
+			AssignFunc: func(flagName string) {
+				smtpPassword = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "smtp.from",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "Address emails are sent from, also used as the SMTP username")
+			},
+			Required: true,
+			AssignFunc: func(flagName string) {
+				smtpFrom = viper.GetString(flagName)
+			},
+		},
+	)
+}
+
+func SourceQueue() string {
+	return sourceQueue
+}
+
+func SetSourceQueue(queue string) {
+	sourceQueue = queue
+}
+
+func SchemaPath() string {
+	return schemaPath
+}
+
+func SetSchemaPath(path string) {
+	schemaPath = path
+}
+
+func SMTPHost() string {
+	return smtpHost
+}
+
+func SMTPPort() int {
+	return smtpPort
+}
+
+func SMTPPassword() string {
+	return smtpPassword
+}
+
+func SMTPFrom() string {
+	return smtpFrom
+}
@@ -2,105 +2,149 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/smtp"
+	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 
-	"github.com/neicnordic/sensitive-data-archive/internal/broker"
+	notifyconf "github.com/neicnordic/sensitive-data-archive/cmd/notify/config"
+	brokerv2 "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/broker/v2/factory"
 	"github.com/neicnordic/sensitive-data-archive/internal/config"
+	configv2 "github.com/neicnordic/sensitive-data-archive/internal/config/v2"
 	"github.com/neicnordic/sensitive-data-archive/internal/schema"
-	"github.com/rabbitmq/amqp091-go"
 	log "github.com/sirupsen/logrus"
 )
 
 const err = "error"
 const ready = "ready"
+const expiring = "expiring"
+
+type Notify struct {
+	Broker brokerv2.Broker
+	SMTP   config.SMTPConf
+	// sendEmail sends the email, replaceable in tests
+	sendEmail func(conf config.SMTPConf, emailBody, recipient, subject string) error
+}
 
 func main() {
-	forever := make(chan bool)
-	conf, err := config.NewConfig("notify")
-	if err != nil {
+	if err := run(); err != nil {
 		log.Fatal(err)
 	}
-	mq, err := broker.NewMQ(conf.Broker)
+}
+
+func run() error {
+	var err error
+	app := Notify{sendEmail: sendEmail}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err = configv2.Load(); err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
+	if setSubject(notifyconf.SourceQueue()) == "" {
+		return fmt.Errorf("unknown queue, %s", notifyconf.SourceQueue())
+	}
+	app.SMTP = config.SMTPConf{
+		Host:     notifyconf.SMTPHost(),
+		Port:     notifyconf.SMTPPort(),
+		Password: notifyconf.SMTPPassword(),
+		FromAddr: notifyconf.SMTPFrom(),
+	}
+
+	app.Broker, err = factory.NewBroker(ctx)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("failed to initialize mq broker, due to: %v", err)
 	}
+	defer func() {
+		if err := app.Broker.Close(); err != nil {
+			log.Errorf("could not close Broker, due to: %v", err)
+		}
+	}()
+	log.Infof("Starting %s notify service", notifyconf.SourceQueue())
 
-	defer mq.Channel.Close()
-	defer mq.Connection.Close()
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 
+	consumeErr := make(chan error, 1)
 	go func() {
-		connError := mq.ConnectionWatcher()
-		log.Error(connError)
-		forever <- false
+		consumeErr <- app.Broker.Subscribe(ctx, notifyconf.SourceQueue(), app.handleMessage)
 	}()
 
-	go func() {
-		connError := mq.ChannelWatcher()
-		log.Error(connError)
-		forever <- false
-	}()
+	select {
+	case sig := <-sigc:
+		log.Infof("recieved signal: %v, shutting down gracefully", sig)
+		cancel()
 
-	log.Infof("Starting %s notify service", conf.Broker.Queue)
+		return nil
+	case err := <-consumeErr:
+		if !errors.Is(err, context.Canceled) {
+			log.Errorf("failed to consume from %s, due to: %v", notifyconf.SourceQueue(), err)
+			cancel()
 
-	go func() {
-		messages, err := mq.GetMessages(conf.Broker.Queue)
-		if err != nil {
-			log.Fatalf("Failed to get message from mq (error: %v)", err)
+			return err
 		}
 
-		for d := range messages {
-			log.Debugf("received a message: %s", d.Body)
-
-			if err := validator(conf.Broker.Queue, conf.Broker.SchemasPath, d); err != nil {
-				log.Errorf("Failed to handle message, reason: %v", err)
-
-				continue
-			}
-			user := getUser(conf.Broker.Queue, d.Body)
-			if user == "" {
-				log.Errorln("No user in message, skipping")
+		return nil
+	}
+}
 
-				continue
-			}
+// handleMessage sends an email to the user of the message. Messages which can not be handled are only logged, as
+// publishing them to the error queue could cause a loop when consuming from the error queue
+func (app *Notify) handleMessage(_ context.Context, delivered *brokerv2.Message) ([]func(), error) {
+	log.Debugf("received a message: %s", delivered.Body)
 
-			if err := sendEmail(conf.Notify, "THIS SHOULD TAKE A TEMPLATE", user, setSubject(conf.Broker.Queue)); err != nil {
-				log.Errorf("Failed to send email, error %v", err)
+	queue := notifyconf.SourceQueue()
+	if err := validator(queue, notifyconf.SchemaPath(), delivered.Body); err != nil {
+		log.Errorf("Failed to handle message, reason: %v", err)
 
-				if e := d.Nack(false, false); e != nil {
-					log.Errorf("Failed to Nack message, error: %v) ", e)
-				}
+		return nil, nil
+	}
+	user := getUser(queue, delivered.Body)
+	if user == "" {
+		log.Errorln("No user in message, skipping")
 
-				continue
-			}
+		return nil, nil
+	}
 
-			if err := d.Ack(false); err != nil {
-				log.Errorf("Failed to ack message, error %v", err)
-			}
-		}
-	}()
+	if err := app.sendEmail(app.SMTP, "THIS SHOULD TAKE A TEMPLATE", user, setSubject(queue)); err != nil {
+		return nil, fmt.Errorf("failed to send email, error %v", err)
+	}
 
-	<-forever
+	return nil, nil
 }
 
 func getUser(queue string, orgMsg []byte) string {
 	switch queue {
 	case err:
-		var notify broker.InfoError
+		var notify schema.InfoError
 		_ = json.Unmarshal(orgMsg, &notify)
-		orgMsg, _ := base64.StdEncoding.DecodeString(notify.OriginalMessage.(string))
+		originalMessage, _ := notify.OriginalMessage.(string)
+		orgMsg, _ := base64.StdEncoding.DecodeString(originalMessage)
 
 		var message map[string]any
 		_ = json.Unmarshal(orgMsg, &message)
+		if message["user"] == nil {
+			return ""
+		}
 
 		return fmt.Sprint(message["user"])
 	case ready:
 		var notify schema.IngestionCompletion
 		_ = json.Unmarshal(orgMsg, &notify)
 
+		return notify.User
+	case expiring:
+		var notify schema.InboxExpiry
+		_ = json.Unmarshal(orgMsg, &notify)
+
 		return notify.User
 	default:
 		return ""
@@ -136,21 +180,29 @@ func setSubject(queue string) string {
 		return "Error during ingestion"
 	case ready:
 		return "Ingestion completed"
+	case expiring:
+		return "File in inbox about to expire"
 	default:
 		return ""
 	}
 }
 
-func validator(queue, schemaPath string, delivery amqp091.Delivery) error {
+func validator(queue, schemaPath string, body []byte) error {
 	switch queue {
 	case err:
-		if err := schema.ValidateJSON(fmt.Sprintf("%s/info-error.json", schemaPath), delivery.Body); err != nil {
+		if err := schema.ValidateJSON(fmt.Sprintf("%s/info-error.json", schemaPath), body); err != nil {
 			return err
 		}
 
 		return nil
 	case ready:
-		if err := schema.ValidateJSON(fmt.Sprintf("%s/ingestion-completion.json", schemaPath), delivery.Body); err != nil {
+		if err := schema.ValidateJSON(fmt.Sprintf("%s/ingestion-completion.json", schemaPath), body); err != nil {
+			return err
+		}
+
+		return nil
+	case expiring:
+		if err := schema.ValidateJSON(fmt.Sprintf("%s/inbox-expiry.json", schemaPath), body); err != nil {
 			return err
 		}
 
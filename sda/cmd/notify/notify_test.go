@@ -1,14 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"testing"
 
 	smtpmock "github.com/mocktools/go-smtp-mock"
+	notifyconf "github.com/neicnordic/sensitive-data-archive/cmd/notify/config"
+	brokerv2 "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
 	"github.com/neicnordic/sensitive-data-archive/internal/config"
 	"github.com/neicnordic/sensitive-data-archive/internal/schema"
-	"github.com/rabbitmq/amqp091-go"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -53,16 +56,20 @@ func TestGetUser(t *testing.T) {
 
 	orgUser := getUser("error", infoErrorBytes)
 	assert.Equal(t, "JohnDoe", orgUser)
+
+	expiryMsgBytes, _ := json.Marshal(schema.InboxExpiry{User: "JohnDoe", FilePath: "path/to file", ExpiresAt: "2024-01-31T12:00:00Z"})
+	assert.Equal(t, "JohnDoe", getUser("expiring", expiryMsgBytes))
 }
 
 func TestSetSubject(t *testing.T) {
 	assert.Equal(t, "Error during ingestion", setSubject("error"))
 	assert.Equal(t, "Ingestion completed", setSubject("ready"))
+	assert.Equal(t, "File in inbox about to expire", setSubject("expiring"))
 	assert.Empty(t, setSubject("phail"))
 }
 
 func TestValidator(t *testing.T) {
-	d := amqp091.Delivery{}
+	d := brokerv2.Message{}
 
 	archivedMsg := schema.IngestionVerification{
 		User:        "JohnDoe",
@@ -84,22 +91,22 @@ func TestValidator(t *testing.T) {
 	}
 
 	d.Body, _ = json.Marshal(infoError)
-	err := validator("error", "../../schemas/federated", d)
+	err := validator("error", "../../schemas/federated", d.Body)
 	assert.NoError(t, err, "validator failed unexpectedly")
 
 	d.Body = []byte("{\"test\":\"valid_json\"}")
-	err = validator("error", "../../schemas/federated", d)
+	err = validator("error", "../../schemas/federated", d.Body)
 	assert.Error(t, err, "validator did not fail when it should")
 
 	d.Body = d.Body[:20]
-	err = validator("error", "../../schemas/federated", d)
+	err = validator("error", "../../schemas/federated", d.Body)
 	assert.Error(t, err, "validator did not fail when it should")
 
-	err = validator("ready", "../../schemas/federated", d)
+	err = validator("ready", "../../schemas/federated", d.Body)
 	assert.Error(t, err, "validator did not fail when it should")
 
 	d.Body = []byte("{\"test\":\"valid_json\"}")
-	err = validator("ready", "../../schemas/federated", d)
+	err = validator("ready", "../../schemas/federated", d.Body)
 	assert.Error(t, err, "validator did not fail when it should")
 
 	finalizedMsg := schema.IngestionAccession{
@@ -113,7 +120,14 @@ func TestValidator(t *testing.T) {
 	}
 
 	d.Body, _ = json.Marshal(finalizedMsg)
-	err = validator("ready", "../../schemas/federated", d)
+	err = validator("ready", "../../schemas/federated", d.Body)
+	assert.Nil(t, err)
+
+	err = validator("expiring", "../../schemas/federated", d.Body)
+	assert.Error(t, err, "validator did not fail when it should")
+
+	d.Body, _ = json.Marshal(schema.InboxExpiry{User: "JohnDoe", FilePath: "path/to file", ExpiresAt: "2024-01-31T12:00:00Z"})
+	err = validator("expiring", "../../schemas/federated", d.Body)
 	assert.Nil(t, err)
 }
 
@@ -139,3 +153,42 @@ func TestSendEmail(t *testing.T) {
 	err := sendEmail(conf, "Mail Body", "recipient", "subject")
 	assert.Equal(t, "smtp: server doesn't support AUTH", err.Error())
 }
+
+func TestHandleMessage(t *testing.T) {
+	notifyconf.SetSchemaPath("../../schemas/federated")
+	notifyconf.SetSourceQueue("ready")
+	var recipients []string
+	sendErr := errors.New("connection refused")
+	app := Notify{sendEmail: func(_ config.SMTPConf, _, recipient, subject string) error {
+		recipients = append(recipients, recipient)
+		assert.Equal(t, "Ingestion completed", subject)
+
+		return sendErr
+	}}
+
+	completedMsg, _ := json.Marshal(schema.IngestionCompletion{
+		User:        "JohnDoe",
+		FilePath:    "path/to file",
+		AccessionID: "EGAF00123456789",
+		DecryptedChecksums: []schema.Checksums{
+			{Type: "sha256", Value: "da886a89637d125ef9f15f6d676357f3a9e5e10306929f0bad246375af89c2e2"},
+			{Type: "md5", Value: "68b329da9893e34099c7d8ad5cb9c940"},
+		},
+	})
+
+	// The message is retried when the email could not be sent
+	_, err := app.handleMessage(context.TODO(), &brokerv2.Message{Body: completedMsg})
+	assert.ErrorContains(t, err, "connection refused")
+
+	sendErr = nil
+	callbacks, err := app.handleMessage(context.TODO(), &brokerv2.Message{Body: completedMsg})
+	assert.NoError(t, err)
+	assert.Empty(t, callbacks)
+	assert.Equal(t, []string{"JohnDoe", "JohnDoe"}, recipients)
+
+	// Invalid messages are dropped without sending an email
+	callbacks, err = app.handleMessage(context.TODO(), &brokerv2.Message{Body: []byte(`{"user": "JohnDoe"}`)})
+	assert.NoError(t, err)
+	assert.Empty(t, callbacks)
+	assert.Len(t, recipients, 2)
+}
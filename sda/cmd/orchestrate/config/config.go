@@ -0,0 +1,182 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	config "github.com/neicnordic/sensitive-data-archive/internal/config/v2"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var (
+	projectFQDN    string
+	inboxQueue     string
+	verifiedQueue  string
+	completedQueue string
+	ingestQueue    string
+	accessionQueue string
+	mappingsQueue  string
+	releaseDelay   time.Duration
+	schemaPath     string
+)
+
+func init() {
+	config.RegisterFlags(
+		&config.Flag{
+			Name: "project.fqdn",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "The fully qualified domain name of the project, used as namespace when generating accession and dataset IDs")
+			},
+			Required: true,
+			AssignFunc: func(flagName string) {
+				projectFQDN = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "inboxQueue",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "inbox", "The queue where the orchestrate service consumes inbox messages from")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				inboxQueue = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "verifiedQueue",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "verified", "The queue where the orchestrate service consumes verified messages from")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				verifiedQueue = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "completedQueue",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "completed", "The queue where the orchestrate service consumes completed messages from")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				completedQueue = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "ingestQueue",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "ingest", "The queue where the orchestrate service publishes ingest messages to")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				ingestQueue = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "accessionQueue",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "accessionIDs", "The queue where the orchestrate service publishes accession messages to")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				accessionQueue = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "mappingsQueue",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "mappings", "The queue where the orchestrate service publishes dataset mapping and release messages to")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				mappingsQueue = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "releaseDelay",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Duration(flagName, time.Minute, "How long to wait after mapping a dataset before releasing it. Expects a go time.Duration parsable string")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				releaseDelay = viper.GetDuration(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "schemaType",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "isolated", "Path to JSON schemas to validate rabbitmq messages against")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				schemaType := viper.GetString("schemaType")
+				switch schemaType {
+				case "federated":
+					schemaPath = "/schemas/federated/"
+				case "isolated":
+					schemaPath = "/schemas/isolated/"
+				default:
+					panic(fmt.Sprintf("schema.type '%s' not supported, needs: <federated|isolated>", schemaType))
+				}
+			},
+		},
+	)
+}
+
+func ProjectFQDN() string {
+	return projectFQDN
+}
+
+func InboxQueue() string {
+	return inboxQueue
+}
+
+func VerifiedQueue() string {
+	return verifiedQueue
+}
+
+func CompletedQueue() string {
+	return completedQueue
+}
+
+func IngestQueue() string {
+	return ingestQueue
+}
+
+func AccessionQueue() string {
+	return accessionQueue
+}
+
+func MappingsQueue() string {
+	return mappingsQueue
+}
+
+func ReleaseDelay() time.Duration {
+	return releaseDelay
+}
+
+func SchemaPath() string {
+	return schemaPath
+}
+
+func SetQueues(inbox, verified, completed, ingest, accession, mappings string) {
+	inboxQueue = inbox
+	verifiedQueue = verified
+	completedQueue = completed
+	ingestQueue = ingest
+	accessionQueue = accession
+	mappingsQueue = mappings
+}
+
+func SetProjectFQDN(fqdn string) {
+	projectFQDN = fqdn
+}
+
+func SetReleaseDelay(delay time.Duration) {
+	releaseDelay = delay
+}
+
+func SetSchemaPath(path string) {
+	schemaPath = path
+}
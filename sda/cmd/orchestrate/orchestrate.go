@@ -3,17 +3,21 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/neicnordic/sensitive-data-archive/internal/broker"
-	"github.com/neicnordic/sensitive-data-archive/internal/config"
+	orchestrateconf "github.com/neicnordic/sensitive-data-archive/cmd/orchestrate/config"
+	brokerv2 "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/broker/v2/factory"
+	configv2 "github.com/neicnordic/sensitive-data-archive/internal/config/v2"
 	"github.com/neicnordic/sensitive-data-archive/internal/schema"
-	"github.com/rabbitmq/amqp091-go"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -22,7 +26,7 @@ type upload struct {
 	User               string      `json:"user"`
 	Filepath           string      `json:"filepath"`
 	Filesize           int         `json:"filesize"`
-	LastModified       string      `json:"file_last_modified,omitempty"`
+	LastModified       int64       `json:"file_last_modified,omitempty"`
 	EncryptedChecksums []checksums `json:"encrypted_checksums,omitempty"`
 }
 
@@ -36,7 +40,7 @@ type trigger struct {
 	Type               string      `json:"type"`
 	User               string      `json:"user"`
 	Filepath           string      `json:"filepath"`
-	EncryptedChecksums []checksums `json:"encrypted_checksums"`
+	EncryptedChecksums []checksums `json:"encrypted_checksums,omitempty"`
 }
 
 type finalize struct {
@@ -58,171 +62,161 @@ type checksums struct {
 	Value string `json:"value"`
 }
 
+type Orchestrate struct {
+	Broker brokerv2.Broker
+}
+
 func main() {
-	conf, err := config.NewConfig("orchestrate")
-	if err != nil {
-		log.Fatal(err)
-	}
-	mq, err := broker.NewMQ(conf.Broker)
-	if err != nil {
+	if err := run(); err != nil {
 		log.Fatal(err)
 	}
+}
+
+func run() error {
+	var err error
+	app := Orchestrate{}
 
-	defer mq.Channel.Close()
-	defer mq.Connection.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	queues := []string{conf.Orchestrator.QueueInbox, conf.Orchestrator.QueueVerify, conf.Orchestrator.QueueComplete}
+	if err = configv2.Load(); err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
 
-	go func() {
-		connError := mq.ConnectionWatcher()
-		log.Error(connError)
-		os.Exit(1)
+	app.Broker, err = factory.NewBroker(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to initialize mq broker, due to: %v", err)
+	}
+	defer func() {
+		if err := app.Broker.Close(); err != nil {
+			log.Errorf("could not close Broker, due to: %v", err)
+		}
 	}()
+	log.Info("Starting orchestrate service")
 
-	routing := map[string]string{
-		conf.Orchestrator.QueueVerify:   conf.Orchestrator.QueueAccession,
-		conf.Orchestrator.QueueInbox:    conf.Orchestrator.QueueIngest,
-		conf.Orchestrator.QueueComplete: conf.Orchestrator.QueueMapping,
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+
+	queues := []string{orchestrateconf.InboxQueue(), orchestrateconf.VerifiedQueue(), orchestrateconf.CompletedQueue()}
+	consumeErr := make(chan error, len(queues))
+	for _, queue := range queues {
+		log.Infof("Monitoring queue: %s", queue)
+		go func() {
+			consumeErr <- app.Broker.Subscribe(ctx, queue, func(ctx context.Context, delivered *brokerv2.Message) ([]func(), error) {
+				return app.handleMessage(ctx, queue, delivered)
+			})
+		}()
 	}
 
-	forever := make(chan bool)
+	select {
+	case sig := <-sigc:
+		log.Infof("recieved signal: %v, shutting down gracefully", sig)
+		cancel()
 
-	log.Info("Starting orchestrate service")
+		return nil
+	case err := <-consumeErr:
+		if !errors.Is(err, context.Canceled) {
+			log.Errorf("failed to consume messages, due to: %v", err)
+			cancel()
 
-	for _, queue := range queues {
-		routingKey := routing[queue]
-		go processQueue(mq, queue, routingKey, conf)
+			return err
+		}
+
+		return nil
 	}
-	<-forever
 }
 
-func processQueue(mq *broker.AMQPBroker, queue string, routingKey string, conf *config.Config) {
-	log.Infof("Monitoring queue: %s", queue)
+// handleMessage routes a message consumed from queue to the next step of the ingestion
+func (app *Orchestrate) handleMessage(ctx context.Context, queue string, delivered *brokerv2.Message) ([]func(), error) {
+	log.Debugf("Received a message (correlation-id: %s, queue: %s, message: %s)", delivered.Key, queue, delivered.Body)
 
-	messages, err := mq.GetMessages(queue)
+	schemaType, err := schemaNameFromQueue(queue, delivered.Body)
 	if err != nil {
-		log.Fatal(err) // nolint # FIXME Fatal should only be called from main
+		log.Error(err.Error())
+
+		return []func(){brokerv2.ErrorQueueCallback(app.Broker, delivered, "Unknown message", err)}, nil
 	}
-	for delivered := range messages {
-		log.Debugf("Received a message: %s", delivered.Body)
 
-		schemaType, err := schemaNameFromQueue(queue, delivered.Body, conf)
+	if err := schema.ValidateJSON(fmt.Sprintf("%s/%s.json", orchestrateconf.SchemaPath(), schemaType), delivered.Body); err != nil {
+		log.Errorf("Message validation failed (schema: %v, error: %v, message: %s)", schemaType, err, delivered.Body)
 
-		if err != nil {
-			log.Error(err.Error())
+		return []func(){brokerv2.ErrorQueueCallback(app.Broker, delivered, "Message validation failed", err)}, nil
+	}
 
-			if err := delivered.Ack(false); err != nil {
-				log.Errorf("failed to ack message: %v", err)
-			}
-			if err := mq.SendMessage(delivered.CorrelationId, mq.Conf.Exchange, "error", delivered.Body); err != nil {
-				log.Errorf("failed to send error message: %v", err)
-			}
+	switch queue {
+	case orchestrateconf.InboxQueue():
+		// Only uploaded files are ingested, renamed and removed files have nothing to trigger
+		if schemaType != "inbox-upload" {
+			log.Debugf("Ignoring inbox operation (correlation-id: %s, schema: %s)", delivered.Key, schemaType)
 
-			continue
+			return nil, nil
 		}
 
-		err = schema.ValidateJSON(fmt.Sprintf("%s/%s.json", conf.Broker.SchemasPath, schemaType), delivered.Body)
+		return app.route(ctx, delivered, orchestrateconf.IngestQueue(), ingestMessage)
+	case orchestrateconf.VerifiedQueue():
+		return app.route(ctx, delivered, orchestrateconf.AccessionQueue(), finalizeMessage)
+	case orchestrateconf.CompletedQueue():
+		if callbacks, err := app.route(ctx, delivered, orchestrateconf.MappingsQueue(), mappingMessage); err != nil || len(callbacks) != 0 {
+			return callbacks, err
+		}
 
-		if err != nil {
-			log.Errorf("Message validation failed (schema: %v, error: %v, message: %s)", schemaType, err, delivered.Body)
+		// Give the mapper time to map the files before the dataset is released
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(orchestrateconf.ReleaseDelay()):
+		}
 
-			if err := delivered.Ack(false); err != nil {
-				log.Errorf("failed to ack message: %v", err)
-			}
-			if err := mq.SendMessage(delivered.CorrelationId, mq.Conf.Exchange, "error", delivered.Body); err != nil {
-				log.Errorf("failed to send error message: %v", err)
-			}
+		return app.route(ctx, delivered, orchestrateconf.MappingsQueue(), releaseMessage)
+	default:
+		return []func(){brokerv2.ErrorQueueCallback(app.Broker, delivered, "Unknown queue", fmt.Errorf("unknown queue: %s", queue))}, nil
+	}
+}
 
-			continue
-		}
+// route builds the outgoing message from the delivered message, validates it and publishes it to routingKey
+func (app *Orchestrate) route(ctx context.Context, delivered *brokerv2.Message, routingKey string, buildMessage func([]byte) ([]byte, error)) ([]func(), error) {
+	publishMsg, err := buildMessage(delivered.Body)
+	if err != nil {
+		log.Errorf("failed to build outgoing message, error: %v", err)
 
-		var publishMsg []byte
-		var publishType any
+		return []func(){brokerv2.ErrorQueueCallback(app.Broker, delivered, "Failed to build outgoing message", err)}, nil
+	}
 
-		routingSchema, err := schemaNameFromQueue(routingKey, nil, conf)
+	routingSchema, err := schemaNameFromQueue(routingKey, publishMsg)
+	if err != nil {
+		log.Errorf("Don't know schema for routing key: %v", routingKey)
 
-		if err != nil {
-			log.Errorf("Don't know schema for routing key: %v", routingKey)
+		return []func(){brokerv2.ErrorQueueCallback(app.Broker, delivered, "Unknown routing key", err)}, nil
+	}
 
-			if err := delivered.Ack(false); err != nil {
-				log.Errorf("failed to ack message: %v", err)
-			}
-			if err := mq.SendMessage(delivered.CorrelationId, mq.Conf.Exchange, "error", delivered.Body); err != nil {
-				log.Errorf("failed to send error message: %v", err)
-			}
+	if err := schema.ValidateJSON(fmt.Sprintf("%s/%s.json", orchestrateconf.SchemaPath(), routingSchema), publishMsg); err != nil {
+		log.Errorf("Validation of outgoing message failed, error: %v", err)
 
-			continue
-		}
+		return []func(){brokerv2.ErrorQueueCallback(app.Broker, delivered, "Validation of outgoing message failed", err)}, nil
+	}
 
-		switch routingKey {
-		case conf.Orchestrator.QueueAccession:
-			publishMsg, publishType = finalizeMessage(delivered.Body, conf)
-			err = validateMsg(&delivered, mq, routingKey, routingSchema, publishMsg, publishType)
-			if err != nil {
-				log.Errorf("Validation of outgoing message failed, error: %v", err)
-				if err := delivered.Nack(false, true); err != nil {
-					log.Errorf("failed to nack message for reason: %v", err)
-				}
-
-				continue
-			}
-		case conf.Orchestrator.QueueIngest:
-			publishMsg, publishType = ingestMessage(delivered.Body)
-			err = validateMsg(&delivered, mq, routingKey, routingSchema, publishMsg, publishType)
-			if err != nil {
-				log.Errorf("Validation of outgoing message failed, error: %v", err)
-				if err := delivered.Nack(false, true); err != nil {
-					log.Errorf("failed to nack message for reason: %v", err)
-				}
-
-				continue
-			}
-		case conf.Orchestrator.QueueMapping:
-			publishMsg, publishType = mappingMessage(delivered.Body, conf)
-			err = validateMsg(&delivered, mq, routingKey, routingSchema, publishMsg, publishType)
-			if err != nil {
-				log.Errorf("Validation of outgoing message failed, error: %v", err)
-				if err := delivered.Nack(false, true); err != nil {
-					log.Errorf("failed to nack message for reason: %v", err)
-				}
-
-				continue
-			}
-			// let us wait a minute before sending the release message
-			time.Sleep(conf.Orchestrator.ReleaseDelay * time.Minute)
-			publishMsg, publishType = releaseMessage(delivered.Body, conf)
-			err = validateMsg(&delivered, mq, routingKey, routingSchema, publishMsg, publishType)
-			if err != nil {
-				log.Errorf("Validation of outgoing message failed, error: %v", err)
-				if err := delivered.Nack(false, true); err != nil {
-					log.Errorf("failed to nack message for reason: %v", err)
-				}
-
-				continue
-			}
-		default:
-			log.Errorln("unknown raouting key")
-
-			continue
-		}
+	log.Debugf("Routing message (correlation-id: %s, routingkey: %s, message: %s)", delivered.Key, routingKey, publishMsg)
+	if err := app.Broker.Publish(ctx, routingKey, brokerv2.Message{Key: delivered.Key, Body: publishMsg}); err != nil {
+		return nil, fmt.Errorf("failed to publish message to: %s, due to: %v", routingKey, err)
 	}
+
+	return nil, nil
 }
 
 // schemaNameFromQueue returns the schema to use for messages
 // determined by the queue
-func schemaNameFromQueue(queue string, body []byte, conf *config.Config) (string, error) {
-	if queue == conf.Orchestrator.QueueInbox {
+func schemaNameFromQueue(queue string, body []byte) (string, error) {
+	if queue == orchestrateconf.InboxQueue() {
 		return schemaFromInboxOperation(body)
 	}
-	if queue == conf.Orchestrator.QueueMapping {
+	if queue == orchestrateconf.MappingsQueue() {
 		return schemaFromDatasetOperation(body)
 	}
 	m := map[string]string{
-		conf.Orchestrator.QueueVerify:    "ingestion-accession-request",
-		conf.Orchestrator.QueueComplete:  "ingestion-completion",
-		conf.Orchestrator.QueueIngest:    "ingestion-trigger",
-		conf.Orchestrator.QueueAccession: "ingestion-accession",
-		conf.Orchestrator.QueueBackup:    "ingestion-completion",
+		orchestrateconf.VerifiedQueue():  "ingestion-accession-request",
+		orchestrateconf.CompletedQueue(): "ingestion-completion",
+		orchestrateconf.IngestQueue():    "ingestion-trigger",
+		orchestrateconf.AccessionQueue(): "ingestion-accession",
 	}
 
 	if m[queue] != "" {
@@ -294,11 +288,10 @@ func schemaFromDatasetOperation(body []byte) (string, error) {
 	}
 }
 
-func ingestMessage(body []byte) ([]byte, any) {
+func ingestMessage(body []byte) ([]byte, error) {
 	var message upload
-	err := json.Unmarshal(body, &message)
-	if err != nil {
-		return nil, nil
+	if err := json.Unmarshal(body, &message); err != nil {
+		return nil, err
 	}
 
 	msg := trigger{
@@ -308,19 +301,16 @@ func ingestMessage(body []byte) ([]byte, any) {
 		EncryptedChecksums: message.EncryptedChecksums,
 	}
 
-	publish, _ := json.Marshal(&msg)
-
-	return publish, new(trigger)
+	return json.Marshal(&msg)
 }
 
-func finalizeMessage(body []byte, conf *config.Config) ([]byte, any) {
+func finalizeMessage(body []byte) ([]byte, error) {
 	var message request
-	err := json.Unmarshal(body, &message)
-	if err != nil {
-		return nil, nil
+	if err := json.Unmarshal(body, &message); err != nil {
+		return nil, err
 	}
 	accessionID := uuid.NewSHA1(
-		uuid.NewSHA1(uuid.NameSpaceDNS, []byte(conf.Orchestrator.ProjectFQDN)),
+		uuid.NewSHA1(uuid.NameSpaceDNS, []byte(orchestrateconf.ProjectFQDN())),
 		body).URN()
 
 	msg := finalize{
@@ -331,18 +321,16 @@ func finalizeMessage(body []byte, conf *config.Config) ([]byte, any) {
 		AccessionID:        accessionID,
 	}
 
-	publish, _ := json.Marshal(&msg)
-
-	return publish, new(finalize)
+	return json.Marshal(&msg)
 }
 
-func mappingMessage(body []byte, conf *config.Config) ([]byte, any) {
+func mappingMessage(body []byte) ([]byte, error) {
 	var message finalize
 	if err := json.Unmarshal(body, &message); err != nil {
-		return nil, nil
+		return nil, err
 	}
 	datasetID := uuid.NewSHA1(
-		uuid.NewSHA1(uuid.NameSpaceDNS, []byte(conf.Orchestrator.ProjectFQDN)),
+		uuid.NewSHA1(uuid.NameSpaceDNS, []byte(orchestrateconf.ProjectFQDN())),
 		body).URN()
 
 	msg := mapping{
@@ -351,18 +339,16 @@ func mappingMessage(body []byte, conf *config.Config) ([]byte, any) {
 		AccessionIDs: []string{message.AccessionID},
 	}
 
-	publish, _ := json.Marshal(&msg)
-
-	return publish, new(mapping)
+	return json.Marshal(&msg)
 }
 
-func releaseMessage(body []byte, conf *config.Config) ([]byte, any) {
+func releaseMessage(body []byte) ([]byte, error) {
 	var message finalize
 	if err := json.Unmarshal(body, &message); err != nil {
-		return nil, nil
+		return nil, err
 	}
 	datasetID := uuid.NewSHA1(
-		uuid.NewSHA1(uuid.NameSpaceDNS, []byte(conf.Orchestrator.ProjectFQDN)),
+		uuid.NewSHA1(uuid.NameSpaceDNS, []byte(orchestrateconf.ProjectFQDN())),
 		body).URN()
 
 	msg := mapping{
@@ -370,26 +356,5 @@ func releaseMessage(body []byte, conf *config.Config) ([]byte, any) {
 		DatasetID: datasetID,
 	}
 
-	publish, _ := json.Marshal(&msg)
-
-	return publish, new(mapping)
-}
-
-func validateMsg(delivered *amqp091.Delivery, mq *broker.AMQPBroker, routingKey string, routingSchema string, publishMsg []byte, publishType any) error {
-	err := schema.ValidateJSON(fmt.Sprintf("%s/%s.json", mq.Conf.SchemasPath, routingSchema), delivered.Body)
-	if err != nil {
-		return err
-	}
-
-	log.Debugf("Routing message (correlation-id: %s, routingkey: %s, message: %s)", delivered.CorrelationId, routingKey, publishMsg)
-
-	if err := mq.SendMessage(delivered.CorrelationId, mq.Conf.Exchange, routingKey, publishMsg); err != nil {
-		// TODO fix resend mechanism
-		log.Errorln("We need to fix this resend stuff ...")
-	}
-	if err := delivered.Ack(false); err != nil {
-		log.Errorf("failed to ack message for reason: %v", err)
-	}
-
-	return nil
+	return json.Marshal(&msg)
 }
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	orchestrateconf "github.com/neicnordic/sensitive-data-archive/cmd/orchestrate/config"
+	brokerv2 "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/broker/v2/memory"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/suite"
+)
+
+const testChecksums = `[{"type": "sha256", "value": "82e4e60e7beb3db2e06a00a079788f7d71f75b61a4b75f28c4c942703dabb6d6"}]`
+
+type TestSuite struct {
+	suite.Suite
+	broker *memory.Broker
+	app    Orchestrate
+}
+
+func TestOrchestrateTestSuite(t *testing.T) {
+	suite.Run(t, new(TestSuite))
+}
+
+func (ts *TestSuite) SetupSuite() {
+	orchestrateconf.SetSchemaPath("../../schemas/isolated")
+	orchestrateconf.SetQueues("inbox", "verified", "completed", "ingest", "accessionIDs", "mappings")
+	orchestrateconf.SetProjectFQDN("sda.test")
+}
+
+func (ts *TestSuite) SetupTest() {
+	viper.Set("log.level", "debug")
+	orchestrateconf.SetReleaseDelay(0)
+
+	ts.broker = memory.NewMemoryBroker()
+	ts.app = Orchestrate{Broker: ts.broker}
+}
+
+func (ts *TestSuite) TestHandleMessage_Inbox() {
+	body := []byte(`{"operation": "upload", "user": "dummy", "filepath": "dummy/file.c4gh", "filesize": 1024, "file_last_modified": 1700000000}`)
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), "inbox", &brokerv2.Message{Key: "correlation-id", Body: body})
+	ts.NoError(err)
+	ts.Empty(callbacks)
+
+	messages := ts.broker.Messages("ingest")
+	ts.Len(messages, 1)
+	ts.Equal("correlation-id", messages[0].Key)
+	ts.JSONEq(`{"type": "ingest", "user": "dummy", "filepath": "dummy/file.c4gh"}`, string(messages[0].Body))
+
+	body = []byte(`{"operation": "upload", "user": "dummy", "filepath": "dummy/file.c4gh", "encrypted_checksums": ` + testChecksums + `}`)
+	_, err = ts.app.handleMessage(context.TODO(), "inbox", &brokerv2.Message{Key: "correlation-id", Body: body})
+	ts.NoError(err)
+	ts.JSONEq(`{"type": "ingest", "user": "dummy", "filepath": "dummy/file.c4gh", "encrypted_checksums": `+testChecksums+`}`, string(ts.broker.Messages("ingest")[1].Body))
+}
+
+func (ts *TestSuite) TestHandleMessage_InboxRename() {
+	body := []byte(`{"operation": "rename", "user": "dummy", "filepath": "dummy/new.c4gh", "oldpath": "dummy/file.c4gh"}`)
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), "inbox", &brokerv2.Message{Body: body})
+	ts.NoError(err)
+	ts.Empty(callbacks)
+	ts.Empty(ts.broker.Messages("ingest"))
+}
+
+func (ts *TestSuite) TestHandleMessage_Verified() {
+	body := []byte(`{"user": "dummy", "filepath": "dummy/file.c4gh", "decrypted_checksums": ` + testChecksums + `}`)
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), "verified", &brokerv2.Message{Key: "correlation-id", Body: body})
+	ts.NoError(err)
+	ts.Empty(callbacks)
+
+	messages := ts.broker.Messages("accessionIDs")
+	ts.Len(messages, 1)
+	var accession finalize
+	ts.NoError(json.Unmarshal(messages[0].Body, &accession))
+	ts.Equal("accession", accession.Type)
+	ts.Contains(accession.AccessionID, "urn:uuid:")
+
+	// The same file is given the same accession ID when redelivered
+	_, err = ts.app.handleMessage(context.TODO(), "verified", &brokerv2.Message{Key: "correlation-id", Body: body})
+	ts.NoError(err)
+	ts.Equal(messages[0].Body, ts.broker.Messages("accessionIDs")[1].Body)
+}
+
+func (ts *TestSuite) TestHandleMessage_Completed() {
+	body := []byte(`{"user": "dummy", "filepath": "dummy/file.c4gh", "accession_id": "urn:uuid:0b7a5e2a-1c5b-5c1e-8f0e-6b5b2c1d3e4f", "decrypted_checksums": ` + testChecksums + `}`)
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), "completed", &brokerv2.Message{Key: "correlation-id", Body: body})
+	ts.NoError(err)
+	ts.Empty(callbacks)
+
+	messages := ts.broker.Messages("mappings")
+	ts.Len(messages, 2)
+	var mapped, released mapping
+	ts.NoError(json.Unmarshal(messages[0].Body, &mapped))
+	ts.NoError(json.Unmarshal(messages[1].Body, &released))
+	ts.Equal("mapping", mapped.Type)
+	ts.Equal([]string{"urn:uuid:0b7a5e2a-1c5b-5c1e-8f0e-6b5b2c1d3e4f"}, mapped.AccessionIDs)
+	ts.Equal("release", released.Type)
+	ts.Equal(mapped.DatasetID, released.DatasetID)
+}
+
+func (ts *TestSuite) TestHandleMessage_CompletedInterrupted() {
+	orchestrateconf.SetReleaseDelay(time.Hour)
+	body := []byte(`{"user": "dummy", "filepath": "dummy/file.c4gh", "accession_id": "urn:uuid:0b7a5e2a-1c5b-5c1e-8f0e-6b5b2c1d3e4f", "decrypted_checksums": ` + testChecksums + `}`)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+
+	// The message is retried when the service shuts down before the dataset was released
+	_, err := ts.app.handleMessage(ctx, "completed", &brokerv2.Message{Body: body})
+	ts.ErrorIs(err, context.Canceled)
+	ts.Len(ts.broker.Messages("mappings"), 1)
+}
+
+func (ts *TestSuite) TestHandleMessage_InvalidMessage() {
+	for queue, body := range map[string]string{
+		"inbox":     `{"operation": "unknown", "user": "dummy", "filepath": "dummy/file.c4gh"}`,
+		"verified":  `{"user": "dummy", "filepath": "dummy/file.c4gh"}`,
+		"completed": `{"user": "dummy", "filepath": "dummy/file.c4gh", "decrypted_checksums": ` + testChecksums + `}`,
+	} {
+		callbacks, err := ts.app.handleMessage(context.TODO(), queue, &brokerv2.Message{Body: []byte(body)})
+		ts.NoError(err)
+		for _, callback := range callbacks {
+			callback()
+		}
+	}
+
+	ts.Len(ts.broker.Messages(brokerv2.ErrorQueue), 3)
+	ts.Empty(ts.broker.Messages("ingest"))
+	ts.Empty(ts.broker.Messages("accessionIDs"))
+	ts.Empty(ts.broker.Messages("mappings"))
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"math"
 	"net"
@@ -50,6 +51,8 @@ type hServer struct {
 // but encrypted with the new public key. If a dataeditlist is provided and contains at
 // least one entry it is added to the new header, replacing any existing dataeditlist. If
 // no dataeditlist is passed and one exists already, it is kept in the new header.
+// The hash of the public key matching the private key that decrypted the original header
+// is returned along with the new header.
 func (s *server) ReencryptHeader(_ context.Context, in *re.ReencryptRequest) (*re.ReencryptResponse, error) {
 	log.Debugf("Received Public key: %v", in.GetPublickey())
 	log.Debugf("Received previous crypt4gh header: %v", in.GetOldheader())
@@ -101,7 +104,9 @@ func (s *server) ReencryptHeader(_ context.Context, in *re.ReencryptRequest) (*r
 	for _, key := range s.c4ghPrivateKeyList {
 		newheader, err := headers.ReEncryptHeader(in.GetOldheader(), *key, newReaderPublicKeyList, extraHeaderPackets...)
 		if err == nil {
-			return &re.ReencryptResponse{Header: newheader}, nil
+			publicKey := keys.DerivePublicKey(*key)
+
+			return &re.ReencryptResponse{Header: newheader, Keyhash: hex.EncodeToString(publicKey[:])}, nil
 		}
 	}
 
@@ -122,6 +122,9 @@ func (ts *ReEncryptTests) TestReencryptHeader() {
 	assert.NoError(ts.T(), err)
 	assert.Equal(ts.T(), "crypt4gh", string(res.Header[:8]))
 
+	archivePublicKey := keys.DerivePublicKey(*ts.PrivateKeyList[0])
+	assert.Equal(ts.T(), hex.EncodeToString(archivePublicKey[:]), res.GetKeyhash())
+
 	hr := bytes.NewReader(res.Header)
 	fileStream := io.MultiReader(hr, bytes.NewReader(ts.FileData))
 
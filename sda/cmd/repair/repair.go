@@ -0,0 +1,237 @@
+// The repair service restores the archive copy of an archived file from its
+// backup copy, for files which archive copy has been lost or corrupted.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	repairconf "github.com/neicnordic/sensitive-data-archive/cmd/repair/config"
+	brokerv2 "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/broker/v2/factory"
+	configv2 "github.com/neicnordic/sensitive-data-archive/internal/config/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/database/postgres"
+	"github.com/neicnordic/sensitive-data-archive/internal/helper"
+	"github.com/neicnordic/sensitive-data-archive/internal/schema"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/locationbroker"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	log "github.com/sirupsen/logrus"
+)
+
+type Repair struct {
+	ArchiveWriter storage.Writer
+	BackupReader  storage.Reader
+	Broker        brokerv2.Broker
+	db            database.Database
+}
+
+// errBackupCorrupted is returned when the backup copy does not match the size and checksum of the archived file
+var errBackupCorrupted = errors.New("backup copy is corrupted")
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run() error {
+	var err error
+	app := Repair{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err = configv2.Load(); err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
+
+	app.Broker, err = factory.NewBroker(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to initialize mq broker, due to: %v", err)
+	}
+	defer func() {
+		if err := app.Broker.Close(); err != nil {
+			log.Errorf("could not close Broker, due to: %v", err)
+		}
+	}()
+
+	app.db, err = postgres.NewPostgresSQLDatabase()
+	if err != nil {
+		return fmt.Errorf("failed to initialize sda db due to: %v", err)
+	}
+	defer app.db.Close()
+	if dbSchemaVersion, err := app.db.SchemaVersion(); err != nil || dbSchemaVersion < 28 {
+		return errors.Join(errors.New("database schema v28 is required"), err)
+	}
+
+	storageLocationBroker, err := locationbroker.NewLocationBroker(app.db)
+	if err != nil {
+		return fmt.Errorf("failed to initialize location broker, due to: %v", err)
+	}
+	app.ArchiveWriter, err = storage.NewWriter(ctx, "archive", storageLocationBroker)
+	if err != nil {
+		return fmt.Errorf("failed to initialize archive writer, due to: %v", err)
+	}
+	app.BackupReader, err = storage.NewReader(ctx, "backup")
+	if err != nil {
+		return fmt.Errorf("failed to initialize backup reader, due to: %v", err)
+	}
+	log.Info("starting repair service")
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+
+	consumeErr := make(chan error, 1)
+	go func() {
+		consumeErr <- app.Broker.Subscribe(ctx, repairconf.SourceQueue(), app.handleMessage)
+	}()
+
+	select {
+	case sig := <-sigc:
+		log.Infof("recieved signal: %v, shutting down gracefully", sig)
+		cancel()
+
+		return nil
+	case err := <-consumeErr:
+		if !errors.Is(err, context.Canceled) {
+			log.Errorf("failed to consume from %s, due to: %v", repairconf.SourceQueue(), err)
+			cancel()
+
+			return err
+		}
+
+		return nil
+	}
+}
+
+func (app *Repair) handleMessage(ctx context.Context, message *brokerv2.Message) ([]func(), error) {
+	err := schema.ValidateJSON(fmt.Sprintf("%s/repair-file.json", repairconf.SchemaPath()), message.Body)
+	if err != nil {
+		log.Errorf("could not validate message: %s, due to: %v", message.Key, err)
+
+		return []func(){app.errorQueue(message)}, nil
+	}
+
+	var repairFile schema.RepairFile
+	if err := json.Unmarshal(message.Body, &repairFile); err != nil {
+		log.Errorf("could not unmarshall message, due to: %v", err)
+
+		return []func(){app.errorQueue(message)}, nil
+	}
+	log.Infof("received work (correlation-id: %s, file-id: %s)", message.Key, repairFile.FileID)
+
+	return app.repairFile(ctx, repairFile.FileID, message)
+}
+
+// repairFile writes the backup copy of the file to the archive, after verifying it against the archived checksum,
+// and registers the new archive location of the file
+func (app *Repair) repairFile(ctx context.Context, fileID string, message *brokerv2.Message) ([]func(), error) {
+	archiveData, err := app.db.GetArchived(ctx, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get archive data of file: %s, due to: %v", fileID, err)
+	}
+	if archiveData == nil {
+		log.Errorf("file: %s has not been archived, can not be repaired", fileID)
+
+		return []func(){app.errorQueue(message)}, nil
+	}
+	if archiveData.BackupFilePath == "" || archiveData.BackupLocation == "" {
+		log.Errorf("file: %s has not been backed up, can not be repaired", fileID)
+
+		return []func(){app.errorQueue(message), app.setErrorEvent(fileID, "file has no backup copy to repair from", message)}, nil
+	}
+
+	verificationData, err := app.db.GetReVerificationDataFromFileID(ctx, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get archived checksum of file: %s, due to: %v", fileID, err)
+	}
+	if verificationData.ArchivedCheckSumType != "sha256" {
+		log.Errorf("archived checksum of file: %s is of unsupported type: %s", fileID, verificationData.ArchivedCheckSumType)
+
+		return []func(){app.errorQueue(message), app.setErrorEvent(fileID, "unsupported archived checksum type", message)}, nil
+	}
+
+	backupSize, err := app.BackupReader.GetFileSize(ctx, archiveData.BackupLocation, archiveData.BackupFilePath)
+	switch {
+	case errors.Is(err, storageerrors.ErrorFileNotFoundInLocation):
+		log.Errorf("backup copy of file: %s not found, location: %s, path: %s", fileID, archiveData.BackupLocation, archiveData.BackupFilePath)
+
+		return []func(){app.errorQueue(message), app.setErrorEvent(fileID, "backup copy not found", message)}, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to get size of backup copy of file: %s, due to: %v", fileID, err)
+	case backupSize != archiveData.FileSize:
+		log.Errorf("size of backup copy of file: %s, size: %d does not match archived size: %d", fileID, backupSize, archiveData.FileSize)
+
+		return []func(){app.errorQueue(message), app.setErrorEvent(fileID, "size of backup copy does not match archived size", message)}, nil
+	}
+
+	backupFile, err := app.BackupReader.NewFileReader(ctx, archiveData.BackupLocation, archiveData.BackupFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup copy of file: %s, due to: %v", fileID, err)
+	}
+	defer func() {
+		_ = backupFile.Close()
+	}()
+
+	// The backup copy is verified while written, so that a corrupted backup copy fails the write rather than
+	// replacing the archive copy
+	verifier := helper.NewVerifyingReader(backupFile, archiveData.FileSize, verificationData.ArchivedCheckSum)
+	location, err := app.ArchiveWriter.WriteFile(ctx, archiveData.FilePath, verifier)
+	if verifier.Err() != nil {
+		reason := fmt.Errorf("%w: %v", errBackupCorrupted, verifier.Err())
+		log.Errorf("backup copy of file: %s could not be verified, reason: %v", fileID, reason)
+
+		return []func(){app.errorQueue(message), app.setErrorEvent(fileID, reason.Error(), message)}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write backup copy of file: %s to archive, due to: %v", fileID, err)
+	}
+
+	if err := app.db.SetArchiveLocation(ctx, fileID, location, archiveData.FilePath); err != nil {
+		return nil, fmt.Errorf("failed to set archive location of file: %s, due to: %v", fileID, err)
+	}
+
+	// The previous copy is no longer referenced when the file was restored to another location
+	if archiveData.Location != "" && archiveData.Location != location {
+		if err := app.ArchiveWriter.RemoveFile(ctx, archiveData.Location, archiveData.FilePath); err != nil {
+			log.Warnf("failed to remove previous archive copy of file: %s, location: %s, due to: %v", fileID, archiveData.Location, err)
+		}
+	}
+
+	// The status of the file is kept, so that the file continues where it was before the repair
+	if err := app.db.SetRepaired(ctx, fileID, string(message.Body)); err != nil {
+		log.Errorf("failed to set repaired event for file: %s, due to: %v", fileID, err)
+	}
+	log.Infof("repaired archive copy of file: %s, location: %s", fileID, location)
+
+	return nil, nil
+}
+
+func (app *Repair) setErrorEvent(fileID, details string, message *brokerv2.Message) func() {
+	return func() {
+		detailsMap := map[string]string{
+			"error": details,
+		}
+
+		detailsJSON, err := json.Marshal(detailsMap)
+		if err != nil {
+			log.Errorf("failed to marshal details to JSON, due to: %v", err)
+			detailsJSON = []byte("{}")
+		}
+		err = app.db.UpdateFileEventLog(context.Background(), fileID, "error", "repair", string(detailsJSON), string(message.Body))
+		if err != nil {
+			log.Errorf("error from database when setting error event, due to: %v", err)
+		}
+	}
+}
+
+func (app *Repair) errorQueue(message *brokerv2.Message) func() {
+	return brokerv2.ErrorQueueCallback(app.Broker, message, "Failed to repair file", nil)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	repairconf "github.com/neicnordic/sensitive-data-archive/cmd/repair/config"
+	brokerv2 "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/suite"
+)
+
+type TestSuite struct {
+	suite.Suite
+	content []byte
+	db      *mockDatabase
+	broker  *mockBroker
+	writer  *mockWriter
+	backup  *mockReader
+	app     Repair
+}
+
+func TestRepairTestSuite(t *testing.T) {
+	suite.Run(t, new(TestSuite))
+}
+
+// mockDatabase implements the database functions used by repair, calling any other function panics
+type mockDatabase struct {
+	database.Database
+	archiveData      *database.ArchiveData
+	archivedChecksum string
+	location         string
+	events           []string
+}
+
+func (m *mockDatabase) GetArchived(_ context.Context, _ string) (*database.ArchiveData, error) {
+	return m.archiveData, nil
+}
+
+func (m *mockDatabase) GetReVerificationDataFromFileID(_ context.Context, fileID string) (*database.ReVerificationData, error) {
+	return &database.ReVerificationData{
+		FileID:               fileID,
+		ArchivedCheckSumType: "sha256",
+		ArchivedCheckSum:     m.archivedChecksum,
+	}, nil
+}
+
+func (m *mockDatabase) SetArchiveLocation(_ context.Context, _, location, _ string) error {
+	m.location = location
+
+	return nil
+}
+
+func (m *mockDatabase) SetRepaired(_ context.Context, _, _ string) error {
+	m.events = append(m.events, "repaired")
+
+	return nil
+}
+
+func (m *mockDatabase) UpdateFileEventLog(_ context.Context, _, event, _, _, _ string) error {
+	m.events = append(m.events, event)
+
+	return nil
+}
+
+type mockBroker struct {
+	brokerv2.Broker
+	published map[string]int
+}
+
+func (m *mockBroker) Publish(_ context.Context, destination string, _ brokerv2.Message) error {
+	m.published[destination]++
+
+	return nil
+}
+
+type mockReader struct {
+	files map[string][]byte
+}
+
+func (r *mockReader) file(location, filePath string) ([]byte, error) {
+	content, ok := r.files[location+"/"+filePath]
+	if !ok {
+		return nil, storageerrors.ErrorFileNotFoundInLocation
+	}
+
+	return content, nil
+}
+
+func (r *mockReader) NewFileReader(_ context.Context, location, filePath string) (io.ReadCloser, error) {
+	content, err := r.file(location, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+func (r *mockReader) NewFileReadSeeker(_ context.Context, _, _ string) (io.ReadSeekCloser, error) {
+	return nil, errors.New("not implemented")
+}
+func (r *mockReader) FindFile(_ context.Context, _ string) (string, error) {
+	return "", errors.New("not implemented")
+}
+func (r *mockReader) GetFileSize(_ context.Context, location, filePath string) (int64, error) {
+	content, err := r.file(location, filePath)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(content)), nil
+}
+func (r *mockReader) Ping(_ context.Context) error { return nil }
+
+// mockWriter stores written files in memory at its location, a failed write leaves no file behind
+type mockWriter struct {
+	location string
+	files    map[string][]byte
+	removed  []string
+}
+
+func (w *mockWriter) WriteFile(_ context.Context, filePath string, fileContent io.Reader) (string, error) {
+	content, err := io.ReadAll(fileContent)
+	if err != nil {
+		return "", fmt.Errorf("failed to write file: %s, due to: %v", filePath, err)
+	}
+	w.files[w.location+"/"+filePath] = content
+
+	return w.location, nil
+}
+
+func (w *mockWriter) RemoveFile(_ context.Context, location, filePath string) error {
+	w.removed = append(w.removed, location+"/"+filePath)
+
+	return nil
+}
+
+func (ts *TestSuite) SetupSuite() {
+	repairconf.SetSchemaPath("../../schemas/isolated")
+}
+
+func (ts *TestSuite) SetupTest() {
+	viper.Set("log.level", "debug")
+
+	ts.content = bytes.Repeat([]byte("archived content"), 1000)
+	ts.db = &mockDatabase{
+		archiveData: &database.ArchiveData{
+			FilePath:       "file-path",
+			Location:       "/archive",
+			FileSize:       int64(len(ts.content)),
+			BackupFilePath: "file-path",
+			BackupLocation: "/backup",
+		},
+		archivedChecksum: fmt.Sprintf("%x", sha256.Sum256(ts.content)),
+	}
+	ts.broker = &mockBroker{published: make(map[string]int)}
+	ts.writer = &mockWriter{location: "/archive", files: make(map[string][]byte)}
+	ts.backup = &mockReader{files: map[string][]byte{"/backup/file-path": ts.content}}
+	ts.app = Repair{
+		ArchiveWriter: ts.writer,
+		BackupReader:  ts.backup,
+		Broker:        ts.broker,
+		db:            ts.db,
+	}
+}
+
+func (ts *TestSuite) repairMessage() *brokerv2.Message {
+	return &brokerv2.Message{
+		Key:  "c2e3c5b0-6a9d-4d1b-9d4e-3d0c6a1e9f4b",
+		Body: []byte(`{"type": "repair", "file_id": "c2e3c5b0-6a9d-4d1b-9d4e-3d0c6a1e9f4b"}`),
+	}
+}
+
+func runCallbacks(callbacks []func()) {
+	for _, callback := range callbacks {
+		callback()
+	}
+}
+
+func (ts *TestSuite) TestHandleMessage() {
+	callbacks, err := ts.app.handleMessage(context.TODO(), ts.repairMessage())
+	ts.NoError(err)
+	ts.Empty(callbacks)
+	ts.Equal(ts.content, ts.writer.files["/archive/file-path"])
+	ts.Equal("/archive", ts.db.location)
+	ts.Equal([]string{"repaired"}, ts.db.events)
+	ts.Empty(ts.writer.removed)
+}
+
+func (ts *TestSuite) TestHandleMessage_NewLocation() {
+	ts.writer.location = "/archive2"
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), ts.repairMessage())
+	ts.NoError(err)
+	ts.Empty(callbacks)
+	ts.Equal(ts.content, ts.writer.files["/archive2/file-path"])
+	ts.Equal("/archive2", ts.db.location)
+	ts.Equal([]string{"/archive/file-path"}, ts.writer.removed)
+}
+
+func (ts *TestSuite) TestHandleMessage_InvalidMessage() {
+	message := ts.repairMessage()
+	message.Body = []byte(`{"type": "repair", "file_id": "not-a-uuid"}`)
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), message)
+	ts.NoError(err)
+	runCallbacks(callbacks)
+	ts.Equal(1, ts.broker.published["error"])
+	ts.Empty(ts.writer.files)
+}
+
+func (ts *TestSuite) TestHandleMessage_NotBackedUp() {
+	ts.db.archiveData.BackupFilePath = ""
+	ts.db.archiveData.BackupLocation = ""
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), ts.repairMessage())
+	ts.NoError(err)
+	runCallbacks(callbacks)
+	ts.Equal(1, ts.broker.published["error"])
+	ts.Equal([]string{"error"}, ts.db.events)
+	ts.Empty(ts.writer.files)
+}
+
+func (ts *TestSuite) TestHandleMessage_MissingBackupCopy() {
+	delete(ts.backup.files, "/backup/file-path")
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), ts.repairMessage())
+	ts.NoError(err)
+	runCallbacks(callbacks)
+	ts.Equal(1, ts.broker.published["error"])
+	ts.Equal([]string{"error"}, ts.db.events)
+	ts.Empty(ts.writer.files)
+}
+
+func (ts *TestSuite) TestHandleMessage_CorruptedBackupCopy() {
+	corrupted := bytes.Clone(ts.content)
+	corrupted[100] ^= 0xff
+	ts.backup.files["/backup/file-path"] = corrupted
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), ts.repairMessage())
+	ts.NoError(err)
+	runCallbacks(callbacks)
+	ts.Equal(1, ts.broker.published["error"])
+	ts.Equal([]string{"error"}, ts.db.events)
+	ts.Empty(ts.writer.files)
+	ts.Empty(ts.db.location)
+}
+
+func (ts *TestSuite) TestHandleMessage_TruncatedBackupCopy() {
+	ts.backup.files["/backup/file-path"] = ts.content[:100]
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), ts.repairMessage())
+	ts.NoError(err)
+	runCallbacks(callbacks)
+	ts.Equal(1, ts.broker.published["error"])
+	ts.Empty(ts.writer.files)
+}
@@ -19,168 +19,149 @@ import (
 	"syscall"
 
 	"github.com/neicnordic/crypt4gh/keys"
-	"github.com/neicnordic/sensitive-data-archive/internal/broker"
+	rotatekeyconf "github.com/neicnordic/sensitive-data-archive/cmd/rotatekey/config"
+	brokerv2 "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/broker/v2/factory"
 	"github.com/neicnordic/sensitive-data-archive/internal/config"
 	configv2 "github.com/neicnordic/sensitive-data-archive/internal/config/v2"
 	"github.com/neicnordic/sensitive-data-archive/internal/database"
 	"github.com/neicnordic/sensitive-data-archive/internal/database/postgres"
 	"github.com/neicnordic/sensitive-data-archive/internal/reencrypt"
 	"github.com/neicnordic/sensitive-data-archive/internal/schema"
-	"github.com/rabbitmq/amqp091-go"
 	log "github.com/sirupsen/logrus"
 )
 
 type RotateKey struct {
-	Conf          *config.Config
-	MQ            *broker.AMQPBroker
+	Broker        brokerv2.Broker
 	db            database.Database
+	PublicKey     *[32]byte
 	PubKeyEncoded string
+	Grpc          config.Grpc
 }
 
-func main() {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+// errRotationKeyNotUsable is returned when the rotation key has been deprecated or removed after the service started,
+// the service stops as no file can be rotated with the key
+var errRotationKeyNotUsable = errors.New("rotation key can not be used")
 
-	if err := configv2.Load(); err != nil {
-		panic(fmt.Errorf("failed to load config: %v", err))
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
 	}
+}
 
-	app := RotateKey{}
+func run() error {
 	var err error
+	app := RotateKey{}
 
-	sigc := make(chan os.Signal, 5)
-	signal.Notify(sigc, os.Interrupt, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
-
-	// Create a function to handle panic and exit gracefully
-	defer func() {
-		if err := recover(); err != nil {
-			if app.MQ != nil {
-				defer app.MQ.Channel.Close()
-				defer app.MQ.Connection.Close()
-			}
-			if app.db != nil {
-				defer app.db.Close()
-			}
-			log.Fatal(err)
-		}
-	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	forever := make(chan bool)
+	if err = configv2.Load(); err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
 
-	app.Conf, err = config.NewConfig("rotatekey")
+	app.PublicKey, err = config.GetC4GHPublicKey(rotatekeyconf.RotatePubKeyPath())
+	if err != nil {
+		return fmt.Errorf("failed to get c4gh rotation public key, due to: %v", err)
+	}
+	app.Grpc, err = config.GetReEncryptClientConfig()
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("failed to load reencrypt client config, due to: %v", err)
 	}
-	app.MQ, err = broker.NewMQ(app.Conf.Broker)
+
+	app.Broker, err = factory.NewBroker(ctx)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("failed to initialize mq broker, due to: %v", err)
 	}
+	defer func() {
+		if err := app.Broker.Close(); err != nil {
+			log.Errorf("could not close Broker, due to: %v", err)
+		}
+	}()
+
 	app.db, err = postgres.NewPostgresSQLDatabase()
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("failed to initialize sda db, due to: %v", err)
 	}
+	defer app.db.Close()
 	if dbSchemaVersion, err := app.db.SchemaVersion(); err != nil || dbSchemaVersion < 23 {
-		panic(errors.Join(errors.New("database schema v23 is required"), err))
+		return errors.Join(errors.New("database schema v23 is required"), err)
 	}
 
-	go func() {
-		<-sigc // blocks here until it receives from sigc
-		_, _ = fmt.Println("Interrupt signal received. Shutting down.")
-		defer app.MQ.Channel.Close()
-		defer app.MQ.Connection.Close()
-		defer app.db.Close()
-
-		os.Exit(0) // exit program
-	}()
-
 	// encode pubkey as pem and then as base64 string
 	tmp := &bytes.Buffer{}
-	if err := keys.WriteCrypt4GHX25519PublicKey(tmp, *app.Conf.RotateKey.PublicKey); err != nil {
-		panic(err)
+	if err := keys.WriteCrypt4GHX25519PublicKey(tmp, *app.PublicKey); err != nil {
+		return fmt.Errorf("failed to encode rotation public key, due to: %v", err)
 	}
 	app.PubKeyEncoded = base64.StdEncoding.EncodeToString(tmp.Bytes())
 
 	// Check that key is registered in the db at startup
-	err = app.checkKeyHash(ctx, hex.EncodeToString(app.Conf.RotateKey.PublicKey[:]))
-	if err != nil {
-		panic(fmt.Errorf("database lookup of the rotation key failed, reason: %v", err))
+	if err := app.checkKeyHash(ctx, hex.EncodeToString(app.PublicKey[:])); err != nil {
+		return fmt.Errorf("database lookup of the rotation key failed, reason: %v", err)
 	}
+	log.Info("Starting rotatekey service")
 
-	go func() {
-		connError := app.MQ.ConnectionWatcher()
-		log.Error(connError)
-		forever <- false
-	}()
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 
+	keyErr := make(chan error, 1)
+	consumeErr := make(chan error, 1)
 	go func() {
-		connError := app.MQ.ChannelWatcher()
-		log.Error(connError)
-		forever <- false
+		consumeErr <- app.Broker.Subscribe(ctx, rotatekeyconf.SourceQueue(), func(ctx context.Context, message *brokerv2.Message) ([]func(), error) {
+			callbacks, err := app.handleMessage(ctx, message)
+			if errors.Is(err, errRotationKeyNotUsable) {
+				select {
+				case keyErr <- err:
+				default:
+				}
+			}
+
+			return callbacks, err
+		})
 	}()
 
-	log.Info("Starting rotatekey service")
+	select {
+	case sig := <-sigc:
+		log.Infof("recieved signal: %v, shutting down gracefully", sig)
+		cancel()
 
-	go func() {
-		// Create a function to handle panic and exit gracefully
-		defer func() {
-			if err := recover(); err != nil {
-				if app.MQ != nil {
-					defer app.MQ.Channel.Close()
-					defer app.MQ.Connection.Close()
-				}
-				if app.db != nil {
-					defer app.db.Close()
-				}
-				log.Fatal(err)
-			}
-		}()
-		messages, err := app.MQ.GetMessages(app.Conf.Broker.Queue)
-		if err != nil {
-			panic(err)
-		}
-		for delivered := range messages {
-			app.handleMessage(delivered)
+		return nil
+	case err := <-keyErr:
+		cancel()
+
+		return err
+	case err := <-consumeErr:
+		if !errors.Is(err, context.Canceled) {
+			log.Errorf("failed to consume from %s, due to: %v", rotatekeyconf.SourceQueue(), err)
+			cancel()
+
+			return err
 		}
-	}()
 
-	<-forever
+		return nil
+	}
 }
 
-func (app *RotateKey) handleMessage(delivered amqp091.Delivery) {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
+func (app *RotateKey) handleMessage(ctx context.Context, delivered *brokerv2.Message) ([]func(), error) {
 	log.Debugf("Received a message (correlation-id: %s, message: %s)",
-		delivered.CorrelationId,
+		delivered.Key,
 		delivered.Body)
 
-	err := schema.ValidateJSON(fmt.Sprintf("%s/rotate-key.json", app.Conf.Broker.SchemasPath), delivered.Body)
+	err := schema.ValidateJSON(fmt.Sprintf("%s/rotate-key.json", rotatekeyconf.SchemaPath()), delivered.Body)
 	if err != nil {
 		msg := "validation of incoming message (rotate-key) failed"
 		log.Errorf("%s, reason: %v", msg, err)
-		// Ack message and send the payload to an error queue so it can be analyzed.
-		infoErrorMessage := broker.InfoError{
-			Error:           msg,
-			Reason:          err.Error(),
-			OriginalMessage: string(delivered.Body),
-		}
-		body, _ := json.Marshal(infoErrorMessage)
-		if err := app.MQ.SendMessage(delivered.CorrelationId, app.Conf.Broker.Exchange, "error", body); err != nil {
-			log.Errorf("failed to publish message, reason: (%s)", err.Error())
-		}
-		if err := delivered.Ack(false); err != nil {
-			log.Errorf("failed to Ack message, reason: (%s)", err.Error())
-		}
 
-		return
+		// Ack message and send the payload to an error queue so it can be analyzed.
+		return []func(){brokerv2.ErrorQueueCallback(app.Broker, delivered, msg, err)}, nil
 	}
 
 	// Fetch rotate key hash before starting work so that we make sure the hash state
 	// has not changed since the application startup.
-	keyhash := hex.EncodeToString(app.Conf.RotateKey.PublicKey[:])
-	// exit app if target key was modified after app start-up, e.g. if key has been deprecated
+	keyhash := hex.EncodeToString(app.PublicKey[:])
+	// stop the service if target key was modified after start-up, e.g. if key has been deprecated
 	if err = app.checkKeyHash(ctx, keyhash); err != nil {
-		panic(fmt.Errorf("check of target key failed, reason: %v", err))
+		return nil, fmt.Errorf("%w: check of target key failed, reason: %v", errRotationKeyNotUsable, err)
 	}
 
 	var message schema.KeyRotation
@@ -191,31 +172,12 @@ func (app *RotateKey) handleMessage(delivered amqp091.Delivery) {
 
 	switch ackNack {
 	case "ack":
-		if err := delivered.Ack(false); err != nil {
-			log.Errorf("failed to ack message, reason: %v", err)
-		}
-	case "ackSendToError":
-		infoErrorMessage := broker.InfoError{
-			Error:           msg,
-			Reason:          err.Error(),
-			OriginalMessage: string(delivered.Body),
-		}
-		body, _ := json.Marshal(infoErrorMessage)
-		if err := app.MQ.SendMessage(delivered.CorrelationId, app.Conf.Broker.Exchange, "error", body); err != nil {
-			log.Errorf("failed to publish message, reason: (%s)", err.Error())
-		}
-		if err := delivered.Ack(false); err != nil {
-			log.Errorf("failed to Ack message, reason: (%s)", err.Error())
-		}
+		return nil, nil
 	case "nackRequeue":
-		if err := delivered.Nack(false, true); err != nil {
-			log.Errorf("failed to Nack message, reason: %v", err)
-		}
+		return nil, fmt.Errorf("%s, reason: %v", msg, err)
 	default:
-		// will catch `reject`s, failures that should not be requeued.
-		if err := delivered.Reject(false); err != nil {
-			log.Errorf("failed to reject message, reason: %v", err)
-		}
+		// will catch `ackSendToError`s, failures that should not be requeued.
+		return []func(){brokerv2.ErrorQueueCallback(app.Broker, delivered, msg, err)}, nil
 	}
 }
 
@@ -235,7 +197,7 @@ func (app *RotateKey) reEncryptHeader(ctx context.Context, fileID string) (ackNa
 	}
 
 	// Check that the file is not already encrypted with the target key
-	keyhash := hex.EncodeToString(app.Conf.RotateKey.PublicKey[:])
+	keyhash := hex.EncodeToString(app.PublicKey[:])
 	if oldKeyHash == keyhash {
 		log.Infof("the file with file-id: %s is already encrypted with the given rotation c4gh key", fileID)
 
@@ -267,7 +229,7 @@ func (app *RotateKey) reEncryptHeader(ctx context.Context, fileID string) (ackNa
 		return "nackRequeue", msg, err
 	}
 
-	newHeader, err := reencrypt.CallReencryptHeader(header, app.PubKeyEncoded, app.Conf.RotateKey.Grpc)
+	newHeader, err := reencrypt.CallReencryptHeader(header, app.PubKeyEncoded, app.Grpc)
 	if err != nil {
 		msg := fmt.Sprintf("failed to rotate c4gh key for file %s", fileID)
 		log.Errorf("%s, reason: %v", msg, err)
@@ -304,7 +266,7 @@ func (app *RotateKey) reEncryptHeader(ctx context.Context, fileID string) (ackNa
 		ReVerify: true,
 	}
 	reVerifyMsg, _ := json.Marshal(&reVerify)
-	err = schema.ValidateJSON(fmt.Sprintf("%s/ingestion-verification.json", app.Conf.Broker.SchemasPath), reVerifyMsg)
+	err = schema.ValidateJSON(fmt.Sprintf("%s/ingestion-verification.json", rotatekeyconf.SchemaPath()), reVerifyMsg)
 	if err != nil {
 		msg := "Validation of outgoing re-verify message failed"
 		log.Errorf("%s, reason: %v", msg, err)
@@ -312,7 +274,7 @@ func (app *RotateKey) reEncryptHeader(ctx context.Context, fileID string) (ackNa
 		return "ackSendToError", msg, err
 	}
 
-	if err := app.MQ.SendMessage(fileID, app.Conf.Broker.Exchange, "archived", reVerifyMsg); err != nil {
+	if err := app.Broker.Publish(ctx, rotatekeyconf.ArchivedQueue(), brokerv2.Message{Key: fileID, Body: reVerifyMsg}); err != nil {
 		msg := "failed to publish message"
 		log.Errorf("%s, reason: %v", msg, err)
 
@@ -7,7 +7,6 @@ import (
 	"errors"
 	"fmt"
 	"net"
-	"net/http"
 	"os"
 	"path"
 	"runtime"
@@ -17,7 +16,8 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/neicnordic/crypt4gh/keys"
-	"github.com/neicnordic/sensitive-data-archive/internal/broker"
+	rotatekeyconf "github.com/neicnordic/sensitive-data-archive/cmd/rotatekey/config"
+	"github.com/neicnordic/sensitive-data-archive/internal/broker/v2/memory"
 	"github.com/neicnordic/sensitive-data-archive/internal/config"
 	"github.com/neicnordic/sensitive-data-archive/internal/database"
 	"github.com/neicnordic/sensitive-data-archive/internal/database/postgres"
@@ -31,7 +31,6 @@ import (
 	"google.golang.org/grpc/reflection"
 )
 
-var mqPort int
 var dbPort uint16
 
 func TestMain(m *testing.M) {
@@ -97,53 +96,6 @@ func TestMain(m *testing.M) {
 		log.Fatalf("Could not connect to postgres: %s", err)
 	}
 
-	// pulls an image, creates a container based on it and runs it
-	rabbitmq, err := pool.RunWithOptions(&dockertest.RunOptions{
-		Repository: "ghcr.io/neicnordic/sensitive-data-archive",
-		Tag:        "v3.0.0-rabbitmq",
-	}, func(config *docker.HostConfig) {
-		// set AutoRemove to true so that stopped container goes away by itself
-		config.AutoRemove = true
-		config.RestartPolicy = docker.RestartPolicy{
-			Name: "no",
-		}
-	})
-	if err != nil {
-		if err := pool.Purge(postgresContainer); err != nil {
-			log.Fatalf("Could not purge resource: %s", err)
-		}
-		log.Fatalf("Could not start resource: %s", err)
-	}
-
-	mqPort, _ = strconv.Atoi(rabbitmq.GetPort("5672/tcp"))
-	brokerAPI := rabbitmq.GetHostPort("15672/tcp")
-
-	client := http.Client{Timeout: 30 * time.Second}
-	req, err := http.NewRequest(http.MethodGet, "http://"+brokerAPI+"/api/queues/sda/", http.NoBody)
-	if err != nil {
-		log.Fatal(err)
-	}
-	req.SetBasicAuth("guest", "guest")
-
-	// exponential backoff-retry, because the application in the container might not be ready to accept connections yet
-	if err := pool.Retry(func() error {
-		res, err := client.Do(req) // #nosec G704 -- request controlled by unit test
-		if err != nil || res.StatusCode != 200 {
-			return err
-		}
-		_ = res.Body.Close()
-
-		return nil
-	}); err != nil {
-		if err := pool.Purge(postgresContainer); err != nil {
-			log.Fatalf("Could not purge resource: %s", err)
-		}
-		if err := pool.Purge(rabbitmq); err != nil {
-			log.Fatalf("Could not purge resource: %s", err)
-		}
-		log.Fatalf("Could not connect to rabbitmq: %s", err)
-	}
-
 	log.Println("starting tests")
 	code := m.Run()
 
@@ -151,9 +103,6 @@ func TestMain(m *testing.M) {
 	if err := pool.Purge(postgresContainer); err != nil {
 		log.Fatalf("Could not purge resource: %s", err)
 	}
-	if err := pool.Purge(rabbitmq); err != nil {
-		log.Fatalf("Could not purge resource: %s", err)
-	}
 
 	os.Exit(code)
 }
@@ -181,8 +130,7 @@ func (ts *TestSuite) TearDownSuite() {
 	}
 }
 func (ts *TestSuite) SetupSuite() {
-	ts.app.Conf = &config.Config{}
-	ts.app.Conf.Broker.SchemasPath = "../../schemas/isolated"
+	rotatekeyconf.SetSchemaPath("../../schemas/isolated")
 	var err error
 	ts.app.db, err = postgres.NewPostgresSQLDatabase(
 		postgres.Host("localhost"),
@@ -200,18 +148,7 @@ func (ts *TestSuite) SetupSuite() {
 	if err != nil {
 		ts.FailNow("Failed to create DB connection")
 	}
-	ts.app.MQ, err = broker.NewMQ(broker.MQConf{
-		Host:     "localhost",
-		Port:     mqPort,
-		User:     "guest",
-		Password: "guest",
-		Exchange: "sda",
-		Vhost:    "/sda",
-	})
-	if err != nil {
-		ts.T().Log(err.Error())
-		ts.FailNow("Failed to create MQ connection")
-	}
+	ts.app.Broker = memory.NewMemoryBroker()
 
 	publicKey, _, err := keys.GenerateKeyPair()
 	if err != nil {
@@ -224,7 +161,7 @@ func (ts *TestSuite) SetupSuite() {
 		}
 	}
 
-	ts.app.Conf.RotateKey.PublicKey = &publicKey
+	ts.app.PublicKey = &publicKey
 
 	ts.fileID, err = ts.app.db.RegisterFile(context.Background(), nil, "/inbox", "rotate-key-test/data.c4gh", "tester_example.org")
 	if err != nil {
@@ -278,7 +215,7 @@ func (ts *TestSuite) SetupSuite() {
 		ts.T().FailNow()
 	}
 
-	ts.app.Conf.RotateKey.Grpc = config.Grpc{
+	ts.app.Grpc = config.Grpc{
 		Host:    reHost,
 		Port:    rePortInt,
 		Timeout: 30,
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"crypto/md5" // #nosec G501 -- md5 is used as a checksum for the S3 ETag, not for security
+	"crypto/sha256"
+	"encoding"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+)
+
+// uploadHashes computes the sha256 and md5 checksums of the encrypted file while the upload is proxied
+type uploadHashes struct {
+	sha256 hash.Hash
+	md5    hash.Hash
+	size   int64
+}
+
+func newUploadHashes() *uploadHashes {
+	return &uploadHashes{
+		sha256: sha256.New(),
+		md5:    md5.New(), // #nosec G401 -- md5 is used as a checksum for the S3 ETag, not for security
+	}
+}
+
+func (h *uploadHashes) Write(p []byte) (int, error) {
+	_, _ = h.sha256.Write(p)
+	_, _ = h.md5.Write(p)
+	h.size += int64(len(p))
+
+	return len(p), nil
+}
+
+// restore continues the checksums from the state stored after a previous part of a multipart upload
+func (h *uploadHashes) restore(state *database.UploadChecksumState) error {
+	if err := h.sha256.(encoding.BinaryUnmarshaler).UnmarshalBinary(state.SHA256State); err != nil {
+		return fmt.Errorf("failed to restore sha256 state: %v", err)
+	}
+	if err := h.md5.(encoding.BinaryUnmarshaler).UnmarshalBinary(state.MD5State); err != nil {
+		return fmt.Errorf("failed to restore md5 state: %v", err)
+	}
+	h.size = state.BytesUploaded
+
+	return nil
+}
+
+// state returns the state of the checksums to be stored after a part of a multipart upload
+func (h *uploadHashes) state(uploadID string, partNumber int32) (*database.UploadChecksumState, error) {
+	sha256State, err := h.sha256.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal sha256 state: %v", err)
+	}
+	md5State, err := h.md5.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal md5 state: %v", err)
+	}
+
+	return &database.UploadChecksumState{
+		UploadID:      uploadID,
+		PartNumber:    partNumber,
+		BytesUploaded: h.size,
+		SHA256State:   sha256State,
+		MD5State:      md5State,
+	}, nil
+}
+
+func (h *uploadHashes) checksums() []Checksum {
+	return []Checksum{
+		{Type: "sha256", Value: fmt.Sprintf("%x", h.sha256.Sum(nil))},
+		{Type: "md5", Value: fmt.Sprintf("%x", h.md5.Sum(nil))},
+	}
+}
+
+// hashUploadPart continues the checksums of a multipart upload from the state of the previous part. Returns nil when
+// the checksums can not be continued, which happens when the parts are not uploaded in order, one at a time, as done
+// by default by the aws cli, rclone and boto3. The sha256 and md5 checksums of the file can not be combined from the
+// checksums of its parts, so the checksums of such uploads are missing and have to be computed by ingest
+func (p *Proxy) hashUploadPart(ctx context.Context, uploadID string, partNumber int32) (*uploadHashes, error) {
+	hashes := newUploadHashes()
+	if partNumber == 1 {
+		return hashes, nil
+	}
+
+	previous, err := p.database.GetUploadChecksumState(ctx, uploadID, partNumber-1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get checksum state of upload: %s, part: %d, due to: %v", uploadID, partNumber-1, err)
+	}
+	if previous == nil {
+		return nil, nil
+	}
+	if err := hashes.restore(previous); err != nil {
+		return nil, err
+	}
+
+	return hashes, nil
+}
+
+// multipartChecksums returns the checksums of a completed multipart upload from the state stored after its last part.
+// Returns nil when the state does not cover the whole uploaded file
+func (p *Proxy) multipartChecksums(ctx context.Context, uploadID, etag string, size int64) ([]Checksum, error) {
+	// The ETag of a multipart upload ends with the number of parts
+	_, parts, found := strings.Cut(etag, "-")
+	partCount, err := strconv.ParseInt(parts, 10, 32)
+	if !found || err != nil {
+		return nil, nil
+	}
+
+	state, err := p.database.GetUploadChecksumState(ctx, uploadID, int32(partCount))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get checksum state of upload: %s, part: %d, due to: %v", uploadID, partCount, err)
+	}
+	if state == nil || state.BytesUploaded != size {
+		return nil, nil
+	}
+
+	hashes := newUploadHashes()
+	if err := hashes.restore(state); err != nil {
+		return nil, err
+	}
+
+	return hashes.checksums(), nil
+}
+
+// uploadedChecksums returns the checksums of the completed upload, the checksums of a single part upload are computed
+// while it is proxied and those of a multipart upload from the state stored after its last part.
+// Returns nil when the checksums do not cover the whole uploaded file
+func (p *Proxy) uploadedChecksums(ctx context.Context, s3RequestType S3RequestType, uploadID string, hashes *uploadHashes, etag string, size int64) ([]Checksum, error) {
+	if s3RequestType == CompleteMultiPartUpload {
+		return p.multipartChecksums(ctx, uploadID, etag, size)
+	}
+	if hashes == nil || hashes.size != size {
+		return nil, nil
+	}
+
+	return hashes.checksums(), nil
+}
+
+// messageChecksums returns the checksums sent in the inbox-upload message. When the checksums were not computed while
+// the upload was proxied, the ETag of a single part upload is used as its md5 checksum, while the ETag of a multipart
+// upload is not a checksum of the file and the message is sent with an empty list of checksums instead
+func messageChecksums(s3RequestType S3RequestType, uploadedChecksums []Checksum, etag Checksum) []any {
+	switch {
+	case uploadedChecksums != nil:
+		checksums := make([]any, len(uploadedChecksums))
+		for i, uploadedChecksum := range uploadedChecksums {
+			checksums[i] = uploadedChecksum
+		}
+
+		return checksums
+	case s3RequestType == CompleteMultiPartUpload:
+		return []any{}
+	default:
+		return []any{etag}
+	}
+}
+
+// teeBody writes the request body to w as it is read when the request is forwarded
+func teeBody(r *http.Request, w io.Writer) {
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+	r.Body = io.NopCloser(io.TeeReader(r.Body, w))
+}
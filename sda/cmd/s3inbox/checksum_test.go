@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" // #nosec G501 -- md5 is used as a checksum for the S3 ETag, not for security
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func expectedChecksums(content []byte) []Checksum {
+	return []Checksum{
+		{Type: "sha256", Value: fmt.Sprintf("%x", sha256.Sum256(content))},
+		{Type: "md5", Value: fmt.Sprintf("%x", md5.Sum(content))}, // #nosec G401 -- md5 is used as a checksum for the S3 ETag, not for security
+	}
+}
+
+func (s *UploadTests) TestUploadHashes_restore() {
+	content := bytes.Repeat([]byte("content"), 1000)
+
+	first := newUploadHashes()
+	_, _ = first.Write(content[:3000])
+	state, err := first.state("upload-id", 1)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(3000), state.BytesUploaded)
+
+	second := newUploadHashes()
+	assert.NoError(s.T(), second.restore(state))
+	_, _ = second.Write(content[3000:])
+	assert.Equal(s.T(), int64(len(content)), second.size)
+	assert.Equal(s.T(), expectedChecksums(content), second.checksums())
+}
+
+// uploadParts uploads the parts through the proxy in the order given
+func (s *UploadTests) uploadParts(proxy *Proxy, parts map[int][]byte, order ...int) {
+	for _, partNumber := range order {
+		r := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/dummy/file.c4gh?partNumber=%d&uploadId=upload-id", partNumber), bytes.NewReader(parts[partNumber]))
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, r)
+		assert.Equal(s.T(), http.StatusOK, w.Code)
+	}
+}
+
+func (s *UploadTests) TestMultipartChecksums() {
+	proxy := s.newProxy()
+	encrypted := s.encrypt(bytes.Repeat([]byte("content"), 100000), s.publicKey)
+	parts := map[int][]byte{1: encrypted[:200000], 2: encrypted[200000:400000], 3: encrypted[400000:]}
+
+	s.uploadParts(proxy, parts, 1, 2, 3)
+	checksums, err := proxy.multipartChecksums(context.TODO(), "upload-id", "0a44282bd39178db9680f24813c41aec-3", int64(len(encrypted)))
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), expectedChecksums(encrypted), checksums)
+
+	// The checksums do not cover the file when it was completed with other parts than the ones uploaded
+	checksums, err = proxy.multipartChecksums(context.TODO(), "upload-id", "0a44282bd39178db9680f24813c41aec-3", int64(len(encrypted)-1))
+	assert.NoError(s.T(), err)
+	assert.Nil(s.T(), checksums)
+	checksums, err = proxy.multipartChecksums(context.TODO(), "upload-id", "0a44282bd39178db9680f24813c41aec-4", int64(len(encrypted)))
+	assert.NoError(s.T(), err)
+	assert.Nil(s.T(), checksums)
+
+	// A re-uploaded part replaces the checksums continued from it
+	s.uploadParts(proxy, parts, 2)
+	checksums, err = proxy.multipartChecksums(context.TODO(), "upload-id", "0a44282bd39178db9680f24813c41aec-3", int64(len(encrypted)))
+	assert.NoError(s.T(), err)
+	assert.Nil(s.T(), checksums)
+	s.uploadParts(proxy, parts, 3)
+	checksums, err = proxy.multipartChecksums(context.TODO(), "upload-id", "0a44282bd39178db9680f24813c41aec-3", int64(len(encrypted)))
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), expectedChecksums(encrypted), checksums)
+}
+
+func (s *UploadTests) TestMultipartChecksums_outOfOrder() {
+	proxy := s.newProxy()
+	encrypted := s.encrypt(bytes.Repeat([]byte("content"), 100000), s.publicKey)
+	parts := map[int][]byte{1: encrypted[:200000], 2: encrypted[200000:400000], 3: encrypted[400000:]}
+
+	// The checksums can not be continued by a part uploaded before the previous part
+	s.uploadParts(proxy, parts, 1, 3, 2)
+	assert.Nil(s.T(), s.db.checksumStates[3])
+	checksums, err := proxy.multipartChecksums(context.TODO(), "upload-id", "0a44282bd39178db9680f24813c41aec-3", int64(len(encrypted)))
+	assert.NoError(s.T(), err)
+	assert.Nil(s.T(), checksums)
+}
+
+func (s *UploadTests) TestMultipartChecksums_singlePartETag() {
+	checksums, err := s.newProxy().multipartChecksums(context.TODO(), "upload-id", "0a44282bd39178db9680f24813c41aec", 5)
+	assert.NoError(s.T(), err)
+	assert.Nil(s.T(), checksums)
+}
+
+func (s *UploadTests) TestMessageChecksums() {
+	uploaded := expectedChecksums([]byte("content"))
+	etag := Checksum{Type: "md5", Value: "9a0364b9e99bb480dd25e1f0284c8555"}
+
+	assert.Equal(s.T(), []any{uploaded[0], uploaded[1]}, messageChecksums(PutObject, uploaded, etag))
+	assert.Equal(s.T(), []any{uploaded[0], uploaded[1]}, messageChecksums(CompleteMultiPartUpload, uploaded, etag))
+	assert.Equal(s.T(), []any{etag}, messageChecksums(CopyObject, nil, etag))
+
+	// The ETag of a multipart upload is not sent as a checksum when the checksums of its parts could not be combined
+	assert.Equal(s.T(), []any{}, messageChecksums(CompleteMultiPartUpload, nil, Checksum{Type: "md5", Value: "0a44282bd39178db9680f24813c41aec-3"}))
+}
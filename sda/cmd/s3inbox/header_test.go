@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/neicnordic/crypt4gh/keys"
+	"github.com/neicnordic/crypt4gh/model/headers"
+	"github.com/neicnordic/crypt4gh/streaming"
+	"github.com/neicnordic/sensitive-data-archive/internal/config"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/helper"
+	"github.com/neicnordic/sensitive-data-archive/internal/reencrypt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+type UploadTests struct {
+	suite.Suite
+	archiveKey *[32]byte
+	publicKey  [32]byte
+	db         *mockDatabase
+	reencrypt  *mockReencryptClient
+	backend    *httptest.Server
+	// uploaded holds the body of the last request received by the backend
+	uploaded []byte
+	// objects and parts hold the sizes of the objects in the backend and of the parts of a multipart upload
+	objects map[string]int64
+	parts   map[int32]int64
+}
+
+func TestUploadTestSuite(t *testing.T) {
+	suite.Run(t, new(UploadTests))
+}
+
+// mockDatabase implements the database functions used for validating headers, computing the checksums of multipart
+// uploads, removing files and checking quotas, calling any other function panics
+type mockDatabase struct {
+	database.Database
+	keyHashes      []*database.C4ghKeyHash
+	checksumStates map[int32]*database.UploadChecksumState
+	// copies maps the file paths of files to the file path of their copy in the inbox
+	copies map[string]string
+	quotas map[string]*database.InboxQuota
+	usage  database.InboxUsage
+	// fileID is the id of the file in the inbox and excludedFileID the file left out of the last usage query
+	fileID         string
+	excludedFileID string
+}
+
+func (m *mockDatabase) ListKeyHashes(_ context.Context) ([]*database.C4ghKeyHash, error) {
+	return m.keyHashes, nil
+}
+
+func (m *mockDatabase) GetUploadChecksumState(_ context.Context, _ string, partNumber int32) (*database.UploadChecksumState, error) {
+	return m.checksumStates[partNumber], nil
+}
+
+func (m *mockDatabase) GetInboxCopyOfFile(_ context.Context, _, filePath string) (string, error) {
+	return m.copies[filePath], nil
+}
+
+func (m *mockDatabase) GetFileIDInInbox(_ context.Context, _, _ string) (string, error) {
+	return m.fileID, nil
+}
+
+func (m *mockDatabase) GetInboxQuota(_ context.Context, submissionUser string) (*database.InboxQuota, error) {
+	return m.quotas[submissionUser], nil
+}
+
+func (m *mockDatabase) GetInboxUsage(_ context.Context, _, excludedFileID string) (*database.InboxUsage, error) {
+	m.excludedFileID = excludedFileID
+
+	return &m.usage, nil
+}
+
+func (m *mockDatabase) SetUploadChecksumState(_ context.Context, state *database.UploadChecksumState) error {
+	m.checksumStates[state.PartNumber] = state
+	for partNumber := range m.checksumStates {
+		if partNumber > state.PartNumber {
+			delete(m.checksumStates, partNumber)
+		}
+	}
+
+	return nil
+}
+
+// mockReencryptClient re-encrypts headers with the archive keys like the reencrypt service does
+type mockReencryptClient struct {
+	archiveKeys []*[32]byte
+	err         error
+}
+
+func (m *mockReencryptClient) ReencryptHeader(_ context.Context, in *reencrypt.ReencryptRequest, _ ...grpc.CallOption) (*reencrypt.ReencryptResponse, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	publicKey, err := base64.StdEncoding.DecodeString(in.GetPublickey())
+	if err != nil || len(publicKey) != 32 {
+		return nil, status.Error(400, "bad public key")
+	}
+	for _, key := range m.archiveKeys {
+		newHeader, err := headers.ReEncryptHeader(in.GetOldheader(), *key, [][32]byte{[32]byte(publicKey)})
+		if err == nil {
+			archivePublicKey := keys.DerivePublicKey(*key)
+
+			return &reencrypt.ReencryptResponse{Header: newHeader, Keyhash: hex.EncodeToString(archivePublicKey[:])}, nil
+		}
+	}
+
+	return nil, status.Error(400, "header reencryption failed, no matching key available")
+}
+
+func (s *UploadTests) SetupTest() {
+	publicKey, privateKey, err := keys.GenerateKeyPair()
+	assert.NoError(s.T(), err)
+	s.archiveKey = &privateKey
+	s.publicKey = publicKey
+
+	s.db = &mockDatabase{
+		keyHashes:      []*database.C4ghKeyHash{{Hash: hex.EncodeToString(publicKey[:])}},
+		checksumStates: make(map[int32]*database.UploadChecksumState),
+		copies:         make(map[string]string),
+		quotas:         make(map[string]*database.InboxQuota),
+	}
+	s.reencrypt = &mockReencryptClient{archiveKeys: []*[32]byte{s.archiveKey}}
+
+	s.uploaded = nil
+	s.objects = make(map[string]int64)
+	s.parts = make(map[int32]int64)
+	s.backend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodHead:
+			size, ok := s.objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+
+				return
+			}
+			w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+			w.Header().Set("ETag", `"0a44282bd39178db9680f24813c41aec"`)
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodGet && r.URL.Query().Has("uploadId"):
+			var parts strings.Builder
+			for partNumber, size := range s.parts {
+				fmt.Fprintf(&parts, "<Part><PartNumber>%d</PartNumber><Size>%d</Size></Part>", partNumber, size)
+			}
+			_, _ = fmt.Fprintf(w, "<ListPartsResult><IsTruncated>false</IsTruncated>%s</ListPartsResult>", parts.String())
+		default:
+			s.uploaded, _ = io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+}
+
+func (s *UploadTests) TearDownTest() {
+	s.backend.Close()
+}
+
+// encrypt returns the content encrypted with crypt4gh for the recipient
+func (s *UploadTests) encrypt(content []byte, recipient [32]byte) []byte {
+	_, privateKey, err := keys.GenerateKeyPair()
+	assert.NoError(s.T(), err)
+
+	var encrypted bytes.Buffer
+	writer, err := streaming.NewCrypt4GHWriter(&encrypted, privateKey, [][32]byte{recipient}, nil)
+	assert.NoError(s.T(), err)
+	_, err = writer.Write(content)
+	assert.NoError(s.T(), err)
+	assert.NoError(s.T(), writer.Close())
+
+	return encrypted.Bytes()
+}
+
+func (s *UploadTests) newProxy() *Proxy {
+	s3conf := config.S3InboxConf{
+		Endpoint:  s.backend.URL,
+		AccessKey: "someAccess",
+		SecretKey: "someSecret",
+		Bucket:    "buckbuck",
+		Region:    "us-east-1",
+	}
+
+	s3Client := s3.New(s3.Options{
+		BaseEndpoint: aws.String(s.backend.URL),
+		Region:       s3conf.Region,
+		UsePathStyle: true,
+		Credentials:  aws.AnonymousCredentials{},
+	})
+
+	return NewProxy(s3conf, s3Client, helper.NewAlwaysAllow(), nil, s.db, s.reencrypt, new(tls.Config))
+}
+
+// nolint:bodyclose
+func (s *UploadTests) TestServeHTTP_uploadPart() {
+	proxy := s.newProxy()
+	encrypted := s.encrypt([]byte("content"), s.publicKey)
+
+	// The first part is forwarded when the header is valid
+	r := httptest.NewRequest(http.MethodPut, "/dummy/file.c4gh?partNumber=1&uploadId=1", bytes.NewReader(encrypted))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), http.StatusOK, w.Result().StatusCode)
+	assert.Equal(s.T(), encrypted, s.uploaded)
+
+	// The first part is rejected without reaching the backend when the header is invalid
+	s.uploaded = nil
+	r = httptest.NewRequest(http.MethodPut, "/dummy/file.c4gh?partNumber=1&uploadId=1", bytes.NewReader([]byte("not crypt4gh")))
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), http.StatusBadRequest, w.Result().StatusCode)
+	assert.Contains(s.T(), w.Body.String(), "<Code>Bad Request</Code>")
+	assert.Nil(s.T(), s.uploaded)
+
+	// Later parts are not validated
+	r = httptest.NewRequest(http.MethodPut, "/dummy/file.c4gh?partNumber=2&uploadId=1", bytes.NewReader([]byte("not crypt4gh")))
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), http.StatusOK, w.Result().StatusCode)
+	assert.Equal(s.T(), []byte("not crypt4gh"), s.uploaded)
+}
+
+// nolint:bodyclose
+func (s *UploadTests) TestServeHTTP_putObjectRejected() {
+	// The upload is rejected before the file is registered in the database
+	r := httptest.NewRequest(http.MethodPut, "/dummy/file.c4gh", bytes.NewReader([]byte("not crypt4gh")))
+	w := httptest.NewRecorder()
+	s.newProxy().ServeHTTP(w, r)
+	assert.Equal(s.T(), http.StatusBadRequest, w.Result().StatusCode)
+	assert.Nil(s.T(), s.uploaded)
+}
+
+func (s *UploadTests) TestDetectS3RequestType_objectOperations() {
+	for _, test := range []struct {
+		method, target, copySource string
+		expected                   S3RequestType
+	}{
+		{http.MethodGet, "/dummy/file.c4gh", "", GetObject},
+		{http.MethodHead, "/dummy/file.c4gh", "", HeadObject},
+		{http.MethodDelete, "/dummy/file.c4gh", "", DeleteObject},
+		{http.MethodDelete, "/dummy/file.c4gh?uploadId=1", "", AbortMultiPartUpload},
+		{http.MethodPut, "/dummy/copy.c4gh", "/dummy/file.c4gh", CopyObject},
+		{http.MethodPut, "/dummy/copy.c4gh?partNumber=1&uploadId=1", "/dummy/file.c4gh", Unsupported},
+		{http.MethodHead, "/dummy", "", Unsupported},
+	} {
+		r := httptest.NewRequest(test.method, test.target, nil)
+		if test.copySource != "" {
+			r.Header.Set("x-amz-copy-source", test.copySource)
+		}
+		assert.Equal(s.T(), test.expected, detectS3RequestType(r), "%s %s", test.method, test.target)
+	}
+}
+
+func (s *UploadTests) TestPrepareCopySource() {
+	proxy := s.newProxy()
+
+	r := httptest.NewRequest(http.MethodPut, "/dummy/dir/copy%20of%20file.c4gh", nil)
+	r.Header.Set("x-amz-copy-source", "dummy/dir/my%20file.c4gh")
+	copiedFrom, err := proxy.prepareCopySource(r, "dummy")
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "dir/my file.c4gh", copiedFrom)
+	assert.Equal(s.T(), "/buckbuck/dummy/dir/my%20file.c4gh", r.Header.Get("x-amz-copy-source"))
+
+	// Files can only be copied from the inbox of the user
+	r.Header.Set("x-amz-copy-source", "/other/file.c4gh")
+	_, err = proxy.prepareCopySource(r, "dummy")
+	assert.Error(s.T(), err)
+
+	// Copying versions is not supported
+	r.Header.Set("x-amz-copy-source", "/dummy/file.c4gh?versionId=1")
+	_, err = proxy.prepareCopySource(r, "dummy")
+	assert.Error(s.T(), err)
+}
+
+func (s *UploadTests) TestRemoveMessage() {
+	proxy := s.newProxy()
+
+	jsonMessage, err := proxy.removeMessage(context.TODO(), "dummy@example.org", "dummy_example.org/dir/file.c4gh", "dir/file.c4gh")
+	assert.NoError(s.T(), err)
+	assert.JSONEq(s.T(), `{"user": "dummy@example.org", "filepath": "dummy_example.org/dir/file.c4gh", "operation": "remove"}`, string(jsonMessage))
+
+	// A file removed after being copied within the inbox has been renamed
+	s.db.copies["dir/file.c4gh"] = "dir/renamed.c4gh"
+	jsonMessage, err = proxy.removeMessage(context.TODO(), "dummy@example.org", "dummy_example.org/dir/file.c4gh", "dir/file.c4gh")
+	assert.NoError(s.T(), err)
+	assert.JSONEq(s.T(), `{"user": "dummy@example.org", "filepath": "dummy_example.org/dir/renamed.c4gh", "oldpath": "dummy_example.org/dir/file.c4gh", "operation": "rename"}`, string(jsonMessage))
+}
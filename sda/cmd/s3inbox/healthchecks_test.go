@@ -83,7 +83,7 @@ func (ts *HealthcheckTestSuite) TearDownTest() {
 }
 
 func (ts *HealthcheckTestSuite) TestHttpsGetCheck() {
-	p := NewProxy(ts.mockS3Conf, ts.s3ClientToMock, &helper.AlwaysAllow{}, ts.messenger, ts.database, new(tls.Config))
+	p := NewProxy(ts.mockS3Conf, ts.s3ClientToMock, &helper.AlwaysAllow{}, ts.messenger, ts.database, nil, new(tls.Config))
 
 	url, _ := p.getS3ReadyPath()
 	assert.NoError(ts.T(), p.httpsGetCheck(url))
@@ -91,7 +91,7 @@ func (ts *HealthcheckTestSuite) TestHttpsGetCheck() {
 }
 
 func (ts *HealthcheckTestSuite) TestS3URL() {
-	p := NewProxy(ts.mockS3Conf, ts.s3ClientToMock, &helper.AlwaysAllow{}, ts.messenger, ts.database, new(tls.Config))
+	p := NewProxy(ts.mockS3Conf, ts.s3ClientToMock, &helper.AlwaysAllow{}, ts.messenger, ts.database, nil, new(tls.Config))
 
 	_, err := p.getS3ReadyPath()
 	assert.NoError(ts.T(), err)
@@ -106,7 +106,7 @@ func (ts *HealthcheckTestSuite) TestHealthchecks() {
 	// Setup
 	messenger, err := broker.NewMQ(ts.MQConf)
 	assert.NoError(ts.T(), err)
-	p := NewProxy(ts.mockS3Conf, ts.s3ClientToMock, &helper.AlwaysAllow{}, messenger, ts.database, new(tls.Config))
+	p := NewProxy(ts.mockS3Conf, ts.s3ClientToMock, &helper.AlwaysAllow{}, messenger, ts.database, nil, new(tls.Config))
 
 	w := httptest.NewRecorder()
 	p.CheckHealth(w, httptest.NewRequest(http.MethodGet, "https://dummy/health", nil))
@@ -119,7 +119,7 @@ func (ts *HealthcheckTestSuite) TestClosedDBHealthchecks() {
 	// Setup
 	messenger, err := broker.NewMQ(ts.MQConf)
 	assert.NoError(ts.T(), err)
-	p := NewProxy(ts.mockS3Conf, ts.s3ClientToMock, &helper.AlwaysAllow{}, messenger, ts.database, new(tls.Config))
+	p := NewProxy(ts.mockS3Conf, ts.s3ClientToMock, &helper.AlwaysAllow{}, messenger, ts.database, nil, new(tls.Config))
 
 	// Check that 200 is reported
 	w := httptest.NewRecorder()
@@ -164,7 +164,7 @@ func (ts *HealthcheckTestSuite) TestNoS3Healthchecks() {
 	// Setup
 	messenger, err := broker.NewMQ(ts.MQConf)
 	assert.NoError(ts.T(), err)
-	p := NewProxy(ts.mockS3Conf, ts.s3ClientToMock, &helper.AlwaysAllow{}, messenger, ts.database, new(tls.Config))
+	p := NewProxy(ts.mockS3Conf, ts.s3ClientToMock, &helper.AlwaysAllow{}, messenger, ts.database, nil, new(tls.Config))
 
 	// S3 unavailable, check that 503 is reported
 	w := httptest.NewRecorder()
@@ -179,7 +179,7 @@ func (ts *HealthcheckTestSuite) TestNoMQHealthchecks() {
 	// Setup
 	messenger, err := broker.NewMQ(ts.MQConf)
 	assert.NoError(ts.T(), err)
-	p := NewProxy(ts.mockS3Conf, ts.s3ClientToMock, &helper.AlwaysAllow{}, messenger, ts.database, new(tls.Config))
+	p := NewProxy(ts.mockS3Conf, ts.s3ClientToMock, &helper.AlwaysAllow{}, messenger, ts.database, nil, new(tls.Config))
 
 	// Messenger unavailable, check that 503 is reported
 	p.messenger.Conf.Port = 123456
@@ -10,7 +10,6 @@ import (
 	"io"
 	"net/http"
 	"net/url"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -23,6 +22,8 @@ import (
 	"github.com/neicnordic/sensitive-data-archive/internal/config"
 	"github.com/neicnordic/sensitive-data-archive/internal/database"
 	"github.com/neicnordic/sensitive-data-archive/internal/helper"
+	"github.com/neicnordic/sensitive-data-archive/internal/inboxcheck"
+	"github.com/neicnordic/sensitive-data-archive/internal/reencrypt"
 	"github.com/neicnordic/sensitive-data-archive/internal/schema"
 	"github.com/neicnordic/sensitive-data-archive/internal/userauth"
 	log "github.com/sirupsen/logrus"
@@ -36,6 +37,8 @@ type Proxy struct {
 	messenger *broker.AMQPBroker
 	database  database.Database
 	client    *http.Client
+	// reencryptClient is used to validate the crypt4gh header of uploads, headers are not validated when it is not set
+	reencryptClient reencrypt.ReencryptClient
 }
 
 // The Event struct
@@ -76,20 +79,25 @@ const (
 	ListParts
 	AbortMultiPartUpload
 	GetBucketLocation
+	GetObject
+	HeadObject
+	DeleteObject
+	CopyObject
 )
 
 // NewProxy creates a new S3Proxy. This implements the ServerHTTP interface.
-func NewProxy(s3conf config.S3InboxConf, s3Client *s3.Client, auth userauth.Authenticator, messenger *broker.AMQPBroker, db database.Database, tlsConf *tls.Config) *Proxy {
+func NewProxy(s3conf config.S3InboxConf, s3Client *s3.Client, auth userauth.Authenticator, messenger *broker.AMQPBroker, db database.Database, reencryptClient reencrypt.ReencryptClient, tlsConf *tls.Config) *Proxy {
 	tr := &http.Transport{TLSClientConfig: tlsConf}
 	client := &http.Client{Transport: tr, Timeout: 30 * time.Second}
 
 	return &Proxy{
-		s3Conf:    s3conf,
-		s3Client:  s3Client,
-		auth:      auth,
-		messenger: messenger,
-		database:  db,
-		client:    client,
+		s3Conf:          s3conf,
+		s3Client:        s3Client,
+		auth:            auth,
+		messenger:       messenger,
+		database:        db,
+		client:          client,
+		reencryptClient: reencryptClient,
 	}
 }
 
@@ -106,10 +114,14 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch s3RequestType {
 	// These actions we just forward to the s3 backend after ensuring that requests have been made user specific by
 	// prepareForwardPathAndQuery
-	case ListObjects, ListObjectsV2, GetBucketLocation, UploadPart, ListMultiPartUploads, AbortMultiPartUpload, ListParts:
+	case ListObjects, ListObjectsV2, GetBucketLocation, ListMultiPartUploads, AbortMultiPartUpload, ListParts, GetObject, HeadObject:
 		p.forwardRequest(s3RequestType, w, r, token)
-	case PutObject, CreateMultiPartUpload, CompleteMultiPartUpload:
+	case UploadPart:
+		p.handleUploadPart(w, r, token)
+	case PutObject, CreateMultiPartUpload, CompleteMultiPartUpload, CopyObject:
 		p.handleUpload(s3RequestType, w, r, token)
+	case DeleteObject:
+		p.handleDelete(w, r, token)
 	default:
 		log.Warnf("user: %s, attempted to do not allowed request: method: %s, path: %s, query: %s", token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery)
 		reportErrorToClient(http.StatusForbidden, "Forbidden", w)
@@ -198,12 +210,147 @@ func (p *Proxy) forwardRequest(s3RequestType S3RequestType, w http.ResponseWrite
 		return
 	}
 
+	// The checksum states of an aborted upload will not be continued
+	if s3RequestType == AbortMultiPartUpload && s3Response.StatusCode == http.StatusNoContent {
+		if err := p.database.DeleteUploadChecksumStates(r.Context(), r.URL.Query().Get("uploadId")); err != nil {
+			log.Warnf("failed to delete checksum states of aborted upload: %s, due to: %v", r.URL.Query().Get("uploadId"), err)
+		}
+	}
+
 	if err := p.forwardResponseToClient(s3Response, w); err != nil {
 		p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to forward response to client: %v", err))
 	}
 
 	_ = s3Response.Body.Close()
 }
+
+// handleUploadPart forwards a part of a multipart upload. The crypt4gh header is validated in the first part, and the
+// checksums of the upload are continued from the state stored after the previous part when the parts are uploaded in
+// order
+func (p *Proxy) handleUploadPart(w http.ResponseWriter, r *http.Request, token jwt.Token) {
+	var err error
+	r.URL.Path, r.URL.RawQuery, err = p.prepareForwardPathAndQuery(UploadPart, r.URL.Path, r.URL.RawQuery, token.Subject())
+	if err != nil {
+		log.Warnf("bad request from user %s: %v", token.Subject(), err)
+		reportErrorToClient(http.StatusBadRequest, "Bad Request", w)
+
+		return
+	}
+
+	uploadID := r.URL.Query().Get("uploadId")
+	partNumber, err := strconv.ParseInt(r.URL.Query().Get("partNumber"), 10, 32)
+	if err != nil || partNumber < 1 {
+		log.Warnf("bad request from user %s: invalid part number: %s", token.Subject(), r.URL.Query().Get("partNumber"))
+		reportErrorToClient(http.StatusBadRequest, "Bad Request", w)
+
+		return
+	}
+
+	// The parts uploaded so far count towards the quota of the user, as the file has been registered when the upload
+	// was created it is left out of the usage of the user
+	s3FilePath := strings.Replace(r.URL.Path, "/"+p.s3Conf.Bucket+"/", "", 1)
+	filePath, err := helper.FormatUploadFilePath(helper.AnonymizeFilepath(s3FilePath, token.Subject()))
+	if err != nil {
+		log.Warnf("bad request from user %s: %v", token.Subject(), err)
+		reportErrorToClient(http.StatusBadRequest, "Bad Request", w)
+
+		return
+	}
+	fileID, err := p.database.GetFileIDInInbox(r.Context(), token.Subject(), filePath)
+	if err != nil {
+		p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to check/get existing file id from database: %v", err))
+
+		return
+	}
+	if !p.checkUploadQuota(w, r, UploadPart, token, s3FilePath, fileID) {
+		return
+	}
+
+	// The crypt4gh header is at the start of the first part
+	if partNumber == 1 && !p.checkHeader(w, r, token) {
+		return
+	}
+
+	hashes, err := p.hashUploadPart(r.Context(), uploadID, int32(partNumber))
+	if err != nil {
+		p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, err.Error())
+
+		return
+	}
+	if hashes != nil {
+		teeBody(r, hashes)
+	}
+
+	s3Response, err := p.forwardRequestToBackend(r)
+	if err != nil {
+		p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("forwarding error: %v", err))
+
+		return
+	}
+	defer func() {
+		_ = s3Response.Body.Close()
+	}()
+
+	if s3Response.StatusCode == http.StatusOK && hashes != nil {
+		state, err := hashes.state(uploadID, int32(partNumber))
+		if err == nil {
+			err = p.database.SetUploadChecksumState(r.Context(), state)
+		}
+		if err != nil {
+			p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to store checksum state: %v", err))
+
+			return
+		}
+	}
+
+	if err := p.forwardResponseToClient(s3Response, w); err != nil {
+		p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to forward response to client: %v", err))
+	}
+}
+
+// checkHeader validates the crypt4gh header of the upload in the request body and replaces the body with one that
+// replays the validated header, when the header is rejected the error is reported to the client and false returned
+func (p *Proxy) checkHeader(w http.ResponseWriter, r *http.Request, token jwt.Token) bool {
+	if p.reencryptClient == nil {
+		return true
+	}
+
+	body, err := inboxcheck.ValidateHeader(r.Context(), p.reencryptClient, p.database, r.Body)
+	r.Body = io.NopCloser(body)
+	switch {
+	case errors.Is(err, inboxcheck.ErrInvalidHeader):
+		log.Warnf("user: %s, upload rejected: method: %s, path: %s, query: %s, reason: %v", token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, err)
+		reportErrorToClient(http.StatusBadRequest, "File is not crypt4gh encrypted with the public key of the archive", w)
+
+		return false
+	case err != nil:
+		p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, err.Error())
+
+		return false
+	}
+
+	return true
+}
+
+// checkUploadQuota checks that the file uploaded by the request does not exceed the quota of the user, when it would
+// the error is reported to the client and false returned
+func (p *Proxy) checkUploadQuota(w http.ResponseWriter, r *http.Request, s3RequestType S3RequestType, token jwt.Token, s3FilePath, fileID string) bool {
+	err := p.checkQuota(r.Context(), token.Subject(), fileID, p.requestQuotaSize(r.Context(), r, s3RequestType, s3FilePath))
+	switch {
+	case errors.Is(err, inboxcheck.ErrQuotaExceeded):
+		log.Warnf("rejected upload from user %s: %v", token.Subject(), err)
+		reportS3ErrorToClient(http.StatusForbidden, "QuotaExceeded", "The upload would exceed the inbox quota of the user", w)
+
+		return false
+	case err != nil:
+		p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, err.Error())
+
+		return false
+	}
+
+	return true
+}
+
 func (p *Proxy) handleUpload(s3RequestType S3RequestType, w http.ResponseWriter, r *http.Request, token jwt.Token) {
 	username := token.Subject()
 
@@ -217,7 +364,7 @@ func (p *Proxy) handleUpload(s3RequestType S3RequestType, w http.ResponseWriter,
 	}
 
 	s3FilePath := strings.Replace(r.URL.Path, "/"+p.s3Conf.Bucket+"/", "", 1)
-	filePath, err := formatUploadFilePath(helper.AnonymizeFilepath(s3FilePath, username))
+	filePath, err := helper.FormatUploadFilePath(helper.AnonymizeFilepath(s3FilePath, username))
 	if err != nil {
 		log.Warnf("bad request from user %s: %v", token.Subject(), err)
 		reportErrorToClient(http.StatusBadRequest, "Bad Request", w)
@@ -225,6 +372,30 @@ func (p *Proxy) handleUpload(s3RequestType S3RequestType, w http.ResponseWriter,
 		return
 	}
 
+	// The copy source is made user specific in the same way as the request path
+	var copiedFrom string
+	if s3RequestType == CopyObject {
+		copiedFrom, err = p.prepareCopySource(r, username)
+		if err != nil {
+			log.Warnf("bad request from user %s: %v", token.Subject(), err)
+			reportErrorToClient(http.StatusBadRequest, "Bad Request", w)
+
+			return
+		}
+	}
+
+	// Reject uploads that can not be ingested before registering the file
+	if s3RequestType == PutObject && !p.checkHeader(w, r, token) {
+		return
+	}
+
+	// The checksums of a multipart upload are computed by handleUploadPart
+	var hashes *uploadHashes
+	if s3RequestType == PutObject {
+		hashes = newUploadHashes()
+		teeBody(r, hashes)
+	}
+
 	fileID, err := p.database.GetFileIDInInbox(r.Context(), username, filePath)
 	if err != nil {
 		p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to check/get existing file id from database: %v", err))
@@ -232,6 +403,12 @@ func (p *Proxy) handleUpload(s3RequestType S3RequestType, w http.ResponseWriter,
 		return
 	}
 
+	// Uploads which would exceed the quota of the user are rejected before they reach the inbox, a file uploaded to an
+	// existing path replaces the existing file
+	if !p.checkUploadQuota(w, r, s3RequestType, token, s3FilePath, fileID) {
+		return
+	}
+
 	// if this is an upload request
 	if fileID == "" { // nolint: nestif
 		// Ideally this transaction should span the whole request processing, but for now just spans the RegisterFile
@@ -261,7 +438,7 @@ func (p *Proxy) handleUpload(s3RequestType S3RequestType, w http.ResponseWriter,
 	isReupload := false
 	// check if the file already exists when an upload completes, in that case send an overwrite message when the s3 has responded with 200,
 	// so that the FEGA portal is informed that a new version
-	if s3RequestType == PutObject || s3RequestType == CompleteMultiPartUpload {
+	if completesUpload(s3RequestType) {
 		isReupload, err = p.checkFileExists(r.Context(), s3FilePath)
 		if err != nil {
 			p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, err.Error())
@@ -280,15 +457,27 @@ func (p *Proxy) handleUpload(s3RequestType S3RequestType, w http.ResponseWriter,
 		_ = s3Response.Body.Close()
 	}()
 
-	// Send message to upstream and set file as uploaded in the database when upload is complete(PutObject / CompleteMultipartUpload)
+	// Send message to upstream and set file as uploaded in the database when upload is complete(PutObject / CompleteMultipartUpload / CopyObject)
 	// nolint: nestif
-	if s3Response.StatusCode == 200 && (s3RequestType == PutObject || s3RequestType == CompleteMultiPartUpload) {
+	if s3Response.StatusCode == 200 && completesUpload(s3RequestType) {
 		message, checksum, err := p.CreateMessageFromRequest(r.Context(), token.Subject(), s3FilePath)
 		if err != nil {
 			p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, err.Error())
 
 			return
 		}
+
+		uploadID := r.URL.Query().Get("uploadId")
+		uploadedChecksums, err := p.uploadedChecksums(r.Context(), s3RequestType, uploadID, hashes, checksum, message.Filesize)
+		if err != nil {
+			p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, err.Error())
+
+			return
+		}
+		if uploadedChecksums == nil && s3RequestType == CompleteMultiPartUpload {
+			log.Warnf("user: %s, checksums of upload: %s, file: %s, are missing since its parts were not uploaded in order, one at a time", token.Subject(), uploadID, s3FilePath)
+		}
+		message.Checksum = messageChecksums(s3RequestType, uploadedChecksums, Checksum{Type: "md5", Value: checksum})
 		jsonMessage, err := json.Marshal(message)
 		if err != nil {
 			p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to marshal rabbitmq message to json: %v", err))
@@ -308,7 +497,33 @@ func (p *Proxy) handleUpload(s3RequestType S3RequestType, w http.ResponseWriter,
 			return
 		}
 
-		if err := p.database.UpdateFileEventLog(r.Context(), fileID, "uploaded", "inbox", "{}", string(jsonMessage)); err != nil {
+		for _, uploadedChecksum := range uploadedChecksums {
+			if err := p.database.AddUploadedChecksum(r.Context(), fileID, uploadedChecksum.Value, uploadedChecksum.Type); err != nil {
+				p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to store uploaded checksum: %v", err))
+
+				return
+			}
+		}
+
+		if s3RequestType == CompleteMultiPartUpload {
+			if err := p.database.DeleteUploadChecksumStates(r.Context(), uploadID); err != nil {
+				log.Warnf("failed to delete checksum states of completed upload: %s, due to: %v", uploadID, err)
+			}
+		}
+
+		// The source of a copy is recorded so that a following delete of the source is reported as a rename
+		details := "{}"
+		if copiedFrom != "" {
+			detailsJSON, err := json.Marshal(map[string]string{"copied_from": copiedFrom})
+			if err != nil {
+				p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to marshal event details to json: %v", err))
+
+				return
+			}
+			details = string(detailsJSON)
+		}
+
+		if err := p.database.UpdateFileEventLog(r.Context(), fileID, "uploaded", "inbox", details, string(jsonMessage)); err != nil {
 			p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("could not connect to db: %v", err))
 
 			return
@@ -331,6 +546,131 @@ func (p *Proxy) handleUpload(s3RequestType S3RequestType, w http.ResponseWriter,
 	}
 }
 
+// completesUpload reports whether a successful request of the type completes the upload of a file to the inbox
+func completesUpload(s3RequestType S3RequestType) bool {
+	return s3RequestType == PutObject || s3RequestType == CompleteMultiPartUpload || s3RequestType == CopyObject
+}
+
+// prepareCopySource makes the copy source of a CopyObject request user specific in the same way as the request path,
+// and returns the anonymized file path of the copy source
+func (p *Proxy) prepareCopySource(r *http.Request, tokenSubject string) (string, error) {
+	copySource, err := url.PathUnescape(r.Header.Get("x-amz-copy-source"))
+	if err != nil {
+		return "", fmt.Errorf("invalid copy source: %v", err)
+	}
+	if strings.Contains(copySource, "?") {
+		return "", fmt.Errorf("copying object versions is not supported: %s", copySource)
+	}
+
+	sourcePath, _, err := p.prepareForwardPathAndQuery(CopyObject, "/"+strings.TrimPrefix(copySource, "/"), "", tokenSubject)
+	if err != nil {
+		return "", err
+	}
+	r.Header.Set("x-amz-copy-source", (&url.URL{Path: sourcePath}).EscapedPath())
+
+	return helper.FormatUploadFilePath(helper.AnonymizeFilepath(strings.Replace(sourcePath, "/"+p.s3Conf.Bucket+"/", "", 1), tokenSubject))
+}
+
+// handleDelete removes a file from the inbox of the user. When the file was uploaded to the inbox it is cancelled in
+// the database, and an inbox-remove message is sent. If the file has been copied within the inbox an inbox-rename
+// message to its copy is sent instead, as S3 clients move files by a copy followed by a delete of the source
+func (p *Proxy) handleDelete(w http.ResponseWriter, r *http.Request, token jwt.Token) {
+	username := token.Subject()
+
+	var err error
+	r.URL.Path, r.URL.RawQuery, err = p.prepareForwardPathAndQuery(DeleteObject, r.URL.Path, r.URL.RawQuery, username)
+	if err != nil {
+		log.Warnf("bad request from user %s: %v", token.Subject(), err)
+		reportErrorToClient(http.StatusBadRequest, "Bad Request", w)
+
+		return
+	}
+
+	s3FilePath := strings.Replace(r.URL.Path, "/"+p.s3Conf.Bucket+"/", "", 1)
+	filePath, err := helper.FormatUploadFilePath(helper.AnonymizeFilepath(s3FilePath, username))
+	if err != nil {
+		log.Warnf("bad request from user %s: %v", token.Subject(), err)
+		reportErrorToClient(http.StatusBadRequest, "Bad Request", w)
+
+		return
+	}
+
+	fileID, err := p.database.GetFileIDInInbox(r.Context(), username, filePath)
+	if err != nil {
+		p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to check/get existing file id from database: %v", err))
+
+		return
+	}
+
+	// Deleting a file which does not exist succeeds in S3, no message is sent for those
+	exists, err := p.checkFileExists(r.Context(), s3FilePath)
+	if err != nil {
+		p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, err.Error())
+
+		return
+	}
+
+	s3Response, err := p.forwardRequestToBackend(r)
+	if err != nil {
+		p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("forwarding error: %v", err))
+
+		return
+	}
+	defer func() {
+		_ = s3Response.Body.Close()
+	}()
+
+	if s3Response.StatusCode == http.StatusNoContent && exists && fileID != "" {
+		jsonMessage, err := p.removeMessage(r.Context(), username, s3FilePath, filePath)
+		if err != nil {
+			p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, err.Error())
+
+			return
+		}
+
+		if err := p.checkAndSendMessage(fileID, jsonMessage); err != nil {
+			p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("broker error: %v", err))
+
+			return
+		}
+
+		if err := p.database.CancelFile(r.Context(), fileID, string(jsonMessage)); err != nil {
+			p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to cancel file in database: %v", err))
+
+			return
+		}
+		log.Infof("user: %s, removed file: %s, with id: %s", username, filePath, fileID)
+	}
+
+	if err := p.forwardResponseToClient(s3Response, w); err != nil {
+		p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to forward response to client: %v", err))
+	}
+}
+
+// removeMessage returns the inbox-remove message for a file removed from the inbox, or the inbox-rename message when
+// the file has been copied within the inbox
+func (p *Proxy) removeMessage(ctx context.Context, username, s3FilePath, filePath string) ([]byte, error) {
+	copyPath, err := p.database.GetInboxCopyOfFile(ctx, username, filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get copy of file from database: %v", err)
+	}
+
+	if copyPath == "" {
+		return json.Marshal(schema.InboxRemove{
+			User:      username,
+			FilePath:  s3FilePath,
+			Operation: "remove",
+		})
+	}
+
+	return json.Marshal(schema.InboxRename{
+		User:      username,
+		FilePath:  helper.UnanonymizeFilepath(copyPath, username),
+		OldPath:   s3FilePath,
+		Operation: "rename",
+	})
+}
+
 // Renew the connection to MQ if necessary, then send message
 func (p *Proxy) checkAndSendMessage(fileID string, jsonMessage []byte) error {
 	var err error
@@ -381,11 +721,11 @@ func (p *Proxy) forwardResponseToClient(s3Response *http.Response, w http.Respon
 	}
 
 	// Writing non-200 to the response before the headers propagate the error
-	// to the s3cmd client.
+	// to the s3cmd client, as well as the 204 of deletes and the 206 of ranged gets.
 	// Writing 200 here breaks uploads though, and writing non-200 codes after
 	// the headers results in the error message always being
 	// "MD5 Sums don't match!".
-	if s3Response.StatusCode < 200 || s3Response.StatusCode > 299 {
+	if s3Response.StatusCode != http.StatusOK {
 		w.WriteHeader(s3Response.StatusCode)
 	}
 
@@ -442,7 +782,7 @@ func (p *Proxy) resignHeader(r *http.Request) *http.Request {
 // * PutObject == PUT /${bucket}/${object}
 // For aws docs see: https://docs.aws.amazon.com/AmazonS3/latest/API/API_PutObject.html
 // partNumber and uploadId query arguments not present
-// We ensure x-amz-copy-source is not present to not allow CopyObject
+// We ensure x-amz-copy-source is not present as that is a CopyObject
 //
 // * UploadPart == PUT /${bucket}/${object}
 // For aws docs see:  https://docs.aws.amazon.com/AmazonS3/latest/API/API_UploadPart.html
@@ -462,6 +802,20 @@ func (p *Proxy) resignHeader(r *http.Request) *http.Request {
 //
 // * ListMultiPartUploads == Get /${bucket}?uploads
 // For aws docs see: https://docs.aws.amazon.com/AmazonS3/latest/API/API_ListMultipartUploads.html
+//
+// * GetObject == GET /${bucket}/${object}
+// For aws docs see: https://docs.aws.amazon.com/AmazonS3/latest/API/API_GetObject.html
+//
+// * HeadObject == HEAD /${bucket}/${object}
+// For aws docs see: https://docs.aws.amazon.com/AmazonS3/latest/API/API_HeadObject.html
+//
+// * DeleteObject == DELETE /${bucket}/${object}
+// For aws docs see: https://docs.aws.amazon.com/AmazonS3/latest/API/API_DeleteObject.html
+// uploadId query argument not present
+//
+// * CopyObject == PUT /${bucket}/${object}
+// For aws docs see: https://docs.aws.amazon.com/AmazonS3/latest/API/API_CopyObject.html
+// x-amz-copy-source present, the copy source is made user specific in the same way as the path
 func detectS3RequestType(r *http.Request) S3RequestType {
 	query := r.URL.Query()
 
@@ -489,16 +843,24 @@ func detectS3RequestType(r *http.Request) S3RequestType {
 		return GetBucketLocation
 	case r.Method == http.MethodGet && isBucketPath:
 		return ListObjects
+	case r.Method == http.MethodGet && isObjectPath:
+		return GetObject
+	case r.Method == http.MethodHead && isObjectPath:
+		return HeadObject
 	case r.Method == http.MethodPut && isObjectPath && !query.Has("partNumber") && !query.Has("uploadId") && r.Header.Get("x-amz-copy-source") == "":
 		return PutObject
 	case r.Method == http.MethodPut && isObjectPath && query.Has("partNumber") && query.Has("uploadId") && r.Header.Get("x-amz-copy-source") == "":
 		return UploadPart
+	case r.Method == http.MethodPut && isObjectPath && !query.Has("partNumber") && !query.Has("uploadId") && r.Header.Get("x-amz-copy-source") != "":
+		return CopyObject
 	case r.Method == http.MethodPost && isObjectPath && query.Has("uploads"):
 		return CreateMultiPartUpload
 	case r.Method == http.MethodPost && isObjectPath && query.Has("uploadId"):
 		return CompleteMultiPartUpload
 	case r.Method == http.MethodDelete && isObjectPath && query.Has("uploadId"):
 		return AbortMultiPartUpload
+	case r.Method == http.MethodDelete && isObjectPath:
+		return DeleteObject
 	default:
 		return Unsupported
 	}
@@ -582,33 +944,15 @@ func (p *Proxy) sendMessageOnOverwrite(username, fileID, s3FilePath string) erro
 	return nil
 }
 
-// FormatUploadFilePath ensures that path separators are "/", and returns error if the
-// filepath contains a disallowed character matched with regex
-func formatUploadFilePath(filePath string) (string, error) {
-	// Check for mixed "\" and "/" in filepath. Stop and throw an error if true so that
-	// we do not end up with unintended folder structure when applying ReplaceAll below
-	if strings.Contains(filePath, "\\") && strings.Contains(filePath, "/") {
-		return filePath, errors.New("filepath contains mixed '\\' and '/' characters")
-	}
-
-	// make any windows path separators linux compatible
-	outPath := strings.ReplaceAll(filePath, "\\", "/")
-
-	// [\x00-\x1F\x7F] is the control character set
-	re := regexp.MustCompile(`[\\<>"\|\x00-\x1F\x7F\!\*\'\(\)\;\:\@\&\=\+\$\,\?\%\#\[\]]`)
-
-	disallowedChars := re.FindAllString(outPath, -1)
-	if disallowedChars != nil {
-		return outPath, fmt.Errorf("filepath contains disallowed characters: %+v", strings.Join(disallowedChars, ", "))
-	}
-
-	return outPath, nil
-}
-
 // Write the error and its status code to the response
 func reportErrorToClient(errorCode int, message string, w http.ResponseWriter) {
+	reportS3ErrorToClient(errorCode, http.StatusText(errorCode), message, w)
+}
+
+// Write the error with an S3 error code, e.g. QuotaExceeded, and its status code to the response
+func reportS3ErrorToClient(errorCode int, s3ErrorCode, message string, w http.ResponseWriter) {
 	errorResponse := ErrorResponse{
-		Code:    http.StatusText(errorCode),
+		Code:    s3ErrorCode,
 		Message: message,
 	}
 	w.WriteHeader(errorCode)
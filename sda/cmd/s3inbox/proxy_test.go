@@ -191,7 +191,7 @@ func (s *ProxyTests) TestServeHTTP_concurrent_put_noRace() {
 	const workers = 50
 	const rounds = 20
 
-	proxy := NewProxy(s.s3Conf, s.s3Client, helper.NewAlwaysAllow(), nil, s.database, new(tls.Config))
+	proxy := NewProxy(s.s3Conf, s.s3Client, helper.NewAlwaysAllow(), nil, s.database, nil, new(tls.Config))
 	proxy.s3Conf.Endpoint = ":" // force forwardRequestToBackend to fail fast
 
 	for round := 0; round < rounds; round++ {
@@ -289,7 +289,7 @@ func (m *MockMessenger) SendMessage(uuid string, body []byte) error {
 
 // nolint:bodyclose
 func (s *ProxyTests) TestServeHTTP_disallowed() {
-	proxy := NewProxy(s.s3Fakeconf, s.s3ClientToFake, &helper.AlwaysAllow{}, s.messenger, s.database, new(tls.Config))
+	proxy := NewProxy(s.s3Fakeconf, s.s3ClientToFake, &helper.AlwaysAllow{}, s.messenger, s.database, nil, new(tls.Config))
 
 	r, _ := http.NewRequest("", "", nil)
 	w := httptest.NewRecorder()
@@ -302,11 +302,12 @@ func (s *ProxyTests) TestServeHTTP_disallowed() {
 	assert.Equal(s.T(), 403, w.Result().StatusCode)
 	assert.Equal(s.T(), false, s.fakeServer.PingedAndRestore())
 
-	// Deletion of files are disallowed
+	// Deletion of files outside of the users inbox is disallowed
+	w = httptest.NewRecorder()
 	r.Method = "DELETE"
 	r.URL, _ = url.Parse("/asdf/asdf")
 	proxy.ServeHTTP(w, r)
-	assert.Equal(s.T(), 403, w.Result().StatusCode)
+	assert.Equal(s.T(), 400, w.Result().StatusCode)
 	assert.Equal(s.T(), false, s.fakeServer.PingedAndRestore())
 
 	log.Warnf("getting to not allowed stuff")
@@ -334,8 +335,18 @@ func (s *ProxyTests) TestServeHTTP_disallowed() {
 	assert.Equal(s.T(), 403, w.Result().StatusCode)
 	assert.Equal(s.T(), false, s.fakeServer.PingedAndRestore())
 
+	// Copying files from outside of the users inbox is disallowed
+	w = httptest.NewRecorder()
+	r.Method = "PUT"
+	r.URL, _ = url.Parse("/dummy/copy")
+	r.Header.Set("x-amz-copy-source", "/asdf/asdf")
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 400, w.Result().StatusCode)
+	assert.Equal(s.T(), false, s.fakeServer.PingedAndRestore())
+	r.Header.Del("x-amz-copy-source")
+
 	// Not authorized user get 401 response
-	proxy = NewProxy(s.s3Fakeconf, s.s3ClientToFake, &helper.AlwaysDeny{}, s.messenger, s.database, new(tls.Config))
+	proxy = NewProxy(s.s3Fakeconf, s.s3ClientToFake, &helper.AlwaysDeny{}, s.messenger, s.database, nil, new(tls.Config))
 	w = httptest.NewRecorder()
 	r.Method = "GET"
 	r.URL, _ = url.Parse("/username/file")
@@ -352,7 +363,7 @@ func (s *ProxyTests) TestServeHTTPS3Unresponsive() {
 		Bucket:    "buckbuck",
 		Region:    "us-east-1",
 	}
-	proxy := NewProxy(s3conf, s.s3Client, &helper.AlwaysAllow{}, s.messenger, s.database, new(tls.Config))
+	proxy := NewProxy(s3conf, s.s3Client, &helper.AlwaysAllow{}, s.messenger, s.database, nil, new(tls.Config))
 
 	r, _ := http.NewRequest("", "", nil)
 	w := httptest.NewRecorder()
@@ -368,7 +379,7 @@ func (s *ProxyTests) TestServeHTTP_MQConnectionClosed() {
 	// Set up
 	messenger, err := broker.NewMQ(s.MQConf)
 	assert.NoError(s.T(), err)
-	proxy := NewProxy(s.s3Fakeconf, s.s3ClientToFake, helper.NewAlwaysAllow(), messenger, s.database, new(tls.Config))
+	proxy := NewProxy(s.s3Fakeconf, s.s3ClientToFake, helper.NewAlwaysAllow(), messenger, s.database, nil, new(tls.Config))
 
 	// Test that the mq connection will be restored when needed
 	proxy.messenger.Connection.Close()
@@ -386,7 +397,7 @@ func (s *ProxyTests) TestServeHTTP_MQChannelClosed() {
 	// Set up
 	messenger, err := broker.NewMQ(s.MQConf)
 	assert.NoError(s.T(), err)
-	proxy := NewProxy(s.s3Fakeconf, s.s3ClientToFake, helper.NewAlwaysAllow(), messenger, s.database, new(tls.Config))
+	proxy := NewProxy(s.s3Fakeconf, s.s3ClientToFake, helper.NewAlwaysAllow(), messenger, s.database, nil, new(tls.Config))
 
 	// Test that the mq channel will be restored when needed
 	proxy.messenger.Channel.Close()
@@ -404,7 +415,7 @@ func (s *ProxyTests) TestServeHTTP_MQ_Unavailable() {
 	// Set up
 	messenger, err := broker.NewMQ(s.MQConf)
 	assert.NoError(s.T(), err)
-	proxy := NewProxy(s.s3Fakeconf, s.s3ClientToFake, helper.NewAlwaysAllow(), messenger, s.database, new(tls.Config))
+	proxy := NewProxy(s.s3Fakeconf, s.s3ClientToFake, helper.NewAlwaysAllow(), messenger, s.database, nil, new(tls.Config))
 
 	// Test that the correct status code is returned when mq connection can't be created
 	proxy.messenger.Conf.Port = 123456
@@ -422,7 +433,7 @@ func (s *ProxyTests) TestServeHTTP_MQ_Unavailable() {
 func (s *ProxyTests) TestServeHTTP_allowed() {
 	messenger, err := broker.NewMQ(s.MQConf)
 	assert.NoError(s.T(), err)
-	proxy := NewProxy(s.s3Fakeconf, s.s3ClientToFake, helper.NewAlwaysAllow(), messenger, s.database, new(tls.Config))
+	proxy := NewProxy(s.s3Fakeconf, s.s3ClientToFake, helper.NewAlwaysAllow(), messenger, s.database, nil, new(tls.Config))
 
 	// List files works
 	r, err := http.NewRequest("GET", "/dummy", nil)
@@ -467,6 +478,31 @@ func (s *ProxyTests) TestServeHTTP_allowed() {
 	assert.Equal(s.T(), 200, w.Result().StatusCode)
 	assert.Equal(s.T(), true, s.fakeServer.PingedAndRestore())
 
+	// Get object works
+	r.Method = "GET"
+	r.URL, _ = url.Parse("/dummy/file")
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 200, w.Result().StatusCode)
+	assert.Equal(s.T(), true, s.fakeServer.PingedAndRestore())
+
+	// Head object returns the stored size
+	r.Method = "HEAD"
+	r.URL, _ = url.Parse("/dummy/file")
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 200, w.Result().StatusCode)
+	assert.Equal(s.T(), "5", w.Result().Header.Get("Content-Length"))
+	assert.Equal(s.T(), true, s.fakeServer.PingedAndRestore())
+
+	// Delete object of a file not registered in the database is only forwarded
+	r.Method = "DELETE"
+	r.URL, _ = url.Parse("/dummy/not_registered")
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 200, w.Result().StatusCode)
+	assert.Equal(s.T(), true, s.fakeServer.PingedAndRestore())
+
 	// Going through the different extra stuff that can be in the get request
 	// that trigger different code paths in the code.
 	// Delimiter alone
@@ -539,7 +575,7 @@ func (s *ProxyTests) TestMessageFormatting() {
 	assert.NoError(s.T(), claims.Set("sub", user))
 
 	// start proxy that denies everything
-	proxy := NewProxy(s.s3Fakeconf, s.s3ClientToFake, &helper.AlwaysDeny{}, s.messenger, s.database, new(tls.Config))
+	proxy := NewProxy(s.s3Fakeconf, s.s3ClientToFake, &helper.AlwaysDeny{}, s.messenger, s.database, nil, new(tls.Config))
 	s.fakeServer.resp = "<ListBucketResult xmlns=\"http://s3.amazonaws.com/doc/2006-03-01/\"><Name>test</Name><Prefix>/user/new_file.txt</Prefix><KeyCount>1</KeyCount><MaxKeys>2</MaxKeys><Delimiter></Delimiter><IsTruncated>false</IsTruncated><Contents><Key>/user/new_file.txt</Key><LastModified>2020-03-10T13:20:15.000Z</LastModified><ETag>&#34;0a44282bd39178db9680f24813c41aec-1&#34;</ETag><Size>1234</Size><Owner><ID></ID><DisplayName></DisplayName></Owner><StorageClass>STANDARD</StorageClass></Contents></ListBucketResult>"
 	s.fakeServer.headHeaders = map[string]string{"ETag": "\"0a44282bd39178db9680f24813c41aec-1\"", "Content-Length": "1234"}
 	msg, checksumValue, err := proxy.CreateMessageFromRequest(r.Context(), claims.Subject(), "new_file.txt")
@@ -571,7 +607,7 @@ func (s *ProxyTests) TestDatabaseConnection() {
 	assert.NoError(s.T(), err)
 	defer messenger.Connection.Close()
 	// Start proxy that allows everything
-	proxy := NewProxy(s.s3Fakeconf, s.s3ClientToFake, helper.NewAlwaysAllow(), messenger, s.database, new(tls.Config))
+	proxy := NewProxy(s.s3Fakeconf, s.s3ClientToFake, helper.NewAlwaysAllow(), messenger, s.database, nil, new(tls.Config))
 
 	// PUT a file into the system
 	filename := "/dummy/db-test-file"
@@ -605,29 +641,11 @@ func (s *ProxyTests) TestDatabaseConnection() {
 	}
 }
 
-func (s *ProxyTests) TestFormatUploadFilePath() {
-	unixPath := "a/b/c.c4gh"
-	testPath := "a\\b\\c.c4gh"
-	uploadPath, err := formatUploadFilePath(testPath)
-	assert.NoError(s.T(), err)
-	assert.Equal(s.T(), unixPath, uploadPath)
-
-	// mixed "\" and "/"
-	weirdPath := `dq\sw:*?"<>|\t\s/df.c4gh`
-	_, err = formatUploadFilePath(weirdPath)
-	assert.EqualError(s.T(), err, "filepath contains mixed '\\' and '/' characters")
-
-	// no mixed "\" and "/" but not allowed
-	weirdPath = `dq\sw:*?"<>|\t\sdf!s'(a);w@4&f=+e$,g#[]d%.c4gh`
-	_, err = formatUploadFilePath(weirdPath)
-	assert.EqualError(s.T(), err, "filepath contains disallowed characters: :, *, ?, \", <, >, |, !, ', (, ), ;, @, &, =, +, $, ,, #, [, ], %")
-}
-
 func (s *ProxyTests) TestCheckFileExists() {
 	messenger, err := broker.NewMQ(s.MQConf)
 	assert.NoError(s.T(), err)
 	defer messenger.Connection.Close()
-	proxy := NewProxy(s.s3Conf, s.s3Client, helper.NewAlwaysAllow(), messenger, s.database, new(tls.Config))
+	proxy := NewProxy(s.s3Conf, s.s3Client, helper.NewAlwaysAllow(), messenger, s.database, nil, new(tls.Config))
 	res, err := proxy.checkFileExists(context.Background(), "/dummy/file")
 	assert.True(s.T(), res)
 	assert.Nil(s.T(), err)
@@ -639,7 +657,7 @@ func (s *ProxyTests) TestCheckFileExists_nonExistingFile() {
 	messenger, err := broker.NewMQ(s.MQConf)
 	assert.NoError(s.T(), err)
 	defer messenger.Connection.Close()
-	proxy := NewProxy(s.s3Conf, s.s3Client, helper.NewAlwaysAllow(), s.messenger, s.database, new(tls.Config))
+	proxy := NewProxy(s.s3Conf, s.s3Client, helper.NewAlwaysAllow(), s.messenger, s.database, nil, new(tls.Config))
 	res, err := proxy.checkFileExists(context.Background(), "nonexistingfilepath")
 	assert.False(s.T(), res)
 	assert.Nil(s.T(), err)
@@ -653,7 +671,7 @@ func (s *ProxyTests) TestCheckFileExists_unresponsive() {
 	defer messenger.Connection.Close()
 
 	// Unaccessible S3 (wrong port)
-	proxy := NewProxy(s.s3Conf, s.s3Client, helper.NewAlwaysAllow(), s.messenger, s.database, new(tls.Config))
+	proxy := NewProxy(s.s3Conf, s.s3Client, helper.NewAlwaysAllow(), s.messenger, s.database, nil, new(tls.Config))
 	proxy.s3Conf.Endpoint = "http://127.0.0.1:1111"
 	proxy.s3Client, err = newS3Client(context.Background(), proxy.s3Conf)
 	assert.NoError(s.T(), err)
@@ -678,7 +696,7 @@ func (s *ProxyTests) TestStoreObjectSizeInDB_s3Failure() {
 	assert.NoError(s.T(), err)
 	defer mq.Connection.Close()
 
-	proxy := NewProxy(s.s3Conf, s.s3Client, helper.NewAlwaysAllow(), s.messenger, s.database, new(tls.Config))
+	proxy := NewProxy(s.s3Conf, s.s3Client, helper.NewAlwaysAllow(), s.messenger, s.database, nil, new(tls.Config))
 
 	fileID, err := proxy.database.RegisterFile(context.Background(), nil, "/inbox", "/dummy/file", "test-user")
 	assert.NoError(s.T(), err)
@@ -704,7 +722,7 @@ func (s *ProxyTests) TestStoreObjectSizeInDB_fastCheck() {
 	assert.NoError(s.T(), err)
 	defer mq.Connection.Close()
 
-	p := NewProxy(s.s3Conf, s.s3Client, helper.NewAlwaysAllow(), s.messenger, s.database, new(tls.Config))
+	p := NewProxy(s.s3Conf, s.s3Client, helper.NewAlwaysAllow(), s.messenger, s.database, nil, new(tls.Config))
 
 	fileID, err := p.database.RegisterFile(context.Background(), nil, "/inbox", "/test/new_file", "test-user")
 	assert.NoError(s.T(), err)
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/inboxcheck"
+)
+
+// checkQuota returns inboxcheck.ErrQuotaExceeded when the file uploaded by the request would exceed the quota of the
+// user, the default quota of the inbox applies to users that do not have a quota of their own
+func (p *Proxy) checkQuota(ctx context.Context, username, fileID string, size func() (int64, error)) error {
+	defaultQuota := database.InboxQuota{User: username, MaxBytes: p.s3Conf.QuotaBytes, MaxFiles: p.s3Conf.QuotaFiles}
+
+	return inboxcheck.CheckQuota(ctx, p.database, defaultQuota, username, fileID, size)
+}
+
+// requestQuotaSize returns a function returning the size of the file uploaded by the request. This is the size of the
+// body of a PutObject, the size of the copied object of a CopyObject, and the size of the parts uploaded so far of a
+// multipart upload for UploadPart and CompleteMultipartUpload, where the part being uploaded replaces any earlier
+// upload of the same part. The size of a multipart upload is not known when it is created
+func (p *Proxy) requestQuotaSize(ctx context.Context, r *http.Request, s3RequestType S3RequestType, s3FilePath string) func() (int64, error) {
+	return func() (int64, error) {
+		switch s3RequestType {
+		case PutObject:
+			return uploadSize(r), nil
+		case CopyObject:
+			return p.copySourceSize(ctx, r)
+		case UploadPart:
+			partNumber, err := strconv.ParseInt(r.URL.Query().Get("partNumber"), 10, 32)
+			if err != nil {
+				return 0, fmt.Errorf("invalid part number: %v", err)
+			}
+			size, err := p.uploadedPartsSize(ctx, s3FilePath, r.URL.Query().Get("uploadId"), int32(partNumber))
+
+			return size + uploadSize(r), err
+		case CompleteMultiPartUpload:
+			return p.uploadedPartsSize(ctx, s3FilePath, r.URL.Query().Get("uploadId"), 0)
+		default:
+			return 0, nil
+		}
+	}
+}
+
+// copySourceSize returns the size of the object copied by a CopyObject request, of which the copy source has already
+// been made user specific
+func (p *Proxy) copySourceSize(ctx context.Context, r *http.Request) (int64, error) {
+	copySource, err := url.PathUnescape(r.Header.Get("x-amz-copy-source"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid copy source: %v", err)
+	}
+
+	_, size, err := p.requestInfo(ctx, strings.TrimPrefix(copySource, "/"+p.s3Conf.Bucket+"/"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to get size of copy source: %v", err)
+	}
+
+	return size, nil
+}
+
+// uploadedPartsSize returns the total size of the parts uploaded so far of a multipart upload, leaving out the part
+// with the number exceptPart
+func (p *Proxy) uploadedPartsSize(ctx context.Context, s3FilePath, uploadID string, exceptPart int32) (int64, error) {
+	var size int64
+	paginator := s3.NewListPartsPaginator(p.s3Client, &s3.ListPartsInput{
+		Bucket:   aws.String(p.s3Conf.Bucket),
+		Key:      aws.String(s3FilePath),
+		UploadId: aws.String(uploadID),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("failed to list parts of upload: %s, due to: %v", uploadID, err)
+		}
+		for _, part := range page.Parts {
+			if aws.ToInt32(part.PartNumber) != exceptPart {
+				size += aws.ToInt64(part.Size)
+			}
+		}
+	}
+
+	return size, nil
+}
+
+// uploadSize returns the size of the object uploaded by the request, 0 when it is not known. The content of uploads
+// signed in chunks is larger than the object, its size is given by the x-amz-decoded-content-length header
+func uploadSize(r *http.Request) int64 {
+	if decoded := r.Header.Get("x-amz-decoded-content-length"); decoded != "" {
+		size, err := strconv.ParseInt(decoded, 10, 64)
+		if err == nil && size > 0 {
+			return size
+		}
+	}
+	if r.ContentLength > 0 {
+		return r.ContentLength
+	}
+
+	return 0
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/inboxcheck"
+	"github.com/stretchr/testify/assert"
+)
+
+// sizeOf returns a function returning the size of an uploaded file
+func sizeOf(size int64) func() (int64, error) {
+	return func() (int64, error) {
+		return size, nil
+	}
+}
+
+func (s *UploadTests) TestCheckQuota_default() {
+	proxy := s.newProxy()
+	s.db.usage = database.InboxUsage{Bytes: 900, Files: 9}
+
+	// Users are not limited by default
+	assert.NoError(s.T(), proxy.checkQuota(context.TODO(), "dummy", "", sizeOf(1000)))
+
+	proxy.s3Conf.QuotaBytes = 1000
+	proxy.s3Conf.QuotaFiles = 10
+	assert.NoError(s.T(), proxy.checkQuota(context.TODO(), "dummy", "", sizeOf(100)))
+	assert.ErrorIs(s.T(), proxy.checkQuota(context.TODO(), "dummy", "", sizeOf(101)), inboxcheck.ErrQuotaExceeded)
+
+	s.db.usage = database.InboxUsage{Bytes: 900, Files: 10}
+	assert.ErrorIs(s.T(), proxy.checkQuota(context.TODO(), "dummy", "", sizeOf(0)), inboxcheck.ErrQuotaExceeded)
+
+	// The file replaced by the upload is left out of the usage
+	s.db.usage = database.InboxUsage{Bytes: 800, Files: 9}
+	assert.NoError(s.T(), proxy.checkQuota(context.TODO(), "dummy", "file-id", sizeOf(200)))
+	assert.Equal(s.T(), "file-id", s.db.excludedFileID)
+
+	// Uploads of unknown size are rejected once the user has reached the quota
+	s.db.usage = database.InboxUsage{Bytes: 1000, Files: 1}
+	assert.ErrorIs(s.T(), proxy.checkQuota(context.TODO(), "dummy", "", sizeOf(0)), inboxcheck.ErrQuotaExceeded)
+}
+
+func (s *UploadTests) TestCheckQuota_user() {
+	proxy := s.newProxy()
+	proxy.s3Conf.QuotaBytes = 1000
+	proxy.s3Conf.QuotaFiles = 10
+	s.db.usage = database.InboxUsage{Bytes: 5000, Files: 50}
+
+	// The quota of the user replaces the default quota, 0 means unlimited
+	s.db.quotas["dummy"] = &database.InboxQuota{User: "dummy", MaxBytes: 10000, MaxFiles: 0}
+	assert.NoError(s.T(), proxy.checkQuota(context.TODO(), "dummy", "", sizeOf(5000)))
+	assert.ErrorIs(s.T(), proxy.checkQuota(context.TODO(), "dummy", "", sizeOf(5001)), inboxcheck.ErrQuotaExceeded)
+
+	// Other users have the default quota
+	assert.ErrorIs(s.T(), proxy.checkQuota(context.TODO(), "other", "", sizeOf(0)), inboxcheck.ErrQuotaExceeded)
+}
+
+func (s *UploadTests) TestRequestQuotaSize() {
+	proxy := s.newProxy()
+	s.objects["/buckbuck/dummy/source.c4gh"] = 500
+	s.parts = map[int32]int64{1: 100, 2: 200}
+
+	r := httptest.NewRequest(http.MethodPut, "/buckbuck/dummy/file.c4gh", bytes.NewReader(make([]byte, 50)))
+	size, err := proxy.requestQuotaSize(context.TODO(), r, PutObject, "dummy/file.c4gh")()
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(50), size)
+
+	// A copy has the size of the copied object
+	r = httptest.NewRequest(http.MethodPut, "/buckbuck/dummy/file.c4gh", nil)
+	r.Header.Set("x-amz-copy-source", "/buckbuck/dummy/source.c4gh")
+	size, err = proxy.requestQuotaSize(context.TODO(), r, CopyObject, "dummy/file.c4gh")()
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(500), size)
+
+	// An uploaded part adds to the parts uploaded so far, replacing an earlier upload of the same part
+	r = httptest.NewRequest(http.MethodPut, "/buckbuck/dummy/file.c4gh?partNumber=3&uploadId=1", bytes.NewReader(make([]byte, 50)))
+	size, err = proxy.requestQuotaSize(context.TODO(), r, UploadPart, "dummy/file.c4gh")()
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(350), size)
+	r = httptest.NewRequest(http.MethodPut, "/buckbuck/dummy/file.c4gh?partNumber=2&uploadId=1", bytes.NewReader(make([]byte, 50)))
+	size, err = proxy.requestQuotaSize(context.TODO(), r, UploadPart, "dummy/file.c4gh")()
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(150), size)
+
+	// A completed multipart upload has the size of its parts
+	r = httptest.NewRequest(http.MethodPost, "/buckbuck/dummy/file.c4gh?uploadId=1", nil)
+	size, err = proxy.requestQuotaSize(context.TODO(), r, CompleteMultiPartUpload, "dummy/file.c4gh")()
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(300), size)
+}
+
+func (s *UploadTests) TestUploadSize() {
+	r := httptest.NewRequest(http.MethodPut, "/dummy/file.c4gh", bytes.NewReader(make([]byte, 100)))
+	assert.Equal(s.T(), int64(100), uploadSize(r))
+
+	// Uploads signed in chunks have the size of the object in a header
+	r.Header.Set("x-amz-decoded-content-length", "80")
+	assert.Equal(s.T(), int64(80), uploadSize(r))
+
+	r = httptest.NewRequest(http.MethodPut, "/dummy/file.c4gh", nil)
+	assert.Equal(s.T(), int64(0), uploadSize(r))
+}
+
+// nolint:bodyclose
+func (s *UploadTests) TestServeHTTP_quotaExceeded() {
+	proxy := s.newProxy()
+	proxy.s3Conf.QuotaFiles = 1
+	s.db.usage = database.InboxUsage{Bytes: 100, Files: 1}
+
+	r := httptest.NewRequest(http.MethodPut, "/dummy/file.c4gh", bytes.NewReader(s.encrypt([]byte("content"), s.publicKey)))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), http.StatusForbidden, w.Result().StatusCode)
+	assert.Contains(s.T(), w.Body.String(), "<Code>QuotaExceeded</Code>")
+	assert.Nil(s.T(), s.uploaded)
+}
+
+// nolint:bodyclose
+func (s *UploadTests) TestServeHTTP_uploadPartQuotaExceeded() {
+	proxy := s.newProxy()
+	proxy.s3Conf.QuotaBytes = 1000
+	s.db.fileID = "file-id"
+	s.db.usage = database.InboxUsage{Bytes: 500, Files: 1}
+	s.parts = map[int32]int64{1: 400}
+
+	// A part is rejected when the parts uploaded so far and the part exceed the quota
+	r := httptest.NewRequest(http.MethodPut, "/dummy/file.c4gh?partNumber=2&uploadId=1", bytes.NewReader(make([]byte, 101)))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), http.StatusForbidden, w.Result().StatusCode)
+	assert.Contains(s.T(), w.Body.String(), "<Code>QuotaExceeded</Code>")
+	assert.Nil(s.T(), s.uploaded)
+	assert.Equal(s.T(), "file-id", s.db.excludedFileID)
+
+	r = httptest.NewRequest(http.MethodPut, "/dummy/file.c4gh?partNumber=2&uploadId=1", bytes.NewReader(make([]byte, 100)))
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), http.StatusOK, w.Result().StatusCode)
+}
@@ -26,9 +26,11 @@ import (
 	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/neicnordic/sensitive-data-archive/internal/broker"
 	"github.com/neicnordic/sensitive-data-archive/internal/config"
+	"github.com/neicnordic/sensitive-data-archive/internal/reencrypt"
 	"github.com/neicnordic/sensitive-data-archive/internal/userauth"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 )
 
 func main() {
@@ -59,8 +61,8 @@ func run() error {
 		return fmt.Errorf("failed to initialize sda db due to: %v", err)
 	}
 	defer db.Close()
-	if dbSchemaVersion, err := db.SchemaVersion(); err != nil || dbSchemaVersion < 23 {
-		return errors.Join(errors.New("database schema v23 is required"), err)
+	if dbSchemaVersion, err := db.SchemaVersion(); err != nil || dbSchemaVersion < 33 {
+		return errors.Join(errors.New("database schema v33 is required"), err)
 	}
 
 	s3Client, err := newS3Client(ctx, conf.S3Inbox)
@@ -104,8 +106,24 @@ func run() error {
 			return fmt.Errorf("failed to read jwt pub key from path: %s, due to %v", conf.Server.Jwtpubkeypath, err)
 		}
 	}
+	// The crypt4gh header of uploads are only validated when the reencrypt service is configured, the archive keys
+	// are kept out of the inbox and the headers are checked by the reencrypt service instead
+	var reencryptClient reencrypt.ReencryptClient
+	if viper.IsSet("grpc.host") {
+		grpcConf, err := config.GetReEncryptClientConfig()
+		if err != nil {
+			return fmt.Errorf("failed to read reencrypt client config: %v", err)
+		}
+		conn, err := reencrypt.NewClientConn(grpcConf)
+		if err != nil {
+			return fmt.Errorf("failed to create reencrypt client: %v", err)
+		}
+		defer conn.Close()
+		reencryptClient = reencrypt.NewReencryptClient(conn)
+	}
+
 	router := mux.NewRouter()
-	proxy := NewProxy(conf.S3Inbox, s3Client, auth, mqBroker, db, tlsProxy)
+	proxy := NewProxy(conf.S3Inbox, s3Client, auth, mqBroker, db, reencryptClient, tlsProxy)
 	router.HandleFunc("/", proxy.CheckHealth).Methods("HEAD")
 	router.HandleFunc("/health", proxy.CheckHealth)
 	router.PathPrefix("/").Handler(proxy)
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"time"
+
+	config "github.com/neicnordic/sensitive-data-archive/internal/config/v2"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var (
+	scrubInterval time.Duration
+	pollInterval  time.Duration
+	batchSize     int
+	rateLimit     int64
+	alertQueue    string
+	repairQueue   string
+)
+
+func init() {
+	config.RegisterFlags(
+		&config.Flag{
+			Name: "scrubInterval",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Duration(flagName, 30*24*time.Hour, "How long to wait before scrubbing a file again after it has been scrubbed. Expects a go time.Duration parsable string")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				scrubInterval = viper.GetDuration(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "pollInterval",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Duration(flagName, time.Hour, "How often to look for files which are due to be scrubbed. Expects a go time.Duration parsable string")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				pollInterval = viper.GetDuration(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "batchSize",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Int(flagName, 100, "Amount of files due to be scrubbed to fetch from the database at a time")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				batchSize = viper.GetInt(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "rateLimit",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Int64(flagName, 50*1024*1024, "Maximum amount of bytes per second to read from storage when scrubbing, 0 disables the limit")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				rateLimit = viper.GetInt64(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "alertQueue",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "error", "The queue where the scrub service publishes alerts about files which failed to be scrubbed")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				alertQueue = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "repairQueue",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "The queue where the scrub service requests files with a corrupted archive copy and an intact backup copy to be repaired, leave empty to not request repairs")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				repairQueue = viper.GetString(flagName)
+			},
+		},
+	)
+}
+
+func ScrubInterval() time.Duration {
+	return scrubInterval
+}
+
+func PollInterval() time.Duration {
+	return pollInterval
+}
+
+func BatchSize() int {
+	return batchSize
+}
+
+func RateLimit() int64 {
+	return rateLimit
+}
+
+func AlertQueue() string {
+	return alertQueue
+}
+
+func RepairQueue() string {
+	return repairQueue
+}
+
+func SetBatchSize(size int) {
+	batchSize = size
+}
+
+func SetRepairQueue(queue string) {
+	repairQueue = queue
+}
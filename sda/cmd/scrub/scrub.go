@@ -0,0 +1,319 @@
+// The scrub service periodically re-verifies the checksums of the archive and
+// backup copies of archived files, to detect archived data which has been lost
+// or corrupted in storage.
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	scrubconf "github.com/neicnordic/sensitive-data-archive/cmd/scrub/config"
+	brokerv2 "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/broker/v2/factory"
+	configv2 "github.com/neicnordic/sensitive-data-archive/internal/config/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/database/postgres"
+	"github.com/neicnordic/sensitive-data-archive/internal/helper"
+	"github.com/neicnordic/sensitive-data-archive/internal/schema"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+type Scrub struct {
+	ArchiveReader storage.Reader
+	// BackupReader is nil when no backup storage is configured, in which case backup copies are not scrubbed
+	BackupReader storage.Reader
+	Broker       brokerv2.Broker
+	db           database.Database
+	// limiter limits the rate at which copies are read from storage, nil if not limited
+	limiter *rate.Limiter
+}
+
+// scrubAlert is published to the alert queue for each copy of a file which failed to be scrubbed
+type scrubAlert struct {
+	FileID   string `json:"file_id"`
+	Copy     string `json:"copy"`
+	Location string `json:"location"`
+	FilePath string `json:"file_path"`
+	// Corrupted is set when the copy is missing or its content does not match the archived checksum, and not set when
+	// the copy could not be checked
+	Corrupted bool   `json:"corrupted"`
+	Reason    string `json:"reason"`
+}
+
+// errCorrupted is returned when a copy is missing or its content does not match the size and checksum of the archived
+// file
+var errCorrupted = errors.New("copy is corrupted")
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run() error {
+	var err error
+	app := Scrub{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err = configv2.Load(); err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
+	switch {
+	case scrubconf.ScrubInterval() <= 0:
+		return errors.New("scrubInterval needs to be positive")
+	case scrubconf.PollInterval() <= 0:
+		return errors.New("pollInterval needs to be positive")
+	case scrubconf.BatchSize() < 1:
+		return errors.New("batchSize needs to be at least 1")
+	case scrubconf.RateLimit() < 0:
+		return errors.New("rateLimit can not be negative")
+	}
+
+	app.Broker, err = factory.NewBroker(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to initialize mq broker, due to: %v", err)
+	}
+	defer func() {
+		if err := app.Broker.Close(); err != nil {
+			log.Errorf("could not close Broker, due to: %v", err)
+		}
+	}()
+
+	app.db, err = postgres.NewPostgresSQLDatabase()
+	if err != nil {
+		return fmt.Errorf("failed to initialize sda db due to: %v", err)
+	}
+	defer app.db.Close()
+	if dbSchemaVersion, err := app.db.SchemaVersion(); err != nil || dbSchemaVersion < 27 {
+		return errors.Join(errors.New("database schema v27 is required"), err)
+	}
+
+	app.ArchiveReader, err = storage.NewReader(ctx, "archive")
+	if err != nil {
+		return fmt.Errorf("failed to initialize archive reader, due to: %v", err)
+	}
+	app.BackupReader, err = storage.NewReader(ctx, "backup")
+	if err != nil && !errors.Is(err, storageerrors.ErrorNoValidReader) {
+		return fmt.Errorf("failed to initialize backup reader, due to: %v", err)
+	}
+	if app.BackupReader == nil {
+		log.Info("no backup reader initialized, will NOT scrub backup copies")
+	}
+
+	if limit := scrubconf.RateLimit(); limit > 0 {
+		app.limiter = helper.NewBandwidthLimiter(limit)
+	}
+	log.Info("starting scrub service")
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+
+	go func() {
+		sig := <-sigc
+		log.Infof("recieved signal: %v, shutting down gracefully", sig)
+		cancel()
+	}()
+
+	ticker := time.NewTicker(scrubconf.PollInterval())
+	defer ticker.Stop()
+	for {
+		if err := app.scrubFiles(ctx); err != nil {
+			if errors.Is(err, context.Canceled) {
+				return nil
+			}
+			log.Errorf("failed to scrub files, will retry in %s, due to: %v", scrubconf.PollInterval(), err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// scrubFiles scrubs all files which have not been scrubbed within the scrub interval
+func (app *Scrub) scrubFiles(ctx context.Context) error {
+	// Files scrubbed during this run will have been scrubbed after this point in time, and will not be fetched again
+	scrubbedBefore := time.Now().Add(-scrubconf.ScrubInterval())
+
+	scrubbed := 0
+	for {
+		files, err := app.db.GetFilesToScrub(ctx, scrubbedBefore, scrubconf.BatchSize())
+		if err != nil {
+			return fmt.Errorf("failed to get files to scrub, due to: %v", err)
+		}
+		if len(files) == 0 {
+			if scrubbed > 0 {
+				log.Infof("scrubbed %d files", scrubbed)
+			}
+
+			return nil
+		}
+
+		for _, file := range files {
+			if err := app.scrubFile(ctx, file); err != nil {
+				return err
+			}
+			scrubbed++
+		}
+	}
+}
+
+// scrubFile scrubs the archive copy, and the backup copy if there is one, of the file and records the outcome.
+// Returns an error if the outcome could not be recorded
+func (app *Scrub) scrubFile(ctx context.Context, file *database.ScrubFile) error {
+	log.Debugf("scrubbing file: %s", file.FileID)
+
+	var alerts []scrubAlert
+	archiveErr := app.scrubCopy(ctx, app.ArchiveReader, file.ArchiveLocation, file.ArchiveFilePath, file.ArchiveFileSize, file.ArchivedChecksum)
+	if archiveErr != nil {
+		alerts = append(alerts, newScrubAlert(file.FileID, "archive", file.ArchiveLocation, file.ArchiveFilePath, archiveErr))
+	}
+
+	backupIntact := false
+	if file.BackupFilePath != "" && app.BackupReader != nil {
+		if err := app.scrubCopy(ctx, app.BackupReader, file.BackupLocation, file.BackupFilePath, file.ArchiveFileSize, file.ArchivedChecksum); err != nil {
+			alerts = append(alerts, newScrubAlert(file.FileID, "backup", file.BackupLocation, file.BackupFilePath, err))
+		} else {
+			backupIntact = true
+		}
+	}
+
+	// An interrupted scrub is neither successful nor failed
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if len(alerts) == 0 {
+		log.Debugf("file: %s scrubbed successfully", file.FileID)
+
+		return app.db.SetFileScrubbed(ctx, file.FileID, true, "")
+	}
+
+	var reasons []error
+	for _, alert := range alerts {
+		log.Errorf("failed to scrub %s copy of file: %s, location: %s, path: %s, reason: %s", alert.Copy, alert.FileID, alert.Location, alert.FilePath, alert.Reason)
+		reasons = append(reasons, fmt.Errorf("%s copy: %s", alert.Copy, alert.Reason))
+		app.publishAlert(ctx, alert)
+	}
+
+	// Only a corrupted archive copy puts the file in an error state, as the file can still be served from the archive
+	// when only the backup copy is corrupted
+	if errors.Is(archiveErr, errCorrupted) {
+		details, _ := json.Marshal(map[string]string{"error": archiveErr.Error()})
+		alert, _ := json.Marshal(alerts[0])
+		if err := app.db.UpdateFileEventLog(ctx, file.FileID, "error", "scrub", string(details), string(alert)); err != nil {
+			log.Errorf("failed to set error event for file: %s, due to: %v", file.FileID, err)
+		}
+		if backupIntact && scrubconf.RepairQueue() != "" {
+			app.requestRepair(ctx, file.FileID)
+		}
+	}
+
+	return app.db.SetFileScrubbed(ctx, file.FileID, false, errors.Join(reasons...).Error())
+}
+
+// scrubCopy reads the copy of the archived file at the location and file path, and checks that its size and checksum
+// match those of the archived file. Returns an error wrapping errCorrupted if they do not match
+func (app *Scrub) scrubCopy(ctx context.Context, reader storage.Reader, location, filePath string, size int64, checksum string) error {
+	storedSize, err := reader.GetFileSize(ctx, location, filePath)
+	if err != nil {
+		if errors.Is(err, storageerrors.ErrorFileNotFoundInLocation) {
+			return fmt.Errorf("%w: %v", errCorrupted, err)
+		}
+
+		return fmt.Errorf("failed to get size of copy, due to: %v", err)
+	}
+	if storedSize != size {
+		return fmt.Errorf("%w: size: %d does not match archived size: %d", errCorrupted, storedSize, size)
+	}
+
+	f, err := reader.NewFileReader(ctx, location, filePath)
+	if err != nil {
+		if errors.Is(err, storageerrors.ErrorFileNotFoundInLocation) {
+			return fmt.Errorf("%w: %v", errCorrupted, err)
+		}
+
+		return fmt.Errorf("failed to open copy, due to: %v", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var content io.Reader = f
+	if app.limiter != nil {
+		content = helper.NewRateLimitedReader(ctx, f, app.limiter)
+	}
+
+	hash := sha256.New()
+	readSize, err := io.Copy(hash, content)
+	if err != nil {
+		return fmt.Errorf("failed to read copy, due to: %v", err)
+	}
+	if readSize != size {
+		return fmt.Errorf("%w: read size: %d does not match archived size: %d", errCorrupted, readSize, size)
+	}
+	if readChecksum := fmt.Sprintf("%x", hash.Sum(nil)); readChecksum != checksum {
+		return fmt.Errorf("%w: checksum: %s does not match archived checksum: %s", errCorrupted, readChecksum, checksum)
+	}
+
+	return nil
+}
+
+func newScrubAlert(fileID, copyName, location, filePath string, err error) scrubAlert {
+	return scrubAlert{
+		FileID:    fileID,
+		Copy:      copyName,
+		Location:  location,
+		FilePath:  filePath,
+		Corrupted: errors.Is(err, errCorrupted),
+		Reason:    err.Error(),
+	}
+}
+
+// publishAlert publishes the alert to the alert queue, failures are only logged as the outcome of the scrub is recorded
+// in the database regardless
+func (app *Scrub) publishAlert(ctx context.Context, alert scrubAlert) {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		log.Errorf("failed to marshal scrub alert, due to: %v", err)
+
+		return
+	}
+
+	if err := app.Broker.Publish(ctx, scrubconf.AlertQueue(), brokerv2.Message{Key: alert.FileID, Body: body}); err != nil {
+		log.Errorf("failed to publish scrub alert for file: %s, due to: %v", alert.FileID, err)
+	}
+}
+
+// requestRepair requests the archive copy of the file to be restored from its backup copy, failures are only logged as
+// the file has been put in the error state regardless
+func (app *Scrub) requestRepair(ctx context.Context, fileID string) {
+	body, err := json.Marshal(schema.RepairFile{Type: "repair", FileID: fileID})
+	if err != nil {
+		log.Errorf("failed to marshal repair message, due to: %v", err)
+
+		return
+	}
+
+	if err := app.Broker.Publish(ctx, scrubconf.RepairQueue(), brokerv2.Message{Key: fileID, Body: body}); err != nil {
+		log.Errorf("failed to request repair of file: %s, due to: %v", fileID, err)
+
+		return
+	}
+	log.Infof("requested repair of archive copy of file: %s", fileID)
+}
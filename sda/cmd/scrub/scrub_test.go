@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	scrubconf "github.com/neicnordic/sensitive-data-archive/cmd/scrub/config"
+	brokerv2 "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/time/rate"
+)
+
+type TestSuite struct {
+	suite.Suite
+	content []byte
+	db      *mockDatabase
+	broker  *mockBroker
+	app     Scrub
+}
+
+func TestScrubTestSuite(t *testing.T) {
+	suite.Run(t, new(TestSuite))
+}
+
+// mockDatabase implements the database functions used by scrub, calling any other function panics
+type mockDatabase struct {
+	database.Database
+	files    []*database.ScrubFile
+	scrubbed map[string]string
+	events   map[string]string
+}
+
+func (m *mockDatabase) GetFilesToScrub(_ context.Context, _ time.Time, limit int) ([]*database.ScrubFile, error) {
+	var files []*database.ScrubFile
+	for _, file := range m.files {
+		if _, ok := m.scrubbed[file.FileID]; !ok && len(files) < limit {
+			files = append(files, file)
+		}
+	}
+
+	return files, nil
+}
+
+func (m *mockDatabase) SetFileScrubbed(_ context.Context, fileID string, _ bool, scrubError string) error {
+	m.scrubbed[fileID] = scrubError
+
+	return nil
+}
+
+func (m *mockDatabase) UpdateFileEventLog(_ context.Context, fileID, event, _, _, _ string) error {
+	m.events[fileID] = event
+
+	return nil
+}
+
+type mockBroker struct {
+	brokerv2.Broker
+	alerts  []scrubAlert
+	repairs []string
+}
+
+func (m *mockBroker) Publish(_ context.Context, destination string, message brokerv2.Message) error {
+	if destination == "repair" {
+		m.repairs = append(m.repairs, message.Key)
+
+		return nil
+	}
+
+	var alert scrubAlert
+	if err := json.Unmarshal(message.Body, &alert); err != nil {
+		return err
+	}
+	m.alerts = append(m.alerts, alert)
+
+	return nil
+}
+
+// mockReader serves the files in memory, files are stored by location and file path
+type mockReader struct {
+	files map[string][]byte
+	err   error
+}
+
+func (r *mockReader) file(location, filePath string) ([]byte, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	content, ok := r.files[location+"/"+filePath]
+	if !ok {
+		return nil, storageerrors.ErrorFileNotFoundInLocation
+	}
+
+	return content, nil
+}
+
+func (r *mockReader) NewFileReader(_ context.Context, location, filePath string) (io.ReadCloser, error) {
+	content, err := r.file(location, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+func (r *mockReader) NewFileReadSeeker(_ context.Context, _, _ string) (io.ReadSeekCloser, error) {
+	return nil, errors.New("not implemented")
+}
+func (r *mockReader) FindFile(_ context.Context, _ string) (string, error) {
+	return "", errors.New("not implemented")
+}
+func (r *mockReader) GetFileSize(_ context.Context, location, filePath string) (int64, error) {
+	content, err := r.file(location, filePath)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(content)), nil
+}
+func (r *mockReader) Ping(_ context.Context) error { return nil }
+
+func (ts *TestSuite) SetupTest() {
+	viper.Set("log.level", "debug")
+	// Scrub one file at a time to exercise fetching multiple batches
+	scrubconf.SetBatchSize(1)
+	scrubconf.SetRepairQueue("")
+
+	ts.content = bytes.Repeat([]byte("archived content"), 1000)
+	ts.db = &mockDatabase{
+		files: []*database.ScrubFile{
+			{
+				FileID:           "file-1",
+				ArchiveLocation:  "/archive",
+				ArchiveFilePath:  "file-1",
+				ArchiveFileSize:  int64(len(ts.content)),
+				BackupLocation:   "/backup",
+				BackupFilePath:   "file-1",
+				ArchivedChecksum: fmt.Sprintf("%x", sha256.Sum256(ts.content)),
+			},
+			{
+				FileID:           "file-2",
+				ArchiveLocation:  "/archive",
+				ArchiveFilePath:  "file-2",
+				ArchiveFileSize:  int64(len(ts.content)),
+				ArchivedChecksum: fmt.Sprintf("%x", sha256.Sum256(ts.content)),
+			},
+		},
+		scrubbed: make(map[string]string),
+		events:   make(map[string]string),
+	}
+	ts.broker = &mockBroker{}
+	ts.app = Scrub{
+		ArchiveReader: &mockReader{files: map[string][]byte{
+			"/archive/file-1": ts.content,
+			"/archive/file-2": ts.content,
+		}},
+		BackupReader: &mockReader{files: map[string][]byte{
+			"/backup/file-1": ts.content,
+		}},
+		Broker: ts.broker,
+		db:     ts.db,
+	}
+}
+
+func (ts *TestSuite) TestScrubFiles() {
+	ts.NoError(ts.app.scrubFiles(context.TODO()))
+	ts.Equal(map[string]string{"file-1": "", "file-2": ""}, ts.db.scrubbed)
+	ts.Empty(ts.db.events)
+	ts.Empty(ts.broker.alerts)
+}
+
+func (ts *TestSuite) TestScrubFiles_CorruptedArchiveCopy() {
+	corrupted := bytes.Clone(ts.content)
+	corrupted[100] ^= 0xff
+	ts.app.ArchiveReader.(*mockReader).files["/archive/file-1"] = corrupted
+
+	ts.NoError(ts.app.scrubFiles(context.TODO()))
+	ts.Contains(ts.db.scrubbed["file-1"], "archive copy: copy is corrupted: checksum")
+	ts.Empty(ts.db.scrubbed["file-2"])
+	ts.Equal(map[string]string{"file-1": "error"}, ts.db.events)
+	ts.Len(ts.broker.alerts, 1)
+	ts.Equal("file-1", ts.broker.alerts[0].FileID)
+	ts.Equal("archive", ts.broker.alerts[0].Copy)
+	ts.True(ts.broker.alerts[0].Corrupted)
+}
+
+func (ts *TestSuite) TestScrubFiles_RepairCorruptedArchiveCopy() {
+	scrubconf.SetRepairQueue("repair")
+	corrupted := bytes.Clone(ts.content)
+	corrupted[100] ^= 0xff
+	ts.app.ArchiveReader.(*mockReader).files["/archive/file-1"] = corrupted
+	ts.app.ArchiveReader.(*mockReader).files["/archive/file-2"] = corrupted
+
+	ts.NoError(ts.app.scrubFiles(context.TODO()))
+	ts.Equal(map[string]string{"file-1": "error", "file-2": "error"}, ts.db.events)
+	// Only file-1 has a backup copy to be repaired from
+	ts.Equal([]string{"file-1"}, ts.broker.repairs)
+}
+
+func (ts *TestSuite) TestScrubFiles_MissingBackupCopy() {
+	delete(ts.app.BackupReader.(*mockReader).files, "/backup/file-1")
+
+	ts.NoError(ts.app.scrubFiles(context.TODO()))
+	ts.Contains(ts.db.scrubbed["file-1"], "backup copy: copy is corrupted: file not found in location")
+	// The file is still available from the archive
+	ts.Empty(ts.db.events)
+	ts.Len(ts.broker.alerts, 1)
+	ts.Equal("backup", ts.broker.alerts[0].Copy)
+	ts.Equal("/backup", ts.broker.alerts[0].Location)
+	ts.True(ts.broker.alerts[0].Corrupted)
+}
+
+func (ts *TestSuite) TestScrubFiles_TruncatedArchiveCopy() {
+	ts.app.ArchiveReader.(*mockReader).files["/archive/file-2"] = ts.content[:100]
+
+	ts.NoError(ts.app.scrubFiles(context.TODO()))
+	ts.Contains(ts.db.scrubbed["file-2"], "size: 100 does not match archived size: 16000")
+	ts.Equal(map[string]string{"file-2": "error"}, ts.db.events)
+}
+
+func (ts *TestSuite) TestScrubFiles_StorageUnavailable() {
+	ts.app.ArchiveReader.(*mockReader).err = errors.New("connection refused")
+
+	ts.NoError(ts.app.scrubFiles(context.TODO()))
+	ts.Contains(ts.db.scrubbed["file-1"], "connection refused")
+	ts.Contains(ts.db.scrubbed["file-2"], "connection refused")
+	// The copies could not be checked, so the files are not put in an error state
+	ts.Empty(ts.db.events)
+	ts.Len(ts.broker.alerts, 2)
+	ts.False(ts.broker.alerts[0].Corrupted)
+}
+
+func (ts *TestSuite) TestScrubFiles_NoBackupReader() {
+	ts.app.BackupReader = nil
+
+	ts.NoError(ts.app.scrubFiles(context.TODO()))
+	ts.Equal(map[string]string{"file-1": "", "file-2": ""}, ts.db.scrubbed)
+}
+
+func (ts *TestSuite) TestScrubFiles_Interrupted() {
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	ts.app.limiter = rate.NewLimiter(rate.Limit(1), 1)
+
+	ts.ErrorIs(ts.app.scrubFiles(ctx), context.Canceled)
+	ts.Empty(ts.db.scrubbed)
+	ts.Empty(ts.broker.alerts)
+}
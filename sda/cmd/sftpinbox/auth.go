@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/userauth"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+)
+
+// errAuthenticationFailed is returned to the client for any failed login, the reason is only logged
+var errAuthenticationFailed = errors.New("authentication failed")
+
+// cegaCredentials are the credentials of a user as returned by the CEGA users endpoint
+type cegaCredentials struct {
+	PasswordHash  string   `json:"passwordHash"`
+	SSHPublicKeys []string `json:"sshPublicKey"`
+}
+
+type cachedCredentials struct {
+	credentials *cegaCredentials
+	expires     time.Time
+}
+
+// Authenticator authenticates the users of the sftp inbox, either with the password or ssh public keys registered for
+// them at CEGA, or with a token used as password
+type Authenticator struct {
+	cegaURL    string
+	cegaID     string
+	cegaSecret string
+	cacheTTL   time.Duration
+	client     *http.Client
+	// tokenValidator is nil when tokens are not accepted as passwords
+	tokenValidator *userauth.ValidateFromToken
+
+	mu    sync.Mutex
+	cache map[string]cachedCredentials
+}
+
+// NewAuthenticator returns an Authenticator which fetches the credentials of users from the CEGA users endpoint, when
+// cegaURL is set, and accepts tokens validated by tokenValidator as passwords, when tokenValidator is set
+func NewAuthenticator(cegaURL, cegaID, cegaSecret string, cacheTTL time.Duration, tokenValidator *userauth.ValidateFromToken) *Authenticator {
+	return &Authenticator{
+		cegaURL:        strings.TrimSuffix(cegaURL, "/"),
+		cegaID:         cegaID,
+		cegaSecret:     cegaSecret,
+		cacheTTL:       cacheTTL,
+		client:         &http.Client{Timeout: 30 * time.Second},
+		tokenValidator: tokenValidator,
+		cache:          make(map[string]cachedCredentials),
+	}
+}
+
+// serverConfig returns the ssh server configuration which authenticates users with the authenticator
+func (a *Authenticator) serverConfig() *ssh.ServerConfig {
+	conf := &ssh.ServerConfig{PasswordCallback: a.passwordCallback}
+	if a.cegaURL != "" {
+		conf.PublicKeyCallback = a.publicKeyCallback
+	}
+
+	return conf
+}
+
+// passwordCallback accepts a token issued to the user, or the password of the user registered at CEGA. Passwords
+// which look like tokens but fail validation are still checked against CEGA
+func (a *Authenticator) passwordCallback(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	if a.tokenValidator != nil && isToken(password) {
+		token, err := a.tokenValidator.ValidateToken(string(password))
+		switch {
+		case err != nil:
+			log.Debugf("user: %s, from: %s, password is not a valid token: %v", conn.User(), conn.RemoteAddr(), err)
+		case token.Subject() != conn.User():
+			log.Warnf("user: %s, from: %s, failed to login with token issued to: %s", conn.User(), conn.RemoteAddr(), token.Subject())
+
+			return nil, errAuthenticationFailed
+		default:
+			log.Infof("user: %s, from: %s, logged in with token", conn.User(), conn.RemoteAddr())
+
+			return &ssh.Permissions{}, nil
+		}
+	}
+
+	if a.cegaURL == "" {
+		return nil, errAuthenticationFailed
+	}
+	credentials, err := a.credentials(conn.User())
+	if err != nil {
+		log.Errorf("user: %s, from: %s, failed to get credentials: %v", conn.User(), conn.RemoteAddr(), err)
+
+		return nil, errAuthenticationFailed
+	}
+	if credentials == nil || credentials.PasswordHash == "" {
+		log.Warnf("user: %s, from: %s, has no password", conn.User(), conn.RemoteAddr())
+
+		return nil, errAuthenticationFailed
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(credentials.PasswordHash), password); err != nil {
+		log.Warnf("user: %s, from: %s, failed to login with password", conn.User(), conn.RemoteAddr())
+
+		return nil, errAuthenticationFailed
+	}
+	log.Infof("user: %s, from: %s, logged in with password", conn.User(), conn.RemoteAddr())
+
+	return &ssh.Permissions{}, nil
+}
+
+// publicKeyCallback accepts any of the ssh public keys of the user registered at CEGA
+func (a *Authenticator) publicKeyCallback(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	credentials, err := a.credentials(conn.User())
+	if err != nil {
+		log.Errorf("user: %s, from: %s, failed to get credentials: %v", conn.User(), conn.RemoteAddr(), err)
+
+		return nil, errAuthenticationFailed
+	}
+	if credentials == nil {
+		log.Warnf("user: %s, from: %s, is not known", conn.User(), conn.RemoteAddr())
+
+		return nil, errAuthenticationFailed
+	}
+
+	for _, registeredKey := range credentials.SSHPublicKeys {
+		publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(registeredKey))
+		if err != nil {
+			log.Warnf("user: %s, has an invalid ssh public key registered: %v", conn.User(), err)
+
+			continue
+		}
+		if bytes.Equal(publicKey.Marshal(), key.Marshal()) {
+			log.Infof("user: %s, from: %s, logged in with ssh key: %s", conn.User(), conn.RemoteAddr(), ssh.FingerprintSHA256(key))
+
+			return &ssh.Permissions{}, nil
+		}
+	}
+	log.Warnf("user: %s, from: %s, failed to login with ssh key: %s", conn.User(), conn.RemoteAddr(), ssh.FingerprintSHA256(key))
+
+	return nil, errAuthenticationFailed
+}
+
+// credentials returns the credentials of the user registered at CEGA, or nil when the user is not known. Credentials
+// are cached to avoid fetching them for every login attempt
+func (a *Authenticator) credentials(username string) (*cegaCredentials, error) {
+	a.mu.Lock()
+	cached, ok := a.cache[username]
+	a.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.credentials, nil
+	}
+
+	credentials, err := a.fetchCredentials(username)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.cacheTTL > 0 {
+		a.mu.Lock()
+		a.cache[username] = cachedCredentials{credentials: credentials, expires: time.Now().Add(a.cacheTTL)}
+		a.mu.Unlock()
+	}
+
+	return credentials, nil
+}
+
+// fetchCredentials fetches the credentials of the user from the CEGA users endpoint
+func (a *Authenticator) fetchCredentials(username string) (*cegaCredentials, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/%s", a.cegaURL, url.PathEscape(username)), http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(a.cegaID, a.cegaSecret)
+	req.Header.Add("Content-Type", "application/json")
+
+	res, err := a.client.Do(req) // #nosec G704 -- auth url controlled by configuration, username is path escaped
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unexpected response from CEGA: %s", res.Status)
+	}
+
+	var credentials cegaCredentials
+	if err := json.NewDecoder(res.Body).Decode(&credentials); err != nil {
+		return nil, fmt.Errorf("failed to decode response from CEGA: %v", err)
+	}
+
+	return &credentials, nil
+}
+
+// isToken reports whether the password has the form of a JWT, the three base64 encoded parts of a JWS in compact
+// serialization
+func isToken(password []byte) bool {
+	return bytes.Count(password, []byte(".")) == 2 && !bytes.ContainsAny(password, " \t\n")
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/neicnordic/sensitive-data-archive/internal/helper"
+	"github.com/neicnordic/sensitive-data-archive/internal/userauth"
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+)
+
+type AuthTestSuite struct {
+	suite.Suite
+	cega      *httptest.Server
+	requests  int
+	users     map[string]cegaCredentials
+	sshKey    ssh.PublicKey
+	token     string
+	validator *userauth.ValidateFromToken
+}
+
+type mockConnMetadata struct {
+	ssh.ConnMetadata
+	user string
+}
+
+func (m mockConnMetadata) User() string {
+	return m.user
+}
+
+func (m mockConnMetadata) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000}
+}
+
+func TestAuthTestSuite(t *testing.T) {
+	suite.Run(t, new(AuthTestSuite))
+}
+
+func (ts *AuthTestSuite) SetupSuite() {
+	keyDir := ts.T().TempDir()
+	prKeyPath, pubKeyPath, err := helper.MakeFolder(keyDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(helper.CreateRSAkeys(prKeyPath, pubKeyPath))
+	prKey, err := helper.ParsePrivateRSAKey(prKeyPath, "/rsa")
+	ts.Require().NoError(err)
+
+	claims := map[string]any{
+		"iss": "https://dummy.ega.nbis.se",
+		"sub": "token-user",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	ts.token, err = helper.CreateRSAToken(prKey, "RS256", claims)
+	ts.Require().NoError(err)
+
+	ts.validator = userauth.NewValidateFromToken(jwk.NewSet())
+	ts.Require().NoError(ts.validator.ReadJwtPubKeyPath(filepath.Join(keyDir, "public-key")))
+
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	ts.Require().NoError(err)
+	ts.sshKey, err = ssh.NewPublicKey(publicKey)
+	ts.Require().NoError(err)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	ts.Require().NoError(err)
+	ts.users = map[string]cegaCredentials{
+		"user": {
+			PasswordHash:  string(hash),
+			SSHPublicKeys: []string{string(ssh.MarshalAuthorizedKey(ts.sshKey))},
+		},
+	}
+
+	ts.cega = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts.requests++
+		if id, secret, ok := r.BasicAuth(); !ok || id != "id" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+		credentials, ok := ts.users[filepath.Base(r.URL.Path)]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+		_ = json.NewEncoder(w).Encode(credentials)
+	}))
+}
+
+func (ts *AuthTestSuite) TearDownSuite() {
+	ts.cega.Close()
+}
+
+func (ts *AuthTestSuite) SetupTest() {
+	ts.requests = 0
+}
+
+func (ts *AuthTestSuite) TestPassword() {
+	auth := NewAuthenticator(ts.cega.URL, "id", "secret", time.Minute, nil)
+
+	_, err := auth.passwordCallback(mockConnMetadata{user: "user"}, []byte("password"))
+	ts.NoError(err)
+	_, err = auth.passwordCallback(mockConnMetadata{user: "user"}, []byte("wrong"))
+	ts.ErrorIs(err, errAuthenticationFailed)
+	_, err = auth.passwordCallback(mockConnMetadata{user: "unknown"}, []byte("password"))
+	ts.ErrorIs(err, errAuthenticationFailed)
+
+	// The credentials of known and unknown users are cached
+	ts.Equal(2, ts.requests)
+}
+
+func (ts *AuthTestSuite) TestPassword_cegaFailure() {
+	auth := NewAuthenticator(ts.cega.URL, "id", "wrong", time.Minute, nil)
+
+	_, err := auth.passwordCallback(mockConnMetadata{user: "user"}, []byte("password"))
+	ts.ErrorIs(err, errAuthenticationFailed)
+}
+
+func (ts *AuthTestSuite) TestPassword_token() {
+	auth := NewAuthenticator("", "", "", time.Minute, ts.validator)
+
+	_, err := auth.passwordCallback(mockConnMetadata{user: "token-user"}, []byte(ts.token))
+	ts.NoError(err)
+	_, err = auth.passwordCallback(mockConnMetadata{user: "user"}, []byte(ts.token))
+	ts.ErrorIs(err, errAuthenticationFailed)
+	_, err = auth.passwordCallback(mockConnMetadata{user: "token-user"}, []byte("not.a.token"))
+	ts.ErrorIs(err, errAuthenticationFailed)
+
+	ts.Nil(auth.serverConfig().PublicKeyCallback)
+}
+
+func (ts *AuthTestSuite) TestPassword_tokenFallback() {
+	auth := NewAuthenticator(ts.cega.URL, "id", "secret", time.Minute, ts.validator)
+
+	// Passwords which are not valid tokens are checked against CEGA
+	hash, err := bcrypt.GenerateFromPassword([]byte("pass.word.dots"), bcrypt.MinCost)
+	ts.Require().NoError(err)
+	ts.users["dotted"] = cegaCredentials{PasswordHash: string(hash)}
+	defer delete(ts.users, "dotted")
+
+	_, err = auth.passwordCallback(mockConnMetadata{user: "dotted"}, []byte("pass.word.dots"))
+	ts.NoError(err)
+}
+
+func (ts *AuthTestSuite) TestPublicKey() {
+	auth := NewAuthenticator(ts.cega.URL, "id", "secret", time.Minute, nil)
+	ts.NotNil(auth.serverConfig().PublicKeyCallback)
+
+	_, err := auth.publicKeyCallback(mockConnMetadata{user: "user"}, ts.sshKey)
+	ts.NoError(err)
+
+	otherKey, _, err := ed25519.GenerateKey(rand.Reader)
+	ts.Require().NoError(err)
+	other, err := ssh.NewPublicKey(otherKey)
+	ts.Require().NoError(err)
+	_, err = auth.publicKeyCallback(mockConnMetadata{user: "user"}, other)
+	ts.ErrorIs(err, errAuthenticationFailed)
+	_, err = auth.publicKeyCallback(mockConnMetadata{user: "unknown"}, ts.sshKey)
+	ts.ErrorIs(err, errAuthenticationFailed)
+}
+
+func (ts *AuthTestSuite) TestReadHostKey() {
+	keyPath := filepath.Join(ts.T().TempDir(), "host_key")
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	ts.Require().NoError(err)
+	pemBlock, err := ssh.MarshalPrivateKey(privateKey, "")
+	ts.Require().NoError(err)
+	ts.Require().NoError(os.WriteFile(keyPath, pem.EncodeToMemory(pemBlock), 0o600))
+
+	signer, err := readHostKey(keyPath)
+	ts.NoError(err)
+	ts.Equal(ssh.KeyAlgoED25519, signer.PublicKey().Type())
+
+	_, err = readHostKey(filepath.Join(ts.T().TempDir(), "missing"))
+	ts.Error(err)
+}
@@ -0,0 +1,245 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	config "github.com/neicnordic/sensitive-data-archive/internal/config/v2"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var (
+	host          string
+	port          int
+	hostKeyPath   string
+	inboxQueue    string
+	schemaPath    string
+	cegaAuthURL   string
+	cegaID        string
+	cegaSecret    string
+	cegaCacheTTL  time.Duration
+	jwtPubKeyPath string
+	jwtPubKeyURL  string
+	reencryptHost string
+	quotaBytes    int64
+	quotaFiles    int64
+)
+
+func init() {
+	config.RegisterFlags(
+		&config.Flag{
+			Name: "sftp.host",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "Address the sftp inbox listens on, leave empty to listen on all interfaces")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				host = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "sftp.port",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Int(flagName, 2222, "Port the sftp inbox listens on")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				port = viper.GetInt(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "sftp.hostKeyPath",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "Path to the private ssh host key of the sftp inbox")
+			},
+			Required: true,
+			AssignFunc: func(flagName string) {
+				hostKeyPath = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "inboxQueue",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "inbox", "The queue where the sftp inbox publishes inbox messages to")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				inboxQueue = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "schemaType",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "isolated", "Path to JSON schemas to validate rabbitmq messages against")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				schemaType := viper.GetString("schemaType")
+				switch schemaType {
+				case "federated":
+					schemaPath = "/schemas/federated/"
+				case "isolated":
+					schemaPath = "/schemas/isolated/"
+				default:
+					panic(fmt.Sprintf("schema.type '%s' not supported, needs: <federated|isolated>", schemaType))
+				}
+			},
+		},
+		&config.Flag{
+			Name: "cega.authUrl",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "URL of the CEGA users endpoint which the credentials of users are fetched from, leave empty to only authenticate users with tokens")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				cegaAuthURL = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "cega.id",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "Username for connecting to the CEGA users endpoint")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				cegaID = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "cega.secret",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "Password for connecting to the CEGA users endpoint")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				cegaSecret = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "cega.cacheTTL",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Duration(flagName, 5*time.Minute, "How long the credentials of a user fetched from CEGA are cached, 0 fetches the credentials on every login. Expects a go time.Duration parsable string")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				cegaCacheTTL = viper.GetDuration(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "jwt.pubKeyPath",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "Path to a directory with the public keys that tokens used as passwords are validated against")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				jwtPubKeyPath = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "jwt.pubKeyUrl",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "URL of the JWKS that tokens used as passwords are validated against")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				jwtPubKeyURL = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "grpc.host",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "Host of the reencrypt service which validates the crypt4gh headers of uploaded files, headers are not validated when empty")
+			},
+			Required: false,
+			// The other grpc settings are read by config.GetReEncryptClientConfig
+			AssignFunc: func(flagName string) {
+				reencryptHost = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "sftp.quotaBytes",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Int64(flagName, 0, "Default maximum total size in bytes of the files of a user in the inbox, 0 means no limit")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				quotaBytes = viper.GetInt64(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "sftp.quotaFiles",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Int64(flagName, 0, "Default maximum amount of files of a user in the inbox, 0 means no limit")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				quotaFiles = viper.GetInt64(flagName)
+			},
+		},
+	)
+}
+
+func Host() string {
+	return host
+}
+
+func Port() int {
+	return port
+}
+
+func HostKeyPath() string {
+	return hostKeyPath
+}
+
+func InboxQueue() string {
+	return inboxQueue
+}
+
+func SetInboxQueue(queue string) {
+	inboxQueue = queue
+}
+
+func SchemaPath() string {
+	return schemaPath
+}
+
+func SetSchemaPath(path string) {
+	schemaPath = path
+}
+
+func CegaAuthURL() string {
+	return cegaAuthURL
+}
+
+func CegaID() string {
+	return cegaID
+}
+
+func CegaSecret() string {
+	return cegaSecret
+}
+
+func CegaCacheTTL() time.Duration {
+	return cegaCacheTTL
+}
+
+func JwtPubKeyPath() string {
+	return jwtPubKeyPath
+}
+
+func JwtPubKeyURL() string {
+	return jwtPubKeyURL
+}
+
+func ReencryptHost() string {
+	return reencryptHost
+}
+
+func QuotaBytes() int64 {
+	return quotaBytes
+}
+
+func QuotaFiles() int64 {
+	return quotaFiles
+}
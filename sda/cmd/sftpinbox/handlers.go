@@ -0,0 +1,626 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	sftpinboxconf "github.com/neicnordic/sensitive-data-archive/cmd/sftpinbox/config"
+	brokerv2 "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/helper"
+	"github.com/neicnordic/sensitive-data-archive/internal/inboxcheck"
+	"github.com/neicnordic/sensitive-data-archive/internal/schema"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	"github.com/pkg/sftp"
+	log "github.com/sirupsen/logrus"
+)
+
+// errInternal is returned to the client when a request fails for reasons other than the request itself, the reason is
+// only logged
+var errInternal = errors.New("internal error")
+
+// inbox serves the sftp requests of a user. Users only see the files in their own inbox, as registered in the
+// database, directories only exist as the directories of those files or when created during the session
+type inbox struct {
+	app  *SftpInbox
+	user string
+
+	mu sync.Mutex
+	// dirs are the directories created by the user during the session which do not contain any files
+	dirs map[string]bool
+}
+
+// handlers returns the sftp handlers serving the inbox of the user
+func (app *SftpInbox) handlers(username string) sftp.Handlers {
+	i := &inbox{app: app, user: username, dirs: make(map[string]bool)}
+
+	return sftp.Handlers{FileGet: i, FilePut: i, FileCmd: i, FileList: i}
+}
+
+// inboxPath returns the path of the requested file relative to the inbox of the user, as stored in the database
+func inboxPath(requestPath string) (string, error) {
+	filePath := strings.TrimPrefix(path.Clean("/"+requestPath), "/")
+	if filePath == "" {
+		return "", sftp.ErrSSHFxPermissionDenied
+	}
+	filePath, err := helper.FormatUploadFilePath(filePath)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", sftp.ErrSSHFxPermissionDenied, err)
+	}
+
+	return filePath, nil
+}
+
+// Fileread opens a file in the inbox of the user for reading
+func (i *inbox) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	filePath, err := inboxPath(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	file, err := i.inboxFile(r.Context(), filePath)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		return nil, os.ErrNotExist
+	}
+
+	location, err := i.app.db.GetSubmissionLocation(r.Context(), file.FileID)
+	if err != nil {
+		log.Errorf("user: %s, failed to get submission location of file: %s, due to: %v", i.user, file.FileID, err)
+
+		return nil, errInternal
+	}
+	reader, err := i.app.InboxReader.NewFileReadSeeker(r.Context(), location, helper.UnanonymizeFilepath(filePath, i.user))
+	if err != nil {
+		log.Errorf("user: %s, failed to open file: %s for reading, due to: %v", i.user, filePath, err)
+
+		return nil, errInternal
+	}
+
+	return &fileReader{reader: reader}, nil
+}
+
+// Filewrite opens a file in the inbox of the user for writing, files can only be written from start to end
+func (i *inbox) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	filePath, err := inboxPath(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	if r.Pflags().Append {
+		return nil, sftp.ErrSSHFxOpUnsupported
+	}
+
+	// The file replaced by the upload is left out of the usage of the user
+	fileID, err := i.app.db.GetFileIDInInbox(r.Context(), i.user, filePath)
+	if err != nil {
+		log.Errorf("user: %s, failed to get file id of file: %s from database, due to: %v", i.user, filePath, err)
+
+		return nil, errInternal
+	}
+	maxSize, err := inboxcheck.RemainingQuota(r.Context(), i.app.db, i.app.defaultQuota, i.user, fileID)
+	switch {
+	case errors.Is(err, inboxcheck.ErrQuotaExceeded):
+		log.Warnf("user: %s, rejected upload of file: %s, due to: %v", i.user, filePath, err)
+
+		return nil, errQuotaExceeded
+	case err != nil:
+		log.Errorf("user: %s, failed to check quota, due to: %v", i.user, err)
+
+		return nil, errInternal
+	}
+
+	return i.newUpload(r.Context(), filePath, maxSize), nil
+}
+
+// Filecmd handles the requests which modify the inbox other than writing files
+func (i *inbox) Filecmd(r *sftp.Request) error {
+	switch r.Method {
+	case "Setstat":
+		// Attributes of files in the inbox can not be changed, ignoring them lets clients which preserve attributes
+		// upload files
+		return nil
+	case "Mkdir":
+		dirPath, err := inboxPath(r.Filepath)
+		if err != nil {
+			return err
+		}
+		i.mu.Lock()
+		i.dirs[dirPath] = true
+		i.mu.Unlock()
+
+		return nil
+	case "Rmdir":
+		return i.rmdir(r.Context(), r.Filepath)
+	case "Remove":
+		return i.remove(r.Context(), r.Filepath)
+	case "Rename", "PosixRename":
+		return i.rename(r.Context(), r.Filepath, r.Target)
+	default:
+		return sftp.ErrSSHFxOpUnsupported
+	}
+}
+
+// Filelist lists directories and stats files in the inbox of the user
+func (i *inbox) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	switch r.Method {
+	case "List":
+		return i.list(r.Context(), r.Filepath)
+	case "Stat":
+		info, err := i.stat(r.Context(), r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+
+		return listerAt{info}, nil
+	default:
+		return nil, sftp.ErrSSHFxOpUnsupported
+	}
+}
+
+// inboxFile returns the file at the path in the inbox of the user, or nil if there is none. A file which is being
+// ingested can share its path with a new upload to the inbox, the file which is still in the inbox takes precedence
+func (i *inbox) inboxFile(ctx context.Context, filePath string) (*database.SubmissionFileInfo, error) {
+	files, _, err := i.app.db.GetUserFiles(ctx, i.user, filePath, false, 0, "")
+	if err != nil {
+		log.Errorf("user: %s, failed to get files from database, due to: %v", i.user, err)
+
+		return nil, errInternal
+	}
+
+	var found *database.SubmissionFileInfo
+	for _, file := range files {
+		if file.InboxPath != filePath {
+			continue
+		}
+		if file.Status == "registered" || file.Status == "uploaded" {
+			return file, nil
+		}
+		if found == nil {
+			found = file
+		}
+	}
+
+	return found, nil
+}
+
+// list returns the files and directories in the directory of the inbox of the user
+func (i *inbox) list(ctx context.Context, requestPath string) (sftp.ListerAt, error) {
+	dirPath := strings.TrimPrefix(path.Clean("/"+requestPath), "/")
+	prefix := ""
+	if dirPath != "" {
+		prefix = dirPath + "/"
+	}
+
+	files, _, err := i.app.db.GetUserFiles(ctx, i.user, prefix, false, 0, "")
+	if err != nil {
+		log.Errorf("user: %s, failed to get files from database, due to: %v", i.user, err)
+
+		return nil, errInternal
+	}
+
+	entries := make(map[string]os.FileInfo)
+	for _, file := range files {
+		name, rest, inSubDir := strings.Cut(strings.TrimPrefix(file.InboxPath, prefix), "/")
+		switch {
+		case name == "" || (inSubDir && rest == ""):
+			continue
+		case inSubDir:
+			if _, ok := entries[name]; !ok {
+				entries[name] = dirInfo(name)
+			}
+		default:
+			entries[name] = newFileInfo(file)
+		}
+	}
+	i.mu.Lock()
+	for dir := range i.dirs {
+		if !strings.HasPrefix(dir, prefix) {
+			continue
+		}
+		name, _, _ := strings.Cut(strings.TrimPrefix(dir, prefix), "/")
+		if _, ok := entries[name]; !ok {
+			entries[name] = dirInfo(name)
+		}
+	}
+	i.mu.Unlock()
+
+	if len(entries) == 0 && dirPath != "" {
+		if _, err := i.stat(ctx, requestPath); err != nil {
+			return nil, err
+		}
+	}
+
+	list := make(listerAt, 0, len(entries))
+	for _, entry := range entries {
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].Name() < list[b].Name() })
+
+	return list, nil
+}
+
+// stat returns the file or directory at the path in the inbox of the user
+func (i *inbox) stat(ctx context.Context, requestPath string) (os.FileInfo, error) {
+	filePath := strings.TrimPrefix(path.Clean("/"+requestPath), "/")
+	if filePath == "" {
+		return dirInfo("/"), nil
+	}
+
+	file, err := i.inboxFile(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if file != nil {
+		return newFileInfo(file), nil
+	}
+
+	i.mu.Lock()
+	created := i.dirs[filePath]
+	i.mu.Unlock()
+	if created {
+		return dirInfo(path.Base(filePath)), nil
+	}
+	files, _, err := i.app.db.GetUserFiles(ctx, i.user, filePath+"/", false, 1, "")
+	if err != nil {
+		log.Errorf("user: %s, failed to get files from database, due to: %v", i.user, err)
+
+		return nil, errInternal
+	}
+	if len(files) > 0 {
+		return dirInfo(path.Base(filePath)), nil
+	}
+
+	return nil, os.ErrNotExist
+}
+
+// rmdir removes an empty directory created during the session, directories containing files only exist through the
+// files in them and are removed with their last file
+func (i *inbox) rmdir(ctx context.Context, requestPath string) error {
+	dirPath, err := inboxPath(requestPath)
+	if err != nil {
+		return err
+	}
+	info, err := i.stat(ctx, dirPath)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	files, _, err := i.app.db.GetUserFiles(ctx, i.user, dirPath+"/", false, 1, "")
+	if err != nil {
+		log.Errorf("user: %s, failed to get files from database, due to: %v", i.user, err)
+
+		return errInternal
+	}
+	if len(files) > 0 {
+		return sftp.ErrSSHFxFailure
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for dir := range i.dirs {
+		if strings.HasPrefix(dir, dirPath+"/") {
+			return sftp.ErrSSHFxFailure
+		}
+	}
+	delete(i.dirs, dirPath)
+
+	return nil
+}
+
+// remove removes the file from the inbox of the user, and publishes the inbox-remove message
+func (i *inbox) remove(ctx context.Context, requestPath string) error {
+	filePath, err := inboxPath(requestPath)
+	if err != nil {
+		return err
+	}
+	file, err := i.inboxFile(ctx, filePath)
+	if err != nil {
+		return err
+	}
+	if file == nil {
+		return os.ErrNotExist
+	}
+	if file.Status != "registered" && file.Status != "uploaded" {
+		log.Warnf("user: %s, can not remove file: %s, with status: %s", i.user, filePath, file.Status)
+
+		return sftp.ErrSSHFxPermissionDenied
+	}
+
+	jsonMessage, err := json.Marshal(schema.InboxRemove{
+		User:      i.user,
+		FilePath:  helper.UnanonymizeFilepath(filePath, i.user),
+		Operation: "remove",
+	})
+	if err != nil {
+		log.Errorf("failed to marshal message to json, due to: %v", err)
+
+		return errInternal
+	}
+
+	if err := i.app.removeFile(ctx, i.user, file.FileID, filePath); err != nil {
+		log.Errorf("user: %s, failed to remove file: %s, due to: %v", i.user, filePath, err)
+
+		return errInternal
+	}
+	if err := i.app.publish(ctx, file.FileID, "inbox-remove.json", jsonMessage); err != nil {
+		log.Errorf("user: %s, failed to publish message for removed file: %s, due to: %v", i.user, filePath, err)
+
+		return errInternal
+	}
+	if err := i.app.db.CancelFile(ctx, file.FileID, string(jsonMessage)); err != nil {
+		log.Errorf("user: %s, failed to cancel file: %s in database, due to: %v", i.user, file.FileID, err)
+
+		return errInternal
+	}
+	log.Infof("user: %s, removed file: %s, with id: %s", i.user, filePath, file.FileID)
+
+	return nil
+}
+
+// rename moves the file within the inbox of the user, and publishes the inbox-rename message. Storage backends can
+// not rename files, so the file is copied to the new path and registered as a new file before the old one is removed
+func (i *inbox) rename(ctx context.Context, requestPath, targetPath string) error {
+	oldPath, err := inboxPath(requestPath)
+	if err != nil {
+		return err
+	}
+	newPath, err := inboxPath(targetPath)
+	if err != nil {
+		return err
+	}
+	file, err := i.inboxFile(ctx, oldPath)
+	if err != nil {
+		return err
+	}
+	if file == nil {
+		// Renaming a directory would require renaming every file in it
+		if _, err := i.stat(ctx, requestPath); err == nil {
+			return sftp.ErrSSHFxOpUnsupported
+		}
+
+		return os.ErrNotExist
+	}
+	if file.Status != "uploaded" {
+		log.Warnf("user: %s, can not rename file: %s, with status: %s", i.user, oldPath, file.Status)
+
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	existing, err := i.inboxFile(ctx, newPath)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		// Files in the inbox are not overwritten by renames, as the previous version of the file would be lost
+		return sftp.ErrSSHFxFailure
+	}
+
+	location, err := i.app.db.GetSubmissionLocation(ctx, file.FileID)
+	if err != nil {
+		log.Errorf("user: %s, failed to get submission location of file: %s, due to: %v", i.user, file.FileID, err)
+
+		return errInternal
+	}
+	reader, err := i.app.InboxReader.NewFileReader(ctx, location, helper.UnanonymizeFilepath(oldPath, i.user))
+	if err != nil {
+		log.Errorf("user: %s, failed to open file: %s for reading, due to: %v", i.user, oldPath, err)
+
+		return errInternal
+	}
+	defer reader.Close()
+
+	hashes := newUploadHashes()
+	newLocation, err := i.app.InboxWriter.WriteFile(ctx, helper.UnanonymizeFilepath(newPath, i.user), io.TeeReader(reader, hashes))
+	if err != nil {
+		log.Errorf("user: %s, failed to copy file: %s to: %s, due to: %v", i.user, oldPath, newPath, err)
+
+		return errInternal
+	}
+
+	if err := i.registerRename(ctx, file.FileID, oldPath, newPath, newLocation, hashes); err != nil {
+		log.Errorf("user: %s, failed to register rename of file: %s to: %s, due to: %v", i.user, oldPath, newPath, err)
+
+		return errInternal
+	}
+
+	return nil
+}
+
+// registerRename registers the copy of the file at the new path as uploaded and cancels the file at the old path,
+// announcing both with the inbox-rename message
+func (i *inbox) registerRename(ctx context.Context, oldFileID, oldPath, newPath, location string, hashes *uploadHashes) error {
+	fileID, err := i.app.registerFile(ctx, i.user, newPath, location)
+	if err != nil {
+		return err
+	}
+
+	jsonMessage, err := json.Marshal(schema.InboxRename{
+		User:      i.user,
+		FilePath:  helper.UnanonymizeFilepath(newPath, i.user),
+		OldPath:   helper.UnanonymizeFilepath(oldPath, i.user),
+		Operation: "rename",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal message to json: %v", err)
+	}
+	details, err := json.Marshal(map[string]string{"renamed_from": oldPath})
+	if err != nil {
+		return fmt.Errorf("failed to marshal event details to json: %v", err)
+	}
+
+	if err := i.app.db.SetSubmissionFileSize(ctx, fileID, hashes.size); err != nil {
+		return fmt.Errorf("failed to set submission file size: %v", err)
+	}
+	for _, checksum := range hashes.checksums() {
+		if err := i.app.db.AddUploadedChecksum(ctx, fileID, checksum.Value, checksum.Type); err != nil {
+			return fmt.Errorf("failed to store uploaded checksum: %v", err)
+		}
+	}
+	if err := i.app.db.UpdateFileEventLog(ctx, fileID, "uploaded", "inbox", string(details), string(jsonMessage)); err != nil {
+		return fmt.Errorf("failed to set file as uploaded: %v", err)
+	}
+
+	if err := i.app.removeFile(ctx, i.user, oldFileID, oldPath); err != nil {
+		return err
+	}
+	if err := i.app.publish(ctx, fileID, "inbox-rename.json", jsonMessage); err != nil {
+		return err
+	}
+	if err := i.app.db.CancelFile(ctx, oldFileID, string(jsonMessage)); err != nil {
+		return fmt.Errorf("failed to cancel file: %s, due to: %v", oldFileID, err)
+	}
+	log.Infof("user: %s, renamed file: %s, with id: %s, to: %s, with id: %s", i.user, oldPath, oldFileID, newPath, fileID)
+
+	return nil
+}
+
+// registerFile returns the id of the file at the path in the inbox of the user, registering the file when it is new or
+// its submission location has changed
+func (app *SftpInbox) registerFile(ctx context.Context, username, filePath, location string) (string, error) {
+	fileID, err := app.db.GetFileIDInInbox(ctx, username, filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to check/get existing file id from database: %v", err)
+	}
+	if fileID != "" {
+		registeredLocation, err := app.db.GetSubmissionLocation(ctx, fileID)
+		if err != nil {
+			return "", fmt.Errorf("failed to get submission location of file: %s, due to: %v", fileID, err)
+		}
+		if registeredLocation == location {
+			return fileID, nil
+		}
+	}
+
+	tx, err := app.db.BeginTransaction(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction, reason: %v", err)
+	}
+	var existingID *string
+	if fileID != "" {
+		existingID = &fileID
+	}
+	fileID, err = tx.RegisterFile(ctx, existingID, location, filePath, username)
+	if err != nil {
+		if err := tx.Rollback(); err != nil {
+			log.Errorf("failed to rollback RegisterFile transaction, reason: %v", err)
+		}
+
+		return "", fmt.Errorf("failed to register file in database: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		_ = tx.Rollback()
+
+		return "", fmt.Errorf("failed to commit RegisterFile transaction, reason: %v", err)
+	}
+
+	return fileID, nil
+}
+
+// removeFile removes the file from the inbox storage, a file which is already gone is considered removed
+func (app *SftpInbox) removeFile(ctx context.Context, username, fileID, filePath string) error {
+	location, err := app.db.GetSubmissionLocation(ctx, fileID)
+	if err != nil {
+		return fmt.Errorf("failed to get submission location of file: %s, due to: %v", fileID, err)
+	}
+	err = app.InboxWriter.RemoveFile(ctx, location, helper.UnanonymizeFilepath(filePath, username))
+	if err != nil && !errors.Is(err, storageerrors.ErrorFileNotFoundInLocation) {
+		return fmt.Errorf("failed to remove file from inbox storage: %v", err)
+	}
+
+	return nil
+}
+
+// publish validates the inbox message against the schema and publishes it to the inbox queue
+func (app *SftpInbox) publish(ctx context.Context, fileID, schemaName string, jsonMessage []byte) error {
+	if err := schema.ValidateJSON(fmt.Sprintf("%s/%s", sftpinboxconf.SchemaPath(), schemaName), jsonMessage); err != nil {
+		return fmt.Errorf("failed to validate message, due to: %v", err)
+	}
+	if err := app.Broker.Publish(ctx, sftpinboxconf.InboxQueue(), brokerv2.Message{Key: fileID, Body: jsonMessage}); err != nil {
+		return fmt.Errorf("failed to publish message, due to: %v", err)
+	}
+
+	return nil
+}
+
+// fileReader reads a file in the inbox at the offsets requested by the client
+type fileReader struct {
+	mu     sync.Mutex
+	reader io.ReadSeekCloser
+}
+
+func (f *fileReader) ReadAt(p []byte, offset int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, err := f.reader.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(f.reader, p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+func (f *fileReader) Close() error {
+	return f.reader.Close()
+}
+
+// fileInfo describes a file or directory in the inbox
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func newFileInfo(file *database.SubmissionFileInfo) os.FileInfo {
+	modTime, _ := time.Parse(time.RFC3339Nano, file.CreatedAt)
+
+	return &fileInfo{name: path.Base(file.InboxPath), size: file.SubmissionFileSize, modTime: modTime}
+}
+
+func dirInfo(name string) os.FileInfo {
+	return &fileInfo{name: name, dir: true}
+}
+
+func (f *fileInfo) Name() string       { return f.name }
+func (f *fileInfo) Size() int64        { return f.size }
+func (f *fileInfo) ModTime() time.Time { return f.modTime }
+func (f *fileInfo) IsDir() bool        { return f.dir }
+func (f *fileInfo) Sys() any           { return nil }
+
+func (f *fileInfo) Mode() os.FileMode {
+	if f.dir {
+		return os.ModeDir | 0o750
+	}
+
+	return 0o640
+}
+
+// listerAt lists the entries of a directory, or the file of a stat request
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(list []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(list, l[offset:])
+	if n < len(list) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
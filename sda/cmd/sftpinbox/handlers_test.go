@@ -0,0 +1,619 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" // #nosec G501
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/neicnordic/crypt4gh/keys"
+	"github.com/neicnordic/crypt4gh/model/headers"
+	"github.com/neicnordic/crypt4gh/streaming"
+	sftpinboxconf "github.com/neicnordic/sensitive-data-archive/cmd/sftpinbox/config"
+	brokerv2 "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/reencrypt"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+type TestSuite struct {
+	suite.Suite
+	db      *mockDatabase
+	broker  *mockBroker
+	storage *mockStorage
+	app     *SftpInbox
+	client  *sftp.Client
+}
+
+func TestSftpInboxTestSuite(t *testing.T) {
+	suite.Run(t, new(TestSuite))
+}
+
+type mockFile struct {
+	id        string
+	user      string
+	path      string
+	location  string
+	status    string
+	size      int64
+	checksums map[string]string
+	details   string
+}
+
+// mockDatabase implements the database functions used by the sftp inbox, calling any other function panics
+type mockDatabase struct {
+	database.Database
+	mu        sync.Mutex
+	files     []*mockFile
+	quotas    map[string]*database.InboxQuota
+	keyHashes []*database.C4ghKeyHash
+}
+
+func (m *mockDatabase) file(fileID string) *mockFile {
+	for _, file := range m.files {
+		if file.id == fileID {
+			return file
+		}
+	}
+
+	return nil
+}
+
+func (m *mockDatabase) GetUserFiles(_ context.Context, userID, pathPrefix string, _ bool, limit int, _ string) ([]*database.SubmissionFileInfo, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var files []*database.SubmissionFileInfo
+	for _, file := range m.files {
+		if file.user == userID && file.status != "disabled" && strings.HasPrefix(file.path, pathPrefix) {
+			files = append(files, &database.SubmissionFileInfo{
+				FileID:             file.id,
+				InboxPath:          file.path,
+				Status:             file.status,
+				SubmissionFileSize: file.size,
+				CreatedAt:          "2024-01-01T00:00:00Z",
+			})
+		}
+	}
+	if limit > 0 && len(files) > limit {
+		files = files[:limit]
+	}
+
+	return files, "", nil
+}
+
+func (m *mockDatabase) GetFileIDInInbox(_ context.Context, submissionUser, filePath string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, file := range m.files {
+		if file.user == submissionUser && file.path == filePath && (file.status == "registered" || file.status == "uploaded" || file.status == "disabled") {
+			return file.id, nil
+		}
+	}
+
+	return "", nil
+}
+
+func (m *mockDatabase) GetSubmissionLocation(_ context.Context, fileID string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.file(fileID).location, nil
+}
+
+func (m *mockDatabase) BeginTransaction(_ context.Context) (database.Transaction, error) {
+	return &mockTransaction{db: m}, nil
+}
+
+func (m *mockDatabase) SetSubmissionFileSize(_ context.Context, fileID string, submissionFileSize int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.file(fileID).size = submissionFileSize
+
+	return nil
+}
+
+func (m *mockDatabase) AddUploadedChecksum(_ context.Context, fileID, checksum, algorithm string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.file(fileID).checksums[algorithm] = checksum
+
+	return nil
+}
+
+func (m *mockDatabase) UpdateFileEventLog(_ context.Context, fileID, event, _, details, _ string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.file(fileID).status = event
+	m.file(fileID).details = details
+
+	return nil
+}
+
+func (m *mockDatabase) CancelFile(_ context.Context, fileID string, _ string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.file(fileID).status = "disabled"
+
+	return nil
+}
+
+func (m *mockDatabase) GetInboxQuota(_ context.Context, submissionUser string) (*database.InboxQuota, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.quotas[submissionUser], nil
+}
+
+func (m *mockDatabase) GetInboxUsage(_ context.Context, submissionUser, excludedFileID string) (*database.InboxUsage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	usage := &database.InboxUsage{}
+	for _, file := range m.files {
+		if file.user == submissionUser && file.status != "disabled" && file.id != excludedFileID {
+			usage.Bytes += file.size
+			usage.Files++
+		}
+	}
+
+	return usage, nil
+}
+
+func (m *mockDatabase) ListKeyHashes(_ context.Context) ([]*database.C4ghKeyHash, error) {
+	return m.keyHashes, nil
+}
+
+type mockTransaction struct {
+	database.Transaction
+	db *mockDatabase
+}
+
+func (m *mockTransaction) RegisterFile(_ context.Context, fileID *string, inboxLocation, uploadPath, uploadUser string) (string, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	if fileID != nil {
+		file := m.db.file(*fileID)
+		file.location = inboxLocation
+		file.status = "registered"
+
+		return file.id, nil
+	}
+	file := &mockFile{
+		id:        fmt.Sprintf("file-%d", len(m.db.files)+1),
+		user:      uploadUser,
+		path:      uploadPath,
+		location:  inboxLocation,
+		status:    "registered",
+		checksums: make(map[string]string),
+	}
+	m.db.files = append(m.db.files, file)
+
+	return file.id, nil
+}
+
+func (m *mockTransaction) Commit() error {
+	return nil
+}
+
+func (m *mockTransaction) Rollback() error {
+	return nil
+}
+
+type mockBroker struct {
+	brokerv2.Broker
+	mu       sync.Mutex
+	messages []map[string]any
+}
+
+func (m *mockBroker) Publish(_ context.Context, destinationQueue string, message brokerv2.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if destinationQueue != "inbox" {
+		return fmt.Errorf("unexpected queue: %s", destinationQueue)
+	}
+	var body map[string]any
+	if err := json.Unmarshal(message.Body, &body); err != nil {
+		return err
+	}
+	body["key"] = message.Key
+	m.messages = append(m.messages, body)
+
+	return nil
+}
+
+// mockStorage is an inbox storage keeping files in memory, files are stored by location and file path
+type mockStorage struct {
+	storage.Reader
+	mu       sync.Mutex
+	location string
+	files    map[string][]byte
+	err      error
+}
+
+func (s *mockStorage) WriteFile(_ context.Context, filePath string, fileContent io.Reader) (string, error) {
+	if s.err != nil {
+		return "", s.err
+	}
+	content, err := io.ReadAll(fileContent)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[s.location+"/"+filePath] = content
+
+	return s.location, nil
+}
+
+func (s *mockStorage) RemoveFile(_ context.Context, location, filePath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.files[location+"/"+filePath]; !ok {
+		return storageerrors.ErrorFileNotFoundInLocation
+	}
+	delete(s.files, location+"/"+filePath)
+
+	return nil
+}
+
+func (s *mockStorage) NewFileReader(ctx context.Context, location, filePath string) (io.ReadCloser, error) {
+	return s.NewFileReadSeeker(ctx, location, filePath)
+}
+
+func (s *mockStorage) NewFileReadSeeker(_ context.Context, location, filePath string) (io.ReadSeekCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	content, ok := s.files[location+"/"+filePath]
+	if !ok {
+		return nil, storageerrors.ErrorFileNotFoundInLocation
+	}
+
+	return struct {
+		io.ReadSeeker
+		io.Closer
+	}{bytes.NewReader(content), io.NopCloser(nil)}, nil
+}
+
+// mockReencryptClient re-encrypts headers with the archive key like the reencrypt service does
+type mockReencryptClient struct {
+	archiveKey [32]byte
+}
+
+func (m *mockReencryptClient) ReencryptHeader(_ context.Context, in *reencrypt.ReencryptRequest, _ ...grpc.CallOption) (*reencrypt.ReencryptResponse, error) {
+	publicKey, err := base64.StdEncoding.DecodeString(in.GetPublickey())
+	if err != nil || len(publicKey) != 32 {
+		return nil, status.Error(400, "bad public key")
+	}
+	newHeader, err := headers.ReEncryptHeader(in.GetOldheader(), m.archiveKey, [][32]byte{[32]byte(publicKey)})
+	if err != nil {
+		return nil, status.Error(400, "header reencryption failed, no matching key available")
+	}
+	archivePublicKey := keys.DerivePublicKey(m.archiveKey)
+
+	return &reencrypt.ReencryptResponse{Header: newHeader, Keyhash: hex.EncodeToString(archivePublicKey[:])}, nil
+}
+
+func (ts *TestSuite) SetupTest() {
+	sftpinboxconf.SetSchemaPath("../../schemas/isolated")
+	sftpinboxconf.SetInboxQueue("inbox")
+
+	ts.db = &mockDatabase{quotas: make(map[string]*database.InboxQuota)}
+	ts.broker = &mockBroker{}
+	ts.storage = &mockStorage{location: "/inbox", files: make(map[string][]byte)}
+	ts.app = &SftpInbox{
+		InboxWriter: ts.storage,
+		InboxReader: ts.storage,
+		Broker:      ts.broker,
+		db:          ts.db,
+	}
+	ts.client = ts.connect("user@example.org")
+}
+
+func (ts *TestSuite) TearDownTest() {
+	_ = ts.client.Close()
+}
+
+// connect returns an sftp client connected to a server serving the inbox of the user
+func (ts *TestSuite) connect(username string) *sftp.Client {
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+	server := sftp.NewRequestServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{serverReader, serverWriter}, ts.app.handlers(username))
+	go func() {
+		_ = server.Serve()
+		_ = server.Close()
+	}()
+
+	client, err := sftp.NewClientPipe(clientReader, clientWriter, sftp.UseConcurrentWrites(true))
+	ts.Require().NoError(err)
+
+	return client
+}
+
+func (ts *TestSuite) upload(filePath string, content []byte) {
+	file, err := ts.client.Create(filePath)
+	ts.Require().NoError(err)
+	_, err = file.ReadFrom(bytes.NewReader(content))
+	ts.Require().NoError(err)
+	ts.Require().NoError(file.Close())
+}
+
+func (ts *TestSuite) TestUpload() {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 100000)
+	ts.upload("/dir/file.c4gh", content)
+
+	ts.Equal(content, ts.storage.files["/inbox/user_example.org/dir/file.c4gh"])
+	ts.Len(ts.db.files, 1)
+	file := ts.db.files[0]
+	ts.Equal("dir/file.c4gh", file.path)
+	ts.Equal("user@example.org", file.user)
+	ts.Equal("/inbox", file.location)
+	ts.Equal("uploaded", file.status)
+	ts.Equal(int64(len(content)), file.size)
+	ts.Equal(map[string]string{
+		"sha256": fmt.Sprintf("%x", sha256.Sum256(content)),
+		"md5":    fmt.Sprintf("%x", md5.Sum(content)), // #nosec G401
+	}, file.checksums)
+
+	ts.Len(ts.broker.messages, 1)
+	ts.Equal("upload", ts.broker.messages[0]["operation"])
+	ts.Equal("user@example.org", ts.broker.messages[0]["user"])
+	ts.Equal("user_example.org/dir/file.c4gh", ts.broker.messages[0]["filepath"])
+	ts.Equal(float64(len(content)), ts.broker.messages[0]["filesize"])
+	ts.Equal("file-1", ts.broker.messages[0]["key"])
+	ts.Equal([]any{
+		map[string]any{"type": "sha256", "value": file.checksums["sha256"]},
+		map[string]any{"type": "md5", "value": file.checksums["md5"]},
+	}, ts.broker.messages[0]["encrypted_checksums"])
+}
+
+func (ts *TestSuite) TestUpload_reupload() {
+	ts.upload("file.c4gh", []byte("first"))
+	ts.upload("file.c4gh", []byte("second"))
+
+	// The existing file is uploaded again
+	ts.Len(ts.db.files, 1)
+	ts.Equal(int64(len("second")), ts.db.files[0].size)
+	ts.Equal([]byte("second"), ts.storage.files["/inbox/user_example.org/file.c4gh"])
+	ts.Len(ts.broker.messages, 2)
+
+	// The file is registered again when the active location of the inbox has changed
+	ts.storage.location = "/inbox2"
+	ts.upload("file.c4gh", []byte("third"))
+	ts.Len(ts.db.files, 1)
+	ts.Equal("/inbox2", ts.db.files[0].location)
+}
+
+func (ts *TestSuite) TestUpload_storageFailure() {
+	ts.storage.err = errors.New("storage unavailable")
+
+	file, err := ts.client.Create("file.c4gh")
+	ts.Require().NoError(err)
+	_, _ = file.Write([]byte("content"))
+	ts.Error(file.Close())
+
+	ts.Empty(ts.db.files)
+	ts.Empty(ts.broker.messages)
+}
+
+func (ts *TestSuite) TestUpload_invalidPath() {
+	_, err := ts.client.Create("file?.c4gh")
+	ts.ErrorIs(err, os.ErrPermission)
+
+	_, err = ts.client.Create("/")
+	ts.Error(err)
+}
+
+func (ts *TestSuite) TestUpload_invalidHeader() {
+	publicKey, privateKey, err := keys.GenerateKeyPair()
+	ts.Require().NoError(err)
+	ts.app.reencryptClient = &mockReencryptClient{archiveKey: privateKey}
+	ts.db.keyHashes = []*database.C4ghKeyHash{{Hash: hex.EncodeToString(publicKey[:])}}
+
+	encrypt := func(recipient [32]byte) []byte {
+		_, senderKey, err := keys.GenerateKeyPair()
+		ts.Require().NoError(err)
+		var encrypted bytes.Buffer
+		writer, err := streaming.NewCrypt4GHWriter(&encrypted, senderKey, [][32]byte{recipient}, nil)
+		ts.Require().NoError(err)
+		_, err = writer.Write(bytes.Repeat([]byte("content"), 100000))
+		ts.Require().NoError(err)
+		ts.Require().NoError(writer.Close())
+
+		return encrypted.Bytes()
+	}
+	rejected := func(filePath string, content []byte) {
+		file, err := ts.client.Create(filePath)
+		ts.Require().NoError(err)
+		_, err = file.ReadFrom(bytes.NewReader(content))
+		if err == nil {
+			err = file.Close()
+		}
+		ts.ErrorContains(err, errInvalidHeader.Error())
+	}
+
+	encrypted := encrypt(publicKey)
+	ts.upload("file.c4gh", encrypted)
+	ts.Equal(encrypted, ts.storage.files["/inbox/user_example.org/file.c4gh"])
+
+	otherPublicKey, _, err := keys.GenerateKeyPair()
+	ts.Require().NoError(err)
+	rejected("other.c4gh", encrypt(otherPublicKey))
+	rejected("plain.txt", bytes.Repeat([]byte("not encrypted"), 100000))
+	// Files smaller than a header are only rejected when they are closed
+	rejected("small.c4gh", []byte("crypt4gh"))
+
+	// Files encrypted with a deprecated key are rejected
+	ts.db.keyHashes[0].DeprecatedAt = "2024-01-01 00:00:00"
+	rejected("deprecated.c4gh", encrypted)
+
+	ts.Len(ts.db.files, 1)
+	ts.Len(ts.broker.messages, 1)
+}
+
+func (ts *TestSuite) TestUpload_quota() {
+	ts.app.defaultQuota = database.InboxQuota{MaxBytes: 100, MaxFiles: 2}
+	ts.upload("file.c4gh", bytes.Repeat([]byte("0"), 60))
+
+	// Uploads that would exceed the quota fail once they do
+	file, err := ts.client.Create("large.c4gh")
+	ts.Require().NoError(err)
+	_, err = file.Write(bytes.Repeat([]byte("0"), 41))
+	ts.ErrorContains(err, errQuotaExceeded.Error())
+	_ = file.Close()
+	ts.Len(ts.db.files, 1)
+
+	// The replaced file is left out of the usage
+	ts.upload("file.c4gh", bytes.Repeat([]byte("0"), 100))
+	ts.Equal(int64(100), ts.db.files[0].size)
+
+	// No files can be uploaded once the quota is reached
+	_, err = ts.client.Create("other.c4gh")
+	ts.ErrorContains(err, errQuotaExceeded.Error())
+
+	// The quota of the user replaces the default quota
+	ts.db.quotas["user@example.org"] = &database.InboxQuota{User: "user@example.org", MaxBytes: 200}
+	ts.upload("other.c4gh", bytes.Repeat([]byte("0"), 100))
+	ts.Len(ts.db.files, 2)
+	_, err = ts.client.Create("third.c4gh")
+	ts.ErrorContains(err, errQuotaExceeded.Error())
+}
+
+func (ts *TestSuite) TestRead() {
+	ts.upload("dir/file.c4gh", []byte("content"))
+
+	file, err := ts.client.Open("/dir/file.c4gh")
+	ts.Require().NoError(err)
+	content, err := io.ReadAll(file)
+	ts.NoError(err)
+	ts.Equal([]byte("content"), content)
+	ts.NoError(file.Close())
+
+	_, err = ts.client.Open("/dir/missing.c4gh")
+	ts.ErrorIs(err, os.ErrNotExist)
+}
+
+func (ts *TestSuite) TestList() {
+	ts.upload("file.c4gh", []byte("content"))
+	ts.upload("dir/file.c4gh", []byte("content"))
+	ts.upload("dir/sub/file.c4gh", []byte("content"))
+	// Files in the inbox of other users are not listed
+	other := ts.connect("other@example.org")
+	defer other.Close()
+	file, err := other.Create("other.c4gh")
+	ts.Require().NoError(err)
+	ts.Require().NoError(file.Close())
+
+	names := func(dir string) []string {
+		entries, err := ts.client.ReadDir(dir)
+		ts.Require().NoError(err)
+		var names []string
+		for _, entry := range entries {
+			if entry.IsDir() {
+				names = append(names, entry.Name()+"/")
+			} else {
+				names = append(names, entry.Name())
+			}
+		}
+		sort.Strings(names)
+
+		return names
+	}
+	ts.Equal([]string{"dir/", "file.c4gh"}, names("/"))
+	ts.Equal([]string{"file.c4gh", "sub/"}, names("/dir"))
+
+	info, err := ts.client.Stat("/dir/file.c4gh")
+	ts.NoError(err)
+	ts.Equal(int64(len("content")), info.Size())
+	ts.False(info.IsDir())
+	info, err = ts.client.Stat("/dir/sub")
+	ts.NoError(err)
+	ts.True(info.IsDir())
+	_, err = ts.client.Stat("/missing")
+	ts.ErrorIs(err, os.ErrNotExist)
+	_, err = ts.client.ReadDir("/missing")
+	ts.ErrorIs(err, os.ErrNotExist)
+
+	// Directories created during the session exist until files are uploaded to them
+	ts.NoError(ts.client.Mkdir("/new"))
+	ts.Equal([]string{"dir/", "file.c4gh", "new/"}, names("/"))
+	ts.Empty(names("/new"))
+}
+
+func (ts *TestSuite) TestRemove() {
+	ts.upload("dir/file.c4gh", []byte("content"))
+
+	ts.NoError(ts.client.Remove("/dir/file.c4gh"))
+	ts.Empty(ts.storage.files)
+	ts.Equal("disabled", ts.db.files[0].status)
+	ts.Len(ts.broker.messages, 2)
+	ts.Equal(map[string]any{
+		"operation": "remove",
+		"user":      "user@example.org",
+		"filepath":  "user_example.org/dir/file.c4gh",
+		"key":       "file-1",
+	}, ts.broker.messages[1])
+
+	ts.ErrorIs(ts.client.Remove("/dir/file.c4gh"), os.ErrNotExist)
+
+	// Files which are being ingested can not be removed
+	ts.upload("other.c4gh", []byte("content"))
+	ts.db.files[1].status = "submitted"
+	ts.ErrorIs(ts.client.Remove("/other.c4gh"), os.ErrPermission)
+}
+
+func (ts *TestSuite) TestRename() {
+	ts.upload("file.c4gh", []byte("content"))
+
+	ts.NoError(ts.client.Rename("/file.c4gh", "/dir/renamed.c4gh"))
+	ts.Equal(map[string][]byte{"/inbox/user_example.org/dir/renamed.c4gh": []byte("content")}, ts.storage.files)
+	ts.Len(ts.db.files, 2)
+	ts.Equal("disabled", ts.db.files[0].status)
+	renamed := ts.db.files[1]
+	ts.Equal("dir/renamed.c4gh", renamed.path)
+	ts.Equal("uploaded", renamed.status)
+	ts.Equal(ts.db.files[0].size, renamed.size)
+	ts.Equal(ts.db.files[0].checksums, renamed.checksums)
+	ts.JSONEq(`{"renamed_from": "file.c4gh"}`, renamed.details)
+	ts.Len(ts.broker.messages, 2)
+	ts.Equal(map[string]any{
+		"operation": "rename",
+		"user":      "user@example.org",
+		"filepath":  "user_example.org/dir/renamed.c4gh",
+		"oldpath":   "user_example.org/file.c4gh",
+		"key":       "file-2",
+	}, ts.broker.messages[1])
+
+	// Existing files are not overwritten
+	ts.upload("file.c4gh", []byte("content"))
+	ts.Error(ts.client.Rename("/file.c4gh", "/dir/renamed.c4gh"))
+	ts.Error(ts.client.Rename("/missing.c4gh", "/other.c4gh"))
+}
@@ -0,0 +1,260 @@
+// The sftp inbox lets users upload files to their inbox over sftp, authenticating with their CEGA credentials or a
+// token. Files are written to the inbox storage, registered in the database, and announced with inbox messages in the
+// same way as by the s3inbox.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	sftpinboxconf "github.com/neicnordic/sensitive-data-archive/cmd/sftpinbox/config"
+	brokerv2 "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/broker/v2/factory"
+	"github.com/neicnordic/sensitive-data-archive/internal/config"
+	configv2 "github.com/neicnordic/sensitive-data-archive/internal/config/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/database/postgres"
+	"github.com/neicnordic/sensitive-data-archive/internal/reencrypt"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/locationbroker"
+	"github.com/neicnordic/sensitive-data-archive/internal/userauth"
+	"github.com/pkg/sftp"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+type SftpInbox struct {
+	InboxWriter storage.Writer
+	InboxReader storage.Reader
+	Broker      brokerv2.Broker
+	db          database.Database
+	sshConfig   *ssh.ServerConfig
+	// reencryptClient validates the crypt4gh headers of uploaded files, headers are not validated when it is nil
+	reencryptClient reencrypt.ReencryptClient
+	// defaultQuota is the quota of users that do not have a quota of their own
+	defaultQuota database.InboxQuota
+}
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run() error {
+	var err error
+	app := SftpInbox{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err = configv2.Load(); err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
+
+	var tokenValidator *userauth.ValidateFromToken
+	if sftpinboxconf.JwtPubKeyPath() != "" || sftpinboxconf.JwtPubKeyURL() != "" {
+		tokenValidator = userauth.NewValidateFromToken(jwk.NewSet())
+		if sftpinboxconf.JwtPubKeyURL() != "" {
+			if err := tokenValidator.FetchJwtPubKeyURL(sftpinboxconf.JwtPubKeyURL()); err != nil {
+				return fmt.Errorf("failed to fetch jwt public keys, due to: %v", err)
+			}
+		}
+		if sftpinboxconf.JwtPubKeyPath() != "" {
+			if err := tokenValidator.ReadJwtPubKeyPath(sftpinboxconf.JwtPubKeyPath()); err != nil {
+				return fmt.Errorf("failed to read jwt public keys, due to: %v", err)
+			}
+		}
+	}
+	if sftpinboxconf.CegaAuthURL() == "" && tokenValidator == nil {
+		return errors.New("either cega.authUrl or jwt.pubKeyPath/jwt.pubKeyUrl needs to be set for users to be able to login")
+	}
+	authenticator := NewAuthenticator(sftpinboxconf.CegaAuthURL(), sftpinboxconf.CegaID(), sftpinboxconf.CegaSecret(), sftpinboxconf.CegaCacheTTL(), tokenValidator)
+
+	app.sshConfig = authenticator.serverConfig()
+	hostKey, err := readHostKey(sftpinboxconf.HostKeyPath())
+	if err != nil {
+		return err
+	}
+	app.sshConfig.AddHostKey(hostKey)
+
+	app.Broker, err = factory.NewBroker(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to initialize mq broker, due to: %v", err)
+	}
+	defer func() {
+		if err := app.Broker.Close(); err != nil {
+			log.Errorf("could not close Broker, due to: %v", err)
+		}
+	}()
+
+	app.db, err = postgres.NewPostgresSQLDatabase()
+	if err != nil {
+		return fmt.Errorf("failed to initialize sda db due to: %v", err)
+	}
+	defer app.db.Close()
+
+	// The archive keys are kept out of the inbox, the crypt4gh headers of uploads are checked by the reencrypt
+	// service instead
+	if sftpinboxconf.ReencryptHost() != "" {
+		grpcConf, err := config.GetReEncryptClientConfig()
+		if err != nil {
+			return fmt.Errorf("failed to read reencrypt client config, due to: %v", err)
+		}
+		conn, err := reencrypt.NewClientConn(grpcConf)
+		if err != nil {
+			return fmt.Errorf("failed to create reencrypt client, due to: %v", err)
+		}
+		defer conn.Close()
+		app.reencryptClient = reencrypt.NewReencryptClient(conn)
+	}
+	app.defaultQuota = database.InboxQuota{MaxBytes: sftpinboxconf.QuotaBytes(), MaxFiles: sftpinboxconf.QuotaFiles()}
+
+	storageLocationBroker, err := locationbroker.NewLocationBroker(app.db)
+	if err != nil {
+		return fmt.Errorf("failed to initialize location broker, due to: %v", err)
+	}
+	app.InboxWriter, err = storage.NewWriter(ctx, "inbox", storageLocationBroker)
+	if err != nil {
+		return fmt.Errorf("failed to initialize inbox writer, due to: %v", err)
+	}
+	app.InboxReader, err = storage.NewReader(ctx, "inbox")
+	if err != nil {
+		return fmt.Errorf("failed to initialize inbox reader, due to: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(sftpinboxconf.Host(), strconv.Itoa(sftpinboxconf.Port())))
+	if err != nil {
+		return fmt.Errorf("failed to listen for sftp connections, due to: %v", err)
+	}
+	log.Infof("starting sftp inbox on: %s", listener.Addr())
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+
+	go func() {
+		sig := <-sigc
+		log.Infof("recieved signal: %v, shutting down gracefully", sig)
+		cancel()
+		_ = listener.Close()
+	}()
+
+	return app.serve(ctx, listener)
+}
+
+// readHostKey reads the private ssh host key of the sftp inbox
+func readHostKey(path string) (ssh.Signer, error) {
+	keyData, err := os.ReadFile(path) // #nosec G304 -- host key path controlled by configuration
+	if err != nil {
+		return nil, fmt.Errorf("failed to read host key, due to: %v", err)
+	}
+	hostKey, err := ssh.ParsePrivateKey(keyData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse host key, due to: %v", err)
+	}
+
+	return hostKey, nil
+}
+
+// serve accepts connections on the listener until it is closed, ongoing sessions are waited for before returning
+func (app *SftpInbox) serve(ctx context.Context, listener net.Listener) error {
+	var sessions sync.WaitGroup
+	defer sessions.Wait()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+
+			return fmt.Errorf("failed to accept sftp connection, due to: %v", err)
+		}
+
+		sessions.Add(1)
+		go func() {
+			defer sessions.Done()
+			app.handleConn(ctx, conn)
+		}()
+	}
+}
+
+// handleConn performs the ssh handshake, authenticating the user, and serves the sftp sessions of the connection
+func (app *SftpInbox) handleConn(ctx context.Context, conn net.Conn) {
+	sshConn, channels, requests, err := ssh.NewServerConn(conn, app.sshConfig)
+	if err != nil {
+		log.Debugf("ssh handshake with: %s failed, due to: %v", conn.RemoteAddr(), err)
+		_ = conn.Close()
+
+		return
+	}
+	defer sshConn.Close()
+	go ssh.DiscardRequests(requests)
+
+	// The connection is closed on shutdown, which ends its sessions
+	connCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-connCtx.Done()
+		_ = sshConn.Close()
+	}()
+
+	var sessions sync.WaitGroup
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			log.Errorf("user: %s, failed to accept session, due to: %v", sshConn.User(), err)
+
+			continue
+		}
+
+		sessions.Add(1)
+		go func() {
+			defer sessions.Done()
+			app.handleSession(sshConn.User(), channel, channelRequests)
+		}()
+	}
+	sessions.Wait()
+}
+
+// handleSession serves the sftp subsystem on the session channel, other requests such as shells are refused
+func (app *SftpInbox) handleSession(username string, channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+
+	for req := range requests {
+		var subsystem struct{ Name string }
+		if req.Type != "subsystem" || ssh.Unmarshal(req.Payload, &subsystem) != nil || subsystem.Name != "sftp" {
+			if req.WantReply {
+				_ = req.Reply(false, nil)
+			}
+
+			continue
+		}
+		if req.WantReply {
+			_ = req.Reply(true, nil)
+		}
+		go ssh.DiscardRequests(requests)
+
+		server := sftp.NewRequestServer(channel, app.handlers(username))
+		if err := server.Serve(); err != nil && !errors.Is(err, io.EOF) {
+			log.Warnf("user: %s, sftp session ended, due to: %v", username, err)
+		}
+		_ = server.Close()
+
+		return
+	}
+}
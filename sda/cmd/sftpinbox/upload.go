@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"crypto/md5" // #nosec G501 -- md5 is used as a checksum of the uploaded file, not for security
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/helper"
+	"github.com/neicnordic/sensitive-data-archive/internal/inboxcheck"
+	"github.com/neicnordic/sensitive-data-archive/internal/schema"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxPendingBytes limits how much data written ahead of the current offset of an upload is held in memory, sftp clients
+// keep a limited number of writes in flight so this is only exceeded by clients that do not write files sequentially
+const maxPendingBytes = 64 * 1024 * 1024
+
+// errNonSequentialWrite is returned for writes that can not be streamed to the inbox storage, such as rewriting
+// already written parts of the file
+var errNonSequentialWrite = errors.New("only sequential writes are supported")
+
+// errInvalidHeader is returned for uploads that are not crypt4gh encrypted with an active archive key
+var errInvalidHeader = errors.New("file is not crypt4gh encrypted with the public key of the archive")
+
+// errQuotaExceeded is returned for uploads that would exceed the quota of the user
+var errQuotaExceeded = errors.New("quota exceeded")
+
+// uploadHashes computes the sha256 and md5 checksums of the file while it is uploaded
+type uploadHashes struct {
+	sha256 hash.Hash
+	md5    hash.Hash
+	size   int64
+}
+
+func newUploadHashes() *uploadHashes {
+	return &uploadHashes{
+		sha256: sha256.New(),
+		md5:    md5.New(), // #nosec G401 -- md5 is used as a checksum of the uploaded file, not for security
+	}
+}
+
+func (h *uploadHashes) Write(p []byte) (int, error) {
+	_, _ = h.sha256.Write(p)
+	_, _ = h.md5.Write(p)
+	h.size += int64(len(p))
+
+	return len(p), nil
+}
+
+func (h *uploadHashes) checksums() []schema.Checksums {
+	return []schema.Checksums{
+		{Type: "sha256", Value: fmt.Sprintf("%x", h.sha256.Sum(nil))},
+		{Type: "md5", Value: fmt.Sprintf("%x", h.md5.Sum(nil))},
+	}
+}
+
+type writeResult struct {
+	location string
+	err      error
+}
+
+// upload streams a file written by a client to the inbox storage. Clients may have several writes of a file in flight
+// which the sftp server handles concurrently, writes which arrive ahead of the current offset are held back until the
+// data preceding them has been written
+type upload struct {
+	inbox    *inbox
+	ctx      context.Context
+	filePath string
+	// maxSize is the size the file can have without exceeding the quota of the user, -1 when it is not limited
+	maxSize int64
+
+	mu           sync.Mutex
+	pipe         *io.PipeWriter
+	hashes       *uploadHashes
+	offset       int64
+	pending      map[int64][]byte
+	pendingBytes int64
+	err          error
+	written      chan writeResult
+}
+
+// newUpload starts writing the file at the file path in the inbox of the user to the inbox storage, the crypt4gh header
+// at the start of the file is validated before anything is written when the reencrypt service is configured
+func (i *inbox) newUpload(ctx context.Context, filePath string, maxSize int64) *upload {
+	pipeReader, pipeWriter := io.Pipe()
+	u := &upload{
+		inbox:    i,
+		ctx:      ctx,
+		filePath: filePath,
+		maxSize:  maxSize,
+		pipe:     pipeWriter,
+		hashes:   newUploadHashes(),
+		pending:  make(map[int64][]byte),
+		written:  make(chan writeResult, 1),
+	}
+
+	go func() {
+		var body io.Reader = pipeReader
+		if i.app.reencryptClient != nil {
+			var err error
+			body, err = inboxcheck.ValidateHeader(ctx, i.app.reencryptClient, i.app.db, pipeReader)
+			if err != nil {
+				_ = pipeReader.CloseWithError(err)
+				u.written <- writeResult{err: err}
+
+				return
+			}
+		}
+		location, err := i.app.InboxWriter.WriteFile(ctx, helper.UnanonymizeFilepath(filePath, i.user), body)
+		// Unblock any write to the pipe when the storage stopped reading early
+		_ = pipeReader.CloseWithError(errors.Join(errors.New("upload to inbox storage ended"), err))
+		u.written <- writeResult{location: location, err: err}
+	}()
+
+	return u
+}
+
+// WriteAt writes the data at the offset to the inbox storage, or holds it back until the data before it has been
+// written
+func (u *upload) WriteAt(p []byte, offset int64) (int, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.err != nil {
+		return 0, u.err
+	}
+	switch {
+	case u.maxSize >= 0 && offset+int64(len(p)) > u.maxSize:
+		u.fail(errQuotaExceeded)
+
+		return 0, u.err
+	case offset < u.offset:
+		u.fail(errNonSequentialWrite)
+
+		return 0, u.err
+	case offset > u.offset:
+		if _, ok := u.pending[offset]; ok || u.pendingBytes+int64(len(p)) > maxPendingBytes {
+			u.fail(errNonSequentialWrite)
+
+			return 0, u.err
+		}
+		// The buffer is reused by the sftp server once the write returns
+		u.pending[offset] = append([]byte(nil), p...)
+		u.pendingBytes += int64(len(p))
+
+		return len(p), nil
+	}
+
+	if err := u.write(p); err != nil {
+		return 0, err
+	}
+	for {
+		next, ok := u.pending[u.offset]
+		if !ok {
+			break
+		}
+		delete(u.pending, u.offset)
+		u.pendingBytes -= int64(len(next))
+		if err := u.write(next); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+func (u *upload) write(p []byte) error {
+	n, err := u.pipe.Write(p)
+	_, _ = u.hashes.Write(p[:n])
+	u.offset += int64(n)
+	switch {
+	case errors.Is(err, inboxcheck.ErrInvalidHeader):
+		u.fail(errInvalidHeader)
+	case err != nil:
+		// The reason the inbox storage stopped reading the upload is logged when the file is closed
+		u.fail(errInternal)
+	}
+
+	return u.err
+}
+
+// fail aborts the upload, the file is not registered as uploaded when it is closed
+func (u *upload) fail(err error) {
+	if u.err == nil {
+		u.err = err
+		_ = u.pipe.CloseWithError(err)
+	}
+}
+
+// TransferError is called by the sftp server when the session ends while the file is open
+func (u *upload) TransferError(err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.fail(fmt.Errorf("upload interrupted: %v", err))
+}
+
+// Close completes the upload to the inbox storage, and registers the file as uploaded to the inbox of the user
+func (u *upload) Close() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if len(u.pending) > 0 {
+		u.fail(fmt.Errorf("file closed with %d bytes missing before offset: %d", u.pendingBytes, u.offset))
+	}
+	if u.err == nil {
+		_ = u.pipe.Close()
+	}
+	result := <-u.written
+	switch {
+	case u.err != nil && !errors.Is(u.err, errInternal):
+		log.Warnf("user: %s, upload of file: %s failed, due to: %v", u.inbox.user, u.filePath, u.err)
+
+		return u.err
+	case errors.Is(result.err, inboxcheck.ErrInvalidHeader):
+		// Files smaller than a crypt4gh header are only found invalid once the file is closed
+		log.Warnf("user: %s, upload of file: %s failed, due to: %v", u.inbox.user, u.filePath, result.err)
+
+		return errInvalidHeader
+	case result.err != nil:
+		log.Errorf("user: %s, failed to write file: %s to inbox storage, due to: %v", u.inbox.user, u.filePath, result.err)
+
+		return errInternal
+	case u.err != nil:
+		log.Errorf("user: %s, failed to write file: %s to inbox storage, due to: inbox storage stopped reading the upload", u.inbox.user, u.filePath)
+
+		return errInternal
+	}
+
+	if err := u.inbox.registerUpload(u.ctx, u.filePath, result.location, u.hashes); err != nil {
+		log.Errorf("user: %s, failed to register upload of file: %s, due to: %v", u.inbox.user, u.filePath, err)
+
+		return errInternal
+	}
+
+	return nil
+}
+
+// registerUpload registers the file uploaded to the location in the database, and publishes the inbox-upload message
+func (i *inbox) registerUpload(ctx context.Context, filePath, location string, hashes *uploadHashes) error {
+	fileID, err := i.app.registerFile(ctx, i.user, filePath, location)
+	if err != nil {
+		return err
+	}
+
+	message := schema.InboxUpload{
+		User:               i.user,
+		FilePath:           helper.UnanonymizeFilepath(filePath, i.user),
+		Operation:          "upload",
+		FileSize:           hashes.size,
+		FileLastModified:   time.Now().Unix(),
+		EncryptedChecksums: hashes.checksums(),
+	}
+	jsonMessage, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message to json: %v", err)
+	}
+	if err := i.app.publish(ctx, fileID, "inbox-upload.json", jsonMessage); err != nil {
+		return err
+	}
+
+	if err := i.app.db.SetSubmissionFileSize(ctx, fileID, hashes.size); err != nil {
+		return fmt.Errorf("failed to set submission file size: %v", err)
+	}
+	for _, checksum := range message.EncryptedChecksums {
+		if err := i.app.db.AddUploadedChecksum(ctx, fileID, checksum.Value, checksum.Type); err != nil {
+			return fmt.Errorf("failed to store uploaded checksum: %v", err)
+		}
+	}
+	if err := i.app.db.UpdateFileEventLog(ctx, fileID, "uploaded", "inbox", "{}", string(jsonMessage)); err != nil {
+		return fmt.Errorf("failed to set file as uploaded: %v", err)
+	}
+	log.Infof("user: %s, uploaded file: %s, with id: %s, checksum: %s", i.user, filePath, fileID, message.EncryptedChecksums[0].Value)
+
+	return nil
+}
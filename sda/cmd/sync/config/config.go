@@ -0,0 +1,306 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	config "github.com/neicnordic/sensitive-data-archive/internal/config/v2"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var (
+	sourceQueue     string
+	schemaPath      string
+	centerPrefix    string
+	remoteHost      string
+	remotePort      int
+	remoteUser      string
+	remotePassword  string
+	syncPubKeyPath  string
+	destinationName string
+	retryAttempts   int
+	retryBackoff    time.Duration
+	confirmInterval time.Duration
+)
+
+// Remote is the sync API of a remote site, which the datasets synced to it are registered with. The site
+// authenticates to the sync API with a client certificate, with signed tokens or with a user and password
+type Remote struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	User     string `mapstructure:"user"`
+	Password string `mapstructure:"password"` // #nosec G117 -- needs to be exported for unmarshalling
+	// ClientCert and ClientKey are the client certificate of the site, and CACert verifies the server certificate of
+	// the sync API
+	ClientCert string `mapstructure:"clientCert"`
+	ClientKey  string `mapstructure:"clientKey"`
+	CACert     string `mapstructure:"caCert"`
+	// JWTPrivateKey signs the tokens of the site, which are issued by JWTIssuer for JWTAudience
+	JWTPrivateKey   string `mapstructure:"jwtPrivateKey"`
+	JWTSignatureAlg string `mapstructure:"jwtSignatureAlg"`
+	JWTIssuer       string `mapstructure:"jwtIssuer"`
+	JWTAudience     string `mapstructure:"jwtAudience"`
+}
+
+// Authenticated reports whether a way of authenticating to the sync API is configured
+func (r Remote) Authenticated() bool {
+	return r.User != "" && r.Password != "" ||
+		r.ClientCert != "" && r.ClientKey != "" ||
+		r.JWTPrivateKey != "" && r.JWTIssuer != "" && r.JWTAudience != ""
+}
+
+// Destination is a remote site that datasets are synced to
+type Destination struct {
+	// Name identifies the destination in the recorded sync progress
+	Name string `mapstructure:"name"`
+	// Storage is the name of the storage backend the files are written to, configured under storage.<Storage>
+	Storage string `mapstructure:"storage"`
+	// PublicKeyPath is the path to the crypt4gh public key of the site, which the file headers are re-encrypted for
+	PublicKeyPath string `mapstructure:"publicKeyPath"`
+	Remote        Remote `mapstructure:"remote"`
+	// DatasetPrefixes and Datasets route datasets to the destination by the prefix of their id or their id, datasets
+	// are routed to destinations without any routing rules
+	DatasetPrefixes []string `mapstructure:"datasetPrefixes"`
+	Datasets        []string `mapstructure:"datasets"`
+}
+
+// Receives reports whether the dataset is routed to the destination
+func (d Destination) Receives(datasetID string) bool {
+	if len(d.DatasetPrefixes) == 0 && len(d.Datasets) == 0 {
+		return true
+	}
+	for _, prefix := range d.DatasetPrefixes {
+		if strings.HasPrefix(datasetID, prefix) {
+			return true
+		}
+	}
+	for _, dataset := range d.Datasets {
+		if dataset == datasetID {
+			return true
+		}
+	}
+
+	return false
+}
+
+func init() {
+	config.RegisterFlags(
+		&config.Flag{
+			Name: "sourceQueue",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "mapping_stream", "The queue or stream where the sync service consumes dataset mapping messages from")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				sourceQueue = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "schemaType",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "isolated", "Path to JSON schemas to validate rabbitmq messages against")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				schemaType := viper.GetString("schemaType")
+				switch schemaType {
+				case "federated":
+					schemaPath = "/schemas/federated/"
+				case "isolated":
+					schemaPath = "/schemas/isolated/"
+				default:
+					panic(fmt.Sprintf("schema.type '%s' not supported, needs: <federated|isolated>", schemaType))
+				}
+			},
+		},
+		&config.Flag{
+			Name: "sync.centerPrefix",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "Prefix of the dataset IDs minted locally, only datasets with the prefix are synced")
+			},
+			Required: true,
+			AssignFunc: func(flagName string) {
+				centerPrefix = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "sync.remote.host",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "URL to the remote sync API host, used when sync.destinations is not set")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				remoteHost = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "sync.remote.port",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Int(flagName, 0, "Port of the remote sync API host, if other than the standard HTTP(S) ports")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				remotePort = viper.GetInt(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "sync.remote.user",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "Username for connecting to the remote sync API")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				remoteUser = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "sync.remote.password",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "Password for connecting to the remote sync API")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				remotePassword = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "sync.destination.name",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "default", "Name the progress of syncing files to the remote site is recorded under, used when sync.destinations is not set")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				destinationName = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "sync.retry.attempts",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Int(flagName, 3, "How many times the sync of a file is attempted before the sync of the dataset fails")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				retryAttempts = viper.GetInt(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "sync.retry.backoff",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Duration(flagName, 10*time.Second, "How long to wait before the first retry of a failed file sync, the wait is doubled for each following retry")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				retryBackoff = viper.GetDuration(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "sync.confirm.interval",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Duration(flagName, 5*time.Minute, "How often the remote sites are asked whether they have ingested the synced files, 0 disables the confirmation")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				confirmInterval = viper.GetDuration(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "c4gh.syncPubKeyPath",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "Path to the crypt4gh public key of the remote site, used to re-encrypt the file headers")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				syncPubKeyPath = viper.GetString(flagName)
+			},
+		},
+	)
+}
+
+func SourceQueue() string {
+	return sourceQueue
+}
+
+func SchemaPath() string {
+	return schemaPath
+}
+
+func SetSchemaPath(path string) {
+	schemaPath = path
+}
+
+func CenterPrefix() string {
+	return centerPrefix
+}
+
+func SetCenterPrefix(prefix string) {
+	centerPrefix = prefix
+}
+
+func RetryAttempts() int {
+	return retryAttempts
+}
+
+func RetryBackoff() time.Duration {
+	return retryBackoff
+}
+
+// SetRetry sets how many times the sync of a file is attempted and the wait before the first retry
+func SetRetry(attempts int, backoff time.Duration) {
+	retryAttempts = attempts
+	retryBackoff = backoff
+}
+
+func ConfirmInterval() time.Duration {
+	return confirmInterval
+}
+
+// Destinations returns the destinations configured in `sync.destinations`. When no destinations are configured the
+// single destination configured by `sync.remote` and `c4gh.syncPubKeyPath` is returned, which writes to the "sync"
+// storage
+func Destinations() ([]Destination, error) {
+	var destinations []Destination
+	if err := viper.UnmarshalKey("sync.destinations", &destinations); err != nil {
+		return nil, fmt.Errorf("failed to parse sync.destinations, due to: %v", err)
+	}
+	if len(destinations) == 0 {
+		destinations = []Destination{{
+			Name:          destinationName,
+			Storage:       "sync",
+			PublicKeyPath: syncPubKeyPath,
+			Remote: Remote{
+				Host:     remoteHost,
+				Port:     remotePort,
+				User:     remoteUser,
+				Password: remotePassword,
+			},
+		}}
+	}
+
+	names := make(map[string]bool)
+	for i := range destinations {
+		d := &destinations[i]
+		if d.Name == "" {
+			return nil, fmt.Errorf("sync destination %d has no name", i)
+		}
+		if names[d.Name] {
+			return nil, fmt.Errorf("sync destination name %s is not unique", d.Name)
+		}
+		names[d.Name] = true
+		if d.Storage == "" {
+			d.Storage = "sync"
+		}
+		if d.PublicKeyPath == "" || d.Remote.Host == "" {
+			return nil, fmt.Errorf("sync destination %s needs publicKeyPath and remote.host to be set", d.Name)
+		}
+		if !d.Remote.Authenticated() {
+			return nil, fmt.Errorf("sync destination %s needs remote.user and remote.password, remote.clientCert and remote.clientKey, or remote.jwtPrivateKey, remote.jwtIssuer and remote.jwtAudience to be set", d.Name)
+		}
+		if d.Remote.JWTPrivateKey != "" && d.Remote.JWTSignatureAlg == "" {
+			d.Remote.JWTSignatureAlg = "RS256"
+		}
+	}
+
+	return destinations, nil
+}
@@ -1,10 +1,13 @@
-// The backup command accepts messages with accessionIDs for
-// ingested files and copies them to the second storage.
+// The sync service accepts dataset mapping messages, copies the files of
+// locally minted datasets to the storage of each destination the dataset is
+// routed to, and registers the datasets with the remote sites.
 package main
 
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,12 +16,19 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
 	"github.com/neicnordic/crypt4gh/model/headers"
-	"github.com/neicnordic/sensitive-data-archive/internal/broker"
+	syncconf "github.com/neicnordic/sensitive-data-archive/cmd/sync/config"
+	brokerv2 "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/broker/v2/factory"
 	"github.com/neicnordic/sensitive-data-archive/internal/config"
 	configv2 "github.com/neicnordic/sensitive-data-archive/internal/config/v2"
 	"github.com/neicnordic/sensitive-data-archive/internal/database"
@@ -26,232 +36,457 @@ import (
 	"github.com/neicnordic/sensitive-data-archive/internal/schema"
 	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2"
 	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/locationbroker"
-	amqp "github.com/rabbitmq/amqp091-go"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/chacha20poly1305"
 )
 
-var (
-	key           *[32]byte
-	db            database.Database
-	conf          *config.Config
-	mqBroker      *broker.AMQPBroker
-	archiveReader storage.Reader
-	syncWriter    storage.Writer
+const (
+	// requestIDHeader identifies the requests sent to the sync API of the remotes
+	requestIDHeader = "X-Request-ID"
+	// requestTimestampHeader is the time the request was sent, the sync API rejects requests sent outside its
+	// replay window
+	requestTimestampHeader = "X-Request-Timestamp"
 )
 
+type Sync struct {
+	ArchiveReader storage.Reader
+	Broker        brokerv2.Broker
+	db            database.Database
+	// key is the archive private key, which the headers are decrypted with before they are re-encrypted for the
+	// destinations
+	key          *[32]byte
+	destinations []*destination
+}
+
+// destination is a remote site that datasets are synced to, with the storage the files are written to and the public
+// key the headers are re-encrypted for
+type destination struct {
+	syncconf.Destination
+	reader    storage.Reader
+	writer    storage.Writer
+	publicKey *[32]byte
+}
+
+// remoteFileStatus is the ingestion status of a synced file reported by the remote sync API
+type remoteFileStatus struct {
+	FileID string `json:"file_id"`
+	Status string `json:"status"`
+	ShaSum string `json:"sha256,omitempty"`
+}
+
 func main() {
 	if err := run(); err != nil {
 		log.Fatal(err)
 	}
 }
+
 func run() error {
+	var err error
+	app := Sync{}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	if err := configv2.Load(); err != nil {
+	if err = configv2.Load(); err != nil {
 		return fmt.Errorf("failed to load config: %v", err)
 	}
 
-	var err error
-	conf, err = config.NewConfig("sync")
+	app.key, err = config.GetC4GHKey()
 	if err != nil {
-		return fmt.Errorf("failed to load config, due to: %v", err)
+		return fmt.Errorf("failed to get c4gh key from config, due to: %v", err)
 	}
-
-	db, err = postgres.NewPostgresSQLDatabase()
+	destinationConfs, err := syncconf.Destinations()
 	if err != nil {
-		return fmt.Errorf("failed to initialize sda db, due to: %v", err)
-	}
-	defer db.Close()
-	if dbSchemaVersion, err := db.SchemaVersion(); err != nil || dbSchemaVersion < 23 {
-		return errors.Join(errors.New("database schema v23 is required"), err)
+		return err
 	}
 
-	mqBroker, err = broker.NewMQ(conf.Broker)
+	app.Broker, err = factory.NewBroker(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to initialize mq broker, due to: %v", err)
 	}
 	defer func() {
-		if mqBroker == nil {
-			return
-		}
-		if mqBroker.Channel != nil {
-			if err := mqBroker.Channel.Close(); err != nil {
-				log.Errorf("failed to close mq broker channel due to: %v", err)
-			}
-		}
-		if mqBroker.Connection != nil {
-			if err := mqBroker.Connection.Close(); err != nil {
-				log.Errorf("failed to close mq broker connection due to: %v", err)
-			}
+		if err := app.Broker.Close(); err != nil {
+			log.Errorf("could not close Broker, due to: %v", err)
 		}
 	}()
 
-	lb, err := locationbroker.NewLocationBroker(db)
+	app.db, err = postgres.NewPostgresSQLDatabase()
 	if err != nil {
-		return fmt.Errorf("failed to initialize location broker, due to: %v", err)
+		return fmt.Errorf("failed to initialize sda db, due to: %v", err)
+	}
+	defer app.db.Close()
+	if dbSchemaVersion, err := app.db.SchemaVersion(); err != nil || dbSchemaVersion < 36 {
+		return errors.Join(errors.New("database schema v36 is required"), err)
 	}
-	syncWriter, err = storage.NewWriter(ctx, "sync", lb)
+
+	lb, err := locationbroker.NewLocationBroker(app.db)
 	if err != nil {
-		return fmt.Errorf("failed to initialize sync writer, due to: %v", err)
+		return fmt.Errorf("failed to initialize location broker, due to: %v", err)
 	}
-	archiveReader, err = storage.NewReader(ctx, "archive")
+	for _, conf := range destinationConfs {
+		dest := &destination{Destination: conf}
+		dest.publicKey, err = config.GetC4GHPublicKey(conf.PublicKeyPath)
+		if err != nil {
+			return fmt.Errorf("failed to get c4gh public key of sync destination: %s, due to: %v", conf.Name, err)
+		}
+		dest.writer, err = storage.NewWriter(ctx, conf.Storage, lb)
+		if err != nil {
+			return fmt.Errorf("failed to initialize writer of sync destination: %s, due to: %v", conf.Name, err)
+		}
+		dest.reader, err = storage.NewReader(ctx, conf.Storage)
+		if err != nil {
+			return fmt.Errorf("failed to initialize reader of sync destination: %s, due to: %v", conf.Name, err)
+		}
+		app.destinations = append(app.destinations, dest)
+	}
+	app.ArchiveReader, err = storage.NewReader(ctx, "archive")
 	if err != nil {
 		return fmt.Errorf("failed to initialize archive reader, due to: %v", err)
 	}
+	log.Info("Starting sync service")
 
-	key, err = config.GetC4GHKey()
-	if err != nil {
-		return fmt.Errorf("failed to get c4gh key from config, due to: %v", err)
-	}
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 
-	log.Info("Starting sync service")
+	if syncconf.ConfirmInterval() > 0 {
+		go app.confirmSyncs(ctx, syncconf.ConfirmInterval())
+	}
 
 	consumeErr := make(chan error, 1)
 	go func() {
-		consumeErr <- startConsumer(ctx)
+		consumeErr <- app.Broker.Subscribe(ctx, syncconf.SourceQueue(), app.handleMessage)
 	}()
 
-	sigc := make(chan os.Signal, 1)
-	signal.Notify(sigc, os.Interrupt, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
-
 	select {
-	case <-sigc:
-	case err := <-mqBroker.Connection.NotifyClose(make(chan *amqp.Error)):
-		return err
-	case err := <-mqBroker.Channel.NotifyClose(make(chan *amqp.Error)):
-		return err
+	case sig := <-sigc:
+		log.Infof("recieved signal: %v, shutting down gracefully", sig)
+		cancel()
+
+		return nil
 	case err := <-consumeErr:
-		return err
-	}
+		if !errors.Is(err, context.Canceled) {
+			log.Errorf("failed to consume from %s, due to: %v", syncconf.SourceQueue(), err)
+			cancel()
 
-	return nil
-}
-func startConsumer(ctx context.Context) error {
-	messages, err := mqBroker.GetMessages(mqBroker.Conf.Queue)
-	if err != nil {
-		return err
-	}
-	for delivered := range messages {
-		handleMessage(ctx, delivered)
-	}
+			return err
+		}
 
-	return nil
+		return nil
+	}
 }
 
-func handleMessage(ctx context.Context, delivered amqp.Delivery) {
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
+func (app *Sync) handleMessage(ctx context.Context, delivered *brokerv2.Message) ([]func(), error) {
 	log.Debugf("Received a message (correlation-id: %s, message: %s)",
-		delivered.CorrelationId,
+		delivered.Key,
 		delivered.Body)
 
-	err := schema.ValidateJSON(fmt.Sprintf("%s/dataset-mapping.json", mqBroker.Conf.SchemasPath), delivered.Body)
+	err := schema.ValidateJSON(fmt.Sprintf("%s/dataset-mapping.json", syncconf.SchemaPath()), delivered.Body)
 	if err != nil {
-		log.Errorf("validation of incoming message (dataset-mapping) failed, correlation-id: %s, reason: (%s)", delivered.CorrelationId, err.Error())
-		// Send the message to an error queue so it can be analyzed.
-		infoErrorMessage := broker.InfoError{
-			Error:           "Message validation failed in sync service",
-			Reason:          err.Error(),
-			OriginalMessage: string(delivered.Body),
-		}
+		log.Errorf("validation of incoming message (dataset-mapping) failed, correlation-id: %s, reason: (%s)", delivered.Key, err.Error())
 
-		body, _ := json.Marshal(infoErrorMessage)
-		if err := mqBroker.SendMessage(delivered.CorrelationId, mqBroker.Conf.Exchange, "error", body); err != nil {
-			log.Errorf("failed to publish message, reason: (%v)", err)
-		}
-		if err := delivered.Ack(false); err != nil {
-			log.Errorf("failed to Ack message, reason: (%s)", err.Error())
-		}
-
-		return
+		return []func(){brokerv2.ErrorQueueCallback(app.Broker, delivered, "Message validation failed in sync service", err)}, nil
 	}
 
 	var message schema.DatasetMapping
 	// we unmarshal the message in the validation step so this is safe to do
 	_ = json.Unmarshal(delivered.Body, &message)
 
-	if !strings.HasPrefix(message.DatasetID, conf.Sync.CenterPrefix) {
+	if !strings.HasPrefix(message.DatasetID, syncconf.CenterPrefix()) {
 		log.Infoln("external dataset")
-		if err := delivered.Ack(false); err != nil {
-			log.Errorf("failed to Ack message, reason: (%s)", err.Error())
+
+		return nil, nil
+	}
+
+	var destinations []*destination
+	for _, dest := range app.destinations {
+		if dest.Receives(message.DatasetID) {
+			destinations = append(destinations, dest)
 		}
+	}
+	if len(destinations) == 0 {
+		log.Infof("no sync destination for dataset: %s", message.DatasetID)
 
-		return
+		return nil, nil
 	}
 
-	var syncFilesErr error
-	for _, aID := range message.AccessionIDs {
-		if err := syncFiles(ctx, aID); err != nil {
-			log.Errorf("failed to sync archived file: accession-id: %s, reason: (%s)", aID, err.Error())
-			syncFilesErr = err
+	log.Infoln("buildSyncDatasetJSON")
+	blob, err := app.buildSyncDatasetJSON(ctx, delivered.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build SyncDatasetJSON, reason: %v", err)
+	}
 
-			break
+	// A failed destination does not stop the dataset from being synced to the other destinations, the files already
+	// synced to a destination are skipped when the message is handled again
+	var failed []string
+	var syncErr error
+	for _, dest := range destinations {
+		if err := app.syncDataset(ctx, dest, message.AccessionIDs, blob); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			log.Errorf("failed to sync dataset: %s, to destination: %s, reason: %v", message.DatasetID, dest.Name, err)
+			failed = append(failed, dest.Name)
+			syncErr = errors.Join(syncErr, fmt.Errorf("destination: %s, %w", dest.Name, err))
 		}
 	}
-	if syncFilesErr != nil {
-		if err := delivered.Nack(false, false); err != nil {
-			log.Errorf("failed to nack following GetFileSize error message")
+	if syncErr != nil {
+		return []func(){brokerv2.ErrorQueueCallback(app.Broker, delivered, fmt.Sprintf("Failed to sync dataset to destinations: %s", strings.Join(failed, ", ")), syncErr)}, nil
+	}
+
+	return nil, nil
+}
+
+// syncDataset syncs the files of the dataset to the destination, and registers the dataset with its remote sync API
+func (app *Sync) syncDataset(ctx context.Context, dest *destination, accessionIDs []string, datasetJSON []byte) error {
+	for _, aID := range accessionIDs {
+		if err := app.syncFile(ctx, dest, aID); err != nil {
+			return fmt.Errorf("failed to sync archived file: accession-id: %s, reason: %v", aID, err)
 		}
+	}
 
-		return
+	if err := sendPOST(dest.Remote, datasetJSON); err != nil {
+		return fmt.Errorf("failed to send dataset to remote sync API, reason: %v", err)
 	}
 
-	log.Infoln("buildSyncDatasetJSON")
-	blob, err := buildSyncDatasetJSON(ctx, delivered.Body)
+	return nil
+}
+
+// syncFile syncs the file to the storage of the destination, the sync is retried with a doubling backoff until it has been attempted
+// the configured amount of times
+func (app *Sync) syncFile(ctx context.Context, dest *destination, accessionID string) error {
+	backoff := syncconf.RetryBackoff()
+	for attempt := 1; ; attempt++ {
+		err := app.syncFileOnce(ctx, dest, accessionID)
+		if err == nil || ctx.Err() != nil || attempt >= syncconf.RetryAttempts() {
+			return err
+		}
+		log.Warnf("failed to sync file: %s, to destination: %s, attempt: %d of %d, retrying in: %s, reason: %v", accessionID, dest.Name, attempt, syncconf.RetryAttempts(), backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// syncFileOnce copies the file to the storage of the destination unless it has already been synced, and records the progress of the
+// sync in the database
+func (app *Sync) syncFileOnce(ctx context.Context, dest *destination, accessionID string) error {
+	syncData, err := app.db.GetSyncData(ctx, accessionID)
+	if err != nil {
+		return fmt.Errorf("failed to get sync data, reason: %v", err)
+	}
+
+	state, err := app.db.GetFileSyncState(ctx, accessionID, dest.Name)
+	if err != nil {
+		return fmt.Errorf("failed to get sync state, reason: %v", err)
+	}
+	if state == nil {
+		state = &database.FileSyncState{AccessionID: accessionID, Destination: dest.Name}
+	}
+
+	if isSynced(ctx, dest, state, syncData) {
+		log.Debugf("file %s has already been synced to destination: %s", accessionID, dest.Name)
+		if state.Status == "verified" || state.Status == "confirmed" {
+			return nil
+		}
+		state.Status = "verified"
+
+		return app.setSyncState(ctx, state)
+	}
+
+	state.Attempts++
+	location, size, err := app.copyFile(ctx, dest, accessionID, syncData.FilePath)
+	if err != nil {
+		app.setSyncFailed(ctx, state, err)
+
+		return err
+	}
+	state.Status = "copied"
+	state.Location = location
+	state.Size = size
+	state.Checksum = syncData.Checksum
+	state.LastError = ""
+	if err := app.setSyncState(ctx, state); err != nil {
+		return err
+	}
+
+	syncedSize, err := dest.reader.GetFileSize(ctx, location, syncData.FilePath)
+	if err == nil && syncedSize != size {
+		err = fmt.Errorf("size of synced file: %d does not match expected size: %d", syncedSize, size)
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to verify synced file, reason: %v", err)
+		app.setSyncFailed(ctx, state, err)
+
+		return err
+	}
+	state.Status = "verified"
+
+	return app.setSyncState(ctx, state)
+}
+
+// isSynced reports whether the file has been synced by an earlier sync, and is still found in the storage of the
+// destination with the size it was synced with. Files whose content has changed since they were synced, such as after a re-ingestion, have
+// to be synced again
+func isSynced(ctx context.Context, dest *destination, state *database.FileSyncState, syncData *database.SyncData) bool {
+	if state.Status != "copied" && state.Status != "verified" && state.Status != "confirmed" || state.Checksum != syncData.Checksum {
+		return false
+	}
+
+	size, err := dest.reader.GetFileSize(ctx, state.Location, syncData.FilePath)
 	if err != nil {
-		log.Errorf("failed to build SyncDatasetJSON, Reason: %v", err)
+		log.Debugf("synced file %s not found in storage of destination: %s, reason: %v", state.AccessionID, dest.Name, err)
+
+		return false
+	}
+
+	return size == state.Size
+}
+
+func (app *Sync) setSyncState(ctx context.Context, state *database.FileSyncState) error {
+	if err := app.db.SetFileSyncState(ctx, state); err != nil {
+		return fmt.Errorf("failed to set sync state, reason: %v", err)
+	}
+
+	return nil
+}
+
+// setSyncFailed records the failed attempt to sync the file, the file has to be copied again by the next attempt
+func (app *Sync) setSyncFailed(ctx context.Context, state *database.FileSyncState, syncErr error) {
+	state.Status = "pending"
+	state.LastError = syncErr.Error()
+	if err := app.setSyncState(ctx, state); err != nil {
+		log.Errorf("failed to record failed sync of file: %s, reason: %v", state.AccessionID, err)
 	}
-	if err := sendPOST(blob); err != nil {
-		log.Errorf("failed to send POST, Reason: %v", err)
-		if err := delivered.Nack(false, false); err != nil {
-			log.Errorf("failed to nack following sendPOST error message")
+}
+
+// confirmSyncs periodically asks the remote site of each destination whether it has ingested the files verified in
+// its sync storage, until the context is cancelled
+func (app *Sync) confirmSyncs(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
+		for _, dest := range app.destinations {
+			if err := app.confirmDestination(ctx, dest); err != nil {
+				log.Errorf("failed to confirm synced files with destination: %s, reason: %v", dest.Name, err)
+			}
+		}
+	}
+}
 
-		return
+// confirmDestination requests the ingestion status of the files verified in the sync storage of the destination from
+// its remote sync API. Files which the remote site has ingested with the decrypted checksum they were synced with are
+// confirmed, files which it failed to ingest or ingested with another checksum are rejected and reported to the error
+// queue, such that they are synced again with the next sync of their dataset
+func (app *Sync) confirmDestination(ctx context.Context, dest *destination) error {
+	accessionIDs, err := app.db.GetUnconfirmedSyncFiles(ctx, dest.Name)
+	if err != nil {
+		return fmt.Errorf("failed to get unconfirmed files, reason: %v", err)
+	}
+	if len(accessionIDs) == 0 {
+		return nil
 	}
 
-	if err := delivered.Ack(false); err != nil {
-		log.Errorf("failed to Ack message, reason: (%s)", err.Error())
+	var request schema.SyncFileStatus
+	for _, aID := range accessionIDs {
+		syncData, err := app.db.GetSyncData(ctx, aID)
+		if err != nil {
+			return fmt.Errorf("failed to get sync data of file: %s, reason: %v", aID, err)
+		}
+		request.Files = append(request.Files, schema.SyncFile{User: syncData.User, FilePath: syncData.FilePath, FileID: aID, DatasetID: syncData.DatasetID, ShaSum: syncData.Checksum})
+	}
+
+	statuses, err := getRemoteFileStatus(dest.Remote, request)
+	if err != nil {
+		return fmt.Errorf("failed to get file status from remote sync API, reason: %v", err)
 	}
+
+	for _, status := range statuses {
+		state, err := app.db.GetFileSyncState(ctx, status.FileID, dest.Name)
+		if err != nil {
+			return fmt.Errorf("failed to get sync state of file: %s, reason: %v", status.FileID, err)
+		}
+		if state == nil || state.Status != "verified" {
+			continue
+		}
+
+		var rejectErr error
+		switch {
+		case status.Status == "failed":
+			rejectErr = errors.New("ingestion failed at remote site")
+		case status.Status == "mismatch" || (status.ShaSum != "" && !strings.EqualFold(status.ShaSum, state.Checksum)):
+			rejectErr = fmt.Errorf("decrypted checksum at remote site: %s does not match: %s", status.ShaSum, state.Checksum)
+		case status.Status == "ingested":
+			log.Infof("file: %s has been ingested by destination: %s", status.FileID, dest.Name)
+			state.Status = "confirmed"
+			state.LastError = ""
+
+			if err := app.setSyncState(ctx, state); err != nil {
+				return err
+			}
+
+			continue
+		default:
+			continue
+		}
+
+		log.Errorf("file: %s was rejected by destination: %s, reason: %v", status.FileID, dest.Name, rejectErr)
+		state.Status = "rejected"
+		state.LastError = rejectErr.Error()
+		if err := app.setSyncState(ctx, state); err != nil {
+			return err
+		}
+		body, _ := json.Marshal(status)
+		if err := brokerv2.PublishError(ctx, app.Broker, &brokerv2.Message{Key: status.FileID, Body: body}, fmt.Sprintf("Synced file rejected by destination: %s", dest.Name), rejectErr); err != nil {
+			log.Errorf("failed to publish rejected file: %s to error queue, reason: %v", status.FileID, err)
+		}
+	}
+
+	return nil
 }
 
-func syncFiles(ctx context.Context, accessionID string) error {
+// copyFile copies the file from the archive to the storage of the destination, with its header re-encrypted for the
+// destination, and returns the location and size of the synced file
+func (app *Sync) copyFile(ctx context.Context, dest *destination, accessionID, inboxPath string) (string, int64, error) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	log.Debugf("syncing file %s", accessionID)
-	inboxPath, err := db.GetInboxPath(ctx, accessionID)
-	if err != nil {
-		return fmt.Errorf("failed to get inbox path, reason: %v", err)
-	}
 
-	archivePath, archiveLocation, err := db.GetArchivePathAndLocation(ctx, accessionID)
+	archivePath, archiveLocation, err := app.db.GetArchivePathAndLocation(ctx, accessionID)
 	if err != nil {
-		return fmt.Errorf("failed to get archive path and location, reason: %v", err)
+		return "", 0, fmt.Errorf("failed to get archive path and location, reason: %v", err)
 	}
 
-	fileSize, err := archiveReader.GetFileSize(ctx, archiveLocation, archivePath)
+	fileSize, err := app.ArchiveReader.GetFileSize(ctx, archiveLocation, archivePath)
 	if err != nil {
-		return fmt.Errorf("failed to get file size from archive storage, location: %s, path: %s, reason: %v", archiveLocation, archivePath, err)
+		return "", 0, fmt.Errorf("failed to get file size from archive storage, location: %s, path: %s, reason: %v", archiveLocation, archivePath, err)
 	}
 
-	file, err := archiveReader.NewFileReader(ctx, archiveLocation, archivePath)
+	file, err := app.ArchiveReader.NewFileReader(ctx, archiveLocation, archivePath)
 	if err != nil {
-		return fmt.Errorf("failed to read file from archive storage, location: %s, path: %s, reason: %v", archiveLocation, archivePath, err)
+		return "", 0, fmt.Errorf("failed to read file from archive storage, location: %s, path: %s, reason: %v", archiveLocation, archivePath, err)
 	}
 	defer func() {
 		_ = file.Close()
 	}()
 
-	header, err := db.GetHeaderByAccessionID(ctx, accessionID)
+	header, err := app.db.GetHeaderByAccessionID(ctx, accessionID)
 	if err != nil {
-		return fmt.Errorf("failed to get header from db, reason: %v", err)
+		return "", 0, fmt.Errorf("failed to get header from db, reason: %v", err)
 	}
 
-	newHeader, err := headers.ReEncryptHeader(header, *key, [][chacha20poly1305.KeySize]byte{*conf.Sync.PublicKey})
+	newHeader, err := headers.ReEncryptHeader(header, *app.key, [][chacha20poly1305.KeySize]byte{*dest.publicKey})
 	if err != nil {
-		return fmt.Errorf("failed to reencrypt header, reason: %v", err)
+		return "", 0, fmt.Errorf("failed to reencrypt header, reason: %v", err)
 	}
 
 	contentReader, contentWriter := io.Pipe()
@@ -272,16 +507,16 @@ func syncFiles(ctx context.Context, accessionID string) error {
 		}
 	}()
 
-	_, err = syncWriter.WriteFile(ctx, inboxPath, contentReader)
+	location, err := dest.writer.WriteFile(ctx, inboxPath, contentReader)
 	if err != nil {
-		return fmt.Errorf("failed to upload file to storage, reason: %v", err)
+		return "", 0, fmt.Errorf("failed to upload file to storage, reason: %v", err)
 	}
 	_ = contentReader.Close()
 
-	return nil
+	return location, int64(len(newHeader)) + fileSize, nil
 }
 
-func buildSyncDatasetJSON(ctx context.Context, b []byte) ([]byte, error) {
+func (app *Sync) buildSyncDatasetJSON(ctx context.Context, b []byte) ([]byte, error) {
 	var msg schema.DatasetMapping
 	_ = json.Unmarshal(b, &msg)
 
@@ -290,7 +525,7 @@ func buildSyncDatasetJSON(ctx context.Context, b []byte) ([]byte, error) {
 	}
 
 	for _, ID := range msg.AccessionIDs {
-		data, err := db.GetSyncData(ctx, ID)
+		data, err := app.db.GetSyncData(ctx, ID)
 		if err != nil {
 			return nil, err
 		}
@@ -311,12 +546,37 @@ func buildSyncDatasetJSON(ctx context.Context, b []byte) ([]byte, error) {
 	return datasetJSON, nil
 }
 
-func sendPOST(payload []byte) error {
-	client := &http.Client{
-		Timeout: 30 * time.Second,
+// sendPOST registers the dataset with the sync API of the remote site
+func sendPOST(remote syncconf.Remote, payload []byte) error {
+	return postRemote(remote, "/dataset", payload, nil)
+}
+
+// getRemoteFileStatus requests the ingestion status of the synced files from the sync API of the remote site
+func getRemoteFileStatus(remote syncconf.Remote, request schema.SyncFileStatus) ([]remoteFileStatus, error) {
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
 	}
 
-	uri, err := createHostURL(conf.Sync.RemoteHost, conf.Sync.RemotePort)
+	var response struct {
+		Files []remoteFileStatus `json:"files"`
+	}
+	if err := postRemote(remote, "/files/status", payload, &response); err != nil {
+		return nil, err
+	}
+
+	return response.Files, nil
+}
+
+// postRemote posts the payload to the path of the sync API of the remote site, and decodes the JSON response into
+// response unless it is nil
+func postRemote(remote syncconf.Remote, path string, payload []byte, response any) error {
+	client, err := remoteClient(remote)
+	if err != nil {
+		return err
+	}
+
+	uri, err := createHostURL(remote.Host, remote.Port, path)
 	if err != nil {
 		return err
 	}
@@ -326,20 +586,110 @@ func sendPOST(payload []byte) error {
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.SetBasicAuth(conf.Sync.RemoteUser, conf.Sync.RemotePassword)
+
+	// Every request has a unique ID, which the sync API rejects replays of
+	requestID := uuid.New().String()
+	req.Header.Set(requestIDHeader, requestID)
+	req.Header.Set(requestTimestampHeader, time.Now().UTC().Format(time.RFC3339))
+	switch {
+	case remote.JWTPrivateKey != "":
+		token, err := signRemoteToken(remote, requestID)
+		if err != nil {
+			return fmt.Errorf("failed to sign token, reason: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	case remote.User != "":
+		req.SetBasicAuth(remote.User, remote.Password)
+	}
+
 	resp, err := client.Do(req) // #nosec G704 host originates from configuration
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("%s", resp.Status)
 	}
-	defer resp.Body.Close()
+	if response == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return fmt.Errorf("failed to decode response, reason: %v", err)
+	}
 
 	return nil
 }
 
-func createHostURL(host string, port int) (string, error) {
+// remoteClient returns the client for the sync API of the remote, which presents the client certificate of the site
+// when one is configured
+func remoteClient(remote syncconf.Remote) (*http.Client, error) {
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+	if remote.ClientCert == "" && remote.CACert == "" {
+		return client, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if remote.ClientCert != "" {
+		certificate, err := tls.LoadX509KeyPair(remote.ClientCert, remote.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client certificate, reason: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	if remote.CACert != "" {
+		caCert, err := os.ReadFile(filepath.Clean(remote.CACert))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate, reason: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in: %s", remote.CACert)
+		}
+	}
+	client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+
+	return client, nil
+}
+
+// signRemoteToken signs a short lived token for the sync API of the remote, identified by the ID of the request
+func signRemoteToken(remote syncconf.Remote, requestID string) (string, error) {
+	prKey, err := os.ReadFile(filepath.Clean(remote.JWTPrivateKey))
+	if err != nil {
+		return "", err
+	}
+	jwtKey, err := jwk.ParseKey(prKey, jwk.WithPEM(true))
+	if err != nil {
+		return "", err
+	}
+	if err := jwtKey.Set(jwk.AlgorithmKey, remote.JWTSignatureAlg); err != nil {
+		return "", err
+	}
+	if err := jwk.AssignKeyID(jwtKey); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	token, err := jwt.NewBuilder().
+		Issuer(remote.JWTIssuer).
+		Audience([]string{remote.JWTAudience}).
+		JwtID(requestID).
+		IssuedAt(now).
+		Expiration(now.Add(time.Minute)).
+		Build()
+	if err != nil {
+		return "", err
+	}
+	signed, err := jwt.Sign(token, jwt.WithKey(jwa.KeyAlgorithmFrom(remote.JWTSignatureAlg), jwtKey))
+	if err != nil {
+		return "", err
+	}
+
+	return string(signed), nil
+}
+
+func createHostURL(host string, port int, path string) (string, error) {
 	uri, err := url.ParseRequestURI(host)
 	if err != nil {
 		return "", err
@@ -347,7 +697,7 @@ func createHostURL(host string, port int) (string, error) {
 	if uri.Port() == "" && port != 0 {
 		uri.Host += fmt.Sprintf(":%d", port)
 	}
-	uri.Path = "/dataset"
+	uri.Path = path
 
 	return uri.String(), nil
 }
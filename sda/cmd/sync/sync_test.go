@@ -1,22 +1,38 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path"
+	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
-	"github.com/neicnordic/sensitive-data-archive/internal/config"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/neicnordic/crypt4gh/keys"
+	"github.com/neicnordic/crypt4gh/model/headers"
+	"github.com/neicnordic/crypt4gh/streaming"
+	syncconf "github.com/neicnordic/sensitive-data-archive/cmd/sync/config"
+	brokerv2 "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/broker/v2/memory"
 	"github.com/neicnordic/sensitive-data-archive/internal/database"
 	"github.com/neicnordic/sensitive-data-archive/internal/database/postgres"
+	"github.com/neicnordic/sensitive-data-archive/internal/helper"
+	"github.com/neicnordic/sensitive-data-archive/internal/schema"
+	"github.com/neicnordic/sensitive-data-archive/internal/userauth"
 	"github.com/ory/dockertest/v3"
 	"github.com/ory/dockertest/v3/docker"
 	log "github.com/sirupsen/logrus"
@@ -149,8 +165,7 @@ func (s *SyncTest) TestBuildSyncDatasetJSON() {
 	s.SetupTest()
 	defer os.RemoveAll(s.keyPath)
 
-	var err error
-	db, err = postgres.NewPostgresSQLDatabase(
+	db, err := postgres.NewPostgresSQLDatabase(
 		postgres.Host("localhost"),
 		postgres.Port(dbPort),
 		postgres.User("postgres"),
@@ -178,20 +193,15 @@ func (s *SyncTest) TestBuildSyncDatasetJSON() {
 	assert.NoError(s.T(), db.MapFileToDataset(context.Background(), "cd532362-e06e-4461-8490-b9ce64b8d9e7", fileID), "failed to map file to dataset")
 
 	m := []byte(`{"type":"mapping", "dataset_id": "cd532362-e06e-4461-8490-b9ce64b8d9e7", "accession_ids": ["ed6af454-d910-49e3-8cda-488a6f246e67"]}`)
-	jsonData, err := buildSyncDatasetJSON(context.Background(), m)
+	app := Sync{db: db}
+	jsonData, err := app.buildSyncDatasetJSON(context.Background(), m)
 	assert.NoError(s.T(), err)
 	dataset := []byte(`{"dataset_id":"cd532362-e06e-4461-8490-b9ce64b8d9e7","dataset_files":[{"filepath":"dummy.user/test/file1.c4gh","file_id":"ed6af454-d910-49e3-8cda-488a6f246e67","sha256":"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}],"user":"dummy.user"}`)
 	assert.Equal(s.T(), string(dataset), string(jsonData))
 }
 
 func (s *SyncTest) TestCreateHostURL() {
-	conf = &config.Config{}
-	conf.Sync = config.Sync{
-		RemoteHost: "http://localhost",
-		RemotePort: 443,
-	}
-
-	h, err := createHostURL(conf.Sync.RemoteHost, conf.Sync.RemotePort)
+	h, err := createHostURL("http://localhost", 443, "/dataset")
 	assert.NoError(s.T(), err)
 	assert.Equal(s.T(), "http://localhost:443/dataset", h)
 }
@@ -206,25 +216,443 @@ func (s *SyncTest) TestSendPOST() {
 			return
 		}
 
+		// Requests are identified by a request ID and the time they were sent
+		sentAt, err := time.Parse(time.RFC3339, r.Header.Get(requestTimestampHeader))
+		if r.Header.Get(requestIDHeader) == "" || err != nil || time.Since(sentAt) > time.Minute {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
 		w.WriteHeader(http.StatusOK)
 	})
 	ts := httptest.NewServer(r)
 	defer ts.Close()
 
-	conf = &config.Config{}
-	conf.Sync = config.Sync{
-		RemoteHost:     ts.URL,
-		RemoteUser:     "test",
-		RemotePassword: "test",
-	}
+	remote := syncconf.Remote{Host: ts.URL, User: "test", Password: "test"}
 	syncJSON := []byte(`{"user":"test.user@example.com", "dataset_id": "cd532362-e06e-4460-8490-b9ce64b8d9e7", "dataset_files": [{"filepath": "inbox/user/file1.c4gh","file_id": "5fe7b660-afea-4c3a-88a9-3daabf055ebb", "sha256": "82E4e60e7beb3db2e06A00a079788F7d71f75b61a4b75f28c4c942703dabb6d6"}, {"filepath": "inbox/user/file2.c4gh","file_id": "ed6af454-d910-49e3-8cda-488a6f246e76", "sha256": "c967d96e56dec0f0cfee8f661846238b7f15771796ee1c345cae73cd812acc2b"}]}`)
-	err := sendPOST(syncJSON)
+	err := sendPOST(remote, syncJSON)
+	assert.NoError(s.T(), err)
+
+	remote.User = "foo"
+	assert.EqualError(s.T(), sendPOST(remote, syncJSON), "401 Unauthorized")
+}
+
+func (s *SyncTest) TestSendPOST_token() {
+	keyDir := s.T().TempDir()
+	prKeyPath, pubKeyPath, err := helper.MakeFolder(keyDir)
+	assert.NoError(s.T(), err)
+	assert.NoError(s.T(), helper.CreateRSAkeys(prKeyPath, pubKeyPath))
+	validator := userauth.NewValidateFromToken(jwk.NewSet())
+	assert.NoError(s.T(), validator.ReadJwtPubKeyPath(filepath.Join(keyDir, "public-key")))
+
+	var requestIDs []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenStr, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		token, err := validator.ValidateToken(tokenStr)
+		if !ok || err != nil || token.Issuer() != "https://site.example.org" || token.JwtID() != r.Header.Get(requestIDHeader) {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+		requestIDs = append(requestIDs, token.JwtID())
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	remote := syncconf.Remote{
+		Host:            ts.URL,
+		JWTPrivateKey:   filepath.Join(prKeyPath, "rsa"),
+		JWTSignatureAlg: "RS256",
+		JWTIssuer:       "https://site.example.org",
+		JWTAudience:     "https://sync-api.example.org",
+	}
+	syncJSON := []byte(`{"dataset_id": "cd532362-e06e-4460-8490-b9ce64b8d9e7"}`)
+	assert.NoError(s.T(), sendPOST(remote, syncJSON))
+	assert.NoError(s.T(), sendPOST(remote, syncJSON))
+
+	// Every request is signed with a new request ID
+	assert.Len(s.T(), requestIDs, 2)
+	assert.NotEqual(s.T(), requestIDs[0], requestIDs[1])
+
+	remote.JWTIssuer = "https://other.example.org"
+	assert.EqualError(s.T(), sendPOST(remote, syncJSON), "401 Unauthorized")
+}
+
+func (s *SyncTest) TestHandleMessage_ExternalDataset() {
+	syncconf.SetSchemaPath("../../schemas/isolated")
+	syncconf.SetCenterPrefix("prefix")
+	broker := memory.NewMemoryBroker()
+	app := Sync{Broker: broker}
+
+	message := &brokerv2.Message{Body: []byte(`{"type":"mapping", "dataset_id": "external-dataset", "accession_ids": ["ed6af454-d910-49e3-8cda-488a6f246e67"]}`)}
+	callbacks, err := app.handleMessage(context.Background(), message)
+	assert.NoError(s.T(), err)
+	assert.Empty(s.T(), callbacks)
+}
+
+func (s *SyncTest) TestHandleMessage_InvalidMessage() {
+	syncconf.SetSchemaPath("../../schemas/isolated")
+	broker := memory.NewMemoryBroker()
+	app := Sync{Broker: broker}
+
+	message := &brokerv2.Message{Body: []byte(`{"type":"mapping", "dataset_id": "prefix-dataset"}`)}
+	callbacks, err := app.handleMessage(context.Background(), message)
+	assert.NoError(s.T(), err)
+	for _, callback := range callbacks {
+		callback()
+	}
+	assert.Len(s.T(), broker.Messages(brokerv2.ErrorQueue), 1)
+}
+
+type mockSyncDatabase struct {
+	database.Database
+	header   []byte
+	checksum string
+	states   map[string]*database.FileSyncState
+}
+
+func (m *mockSyncDatabase) GetSyncData(_ context.Context, _ string) (*database.SyncData, error) {
+	return &database.SyncData{User: "user", FilePath: "user/file.c4gh", Checksum: m.checksum, DatasetID: "dataset"}, nil
+}
+
+func (m *mockSyncDatabase) GetArchivePathAndLocation(_ context.Context, accessionID string) (string, string, error) {
+	return accessionID, "/archive", nil
+}
+
+func (m *mockSyncDatabase) GetHeaderByAccessionID(_ context.Context, _ string) ([]byte, error) {
+	return m.header, nil
+}
+
+func (m *mockSyncDatabase) GetFileSyncState(_ context.Context, accessionID, destination string) (*database.FileSyncState, error) {
+	state, ok := m.states[accessionID+"/"+destination]
+	if !ok {
+		return nil, nil
+	}
+	stateCopy := *state
+
+	return &stateCopy, nil
+}
+
+func (m *mockSyncDatabase) SetFileSyncState(_ context.Context, state *database.FileSyncState) error {
+	stateCopy := *state
+	m.states[state.AccessionID+"/"+state.Destination] = &stateCopy
+
+	return nil
+}
+
+func (m *mockSyncDatabase) GetUnconfirmedSyncFiles(_ context.Context, destination string) ([]string, error) {
+	var accessionIDs []string
+	for _, state := range m.states {
+		if state.Destination == destination && state.Status == "verified" {
+			accessionIDs = append(accessionIDs, state.AccessionID)
+		}
+	}
+	sort.Strings(accessionIDs)
+
+	return accessionIDs, nil
+}
+
+// mockSyncStorage is an in memory archive and sync storage, writes fail while failWrites is above zero
+type mockSyncStorage struct {
+	files      map[string][]byte
+	writes     int
+	failWrites int
+}
+
+func (m *mockSyncStorage) NewFileReader(_ context.Context, location, filePath string) (io.ReadCloser, error) {
+	content, ok := m.files[location+"/"+filePath]
+	if !ok {
+		return nil, errors.New("file not found")
+	}
+
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+func (m *mockSyncStorage) NewFileReadSeeker(_ context.Context, _, _ string) (io.ReadSeekCloser, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockSyncStorage) FindFile(_ context.Context, _ string) (string, error) {
+	return "", errors.New("not implemented")
+}
+
+func (m *mockSyncStorage) GetFileSize(_ context.Context, location, filePath string) (int64, error) {
+	content, ok := m.files[location+"/"+filePath]
+	if !ok {
+		return 0, errors.New("file not found")
+	}
+
+	return int64(len(content)), nil
+}
+
+func (m *mockSyncStorage) Ping(_ context.Context) error {
+	return nil
+}
+
+func (m *mockSyncStorage) WriteFile(_ context.Context, filePath string, fileContent io.Reader) (string, error) {
+	m.writes++
+	content, err := io.ReadAll(fileContent)
+	if err != nil {
+		return "", err
+	}
+	if m.failWrites > 0 {
+		m.failWrites--
+
+		return "", errors.New("write failed")
+	}
+	m.files["/sync/"+filePath] = content
+
+	return "/sync", nil
+}
+
+func (m *mockSyncStorage) RemoveFile(_ context.Context, location, filePath string) error {
+	delete(m.files, location+"/"+filePath)
+
+	return nil
+}
+
+// newSyncApp returns a Sync with in memory storage and a destination named "remote", and an archived file with the
+// accession id "accession"
+func (s *SyncTest) newSyncApp() (*Sync, *mockSyncDatabase, *mockSyncStorage) {
+	archivePublicKey, archiveKey, err := keys.GenerateKeyPair()
+	assert.NoError(s.T(), err)
+	syncPublicKey, _, err := keys.GenerateKeyPair()
+	assert.NoError(s.T(), err)
+	_, writerKey, err := keys.GenerateKeyPair()
+	assert.NoError(s.T(), err)
+
+	var encrypted bytes.Buffer
+	writer, err := streaming.NewCrypt4GHWriter(&encrypted, writerKey, [][32]byte{archivePublicKey}, nil)
+	assert.NoError(s.T(), err)
+	_, err = writer.Write(bytes.Repeat([]byte("content"), 1000))
+	assert.NoError(s.T(), err)
+	assert.NoError(s.T(), writer.Close())
+	header, err := headers.ReadHeader(&encrypted)
 	assert.NoError(s.T(), err)
 
-	conf.Sync = config.Sync{
-		RemoteHost:     ts.URL,
-		RemoteUser:     "foo",
-		RemotePassword: "bar",
+	db := &mockSyncDatabase{header: header, checksum: "checksum", states: make(map[string]*database.FileSyncState)}
+	store := &mockSyncStorage{files: map[string][]byte{"/archive/accession": encrypted.Bytes()}}
+	syncconf.SetRetry(3, time.Millisecond)
+	dest := &destination{
+		Destination: syncconf.Destination{Name: "remote"},
+		reader:      store,
+		writer:      store,
+		publicKey:   &syncPublicKey,
 	}
-	assert.EqualError(s.T(), sendPOST(syncJSON), "401 Unauthorized")
+
+	return &Sync{ArchiveReader: store, db: db, key: &archiveKey, destinations: []*destination{dest}}, db, store
+}
+
+func (s *SyncTest) TestSyncFile() {
+	app, db, store := s.newSyncApp()
+	dest := app.destinations[0]
+
+	assert.NoError(s.T(), app.syncFile(context.Background(), dest, "accession"))
+	assert.Equal(s.T(), 1, store.writes)
+	state := db.states["accession/remote"]
+	assert.Equal(s.T(), "verified", state.Status)
+	assert.Equal(s.T(), "/sync", state.Location)
+	assert.Equal(s.T(), int64(len(store.files["/sync/user/file.c4gh"])), state.Size)
+	assert.Equal(s.T(), "checksum", state.Checksum)
+	assert.Equal(s.T(), 1, state.Attempts)
+
+	// Files which have already been synced are not copied again
+	assert.NoError(s.T(), app.syncFile(context.Background(), dest, "accession"))
+	assert.Equal(s.T(), 1, store.writes)
+
+	// Files whose content has changed are copied again
+	db.checksum = "other-checksum"
+	assert.NoError(s.T(), app.syncFile(context.Background(), dest, "accession"))
+	assert.Equal(s.T(), 2, store.writes)
+
+	// Files which are missing from the sync storage are copied again
+	delete(store.files, "/sync/user/file.c4gh")
+	assert.NoError(s.T(), app.syncFile(context.Background(), dest, "accession"))
+	assert.Equal(s.T(), 3, store.writes)
+}
+
+func (s *SyncTest) TestSyncFile_retry() {
+	app, db, store := s.newSyncApp()
+	store.failWrites = 2
+
+	assert.NoError(s.T(), app.syncFile(context.Background(), app.destinations[0], "accession"))
+	assert.Equal(s.T(), 3, store.writes)
+	state := db.states["accession/remote"]
+	assert.Equal(s.T(), "verified", state.Status)
+	assert.Equal(s.T(), 3, state.Attempts)
+	assert.Empty(s.T(), state.LastError)
+}
+
+func (s *SyncTest) TestSyncFile_failed() {
+	app, db, store := s.newSyncApp()
+	store.failWrites = 3
+
+	assert.ErrorContains(s.T(), app.syncFile(context.Background(), app.destinations[0], "accession"), "write failed")
+	assert.Equal(s.T(), 3, store.writes)
+	state := db.states["accession/remote"]
+	assert.Equal(s.T(), "pending", state.Status)
+	assert.Equal(s.T(), 3, state.Attempts)
+	assert.Contains(s.T(), state.LastError, "write failed")
+}
+
+func (s *SyncTest) TestHandleMessage_destinations() {
+	syncconf.SetSchemaPath("../../schemas/isolated")
+	syncconf.SetCenterPrefix("prefix")
+	app, db, _ := s.newSyncApp()
+	broker := memory.NewMemoryBroker()
+	app.Broker = broker
+
+	var received []string
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _, _ := r.BasicAuth()
+		received = append(received, user)
+		if user == "failing" {
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer remote.Close()
+
+	// Each destination has its own storage
+	otherStore := &mockSyncStorage{files: make(map[string][]byte)}
+	first := app.destinations[0]
+	first.Remote = syncconf.Remote{Host: remote.URL, User: "first", Password: "pass"}
+	first.DatasetPrefixes = []string{"prefix-a"}
+	second := &destination{
+		Destination: syncconf.Destination{Name: "second", Remote: syncconf.Remote{Host: remote.URL, User: "second", Password: "pass"}, Datasets: []string{"prefix-b-dataset"}},
+		reader:      otherStore,
+		writer:      otherStore,
+		publicKey:   first.publicKey,
+	}
+	catchAll := &destination{
+		Destination: syncconf.Destination{Name: "catch-all", Remote: syncconf.Remote{Host: remote.URL, User: "failing", Password: "pass"}},
+		reader:      otherStore,
+		writer:      otherStore,
+		publicKey:   first.publicKey,
+	}
+	app.destinations = append(app.destinations, second)
+
+	message := &brokerv2.Message{Body: []byte(`{"type":"mapping", "dataset_id": "prefix-b-dataset", "accession_ids": ["accession"]}`)}
+	callbacks, err := app.handleMessage(context.Background(), message)
+	assert.NoError(s.T(), err)
+	assert.Empty(s.T(), callbacks)
+	assert.Equal(s.T(), []string{"second"}, received)
+	assert.Equal(s.T(), "verified", db.states["accession/second"].Status)
+	assert.Nil(s.T(), db.states["accession/remote"])
+	assert.Len(s.T(), otherStore.files, 1)
+
+	// Datasets which are not routed to any destination are not synced
+	received = nil
+	message = &brokerv2.Message{Body: []byte(`{"type":"mapping", "dataset_id": "prefix-c-dataset", "accession_ids": ["accession"]}`)}
+	callbacks, err = app.handleMessage(context.Background(), message)
+	assert.NoError(s.T(), err)
+	assert.Empty(s.T(), callbacks)
+	assert.Empty(s.T(), received)
+
+	// A failing destination does not stop the dataset from being synced to the other destinations
+	app.destinations = append(app.destinations, catchAll)
+	message = &brokerv2.Message{Body: []byte(`{"type":"mapping", "dataset_id": "prefix-a-dataset", "accession_ids": ["accession"]}`)}
+	callbacks, err = app.handleMessage(context.Background(), message)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), []string{"first", "failing"}, received)
+	assert.Equal(s.T(), "verified", db.states["accession/remote"].Status)
+	for _, callback := range callbacks {
+		callback()
+	}
+	assert.Len(s.T(), broker.Messages(brokerv2.ErrorQueue), 1)
+}
+
+func (s *SyncTest) TestConfirmDestination() {
+	app, db, _ := s.newSyncApp()
+	broker := memory.NewMemoryBroker()
+	app.Broker = broker
+	dest := app.destinations[0]
+
+	var requested, datasets []string
+	statuses := map[string]string{"ingested": "checksum", "ingesting": "", "mismatch": "other-checksum", "failed": ""}
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request schema.SyncFileStatus
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		var response struct {
+			Files []remoteFileStatus `json:"files"`
+		}
+		for _, file := range request.Files {
+			requested = append(requested, file.FileID)
+			datasets = append(datasets, file.DatasetID)
+			status := file.FileID
+			if status == "mismatch" {
+				status = "ingested"
+			}
+			response.Files = append(response.Files, remoteFileStatus{FileID: file.FileID, Status: status, ShaSum: statuses[file.FileID]})
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	defer remote.Close()
+	dest.Remote = syncconf.Remote{Host: remote.URL, User: "user", Password: "pass"}
+
+	for accessionID := range statuses {
+		db.states[accessionID+"/remote"] = &database.FileSyncState{AccessionID: accessionID, Destination: "remote", Status: "verified", Checksum: "checksum"}
+	}
+	db.states["pending/remote"] = &database.FileSyncState{AccessionID: "pending", Destination: "remote", Status: "pending"}
+
+	assert.NoError(s.T(), app.confirmDestination(context.Background(), dest))
+	assert.Equal(s.T(), []string{"failed", "ingested", "ingesting", "mismatch"}, requested)
+	// The files are requested with their dataset, which the remote site authorizes the request by
+	assert.Equal(s.T(), []string{"dataset", "dataset", "dataset", "dataset"}, datasets)
+	assert.Equal(s.T(), "confirmed", db.states["ingested/remote"].Status)
+	assert.Equal(s.T(), "verified", db.states["ingesting/remote"].Status)
+	assert.Equal(s.T(), "rejected", db.states["mismatch/remote"].Status)
+	assert.Contains(s.T(), db.states["mismatch/remote"].LastError, "does not match")
+	assert.Equal(s.T(), "rejected", db.states["failed/remote"].Status)
+	assert.Len(s.T(), broker.Messages(brokerv2.ErrorQueue), 2)
+
+	// Only the files which have not been confirmed or rejected are requested again
+	requested = nil
+	assert.NoError(s.T(), app.confirmDestination(context.Background(), dest))
+	assert.Equal(s.T(), []string{"ingesting"}, requested)
+}
+
+func (s *SyncTest) TestDestinations() {
+	defer viper.Set("sync.destinations", nil)
+
+	viper.Set("sync.destinations", []map[string]any{
+		{"name": "a", "publicKeyPath": "/a.pub", "remote": map[string]any{"host": "https://a", "port": "8443", "user": "u", "password": "p"}, "datasetPrefixes": []string{"A-"}},
+		{"name": "b", "storage": "sync-b", "publicKeyPath": "/b.pub", "remote": map[string]any{"host": "https://b", "user": "u", "password": "p"}, "datasets": []string{"B-1"}},
+	})
+	destinations, err := syncconf.Destinations()
+	assert.NoError(s.T(), err)
+	assert.Len(s.T(), destinations, 2)
+	assert.Equal(s.T(), "sync", destinations[0].Storage)
+	assert.Equal(s.T(), 8443, destinations[0].Remote.Port)
+	assert.Equal(s.T(), "sync-b", destinations[1].Storage)
+	assert.True(s.T(), destinations[0].Receives("A-1"))
+	assert.False(s.T(), destinations[0].Receives("B-1"))
+	assert.True(s.T(), destinations[1].Receives("B-1"))
+	assert.False(s.T(), destinations[1].Receives("B-2"))
+
+	viper.Set("sync.destinations", []map[string]any{
+		{"name": "a", "publicKeyPath": "/a.pub", "remote": map[string]any{"host": "https://a", "user": "u", "password": "p"}},
+		{"name": "a", "publicKeyPath": "/a.pub", "remote": map[string]any{"host": "https://a", "user": "u", "password": "p"}},
+	})
+	_, err = syncconf.Destinations()
+	assert.ErrorContains(s.T(), err, "not unique")
+
+	viper.Set("sync.destinations", []map[string]any{{"name": "a", "remote": map[string]any{"host": "https://a"}}})
+	_, err = syncconf.Destinations()
+	assert.ErrorContains(s.T(), err, "needs publicKeyPath")
+
+	viper.Set("sync.destinations", []map[string]any{{"name": "a", "publicKeyPath": "/a.pub", "remote": map[string]any{"host": "https://a", "jwtPrivateKey": "/a.key"}}})
+	_, err = syncconf.Destinations()
+	assert.ErrorContains(s.T(), err, "needs remote.user and remote.password")
+
+	viper.Set("sync.destinations", []map[string]any{
+		{"name": "a", "publicKeyPath": "/a.pub", "remote": map[string]any{"host": "https://a", "jwtPrivateKey": "/a.key", "jwtIssuer": "https://site", "jwtAudience": "https://a"}},
+		{"name": "b", "publicKeyPath": "/b.pub", "remote": map[string]any{"host": "https://b", "clientCert": "/b.crt", "clientKey": "/b.key"}},
+	})
+	destinations, err = syncconf.Destinations()
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "RS256", destinations[0].Remote.JWTSignatureAlg)
 }
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/neicnordic/sensitive-data-archive/internal/config"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/userauth"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// requestIDHeader is the header that requests authenticated by client certificate or basic auth identify
+	// themselves with, requests authenticated by token are identified by the jti claim of the token
+	requestIDHeader = "X-Request-ID"
+	// requestTimestampHeader is the header with the time, in RFC 3339 format, at which a request identified by the
+	// request ID header was sent
+	requestTimestampHeader = "X-Request-Timestamp"
+)
+
+var (
+	errUnauthorized            = errors.New("unauthorized")
+	errInvalidRequestTimestamp = errors.New("invalid request timestamp")
+)
+
+// partner is a remote site allowed to sync datasets through the API
+type partner struct {
+	config.SyncAPIPartner
+	tokens *userauth.ValidateFromToken
+	// allDatasets is set for the partner of the requests authenticated with the basic auth credentials, which may
+	// sync all datasets
+	allDatasets bool
+}
+
+// allows reports whether the partner may sync the dataset, requests which have not been authenticated have no partner
+// and may not sync any dataset
+func (p *partner) allows(datasetID string) bool {
+	switch {
+	case p == nil:
+		return false
+	case p.allDatasets:
+		return true
+	}
+
+	return slices.ContainsFunc(p.DatasetPrefixes, func(prefix string) bool {
+		return strings.HasPrefix(datasetID, prefix)
+	})
+}
+
+type partnerKey struct{}
+
+// requestPartner returns the partner which sent the request
+func requestPartner(r *http.Request) *partner {
+	p, _ := r.Context().Value(partnerKey{}).(*partner)
+
+	return p
+}
+
+// authenticator authenticates the requests of the partners by client certificate, signed token or the basic auth
+// credentials, and rejects requests whose request ID has already been received. The request IDs are registered in
+// the database until the requests expire, such that all replicas of the API share them, and are released when the
+// request fails such that it can be retried
+type authenticator struct {
+	partners []*partner
+	// basicAuth is the partner of the requests authenticated with the basic auth credentials
+	basicAuth *partner
+	audience  string
+	window    time.Duration
+
+	db      database.Database
+	nowFunc func() time.Time
+}
+
+func newAuthenticator(conf config.SyncAPIConf, db database.Database) (*authenticator, error) {
+	a := &authenticator{
+		basicAuth: &partner{allDatasets: true},
+		audience:  conf.JWTAudience,
+		window:    conf.ReplayWindow,
+		db:        db,
+		nowFunc:   time.Now,
+	}
+	for _, partnerConf := range conf.Partners {
+		p := &partner{SyncAPIPartner: partnerConf}
+		if partnerConf.JWTIssuer != "" {
+			p.tokens = userauth.NewValidateFromToken(jwk.NewSet())
+			if err := p.tokens.ReadJwtPubKeyPath(partnerConf.JWTPubKeyPath); err != nil {
+				return nil, fmt.Errorf("failed to read public keys of sync API partner: %s, reason: %v", partnerConf.Name, err)
+			}
+		}
+		a.partners = append(a.partners, p)
+	}
+
+	return a, nil
+}
+
+// authenticate serves the request with the handler once the partner has been authenticated and the request has not
+// been received before
+func (a *authenticator) authenticate(handler http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, requestID, expiresAt, err := a.identify(r)
+		switch {
+		case errors.Is(err, errInvalidRequestTimestamp):
+			log.Warnf("rejected request from: %s, reason: %v", r.RemoteAddr, err)
+			respondWithError(w, http.StatusBadRequest, err.Error())
+
+			return
+		case err != nil:
+			log.Warnf("rejected request from: %s, reason: %v", r.RemoteAddr, err)
+			w.Header().Set("WWW-Authenticate", `Basic realm="restricted", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+
+			return
+		case requestID == "":
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("the %s header is required", requestIDHeader))
+
+			return
+		}
+
+		firstSeen, err := a.firstSeen(r.Context(), p, requestID, expiresAt)
+		if err != nil {
+			log.Errorf("failed to register request: %s from: %s, reason: %v", requestID, r.RemoteAddr, err)
+			respondWithError(w, http.StatusInternalServerError, "failed to register the request")
+
+			return
+		}
+		if !firstSeen {
+			log.Warnf("rejected replayed request: %s from: %s", requestID, r.RemoteAddr)
+			respondWithError(w, http.StatusConflict, "the request has already been received")
+
+			return
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), partnerKey{}, p)))
+		if recorder.status < 200 || recorder.status > 299 {
+			// The request was registered before it was handled such that concurrent replays are rejected, a request
+			// which failed can be retried with the same request ID
+			if err := a.db.ReleaseSyncAPIRequest(context.WithoutCancel(r.Context()), p.Name, requestID); err != nil {
+				log.Errorf("failed to release failed request: %s from: %s, reason: %v", requestID, r.RemoteAddr, err)
+			}
+		}
+	})
+}
+
+// statusRecorder records the status code of the response to a request
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// identify returns the partner which sent the request, the ID of the request and when the request expires
+func (a *authenticator) identify(r *http.Request) (*partner, string, time.Time, error) {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		subject := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		for _, p := range a.partners {
+			if p.CertificateSubject != "" && p.CertificateSubject == subject {
+				requestID, expiresAt, err := a.headerRequestID(r)
+
+				return p, requestID, expiresAt, err
+			}
+		}
+
+		return nil, "", time.Time{}, fmt.Errorf("no partner with client certificate: %s", subject)
+	}
+
+	if tokenStr, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return a.identifyToken(tokenStr)
+	}
+
+	if checkBasicAuth(r) {
+		requestID, expiresAt, err := a.headerRequestID(r)
+
+		return a.basicAuth, requestID, expiresAt, err
+	}
+
+	return nil, "", time.Time{}, errUnauthorized
+}
+
+// headerRequestID returns the request ID header of the request and when the request expires. A request with a request
+// ID has to be sent within the replay window of the current time, according to its request timestamp header, and
+// expires once the replay window has passed since it was sent, such that it can not be replayed once its ID has been
+// forgotten
+func (a *authenticator) headerRequestID(r *http.Request) (string, time.Time, error) {
+	requestID := r.Header.Get(requestIDHeader)
+	if requestID == "" {
+		return "", time.Time{}, nil
+	}
+
+	sentAt, err := time.Parse(time.RFC3339, r.Header.Get(requestTimestampHeader))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("%w: the %s header has to be an RFC 3339 timestamp", errInvalidRequestTimestamp, requestTimestampHeader)
+	}
+	now := a.nowFunc()
+	if sentAt.Before(now.Add(-a.window)) || sentAt.After(now.Add(a.window)) {
+		return "", time.Time{}, fmt.Errorf("%w: the request has to be sent within %s of the current time", errInvalidRequestTimestamp, a.window)
+	}
+
+	return requestID, sentAt.Add(a.window), nil
+}
+
+// identifyToken returns the partner which issued the token, the ID of the token and when the token expires. Tokens have
+// to be issued for the audience of the API and may not be valid for longer than the replay window
+func (a *authenticator) identifyToken(tokenStr string) (*partner, string, time.Time, error) {
+	for _, p := range a.partners {
+		if p.tokens == nil {
+			continue
+		}
+		token, err := p.tokens.ValidateToken(tokenStr)
+		if err != nil || token.Issuer() != p.JWTIssuer {
+			continue
+		}
+
+		switch {
+		case !slices.Contains(token.Audience(), a.audience):
+			return nil, "", time.Time{}, fmt.Errorf("token of partner: %s is not issued for audience: %s", p.Name, a.audience)
+		case token.JwtID() == "":
+			return nil, "", time.Time{}, fmt.Errorf("token of partner: %s has no jti", p.Name)
+		case token.Expiration().IsZero() || token.Expiration().After(a.nowFunc().Add(a.window)):
+			return nil, "", time.Time{}, fmt.Errorf("token of partner: %s has to expire within: %s", p.Name, a.window)
+		}
+
+		return p, token.JwtID(), token.Expiration(), nil
+	}
+
+	return nil, "", time.Time{}, errors.New("token not signed by any partner")
+}
+
+// firstSeen registers the request ID of the partner until the request expires, and reports whether it had not been
+// received before
+func (a *authenticator) firstSeen(ctx context.Context, p *partner, requestID string, expiresAt time.Time) (bool, error) {
+	return a.db.RegisterSyncAPIRequest(ctx, p.Name, requestID, expiresAt, a.nowFunc())
+}
+
+// expireRequests deletes the request IDs of expired requests from the database once every replay window, until the
+// context is done
+func (a *authenticator) expireRequests(ctx context.Context) {
+	ticker := time.NewTicker(a.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := a.db.DeleteSyncAPIRequests(ctx, a.nowFunc())
+			if err != nil {
+				log.Errorf("failed to delete expired request IDs, reason: %v", err)
+
+				continue
+			}
+			log.Debugf("deleted %d expired request IDs", deleted)
+		}
+	}
+}
+
+// checkBasicAuth reports whether the request has the basic auth credentials of the API, basic auth is disabled when
+// no credentials are configured
+func checkBasicAuth(r *http.Request) bool {
+	if Conf.SyncAPI.APIUser == "" {
+		return false
+	}
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	usernameHash := sha256.Sum256([]byte(username))
+	passwordHash := sha256.Sum256([]byte(password))
+	expectedUsernameHash := sha256.Sum256([]byte(Conf.SyncAPI.APIUser))
+	expectedPasswordHash := sha256.Sum256([]byte(Conf.SyncAPI.APIPassword))
+
+	usernameMatch := (subtle.ConstantTimeCompare(usernameHash[:], expectedUsernameHash[:]) == 1)
+	passwordMatch := (subtle.ConstantTimeCompare(passwordHash[:], expectedPasswordHash[:]) == 1)
+
+	return usernameMatch && passwordMatch
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/neicnordic/sensitive-data-archive/internal/config"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/helper"
+	"github.com/stretchr/testify/suite"
+)
+
+type AuthTestSuite struct {
+	suite.Suite
+	auth   *authenticator
+	db     *mockRequestDatabase
+	jwtKey jwk.Key
+}
+
+// mockRequestDatabase registers request IDs in memory like the database does
+type mockRequestDatabase struct {
+	database.Database
+	requests map[string]time.Time
+	err      error
+}
+
+func (m *mockRequestDatabase) RegisterSyncAPIRequest(_ context.Context, partner, requestID string, expiresAt, now time.Time) (bool, error) {
+	if m.err != nil {
+		return false, m.err
+	}
+	key := partner + "/" + requestID
+	if registered, ok := m.requests[key]; ok && !registered.Before(now) {
+		return false, nil
+	}
+	m.requests[key] = expiresAt
+
+	return true, nil
+}
+
+func (m *mockRequestDatabase) DeleteSyncAPIRequests(_ context.Context, expiredBefore time.Time) (int64, error) {
+	var deleted int64
+	for key, expiresAt := range m.requests {
+		if expiresAt.Before(expiredBefore) {
+			delete(m.requests, key)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+func (m *mockRequestDatabase) ReleaseSyncAPIRequest(_ context.Context, partner, requestID string) error {
+	delete(m.requests, partner+"/"+requestID)
+
+	return nil
+}
+
+func TestAuthTestSuite(t *testing.T) {
+	suite.Run(t, new(AuthTestSuite))
+}
+
+func (ts *AuthTestSuite) SetupTest() {
+	keyDir := ts.T().TempDir()
+	prKeyPath, pubKeyPath, err := helper.MakeFolder(keyDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(helper.CreateRSAkeys(prKeyPath, pubKeyPath))
+	ts.jwtKey, err = helper.ParsePrivateRSAKey(prKeyPath, "/rsa")
+	ts.Require().NoError(err)
+
+	Conf = &config.Config{}
+	Conf.Broker.SchemasPath = "../../schemas"
+	Conf.SyncAPI = config.SyncAPIConf{
+		APIUser:      "dummy",
+		APIPassword:  "test",
+		JWTAudience:  "https://sync-api.example.org",
+		ReplayWindow: 10 * time.Minute,
+		Partners: []config.SyncAPIPartner{
+			{Name: "site-a", JWTIssuer: "https://site-a.example.org", JWTPubKeyPath: filepath.Join(keyDir, "public-key"), DatasetPrefixes: []string{"SITE-A-"}},
+			{Name: "site-b", CertificateSubject: "sync.site-b.example.org", DatasetPrefixes: []string{"SITE-B-"}},
+		},
+	}
+	ts.db = &mockRequestDatabase{requests: make(map[string]time.Time)}
+	ts.auth, err = newAuthenticator(Conf.SyncAPI, ts.db)
+	ts.Require().NoError(err)
+}
+
+// post sends the metadata of the dataset to the metadata handler, authenticated by the authenticator
+func (ts *AuthTestSuite) post(datasetID string, prepare func(r *http.Request)) int {
+	body := []byte(`{"dataset_id": "` + datasetID + `", "metadata": {"dummy":"data"}}`)
+	r := httptest.NewRequest(http.MethodPost, "/metadata", bytes.NewBuffer(body))
+	prepare(r)
+	w := httptest.NewRecorder()
+	ts.auth.authenticate(metadata)(w, r)
+
+	return w.Code
+}
+
+func (ts *AuthTestSuite) token(claims map[string]any) string {
+	token, err := helper.CreateRSAToken(ts.jwtKey, "RS256", claims)
+	ts.Require().NoError(err)
+
+	return token
+}
+
+func (ts *AuthTestSuite) TestAuthenticate_token() {
+	claims := map[string]any{
+		"iss": "https://site-a.example.org",
+		"aud": "https://sync-api.example.org",
+		"jti": "request-1",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}
+	bearer := func(token string) func(r *http.Request) {
+		return func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+
+	ts.Equal(http.StatusOK, ts.post("SITE-A-00001", bearer(ts.token(claims))))
+
+	// Replayed tokens are rejected
+	ts.Equal(http.StatusConflict, ts.post("SITE-A-00001", bearer(ts.token(claims))))
+
+	// Partners may only sync datasets with their prefixes
+	claims["jti"] = "request-2"
+	ts.Equal(http.StatusForbidden, ts.post("SITE-B-00001", bearer(ts.token(claims))))
+
+	claims["jti"] = "request-3"
+	claims["aud"] = "https://other.example.org"
+	ts.Equal(http.StatusUnauthorized, ts.post("SITE-A-00001", bearer(ts.token(claims))))
+
+	claims["aud"] = "https://sync-api.example.org"
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	ts.Equal(http.StatusUnauthorized, ts.post("SITE-A-00001", bearer(ts.token(claims))))
+
+	delete(claims, "jti")
+	claims["exp"] = time.Now().Add(time.Minute).Unix()
+	ts.Equal(http.StatusUnauthorized, ts.post("SITE-A-00001", bearer(ts.token(claims))))
+
+	claims["jti"] = "request-4"
+	claims["iss"] = "https://site-b.example.org"
+	ts.Equal(http.StatusUnauthorized, ts.post("SITE-A-00001", bearer(ts.token(claims))))
+}
+
+func (ts *AuthTestSuite) TestAuthenticate_certificate() {
+	certificate := func(subject, requestID string) func(r *http.Request) {
+		return func(r *http.Request) {
+			r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: subject}}}}}
+			if requestID != "" {
+				r.Header.Set(requestIDHeader, requestID)
+				r.Header.Set(requestTimestampHeader, time.Now().Format(time.RFC3339))
+			}
+		}
+	}
+
+	ts.Equal(http.StatusOK, ts.post("SITE-B-00001", certificate("sync.site-b.example.org", "request-1")))
+	ts.Equal(http.StatusConflict, ts.post("SITE-B-00001", certificate("sync.site-b.example.org", "request-1")))
+	ts.Equal(http.StatusBadRequest, ts.post("SITE-B-00001", certificate("sync.site-b.example.org", "")))
+	ts.Equal(http.StatusForbidden, ts.post("SITE-A-00001", certificate("sync.site-b.example.org", "request-2")))
+	ts.Equal(http.StatusUnauthorized, ts.post("SITE-B-00001", certificate("sync.other.example.org", "request-3")))
+}
+
+func (ts *AuthTestSuite) TestAuthenticate_basicAuth() {
+	basicAuth := func(requestID string) func(r *http.Request) {
+		return func(r *http.Request) {
+			r.SetBasicAuth("dummy", "test")
+			if requestID != "" {
+				r.Header.Set(requestIDHeader, requestID)
+				r.Header.Set(requestTimestampHeader, time.Now().Format(time.RFC3339))
+			}
+		}
+	}
+
+	// The basic auth credentials may sync all datasets, request IDs are required
+	ts.Equal(http.StatusOK, ts.post("SITE-A-00001", basicAuth("request-1")))
+	ts.Equal(http.StatusOK, ts.post("SITE-B-00001", basicAuth("request-2")))
+	ts.Equal(http.StatusConflict, ts.post("SITE-B-00001", basicAuth("request-2")))
+	ts.Equal(http.StatusBadRequest, ts.post("SITE-A-00001", basicAuth("")))
+
+	// Basic auth is disabled without credentials
+	Conf.SyncAPI.APIUser = ""
+	ts.Equal(http.StatusUnauthorized, ts.post("SITE-A-00001", basicAuth("request-3")))
+}
+
+func (ts *AuthTestSuite) TestAuthenticate_requestTimestamp() {
+	timestamp := func(sentAt string) func(r *http.Request) {
+		return func(r *http.Request) {
+			r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "sync.site-b.example.org"}}}}}
+			r.Header.Set(requestIDHeader, "request-"+sentAt)
+			if sentAt != "" {
+				r.Header.Set(requestTimestampHeader, sentAt)
+			}
+		}
+	}
+
+	// Requests identified by the request ID header have to be sent within the replay window
+	ts.Equal(http.StatusOK, ts.post("SITE-B-00001", timestamp(time.Now().Add(-9*time.Minute).Format(time.RFC3339))))
+	ts.Equal(http.StatusOK, ts.post("SITE-B-00001", timestamp(time.Now().Add(9*time.Minute).Format(time.RFC3339))))
+	ts.Equal(http.StatusBadRequest, ts.post("SITE-B-00001", timestamp(time.Now().Add(-11*time.Minute).Format(time.RFC3339))))
+	ts.Equal(http.StatusBadRequest, ts.post("SITE-B-00001", timestamp(time.Now().Add(11*time.Minute).Format(time.RFC3339))))
+	ts.Equal(http.StatusBadRequest, ts.post("SITE-B-00001", timestamp(fmt.Sprint(time.Now().Unix()))))
+	ts.Equal(http.StatusBadRequest, ts.post("SITE-B-00001", timestamp("")))
+}
+
+func (ts *AuthTestSuite) TestAuthenticate_failedRequest() {
+	certificate := func(r *http.Request) {
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "sync.site-b.example.org"}}}}}
+		r.Header.Set(requestIDHeader, "request-1")
+		r.Header.Set(requestTimestampHeader, time.Now().Format(time.RFC3339))
+	}
+
+	// The request ID of a failed request is released, such that the request can be retried
+	ts.Equal(http.StatusForbidden, ts.post("SITE-A-00001", certificate))
+	ts.Empty(ts.db.requests)
+	ts.Equal(http.StatusOK, ts.post("SITE-B-00001", certificate))
+	ts.Contains(ts.db.requests, "site-b/request-1")
+	ts.Equal(http.StatusConflict, ts.post("SITE-B-00001", certificate))
+}
+
+func (ts *AuthTestSuite) TestAllows() {
+	ts.True(ts.auth.partners[0].allows("SITE-A-00001"))
+	ts.False(ts.auth.partners[0].allows("SITE-B-00001"))
+	ts.True(ts.auth.basicAuth.allows("SITE-B-00001"))
+
+	// Requests which have not been authenticated may not sync any dataset
+	var unauthenticated *partner
+	ts.False(unauthenticated.allows("SITE-A-00001"))
+}
+
+func (ts *AuthTestSuite) TestAuthenticate_databaseError() {
+	ts.db.err = errors.New("database error")
+
+	basicAuth := func(r *http.Request) {
+		r.SetBasicAuth("dummy", "test")
+		r.Header.Set(requestIDHeader, "request-1")
+		r.Header.Set(requestTimestampHeader, time.Now().Format(time.RFC3339))
+	}
+	ts.Equal(http.StatusInternalServerError, ts.post("SITE-A-00001", basicAuth))
+}
+
+func (ts *AuthTestSuite) TestFirstSeen() {
+	now := time.Now()
+	ts.auth.nowFunc = func() time.Time { return now }
+	p := ts.auth.partners[0]
+
+	firstSeen := func(p *partner, requestID string) bool {
+		seen, err := ts.auth.firstSeen(context.TODO(), p, requestID, now.Add(ts.auth.window))
+		ts.Require().NoError(err)
+
+		return seen
+	}
+
+	ts.True(firstSeen(p, "request"))
+	ts.False(firstSeen(p, "request"))
+	ts.True(firstSeen(ts.auth.partners[1], "request"))
+	ts.True(firstSeen(ts.auth.basicAuth, "request"))
+
+	// Request IDs are registered again once the requests have expired
+	now = now.Add(11 * time.Minute)
+	ts.True(firstSeen(p, "request"))
+}
+
+func (ts *AuthTestSuite) TestExpireRequests() {
+	ts.auth.window = 10 * time.Millisecond
+	ts.db.requests["site-a/expired"] = time.Now().Add(-time.Minute)
+	ts.db.requests["site-a/valid"] = time.Now().Add(time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+	defer cancel()
+	ts.auth.expireRequests(ctx)
+
+	ts.Equal(map[string]time.Time{"site-a/valid": ts.db.requests["site-a/valid"]}, ts.db.requests)
+}
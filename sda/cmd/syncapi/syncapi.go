@@ -1,15 +1,17 @@
 package main
 
 import (
-	"crypto/sha256"
-	"crypto/subtle"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -17,6 +19,9 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/neicnordic/sensitive-data-archive/internal/broker"
 	"github.com/neicnordic/sensitive-data-archive/internal/config"
+	configv2 "github.com/neicnordic/sensitive-data-archive/internal/config/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/database/postgres"
 	"github.com/neicnordic/sensitive-data-archive/internal/schema"
 
 	log "github.com/sirupsen/logrus"
@@ -24,6 +29,8 @@ import (
 
 var Conf *config.Config
 var err error
+var db database.Database
+var auth *authenticator
 
 type syncDataset struct {
 	DatasetID    string         `json:"dataset_id"`
@@ -37,11 +44,29 @@ type datasetFiles struct {
 	ShaSum   string `json:"sha256"`
 }
 
+// fileStatus is the ingestion status of a synced file reported to the sending site, Status is one of missing,
+// ingesting, ingested, mismatch or failed
+type fileStatus struct {
+	FileID string `json:"file_id"`
+	Status string `json:"status"`
+	ShaSum string `json:"sha256,omitempty"`
+}
+
 func main() {
+	if err := configv2.Load(); err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
 	Conf, err = config.NewConfig("sync-api")
 	if err != nil {
 		log.Fatal(err)
 	}
+	db, err = postgres.NewPostgresSQLDatabase()
+	if err != nil {
+		log.Fatalf("failed to initialize sda db, due to: %v", err)
+	}
+	if dbSchemaVersion, err := db.SchemaVersion(); err != nil || dbSchemaVersion < 39 {
+		log.Fatal(errors.Join(errors.New("database schema v39 is required"), err))
+	}
 	Conf.API.MQ, err = broker.NewMQ(Conf.Broker)
 	if err != nil {
 		log.Fatal(err)
@@ -55,7 +80,12 @@ func main() {
 		os.Exit(0)
 	}()
 
-	srv := setup(Conf)
+	srv, err := setup(Conf)
+	if err != nil {
+		shutdown()
+		log.Fatal(err)
+	}
+	go auth.expireRequests(context.Background())
 
 	if Conf.API.ServerCert != "" && Conf.API.ServerKey != "" {
 		log.Infof("Web server is ready to receive connections at https://%s:%d", Conf.API.Host, Conf.API.Port)
@@ -72,14 +102,32 @@ func main() {
 	}
 }
 
-func setup(conf *config.Config) *http.Server {
+func setup(conf *config.Config) (*http.Server, error) {
+	auth, err = newAuthenticator(conf.SyncAPI, db)
+	if err != nil {
+		return nil, err
+	}
+
 	r := mux.NewRouter().SkipClean(true)
 
 	r.HandleFunc("/ready", readinessResponse).Methods("GET")
-	r.HandleFunc("/dataset", basicAuth(http.HandlerFunc(dataset))).Methods("POST")
-	r.HandleFunc("/metadata", basicAuth(http.HandlerFunc(metadata))).Methods("POST")
+	r.HandleFunc("/dataset", auth.authenticate(dataset)).Methods("POST")
+	r.HandleFunc("/metadata", auth.authenticate(metadata)).Methods("POST")
+	r.HandleFunc("/files/status", auth.authenticate(filesStatus)).Methods("POST")
 
 	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if conf.SyncAPI.ClientCACert != "" {
+		caCert, err := os.ReadFile(conf.SyncAPI.ClientCACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA certificate, reason: %v", err)
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(caCert) {
+			return nil, errors.New("no certificates found in client CA certificate")
+		}
+		// Partners authenticated by token or basic auth do not have client certificates
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
 
 	srv := &http.Server{
 		Addr:              conf.API.Host + ":" + fmt.Sprint(conf.API.Port),
@@ -92,12 +140,15 @@ func setup(conf *config.Config) *http.Server {
 		ReadHeaderTimeout: 20 * time.Second,
 	}
 
-	return srv
+	return srv, nil
 }
 
 func shutdown() {
 	defer Conf.API.MQ.Channel.Close()
 	defer Conf.API.MQ.Connection.Close()
+	if db != nil {
+		db.Close()
+	}
 }
 
 func readinessResponse(w http.ResponseWriter, _ *http.Request) {
@@ -142,6 +193,14 @@ func dataset(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var blob syncDataset
+	_ = json.Unmarshal(b, &blob)
+	if !requestPartner(r).allows(blob.DatasetID) {
+		respondWithError(w, http.StatusForbidden, fmt.Sprintf("not allowed to sync dataset: %s", blob.DatasetID))
+
+		return
+	}
+
 	if err := parseDatasetMessage(b); err != nil {
 		log.Errorf("error on parsing dataset message: %v", err)
 		respondWithError(w, http.StatusInternalServerError, "error while processing message")
@@ -209,6 +268,83 @@ func parseDatasetMessage(msg []byte) error {
 	return nil
 }
 
+// filesStatus reports the ingestion status of the synced files, so that the sending site can confirm that the files
+// have been ingested with the decrypted checksums they were synced with. Partners may only request the status of files
+// in the datasets they may sync
+func filesStatus(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "failed to read request body")
+
+		return
+	}
+	defer r.Body.Close()
+
+	if err := schema.ValidateJSON(fmt.Sprintf("%s/../bigpicture/file-sync-status.json", Conf.Broker.SchemasPath), b); err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("error on JSON validation: %s", err.Error()))
+
+		return
+	}
+
+	var request schema.SyncFileStatus
+	_ = json.Unmarshal(b, &request)
+
+	p := requestPartner(r)
+	for _, file := range request.Files {
+		if !p.allows(file.DatasetID) {
+			respondWithError(w, http.StatusForbidden, fmt.Sprintf("not allowed to sync dataset: %s", file.DatasetID))
+
+			return
+		}
+	}
+
+	statuses := make([]fileStatus, 0, len(request.Files))
+	for _, file := range request.Files {
+		ingestion, err := db.GetIngestionStatus(r.Context(), file.FileID, file.User, file.FilePath)
+		if err != nil {
+			log.Errorf("failed to get ingestion status of file: %s, reason: %v", file.FileID, err)
+			respondWithError(w, http.StatusInternalServerError, "failed to get ingestion status")
+
+			return
+		}
+		// Files which have been mapped to another dataset than the one in the request are not reported, such that
+		// partners can not learn about files outside the datasets they may sync
+		if ingestion != nil && ingestion.DatasetID != "" && ingestion.DatasetID != file.DatasetID {
+			ingestion = nil
+		}
+		statuses = append(statuses, ingestionStatus(file, ingestion))
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string][]fileStatus{"files": statuses})
+}
+
+// ingestionStatus compares the ingestion of the synced file with what the sending site synced
+func ingestionStatus(file schema.SyncFile, ingestion *database.IngestionStatus) fileStatus {
+	status := fileStatus{FileID: file.FileID}
+	if ingestion == nil {
+		status.Status = "missing"
+
+		return status
+	}
+
+	status.ShaSum = ingestion.DecryptedChecksum
+	switch {
+	case ingestion.Status == "error" || ingestion.Status == "disabled":
+		status.Status = "failed"
+	case ingestion.AccessionID != "" && ingestion.AccessionID != file.FileID:
+		// The file has been ingested under another accession id
+		status.Status = "failed"
+	case ingestion.DecryptedChecksum != "" && !strings.EqualFold(ingestion.DecryptedChecksum, file.ShaSum):
+		status.Status = "mismatch"
+	case ingestion.Status == "ready" && ingestion.AccessionID == file.FileID:
+		status.Status = "ingested"
+	default:
+		status.Status = "ingesting"
+	}
+
+	return status
+}
+
 func respondWithError(w http.ResponseWriter, code int, message string) {
 	respondWithJSON(w, code, map[string]string{"error": message})
 }
@@ -240,29 +376,13 @@ func metadata(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-}
-
-func basicAuth(auth http.HandlerFunc) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		username, password, ok := r.BasicAuth()
-		if ok {
-			usernameHash := sha256.Sum256([]byte(username))
-			passwordHash := sha256.Sum256([]byte(password))
-			expectedUsernameHash := sha256.Sum256([]byte(Conf.SyncAPI.APIUser))
-			expectedPasswordHash := sha256.Sum256([]byte(Conf.SyncAPI.APIPassword))
-
-			usernameMatch := (subtle.ConstantTimeCompare(usernameHash[:], expectedUsernameHash[:]) == 1)
-			passwordMatch := (subtle.ConstantTimeCompare(passwordHash[:], expectedPasswordHash[:]) == 1)
-
-			if usernameMatch && passwordMatch {
-				auth.ServeHTTP(w, r)
+	var blob schema.SyncMetadata
+	_ = json.Unmarshal(b, &blob)
+	if !requestPartner(r).allows(blob.DatasetID) {
+		respondWithError(w, http.StatusForbidden, fmt.Sprintf("not allowed to sync dataset: %s", blob.DatasetID))
 
-				return
-			}
-		}
+		return
+	}
 
-		w.Header().Set("WWW-Authenticate", `Basic realm="restricted", charset="UTF-8"`)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-	})
+	w.WriteHeader(http.StatusOK)
 }
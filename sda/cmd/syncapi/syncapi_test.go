@@ -3,6 +3,8 @@ package main
 import (
 	"bytes"
 	"context"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -13,6 +15,8 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/neicnordic/sensitive-data-archive/internal/broker"
 	"github.com/neicnordic/sensitive-data-archive/internal/config"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/schema"
 	"github.com/ory/dockertest/v3"
 	"github.com/ory/dockertest/v3/docker"
 	"github.com/spf13/viper"
@@ -133,7 +137,8 @@ func (s *SyncAPITest) TestSetup() {
 	assert.Equal(s.T(), mqPort, conf.Broker.Port)
 	assert.Equal(s.T(), mqPort, viper.GetInt("broker.port"))
 
-	server := setup(conf)
+	server, err := setup(conf)
+	assert.NoError(s.T(), err)
 	assert.Equal(s.T(), "0.0.0.0:8080", server.Addr)
 }
 
@@ -211,7 +216,7 @@ func (s *SyncAPITest) TestDatasetRoute() {
 	Conf.Broker.SchemasPath = "../../schemas/isolated/"
 
 	r := mux.NewRouter()
-	r.HandleFunc("/dataset", dataset)
+	r.HandleFunc("/dataset", withBasicAuthPartner(dataset))
 	ts := httptest.NewServer(r)
 	defer ts.Close()
 
@@ -233,7 +238,7 @@ func (s *SyncAPITest) TestMetadataRoute() {
 	Conf.Broker.SchemasPath = "../../schemas"
 
 	r := mux.NewRouter()
-	r.HandleFunc("/metadata", metadata)
+	r.HandleFunc("/metadata", withBasicAuthPartner(metadata))
 	ts := httptest.NewServer(r)
 	defer ts.Close()
 
@@ -254,12 +259,15 @@ func (s *SyncAPITest) TestBasicAuth() {
 	Conf = &config.Config{}
 	Conf.Broker.SchemasPath = "../../schemas"
 	Conf.SyncAPI = config.SyncAPIConf{
-		APIUser:     "dummy",
-		APIPassword: "test",
+		APIUser:      "dummy",
+		APIPassword:  "test",
+		ReplayWindow: time.Minute,
 	}
 
+	auth, err := newAuthenticator(Conf.SyncAPI, &mockRequestDatabase{requests: make(map[string]time.Time)})
+	assert.NoError(s.T(), err)
 	r := mux.NewRouter()
-	r.HandleFunc("/metadata", basicAuth(metadata))
+	r.HandleFunc("/metadata", auth.authenticate(metadata))
 	ts := httptest.NewServer(r)
 	defer ts.Close()
 
@@ -267,6 +275,8 @@ func (s *SyncAPITest) TestBasicAuth() {
 	req, err := http.NewRequest("POST", ts.URL+"/metadata", bytes.NewBuffer(goodJSON))
 	assert.NoError(s.T(), err)
 	req.SetBasicAuth(Conf.SyncAPI.APIUser, Conf.SyncAPI.APIPassword)
+	req.Header.Set(requestIDHeader, "request-1")
+	req.Header.Set(requestTimestampHeader, time.Now().Format(time.RFC3339))
 	good, err := ts.Client().Do(req) // #nosec G704 -- request controlled by unit test
 	assert.NoError(s.T(), err)
 	assert.Equal(s.T(), http.StatusOK, good.StatusCode)
@@ -278,3 +288,99 @@ func (s *SyncAPITest) TestBasicAuth() {
 	assert.Equal(s.T(), http.StatusUnauthorized, bad.StatusCode)
 	defer bad.Body.Close()
 }
+
+// withBasicAuthPartner serves the request with the handler as if it was authenticated with the basic auth credentials
+func withBasicAuthPartner(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r.WithContext(context.WithValue(r.Context(), partnerKey{}, &partner{allDatasets: true})))
+	}
+}
+
+type mockSyncAPIDatabase struct {
+	database.Database
+	statuses map[string]*database.IngestionStatus
+}
+
+func (m *mockSyncAPIDatabase) GetIngestionStatus(_ context.Context, accessionID, _, _ string) (*database.IngestionStatus, error) {
+	if accessionID == "error-accession" {
+		return nil, errors.New("database error")
+	}
+
+	return m.statuses[accessionID], nil
+}
+
+func (s *SyncAPITest) TestFilesStatusRoute() {
+	Conf = &config.Config{}
+	Conf.Broker.SchemasPath = "../../schemas/isolated/"
+	db = &mockSyncAPIDatabase{statuses: map[string]*database.IngestionStatus{
+		"5fe7b660-afea-4c3a-88a9-3daabf055ebb": {AccessionID: "5fe7b660-afea-4c3a-88a9-3daabf055ebb", Status: "ready", DecryptedChecksum: "82e4e60e7beb3db2e06a00a079788f7d71f75b61a4b75f28c4c942703dabb6d6"},
+	}}
+	defer func() { db = nil }()
+
+	// The requests are sent by a partner which may sync the SITE-A- datasets
+	sender := &partner{SyncAPIPartner: config.SyncAPIPartner{Name: "site-a", DatasetPrefixes: []string{"SITE-A-"}}}
+	r := mux.NewRouter()
+	r.HandleFunc("/files/status", func(w http.ResponseWriter, r *http.Request) {
+		filesStatus(w, r.WithContext(context.WithValue(r.Context(), partnerKey{}, sender)))
+	})
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	goodJSON := []byte(`{"files": [{"user": "test.user@example.com", "filepath": "inbox/user/file-1.c4gh", "file_id": "5fe7b660-afea-4c3a-88a9-3daabf055ebb", "dataset_id": "SITE-A-DATASET-1", "sha256": "82E4e60e7beb3db2e06A00a079788F7d71f75b61a4b75f28c4c942703dabb6d6"}, {"user": "test.user@example.com", "filepath": "inbox/user/file2.c4gh", "file_id": "ed6af454-d910-49e3-8cda-488a6f246e76", "dataset_id": "SITE-A-DATASET-1", "sha256": "c967d96e56dec0f0cfee8f661846238b7f15771796ee1c345cae73cd812acc2b"}]}`)
+	good, err := http.Post(ts.URL+"/files/status", "application/json", bytes.NewBuffer(goodJSON))
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), http.StatusOK, good.StatusCode)
+	body, err := io.ReadAll(good.Body)
+	assert.NoError(s.T(), err)
+	assert.JSONEq(s.T(), `{"files": [{"file_id": "5fe7b660-afea-4c3a-88a9-3daabf055ebb", "status": "ingested", "sha256": "82e4e60e7beb3db2e06a00a079788f7d71f75b61a4b75f28c4c942703dabb6d6"}, {"file_id": "ed6af454-d910-49e3-8cda-488a6f246e76", "status": "missing"}]}`, string(body))
+	defer good.Body.Close()
+
+	badJSON := []byte(`{"files": []}`)
+	bad, err := http.Post(ts.URL+"/files/status", "application/json", bytes.NewBuffer(badJSON))
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), http.StatusBadRequest, bad.StatusCode)
+	defer bad.Body.Close()
+
+	// Partners may only request the status of files in the datasets they may sync
+	forbiddenJSON := []byte(`{"files": [{"user": "test.user@example.com", "filepath": "inbox/user/file-1.c4gh", "file_id": "5fe7b660-afea-4c3a-88a9-3daabf055ebb", "dataset_id": "SITE-B-DATASET-1", "sha256": "82E4e60e7beb3db2e06A00a079788F7d71f75b61a4b75f28c4c942703dabb6d6"}]}`)
+	forbidden, err := http.Post(ts.URL+"/files/status", "application/json", bytes.NewBuffer(forbiddenJSON))
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), http.StatusForbidden, forbidden.StatusCode)
+	defer forbidden.Body.Close()
+
+	// Files mapped to another dataset than the requested one are not reported
+	db.(*mockSyncAPIDatabase).statuses["5fe7b660-afea-4c3a-88a9-3daabf055ebb"].DatasetID = "SITE-B-DATASET-1"
+	otherJSON := []byte(`{"files": [{"user": "test.user@example.com", "filepath": "inbox/user/file-1.c4gh", "file_id": "5fe7b660-afea-4c3a-88a9-3daabf055ebb", "dataset_id": "SITE-A-DATASET-1", "sha256": "82E4e60e7beb3db2e06A00a079788F7d71f75b61a4b75f28c4c942703dabb6d6"}]}`)
+	other, err := http.Post(ts.URL+"/files/status", "application/json", bytes.NewBuffer(otherJSON))
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), http.StatusOK, other.StatusCode)
+	body, err = io.ReadAll(other.Body)
+	assert.NoError(s.T(), err)
+	assert.JSONEq(s.T(), `{"files": [{"file_id": "5fe7b660-afea-4c3a-88a9-3daabf055ebb", "status": "missing"}]}`, string(body))
+	defer other.Body.Close()
+
+	failingJSON := []byte(`{"files": [{"user": "test.user@example.com", "filepath": "inbox/user/file-1.c4gh", "file_id": "error-accession", "dataset_id": "SITE-A-DATASET-1", "sha256": "82E4e60e7beb3db2e06A00a079788F7d71f75b61a4b75f28c4c942703dabb6d6"}]}`)
+	failing, err := http.Post(ts.URL+"/files/status", "application/json", bytes.NewBuffer(failingJSON))
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), http.StatusInternalServerError, failing.StatusCode)
+	defer failing.Body.Close()
+}
+
+func (s *SyncAPITest) TestIngestionStatus() {
+	file := schema.SyncFile{FileID: "accession", ShaSum: "ABCD"}
+
+	for _, test := range []struct {
+		ingestion *database.IngestionStatus
+		expected  string
+	}{
+		{nil, "missing"},
+		{&database.IngestionStatus{Status: "uploaded"}, "ingesting"},
+		{&database.IngestionStatus{Status: "verified", DecryptedChecksum: "abcd"}, "ingesting"},
+		{&database.IngestionStatus{AccessionID: "accession", Status: "ready", DecryptedChecksum: "abcd"}, "ingested"},
+		{&database.IngestionStatus{AccessionID: "accession", Status: "ready", DecryptedChecksum: "other"}, "mismatch"},
+		{&database.IngestionStatus{AccessionID: "other", Status: "ready", DecryptedChecksum: "abcd"}, "failed"},
+		{&database.IngestionStatus{Status: "error"}, "failed"},
+	} {
+		assert.Equal(s.T(), test.expected, ingestionStatus(file, test.ingestion).Status)
+	}
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+
+	config "github.com/neicnordic/sensitive-data-archive/internal/config/v2"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var (
+	sourceQueue   string
+	verifiedQueue string
+	schemaPath    string
+	concurrency   int
+)
+
+func init() {
+	config.RegisterFlags(
+		&config.Flag{
+			Name: "sourceQueue",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "archived", "The queue where the verify service consumes archived messages from")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				sourceQueue = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "verifiedQueue",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "verified", "The queue where the verify service publishes verified messages to")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				verifiedQueue = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "concurrency",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Int(flagName, 4, "Amount of parts of an archived file to fetch and decrypt in parallel when verifying it, 1 verifies the file sequentially")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				concurrency = viper.GetInt(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "schemaType",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "isolated", "Path to JSON schemas to validate rabbitmq messages against")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				schemaType := viper.GetString("schemaType")
+				switch schemaType {
+				case "federated":
+					schemaPath = "/schemas/federated/"
+				case "isolated":
+					schemaPath = "/schemas/isolated/"
+				default:
+					panic(fmt.Sprintf("schema.type '%s' not supported, needs: <federated|isolated>", schemaType))
+				}
+			},
+		},
+	)
+}
+
+func SourceQueue() string {
+	return sourceQueue
+}
+
+func VerifiedQueue() string {
+	return verifiedQueue
+}
+
+func Concurrency() int {
+	return concurrency
+}
+
+func SetConcurrency(c int) {
+	concurrency = c
+}
+
+func SchemaPath() string {
+	return schemaPath
+}
+
+func SetSchemaPath(path string) {
+	schemaPath = path
+}
@@ -14,141 +14,116 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
 
+	"github.com/neicnordic/crypt4gh/model/body"
 	"github.com/neicnordic/crypt4gh/model/headers"
 	"github.com/neicnordic/crypt4gh/streaming"
-	"github.com/neicnordic/sensitive-data-archive/internal/broker"
+	verifyconf "github.com/neicnordic/sensitive-data-archive/cmd/verify/config"
+	brokerv2 "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/broker/v2/factory"
 	"github.com/neicnordic/sensitive-data-archive/internal/config"
 	configv2 "github.com/neicnordic/sensitive-data-archive/internal/config/v2"
 	"github.com/neicnordic/sensitive-data-archive/internal/database"
 	"github.com/neicnordic/sensitive-data-archive/internal/database/postgres"
 	"github.com/neicnordic/sensitive-data-archive/internal/schema"
 	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2"
-	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	"golang.org/x/crypto/chacha20poly1305"
 
 	log "github.com/sirupsen/logrus"
 )
 
-var (
+type Verify struct {
+	ArchiveReader  storage.Reader
+	ArchiveKeyList []*[32]byte
+	Broker         brokerv2.Broker
 	db             database.Database
-	mqBroker       *broker.AMQPBroker
-	archiveReader  storage.Reader
-	archiveKeyList []*[32]byte
-)
+}
 
 func main() {
 	if err := run(); err != nil {
 		log.Fatal(err)
 	}
 }
+
 func run() error {
+	var err error
+	app := Verify{}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	if err := configv2.Load(); err != nil {
+	if err = configv2.Load(); err != nil {
 		return fmt.Errorf("failed to load config: %v", err)
 	}
-
-	conf, err := config.NewConfig("verify")
-	if err != nil {
-		return fmt.Errorf("failed to load config, due to: %v", err)
+	if verifyconf.Concurrency() < 1 {
+		return errors.New("concurrency needs to be at least 1")
 	}
-	db, err = postgres.NewPostgresSQLDatabase()
-	if err != nil {
-		return fmt.Errorf("failed to initialize sda db, due to: %v", err)
-	}
-	defer db.Close()
 
-	if dbSchemaVersion, err := db.SchemaVersion(); err != nil || dbSchemaVersion < 23 {
-		return errors.Join(errors.New("database schema v23 is required"), err)
-	}
-	mqBroker, err = broker.NewMQ(conf.Broker)
+	app.Broker, err = factory.NewBroker(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to initialize mq broker, due to: %v", err)
 	}
 	defer func() {
-		if mqBroker == nil {
-			return
-		}
-		if mqBroker.Channel != nil {
-			if err := mqBroker.Channel.Close(); err != nil {
-				log.Errorf("failed to close mq broker channel due to: %v", err)
-			}
-		}
-		if mqBroker.Connection != nil {
-			if err := mqBroker.Connection.Close(); err != nil {
-				log.Errorf("failed to close mq broker connection due to: %v", err)
-			}
+		if err := app.Broker.Close(); err != nil {
+			log.Errorf("could not close Broker, due to: %v", err)
 		}
 	}()
 
-	archiveReader, err = storage.NewReader(ctx, "archive")
+	app.db, err = postgres.NewPostgresSQLDatabase()
+	if err != nil {
+		return fmt.Errorf("failed to initialize sda db, due to: %v", err)
+	}
+	defer app.db.Close()
+	if dbSchemaVersion, err := app.db.SchemaVersion(); err != nil || dbSchemaVersion < 23 {
+		return errors.Join(errors.New("database schema v23 is required"), err)
+	}
+
+	app.ArchiveReader, err = storage.NewReader(ctx, "archive")
 	if err != nil {
 		return fmt.Errorf("failed to initialize archive reader, due to: %v", err)
 	}
-	archiveKeyList, err = config.GetC4GHprivateKeys()
-	if err != nil || len(archiveKeyList) == 0 {
+	app.ArchiveKeyList, err = config.GetC4GHprivateKeys()
+	if err != nil || len(app.ArchiveKeyList) == 0 {
 		return errors.New("no C4GH private keys configured")
 	}
-
-	consumerErr := make(chan error, 1)
 	log.Info("starting verify service")
-	go func() {
-		consumerErr <- startConsumer(ctx)
-	}()
 
 	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc, os.Interrupt, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 
+	consumeErr := make(chan error, 1)
+	go func() {
+		consumeErr <- app.Broker.Subscribe(ctx, verifyconf.SourceQueue(), app.handleMessage)
+	}()
+
 	select {
-	case <-sigc:
-	case err := <-mqBroker.Connection.NotifyClose(make(chan *amqp.Error)):
-		return err
-	case err := <-mqBroker.Channel.NotifyClose(make(chan *amqp.Error)):
-		return err
-	case err := <-consumerErr:
-		return err
-	}
+	case sig := <-sigc:
+		log.Infof("recieved signal: %v, shutting down gracefully", sig)
+		cancel()
 
-	return nil
-}
-func startConsumer(ctx context.Context) error {
-	messages, err := mqBroker.GetMessages(mqBroker.Conf.Queue)
-	if err != nil {
-		return fmt.Errorf("failed to get messages (error: %v) ", err)
-	}
-	for delivered := range messages {
-		handleMessage(ctx, delivered)
-	}
+		return nil
+	case err := <-consumeErr:
+		if !errors.Is(err, context.Canceled) {
+			log.Errorf("failed to consume from %s, due to: %v", verifyconf.SourceQueue(), err)
+			cancel()
+
+			return err
+		}
 
-	return nil
+		return nil
+	}
 }
 
-func handleMessage(ctx context.Context, delivered amqp.Delivery) {
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-	log.Debugf("received a message (correlation-id: %s, message: %s)", delivered.CorrelationId, delivered.Body)
-	err := schema.ValidateJSON(fmt.Sprintf("%s/ingestion-verification.json", mqBroker.Conf.SchemasPath), delivered.Body)
+func (app *Verify) handleMessage(ctx context.Context, delivered *brokerv2.Message) ([]func(), error) {
+	log.Debugf("received a message (correlation-id: %s, message: %s)", delivered.Key, delivered.Body)
+	err := schema.ValidateJSON(fmt.Sprintf("%s/ingestion-verification.json", verifyconf.SchemaPath()), delivered.Body)
 	if err != nil {
-		log.Errorf("validation of incoming message (ingestion-verification) failed, correlation-id: %s, reason: (%s)", delivered.CorrelationId, err.Error())
-		// Send the message to an error queue so it can be analyzed.
-		infoErrorMessage := broker.InfoError{
-			Error:           "Message validation failed",
-			Reason:          err.Error(),
-			OriginalMessage: delivered,
-		}
-
-		body, _ := json.Marshal(infoErrorMessage)
-		if err := mqBroker.SendMessage(delivered.CorrelationId, mqBroker.Conf.Exchange, "error", body); err != nil {
-			log.Errorf("failed to publish message, reason: %v", err)
-		}
-		if err := delivered.Ack(false); err != nil {
-			log.Errorf("failed to Ack message, reason: %v", err)
-		}
+		log.Errorf("validation of incoming message (ingestion-verification) failed, correlation-id: %s, reason: (%s)", delivered.Key, err.Error())
 
-		// Restart on new message
-		return
+		return []func(){brokerv2.ErrorQueueCallback(app.Broker, delivered, "Message validation failed", err)}, nil
 	}
 
 	var message schema.IngestionVerification
@@ -157,153 +132,56 @@ func handleMessage(ctx context.Context, delivered amqp.Delivery) {
 
 	log.Infof(
 		"Received work (message.correlation-id: %s, file-id: %s, filepath: %s, user: %s)",
-		delivered.CorrelationId, message.FileID, message.FilePath, message.User,
+		delivered.Key, message.FileID, message.FilePath, message.User,
 	)
 
 	// If the file has been canceled by the uploader, don't spend time working on it.
-	status, err := db.GetFileStatus(ctx, message.FileID)
+	status, err := app.db.GetFileStatus(ctx, message.FileID)
 	if err != nil {
-		log.Errorf("failed to get file status, file-id: %s, reason: (%s)", message.FileID, err.Error())
-		// Send the message to an error queue so it can be analyzed.
-		infoErrorMessage := broker.InfoError{
-			Error:           "Getheader failed",
-			Reason:          err.Error(),
-			OriginalMessage: message,
-		}
-
-		body, _ := json.Marshal(infoErrorMessage)
-		if err := mqBroker.SendMessage(message.FileID, mqBroker.Conf.Exchange, "error", body); err != nil {
-			log.Errorf("failed to publish message, reason: (%s)", err.Error())
-		}
-
-		if err := delivered.Ack(false); err != nil {
-			log.Errorf("Failed acking canceled work, reason: (%s)", err.Error())
-		}
-
-		return
+		return nil, fmt.Errorf("failed to get file status, file-id: %s, reason: %v", message.FileID, err)
 	}
 	if status == "disabled" {
 		log.Infof("file with file-id: %s is disabled, stopping verification", message.FileID)
-		if err := delivered.Ack(false); err != nil {
-			log.Errorf("Failed acking canceled work, reason: (%s)", err.Error())
-		}
 
-		return
+		return nil, nil
 	}
 
-	header, err := db.GetHeader(ctx, message.FileID)
+	header, err := app.db.GetHeader(ctx, message.FileID)
 	if err != nil {
 		log.Errorf("GetHeader failed for file with ID: %v, reason: %v", message.FileID, err.Error())
-		if err := delivered.Ack(false); err != nil {
-			log.Errorf("Failed to nack following getheader error message")
-		}
-		// store full message info in case we want to fix the db entry and retry
-		infoErrorMessage := broker.InfoError{
-			Error:           "Getheader failed",
-			Reason:          err.Error(),
-			OriginalMessage: message,
-		}
 
-		body, _ := json.Marshal(infoErrorMessage)
-
-		// Send the message to an error queue so it can be analyzed.
-		if err := mqBroker.SendMessage(message.FileID, mqBroker.Conf.Exchange, "error", body); err != nil {
-			log.Errorf("failed to publish message, reason: (%s)", err.Error())
-		}
-
-		return
+		// store full message info in case we want to fix the db entry and retry
+		return []func(){brokerv2.ErrorQueueCallback(app.Broker, delivered, "Getheader failed", err)}, nil
 	}
 
-	archiveLocation, err := db.GetArchiveLocation(ctx, message.FileID)
+	archiveLocation, err := app.db.GetArchiveLocation(ctx, message.FileID)
 	if err != nil {
-		log.Errorf("failed to get archive location of file: %s, error: %v", message.FileID, err)
-
-		if err := delivered.Nack(false, true); err != nil {
-			log.Errorf("failed to Nack message, reason: (%s)", err.Error())
-		}
-
-		return
+		return nil, fmt.Errorf("failed to get archive location of file: %s, error: %v", message.FileID, err)
 	}
 	if archiveLocation == "" {
 		log.Errorf("archive location for file: %s, not known in database", message.FileID)
-		jsonMsg, _ := json.Marshal(map[string]string{"error": "archive location for file not known in database"})
-		if err := db.UpdateFileEventLog(ctx, message.FileID, "error", "verify", string(jsonMsg), string(delivered.Body)); err != nil {
-			log.Errorf("failed to set ingestion status for file from message, file-id: %v", message.FileID)
-		}
+		reason := errors.New("archive location for file not known in database")
 
-		// Send the message to an error queue so it can be analyzed.
-		infoErrorMessage := broker.InfoError{
-			Error:           "GetArchiveLocation failed",
-			Reason:          "archive location for file not known in database",
-			OriginalMessage: message,
-		}
-
-		body, _ := json.Marshal(infoErrorMessage)
-		if err := mqBroker.SendMessage(message.FileID, mqBroker.Conf.Exchange, "error", body); err != nil {
-			log.Errorf("failed to publish message, reason: (%s)", err.Error())
-		}
-
-		if err := delivered.Ack(false); err != nil {
-			log.Errorf("Failed acking canceled work, reason: (%s)", err.Error())
-		}
-
-		return
+		return []func(){
+			brokerv2.ErrorQueueCallback(app.Broker, delivered, "GetArchiveLocation failed", reason),
+			app.setErrorEvent(message.FileID, reason.Error(), delivered),
+		}, nil
 	}
 
 	file := new(database.FileInfo)
-	file.Size, err = archiveReader.GetFileSize(ctx, archiveLocation, message.ArchivePath)
-	if err != nil { //nolint:nestif
-		log.Errorf("Failed to get archived file size, file-id: %s, archive-path: %s, reason: (%s)", message.FileID, message.ArchivePath, err.Error())
-		if strings.Contains(err.Error(), "no such file or directory") || strings.Contains(err.Error(), "NoSuchKey:") || strings.Contains(err.Error(), "NotFound:") {
-			jsonMsg, _ := json.Marshal(map[string]string{"error": err.Error()})
-			if err := db.UpdateFileEventLog(ctx, message.FileID, "error", "verify", string(jsonMsg), string(delivered.Body)); err != nil {
-				log.Errorf("failed to set ingestion status for file from message, file-id: %v", message.FileID)
-			}
-		}
-
-		if err := delivered.Ack(false); err != nil {
-			log.Errorf("Failed to Ack message, reason: (%s)", err.Error())
-		}
-
-		// Send the message to an error queue so it can be analyzed.
-		fileError := broker.InfoError{
-			Error:           "Failed to get archived file size",
-			Reason:          err.Error(),
-			OriginalMessage: message,
-		}
-		body, _ := json.Marshal(fileError)
-		if err := mqBroker.SendMessage(message.FileID, mqBroker.Conf.Exchange, "error", body); err != nil {
-			log.Errorf("failed to publish message, reason: (%s)", err.Error())
-		}
-
-		return
-	}
-
-	archiveFileHash := sha256.New()
-	f, err := archiveReader.NewFileReader(ctx, archiveLocation, message.ArchivePath)
+	file.Size, err = app.ArchiveReader.GetFileSize(ctx, archiveLocation, message.ArchivePath)
 	if err != nil {
-		log.Errorf("Failed to open archived file, file-id: %s, reason: %v ", message.FileID, err.Error())
-		// Send the message to an error queue so it can be analyzed.
-		infoErrorMessage := broker.InfoError{
-			Error:           "Failed to open archived file",
-			Reason:          err.Error(),
-			OriginalMessage: message,
-		}
-
-		body, _ := json.Marshal(infoErrorMessage)
-		if err := mqBroker.SendMessage(message.FileID, mqBroker.Conf.Exchange, "error", body); err != nil {
-			log.Errorf("failed to publish message, reason: (%s)", err.Error())
+		log.Errorf("Failed to get archived file size, file-id: %s, archive-path: %s, reason: (%s)", message.FileID, message.ArchivePath, err.Error())
+		callbacks := []func(){brokerv2.ErrorQueueCallback(app.Broker, delivered, "Failed to get archived file size", err)}
+		if errors.Is(err, storageerrors.ErrorFileNotFoundInLocation) || strings.Contains(err.Error(), "no such file or directory") || strings.Contains(err.Error(), "NoSuchKey:") || strings.Contains(err.Error(), "NotFound:") {
+			callbacks = append(callbacks, app.setErrorEvent(message.FileID, err.Error(), delivered))
 		}
 
-		// Restart on new message
-		return
+		return callbacks, nil
 	}
-	defer func() {
-		_ = f.Close()
-	}()
 
 	var key *[32]byte
-	for _, k := range archiveKeyList {
+	for _, k := range app.ArchiveKeyList {
 		size, err := headers.EncryptedSegmentSize(header, *k)
 		if (err == nil) && (size != 0) {
 			key = k
@@ -314,190 +192,352 @@ func handleMessage(ctx context.Context, delivered amqp.Delivery) {
 
 	if key == nil {
 		log.Errorf("no matching key found for file, file-id: %s, archive-path: %s", message.FileID, message.ArchivePath)
+		reason := errors.New("no matching key found for file")
 
-		return
+		return []func(){
+			brokerv2.ErrorQueueCallback(app.Broker, delivered, "Failed to decrypt header", reason),
+			app.setErrorEvent(message.FileID, reason.Error(), delivered),
+		}, nil
 	}
 
-	mr := io.MultiReader(bytes.NewReader(header), io.TeeReader(f, archiveFileHash))
-	c4ghr, err := streaming.NewCrypt4GHReader(mr, *key, nil)
+	decryptedHeader, err := headers.NewHeader(bytes.NewReader(header), *key)
+	if err != nil {
+		log.Errorf("failed to decrypt header, file-id: %s, archive-path: %s, reason: %s", message.FileID, message.ArchivePath, err.Error())
+
+		return []func(){
+			brokerv2.ErrorQueueCallback(app.Broker, delivered, "Failed to decrypt header", err),
+			app.setErrorEvent(message.FileID, err.Error(), delivered),
+		}, nil
+	}
+	dataKeys, err := decryptedHeader.GetDataEncryptionParameterHeaderPackets()
 	if err != nil {
-		log.Errorf("failed to open c4gh decryptor stream, file-id: %s, archive-path: %s, reason: %s", message.FileID, message.ArchivePath, err.Error())
+		log.Errorf("failed to get data encryption parameters, file-id: %s, archive-path: %s, reason: %s", message.FileID, message.ArchivePath, err.Error())
 
-		return
+		return []func(){
+			brokerv2.ErrorQueueCallback(app.Broker, delivered, "Failed to decrypt header", err),
+			app.setErrorEvent(message.FileID, err.Error(), delivered),
+		}, nil
+	}
+
+	// The decrypted content of files with a data edit list is not the concatenation of the decrypted segments, so such
+	// files are always verified sequentially
+	concurrency := verifyconf.Concurrency()
+	if decryptedHeader.GetDataEditListHeaderPacket() != nil {
+		concurrency = 1
+	}
+
+	readers, err := app.openArchivedFile(ctx, archiveLocation, message.ArchivePath, concurrency)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archived file, file-id: %s, reason: %v", message.FileID, err)
 	}
 	defer func() {
-		if err := c4ghr.Close(); err != nil {
-			log.Errorf("failed to close crypt4gh reader, file-id %s, reason: %v", message.FileID, err)
+		for _, r := range readers {
+			_ = r.Close()
 		}
 	}()
 
-	md5hash := md5.New()
-	sha256hash := sha256.New()
-	stream := io.TeeReader(c4ghr, md5hash)
-
-	if file.DecryptedSize, err = io.Copy(sha256hash, stream); err != nil {
+	var checksums verifiedChecksums
+	if concurrency > 1 {
+		readSeekers := make([]io.ReadSeeker, len(readers))
+		for i, r := range readers {
+			readSeekers[i] = r.(io.ReadSeeker)
+		}
+		checksums, err = decryptParallel(ctx, readSeekers, file.Size, *dataKeys, segmentsPerBatch)
+	} else {
+		checksums, err = decryptSequential(readers[0], header, key)
+	}
+	switch {
+	case ctx.Err() != nil:
+		return nil, ctx.Err()
+	case err != nil:
 		log.Errorf("failed to copy decrypted data, file-id: %s, reason: (%s)", message.FileID, err.Error())
 
-		// Send the message to an error queue so it can be analyzed.
-		infoErrorMessage := broker.InfoError{
-			Error:           "Failed to verify archived file",
-			Reason:          err.Error(),
-			OriginalMessage: message,
-		}
+		return []func(){brokerv2.ErrorQueueCallback(app.Broker, delivered, "Failed to verify archived file", err)}, nil
+	}
+	file.DecryptedSize = checksums.decryptedSize
 
-		body, _ := json.Marshal(infoErrorMessage)
-		if err := mqBroker.SendMessage(message.FileID, mqBroker.Conf.Exchange, "error", body); err != nil {
-			log.Errorf("Failed to publish error message, reason: (%s)", err.Error())
-		}
+	// At this point we should do checksum comparison
+	file.ArchivedChecksum = checksums.archivedSHA256
+	file.DecryptedChecksum = checksums.decryptedSHA256
 
-		if err := delivered.Ack(false); err != nil {
-			log.Errorf("Failed to ack message, reason: (%s)", err.Error())
-		}
+	if message.ReVerify {
+		return app.reVerify(ctx, message, file, delivered)
+	}
 
-		return
+	c := schema.IngestionAccessionRequest{
+		User:     message.User,
+		FilePath: message.FilePath,
+		DecryptedChecksums: []schema.Checksums{
+			{Type: "sha256", Value: checksums.decryptedSHA256},
+			{Type: "md5", Value: checksums.decryptedMD5},
+		},
 	}
 
-	// At this point we should do checksum comparison
-	file.ArchivedChecksum = fmt.Sprintf("%x", archiveFileHash.Sum(nil))
-	file.DecryptedChecksum = fmt.Sprintf("%x", sha256hash.Sum(nil))
+	verifiedMessage, _ := json.Marshal(&c)
+	err = schema.ValidateJSON(fmt.Sprintf("%s/ingestion-accession-request.json", verifyconf.SchemaPath()), verifiedMessage)
+	if err != nil {
+		log.Errorf("Validation of outgoing (ingestion-accession-request) failed, file-id: %s, reason: (%s)", message.FileID, err.Error())
 
-	switch {
-	case message.ReVerify:
-		decrypted, err := db.GetDecryptedChecksum(ctx, message.FileID)
-		if err != nil {
-			log.Errorf("failed to get unencrypted checksum for file, file-id: %s, reason: %s", message.FileID, err.Error())
-			if err := delivered.Nack(false, true); err != nil {
-				log.Errorf("failed to Nack message, reason: (%s)", err.Error())
-			}
+		return []func(){brokerv2.ErrorQueueCallback(app.Broker, delivered, "Validation of outgoing message failed", err)}, nil
+	}
 
-			return
-		}
+	// The file might have been disabled while it was being verified
+	status, err = app.db.GetFileStatus(ctx, message.FileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file status, file-id: %s, reason: %v", message.FileID, err)
+	}
+	if status == "disabled" {
+		log.Infof("file with file-id: %s is disabled, stopping verification", message.FileID)
 
-		if file.DecryptedChecksum != decrypted {
-			log.Errorf("encrypted checksum don't match for file, file-id: %s", message.FileID)
-			if err := db.UpdateFileEventLog(ctx, message.FileID, "error", "verify", `{"error":"decrypted checksum don't match"}`, string(delivered.Body)); err != nil {
-				log.Errorf("set status ready failed, file-id: %s, reason: (%v)", message.FileID, err)
-				if err := delivered.Nack(false, true); err != nil {
-					log.Errorf("failed to Nack message, reason: (%v)", err)
-				}
+		return nil, nil
+	}
 
-				return
-			}
-			if err := delivered.Ack(false); err != nil {
-				log.Errorf("Failed to ack message, reason: (%s)", err.Error())
-			}
+	fileInfo, err := app.db.GetFileInfo(ctx, message.FileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get info for file, file-id: %s, reason: %v", message.FileID, err)
+	}
 
-			return
+	if fileInfo.DecryptedChecksum != checksums.decryptedSHA256 {
+		if err := app.db.SetVerified(ctx, file, message.FileID); err != nil {
+			return nil, fmt.Errorf("SetVerified failed, file-id: %s, reason: %v", message.FileID, err)
 		}
+	} else {
+		log.Infof("file is already verified, file-id: %s", message.FileID)
+	}
 
-		if file.ArchivedChecksum != message.EncryptedChecksums[0].Value {
-			log.Errorf("encrypted checksum mismatch for file, file-id: %s, filepath: %s, expected: %s, got: %s", message.FileID, message.FilePath, message.EncryptedChecksums[0].Value, file.ArchivedChecksum)
-			if err := db.UpdateFileEventLog(ctx, message.FileID, "error", "verify", `{"error":"encrypted checksum don't match"}`, string(delivered.Body)); err != nil {
-				log.Errorf("set status ready failed, file-id: %s, reason: (%v)", message.FileID, err)
-				if err := delivered.Nack(false, true); err != nil {
-					log.Errorf("failed to Nack message, reason: (%v)", err)
-				}
+	if err := app.db.UpdateFileEventLog(ctx, message.FileID, "verified", "ingest", "{}", string(verifiedMessage)); err != nil {
+		return nil, fmt.Errorf("failed to set event log status for file, file-id: %s, reason: %v", message.FileID, err)
+	}
 
-				return
-			}
-		}
+	// Send message to verified queue
+	if err := app.Broker.Publish(ctx, verifyconf.VerifiedQueue(), brokerv2.Message{Key: message.FileID, Body: verifiedMessage}); err != nil {
+		return nil, fmt.Errorf("failed to publish message, reason: %v", err)
+	}
+	log.Infof("Successfully verified the file, file-id: %s, filepath: %s", message.FileID, message.FilePath)
 
-		if err := delivered.Ack(false); err != nil {
-			log.Errorf("Failed to ack message, reason: (%s)", err.Error())
+	return nil, nil
+}
+
+// reVerify compares the checksums of a re-verified file with the checksums recorded when it was first verified, a
+// mismatch puts the file in an error state
+func (app *Verify) reVerify(ctx context.Context, message schema.IngestionVerification, file *database.FileInfo, delivered *brokerv2.Message) ([]func(), error) {
+	decrypted, err := app.db.GetDecryptedChecksum(ctx, message.FileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get unencrypted checksum for file, file-id: %s, reason: %v", message.FileID, err)
+	}
+
+	if file.DecryptedChecksum != decrypted {
+		log.Errorf("decrypted checksum don't match for file, file-id: %s", message.FileID)
+		if err := app.db.UpdateFileEventLog(ctx, message.FileID, "error", "verify", `{"error":"decrypted checksum don't match"}`, string(delivered.Body)); err != nil {
+			return nil, fmt.Errorf("set status error failed, file-id: %s, reason: %v", message.FileID, err)
 		}
 
-		return
-	default:
-		c := schema.IngestionAccessionRequest{
-			User:     message.User,
-			FilePath: message.FilePath,
-			DecryptedChecksums: []schema.Checksums{
-				{Type: "sha256", Value: fmt.Sprintf("%x", sha256hash.Sum(nil))},
-				{Type: "md5", Value: fmt.Sprintf("%x", md5hash.Sum(nil))},
-			},
+		return nil, nil
+	}
+
+	if file.ArchivedChecksum != message.EncryptedChecksums[0].Value {
+		log.Errorf("encrypted checksum mismatch for file, file-id: %s, filepath: %s, expected: %s, got: %s", message.FileID, message.FilePath, message.EncryptedChecksums[0].Value, file.ArchivedChecksum)
+		if err := app.db.UpdateFileEventLog(ctx, message.FileID, "error", "verify", `{"error":"encrypted checksum don't match"}`, string(delivered.Body)); err != nil {
+			return nil, fmt.Errorf("set status error failed, file-id: %s, reason: %v", message.FileID, err)
 		}
 
-		verifiedMessage, _ := json.Marshal(&c)
-		err = schema.ValidateJSON(fmt.Sprintf("%s/ingestion-accession-request.json", mqBroker.Conf.SchemasPath), verifiedMessage)
+		return nil, nil
+	}
+	log.Infof("Successfully re-verified the file, file-id: %s, filepath: %s", message.FileID, message.FilePath)
+
+	return nil, nil
+}
+
+func (app *Verify) setErrorEvent(fileID, details string, message *brokerv2.Message) func() {
+	return func() {
+		detailsJSON, err := json.Marshal(map[string]string{"error": details})
 		if err != nil {
-			log.Errorf("Validation of outgoing (ingestion-accession-request) failed, file-id: %s, reason: (%s)", message.FileID, err.Error())
-			// Logging is in ValidateJSON so just restart on new message
-			return
+			log.Errorf("failed to marshal details to JSON, due to: %v", err)
+			detailsJSON = []byte("{}")
 		}
-		status, err := db.GetFileStatus(ctx, message.FileID)
-		if err != nil {
-			log.Errorf("failed to get file status, file-id: %s, reason: (%s)", message.FileID, err.Error())
-			// Send the message to an error queue so it can be analyzed.
-			infoErrorMessage := broker.InfoError{
-				Error:           "Getheader failed",
-				Reason:          err.Error(),
-				OriginalMessage: message,
-			}
+		if err := app.db.UpdateFileEventLog(context.Background(), fileID, "error", "verify", string(detailsJSON), string(message.Body)); err != nil {
+			log.Errorf("failed to set error event for file: %s, due to: %v", fileID, err)
+		}
+	}
+}
 
-			body, _ := json.Marshal(infoErrorMessage)
-			if err := mqBroker.SendMessage(message.FileID, mqBroker.Conf.Exchange, "error", body); err != nil {
-				log.Errorf("failed to publish message, reason: (%s)", err.Error())
-			}
+// segmentsPerBatch is the amount of crypt4gh segments which are fetched and decrypted together when verifying in
+// parallel, about 32MiB of data
+const segmentsPerBatch = 512
 
-			if err := delivered.Ack(false); err != nil {
-				log.Errorf("Failed acking canceled work, reason: (%s)", err.Error())
-			}
+// verifiedChecksums holds the checksums of the archived file and of its decrypted content
+type verifiedChecksums struct {
+	archivedSHA256  string
+	decryptedSHA256 string
+	decryptedMD5    string
+	decryptedSize   int64
+}
 
-			return
+// openArchivedFile opens the archived file with a reader, or with a read seeker for each of the concurrent workers if
+// the file is to be decrypted in parallel
+func (app *Verify) openArchivedFile(ctx context.Context, location, filePath string, concurrency int) ([]io.ReadCloser, error) {
+	if concurrency <= 1 {
+		r, err := app.ArchiveReader.NewFileReader(ctx, location, filePath)
+		if err != nil {
+			return nil, err
 		}
 
-		if status == "disabled" {
-			log.Infof("file with file-id: %s is disabled, stopping verification", message.FileID)
-			if err := delivered.Ack(false); err != nil {
-				log.Errorf("Failed acking canceled work, reason: (%s)", err.Error())
+		return []io.ReadCloser{r}, nil
+	}
+
+	readers := make([]io.ReadCloser, 0, concurrency)
+	for range concurrency {
+		r, err := app.ArchiveReader.NewFileReadSeeker(ctx, location, filePath)
+		if err != nil {
+			for _, opened := range readers {
+				_ = opened.Close()
 			}
 
-			return
+			return nil, err
 		}
+		readers = append(readers, r)
+	}
 
-		fileInfo, err := db.GetFileInfo(ctx, message.FileID)
-		if err != nil {
-			log.Errorf("failed to get info for file, file-id: %s", message.FileID)
-			if err := delivered.Nack(false, true); err != nil {
-				log.Errorf("failed to Nack message, reason: (%s)", err.Error())
-			}
+	return readers, nil
+}
 
-			return
+// decryptSequential decrypts the archived file as a single stream
+func decryptSequential(archivedFile io.Reader, header []byte, key *[32]byte) (verifiedChecksums, error) {
+	archiveFileHash := sha256.New()
+	mr := io.MultiReader(bytes.NewReader(header), io.TeeReader(archivedFile, archiveFileHash))
+	c4ghr, err := streaming.NewCrypt4GHReader(mr, *key, nil)
+	if err != nil {
+		return verifiedChecksums{}, fmt.Errorf("failed to open c4gh decryptor stream, due to: %v", err)
+	}
+	defer func() {
+		if err := c4ghr.Close(); err != nil {
+			log.Errorf("failed to close crypt4gh reader, reason: %v", err)
 		}
+	}()
+
+	md5hash := md5.New()
+	sha256hash := sha256.New()
+	stream := io.TeeReader(c4ghr, md5hash)
+
+	decryptedSize, err := io.Copy(sha256hash, stream)
+	if err != nil {
+		return verifiedChecksums{}, err
+	}
 
-		if fileInfo.DecryptedChecksum != fmt.Sprintf("%x", sha256hash.Sum(nil)) {
-			if err := db.SetVerified(ctx, file, message.FileID); err != nil {
-				log.Errorf("SetVerified failed, file-id: %s, reason: (%s)", message.FileID, err.Error())
-				if err := delivered.Nack(false, true); err != nil {
-					log.Errorf("failed to Nack message, reason: (%s)", err.Error())
-				}
+	return verifiedChecksums{
+		archivedSHA256:  fmt.Sprintf("%x", archiveFileHash.Sum(nil)),
+		decryptedSHA256: fmt.Sprintf("%x", sha256hash.Sum(nil)),
+		decryptedMD5:    fmt.Sprintf("%x", md5hash.Sum(nil)),
+		decryptedSize:   decryptedSize,
+	}, nil
+}
 
+// decryptedBatch is a batch of segments of the archived file together with their decrypted content
+type decryptedBatch struct {
+	encrypted []byte
+	decrypted []byte
+	err       error
+}
+
+// decryptParallel fetches and decrypts batches of segments of the archived file concurrently, with one worker per
+// reader, while the checksums are calculated over the batches in order. At most one batch per worker is kept in memory
+// at any time
+func decryptParallel(ctx context.Context, readers []io.ReadSeeker, size int64, dataKeys []headers.DataEncryptionParametersHeaderPacket, segmentsPerBatch int) (verifiedChecksums, error) {
+	// The workers are waited for after cancelling, so that an early return stops the dispatch of batches
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	batchSize := int64(dataKeys[0].EncryptedSegmentSize * segmentsPerBatch)
+	batches := int((size + batchSize - 1) / batchSize)
+
+	results := make([]chan decryptedBatch, batches)
+	for i := range results {
+		results[i] = make(chan decryptedBatch, 1)
+	}
+
+	// A batch is only dispatched when there is room for it, the room is freed once the batch has been checksummed
+	room := make(chan struct{}, len(readers))
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := range batches {
+			select {
+			case room <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
 				return
 			}
-		} else {
-			log.Infof("file is already verified, file-id: %s", message.FileID)
 		}
+	}()
 
-		if err := db.UpdateFileEventLog(ctx, message.FileID, "verified", "ingest", "{}", string(verifiedMessage)); err != nil {
-			log.Errorf("failed to set event log status for file, file-id: %s", message.FileID)
-			if err := delivered.Nack(false, true); err != nil {
-				log.Errorf("failed to Nack message, reason: (%s)", err.Error())
+	for _, reader := range readers {
+		wg.Go(func() {
+			for i := range jobs {
+				offset := int64(i) * batchSize
+				results[i] <- decryptBatch(reader, offset, min(batchSize, size-offset), dataKeys)
 			}
+		})
+	}
 
-			return
-		}
+	archiveFileHash := sha256.New()
+	md5hash := md5.New()
+	sha256hash := sha256.New()
+	var decryptedSize int64
+	for i := range batches {
+		var batch decryptedBatch
+		select {
+		case batch = <-results[i]:
+		case <-ctx.Done():
+			return verifiedChecksums{}, ctx.Err()
+		}
+		if batch.err != nil {
+			return verifiedChecksums{}, fmt.Errorf("failed to decrypt data at offset: %d, due to: %v", int64(i)*batchSize, batch.err)
+		}
+
+		_, _ = archiveFileHash.Write(batch.encrypted)
+		_, _ = md5hash.Write(batch.decrypted)
+		_, _ = sha256hash.Write(batch.decrypted)
+		decryptedSize += int64(len(batch.decrypted))
+		<-room
+	}
+
+	return verifiedChecksums{
+		archivedSHA256:  fmt.Sprintf("%x", archiveFileHash.Sum(nil)),
+		decryptedSHA256: fmt.Sprintf("%x", sha256hash.Sum(nil)),
+		decryptedMD5:    fmt.Sprintf("%x", md5hash.Sum(nil)),
+		decryptedSize:   decryptedSize,
+	}, nil
+}
 
-		// Send message to verified queue
-		if err := mqBroker.SendMessage(message.FileID, mqBroker.Conf.Exchange, mqBroker.Conf.RoutingKey, verifiedMessage); err != nil {
-			// TODO fix resend mechanism
-			log.Errorf("failed to publish message, reason: (%s)", err.Error())
+// decryptBatch reads the batch of segments at the offset of the archived file, and decrypts them
+func decryptBatch(reader io.ReadSeeker, offset, length int64, dataKeys []headers.DataEncryptionParametersHeaderPacket) decryptedBatch {
+	if _, err := reader.Seek(offset, io.SeekStart); err != nil {
+		return decryptedBatch{err: err}
+	}
+	encrypted := make([]byte, length)
+	if _, err := io.ReadFull(reader, encrypted); err != nil {
+		return decryptedBatch{err: err}
+	}
 
-			return
+	segmentSize := dataKeys[0].EncryptedSegmentSize
+	decrypted := make([]byte, 0, length)
+	for start := 0; start < len(encrypted); start += segmentSize {
+		encryptedSegment := encrypted[start:min(start+segmentSize, len(encrypted))]
+		if len(encryptedSegment) < chacha20poly1305.NonceSize+chacha20poly1305.Overhead {
+			return decryptedBatch{err: errors.New("truncated data segment")}
 		}
-
-		if err := delivered.Ack(false); err != nil {
-			log.Errorf("failed to Ack message, reason: (%s)", err.Error())
+		segment := body.Segment{DataEncryptionParametersHeaderPackets: dataKeys}
+		if err := segment.UnmarshalBinary(encryptedSegment); err != nil {
+			return decryptedBatch{err: err}
 		}
+		decrypted = append(decrypted, segment.UnencryptedData...)
 	}
-	log.Infof("Successfully verified the file, file-id: %s, filepath: %s", message.FileID, message.FilePath)
+
+	return decryptedBatch{encrypted: encrypted, decrypted: decrypted}
 }
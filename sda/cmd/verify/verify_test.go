@@ -1,20 +1,371 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"testing"
+	"time"
 
+	"github.com/neicnordic/crypt4gh/keys"
+	"github.com/neicnordic/crypt4gh/model/headers"
+	"github.com/neicnordic/crypt4gh/streaming"
+	verifyconf "github.com/neicnordic/sensitive-data-archive/cmd/verify/config"
+	brokerv2 "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/broker/v2/memory"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/schema"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/suite"
 )
 
+const testFileID = "c2e3c5b0-6a9d-4d1b-9d4e-3d0c6a1e9f4b"
+
 type TestSuite struct {
 	suite.Suite
+	content []byte
+	body    []byte
+	db      *mockDatabase
+	broker  *memory.Broker
+	app     Verify
 }
 
 func TestConfigTestSuite(t *testing.T) {
 	suite.Run(t, new(TestSuite))
 }
 
+// mockDatabase implements the database functions used by verify, calling any other function panics
+type mockDatabase struct {
+	database.Database
+	header            []byte
+	status            string
+	decryptedChecksum string
+	verified          *database.FileInfo
+	events            []string
+}
+
+func (m *mockDatabase) GetFileStatus(_ context.Context, _ string) (string, error) {
+	return m.status, nil
+}
+
+func (m *mockDatabase) GetHeader(_ context.Context, _ string) ([]byte, error) {
+	return m.header, nil
+}
+
+func (m *mockDatabase) GetArchiveLocation(_ context.Context, _ string) (string, error) {
+	return "/archive", nil
+}
+
+func (m *mockDatabase) GetFileInfo(_ context.Context, _ string) (*database.FileInfo, error) {
+	return &database.FileInfo{DecryptedChecksum: m.decryptedChecksum}, nil
+}
+
+func (m *mockDatabase) GetDecryptedChecksum(_ context.Context, _ string) (string, error) {
+	return m.decryptedChecksum, nil
+}
+
+func (m *mockDatabase) SetVerified(_ context.Context, file *database.FileInfo, _ string) error {
+	m.verified = file
+
+	return nil
+}
+
+func (m *mockDatabase) UpdateFileEventLog(_ context.Context, _, event, _, _, _ string) error {
+	m.events = append(m.events, event)
+
+	return nil
+}
+
+// mockReader serves the files in memory, files are stored by location and file path
+type mockReader struct {
+	files map[string][]byte
+}
+
+func (r *mockReader) file(location, filePath string) ([]byte, error) {
+	content, ok := r.files[location+"/"+filePath]
+	if !ok {
+		return nil, storageerrors.ErrorFileNotFoundInLocation
+	}
+
+	return content, nil
+}
+
+func (r *mockReader) NewFileReader(ctx context.Context, location, filePath string) (io.ReadCloser, error) {
+	return r.NewFileReadSeeker(ctx, location, filePath)
+}
+func (r *mockReader) NewFileReadSeeker(_ context.Context, location, filePath string) (io.ReadSeekCloser, error) {
+	content, err := r.file(location, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return readSeekCloser{bytes.NewReader(content)}, nil
+}
+func (r *mockReader) FindFile(_ context.Context, _ string) (string, error) {
+	return "", errors.New("not implemented")
+}
+func (r *mockReader) GetFileSize(_ context.Context, location, filePath string) (int64, error) {
+	content, err := r.file(location, filePath)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(content)), nil
+}
+func (r *mockReader) Ping(_ context.Context) error { return nil }
+
+type readSeekCloser struct {
+	*bytes.Reader
+}
+
+func (readSeekCloser) Close() error { return nil }
+
+func (ts *TestSuite) SetupSuite() {
+	verifyconf.SetSchemaPath("../../schemas/isolated")
+}
+
 func (ts *TestSuite) SetupTest() {
 	viper.Set("log.level", "debug")
+	verifyconf.SetConcurrency(2)
+
+	ts.content = bytes.Repeat([]byte("archived content"), 10000)
+	header, body, key := ts.encryptFile(ts.content)
+	ts.body = body
+	ts.db = &mockDatabase{header: header, status: "archived"}
+	ts.broker = memory.NewMemoryBroker()
+	ts.app = Verify{
+		ArchiveReader:  &mockReader{files: map[string][]byte{"/archive/archive-path": body}},
+		ArchiveKeyList: []*[32]byte{key},
+		Broker:         ts.broker,
+		db:             ts.db,
+	}
+}
+
+func (ts *TestSuite) verifyMessage(reVerify bool) *brokerv2.Message {
+	body, err := json.Marshal(schema.IngestionVerification{
+		User:               "user",
+		FilePath:           "dummy.c4gh",
+		FileID:             testFileID,
+		ArchivePath:        "archive-path",
+		EncryptedChecksums: []schema.Checksums{{Type: "sha256", Value: fmt.Sprintf("%x", sha256.Sum256(ts.body))}},
+		ReVerify:           reVerify,
+	})
+	ts.Require().NoError(err)
+
+	return &brokerv2.Message{Key: testFileID, Body: body}
+}
+
+func runCallbacks(callbacks []func()) {
+	for _, callback := range callbacks {
+		callback()
+	}
+}
+
+func (ts *TestSuite) TestHandleMessage() {
+	for _, concurrency := range []int{1, 2} {
+		ts.SetupTest()
+		verifyconf.SetConcurrency(concurrency)
+
+		callbacks, err := ts.app.handleMessage(context.TODO(), ts.verifyMessage(false))
+		ts.NoError(err)
+		ts.Empty(callbacks)
+		ts.Require().NotNil(ts.db.verified)
+		ts.Equal(fmt.Sprintf("%x", sha256.Sum256(ts.content)), ts.db.verified.DecryptedChecksum)
+		ts.Equal(int64(len(ts.content)), ts.db.verified.DecryptedSize)
+		ts.Equal([]string{"verified"}, ts.db.events)
+
+		verified := ts.broker.Messages(verifyconf.VerifiedQueue())
+		ts.Require().Len(verified, 1)
+		var accessionRequest schema.IngestionAccessionRequest
+		ts.NoError(json.Unmarshal(verified[0].Body, &accessionRequest))
+		ts.Equal("dummy.c4gh", accessionRequest.FilePath)
+	}
+}
+
+func (ts *TestSuite) TestHandleMessage_InvalidMessage() {
+	message := &brokerv2.Message{Key: testFileID, Body: []byte(`{"file_id": "` + testFileID + `"}`)}
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), message)
+	ts.NoError(err)
+	runCallbacks(callbacks)
+
+	published := ts.broker.Messages(brokerv2.ErrorQueue)
+	ts.Require().Len(published, 1)
+	var infoError schema.InfoError
+	ts.NoError(json.Unmarshal(published[0].Body, &infoError))
+	ts.Equal("Message validation failed", infoError.Error)
+	ts.Nil(ts.db.verified)
+}
+
+func (ts *TestSuite) TestHandleMessage_DisabledFile() {
+	ts.db.status = "disabled"
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), ts.verifyMessage(false))
+	ts.NoError(err)
+	ts.Empty(callbacks)
+	ts.Nil(ts.db.verified)
+	ts.Empty(ts.broker.Messages(verifyconf.VerifiedQueue()))
+}
+
+func (ts *TestSuite) TestHandleMessage_MissingArchivedFile() {
+	ts.app.ArchiveReader = &mockReader{files: map[string][]byte{}}
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), ts.verifyMessage(false))
+	ts.NoError(err)
+	runCallbacks(callbacks)
+	ts.Len(ts.broker.Messages(brokerv2.ErrorQueue), 1)
+	ts.Equal([]string{"error"}, ts.db.events)
+}
+
+func (ts *TestSuite) TestHandleMessage_CorruptedArchivedFile() {
+	corrupted := bytes.Clone(ts.body)
+	corrupted[100] ^= 0xff
+	ts.app.ArchiveReader = &mockReader{files: map[string][]byte{"/archive/archive-path": corrupted}}
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), ts.verifyMessage(false))
+	ts.NoError(err)
+	runCallbacks(callbacks)
+	ts.Len(ts.broker.Messages(brokerv2.ErrorQueue), 1)
+	ts.Nil(ts.db.verified)
+	ts.Empty(ts.broker.Messages(verifyconf.VerifiedQueue()))
+}
+
+func (ts *TestSuite) TestHandleMessage_ReVerify() {
+	ts.db.decryptedChecksum = fmt.Sprintf("%x", sha256.Sum256(ts.content))
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), ts.verifyMessage(true))
+	ts.NoError(err)
+	ts.Empty(callbacks)
+	ts.Empty(ts.db.events)
+	ts.Empty(ts.broker.Messages(verifyconf.VerifiedQueue()))
+}
+
+func (ts *TestSuite) TestHandleMessage_ReVerifyMismatch() {
+	ts.db.decryptedChecksum = fmt.Sprintf("%x", sha256.Sum256([]byte("other content")))
+
+	callbacks, err := ts.app.handleMessage(context.TODO(), ts.verifyMessage(true))
+	ts.NoError(err)
+	ts.Empty(callbacks)
+	ts.Equal([]string{"error"}, ts.db.events)
+}
+
+func (ts *TestSuite) TestSubscribe() {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	ts.NoError(ts.broker.Publish(ctx, "archived", *ts.verifyMessage(false)))
+	done := make(chan error, 1)
+	go func() {
+		done <- ts.broker.Subscribe(ctx, "archived", func(ctx context.Context, message *brokerv2.Message) ([]func(), error) {
+			defer cancel()
+
+			return ts.app.handleMessage(ctx, message)
+		})
+	}()
+
+	ts.ErrorIs(<-done, context.Canceled)
+	ts.Empty(ts.broker.Messages("archived"))
+	ts.Len(ts.broker.Messages(verifyconf.VerifiedQueue()), 1)
+}
+
+// encryptFile encrypts the content with a new key pair, and returns the header, the body and the private key
+func (ts *TestSuite) encryptFile(content []byte) ([]byte, []byte, *[32]byte) {
+	publicKey, privateKey, err := keys.GenerateKeyPair()
+	ts.Require().NoError(err)
+
+	encrypted := &bytes.Buffer{}
+	c4ghWriter, err := streaming.NewCrypt4GHWriter(encrypted, privateKey, [][32]byte{publicKey}, nil)
+	ts.Require().NoError(err)
+	_, err = c4ghWriter.Write(content)
+	ts.Require().NoError(err)
+	ts.Require().NoError(c4ghWriter.Close())
+
+	header, err := headers.ReadHeader(encrypted)
+	ts.Require().NoError(err)
+
+	return header, encrypted.Bytes(), &privateKey
+}
+
+func (ts *TestSuite) dataKeys(header []byte, key *[32]byte) []headers.DataEncryptionParametersHeaderPacket {
+	decryptedHeader, err := headers.NewHeader(bytes.NewReader(header), *key)
+	ts.Require().NoError(err)
+	dataKeys, err := decryptedHeader.GetDataEncryptionParameterHeaderPackets()
+	ts.Require().NoError(err)
+
+	return *dataKeys
+}
+
+func readSeekers(body []byte, amount int) []io.ReadSeeker {
+	readers := make([]io.ReadSeeker, amount)
+	for i := range readers {
+		readers[i] = bytes.NewReader(body)
+	}
+
+	return readers
+}
+
+func (ts *TestSuite) TestDecryptParallel() {
+	for _, size := range []int{0, 100, 65536, 65536*5 + 100, 65536 * 8} {
+		content := make([]byte, size)
+		_, err := rand.Read(content)
+		ts.Require().NoError(err)
+		header, body, key := ts.encryptFile(content)
+
+		expected, err := decryptSequential(bytes.NewReader(body), header, key)
+		ts.Require().NoError(err)
+		ts.Equal(int64(size), expected.decryptedSize)
+
+		checksums, err := decryptParallel(context.TODO(), readSeekers(body, 3), int64(len(body)), ts.dataKeys(header, key), 2)
+		ts.NoError(err, "size: %d", size)
+		ts.Equal(expected, checksums, "size: %d", size)
+	}
+}
+
+func (ts *TestSuite) TestDecryptParallel_CorruptedSegment() {
+	content := make([]byte, 65536*5)
+	_, err := rand.Read(content)
+	ts.Require().NoError(err)
+	header, body, key := ts.encryptFile(content)
+
+	// Flip a byte in the fourth segment
+	body[65564*3+100] ^= 0xff
+
+	_, err = decryptParallel(context.TODO(), readSeekers(body, 2), int64(len(body)), ts.dataKeys(header, key), 2)
+	ts.ErrorContains(err, "failed to decrypt data at offset: 131128")
+}
+
+func (ts *TestSuite) TestDecryptParallel_EarlyCorruptedSegment() {
+	content := make([]byte, 65536*40)
+	_, err := rand.Read(content)
+	ts.Require().NoError(err)
+	header, body, key := ts.encryptFile(content)
+
+	// Flip a byte in the third segment, with many more batches left than there are workers
+	body[65564*2+100] ^= 0xff
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := decryptParallel(context.TODO(), readSeekers(body, 2), int64(len(body)), ts.dataKeys(header, key), 1)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		ts.ErrorContains(err, "failed to decrypt data at offset: 131128")
+	case <-time.After(10 * time.Second):
+		ts.FailNow("decryptParallel did not return after a corrupted segment")
+	}
+}
+
+func (ts *TestSuite) TestDecryptParallel_TruncatedFile() {
+	header, body, key := ts.encryptFile(bytes.Repeat([]byte("content"), 20000))
+
+	_, err := decryptParallel(context.TODO(), readSeekers(body[:len(body)-10], 2), int64(len(body)), ts.dataKeys(header, key), 2)
+	ts.Error(err)
 }
@@ -0,0 +1,46 @@
+package v2
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/schema"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrorQueue is the queue messages which could not be handled are published to
+const ErrorQueue = "error"
+
+// PublishError publishes an InfoError describing why the message could not be handled to the error queue, the
+// original message is included in the InfoError such that it can be analysed and resent
+func PublishError(ctx context.Context, broker Broker, message *Message, errorMessage string, reason error) error {
+	infoError := schema.InfoError{
+		Error:           errorMessage,
+		Reason:          errorMessage,
+		OriginalMessage: message.Body,
+	}
+	if reason != nil {
+		infoError.Reason = reason.Error()
+	}
+
+	body, err := json.Marshal(infoError)
+	if err != nil {
+		return fmt.Errorf("failed to marshal info error, reason: %v", err)
+	}
+
+	return broker.Publish(ctx, ErrorQueue, Message{Key: message.Key, Body: body})
+}
+
+// ErrorQueueCallback returns a callback which publishes an InfoError for the message to the error queue, to be
+// returned by a handleFunc such that the message is acknowledged and the error published once it has been
+func ErrorQueueCallback(broker Broker, message *Message, errorMessage string, reason error) func() {
+	return func() {
+		if err := PublishError(context.Background(), broker, message, errorMessage, reason); err != nil {
+			log.Errorf("failed to publish message: %s to error queue, reason: %v", message.Key, err)
+
+			return
+		}
+		log.Infof("published message: %s to error queue", message.Key)
+	}
+}
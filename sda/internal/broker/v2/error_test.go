@@ -0,0 +1,66 @@
+package v2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockBroker struct {
+	Broker
+	destination string
+	published   Message
+	err         error
+}
+
+func (m *mockBroker) Publish(_ context.Context, destination string, message Message) error {
+	m.destination = destination
+	m.published = message
+
+	return m.err
+}
+
+func TestPublishError(t *testing.T) {
+	broker := &mockBroker{}
+	message := &Message{Key: "correlation-id", Body: []byte(`{"type": "ingest"}`)}
+
+	require.NoError(t, PublishError(context.TODO(), broker, message, "Message validation failed", errors.New("missing properties")))
+	assert.Equal(t, ErrorQueue, broker.destination)
+	assert.Equal(t, "correlation-id", broker.published.Key)
+
+	var infoError struct {
+		schema.InfoError
+		OriginalMessage []byte `json:"original-message"`
+	}
+	require.NoError(t, json.Unmarshal(broker.published.Body, &infoError))
+	assert.Equal(t, "Message validation failed", infoError.Error)
+	assert.Equal(t, "missing properties", infoError.Reason)
+	assert.Equal(t, message.Body, infoError.OriginalMessage)
+
+	// The published message is valid according to the info-error schema
+	assert.NoError(t, schema.ValidateJSON("../../../schemas/isolated/info-error.json", broker.published.Body))
+}
+
+func TestPublishError_NoReason(t *testing.T) {
+	broker := &mockBroker{}
+
+	require.NoError(t, PublishError(context.TODO(), broker, &Message{Key: "correlation-id"}, "File not found", nil))
+
+	var infoError schema.InfoError
+	require.NoError(t, json.Unmarshal(broker.published.Body, &infoError))
+	assert.Equal(t, "File not found", infoError.Reason)
+}
+
+func TestErrorQueueCallback(t *testing.T) {
+	broker := &mockBroker{err: errors.New("broker closed")}
+	callback := ErrorQueueCallback(broker, &Message{Key: "correlation-id"}, "Message validation failed", nil)
+	assert.Empty(t, broker.destination, "nothing is published before the callback is run")
+
+	callback()
+	assert.Equal(t, ErrorQueue, broker.destination)
+}
@@ -0,0 +1,46 @@
+// Package factory creates the broker implementation selected by the broker.type config
+package factory
+
+import (
+	"context"
+	"fmt"
+
+	broker "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/broker/v2/kafka"
+	"github.com/neicnordic/sensitive-data-archive/internal/broker/v2/memory"
+	"github.com/neicnordic/sensitive-data-archive/internal/broker/v2/rabbitmq"
+	"github.com/neicnordic/sensitive-data-archive/internal/config/v2"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var brokerType string
+
+func init() {
+	config.RegisterFlags(
+		&config.Flag{
+			Name: "broker.type",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "rabbitmq", "Type of message broker to use, one of: rabbitmq, kafka, memory. The memory broker is only shared between services running in the same process")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				brokerType = viper.GetString(flagName)
+			},
+		},
+	)
+}
+
+// NewBroker creates a broker of the configured type
+func NewBroker(ctx context.Context) (broker.Broker, error) {
+	switch brokerType {
+	case "rabbitmq":
+		return rabbitmq.NewRabbitMQBroker(ctx)
+	case "kafka":
+		return kafka.NewKafkaBroker(ctx)
+	case "memory":
+		return memory.SharedBroker(), nil
+	default:
+		return nil, fmt.Errorf("broker.type: %s not supported, needs: <rabbitmq|kafka|memory>", brokerType)
+	}
+}
@@ -0,0 +1,247 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/config/v2"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+type options struct {
+	addresses       []string
+	consumerGroup   string
+	ssl             bool
+	caCert          string
+	clientCert      string
+	clientKey       string
+	user            string
+	password        string
+	saslMechanism   string
+	maxDeliveries   int
+	deadLetterTopic string
+	redeliveryDelay time.Duration
+	timeout         time.Duration
+}
+
+var defaultConfig *options
+
+func init() {
+	defaultConfig = new(options)
+	config.RegisterFlags(
+		&config.Flag{
+			Name: "broker.kafka.addresses",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.StringSlice(flagName, []string{"kafka:9092"}, "Addresses, as host:port, of the kafka brokers to bootstrap the connection from")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				defaultConfig.addresses = viper.GetStringSlice(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "broker.kafka.consumer_group",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "Consumer group used when subscribing to a topic, defaults to the name of the topic such that all instances of a service share the messages")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				defaultConfig.consumerGroup = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "broker.kafka.ssl",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Bool(flagName, false, "If to connect to the kafka brokers with tls")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				defaultConfig.ssl = viper.GetBool(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "broker.kafka.ca_cert",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "File path to the ca cert file of the kafka brokers")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				defaultConfig.caCert = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "broker.kafka.client_cert",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "File path to the client cert file used to connect to the kafka brokers")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				defaultConfig.clientCert = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "broker.kafka.client_key",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "File path to the client key file used to connect to the kafka brokers")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				defaultConfig.clientKey = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "broker.kafka.user",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "User used to authenticate with SASL to the kafka brokers, SASL is not used if not set")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				defaultConfig.user = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "broker.kafka.password",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "Password used to authenticate with SASL to the kafka brokers")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				defaultConfig.password = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "broker.kafka.sasl_mechanism",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "scram-sha-512", "SASL mechanism used to authenticate to the kafka brokers, one of: plain, scram-sha-256, scram-sha-512")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				defaultConfig.saslMechanism = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "broker.kafka.max_deliveries",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Int(flagName, 20, "How many times a message is delivered before it is dead lettered when not acknowledged, 0 delivers it until acknowledged")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				defaultConfig.maxDeliveries = viper.GetInt(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "broker.kafka.dead_letter_topic",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "catch_all.dead", "Topic where messages which were delivered max_deliveries times without being acknowledged are published")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				defaultConfig.deadLetterTopic = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "broker.kafka.redelivery_delay",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Duration(flagName, 5*time.Second, "Time to wait before delivering a message which was not acknowledged again. Expects a go time.Duration parsable string")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				defaultConfig.redeliveryDelay = viper.GetDuration(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "broker.kafka.timeout",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Duration(flagName, 10*time.Second, "Timeout when connecting to the kafka brokers. Expects a go time.Duration parsable string")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				defaultConfig.timeout = viper.GetDuration(flagName)
+			},
+		},
+	)
+}
+
+func (cfg *options) clone() *options {
+	return &options{
+		addresses:       append([]string(nil), cfg.addresses...),
+		consumerGroup:   cfg.consumerGroup,
+		ssl:             cfg.ssl,
+		caCert:          cfg.caCert,
+		clientCert:      cfg.clientCert,
+		clientKey:       cfg.clientKey,
+		user:            cfg.user,
+		password:        cfg.password,
+		saslMechanism:   cfg.saslMechanism,
+		maxDeliveries:   cfg.maxDeliveries,
+		deadLetterTopic: cfg.deadLetterTopic,
+		redeliveryDelay: cfg.redeliveryDelay,
+		timeout:         cfg.timeout,
+	}
+}
+
+// setupSASLMechanism returns the configured SASL mechanism, nil if SASL is not to be used
+func (cfg *options) setupSASLMechanism() (sasl.Mechanism, error) {
+	if cfg.user == "" {
+		return nil, nil
+	}
+
+	switch strings.ToLower(cfg.saslMechanism) {
+	case "plain":
+		return plain.Mechanism{Username: cfg.user, Password: cfg.password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, cfg.user, cfg.password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, cfg.user, cfg.password)
+	default:
+		return nil, fmt.Errorf("sasl mechanism: %s not supported, needs: <plain|scram-sha-256|scram-sha-512>", cfg.saslMechanism)
+	}
+}
+
+// setupTLSConfig returns the tls config to connect to the brokers with, nil if tls is not to be used
+func (cfg *options) setupTLSConfig() (*tls.Config, error) {
+	if !cfg.ssl {
+		return nil, nil
+	}
+
+	systemCAs, err := x509.SystemCertPool()
+	if err != nil {
+		log.Errorf("failed to read system CAs: %v", err)
+
+		return nil, err
+	}
+
+	tlsConfig := tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    systemCAs,
+	}
+
+	if cfg.caCert != "" {
+		cacert, err := os.ReadFile(cfg.caCert)
+		if err != nil {
+			return nil, err
+		}
+		if ok := tlsConfig.RootCAs.AppendCertsFromPEM(cacert); !ok {
+			log.Warnln("No certs appended, using system certs only")
+		}
+	}
+
+	if cfg.clientCert != "" && cfg.clientKey != "" {
+		certs, err := tls.LoadX509KeyPair(cfg.clientCert, cfg.clientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = append(tlsConfig.Certificates, certs)
+	}
+
+	return &tlsConfig, nil
+}
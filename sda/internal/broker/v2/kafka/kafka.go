@@ -0,0 +1,301 @@
+// Package kafka implements the broker interface on top of Kafka, queues are
+// mapped to topics and each subscribing service consumes a topic through a
+// consumer group.
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	broker "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
+	"github.com/segmentio/kafka-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// messageReader is the part of the kafka.Reader used by the broker
+type messageReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, messages ...kafka.Message) error
+	Close() error
+}
+
+// messageWriter is the part of the kafka.Writer used by the broker
+type messageWriter interface {
+	WriteMessages(ctx context.Context, messages ...kafka.Message) error
+	Close() error
+}
+
+type kafkaBroker struct {
+	mu sync.Mutex
+
+	dialer    *kafka.Dialer
+	writer    messageWriter
+	readers   []messageReader
+	newReader func(topic string) messageReader
+	closed    bool
+	config    *options
+}
+
+func NewKafkaBroker(ctx context.Context, options ...func(*options)) (broker.Broker, error) {
+	b := &kafkaBroker{
+		config: defaultConfig.clone(),
+	}
+
+	for _, option := range options {
+		option(b.config)
+	}
+
+	if len(b.config.addresses) == 0 {
+		return nil, errors.New("no kafka broker addresses configured")
+	}
+
+	tlsConfig, err := b.config.setupTLSConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup tls config: %v", err)
+	}
+	saslMechanism, err := b.config.setupSASLMechanism()
+	if err != nil {
+		return nil, err
+	}
+
+	b.dialer = &kafka.Dialer{
+		Timeout:       b.config.timeout,
+		DualStack:     true,
+		TLS:           tlsConfig,
+		SASLMechanism: saslMechanism,
+	}
+	b.writer = &kafka.Writer{
+		Addr:         kafka.TCP(b.config.addresses...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		BatchSize:    1,
+		Transport: &kafka.Transport{
+			DialTimeout: b.config.timeout,
+			TLS:         tlsConfig,
+			SASL:        saslMechanism,
+		},
+	}
+	b.newReader = func(topic string) messageReader {
+		groupID := b.config.consumerGroup
+		if groupID == "" {
+			groupID = topic
+		}
+
+		return kafka.NewReader(kafka.ReaderConfig{
+			Brokers: b.config.addresses,
+			GroupID: groupID,
+			Topic:   topic,
+			Dialer:  b.dialer,
+			// Offsets are committed synchronously such that a message is only acknowledged once handled
+			CommitInterval: 0,
+			StartOffset:    kafka.FirstOffset,
+		})
+	}
+
+	if err := b.ping(ctx); err != nil {
+		return nil, fmt.Errorf("failed to connect to kafka: %v", err)
+	}
+
+	return b, nil
+}
+
+func (b *kafkaBroker) Subscribe(ctx context.Context, sourceQueue string, handleFunc func(context.Context, *broker.Message) ([]func(), error)) error {
+	reader := b.newReader(sourceQueue)
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		_ = reader.Close()
+
+		return errors.New("cannot subscribe: broker is closed")
+	}
+	b.readers = append(b.readers, reader)
+	b.mu.Unlock()
+
+	for {
+		message, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+
+			return fmt.Errorf("failed to fetch message from topic: %s, reason: %v", sourceQueue, err)
+		}
+
+		if err := b.handleMessage(ctx, reader, message, handleFunc); err != nil {
+			return err
+		}
+	}
+}
+
+// handleMessage handles a message until it has been acknowledged or dead lettered.
+// As the offset of a partition can not be committed past a message which is not acknowledged the message is
+// redelivered in place, after the redelivery delay, until handleFunc succeeds or max deliveries has been reached,
+// at which point it is published to the dead letter topic.
+func (b *kafkaBroker) handleMessage(ctx context.Context, reader messageReader, message kafka.Message, handleFunc func(context.Context, *broker.Message) ([]func(), error)) error {
+	for deliveries := 1; ; deliveries++ {
+		msg := toBrokerMessage(message, deliveries)
+
+		callbacks, err := handleFunc(ctx, msg)
+		switch {
+		case err == nil:
+			if err := reader.CommitMessages(ctx, message); err != nil {
+				return fmt.Errorf("failed to commit message: %s, reason: %v", msg.Key, err)
+			}
+			runCallbacks(callbacks)
+
+			return nil
+		case b.config.maxDeliveries > 0 && deliveries >= b.config.maxDeliveries:
+			log.Errorf("message: %s from topic: %s was not acknowledged after %d deliveries, dead lettering it, reason: %v", msg.Key, message.Topic, deliveries, err)
+			if err := b.deadLetter(ctx, message); err != nil {
+				return err
+			}
+			if err := reader.CommitMessages(ctx, message); err != nil {
+				return fmt.Errorf("failed to commit message: %s, reason: %v", msg.Key, err)
+			}
+			runCallbacks(callbacks)
+
+			return nil
+		default:
+			runCallbacks(callbacks)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(b.config.redeliveryDelay):
+		}
+	}
+}
+
+// deadLetter publishes the message to the dead letter topic, with headers naming the topic it was dead lettered from
+// in the same manner as RabbitMQ
+func (b *kafkaBroker) deadLetter(ctx context.Context, message kafka.Message) error {
+	headers := append([]kafka.Header(nil), message.Headers...)
+	headers = append(headers,
+		kafka.Header{Key: "x-first-death-queue", Value: []byte(message.Topic)},
+		kafka.Header{Key: "x-first-death-reason", Value: []byte("delivery_limit")},
+	)
+
+	if err := b.writer.WriteMessages(ctx, kafka.Message{
+		Topic:   b.config.deadLetterTopic,
+		Key:     message.Key,
+		Value:   message.Value,
+		Headers: headers,
+	}); err != nil {
+		return fmt.Errorf("failed to publish message to dead letter topic: %s, reason: %v", b.config.deadLetterTopic, err)
+	}
+
+	return nil
+}
+
+func (b *kafkaBroker) Publish(ctx context.Context, destinationQueue string, message broker.Message) error {
+	headers := make([]kafka.Header, 0, len(message.Headers))
+	for key, value := range message.Headers {
+		headers = append(headers, kafka.Header{Key: key, Value: headerValue(value)})
+	}
+
+	if err := b.writer.WriteMessages(ctx, kafka.Message{
+		Topic:   destinationQueue,
+		Key:     []byte(message.Key),
+		Value:   message.Body,
+		Headers: headers,
+		Time:    time.Now(),
+	}); err != nil {
+		return fmt.Errorf("failed to publish message, reason: %v", err)
+	}
+
+	return nil
+}
+
+func (b *kafkaBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+
+	var errs []error
+	for _, reader := range b.readers {
+		if err := reader.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close kafka reader, reason: %v", err))
+		}
+	}
+	b.readers = nil
+
+	if b.writer != nil {
+		if err := b.writer.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close kafka writer, reason: %v", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (b *kafkaBroker) Alive() bool {
+	b.mu.Lock()
+	closed := b.closed
+	b.mu.Unlock()
+
+	if closed {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), b.config.timeout)
+	defer cancel()
+
+	return b.ping(ctx) == nil
+}
+
+// ping checks that at least one of the kafka brokers can be connected to
+func (b *kafkaBroker) ping(ctx context.Context) error {
+	var errs []error
+	for _, address := range b.config.addresses {
+		conn, err := b.dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+		_ = conn.Close()
+
+		return nil
+	}
+
+	return errors.Join(errs...)
+}
+
+// toBrokerMessage converts a kafka message to a broker message, the number of times the message has been delivered
+// is set in the x-delivery-count header in the same manner as RabbitMQ quorum queues
+func toBrokerMessage(message kafka.Message, deliveries int) *broker.Message {
+	headers := make(map[string]any, len(message.Headers)+1)
+	for _, header := range message.Headers {
+		headers[header.Key] = string(header.Value)
+	}
+	headers["x-delivery-count"] = deliveries - 1
+
+	return &broker.Message{
+		Key:     string(message.Key),
+		Headers: headers,
+		Body:    message.Value,
+	}
+}
+
+func headerValue(value any) []byte {
+	switch v := value.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	default:
+		return fmt.Appendf(nil, "%v", v)
+	}
+}
+
+func runCallbacks(callbacks []func()) {
+	for _, cb := range callbacks {
+		cb()
+	}
+}
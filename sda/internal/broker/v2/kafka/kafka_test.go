@@ -0,0 +1,252 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	broker "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockReader serves the messages in order, and returns the context error when no messages remain
+type mockReader struct {
+	messages  []kafka.Message
+	committed []kafka.Message
+	closed    bool
+}
+
+func (m *mockReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	if len(m.messages) == 0 {
+		<-ctx.Done()
+
+		return kafka.Message{}, ctx.Err()
+	}
+	message := m.messages[0]
+	m.messages = m.messages[1:]
+
+	return message, nil
+}
+
+func (m *mockReader) CommitMessages(_ context.Context, messages ...kafka.Message) error {
+	m.committed = append(m.committed, messages...)
+
+	return nil
+}
+
+func (m *mockReader) Close() error {
+	m.closed = true
+
+	return nil
+}
+
+type mockWriter struct {
+	written []kafka.Message
+	err     error
+}
+
+func (m *mockWriter) WriteMessages(_ context.Context, messages ...kafka.Message) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.written = append(m.written, messages...)
+
+	return nil
+}
+
+func (m *mockWriter) Close() error { return nil }
+
+func newTestBroker(reader *mockReader, writer *mockWriter) *kafkaBroker {
+	b := &kafkaBroker{
+		config: defaultConfig.clone(),
+		writer: writer,
+		newReader: func(_ string) messageReader {
+			return reader
+		},
+	}
+	b.config.maxDeliveries = 3
+	b.config.deadLetterTopic = "catch_all.dead"
+	b.config.redeliveryDelay = time.Millisecond
+
+	return b
+}
+
+func testMessage() kafka.Message {
+	return kafka.Message{
+		Topic:   "ingest",
+		Key:     []byte("key-1"),
+		Value:   []byte(`{}`),
+		Headers: []kafka.Header{{Key: "header", Value: []byte("value")}},
+	}
+}
+
+func TestKafka_CommitsOnSuccess(t *testing.T) {
+	reader := &mockReader{}
+	b := newTestBroker(reader, &mockWriter{})
+
+	var received *broker.Message
+	handle := func(_ context.Context, msg *broker.Message) ([]func(), error) {
+		received = msg
+
+		return nil, nil
+	}
+
+	require.NoError(t, b.handleMessage(context.Background(), reader, testMessage(), handle))
+	assert.Len(t, reader.committed, 1)
+	assert.Equal(t, "key-1", received.Key)
+	assert.Equal(t, []byte(`{}`), received.Body)
+	assert.Equal(t, "value", received.Headers["header"])
+	assert.Equal(t, 0, received.Headers["x-delivery-count"])
+}
+
+func TestKafka_CallbacksRunAfterCommit(t *testing.T) {
+	reader := &mockReader{}
+	b := newTestBroker(reader, &mockWriter{})
+
+	var committed []int
+	handle := func(_ context.Context, _ *broker.Message) ([]func(), error) {
+		return []func(){
+			func() { committed = append(committed, len(reader.committed)) },
+			func() { committed = append(committed, len(reader.committed)) },
+		}, nil
+	}
+
+	require.NoError(t, b.handleMessage(context.Background(), reader, testMessage(), handle))
+	assert.Equal(t, []int{1, 1}, committed)
+}
+
+func TestKafka_RedeliversOnError(t *testing.T) {
+	reader := &mockReader{}
+	b := newTestBroker(reader, &mockWriter{})
+
+	var deliveries []any
+	handle := func(_ context.Context, msg *broker.Message) ([]func(), error) {
+		deliveries = append(deliveries, msg.Headers["x-delivery-count"])
+		if len(deliveries) < 2 {
+			return []func(){func() {}}, errors.New("something went wrong")
+		}
+
+		return nil, nil
+	}
+
+	require.NoError(t, b.handleMessage(context.Background(), reader, testMessage(), handle))
+	assert.Equal(t, []any{0, 1}, deliveries)
+	assert.Len(t, reader.committed, 1)
+}
+
+func TestKafka_CallbacksRunOnError(t *testing.T) {
+	reader := &mockReader{}
+	b := newTestBroker(reader, &mockWriter{})
+
+	var ran int
+	handle := func(_ context.Context, _ *broker.Message) ([]func(), error) {
+		return []func(){func() { ran++ }}, errors.New("boom")
+	}
+
+	require.NoError(t, b.handleMessage(context.Background(), reader, testMessage(), handle))
+	assert.Equal(t, 3, ran, "callbacks must run after every delivery")
+}
+
+func TestKafka_DeadLettersAfterMaxDeliveries(t *testing.T) {
+	reader := &mockReader{}
+	writer := &mockWriter{}
+	b := newTestBroker(reader, writer)
+
+	var deliveries int
+	handle := func(_ context.Context, _ *broker.Message) ([]func(), error) {
+		deliveries++
+
+		return nil, errors.New("boom")
+	}
+
+	require.NoError(t, b.handleMessage(context.Background(), reader, testMessage(), handle))
+	assert.Equal(t, 3, deliveries)
+	assert.Len(t, reader.committed, 1)
+	require.Len(t, writer.written, 1)
+	assert.Equal(t, "catch_all.dead", writer.written[0].Topic)
+	assert.Equal(t, []byte("key-1"), writer.written[0].Key)
+	assert.Contains(t, writer.written[0].Headers, kafka.Header{Key: "x-first-death-queue", Value: []byte("ingest")})
+	assert.Contains(t, writer.written[0].Headers, kafka.Header{Key: "header", Value: []byte("value")})
+}
+
+func TestKafka_NotCommittedWhenDeadLetteringFails(t *testing.T) {
+	reader := &mockReader{}
+	b := newTestBroker(reader, &mockWriter{err: errors.New("unavailable")})
+
+	handle := func(_ context.Context, _ *broker.Message) ([]func(), error) {
+		return nil, errors.New("boom")
+	}
+
+	assert.Error(t, b.handleMessage(context.Background(), reader, testMessage(), handle))
+	assert.Empty(t, reader.committed)
+}
+
+func TestKafka_StopsRedeliveringWhenCanceled(t *testing.T) {
+	reader := &mockReader{}
+	b := newTestBroker(reader, &mockWriter{})
+	b.config.maxDeliveries = 0
+	b.config.redeliveryDelay = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	handle := func(_ context.Context, _ *broker.Message) ([]func(), error) {
+		cancel()
+
+		return nil, errors.New("boom")
+	}
+
+	assert.ErrorIs(t, b.handleMessage(ctx, reader, testMessage(), handle), context.Canceled)
+	assert.Empty(t, reader.committed)
+}
+
+func TestKafka_Subscribe(t *testing.T) {
+	reader := &mockReader{messages: []kafka.Message{testMessage(), testMessage()}}
+	b := newTestBroker(reader, &mockWriter{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var handled int
+	handle := func(_ context.Context, _ *broker.Message) ([]func(), error) {
+		handled++
+		if handled == 2 {
+			cancel()
+		}
+
+		return nil, nil
+	}
+
+	assert.ErrorIs(t, b.Subscribe(ctx, "ingest", handle), context.Canceled)
+	assert.Equal(t, 2, handled)
+	assert.Len(t, reader.committed, 2)
+
+	require.NoError(t, b.Close())
+	assert.True(t, reader.closed)
+	assert.False(t, b.Alive())
+}
+
+func TestKafka_Publish(t *testing.T) {
+	writer := &mockWriter{}
+	b := newTestBroker(&mockReader{}, writer)
+
+	err := b.Publish(context.Background(), "completed", broker.Message{
+		Key:     "key-1",
+		Headers: map[string]any{"string": "value", "number": 1},
+		Body:    []byte(`{}`),
+	})
+	require.NoError(t, err)
+	require.Len(t, writer.written, 1)
+	assert.Equal(t, "completed", writer.written[0].Topic)
+	assert.Equal(t, []byte("key-1"), writer.written[0].Key)
+	assert.Equal(t, []byte(`{}`), writer.written[0].Value)
+	assert.ElementsMatch(t, []kafka.Header{{Key: "string", Value: []byte("value")}, {Key: "number", Value: []byte("1")}}, writer.written[0].Headers)
+}
+
+func TestKafka_UnsupportedSASLMechanism(t *testing.T) {
+	_, err := NewKafkaBroker(context.Background(), func(o *options) {
+		o.addresses = []string{"localhost:9092"}
+		o.user = "user"
+		o.saslMechanism = "gssapi"
+	})
+	assert.ErrorContains(t, err, "sasl mechanism: gssapi not supported")
+}
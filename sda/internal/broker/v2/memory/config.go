@@ -0,0 +1,47 @@
+package memory
+
+import (
+	"github.com/neicnordic/sensitive-data-archive/internal/config/v2"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+type options struct {
+	maxDeliveries   int
+	deadLetterQueue string
+}
+
+var defaultConfig *options
+
+func init() {
+	defaultConfig = new(options)
+	config.RegisterFlags(
+		&config.Flag{
+			Name: "broker.memory.max_deliveries",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Int(flagName, 20, "How many times a message is delivered before it is dead lettered when not acknowledged, 0 delivers it until acknowledged")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				defaultConfig.maxDeliveries = viper.GetInt(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "broker.memory.dead_letter_queue",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "catch_all.dead", "Queue where messages which were delivered max_deliveries times without being acknowledged are moved")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				defaultConfig.deadLetterQueue = viper.GetString(flagName)
+			},
+		},
+	)
+}
+
+func (cfg *options) clone() *options {
+	return &options{
+		maxDeliveries:   cfg.maxDeliveries,
+		deadLetterQueue: cfg.deadLetterQueue,
+	}
+}
@@ -0,0 +1,236 @@
+// Package memory implements the broker interface with in process queues, for
+// use in tests and in development deployments running the services within a
+// single process. Messages are not persisted and are lost when the process exits.
+package memory
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"maps"
+	"sync"
+
+	broker "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+// Broker is an in memory broker, messages are published directly to the queue named by the destination
+type Broker struct {
+	mu sync.Mutex
+
+	queues map[string]*queue
+	done   chan struct{}
+	closed bool
+	config *options
+}
+
+type queue struct {
+	deliveries []*delivery
+	// notify is signaled when messages are available in the queue
+	notify chan struct{}
+}
+
+type delivery struct {
+	message broker.Message
+	count   int
+}
+
+var (
+	shared     *Broker
+	sharedOnce sync.Once
+)
+
+func NewMemoryBroker(options ...func(*options)) *Broker {
+	b := &Broker{
+		queues: make(map[string]*queue),
+		done:   make(chan struct{}),
+		config: defaultConfig.clone(),
+	}
+
+	for _, option := range options {
+		option(b.config)
+	}
+
+	return b
+}
+
+// SharedBroker returns the broker shared by all users within the process, such that services running in the same
+// process can exchange messages
+func SharedBroker() *Broker {
+	sharedOnce.Do(func() {
+		shared = NewMemoryBroker()
+	})
+
+	return shared
+}
+
+func (b *Broker) Subscribe(ctx context.Context, sourceQueue string, handleFunc func(context.Context, *broker.Message) ([]func(), error)) error {
+	for {
+		d, notify, err := b.next(sourceQueue)
+		if err != nil {
+			return err
+		}
+
+		if d == nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-b.done:
+				return errors.New("broker closed")
+			case <-notify:
+			}
+
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			b.enqueue(sourceQueue, d, true)
+
+			return err
+		}
+
+		b.handleDelivery(ctx, sourceQueue, d, handleFunc)
+	}
+}
+
+func (b *Broker) handleDelivery(ctx context.Context, sourceQueue string, d *delivery, handleFunc func(context.Context, *broker.Message) ([]func(), error)) {
+	d.count++
+	msg := cloneMessage(d.message)
+	msg.Headers["x-delivery-count"] = d.count - 1
+
+	callbacks, err := handleFunc(ctx, &msg)
+	if err != nil {
+		if b.config.maxDeliveries > 0 && d.count >= b.config.maxDeliveries {
+			log.Errorf("message: %s from queue: %s was not acknowledged after %d deliveries, dead lettering it, reason: %v", msg.Key, sourceQueue, d.count, err)
+			deadLettered := cloneMessage(d.message)
+			deadLettered.Headers["x-first-death-queue"] = sourceQueue
+			deadLettered.Headers["x-first-death-reason"] = "delivery_limit"
+			b.enqueue(b.config.deadLetterQueue, &delivery{message: deadLettered}, false)
+		} else {
+			b.enqueue(sourceQueue, d, false)
+		}
+	}
+
+	for _, cb := range callbacks {
+		cb()
+	}
+}
+
+func (b *Broker) Publish(_ context.Context, destinationQueue string, message broker.Message) error {
+	b.mu.Lock()
+	closed := b.closed
+	b.mu.Unlock()
+
+	if closed {
+		return errors.New("cannot publish: broker is closed")
+	}
+
+	b.enqueue(destinationQueue, &delivery{message: cloneMessage(message)}, false)
+
+	return nil
+}
+
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.closed {
+		b.closed = true
+		close(b.done)
+	}
+
+	return nil
+}
+
+func (b *Broker) Alive() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return !b.closed
+}
+
+// Messages returns the messages currently waiting in the queue
+func (b *Broker) Messages(queueName string) []broker.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.queues[queueName]
+	if !ok {
+		return nil
+	}
+
+	messages := make([]broker.Message, 0, len(q.deliveries))
+	for _, d := range q.deliveries {
+		messages = append(messages, cloneMessage(d.message))
+	}
+
+	return messages
+}
+
+// next takes the first message of the queue, when the queue is empty the channel signaling new messages is returned
+func (b *Broker) next(queueName string) (*delivery, <-chan struct{}, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, nil, errors.New("broker closed")
+	}
+
+	q := b.queue(queueName)
+	if len(q.deliveries) == 0 {
+		return nil, q.notify, nil
+	}
+
+	d := q.deliveries[0]
+	q.deliveries = q.deliveries[1:]
+	// Wake up any other subscriber of the queue
+	if len(q.deliveries) > 0 {
+		signal(q.notify)
+	}
+
+	return d, nil, nil
+}
+
+// enqueue adds the message to the end of the queue, or the front if first is set
+func (b *Broker) enqueue(queueName string, d *delivery, first bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q := b.queue(queueName)
+	if first {
+		q.deliveries = append([]*delivery{d}, q.deliveries...)
+	} else {
+		q.deliveries = append(q.deliveries, d)
+	}
+	signal(q.notify)
+}
+
+// queue returns the named queue, creating it if needed, the lock must be held by the caller
+func (b *Broker) queue(queueName string) *queue {
+	q, ok := b.queues[queueName]
+	if !ok {
+		q = &queue{notify: make(chan struct{}, 1)}
+		b.queues[queueName] = q
+	}
+
+	return q
+}
+
+func signal(notify chan struct{}) {
+	select {
+	case notify <- struct{}{}:
+	default:
+	}
+}
+
+// cloneMessage copies the message such that neither publishers nor subscribers can modify queued messages
+func cloneMessage(message broker.Message) broker.Message {
+	headers := make(map[string]any, len(message.Headers))
+	maps.Copy(headers, message.Headers)
+
+	return broker.Message{
+		Key:     message.Key,
+		Headers: headers,
+		Body:    bytes.Clone(message.Body),
+	}
+}
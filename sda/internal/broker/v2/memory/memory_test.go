@@ -0,0 +1,156 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	broker "github.com/neicnordic/sensitive-data-archive/internal/broker/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBroker() *Broker {
+	return NewMemoryBroker(func(o *options) {
+		o.maxDeliveries = 3
+		o.deadLetterQueue = "catch_all.dead"
+	})
+}
+
+// subscribe subscribes to the queue until the handleFunc has been called count times
+func subscribe(t *testing.T, b *Broker, sourceQueue string, count int, handleFunc func(context.Context, *broker.Message) ([]func(), error)) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var calls int
+	err := b.Subscribe(ctx, sourceQueue, func(ctx context.Context, msg *broker.Message) ([]func(), error) {
+		calls++
+		if calls == count {
+			cancel()
+		}
+
+		return handleFunc(ctx, msg)
+	})
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestMemory_AcksOnSuccess(t *testing.T) {
+	b := newTestBroker()
+	require.NoError(t, b.Publish(context.Background(), "ingest", broker.Message{Key: "key-1", Body: []byte(`{}`)}))
+	require.Len(t, b.Messages("ingest"), 1)
+
+	var received *broker.Message
+	subscribe(t, b, "ingest", 1, func(_ context.Context, msg *broker.Message) ([]func(), error) {
+		received = msg
+
+		return nil, nil
+	})
+
+	assert.Equal(t, "key-1", received.Key)
+	assert.Equal(t, []byte(`{}`), received.Body)
+	assert.Equal(t, 0, received.Headers["x-delivery-count"])
+	assert.Empty(t, b.Messages("ingest"))
+}
+
+func TestMemory_CallbacksRunAfterAck(t *testing.T) {
+	b := newTestBroker()
+	require.NoError(t, b.Publish(context.Background(), "ingest", broker.Message{Key: "key-1"}))
+
+	var ran []string
+	subscribe(t, b, "ingest", 1, func(_ context.Context, _ *broker.Message) ([]func(), error) {
+		return []func(){
+			func() { ran = append(ran, "first") },
+			func() { ran = append(ran, "second") },
+		}, nil
+	})
+
+	assert.Equal(t, []string{"first", "second"}, ran)
+}
+
+func TestMemory_RequeuesOnError(t *testing.T) {
+	b := newTestBroker()
+	require.NoError(t, b.Publish(context.Background(), "ingest", broker.Message{Key: "key-1"}))
+	require.NoError(t, b.Publish(context.Background(), "ingest", broker.Message{Key: "key-2"}))
+
+	var received []string
+	var ran int
+	subscribe(t, b, "ingest", 3, func(_ context.Context, msg *broker.Message) ([]func(), error) {
+		received = append(received, msg.Key)
+		if msg.Key == "key-1" && msg.Headers["x-delivery-count"] == 0 {
+			return []func(){func() { ran++ }}, errors.New("something went wrong")
+		}
+
+		return nil, nil
+	})
+
+	// The nacked message is requeued behind the messages already waiting
+	assert.Equal(t, []string{"key-1", "key-2", "key-1"}, received)
+	assert.Equal(t, 1, ran, "callbacks must run even on error")
+	assert.Empty(t, b.Messages("ingest"))
+}
+
+func TestMemory_DeadLettersAfterMaxDeliveries(t *testing.T) {
+	b := newTestBroker()
+	require.NoError(t, b.Publish(context.Background(), "ingest", broker.Message{Key: "key-1", Headers: map[string]any{"header": "value"}}))
+
+	subscribe(t, b, "ingest", 3, func(_ context.Context, _ *broker.Message) ([]func(), error) {
+		return nil, errors.New("boom")
+	})
+
+	assert.Empty(t, b.Messages("ingest"))
+	deadLettered := b.Messages("catch_all.dead")
+	require.Len(t, deadLettered, 1)
+	assert.Equal(t, "key-1", deadLettered[0].Key)
+	assert.Equal(t, "value", deadLettered[0].Headers["header"])
+	assert.Equal(t, "ingest", deadLettered[0].Headers["x-first-death-queue"])
+}
+
+func TestMemory_SubscribeWaitsForMessages(t *testing.T) {
+	b := newTestBroker()
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = b.Publish(context.Background(), "ingest", broker.Message{Key: "key-1"})
+	}()
+
+	var received string
+	subscribe(t, b, "ingest", 1, func(_ context.Context, msg *broker.Message) ([]func(), error) {
+		received = msg.Key
+
+		return nil, nil
+	})
+
+	assert.Equal(t, "key-1", received)
+}
+
+func TestMemory_PublishedMessageIsCopied(t *testing.T) {
+	b := newTestBroker()
+	message := broker.Message{Key: "key-1", Headers: map[string]any{}, Body: []byte("body")}
+	require.NoError(t, b.Publish(context.Background(), "ingest", message))
+
+	message.Body[0] = 'B'
+	message.Headers["header"] = "value"
+
+	assert.Equal(t, []byte("body"), b.Messages("ingest")[0].Body)
+	assert.Empty(t, b.Messages("ingest")[0].Headers)
+}
+
+func TestMemory_Close(t *testing.T) {
+	b := newTestBroker()
+	assert.True(t, b.Alive())
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- b.Subscribe(context.Background(), "ingest", func(_ context.Context, _ *broker.Message) ([]func(), error) {
+			return nil, nil
+		})
+	}()
+
+	require.NoError(t, b.Close())
+	assert.False(t, b.Alive())
+	assert.Error(t, <-errChan)
+	assert.Error(t, b.Publish(context.Background(), "ingest", broker.Message{}))
+}
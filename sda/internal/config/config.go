@@ -80,6 +80,26 @@ type SyncAPIConf struct {
 	AccessionRouting string `default:"accession"`
 	IngestRouting    string `default:"ingest"`
 	MappingRouting   string `default:"mappings"`
+	// ClientCACert is the CA the client certificates of the partners are verified against
+	ClientCACert string
+	// JWTAudience is the audience the tokens of the partners have to be issued for
+	JWTAudience string
+	// ReplayWindow is how long before or after the current time requests may have been sent, and the longest time
+	// tokens may be valid for
+	ReplayWindow time.Duration
+	Partners     []SyncAPIPartner
+}
+
+// SyncAPIPartner is a remote site allowed to sync datasets through the sync API, authenticated by its client
+// certificate or by tokens signed with its keys
+type SyncAPIPartner struct {
+	Name string `mapstructure:"name"`
+	// CertificateSubject is the common name of the client certificate of the partner
+	CertificateSubject string `mapstructure:"certificateSubject"`
+	// JWTIssuer is the issuer of the tokens of the partner, which are verified with the keys in JWTPubKeyPath
+	JWTIssuer       string   `mapstructure:"jwtIssuer"`
+	JWTPubKeyPath   string   `mapstructure:"jwtPubKeyPath"`
+	DatasetPrefixes []string `mapstructure:"datasetPrefixes"`
 }
 
 type S3InboxConf struct {
@@ -90,6 +110,9 @@ type S3InboxConf struct {
 	Region    string `mapstructure:"region"`
 	CaCert    string `mapstructure:"ca_cert"`
 	ReadyPath string `mapstructure:"ready_path"`
+	// QuotaBytes and QuotaFiles are the default quota of users in the inbox, 0 means users are not limited
+	QuotaBytes int64 `mapstructure:"quota_bytes"`
+	QuotaFiles int64 `mapstructure:"quota_files"`
 }
 type APIConf struct {
 	RBACpolicy  []byte
@@ -341,8 +364,6 @@ func NewConfig(app string) (*Config, error) {
 			"broker.port",
 			"broker.user",
 			"broker.password",
-			"sync.api.user",
-			"sync.api.password",
 		}
 	default:
 		return nil, fmt.Errorf("application '%s' doesn't exist", app)
@@ -518,7 +539,9 @@ func NewConfig(app string) (*Config, error) {
 			return nil, err
 		}
 
-		c.configSyncAPI()
+		if err := c.configSyncAPI(); err != nil {
+			return nil, err
+		}
 		c.configSchemas()
 	default:
 		return nil, errors.New("unknown app name")
@@ -681,6 +704,12 @@ func (c *Config) configOrchestrator() {
 	}
 }
 
+// GetReEncryptClientConfig returns the configuration of the client connecting to the reencrypt service, for services
+// which are configured with the config/v2 package
+func GetReEncryptClientConfig() (Grpc, error) {
+	return configReEncryptClient()
+}
+
 func configReEncryptClient() (Grpc, error) {
 	var grpc Grpc
 	grpc.Host = viper.GetString("grpc.host")
@@ -811,7 +840,7 @@ func (c *Config) configSync() error {
 }
 
 // configSyncAPI provides configuration for the outgoing sync settings
-func (c *Config) configSyncAPI() {
+func (c *Config) configSyncAPI() error {
 	c.SyncAPI = SyncAPIConf{}
 	c.SyncAPI.APIPassword = viper.GetString("sync.api.password")
 	c.SyncAPI.APIUser = viper.GetString("sync.api.user")
@@ -825,6 +854,47 @@ func (c *Config) configSyncAPI() {
 	if viper.IsSet("sync.api.MappingRouting") {
 		c.SyncAPI.MappingRouting = viper.GetString("sync.api.MappingRouting")
 	}
+
+	c.SyncAPI.ClientCACert = viper.GetString("sync.api.clientCACert")
+	c.SyncAPI.JWTAudience = viper.GetString("sync.api.jwtAudience")
+	c.SyncAPI.ReplayWindow = 10 * time.Minute
+	if viper.IsSet("sync.api.replayWindow") {
+		c.SyncAPI.ReplayWindow = viper.GetDuration("sync.api.replayWindow")
+	}
+	if err := viper.UnmarshalKey("sync.api.partners", &c.SyncAPI.Partners); err != nil {
+		return fmt.Errorf("failed to parse sync.api.partners: %v", err)
+	}
+	if c.SyncAPI.ReplayWindow <= 0 {
+		return errors.New("sync.api.replayWindow has to be positive")
+	}
+
+	if (c.SyncAPI.APIUser == "") != (c.SyncAPI.APIPassword == "") {
+		return errors.New("both sync.api.user and sync.api.password have to be set")
+	}
+	if c.SyncAPI.APIUser == "" && len(c.SyncAPI.Partners) == 0 {
+		return errors.New("sync.api.user and sync.api.password, or sync.api.partners, have to be set")
+	}
+
+	names := make(map[string]bool)
+	for _, partner := range c.SyncAPI.Partners {
+		switch {
+		case partner.Name == "":
+			return errors.New("sync API partner without name")
+		case names[partner.Name]:
+			return fmt.Errorf("sync API partner: %s is configured more than once", partner.Name)
+		case partner.CertificateSubject == "" && partner.JWTIssuer == "":
+			return fmt.Errorf("sync API partner: %s needs certificateSubject or jwtIssuer", partner.Name)
+		case partner.CertificateSubject != "" && (c.SyncAPI.ClientCACert == "" || c.API.ServerCert == ""):
+			return fmt.Errorf("sync API partner: %s is authenticated by client certificate, which needs sync.api.clientCACert and a server certificate", partner.Name)
+		case partner.JWTIssuer != "" && (partner.JWTPubKeyPath == "" || c.SyncAPI.JWTAudience == ""):
+			return fmt.Errorf("sync API partner: %s is authenticated by tokens, which needs jwtPubKeyPath and sync.api.jwtAudience", partner.Name)
+		case len(partner.DatasetPrefixes) == 0:
+			return fmt.Errorf("sync API partner: %s has no datasetPrefixes", partner.Name)
+		}
+		names[partner.Name] = true
+	}
+
+	return nil
 }
 
 // GetC4GHKey reads and decrypts and returns the c4gh key
@@ -479,6 +479,39 @@ func (ts *ConfigTestSuite) TestConfigSyncAPI() {
 	config, err = NewConfig("sync-api")
 	assert.NoError(ts.T(), err)
 	assert.Equal(ts.T(), "wrong", config.SyncAPI.AccessionRouting)
+	assert.Equal(ts.T(), 10*time.Minute, config.SyncAPI.ReplayWindow)
+}
+
+func (ts *ConfigTestSuite) TestConfigSyncAPI_partners() {
+	ts.SetupTest()
+	defer viper.Set("sync.api.partners", nil)
+
+	viper.Set("sync.api.jwtAudience", "https://sync-api.example.org")
+	viper.Set("sync.api.replayWindow", "5m")
+	viper.Set("sync.api.partners", []map[string]any{
+		{"name": "site-a", "jwtIssuer": "https://site-a.example.org", "jwtPubKeyPath": "/keys/site-a", "datasetPrefixes": []string{"SITE-A-"}},
+	})
+	config, err := NewConfig("sync-api")
+	assert.NoError(ts.T(), err)
+	assert.Equal(ts.T(), 5*time.Minute, config.SyncAPI.ReplayWindow)
+	assert.Equal(ts.T(), []SyncAPIPartner{{Name: "site-a", JWTIssuer: "https://site-a.example.org", JWTPubKeyPath: "/keys/site-a", DatasetPrefixes: []string{"SITE-A-"}}}, config.SyncAPI.Partners)
+
+	viper.Set("sync.api.replayWindow", "0s")
+	_, err = NewConfig("sync-api")
+	assert.Error(ts.T(), err)
+	viper.Set("sync.api.replayWindow", "5m")
+
+	for _, partners := range [][]map[string]any{
+		{{"jwtIssuer": "https://site-a.example.org", "jwtPubKeyPath": "/keys/site-a", "datasetPrefixes": []string{"SITE-A-"}}},
+		{{"name": "site-a", "datasetPrefixes": []string{"SITE-A-"}}},
+		{{"name": "site-a", "jwtIssuer": "https://site-a.example.org", "jwtPubKeyPath": "/keys/site-a"}},
+		{{"name": "site-a", "certificateSubject": "site-a", "datasetPrefixes": []string{"SITE-A-"}}},
+		{{"name": "site-a", "certificateSubject": "site-a", "datasetPrefixes": []string{"SITE-A-"}}, {"name": "site-a", "certificateSubject": "site-a", "datasetPrefixes": []string{"SITE-A-"}}},
+	} {
+		viper.Set("sync.api.partners", partners)
+		_, err := NewConfig("sync-api")
+		assert.Error(ts.T(), err, "partners: %v", partners)
+	}
 }
 
 func (ts *ConfigTestSuite) TestConfigReEncryptServer() {
@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"time"
 )
 
 type Transaction interface {
@@ -191,4 +192,142 @@ type functions interface {
 
 	// SetBackedUp sets the file backup_path and backup_location
 	SetBackedUp(ctx context.Context, location, path, fileID string) error
+
+	// SetRepaired logs that the archive copy of the file was repaired, keeping the status the file had before the repair
+	SetRepaired(ctx context.Context, fileID, message string) error
+
+	// GetArchiveObject returns the archive object with the checksum and size of the archived content, the row is locked
+	// for the remainder of the transaction so the object can not be dereferenced before being referenced by the caller.
+	// Returns nil if no such archive object exists
+	GetArchiveObject(ctx context.Context, checksum string, size int64) (*ArchiveObject, error)
+
+	// GetUploadedArchiveObject returns the archive object of another file which was uploaded with the same sha256
+	// checksum as the file, and thereby has the same archived content, without locking it.
+	// Returns nil if no such archive object exists
+	GetUploadedArchiveObject(ctx context.Context, fileID string) (*ArchiveObject, error)
+
+	// ReferenceArchiveObject adds a reference to the archive object at the location and file path, the archive object
+	// is registered if it does not exist
+	ReferenceArchiveObject(ctx context.Context, location, filePath string, size int64, checksum string) error
+
+	// DereferenceArchiveObject removes a reference to the archive object at the location and file path, and removes the
+	// archive object registration when it is no longer referenced.
+	// Returns true if the archive object is still referenced by other files, objects which are not registered as archive
+	// objects are only referenced by a single file
+	DereferenceArchiveObject(ctx context.Context, location, filePath string) (bool, error)
+
+	// GetIngestCheckpoint returns the checkpoint of an interrupted archive upload of the file, returns nil if the file
+	// has no checkpoint
+	GetIngestCheckpoint(ctx context.Context, fileID string) (*IngestCheckpoint, error)
+
+	// SetIngestCheckpoint stores the progress of the archive upload of the file, replacing any previous checkpoint
+	SetIngestCheckpoint(ctx context.Context, fileID string, checkpoint *IngestCheckpoint) error
+
+	// DeleteIngestCheckpoint removes the checkpoint of the file if there is one
+	DeleteIngestCheckpoint(ctx context.Context, fileID string) error
+
+	// GetFilesToScrub returns up to limit archived files which have not been scrubbed since scrubbedBefore, the files
+	// which have gone the longest without being scrubbed first
+	GetFilesToScrub(ctx context.Context, scrubbedBefore time.Time, limit int) ([]*ScrubFile, error)
+
+	// SetFileScrubbed records the outcome of a scrub of the file, scrubError is empty when the scrub succeeded
+	SetFileScrubbed(ctx context.Context, fileID string, success bool, scrubError string) error
+
+	// SetArchiveLocation sets the archive location and file path of the file. Files sharing the archived object of the
+	// file, and the archive object registration, are updated as well
+	SetArchiveLocation(ctx context.Context, fileID, location, filePath string) error
+
+	// MigrateArchiveLocation sets the archive location and file path of the file, like SetArchiveLocation, if the file
+	// is archived at the source location, and records the source location. Returns sql.ErrNoRows if the file is not
+	// archived at the source location
+	MigrateArchiveLocation(ctx context.Context, fileID, sourceLocation, location, filePath string) error
+
+	// GetMigratedFromLocation returns the archive location the file was last migrated from, empty if the file has not
+	// been migrated
+	GetMigratedFromLocation(ctx context.Context, fileID string) (string, error)
+
+	// GetFileIDsInArchiveLocation returns the ids of the files archived at the location, optionally limited to the files
+	// of a dataset and to the files submitted by a user. Empty datasetID or user do not limit the files
+	GetFileIDsInArchiveLocation(ctx context.Context, location, datasetID, user string) ([]string, error)
+
+	// IsArchivedObjectReferenced checks if any file, or archive object registration, references the archived object at
+	// the location and file path
+	IsArchivedObjectReferenced(ctx context.Context, location, filePath string) (bool, error)
+
+	// AddUploadedChecksum sets the UPLOADED checksum of the file for the algorithm, replacing any previous checksum
+	AddUploadedChecksum(ctx context.Context, fileID, checksum, algorithm string) error
+
+	// GetUploadChecksumState returns the checksum state of the multipart upload after the part, returns nil if there
+	// is no state for the part
+	GetUploadChecksumState(ctx context.Context, uploadID string, partNumber int32) (*UploadChecksumState, error)
+
+	// SetUploadChecksumState stores the checksum state of the multipart upload after the part, replacing any previous
+	// state of the part. The states of later parts of the upload are removed, as they continued from the replaced state
+	SetUploadChecksumState(ctx context.Context, state *UploadChecksumState) error
+
+	// DeleteUploadChecksumStates removes the checksum states of all parts of the multipart upload
+	DeleteUploadChecksumStates(ctx context.Context, uploadID string) error
+
+	// GetInboxCopyOfFile returns the submission file path of the latest file uploaded to the inbox of the user as a copy
+	// of the file at filePath, recorded by the `copied_from` detail of its uploaded event. Returns an empty path if the
+	// file has not been copied
+	GetInboxCopyOfFile(ctx context.Context, submissionUser, filePath string) (string, error)
+
+	// GetInboxQuota returns the quota of the user, returns nil if the user has no quota of their own
+	GetInboxQuota(ctx context.Context, submissionUser string) (*InboxQuota, error)
+
+	// SetInboxQuota sets the quota of the user, replacing any previous quota of the user
+	SetInboxQuota(ctx context.Context, quota *InboxQuota) error
+
+	// DeleteInboxQuota removes the quota of the user if there is one
+	DeleteInboxQuota(ctx context.Context, submissionUser string) error
+
+	// GetInboxUsage sums the size and count of the files in the inbox of the user, being the files that have not been
+	// disabled or mapped to a dataset. The file with id excludedFileID is left out, so that the usage after a file is
+	// replaced can be computed, an empty excludedFileID includes all files
+	GetInboxUsage(ctx context.Context, submissionUser, excludedFileID string) (*InboxUsage, error)
+
+	// GetStaleInboxFiles returns up to limit files in the inbox which last event is lastEvent and happened before
+	// before, ordered by file id and starting after afterFileID. Files mapped to a dataset are not returned
+	GetStaleInboxFiles(ctx context.Context, lastEvent string, before time.Time, afterFileID string, limit int) ([]*InboxFile, error)
+
+	// SetInboxExpiryWarned records that the user has been warned that the file is about to be removed from the inbox
+	SetInboxExpiryWarned(ctx context.Context, fileID string) error
+
+	// DisableInboxFile logs that the file has been removed from the inbox by the housekeeping service, unless the last
+	// event of the file is no longer lastEvent logged at lastEventAt, or the file has been archived since.
+	// Returns false if the file has changed, otherwise the file is locked for the remainder of the transaction
+	DisableInboxFile(ctx context.Context, fileID, lastEvent string, lastEventAt time.Time, details string) (bool, error)
+
+	// GetFileSyncState returns the progress of syncing the file to the destination, returns nil if the file has not
+	// been synced to the destination
+	GetFileSyncState(ctx context.Context, accessionID, destination string) (*FileSyncState, error)
+
+	// SetFileSyncState stores the progress of syncing the file to the destination, replacing any previous progress
+	SetFileSyncState(ctx context.Context, state *FileSyncState) error
+
+	// GetDatasetSyncStates returns the progress of syncing the files of the dataset to each destination, files which
+	// have not been synced to any destination are returned as pending without a destination
+	GetDatasetSyncStates(ctx context.Context, datasetID string) ([]*FileSyncState, error)
+
+	// GetUnconfirmedSyncFiles returns the accession ids of the files which have been verified in the sync storage of
+	// the destination, but whose ingestion has not yet been confirmed by the remote site
+	GetUnconfirmedSyncFiles(ctx context.Context, destination string) ([]string, error)
+
+	// GetIngestionStatus returns the ingestion status of a file received from a sync, found by its accession id or
+	// else by its submission user and file path, returns nil if the file is not found
+	GetIngestionStatus(ctx context.Context, accessionID, user, filePath string) (*IngestionStatus, error)
+
+	// RegisterSyncAPIRequest registers the ID of a request received by the sync-api from the partner until the request
+	// expires, and reports whether it was registered. A request ID which is registered and has not expired at now is
+	// not registered again
+	RegisterSyncAPIRequest(ctx context.Context, partner, requestID string, expiresAt, now time.Time) (bool, error)
+
+	// DeleteSyncAPIRequests deletes the IDs of the requests received by the sync-api which expired before
+	// expiredBefore, and returns the amount of deleted request IDs
+	DeleteSyncAPIRequests(ctx context.Context, expiredBefore time.Time) (int64, error)
+
+	// ReleaseSyncAPIRequest deletes the ID of a request received by the sync-api from the partner, such that a request
+	// which failed can be retried with the same request ID
+	ReleaseSyncAPIRequest(ctx context.Context, partner, requestID string) error
 }
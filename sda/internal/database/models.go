@@ -1,5 +1,7 @@
 package database
 
+import "time"
+
 type FileInfo struct {
 	Size              int64
 	Path              string
@@ -20,6 +22,8 @@ type SyncData struct {
 	User     string
 	FilePath string
 	Checksum string
+	// DatasetID is the accession id of the dataset of the file, empty when the file is not in a dataset
+	DatasetID string
 }
 type ArchiveData struct {
 	FilePath string
@@ -65,3 +69,103 @@ type ReVerificationData struct {
 	ArchivedCheckSum     string
 	ArchivedCheckSumType string
 }
+
+// ArchiveObject is an archived object which can be referenced by multiple files
+type ArchiveObject struct {
+	Location       string
+	FilePath       string
+	FileSize       int64
+	Checksum       string
+	ReferenceCount int64
+}
+
+// UploadChecksumState is the state of the checksums of a multipart upload to the inbox after one of its parts, used to
+// continue the checksums with the next part
+type UploadChecksumState struct {
+	UploadID   string
+	PartNumber int32
+	// BytesUploaded is the total size of the parts up to and including PartNumber
+	BytesUploaded int64
+	// SHA256State and MD5State are the marshalled states of the checksums of the parts up to and including PartNumber
+	SHA256State []byte
+	MD5State    []byte
+}
+
+// IngestCheckpoint is the progress of an ongoing multipart archive upload of a file, used to resume an interrupted
+// ingestion
+type IngestCheckpoint struct {
+	Location string
+	FilePath string
+	UploadID string
+	// PartsUploaded is the amount of parts which have been uploaded, and BytesArchived their total size
+	PartsUploaded int32
+	BytesArchived int64
+	// PartSize is the size of all but the last part, 0 if the upload was started with the chunk size of the archive
+	PartSize int64
+	// HashState is the marshalled state of the sha256 of the uploaded file after BytesArchived of its content
+	HashState []byte
+}
+
+// ScrubFile is an archived file due to be scrubbed, with the locations of its archive and backup copies and the
+// checksum both copies are expected to have
+type ScrubFile struct {
+	FileID           string
+	ArchiveLocation  string
+	ArchiveFilePath  string
+	ArchiveFileSize  int64
+	BackupLocation   string
+	BackupFilePath   string
+	ArchivedChecksum string
+}
+
+// InboxQuota limits the total size and amount of the files a user can have in the inbox, a limit of 0 means the user
+// is not limited
+type InboxQuota struct {
+	User     string `json:"user"`
+	MaxBytes int64  `json:"max_bytes"`
+	MaxFiles int64  `json:"max_files"`
+}
+
+// InboxUsage is the total size and amount of the files a user has in the inbox
+type InboxUsage struct {
+	Bytes int64 `json:"bytes"`
+	Files int64 `json:"files"`
+}
+
+// InboxFile is a file in the inbox, with the time of its last event and the time the user was last warned that the
+// file is about to be removed, which is zero if the user has not been warned
+type InboxFile struct {
+	FileID      string
+	User        string
+	FilePath    string
+	Location    string
+	LastEventAt time.Time
+	WarnedAt    time.Time
+}
+
+// FileSyncState is the progress of syncing a file to a destination of the sync service. Status is one of pending,
+// copied, verified, confirmed or rejected, where a verified file has been found with the expected size in the sync
+// storage, and a confirmed file has been ingested by the remote site with the same decrypted checksum
+type FileSyncState struct {
+	AccessionID string
+	Destination string
+	Status      string
+	// Location and Size are the location and expected size of the synced file in the sync storage, and Checksum the
+	// decrypted checksum of the file when it was synced
+	Location  string
+	Size      int64
+	Checksum  string
+	Attempts  int
+	LastError string
+	UpdatedAt time.Time
+}
+
+// IngestionStatus is the ingestion status of a file received from a sync, Status is the last event of the file,
+// DecryptedChecksum the sha256 checksum of the decrypted file once it has been verified and DatasetID the accession id
+// of the dataset of the file once it has been mapped
+type IngestionStatus struct {
+	AccessionID       string
+	Status            string
+	DecryptedChecksum string
+	DatasetID         string
+}
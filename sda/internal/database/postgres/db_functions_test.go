@@ -604,6 +604,12 @@ func (ts *DatabaseTests) TestGetSyncData() { // register a file in the database
 	assert.Equal(ts.T(), "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", fileData.Checksum, "did not get expected file checksum")
 	assert.Equal(ts.T(), "/testuser/TestGetGetSyncData.c4gh", fileData.FilePath, "did not get expected file path")
 	assert.Equal(ts.T(), "testuser", fileData.User, "did not get expected user")
+	assert.Equal(ts.T(), "", fileData.DatasetID, "file should not be in a dataset")
+
+	assert.NoError(ts.T(), ts.db.MapFileToDataset(context.Background(), "TestGetSyncData-dataset", fileID))
+	fileData, err = ts.db.GetSyncData(context.Background(), "TEST:000-1111-2222")
+	assert.NoError(ts.T(), err, "failed to get sync data for file")
+	assert.Equal(ts.T(), "TestGetSyncData-dataset", fileData.DatasetID, "did not get expected dataset")
 }
 
 func (ts *DatabaseTests) TestCheckIfDatasetExists() {
@@ -1802,6 +1808,38 @@ func (ts *DatabaseTests) TestSetBackedUp() {
 	ts.Equal("/backup", archiveData.BackupLocation)
 	ts.Equal(fileID, archiveData.BackupFilePath)
 }
+func (ts *DatabaseTests) TestSetRepaired() {
+	fileID, err := ts.db.RegisterFile(context.Background(), nil, "/inbox", "/testuser/TestSetRepaired.c4gh", "testuser")
+	assert.NoError(ts.T(), err, "failed to register file in database")
+	assert.NoError(ts.T(), ts.db.UpdateFileEventLog(context.Background(), fileID, "verified", "testuser", "{}", "{}"))
+
+	assert.NoError(ts.T(), ts.db.SetRepaired(context.Background(), fileID, "{}"))
+
+	// The file keeps the status it had before the repair, so that finalize can still complete it
+	status, err := ts.db.GetFileStatus(context.Background(), fileID)
+	assert.NoError(ts.T(), err)
+	ts.Equal("verified", status)
+	var lastEvent string
+	assert.NoError(ts.T(), ts.verificationDB.QueryRow("SELECT last_event FROM sda.files WHERE id = $1", fileID).Scan(&lastEvent))
+	ts.Equal("verified", lastEvent)
+
+	var events []string
+	rows, err := ts.verificationDB.Query("SELECT event FROM sda.file_event_log WHERE file_id = $1 ORDER BY id", fileID)
+	assert.NoError(ts.T(), err)
+	defer rows.Close()
+	for rows.Next() {
+		var event string
+		assert.NoError(ts.T(), rows.Scan(&event))
+		events = append(events, event)
+	}
+	assert.NoError(ts.T(), rows.Err())
+	ts.Equal([]string{"registered", "verified", "repaired", "verified"}, events)
+}
+
+func (ts *DatabaseTests) TestSetRepaired_FileID_Not_Exists() {
+	assert.EqualError(ts.T(), ts.db.SetRepaired(context.Background(), uuid.NewString(), "{}"), sql.ErrNoRows.Error())
+}
+
 func (ts *DatabaseTests) TestSetBackedUp_FileID_Not_Exists() {
 	notExistingFileID := uuid.NewString()
 	assert.EqualError(ts.T(), ts.db.SetBackedUp(context.Background(), "/backup", notExistingFileID, notExistingFileID), sql.ErrNoRows.Error())
@@ -1840,3 +1878,899 @@ func (ts *DatabaseTests) TestGetFileIDInInbox_NotFound() {
 	assert.NoError(ts.T(), err)
 	assert.Equal(ts.T(), "", fileIDFromDB)
 }
+
+func (ts *DatabaseTests) TestGetArchiveObject() {
+	checksum := fmt.Sprintf("%x", sha256.Sum256([]byte("archived content")))
+	assert.NoError(ts.T(), ts.db.ReferenceArchiveObject(context.Background(), "/archive", checksum, 1000, checksum))
+
+	archiveObject, err := ts.db.GetArchiveObject(context.Background(), checksum, 1000)
+	assert.NoError(ts.T(), err)
+	if archiveObject == nil {
+		ts.FailNow("archive object not found")
+
+		return
+	}
+	ts.Equal("/archive", archiveObject.Location)
+	ts.Equal(checksum, archiveObject.FilePath)
+	ts.Equal(int64(1000), archiveObject.FileSize)
+	ts.Equal(int64(1), archiveObject.ReferenceCount)
+
+	// Same checksum but different size is not the same object
+	archiveObject, err = ts.db.GetArchiveObject(context.Background(), checksum, 999)
+	assert.NoError(ts.T(), err)
+	ts.Nil(archiveObject)
+}
+
+func (ts *DatabaseTests) TestGetArchiveObject_NotFound() {
+	archiveObject, err := ts.db.GetArchiveObject(context.Background(), "not-existing-checksum", 1000)
+	assert.NoError(ts.T(), err)
+	ts.Nil(archiveObject)
+}
+
+func (ts *DatabaseTests) TestGetUploadedArchiveObject() {
+	uploadedChecksum := fmt.Sprintf("%x", sha256.Sum256([]byte("uploaded file")))
+	contentChecksum := fmt.Sprintf("%x", sha256.Sum256([]byte("uploaded content")))
+
+	archivedID, err := ts.db.RegisterFile(context.Background(), nil, "/inbox", "/testuser/TestGetUploadedArchiveObject1.c4gh", "testuser")
+	assert.NoError(ts.T(), err, "failed to register file in database")
+	assert.NoError(ts.T(), ts.db.SetArchived(context.Background(), "/archive", &database.FileInfo{
+		Size:             1000,
+		Path:             contentChecksum,
+		UploadedChecksum: uploadedChecksum,
+	}, archivedID))
+	assert.NoError(ts.T(), ts.db.ReferenceArchiveObject(context.Background(), "/archive", contentChecksum, 1000, contentChecksum))
+
+	fileID, err := ts.db.RegisterFile(context.Background(), nil, "/inbox", "/testuser/TestGetUploadedArchiveObject2.c4gh", "testuser")
+	assert.NoError(ts.T(), err, "failed to register file in database")
+
+	// Files without an uploaded checksum have no archive object to share
+	archiveObject, err := ts.db.GetUploadedArchiveObject(context.Background(), fileID)
+	assert.NoError(ts.T(), err)
+	ts.Nil(archiveObject)
+
+	assert.NoError(ts.T(), ts.db.AddUploadedChecksum(context.Background(), fileID, uploadedChecksum, "SHA256"))
+	archiveObject, err = ts.db.GetUploadedArchiveObject(context.Background(), fileID)
+	assert.NoError(ts.T(), err)
+	if archiveObject == nil {
+		ts.FailNow("archive object not found")
+
+		return
+	}
+	ts.Equal("/archive", archiveObject.Location)
+	ts.Equal(contentChecksum, archiveObject.FilePath)
+	ts.Equal(contentChecksum, archiveObject.Checksum)
+
+	// The archived file does not share its own archive object
+	archiveObject, err = ts.db.GetUploadedArchiveObject(context.Background(), archivedID)
+	assert.NoError(ts.T(), err)
+	ts.Nil(archiveObject)
+}
+
+func (ts *DatabaseTests) TestReferenceAndDereferenceArchiveObject() {
+	checksum := fmt.Sprintf("%x", sha256.Sum256([]byte("shared content")))
+	assert.NoError(ts.T(), ts.db.ReferenceArchiveObject(context.Background(), "/archive", checksum, 1000, checksum))
+	assert.NoError(ts.T(), ts.db.ReferenceArchiveObject(context.Background(), "/archive", checksum, 1000, checksum))
+
+	archiveObject, err := ts.db.GetArchiveObject(context.Background(), checksum, 1000)
+	assert.NoError(ts.T(), err)
+	ts.Equal(int64(2), archiveObject.ReferenceCount)
+
+	stillReferenced, err := ts.db.DereferenceArchiveObject(context.Background(), "/archive", checksum)
+	assert.NoError(ts.T(), err)
+	ts.True(stillReferenced)
+
+	stillReferenced, err = ts.db.DereferenceArchiveObject(context.Background(), "/archive", checksum)
+	assert.NoError(ts.T(), err)
+	ts.False(stillReferenced)
+
+	// The archive object registration is removed when no longer referenced
+	archiveObject, err = ts.db.GetArchiveObject(context.Background(), checksum, 1000)
+	assert.NoError(ts.T(), err)
+	ts.Nil(archiveObject)
+}
+
+func (ts *DatabaseTests) TestDereferenceArchiveObject_NotRegistered() {
+	stillReferenced, err := ts.db.DereferenceArchiveObject(context.Background(), "/archive", uuid.NewString())
+	assert.NoError(ts.T(), err)
+	ts.False(stillReferenced)
+}
+
+func (ts *DatabaseTests) TestGetSizeAndObjectCountOfLocation_SharedArchiveObject() {
+	checksum := fmt.Sprintf("%x", sha256.Sum256([]byte("shared content")))
+	for _, filePath := range []string{"/testuser/shared1.c4gh", "/testuser/shared2.c4gh"} {
+		fileID, err := ts.db.RegisterFile(context.Background(), nil, "/inbox", filePath, "testuser")
+		assert.NoError(ts.T(), err, "failed to register file in database")
+
+		assert.NoError(ts.T(), ts.db.SetArchived(context.Background(), "/archive", &database.FileInfo{
+			Size:             1000,
+			Path:             checksum,
+			UploadedChecksum: fmt.Sprintf("%x", sha256.New().Sum(nil)),
+		}, fileID))
+	}
+
+	size, count, err := ts.db.GetSizeAndObjectCountOfLocation(context.Background(), "/archive")
+	assert.NoError(ts.T(), err)
+	ts.Equal(uint64(1000), size)
+	ts.Equal(uint64(1), count)
+}
+
+func (ts *DatabaseTests) TestSetAndGetIngestCheckpoint() {
+	fileID, err := ts.db.RegisterFile(context.Background(), nil, "/inbox", "/testuser/TestSetAndGetIngestCheckpoint.c4gh", "testuser")
+	if err != nil {
+		ts.FailNow("failed to register file in database")
+	}
+
+	checkpoint := &database.IngestCheckpoint{
+		Location:      "http://s3:9000/archive",
+		FilePath:      fileID,
+		UploadID:      "upload-id",
+		PartsUploaded: 1,
+		BytesArchived: 5 * 1024 * 1024,
+		HashState:     []byte("hash state"),
+		PartSize:      5 * 1024 * 1024,
+	}
+	assert.NoError(ts.T(), ts.db.SetIngestCheckpoint(context.Background(), fileID, checkpoint))
+
+	// Setting the checkpoint again replaces the previous one
+	checkpoint.PartsUploaded = 2
+	checkpoint.BytesArchived = 10 * 1024 * 1024
+	checkpoint.HashState = []byte("updated hash state")
+	assert.NoError(ts.T(), ts.db.SetIngestCheckpoint(context.Background(), fileID, checkpoint))
+
+	checkpointFromDB, err := ts.db.GetIngestCheckpoint(context.Background(), fileID)
+	assert.NoError(ts.T(), err)
+	ts.Equal(checkpoint, checkpointFromDB)
+}
+
+func (ts *DatabaseTests) TestGetIngestCheckpoint_NotFound() {
+	fileID, err := ts.db.RegisterFile(context.Background(), nil, "/inbox", "/testuser/TestGetIngestCheckpoint_NotFound.c4gh", "testuser")
+	if err != nil {
+		ts.FailNow("failed to register file in database")
+	}
+
+	checkpoint, err := ts.db.GetIngestCheckpoint(context.Background(), fileID)
+	assert.NoError(ts.T(), err)
+	ts.Nil(checkpoint)
+}
+
+func (ts *DatabaseTests) TestDeleteIngestCheckpoint() {
+	fileID, err := ts.db.RegisterFile(context.Background(), nil, "/inbox", "/testuser/TestDeleteIngestCheckpoint.c4gh", "testuser")
+	if err != nil {
+		ts.FailNow("failed to register file in database")
+	}
+
+	assert.NoError(ts.T(), ts.db.SetIngestCheckpoint(context.Background(), fileID, &database.IngestCheckpoint{
+		Location:  "http://s3:9000/archive",
+		FilePath:  fileID,
+		UploadID:  "upload-id",
+		HashState: []byte("hash state"),
+	}))
+	assert.NoError(ts.T(), ts.db.DeleteIngestCheckpoint(context.Background(), fileID))
+
+	checkpoint, err := ts.db.GetIngestCheckpoint(context.Background(), fileID)
+	assert.NoError(ts.T(), err)
+	ts.Nil(checkpoint)
+
+	// Deleting a non existing checkpoint is not an error
+	assert.NoError(ts.T(), ts.db.DeleteIngestCheckpoint(context.Background(), fileID))
+}
+
+func (ts *DatabaseTests) TestSetAndGetUploadChecksumState() {
+	for part := int32(1); part <= 3; part++ {
+		assert.NoError(ts.T(), ts.db.SetUploadChecksumState(context.Background(), &database.UploadChecksumState{
+			UploadID:      "TestSetAndGetUploadChecksumState",
+			PartNumber:    part,
+			BytesUploaded: int64(part) * 5 * 1024 * 1024,
+			SHA256State:   []byte(fmt.Sprintf("sha256 state %d", part)),
+			MD5State:      []byte(fmt.Sprintf("md5 state %d", part)),
+		}))
+	}
+
+	state, err := ts.db.GetUploadChecksumState(context.Background(), "TestSetAndGetUploadChecksumState", 3)
+	assert.NoError(ts.T(), err)
+	ts.Equal(&database.UploadChecksumState{
+		UploadID:      "TestSetAndGetUploadChecksumState",
+		PartNumber:    3,
+		BytesUploaded: 15 * 1024 * 1024,
+		SHA256State:   []byte("sha256 state 3"),
+		MD5State:      []byte("md5 state 3"),
+	}, state)
+
+	// Replacing the state of a part removes the states of the later parts
+	replaced := &database.UploadChecksumState{
+		UploadID:      "TestSetAndGetUploadChecksumState",
+		PartNumber:    2,
+		BytesUploaded: 10 * 1024 * 1024,
+		SHA256State:   []byte("replaced sha256 state"),
+		MD5State:      []byte("replaced md5 state"),
+	}
+	assert.NoError(ts.T(), ts.db.SetUploadChecksumState(context.Background(), replaced))
+
+	state, err = ts.db.GetUploadChecksumState(context.Background(), "TestSetAndGetUploadChecksumState", 2)
+	assert.NoError(ts.T(), err)
+	ts.Equal(replaced, state)
+
+	state, err = ts.db.GetUploadChecksumState(context.Background(), "TestSetAndGetUploadChecksumState", 3)
+	assert.NoError(ts.T(), err)
+	ts.Nil(state)
+}
+
+func (ts *DatabaseTests) TestDeleteUploadChecksumStates() {
+	for part := int32(1); part <= 2; part++ {
+		assert.NoError(ts.T(), ts.db.SetUploadChecksumState(context.Background(), &database.UploadChecksumState{
+			UploadID:    "TestDeleteUploadChecksumStates",
+			PartNumber:  part,
+			SHA256State: []byte("sha256 state"),
+			MD5State:    []byte("md5 state"),
+		}))
+	}
+	assert.NoError(ts.T(), ts.db.DeleteUploadChecksumStates(context.Background(), "TestDeleteUploadChecksumStates"))
+
+	for part := int32(1); part <= 2; part++ {
+		state, err := ts.db.GetUploadChecksumState(context.Background(), "TestDeleteUploadChecksumStates", part)
+		assert.NoError(ts.T(), err)
+		ts.Nil(state)
+	}
+}
+
+func (ts *DatabaseTests) TestAddUploadedChecksum() {
+	fileID, err := ts.db.RegisterFile(context.Background(), nil, "/inbox", "/testuser/TestAddUploadedChecksum.c4gh", "testuser")
+	if err != nil {
+		ts.FailNow("failed to register file in database")
+	}
+
+	assert.NoError(ts.T(), ts.db.AddUploadedChecksum(context.Background(), fileID, "a1b2c3", "sha256"))
+	assert.NoError(ts.T(), ts.db.AddUploadedChecksum(context.Background(), fileID, "d4e5f6", "md5"))
+	// Adding the checksum again replaces the previous one
+	assert.NoError(ts.T(), ts.db.AddUploadedChecksum(context.Background(), fileID, "b2c3d4", "sha256"))
+
+	var sha256Checksum, md5Checksum string
+	assert.NoError(ts.T(), ts.verificationDB.QueryRow("SELECT checksum FROM sda.checksums WHERE file_id = $1 AND source = 'UPLOADED' AND type = 'SHA256';", fileID).Scan(&sha256Checksum))
+	assert.NoError(ts.T(), ts.verificationDB.QueryRow("SELECT checksum FROM sda.checksums WHERE file_id = $1 AND source = 'UPLOADED' AND type = 'MD5';", fileID).Scan(&md5Checksum))
+	ts.Equal("b2c3d4", sha256Checksum)
+	ts.Equal("d4e5f6", md5Checksum)
+}
+
+func (ts *DatabaseTests) TestGetInboxCopyOfFile() {
+	sourceID, err := ts.db.RegisterFile(context.Background(), nil, "/inbox", "TestGetInboxCopyOfFile/source.c4gh", "testuser")
+	if err != nil {
+		ts.FailNow("failed to register file in database")
+	}
+	assert.NoError(ts.T(), ts.db.UpdateFileEventLog(context.Background(), sourceID, "uploaded", "testuser", "{}", "{}"))
+
+	copyPath, err := ts.db.GetInboxCopyOfFile(context.Background(), "testuser", "TestGetInboxCopyOfFile/source.c4gh")
+	assert.NoError(ts.T(), err)
+	ts.Empty(copyPath)
+
+	copyID, err := ts.db.RegisterFile(context.Background(), nil, "/inbox", "TestGetInboxCopyOfFile/copy.c4gh", "testuser")
+	if err != nil {
+		ts.FailNow("failed to register file in database")
+	}
+	assert.NoError(ts.T(), ts.db.UpdateFileEventLog(context.Background(), copyID, "uploaded", "testuser", `{"copied_from": "TestGetInboxCopyOfFile/source.c4gh"}`, "{}"))
+
+	copyPath, err = ts.db.GetInboxCopyOfFile(context.Background(), "testuser", "TestGetInboxCopyOfFile/source.c4gh")
+	assert.NoError(ts.T(), err)
+	ts.Equal("TestGetInboxCopyOfFile/copy.c4gh", copyPath)
+
+	// Copies of other users are not returned
+	copyPath, err = ts.db.GetInboxCopyOfFile(context.Background(), "otheruser", "TestGetInboxCopyOfFile/source.c4gh")
+	assert.NoError(ts.T(), err)
+	ts.Empty(copyPath)
+
+	// Copies which are no longer in the inbox are not returned
+	assert.NoError(ts.T(), ts.db.UpdateFileEventLog(context.Background(), copyID, "disabled", "testuser", "{}", "{}"))
+	copyPath, err = ts.db.GetInboxCopyOfFile(context.Background(), "testuser", "TestGetInboxCopyOfFile/source.c4gh")
+	assert.NoError(ts.T(), err)
+	ts.Empty(copyPath)
+}
+
+func (ts *DatabaseTests) TestGetFilesToScrub() {
+	var fileIDs []string
+	for _, name := range []string{"verified", "backedup", "archived"} {
+		fileID, err := ts.db.RegisterFile(context.Background(), nil, "/inbox", "/testuser/TestGetFilesToScrub_"+name+".c4gh", "testuser")
+		if err != nil {
+			ts.FailNow("failed to register file in database")
+		}
+
+		fileInfo := &database.FileInfo{
+			Size:              1000,
+			Path:              fileID,
+			ArchivedChecksum:  fmt.Sprintf("%x", sha256.Sum256([]byte(name))),
+			DecryptedChecksum: fmt.Sprintf("%x", sha256.New().Sum(nil)),
+			DecryptedSize:     999,
+			UploadedChecksum:  fmt.Sprintf("%x", sha256.New().Sum(nil)),
+		}
+		assert.NoError(ts.T(), ts.db.SetArchived(context.Background(), "/archive", fileInfo, fileID))
+		assert.NoError(ts.T(), ts.db.UpdateFileEventLog(context.Background(), fileID, "archived", "ingest", "{}", "{}"))
+		fileIDs = append(fileIDs, fileID)
+	}
+	assert.NoError(ts.T(), ts.db.UpdateFileEventLog(context.Background(), fileIDs[0], "verified", "verify", "{}", "{}"))
+	assert.NoError(ts.T(), ts.db.SetBackedUp(context.Background(), "/backup", fileIDs[1], fileIDs[1]))
+	assert.NoError(ts.T(), ts.db.UpdateFileEventLog(context.Background(), fileIDs[1], "backed up", "finalize", "{}", "{}"))
+
+	// Files which have not been verified yet are not scrubbed
+	files, err := ts.db.GetFilesToScrub(context.Background(), time.Now(), 10)
+	assert.NoError(ts.T(), err)
+	ts.Len(files, 2)
+	ts.ElementsMatch([]string{fileIDs[0], fileIDs[1]}, []string{files[0].FileID, files[1].FileID})
+
+	for _, file := range files {
+		ts.Equal("/archive", file.ArchiveLocation)
+		ts.Equal(file.FileID, file.ArchiveFilePath)
+		ts.Equal(int64(1000), file.ArchiveFileSize)
+		if file.FileID == fileIDs[1] {
+			ts.Equal("/backup", file.BackupLocation)
+			ts.Equal(file.FileID, file.BackupFilePath)
+			ts.Equal(fmt.Sprintf("%x", sha256.Sum256([]byte("backedup"))), file.ArchivedChecksum)
+		} else {
+			ts.Empty(file.BackupLocation)
+			ts.Empty(file.BackupFilePath)
+			ts.Equal(fmt.Sprintf("%x", sha256.Sum256([]byte("verified"))), file.ArchivedChecksum)
+		}
+	}
+
+	// The file which has gone the longest without being scrubbed is returned first
+	assert.NoError(ts.T(), ts.db.SetFileScrubbed(context.Background(), fileIDs[0], true, ""))
+	files, err = ts.db.GetFilesToScrub(context.Background(), time.Now().Add(time.Hour), 1)
+	assert.NoError(ts.T(), err)
+	ts.Len(files, 1)
+	ts.Equal(fileIDs[1], files[0].FileID)
+
+	files, err = ts.db.GetFilesToScrub(context.Background(), time.Now().Add(-time.Hour), 10)
+	assert.NoError(ts.T(), err)
+	ts.Len(files, 1)
+	ts.Equal(fileIDs[1], files[0].FileID)
+}
+
+func (ts *DatabaseTests) TestSetFileScrubbed() {
+	fileID, err := ts.db.RegisterFile(context.Background(), nil, "/inbox", "/testuser/TestSetFileScrubbed.c4gh", "testuser")
+	if err != nil {
+		ts.FailNow("failed to register file in database")
+	}
+
+	assert.NoError(ts.T(), ts.db.SetFileScrubbed(context.Background(), fileID, false, "archived checksum mismatch"))
+
+	var success bool
+	var scrubError sql.NullString
+	var firstScrub time.Time
+	assert.NoError(ts.T(), ts.verificationDB.QueryRow("SELECT success, error, scrubbed_at FROM sda.file_scrubs WHERE file_id = $1", fileID).Scan(&success, &scrubError, &firstScrub))
+	ts.False(success)
+	ts.Equal("archived checksum mismatch", scrubError.String)
+
+	// Scrubbing the file again replaces the previous outcome
+	assert.NoError(ts.T(), ts.db.SetFileScrubbed(context.Background(), fileID, true, ""))
+
+	var lastScrub time.Time
+	assert.NoError(ts.T(), ts.verificationDB.QueryRow("SELECT success, error, scrubbed_at FROM sda.file_scrubs WHERE file_id = $1", fileID).Scan(&success, &scrubError, &lastScrub))
+	ts.True(success)
+	ts.False(scrubError.Valid)
+	ts.True(lastScrub.After(firstScrub))
+}
+
+func (ts *DatabaseTests) TestSetArchiveLocation() {
+	fileID, err := ts.db.RegisterFile(context.Background(), nil, "/inbox", "/testuser/TestSetArchiveLocation.c4gh", "testuser")
+	if err != nil {
+		ts.FailNow("failed to register file in database")
+	}
+	assert.NoError(ts.T(), ts.db.SetArchived(context.Background(), "/archive", &database.FileInfo{
+		Size:              1000,
+		Path:              fileID,
+		ArchivedChecksum:  fmt.Sprintf("%x", sha256.New().Sum(nil)),
+		DecryptedChecksum: fmt.Sprintf("%x", sha256.New().Sum(nil)),
+		DecryptedSize:     999,
+		UploadedChecksum:  fmt.Sprintf("%x", sha256.New().Sum(nil)),
+	}, fileID))
+
+	assert.NoError(ts.T(), ts.db.SetArchiveLocation(context.Background(), fileID, "/archive2", fileID))
+
+	archiveData, err := ts.db.GetArchived(context.Background(), fileID)
+	assert.NoError(ts.T(), err)
+	ts.Equal("/archive2", archiveData.Location)
+	ts.Equal(fileID, archiveData.FilePath)
+}
+
+func (ts *DatabaseTests) TestSetArchiveLocation_SharedArchiveObject() {
+	checksum := fmt.Sprintf("%x", sha256.Sum256([]byte("shared content")))
+	var fileIDs []string
+	for _, name := range []string{"first", "second"} {
+		fileID, err := ts.db.RegisterFile(context.Background(), nil, "/inbox", "/testuser/TestSetArchiveLocation_"+name+".c4gh", "testuser")
+		if err != nil {
+			ts.FailNow("failed to register file in database")
+		}
+		assert.NoError(ts.T(), ts.db.SetArchived(context.Background(), "/archive", &database.FileInfo{
+			Size:              1000,
+			Path:              checksum,
+			ArchivedChecksum:  checksum,
+			DecryptedChecksum: fmt.Sprintf("%x", sha256.New().Sum(nil)),
+			DecryptedSize:     999,
+			UploadedChecksum:  fmt.Sprintf("%x", sha256.New().Sum(nil)),
+		}, fileID))
+		assert.NoError(ts.T(), ts.db.ReferenceArchiveObject(context.Background(), "/archive", checksum, 1000, checksum))
+		fileIDs = append(fileIDs, fileID)
+	}
+
+	assert.NoError(ts.T(), ts.db.SetArchiveLocation(context.Background(), fileIDs[0], "/archive2", checksum))
+
+	for _, fileID := range fileIDs {
+		archiveData, err := ts.db.GetArchived(context.Background(), fileID)
+		assert.NoError(ts.T(), err)
+		ts.Equal("/archive2", archiveData.Location)
+	}
+	archiveObject, err := ts.db.GetArchiveObject(context.Background(), checksum, 1000)
+	assert.NoError(ts.T(), err)
+	ts.Equal("/archive2", archiveObject.Location)
+	ts.Equal(int64(2), archiveObject.ReferenceCount)
+}
+
+func (ts *DatabaseTests) TestSetArchiveLocation_FileID_Not_Exists() {
+	assert.EqualError(ts.T(), ts.db.SetArchiveLocation(context.Background(), uuid.NewString(), "/archive", "path"), sql.ErrNoRows.Error())
+}
+
+func (ts *DatabaseTests) TestMigrateArchiveLocation() {
+	fileID, err := ts.db.RegisterFile(context.Background(), nil, "/inbox", "/testuser/TestMigrateArchiveLocation.c4gh", "testuser")
+	if err != nil {
+		ts.FailNow("failed to register file in database")
+	}
+	assert.NoError(ts.T(), ts.db.SetArchived(context.Background(), "/archive", &database.FileInfo{
+		Size:              1000,
+		Path:              fileID,
+		ArchivedChecksum:  fmt.Sprintf("%x", sha256.New().Sum(nil)),
+		DecryptedChecksum: fmt.Sprintf("%x", sha256.New().Sum(nil)),
+		DecryptedSize:     999,
+		UploadedChecksum:  fmt.Sprintf("%x", sha256.New().Sum(nil)),
+	}, fileID))
+
+	migratedFrom, err := ts.db.GetMigratedFromLocation(context.Background(), fileID)
+	assert.NoError(ts.T(), err)
+	ts.Equal("", migratedFrom)
+
+	assert.NoError(ts.T(), ts.db.MigrateArchiveLocation(context.Background(), fileID, "/archive", "/archive2", fileID))
+
+	archiveData, err := ts.db.GetArchived(context.Background(), fileID)
+	assert.NoError(ts.T(), err)
+	ts.Equal("/archive2", archiveData.Location)
+	migratedFrom, err = ts.db.GetMigratedFromLocation(context.Background(), fileID)
+	assert.NoError(ts.T(), err)
+	ts.Equal("/archive", migratedFrom)
+
+	// The file is no longer archived at the source location
+	assert.ErrorIs(ts.T(), ts.db.MigrateArchiveLocation(context.Background(), fileID, "/archive", "/archive3", fileID), sql.ErrNoRows)
+	archiveData, err = ts.db.GetArchived(context.Background(), fileID)
+	assert.NoError(ts.T(), err)
+	ts.Equal("/archive2", archiveData.Location)
+}
+
+func (ts *DatabaseTests) TestMigrateArchiveLocation_FileID_Not_Exists() {
+	assert.ErrorIs(ts.T(), ts.db.MigrateArchiveLocation(context.Background(), uuid.NewString(), "/archive", "/archive2", "path"), sql.ErrNoRows)
+
+	migratedFrom, err := ts.db.GetMigratedFromLocation(context.Background(), uuid.NewString())
+	assert.NoError(ts.T(), err)
+	ts.Equal("", migratedFrom)
+}
+
+func (ts *DatabaseTests) TestGetFileIDsInArchiveLocation() {
+	var fileIDs []string
+	for i, user := range []string{"User-A", "User-A", "User-B"} {
+		fileID, err := ts.db.RegisterFile(context.Background(), nil, "/inbox", fmt.Sprintf("/%s/TestGetFileIDsInArchiveLocation-%d.c4gh", user, i), user)
+		if err != nil {
+			ts.FailNow("failed to register file in database")
+		}
+		location := "/archive"
+		if i == 1 {
+			location = "/archive2"
+		}
+		assert.NoError(ts.T(), ts.db.SetArchived(context.Background(), location, &database.FileInfo{
+			Size:              1000,
+			Path:              fileID,
+			ArchivedChecksum:  fmt.Sprintf("%x", sha256.New().Sum(nil)),
+			DecryptedChecksum: fmt.Sprintf("%x", sha256.New().Sum(nil)),
+			DecryptedSize:     999,
+			UploadedChecksum:  fmt.Sprintf("%x", sha256.New().Sum(nil)),
+		}, fileID))
+		fileIDs = append(fileIDs, fileID)
+	}
+	assert.NoError(ts.T(), ts.db.SetAccessionID(context.Background(), "TestGetFileIDsInArchiveLocation-accession", fileIDs[2]))
+	assert.NoError(ts.T(), ts.db.MapFileToDataset(context.Background(), "TestGetFileIDsInArchiveLocation-dataset", fileIDs[2]))
+
+	files, err := ts.db.GetFileIDsInArchiveLocation(context.Background(), "/archive", "", "")
+	assert.NoError(ts.T(), err)
+	assert.ElementsMatch(ts.T(), []string{fileIDs[0], fileIDs[2]}, files)
+
+	files, err = ts.db.GetFileIDsInArchiveLocation(context.Background(), "/archive", "", "User-A")
+	assert.NoError(ts.T(), err)
+	assert.Equal(ts.T(), []string{fileIDs[0]}, files)
+
+	files, err = ts.db.GetFileIDsInArchiveLocation(context.Background(), "/archive", "TestGetFileIDsInArchiveLocation-dataset", "")
+	assert.NoError(ts.T(), err)
+	assert.Equal(ts.T(), []string{fileIDs[2]}, files)
+
+	files, err = ts.db.GetFileIDsInArchiveLocation(context.Background(), "/archive3", "", "")
+	assert.NoError(ts.T(), err)
+	assert.Empty(ts.T(), files)
+}
+
+func (ts *DatabaseTests) TestIsArchivedObjectReferenced() {
+	fileID, err := ts.db.RegisterFile(context.Background(), nil, "/inbox", "/testuser/TestIsArchivedObjectReferenced.c4gh", "testuser")
+	if err != nil {
+		ts.FailNow("failed to register file in database")
+	}
+	assert.NoError(ts.T(), ts.db.SetArchived(context.Background(), "/archive", &database.FileInfo{
+		Size:              1000,
+		Path:              fileID,
+		ArchivedChecksum:  fmt.Sprintf("%x", sha256.New().Sum(nil)),
+		DecryptedChecksum: fmt.Sprintf("%x", sha256.New().Sum(nil)),
+		DecryptedSize:     999,
+		UploadedChecksum:  fmt.Sprintf("%x", sha256.New().Sum(nil)),
+	}, fileID))
+
+	referenced, err := ts.db.IsArchivedObjectReferenced(context.Background(), "/archive", fileID)
+	assert.NoError(ts.T(), err)
+	assert.True(ts.T(), referenced)
+
+	assert.NoError(ts.T(), ts.db.SetArchiveLocation(context.Background(), fileID, "/archive2", fileID))
+
+	referenced, err = ts.db.IsArchivedObjectReferenced(context.Background(), "/archive", fileID)
+	assert.NoError(ts.T(), err)
+	assert.False(ts.T(), referenced)
+}
+
+func (ts *DatabaseTests) TestSetGetAndDeleteInboxQuota() {
+	quota, err := ts.db.GetInboxQuota(context.Background(), "TestInboxQuota")
+	assert.NoError(ts.T(), err)
+	ts.Nil(quota)
+
+	assert.NoError(ts.T(), ts.db.SetInboxQuota(context.Background(), &database.InboxQuota{User: "TestInboxQuota", MaxBytes: 1000, MaxFiles: 10}))
+	assert.NoError(ts.T(), ts.db.SetInboxQuota(context.Background(), &database.InboxQuota{User: "TestInboxQuota", MaxBytes: 2000, MaxFiles: 0}))
+
+	quota, err = ts.db.GetInboxQuota(context.Background(), "TestInboxQuota")
+	assert.NoError(ts.T(), err)
+	ts.Equal(&database.InboxQuota{User: "TestInboxQuota", MaxBytes: 2000, MaxFiles: 0}, quota)
+
+	assert.NoError(ts.T(), ts.db.DeleteInboxQuota(context.Background(), "TestInboxQuota"))
+	quota, err = ts.db.GetInboxQuota(context.Background(), "TestInboxQuota")
+	assert.NoError(ts.T(), err)
+	ts.Nil(quota)
+}
+
+func (ts *DatabaseTests) TestGetInboxUsage() {
+	usage, err := ts.db.GetInboxUsage(context.Background(), "TestGetInboxUsage", "")
+	assert.NoError(ts.T(), err)
+	ts.Equal(&database.InboxUsage{Bytes: 0, Files: 0}, usage)
+
+	var fileIDs []string
+	for i, name := range []string{"uploaded", "registered", "disabled", "mapped"} {
+		fileID, err := ts.db.RegisterFile(context.Background(), nil, "/inbox", "TestGetInboxUsage/"+name+".c4gh", "TestGetInboxUsage")
+		if err != nil {
+			ts.FailNow("failed to register file in database")
+		}
+		if name != "registered" {
+			assert.NoError(ts.T(), ts.db.SetSubmissionFileSize(context.Background(), fileID, int64(100*(i+1))))
+			assert.NoError(ts.T(), ts.db.UpdateFileEventLog(context.Background(), fileID, "uploaded", "TestGetInboxUsage", "{}", "{}"))
+		}
+		fileIDs = append(fileIDs, fileID)
+	}
+	assert.NoError(ts.T(), ts.db.UpdateFileEventLog(context.Background(), fileIDs[2], "disabled", "TestGetInboxUsage", "{}", "{}"))
+	assert.NoError(ts.T(), ts.db.MapFileToDataset(context.Background(), "TestGetInboxUsage", fileIDs[3]))
+
+	// Disabled files and files mapped to a dataset are no longer in the inbox, files being uploaded have no size yet
+	usage, err = ts.db.GetInboxUsage(context.Background(), "TestGetInboxUsage", "")
+	assert.NoError(ts.T(), err)
+	ts.Equal(&database.InboxUsage{Bytes: 100, Files: 2}, usage)
+
+	// The usage without a file which is about to be replaced
+	usage, err = ts.db.GetInboxUsage(context.Background(), "TestGetInboxUsage", fileIDs[0])
+	assert.NoError(ts.T(), err)
+	ts.Equal(&database.InboxUsage{Bytes: 0, Files: 1}, usage)
+}
+
+func (ts *DatabaseTests) TestGetStaleInboxFiles() {
+	var fileIDs []string
+	for _, name := range []string{"uploaded", "registered", "mapped", "other_uploaded"} {
+		fileID, err := ts.db.RegisterFile(context.Background(), nil, "/inbox", "TestGetStaleInboxFiles/"+name+".c4gh", "TestGetStaleInboxFiles")
+		if err != nil {
+			ts.FailNow("failed to register file in database")
+		}
+		if name != "registered" {
+			assert.NoError(ts.T(), ts.db.UpdateFileEventLog(context.Background(), fileID, "uploaded", "TestGetStaleInboxFiles", "{}", "{}"))
+		}
+		fileIDs = append(fileIDs, fileID)
+	}
+	assert.NoError(ts.T(), ts.db.MapFileToDataset(context.Background(), "TestGetStaleInboxFiles", fileIDs[2]))
+
+	// Files mapped to a dataset are no longer in the inbox
+	files, err := ts.db.GetStaleInboxFiles(context.Background(), "uploaded", time.Now().Add(time.Minute), "", 10)
+	assert.NoError(ts.T(), err)
+	ts.Len(files, 2)
+	ts.ElementsMatch([]string{fileIDs[0], fileIDs[3]}, []string{files[0].FileID, files[1].FileID})
+	for _, file := range files {
+		ts.Equal("TestGetStaleInboxFiles", file.User)
+		ts.Equal("/inbox", file.Location)
+		ts.WithinDuration(time.Now(), file.LastEventAt, time.Minute)
+		ts.True(file.WarnedAt.IsZero())
+	}
+
+	// Files are paginated by file id
+	next, err := ts.db.GetStaleInboxFiles(context.Background(), "uploaded", time.Now().Add(time.Minute), files[0].FileID, 10)
+	assert.NoError(ts.T(), err)
+	ts.Len(next, 1)
+	ts.Equal(files[1].FileID, next[0].FileID)
+
+	// Files with a more recent last event are not stale
+	files, err = ts.db.GetStaleInboxFiles(context.Background(), "uploaded", time.Now().Add(-time.Minute), "", 10)
+	assert.NoError(ts.T(), err)
+	ts.Empty(files)
+
+	files, err = ts.db.GetStaleInboxFiles(context.Background(), "registered", time.Now().Add(time.Minute), "", 10)
+	assert.NoError(ts.T(), err)
+	ts.Len(files, 1)
+	ts.Equal(fileIDs[1], files[0].FileID)
+	ts.Equal("TestGetStaleInboxFiles/registered.c4gh", files[0].FilePath)
+}
+
+func (ts *DatabaseTests) TestDisableInboxFile() {
+	fileID, err := ts.db.RegisterFile(context.Background(), nil, "/inbox", "TestDisableInboxFile.c4gh", "TestDisableInboxFile")
+	if err != nil {
+		ts.FailNow("failed to register file in database")
+	}
+	assert.NoError(ts.T(), ts.db.UpdateFileEventLog(context.Background(), fileID, "uploaded", "TestDisableInboxFile", "{}", "{}"))
+	files, err := ts.db.GetStaleInboxFiles(context.Background(), "uploaded", time.Now().Add(time.Minute), "", 10)
+	assert.NoError(ts.T(), err)
+	var file *database.InboxFile
+	for _, f := range files {
+		if f.FileID == fileID {
+			file = f
+		}
+	}
+	if file == nil {
+		ts.FailNow("file not found in the inbox")
+
+		return
+	}
+
+	// A file which has been uploaded again since it was found is not disabled
+	disabled, err := ts.db.DisableInboxFile(context.Background(), fileID, "uploaded", file.LastEventAt.Add(-time.Second), `{"reason": "test"}`)
+	assert.NoError(ts.T(), err)
+	ts.False(disabled)
+	disabled, err = ts.db.DisableInboxFile(context.Background(), fileID, "registered", file.LastEventAt, `{"reason": "test"}`)
+	assert.NoError(ts.T(), err)
+	ts.False(disabled)
+
+	// The event is only kept when the transaction is committed
+	tx, err := ts.db.BeginTransaction(context.Background())
+	assert.NoError(ts.T(), err)
+	disabled, err = tx.DisableInboxFile(context.Background(), fileID, "uploaded", file.LastEventAt, `{"reason": "test"}`)
+	assert.NoError(ts.T(), err)
+	ts.True(disabled)
+	assert.NoError(ts.T(), tx.Rollback())
+	status, err := ts.db.GetFileStatus(context.Background(), fileID)
+	assert.NoError(ts.T(), err)
+	ts.Equal("uploaded", status)
+
+	disabled, err = ts.db.DisableInboxFile(context.Background(), fileID, "uploaded", file.LastEventAt, `{"reason": "test"}`)
+	assert.NoError(ts.T(), err)
+	ts.True(disabled)
+	status, err = ts.db.GetFileStatus(context.Background(), fileID)
+	assert.NoError(ts.T(), err)
+	ts.Equal("disabled", status)
+}
+
+func (ts *DatabaseTests) TestSetInboxExpiryWarned() {
+	fileID, err := ts.db.RegisterFile(context.Background(), nil, "/inbox", "TestSetInboxExpiryWarned.c4gh", "testuser")
+	if err != nil {
+		ts.FailNow("failed to register file in database")
+	}
+	assert.NoError(ts.T(), ts.db.UpdateFileEventLog(context.Background(), fileID, "uploaded", "testuser", "{}", "{}"))
+
+	assert.NoError(ts.T(), ts.db.SetInboxExpiryWarned(context.Background(), fileID))
+	files, err := ts.db.GetStaleInboxFiles(context.Background(), "uploaded", time.Now().Add(time.Minute), "", 10)
+	assert.NoError(ts.T(), err)
+	ts.Len(files, 1)
+	firstWarning := files[0].WarnedAt
+	ts.WithinDuration(time.Now(), firstWarning, time.Minute)
+
+	// Warning the user again updates the time of the warning
+	assert.NoError(ts.T(), ts.db.SetInboxExpiryWarned(context.Background(), fileID))
+	files, err = ts.db.GetStaleInboxFiles(context.Background(), "uploaded", time.Now().Add(time.Minute), "", 10)
+	assert.NoError(ts.T(), err)
+	ts.Len(files, 1)
+	ts.True(files[0].WarnedAt.After(firstWarning))
+}
+
+func (ts *DatabaseTests) TestSetAndGetFileSyncState() {
+	fileID, err := ts.db.RegisterFile(context.Background(), nil, "/inbox", "TestSetAndGetFileSyncState.c4gh", "testuser")
+	if err != nil {
+		ts.FailNow("failed to register file in database")
+	}
+	assert.NoError(ts.T(), ts.db.SetAccessionID(context.Background(), "TestSetAndGetFileSyncState-accession", fileID))
+
+	state, err := ts.db.GetFileSyncState(context.Background(), "TestSetAndGetFileSyncState-accession", "remote")
+	assert.NoError(ts.T(), err)
+	ts.Nil(state)
+
+	assert.NoError(ts.T(), ts.db.SetFileSyncState(context.Background(), &database.FileSyncState{
+		AccessionID: "TestSetAndGetFileSyncState-accession",
+		Destination: "remote",
+		Status:      "pending",
+		Attempts:    1,
+		LastError:   "failed",
+	}))
+	state, err = ts.db.GetFileSyncState(context.Background(), "TestSetAndGetFileSyncState-accession", "remote")
+	assert.NoError(ts.T(), err)
+	ts.Equal("pending", state.Status)
+	ts.Equal(1, state.Attempts)
+	ts.Equal("failed", state.LastError)
+	ts.WithinDuration(time.Now(), state.UpdatedAt, time.Minute)
+
+	// Setting the state again replaces the previous state
+	assert.NoError(ts.T(), ts.db.SetFileSyncState(context.Background(), &database.FileSyncState{
+		AccessionID: "TestSetAndGetFileSyncState-accession",
+		Destination: "remote",
+		Status:      "verified",
+		Location:    "/sync",
+		Size:        1234,
+		Checksum:    "checksum",
+		Attempts:    2,
+	}))
+	state, err = ts.db.GetFileSyncState(context.Background(), "TestSetAndGetFileSyncState-accession", "remote")
+	assert.NoError(ts.T(), err)
+	ts.Equal(&database.FileSyncState{
+		AccessionID: "TestSetAndGetFileSyncState-accession",
+		Destination: "remote",
+		Status:      "verified",
+		Location:    "/sync",
+		Size:        1234,
+		Checksum:    "checksum",
+		Attempts:    2,
+		UpdatedAt:   state.UpdatedAt,
+	}, state)
+
+	state, err = ts.db.GetFileSyncState(context.Background(), "TestSetAndGetFileSyncState-accession", "other")
+	assert.NoError(ts.T(), err)
+	ts.Nil(state)
+
+	assert.Error(ts.T(), ts.db.SetFileSyncState(context.Background(), &database.FileSyncState{AccessionID: "missing", Destination: "remote", Status: "pending"}))
+}
+
+func (ts *DatabaseTests) TestGetDatasetSyncStates() {
+	for _, name := range []string{"synced", "unsynced"} {
+		fileID, err := ts.db.RegisterFile(context.Background(), nil, "/inbox", "TestGetDatasetSyncStates/"+name+".c4gh", "testuser")
+		if err != nil {
+			ts.FailNow("failed to register file in database")
+		}
+		assert.NoError(ts.T(), ts.db.SetAccessionID(context.Background(), "TestGetDatasetSyncStates-"+name, fileID))
+		assert.NoError(ts.T(), ts.db.MapFileToDataset(context.Background(), "TestGetDatasetSyncStates", fileID))
+	}
+	assert.NoError(ts.T(), ts.db.SetFileSyncState(context.Background(), &database.FileSyncState{AccessionID: "TestGetDatasetSyncStates-synced", Destination: "remote", Status: "verified", Attempts: 1}))
+
+	states, err := ts.db.GetDatasetSyncStates(context.Background(), "TestGetDatasetSyncStates")
+	assert.NoError(ts.T(), err)
+	ts.Len(states, 2)
+	ts.Equal("TestGetDatasetSyncStates-synced", states[0].AccessionID)
+	ts.Equal("remote", states[0].Destination)
+	ts.Equal("verified", states[0].Status)
+	ts.Equal("TestGetDatasetSyncStates-unsynced", states[1].AccessionID)
+	ts.Equal("", states[1].Destination)
+	ts.Equal("pending", states[1].Status)
+
+	states, err = ts.db.GetDatasetSyncStates(context.Background(), "missing")
+	assert.NoError(ts.T(), err)
+	ts.Empty(states)
+}
+
+func (ts *DatabaseTests) TestGetUnconfirmedSyncFiles() {
+	for _, status := range []string{"verified", "confirmed", "pending"} {
+		fileID, err := ts.db.RegisterFile(context.Background(), nil, "/inbox", "TestGetUnconfirmedSyncFiles/"+status+".c4gh", "testuser")
+		if err != nil {
+			ts.FailNow("failed to register file in database")
+		}
+		assert.NoError(ts.T(), ts.db.SetAccessionID(context.Background(), "TestGetUnconfirmedSyncFiles-"+status, fileID))
+		assert.NoError(ts.T(), ts.db.SetFileSyncState(context.Background(), &database.FileSyncState{AccessionID: "TestGetUnconfirmedSyncFiles-" + status, Destination: "unconfirmed", Status: status}))
+	}
+
+	accessionIDs, err := ts.db.GetUnconfirmedSyncFiles(context.Background(), "unconfirmed")
+	assert.NoError(ts.T(), err)
+	ts.Equal([]string{"TestGetUnconfirmedSyncFiles-verified"}, accessionIDs)
+
+	accessionIDs, err = ts.db.GetUnconfirmedSyncFiles(context.Background(), "missing")
+	assert.NoError(ts.T(), err)
+	ts.Empty(accessionIDs)
+}
+
+func (ts *DatabaseTests) TestGetIngestionStatus() {
+	fileID, err := ts.db.RegisterFile(context.Background(), nil, "/inbox", "TestGetIngestionStatus.c4gh", "testuser")
+	if err != nil {
+		ts.FailNow("failed to register file in database")
+	}
+	assert.NoError(ts.T(), ts.db.UpdateFileEventLog(context.Background(), fileID, "uploaded", "testuser", "{}", "{}"))
+
+	// The file is found by its submission user and path until it has an accession id
+	status, err := ts.db.GetIngestionStatus(context.Background(), "TestGetIngestionStatus-accession", "testuser", "TestGetIngestionStatus.c4gh")
+	assert.NoError(ts.T(), err)
+	ts.Equal(&database.IngestionStatus{Status: "uploaded"}, status)
+
+	checksum := fmt.Sprintf("%x", sha256.New().Sum(nil))
+	assert.NoError(ts.T(), ts.db.SetVerified(context.Background(), &database.FileInfo{
+		Size:              1000,
+		Path:              "/testuser/TestGetIngestionStatus.c4gh",
+		ArchivedChecksum:  checksum,
+		DecryptedChecksum: checksum,
+		DecryptedSize:     948,
+		UploadedChecksum:  checksum,
+	}, fileID))
+	assert.NoError(ts.T(), ts.db.SetAccessionID(context.Background(), "TestGetIngestionStatus-accession", fileID))
+	assert.NoError(ts.T(), ts.db.UpdateFileEventLog(context.Background(), fileID, "ready", "finalize", "{}", "{}"))
+
+	status, err = ts.db.GetIngestionStatus(context.Background(), "TestGetIngestionStatus-accession", "otheruser", "other.c4gh")
+	assert.NoError(ts.T(), err)
+	ts.Equal(&database.IngestionStatus{AccessionID: "TestGetIngestionStatus-accession", Status: "ready", DecryptedChecksum: checksum}, status)
+
+	// The dataset of the file is known once it has been mapped
+	assert.NoError(ts.T(), ts.db.MapFileToDataset(context.Background(), "TestGetIngestionStatus-dataset", fileID))
+	status, err = ts.db.GetIngestionStatus(context.Background(), "TestGetIngestionStatus-accession", "otheruser", "other.c4gh")
+	assert.NoError(ts.T(), err)
+	ts.Equal("TestGetIngestionStatus-dataset", status.DatasetID)
+
+	status, err = ts.db.GetIngestionStatus(context.Background(), "missing", "testuser", "missing.c4gh")
+	assert.NoError(ts.T(), err)
+	ts.Nil(status)
+}
+
+func (ts *DatabaseTests) TestRegisterSyncAPIRequest() {
+	now := time.Now()
+	expiresAt := now.Add(10 * time.Minute)
+
+	registered, err := ts.db.RegisterSyncAPIRequest(context.Background(), "site-a", "TestRegisterSyncAPIRequest", expiresAt, now)
+	assert.NoError(ts.T(), err)
+	ts.True(registered)
+
+	// Replayed request IDs are not registered, while request IDs of other partners are
+	registered, err = ts.db.RegisterSyncAPIRequest(context.Background(), "site-a", "TestRegisterSyncAPIRequest", expiresAt.Add(time.Minute), now.Add(time.Minute))
+	assert.NoError(ts.T(), err)
+	ts.False(registered)
+	registered, err = ts.db.RegisterSyncAPIRequest(context.Background(), "", "TestRegisterSyncAPIRequest", expiresAt, now)
+	assert.NoError(ts.T(), err)
+	ts.True(registered)
+
+	// An expired request ID is registered again, even if it has not been deleted yet
+	registered, err = ts.db.RegisterSyncAPIRequest(context.Background(), "site-a", "TestRegisterSyncAPIRequest", expiresAt.Add(11*time.Minute), now.Add(11*time.Minute))
+	assert.NoError(ts.T(), err)
+	ts.True(registered)
+}
+
+func (ts *DatabaseTests) TestDeleteSyncAPIRequests() {
+	now := time.Now()
+	for i, expiresAt := range []time.Time{now.Add(-2 * time.Hour), now.Add(-2 * time.Hour), now.Add(2 * time.Hour)} {
+		registered, err := ts.db.RegisterSyncAPIRequest(context.Background(), "site-a", fmt.Sprintf("TestDeleteSyncAPIRequests-%d", i), expiresAt, now.Add(-3*time.Hour))
+		assert.NoError(ts.T(), err)
+		ts.True(registered)
+	}
+
+	deleted, err := ts.db.DeleteSyncAPIRequests(context.Background(), now.Add(-time.Hour))
+	assert.NoError(ts.T(), err)
+	ts.Equal(int64(2), deleted)
+
+	// The request ID which has not expired is still registered
+	registered, err := ts.db.RegisterSyncAPIRequest(context.Background(), "site-a", "TestDeleteSyncAPIRequests-2", now.Add(2*time.Hour), now)
+	assert.NoError(ts.T(), err)
+	ts.False(registered)
+}
+
+func (ts *DatabaseTests) TestReleaseSyncAPIRequest() {
+	now := time.Now()
+	expiresAt := now.Add(10 * time.Minute)
+	for _, partner := range []string{"site-a", "site-b"} {
+		registered, err := ts.db.RegisterSyncAPIRequest(context.Background(), partner, "TestReleaseSyncAPIRequest", expiresAt, now)
+		assert.NoError(ts.T(), err)
+		ts.True(registered)
+	}
+
+	assert.NoError(ts.T(), ts.db.ReleaseSyncAPIRequest(context.Background(), "site-a", "TestReleaseSyncAPIRequest"))
+
+	// Only the released request ID can be registered again
+	registered, err := ts.db.RegisterSyncAPIRequest(context.Background(), "site-a", "TestReleaseSyncAPIRequest", expiresAt, now)
+	assert.NoError(ts.T(), err)
+	ts.True(registered)
+	registered, err = ts.db.RegisterSyncAPIRequest(context.Background(), "site-b", "TestReleaseSyncAPIRequest", expiresAt, now)
+	assert.NoError(ts.T(), err)
+	ts.False(registered)
+}
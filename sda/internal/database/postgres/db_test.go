@@ -151,7 +151,7 @@ func (ts *DatabaseTests) SetupTest() {
 	}
 
 	assert.Nil(ts.T(), err, "got %v when creating new connection", err)
-	_, err = ts.verificationDB.Exec("TRUNCATE sda.files, sda.encryption_keys CASCADE")
+	_, err = ts.verificationDB.Exec("TRUNCATE sda.files, sda.encryption_keys, sda.archive_objects CASCADE")
 	assert.NoError(ts.T(), err)
 }
 
@@ -0,0 +1,30 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+const addUploadedChecksumQuery = "addUploadedChecksum"
+
+func init() {
+	queries[addUploadedChecksumQuery] = `
+INSERT INTO sda.checksums(file_id, checksum, type, source)
+VALUES($1, $2, upper($3)::sda.checksum_algorithm, upper('UPLOADED')::sda.checksum_source)
+ON CONFLICT ON CONSTRAINT unique_checksum DO UPDATE SET checksum = EXCLUDED.checksum;
+`
+}
+
+func (db *pgDb) addUploadedChecksum(ctx context.Context, tx *sql.Tx, fileID, checksum, algorithm string) error {
+	stmt, err := db.getPreparedStmt(tx, addUploadedChecksumQuery)
+	if err != nil {
+		return err
+	}
+
+	if _, err := stmt.ExecContext(ctx, fileID, checksum, algorithm); err != nil {
+		return fmt.Errorf("addUploadedChecksum error: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,29 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+const deleteInboxQuotaQuery = "deleteInboxQuota"
+
+func init() {
+	queries[deleteInboxQuotaQuery] = `
+DELETE FROM sda.inbox_quotas
+WHERE submission_user = $1;
+`
+}
+
+func (db *pgDb) deleteInboxQuota(ctx context.Context, tx *sql.Tx, submissionUser string) error {
+	stmt, err := db.getPreparedStmt(tx, deleteInboxQuotaQuery)
+	if err != nil {
+		return err
+	}
+
+	if _, err := stmt.ExecContext(ctx, submissionUser); err != nil {
+		return fmt.Errorf("deleteInboxQuota error: %w", err)
+	}
+
+	return nil
+}
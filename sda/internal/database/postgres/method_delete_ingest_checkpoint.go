@@ -0,0 +1,29 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+const deleteIngestCheckpointQuery = "deleteIngestCheckpoint"
+
+func init() {
+	queries[deleteIngestCheckpointQuery] = `
+DELETE FROM sda.ingest_checkpoints
+WHERE file_id = $1;
+`
+}
+
+func (db *pgDb) deleteIngestCheckpoint(ctx context.Context, tx *sql.Tx, fileID string) error {
+	stmt, err := db.getPreparedStmt(tx, deleteIngestCheckpointQuery)
+	if err != nil {
+		return err
+	}
+
+	if _, err := stmt.ExecContext(ctx, fileID); err != nil {
+		return fmt.Errorf("deleteIngestCheckpoint error: %w", err)
+	}
+
+	return nil
+}
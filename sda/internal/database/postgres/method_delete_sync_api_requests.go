@@ -0,0 +1,31 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const deleteSyncAPIRequestsQuery = "deleteSyncAPIRequests"
+
+func init() {
+	queries[deleteSyncAPIRequestsQuery] = `
+DELETE FROM sda.sync_api_requests
+WHERE expires_at < $1;
+`
+}
+
+func (db *pgDb) deleteSyncAPIRequests(ctx context.Context, tx *sql.Tx, expiredBefore time.Time) (int64, error) {
+	stmt, err := db.getPreparedStmt(tx, deleteSyncAPIRequestsQuery)
+	if err != nil {
+		return 0, err
+	}
+
+	r, err := stmt.ExecContext(ctx, expiredBefore)
+	if err != nil {
+		return 0, fmt.Errorf("deleteSyncAPIRequests error: %w", err)
+	}
+
+	return r.RowsAffected()
+}
@@ -0,0 +1,29 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+const deleteUploadChecksumStatesQuery = "deleteUploadChecksumStates"
+
+func init() {
+	queries[deleteUploadChecksumStatesQuery] = `
+DELETE FROM sda.upload_checksum_states
+WHERE upload_id = $1;
+`
+}
+
+func (db *pgDb) deleteUploadChecksumStates(ctx context.Context, tx *sql.Tx, uploadID string) error {
+	stmt, err := db.getPreparedStmt(tx, deleteUploadChecksumStatesQuery)
+	if err != nil {
+		return err
+	}
+
+	if _, err := stmt.ExecContext(ctx, uploadID); err != nil {
+		return fmt.Errorf("deleteUploadChecksumStates error: %w", err)
+	}
+
+	return nil
+}
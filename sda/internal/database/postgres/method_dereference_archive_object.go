@@ -0,0 +1,57 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+const (
+	dereferenceArchiveObjectQuery       = "dereferenceArchiveObject"
+	dereferenceArchiveObjectDeleteQuery = "dereferenceArchiveObjectDelete"
+)
+
+func init() {
+	queries[dereferenceArchiveObjectQuery] = `
+UPDATE sda.archive_objects
+SET reference_count = reference_count - 1
+WHERE archive_location = $1 AND archive_file_path = $2
+RETURNING reference_count;
+`
+	queries[dereferenceArchiveObjectDeleteQuery] = `
+DELETE FROM sda.archive_objects
+WHERE archive_location = $1 AND archive_file_path = $2 AND reference_count <= 0;
+`
+}
+
+func (db *pgDb) dereferenceArchiveObject(ctx context.Context, tx *sql.Tx, location, filePath string) (bool, error) {
+	stmt, err := db.getPreparedStmt(tx, dereferenceArchiveObjectQuery)
+	if err != nil {
+		return false, err
+	}
+	deleteStmt, err := db.getPreparedStmt(tx, dereferenceArchiveObjectDeleteQuery)
+	if err != nil {
+		return false, err
+	}
+
+	var referenceCount int64
+	if err := stmt.QueryRowContext(ctx, location, filePath).Scan(&referenceCount); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// Not a registered archive object, so only referenced by a single file
+			return false, nil
+		}
+
+		return false, fmt.Errorf("dereferenceArchiveObject error: %w", err)
+	}
+
+	if referenceCount > 0 {
+		return true, nil
+	}
+
+	if _, err := deleteStmt.ExecContext(ctx, location, filePath); err != nil {
+		return false, fmt.Errorf("dereferenceArchiveObject error: %w", err)
+	}
+
+	return false, nil
+}
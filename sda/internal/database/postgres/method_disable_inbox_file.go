@@ -0,0 +1,43 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const disableInboxFileQuery = "disableInboxFile"
+
+func init() {
+	// The event is only logged when the file has not changed since it was found by housekeeping, logging the event
+	// updates the last event of the file, which locks the file for the remainder of the transaction
+	queries[disableInboxFileQuery] = `
+INSERT INTO sda.file_event_log(file_id, event, user_id, details, message)
+SELECT f.id, 'disabled', 'housekeeping', CAST($4 AS JSONB), '{}'
+FROM sda.files AS f
+WHERE f.id = $1
+AND f.last_event = $2
+AND f.archive_file_path = ''
+AND (SELECT max(started_at) FROM sda.file_event_log WHERE file_id = f.id) = $3;
+`
+}
+
+func (db *pgDb) disableInboxFile(ctx context.Context, tx *sql.Tx, fileID, lastEvent string, lastEventAt time.Time, details string) (bool, error) {
+	stmt, err := db.getPreparedStmt(tx, disableInboxFileQuery)
+	if err != nil {
+		return false, err
+	}
+
+	r, err := stmt.ExecContext(ctx, fileID, lastEvent, lastEventAt, details)
+	if err != nil {
+		return false, fmt.Errorf("disableInboxFile error: %w", err)
+	}
+
+	rowsAffected, err := r.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("disableInboxFile error: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
@@ -0,0 +1,46 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+)
+
+const getArchiveObjectQuery = "getArchiveObject"
+
+func init() {
+	queries[getArchiveObjectQuery] = `
+SELECT archive_location, archive_file_path, archive_file_size, checksum, reference_count
+FROM sda.archive_objects
+WHERE checksum = $1 AND archive_file_size = $2 AND reference_count > 0
+ORDER BY created_at
+LIMIT 1
+FOR UPDATE;
+`
+}
+
+func (db *pgDb) getArchiveObject(ctx context.Context, tx *sql.Tx, checksum string, size int64) (*database.ArchiveObject, error) {
+	stmt, err := db.getPreparedStmt(tx, getArchiveObjectQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	archiveObject := new(database.ArchiveObject)
+	if err := stmt.QueryRowContext(ctx, checksum, size).Scan(
+		&archiveObject.Location,
+		&archiveObject.FilePath,
+		&archiveObject.FileSize,
+		&archiveObject.Checksum,
+		&archiveObject.ReferenceCount,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return archiveObject, nil
+}
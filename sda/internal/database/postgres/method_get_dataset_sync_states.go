@@ -0,0 +1,56 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+)
+
+const getDatasetSyncStatesQuery = "getDatasetSyncStates"
+
+func init() {
+	queries[getDatasetSyncStatesQuery] = `
+SELECT f.stable_id, COALESCE(s.destination, ''), COALESCE(s.status, 'pending'), COALESCE(s.location, ''), COALESCE(s.size, 0), COALESCE(s.checksum, ''), COALESCE(s.attempts, 0), COALESCE(s.last_error, ''), COALESCE(s.updated_at, 'epoch')
+FROM sda.datasets AS d
+INNER JOIN sda.file_dataset AS fd ON fd.dataset_id = d.id
+INNER JOIN sda.files AS f ON f.id = fd.file_id
+LEFT JOIN sda.sync_files AS s ON s.file_id = f.id
+WHERE d.stable_id = $1
+ORDER BY f.stable_id, s.destination;
+`
+}
+
+func (db *pgDb) getDatasetSyncStates(ctx context.Context, tx *sql.Tx, datasetID string) ([]*database.FileSyncState, error) {
+	stmt, err := db.getPreparedStmt(tx, getDatasetSyncStatesQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.QueryContext(ctx, datasetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var states []*database.FileSyncState
+	for rows.Next() {
+		state := new(database.FileSyncState)
+		if err := rows.Scan(
+			&state.AccessionID,
+			&state.Destination,
+			&state.Status,
+			&state.Location,
+			&state.Size,
+			&state.Checksum,
+			&state.Attempts,
+			&state.LastError,
+			&state.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+
+	return states, rows.Err()
+}
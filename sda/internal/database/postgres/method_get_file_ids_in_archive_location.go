@@ -0,0 +1,55 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+)
+
+const getFileIDsInArchiveLocationQuery = "getFileIDsInArchiveLocation"
+
+func init() {
+	// An empty dataset id or user matches files of any dataset or user
+	queries[getFileIDsInArchiveLocationQuery] = `
+SELECT f.id
+FROM sda.files AS f
+WHERE f.archive_location = $1
+AND ($2 = '' OR EXISTS (
+	SELECT 1
+	FROM sda.file_dataset AS fd
+	INNER JOIN sda.datasets AS d ON d.id = fd.dataset_id
+	WHERE fd.file_id = f.id AND d.stable_id = $2))
+AND ($3 = '' OR f.submission_user = $3)
+ORDER BY f.id;
+`
+}
+
+func (db *pgDb) getFileIDsInArchiveLocation(ctx context.Context, tx *sql.Tx, location, datasetID, user string) ([]string, error) {
+	stmt, err := db.getPreparedStmt(tx, getFileIDsInArchiveLocationQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileIDs []string
+	rows, err := stmt.QueryContext(ctx, location, datasetID, user)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	for rows.Next() {
+		var fileID string
+		if err := rows.Scan(&fileID); err != nil {
+			return nil, err
+		}
+
+		fileIDs = append(fileIDs, fileID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return fileIDs, nil
+}
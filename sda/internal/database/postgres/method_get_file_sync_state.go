@@ -0,0 +1,48 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+)
+
+const getFileSyncStateQuery = "getFileSyncState"
+
+func init() {
+	queries[getFileSyncStateQuery] = `
+SELECT f.stable_id, s.destination, s.status, COALESCE(s.location, ''), COALESCE(s.size, 0), COALESCE(s.checksum, ''), s.attempts, COALESCE(s.last_error, ''), s.updated_at
+FROM sda.sync_files AS s
+INNER JOIN sda.files AS f ON f.id = s.file_id
+WHERE f.stable_id = $1 AND s.destination = $2;
+`
+}
+
+func (db *pgDb) getFileSyncState(ctx context.Context, tx *sql.Tx, accessionID, destination string) (*database.FileSyncState, error) {
+	stmt, err := db.getPreparedStmt(tx, getFileSyncStateQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	state := new(database.FileSyncState)
+	if err := stmt.QueryRowContext(ctx, accessionID, destination).Scan(
+		&state.AccessionID,
+		&state.Destination,
+		&state.Status,
+		&state.Location,
+		&state.Size,
+		&state.Checksum,
+		&state.Attempts,
+		&state.LastError,
+		&state.UpdatedAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return state, nil
+}
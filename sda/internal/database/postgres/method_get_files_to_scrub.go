@@ -0,0 +1,64 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+)
+
+const getFilesToScrubQuery = "getFilesToScrub"
+
+func init() {
+	queries[getFilesToScrubQuery] = `
+SELECT f.id, COALESCE(f.archive_location, ''), f.archive_file_path, f.archive_file_size,
+COALESCE(f.backup_location, ''), COALESCE(f.backup_path, ''), c.checksum
+FROM sda.files AS f
+INNER JOIN sda.checksums AS c ON c.file_id = f.id AND c.source = 'ARCHIVED' AND c.type = 'SHA256'
+LEFT JOIN sda.file_scrubs AS s ON s.file_id = f.id
+WHERE f.last_event IN ('verified', 'backed up', 'ready', 'repaired')
+AND f.archive_file_path != ''
+AND (s.scrubbed_at IS NULL OR s.scrubbed_at < $1)
+ORDER BY s.scrubbed_at ASC NULLS FIRST, f.id
+LIMIT $2;
+`
+}
+
+func (db *pgDb) getFilesToScrub(ctx context.Context, tx *sql.Tx, scrubbedBefore time.Time, limit int) ([]*database.ScrubFile, error) {
+	stmt, err := db.getPreparedStmt(tx, getFilesToScrubQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.QueryContext(ctx, scrubbedBefore, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var files []*database.ScrubFile
+	for rows.Next() {
+		file := new(database.ScrubFile)
+		if err := rows.Scan(
+			&file.FileID,
+			&file.ArchiveLocation,
+			&file.ArchiveFilePath,
+			&file.ArchiveFileSize,
+			&file.BackupLocation,
+			&file.BackupFilePath,
+			&file.ArchivedChecksum,
+		); err != nil {
+			return nil, err
+		}
+
+		files = append(files, file)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
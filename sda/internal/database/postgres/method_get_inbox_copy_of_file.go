@@ -0,0 +1,42 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+const getInboxCopyOfFileQuery = "getInboxCopyOfFile"
+
+func init() {
+	queries[getInboxCopyOfFileQuery] = `
+SELECT f.submission_file_path
+FROM sda.files AS f
+JOIN sda.file_event_log AS fel ON fel.file_id = f.id
+WHERE f.submission_user = $1
+  AND f.archive_file_path = ''
+  AND f.last_event = 'uploaded'
+  AND fel.event = 'uploaded'
+  AND fel.details->>'copied_from' = $2
+ORDER BY fel.started_at DESC
+LIMIT 1;
+`
+}
+
+func (db *pgDb) getInboxCopyOfFile(ctx context.Context, tx *sql.Tx, submissionUser, filePath string) (string, error) {
+	stmt, err := db.getPreparedStmt(tx, getInboxCopyOfFileQuery)
+	if err != nil {
+		return "", err
+	}
+
+	var copyPath string
+	if err := stmt.QueryRowContext(ctx, submissionUser, filePath).Scan(&copyPath); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return copyPath, nil
+}
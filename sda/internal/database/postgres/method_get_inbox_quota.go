@@ -0,0 +1,37 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+)
+
+const getInboxQuotaQuery = "getInboxQuota"
+
+func init() {
+	queries[getInboxQuotaQuery] = `
+SELECT submission_user, max_bytes, max_files
+FROM sda.inbox_quotas
+WHERE submission_user = $1;
+`
+}
+
+func (db *pgDb) getInboxQuota(ctx context.Context, tx *sql.Tx, submissionUser string) (*database.InboxQuota, error) {
+	stmt, err := db.getPreparedStmt(tx, getInboxQuotaQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	quota := new(database.InboxQuota)
+	if err := stmt.QueryRowContext(ctx, submissionUser).Scan(&quota.User, &quota.MaxBytes, &quota.MaxFiles); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return quota, nil
+}
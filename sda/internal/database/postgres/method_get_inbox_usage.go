@@ -0,0 +1,41 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+)
+
+const getInboxUsageQuery = "getInboxUsage"
+
+func init() {
+	// Files stay in the inbox until they are mapped to a dataset, unless they are removed. Files of which the upload has
+	// not completed have no size yet, but are counted
+	queries[getInboxUsageQuery] = `
+SELECT COALESCE(SUM(f.submission_file_size), 0), COUNT(*)
+FROM sda.files f
+WHERE f.submission_user = $1
+AND f.id::text <> $2
+AND f.last_event IS DISTINCT FROM 'disabled'
+AND NOT EXISTS (
+	SELECT 1
+	FROM sda.file_dataset d
+	WHERE f.id = d.file_id
+);
+`
+}
+
+func (db *pgDb) getInboxUsage(ctx context.Context, tx *sql.Tx, submissionUser, excludedFileID string) (*database.InboxUsage, error) {
+	stmt, err := db.getPreparedStmt(tx, getInboxUsageQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := new(database.InboxUsage)
+	if err := stmt.QueryRowContext(ctx, submissionUser, excludedFileID).Scan(&usage.Bytes, &usage.Files); err != nil {
+		return nil, err
+	}
+
+	return usage, nil
+}
@@ -0,0 +1,45 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+)
+
+const getIngestCheckpointQuery = "getIngestCheckpoint"
+
+func init() {
+	queries[getIngestCheckpointQuery] = `
+SELECT archive_location, archive_file_path, upload_id, parts_uploaded, bytes_archived, hash_state, part_size
+FROM sda.ingest_checkpoints
+WHERE file_id = $1;
+`
+}
+
+func (db *pgDb) getIngestCheckpoint(ctx context.Context, tx *sql.Tx, fileID string) (*database.IngestCheckpoint, error) {
+	stmt, err := db.getPreparedStmt(tx, getIngestCheckpointQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoint := new(database.IngestCheckpoint)
+	if err := stmt.QueryRowContext(ctx, fileID).Scan(
+		&checkpoint.Location,
+		&checkpoint.FilePath,
+		&checkpoint.UploadID,
+		&checkpoint.PartsUploaded,
+		&checkpoint.BytesArchived,
+		&checkpoint.HashState,
+		&checkpoint.PartSize,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return checkpoint, nil
+}
@@ -0,0 +1,47 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+)
+
+const getIngestionStatusQuery = "getIngestionStatus"
+
+func init() {
+	queries[getIngestionStatusQuery] = `
+SELECT COALESCE(f.stable_id, ''), COALESCE(f.last_event, ''), COALESCE(cs.checksum, ''), COALESCE((
+    SELECT d.stable_id
+    FROM sda.file_dataset AS fd
+    INNER JOIN sda.datasets AS d ON d.id = fd.dataset_id
+    WHERE fd.file_id = f.id
+    ORDER BY fd.id DESC
+    LIMIT 1
+), '')
+FROM sda.files AS f
+LEFT JOIN sda.checksums AS cs ON cs.file_id = f.id AND cs.source = 'UNENCRYPTED' AND cs.type = 'SHA256'
+WHERE f.stable_id = $1 OR (f.submission_user = $2 AND f.submission_file_path = $3)
+ORDER BY f.stable_id = $1 DESC NULLS LAST, f.created_at DESC
+LIMIT 1;
+`
+}
+
+func (db *pgDb) getIngestionStatus(ctx context.Context, tx *sql.Tx, accessionID, user, filePath string) (*database.IngestionStatus, error) {
+	stmt, err := db.getPreparedStmt(tx, getIngestionStatusQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	status := new(database.IngestionStatus)
+	if err := stmt.QueryRowContext(ctx, accessionID, user, filePath).Scan(&status.AccessionID, &status.Status, &status.DecryptedChecksum, &status.DatasetID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return status, nil
+}
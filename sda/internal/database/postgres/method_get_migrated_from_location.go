@@ -0,0 +1,35 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+const getMigratedFromLocationQuery = "getMigratedFromLocation"
+
+func init() {
+	queries[getMigratedFromLocationQuery] = `
+SELECT migrated_from_location
+FROM sda.files
+WHERE id = $1;
+`
+}
+
+func (db *pgDb) getMigratedFromLocation(ctx context.Context, tx *sql.Tx, fileID string) (string, error) {
+	stmt, err := db.getPreparedStmt(tx, getMigratedFromLocationQuery)
+	if err != nil {
+		return "", err
+	}
+
+	var migratedFromLocation sql.NullString
+	if err := stmt.QueryRowContext(ctx, fileID).Scan(&migratedFromLocation); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return migratedFromLocation.String, nil
+}
@@ -9,17 +9,24 @@ import (
 const getSizeAndObjectCountOfLocationQuery = "getSizeAndObjectCountOfLocation"
 
 func init() {
+	// Archived objects shared by multiple files are only counted once, as files in the inbox are not shared they are
+	// identified by their id
 	queries[getSizeAndObjectCountOfLocationQuery] = `
-SELECT SUM(CASE WHEN f.submission_location = $1 AND f.file_in_dataset IS NOT TRUE THEN f.submission_file_size ELSE f.archive_file_size END ) AS size, COUNT(*)
+SELECT SUM(o.size) AS size, COUNT(*)
 FROM (
-SELECT f.submission_file_size, f.archive_file_size, f.submission_location, f.archive_location, f.backup_location,
+SELECT DISTINCT
+      CASE WHEN f.submission_location = $1 AND f.file_in_dataset IS NOT TRUE THEN f.id::TEXT ELSE f.archive_file_path END AS object_key,
+      CASE WHEN f.submission_location = $1 AND f.file_in_dataset IS NOT TRUE THEN f.submission_file_size ELSE f.archive_file_size END AS size
+FROM (
+SELECT f.id, f.submission_file_size, f.archive_file_path, f.archive_file_size, f.submission_location, f.archive_location, f.backup_location,
       (EXISTS (SELECT 1
          FROM sda.file_dataset fd
          WHERE fd.file_id = f.id)
       ) AS file_in_dataset
   FROM sda.files AS f
 ) as f
-WHERE (f.submission_location = $1 AND f.file_in_dataset IS NOT TRUE) OR f.archive_location = $1 OR f.backup_location = $1;
+WHERE (f.submission_location = $1 AND f.file_in_dataset IS NOT TRUE) OR f.archive_location = $1 OR f.backup_location = $1
+) as o;
 `
 }
 
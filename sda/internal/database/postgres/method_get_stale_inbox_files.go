@@ -0,0 +1,70 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+)
+
+const getStaleInboxFilesQuery = "getStaleInboxFiles"
+
+func init() {
+	queries[getStaleInboxFilesQuery] = `
+SELECT f.id, f.submission_user, f.submission_file_path, COALESCE(f.submission_location, ''), e.started_at, w.warned_at
+FROM sda.files AS f
+CROSS JOIN LATERAL (
+    SELECT started_at FROM sda.file_event_log
+    WHERE file_id = f.id
+    ORDER BY started_at DESC LIMIT 1
+) AS e
+LEFT JOIN sda.inbox_expiry_warnings AS w ON w.file_id = f.id
+WHERE f.last_event = $1
+AND f.archive_file_path = ''
+AND e.started_at < $2
+AND f.id > CAST(COALESCE(NULLIF($3, ''), '00000000-0000-0000-0000-000000000000') AS UUID)
+AND NOT EXISTS (SELECT 1 FROM sda.file_dataset AS fd WHERE fd.file_id = f.id)
+ORDER BY f.id
+LIMIT $4;
+`
+}
+
+func (db *pgDb) getStaleInboxFiles(ctx context.Context, tx *sql.Tx, lastEvent string, before time.Time, afterFileID string, limit int) ([]*database.InboxFile, error) {
+	stmt, err := db.getPreparedStmt(tx, getStaleInboxFilesQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.QueryContext(ctx, lastEvent, before, afterFileID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var files []*database.InboxFile
+	for rows.Next() {
+		file := new(database.InboxFile)
+		var warnedAt sql.NullTime
+		if err := rows.Scan(
+			&file.FileID,
+			&file.User,
+			&file.FilePath,
+			&file.Location,
+			&file.LastEventAt,
+			&warnedAt,
+		); err != nil {
+			return nil, err
+		}
+		file.WarnedAt = warnedAt.Time
+
+		files = append(files, file)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
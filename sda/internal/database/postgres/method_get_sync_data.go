@@ -11,7 +11,14 @@ const getSyncDataQuery = "getSyncData"
 
 func init() {
 	queries[getSyncDataQuery] = `
-SELECT f.submission_user, f.submission_file_path, cs.checksum
+SELECT f.submission_user, f.submission_file_path, cs.checksum, COALESCE((
+    SELECT d.stable_id
+    FROM sda.file_dataset AS fd
+    INNER JOIN sda.datasets AS d ON d.id = fd.dataset_id
+    WHERE fd.file_id = f.id
+    ORDER BY fd.id DESC
+    LIMIT 1
+), '')
 FROM sda.files AS f
 INNER JOIN sda.checksums AS cs ON f.id = cs.file_id
 WHERE f.stable_id = $1 AND cs.source = 'UNENCRYPTED';
@@ -25,7 +32,7 @@ func (db *pgDb) getSyncData(ctx context.Context, tx *sql.Tx, accessionID string)
 	}
 
 	data := new(database.SyncData)
-	if err := stmt.QueryRowContext(ctx, accessionID).Scan(&data.User, &data.FilePath, &data.Checksum); err != nil {
+	if err := stmt.QueryRowContext(ctx, accessionID).Scan(&data.User, &data.FilePath, &data.Checksum, &data.DatasetID); err != nil {
 		return nil, err
 	}
 
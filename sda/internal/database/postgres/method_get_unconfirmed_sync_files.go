@@ -0,0 +1,42 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+)
+
+const getUnconfirmedSyncFilesQuery = "getUnconfirmedSyncFiles"
+
+func init() {
+	queries[getUnconfirmedSyncFilesQuery] = `
+SELECT f.stable_id
+FROM sda.sync_files AS s
+INNER JOIN sda.files AS f ON f.id = s.file_id
+WHERE s.destination = $1 AND s.status = 'verified'
+ORDER BY s.updated_at;
+`
+}
+
+func (db *pgDb) getUnconfirmedSyncFiles(ctx context.Context, tx *sql.Tx, destination string) ([]string, error) {
+	stmt, err := db.getPreparedStmt(tx, getUnconfirmedSyncFilesQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.QueryContext(ctx, destination)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accessionIDs []string
+	for rows.Next() {
+		var accessionID string
+		if err := rows.Scan(&accessionID); err != nil {
+			return nil, err
+		}
+		accessionIDs = append(accessionIDs, accessionID)
+	}
+
+	return accessionIDs, rows.Err()
+}
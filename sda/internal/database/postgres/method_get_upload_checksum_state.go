@@ -0,0 +1,41 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+)
+
+const getUploadChecksumStateQuery = "getUploadChecksumState"
+
+func init() {
+	queries[getUploadChecksumStateQuery] = `
+SELECT bytes_uploaded, sha256_state, md5_state
+FROM sda.upload_checksum_states
+WHERE upload_id = $1 AND part_number = $2;
+`
+}
+
+func (db *pgDb) getUploadChecksumState(ctx context.Context, tx *sql.Tx, uploadID string, partNumber int32) (*database.UploadChecksumState, error) {
+	stmt, err := db.getPreparedStmt(tx, getUploadChecksumStateQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &database.UploadChecksumState{UploadID: uploadID, PartNumber: partNumber}
+	if err := stmt.QueryRowContext(ctx, uploadID, partNumber).Scan(
+		&state.BytesUploaded,
+		&state.SHA256State,
+		&state.MD5State,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return state, nil
+}
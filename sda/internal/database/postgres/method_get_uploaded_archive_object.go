@@ -0,0 +1,49 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+)
+
+const getUploadedArchiveObjectQuery = "getUploadedArchiveObject"
+
+func init() {
+	queries[getUploadedArchiveObjectQuery] = `
+SELECT ao.archive_location, ao.archive_file_path, ao.archive_file_size, ao.checksum, ao.reference_count
+FROM sda.checksums AS uploaded
+JOIN sda.checksums AS other
+	ON other.checksum = uploaded.checksum AND other.type = uploaded.type AND other.source = uploaded.source AND other.file_id != uploaded.file_id
+JOIN sda.files AS f ON f.id = other.file_id
+JOIN sda.archive_objects AS ao ON ao.archive_location = f.archive_location AND ao.archive_file_path = f.archive_file_path
+WHERE uploaded.file_id = $1 AND uploaded.type = 'SHA256' AND uploaded.source = 'UPLOADED' AND ao.reference_count > 0
+ORDER BY ao.created_at
+LIMIT 1;
+`
+}
+
+func (db *pgDb) getUploadedArchiveObject(ctx context.Context, tx *sql.Tx, fileID string) (*database.ArchiveObject, error) {
+	stmt, err := db.getPreparedStmt(tx, getUploadedArchiveObjectQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	archiveObject := new(database.ArchiveObject)
+	if err := stmt.QueryRowContext(ctx, fileID).Scan(
+		&archiveObject.Location,
+		&archiveObject.FilePath,
+		&archiveObject.FileSize,
+		&archiveObject.Checksum,
+		&archiveObject.ReferenceCount,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return archiveObject, nil
+}
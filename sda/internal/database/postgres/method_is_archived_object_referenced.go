@@ -0,0 +1,29 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+)
+
+const isArchivedObjectReferencedQuery = "isArchivedObjectReferenced"
+
+func init() {
+	queries[isArchivedObjectReferencedQuery] = `
+SELECT EXISTS(SELECT 1 FROM sda.files WHERE archive_location = $1 AND archive_file_path = $2)
+OR EXISTS(SELECT 1 FROM sda.archive_objects WHERE archive_location = $1 AND archive_file_path = $2);
+`
+}
+
+func (db *pgDb) isArchivedObjectReferenced(ctx context.Context, tx *sql.Tx, location, filePath string) (bool, error) {
+	stmt, err := db.getPreparedStmt(tx, isArchivedObjectReferencedQuery)
+	if err != nil {
+		return false, err
+	}
+
+	var referenced bool
+	if err := stmt.QueryRowContext(ctx, location, filePath).Scan(&referenced); err != nil {
+		return false, err
+	}
+
+	return referenced, nil
+}
@@ -0,0 +1,53 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+const migrateArchiveLocationQuery = "migrateArchiveLocation"
+
+func init() {
+	// Like setArchiveLocation, but only moves files which are archived at the source location, and records the source
+	// location so that the source copy can be removed once the migration is done
+	queries[migrateArchiveLocationQuery] = `
+WITH previous AS (
+	SELECT archive_location, archive_file_path
+	FROM sda.files
+	WHERE id = $1 AND archive_location = $2
+), objects AS (
+	UPDATE sda.archive_objects AS o
+	SET archive_location = $3, archive_file_path = $4
+	FROM previous AS p
+	WHERE o.archive_location = p.archive_location AND o.archive_file_path = p.archive_file_path
+)
+UPDATE sda.files AS f
+SET archive_location = $3, archive_file_path = $4, migrated_from_location = p.archive_location
+FROM previous AS p
+WHERE f.id = $1 OR (f.archive_location = p.archive_location AND f.archive_file_path = p.archive_file_path);
+`
+}
+
+func (db *pgDb) migrateArchiveLocation(ctx context.Context, tx *sql.Tx, fileID, sourceLocation, location, filePath string) error {
+	stmt, err := db.getPreparedStmt(tx, migrateArchiveLocationQuery)
+	if err != nil {
+		return err
+	}
+
+	r, err := stmt.ExecContext(ctx, fileID, sourceLocation, location, filePath)
+	if err != nil {
+		return fmt.Errorf("migrateArchiveLocation error: %w", err)
+	}
+
+	rowsAffected, err := r.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("migrateArchiveLocation error: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -0,0 +1,30 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+const referenceArchiveObjectQuery = "referenceArchiveObject"
+
+func init() {
+	queries[referenceArchiveObjectQuery] = `
+INSERT INTO sda.archive_objects(archive_location, archive_file_path, archive_file_size, checksum, reference_count)
+VALUES($1, $2, $3, $4, 1)
+ON CONFLICT ON CONSTRAINT unique_archive_object DO UPDATE SET reference_count = sda.archive_objects.reference_count + 1;
+`
+}
+
+func (db *pgDb) referenceArchiveObject(ctx context.Context, tx *sql.Tx, location, filePath string, size int64, checksum string) error {
+	stmt, err := db.getPreparedStmt(tx, referenceArchiveObjectQuery)
+	if err != nil {
+		return err
+	}
+
+	if _, err := stmt.ExecContext(ctx, location, filePath, size, checksum); err != nil {
+		return fmt.Errorf("referenceArchiveObject error: %w", err)
+	}
+
+	return nil
+}
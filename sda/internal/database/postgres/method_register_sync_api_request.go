@@ -0,0 +1,41 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const registerSyncAPIRequestQuery = "registerSyncAPIRequest"
+
+func init() {
+	// A request ID which has expired is registered again, as it may not have been deleted yet
+	queries[registerSyncAPIRequestQuery] = `
+INSERT INTO sda.sync_api_requests(partner, request_id, expires_at)
+VALUES($1, $2, $3)
+ON CONFLICT (partner, request_id) DO UPDATE SET
+expires_at = EXCLUDED.expires_at
+WHERE sda.sync_api_requests.expires_at < $4
+RETURNING true;
+`
+}
+
+func (db *pgDb) registerSyncAPIRequest(ctx context.Context, tx *sql.Tx, partner, requestID string, expiresAt, now time.Time) (bool, error) {
+	stmt, err := db.getPreparedStmt(tx, registerSyncAPIRequestQuery)
+	if err != nil {
+		return false, err
+	}
+
+	var registered bool
+	if err := stmt.QueryRowContext(ctx, partner, requestID, expiresAt, now).Scan(&registered); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("registerSyncAPIRequest error: %w", err)
+	}
+
+	return registered, nil
+}
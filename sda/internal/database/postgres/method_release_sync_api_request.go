@@ -0,0 +1,29 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+const releaseSyncAPIRequestQuery = "releaseSyncAPIRequest"
+
+func init() {
+	queries[releaseSyncAPIRequestQuery] = `
+DELETE FROM sda.sync_api_requests
+WHERE partner = $1 AND request_id = $2;
+`
+}
+
+func (db *pgDb) releaseSyncAPIRequest(ctx context.Context, tx *sql.Tx, partner, requestID string) error {
+	stmt, err := db.getPreparedStmt(tx, releaseSyncAPIRequestQuery)
+	if err != nil {
+		return err
+	}
+
+	if _, err := stmt.ExecContext(ctx, partner, requestID); err != nil {
+		return fmt.Errorf("releaseSyncAPIRequest error: %w", err)
+	}
+
+	return nil
+}
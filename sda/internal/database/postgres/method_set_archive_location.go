@@ -0,0 +1,52 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+const setArchiveLocationQuery = "setArchiveLocation"
+
+func init() {
+	// Files sharing a deduplicated archive object, and the archive object itself, are moved along with the file
+	queries[setArchiveLocationQuery] = `
+WITH previous AS (
+	SELECT archive_location, archive_file_path
+	FROM sda.files
+	WHERE id = $1
+), objects AS (
+	UPDATE sda.archive_objects AS o
+	SET archive_location = $2, archive_file_path = $3
+	FROM previous AS p
+	WHERE o.archive_location = p.archive_location AND o.archive_file_path = p.archive_file_path
+)
+UPDATE sda.files AS f
+SET archive_location = $2, archive_file_path = $3
+FROM previous AS p
+WHERE f.id = $1 OR (f.archive_location = p.archive_location AND f.archive_file_path = p.archive_file_path);
+`
+}
+
+func (db *pgDb) setArchiveLocation(ctx context.Context, tx *sql.Tx, fileID, location, filePath string) error {
+	stmt, err := db.getPreparedStmt(tx, setArchiveLocationQuery)
+	if err != nil {
+		return err
+	}
+
+	r, err := stmt.ExecContext(ctx, fileID, location, filePath)
+	if err != nil {
+		return fmt.Errorf("setArchiveLocation error: %w", err)
+	}
+
+	rowsAffected, err := r.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("setArchiveLocation error: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -0,0 +1,33 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+const setFileScrubbedQuery = "setFileScrubbed"
+
+func init() {
+	queries[setFileScrubbedQuery] = `
+INSERT INTO sda.file_scrubs(file_id, success, error)
+VALUES($1, $2, NULLIF($3, ''))
+ON CONFLICT (file_id) DO UPDATE SET
+scrubbed_at = clock_timestamp(),
+success = EXCLUDED.success,
+error = EXCLUDED.error;
+`
+}
+
+func (db *pgDb) setFileScrubbed(ctx context.Context, tx *sql.Tx, fileID string, success bool, scrubError string) error {
+	stmt, err := db.getPreparedStmt(tx, setFileScrubbedQuery)
+	if err != nil {
+		return err
+	}
+
+	if _, err := stmt.ExecContext(ctx, fileID, success, scrubError); err != nil {
+		return fmt.Errorf("setFileScrubbed error: %w", err)
+	}
+
+	return nil
+}
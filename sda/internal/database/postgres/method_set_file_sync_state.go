@@ -0,0 +1,45 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+)
+
+const setFileSyncStateQuery = "setFileSyncState"
+
+func init() {
+	queries[setFileSyncStateQuery] = `
+INSERT INTO sda.sync_files(file_id, destination, status, location, size, checksum, attempts, last_error)
+SELECT id, $2, $3, NULLIF($4, ''), $5, NULLIF($6, ''), $7, NULLIF($8, '')
+FROM sda.files
+WHERE stable_id = $1
+ON CONFLICT (file_id, destination) DO UPDATE SET
+status = EXCLUDED.status,
+location = EXCLUDED.location,
+size = EXCLUDED.size,
+checksum = EXCLUDED.checksum,
+attempts = EXCLUDED.attempts,
+last_error = EXCLUDED.last_error,
+updated_at = clock_timestamp();
+`
+}
+
+func (db *pgDb) setFileSyncState(ctx context.Context, tx *sql.Tx, state *database.FileSyncState) error {
+	stmt, err := db.getPreparedStmt(tx, setFileSyncStateQuery)
+	if err != nil {
+		return err
+	}
+
+	result, err := stmt.ExecContext(ctx, state.AccessionID, state.Destination, state.Status, state.Location, state.Size, state.Checksum, state.Attempts, state.LastError)
+	if err != nil {
+		return fmt.Errorf("setFileSyncState error: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return fmt.Errorf("setFileSyncState error: no file with accession id: %s", state.AccessionID)
+	}
+
+	return nil
+}
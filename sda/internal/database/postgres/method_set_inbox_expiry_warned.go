@@ -0,0 +1,31 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+const setInboxExpiryWarnedQuery = "setInboxExpiryWarned"
+
+func init() {
+	queries[setInboxExpiryWarnedQuery] = `
+INSERT INTO sda.inbox_expiry_warnings(file_id)
+VALUES($1)
+ON CONFLICT (file_id) DO UPDATE SET
+warned_at = clock_timestamp();
+`
+}
+
+func (db *pgDb) setInboxExpiryWarned(ctx context.Context, tx *sql.Tx, fileID string) error {
+	stmt, err := db.getPreparedStmt(tx, setInboxExpiryWarnedQuery)
+	if err != nil {
+		return err
+	}
+
+	if _, err := stmt.ExecContext(ctx, fileID); err != nil {
+		return fmt.Errorf("setInboxExpiryWarned error: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+)
+
+const setInboxQuotaQuery = "setInboxQuota"
+
+func init() {
+	queries[setInboxQuotaQuery] = `
+INSERT INTO sda.inbox_quotas(submission_user, max_bytes, max_files)
+VALUES($1, $2, $3)
+ON CONFLICT (submission_user) DO UPDATE SET
+max_bytes = EXCLUDED.max_bytes,
+max_files = EXCLUDED.max_files,
+updated_at = clock_timestamp();
+`
+}
+
+func (db *pgDb) setInboxQuota(ctx context.Context, tx *sql.Tx, quota *database.InboxQuota) error {
+	stmt, err := db.getPreparedStmt(tx, setInboxQuotaQuery)
+	if err != nil {
+		return err
+	}
+
+	if _, err := stmt.ExecContext(ctx, quota.User, quota.MaxBytes, quota.MaxFiles); err != nil {
+		return fmt.Errorf("setInboxQuota error: %w", err)
+	}
+
+	return nil
+}
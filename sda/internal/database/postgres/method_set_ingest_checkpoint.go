@@ -0,0 +1,40 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+)
+
+const setIngestCheckpointQuery = "setIngestCheckpoint"
+
+func init() {
+	queries[setIngestCheckpointQuery] = `
+INSERT INTO sda.ingest_checkpoints(file_id, archive_location, archive_file_path, upload_id, parts_uploaded, bytes_archived, hash_state, part_size)
+VALUES($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (file_id) DO UPDATE SET
+archive_location = EXCLUDED.archive_location,
+archive_file_path = EXCLUDED.archive_file_path,
+upload_id = EXCLUDED.upload_id,
+parts_uploaded = EXCLUDED.parts_uploaded,
+bytes_archived = EXCLUDED.bytes_archived,
+hash_state = EXCLUDED.hash_state,
+part_size = EXCLUDED.part_size,
+updated_at = clock_timestamp();
+`
+}
+
+func (db *pgDb) setIngestCheckpoint(ctx context.Context, tx *sql.Tx, fileID string, checkpoint *database.IngestCheckpoint) error {
+	stmt, err := db.getPreparedStmt(tx, setIngestCheckpointQuery)
+	if err != nil {
+		return err
+	}
+
+	if _, err := stmt.ExecContext(ctx, fileID, checkpoint.Location, checkpoint.FilePath, checkpoint.UploadID, checkpoint.PartsUploaded, checkpoint.BytesArchived, checkpoint.HashState, checkpoint.PartSize); err != nil {
+		return fmt.Errorf("setIngestCheckpoint error: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+const setRepairedQuery = "setRepaired"
+
+func init() {
+	// The repaired event is followed by the event the file had before the repair, so that the status of the file,
+	// which is its last event, is kept for the services working on it
+	queries[setRepairedQuery] = `
+WITH previous AS (
+	SELECT last_event FROM sda.files WHERE id = $1
+)
+INSERT INTO sda.file_event_log(file_id, event, user_id, details, message)
+SELECT $1, e.event, 'repair', '{}', $2
+FROM previous, LATERAL (VALUES (1, 'repaired'), (2, previous.last_event)) AS e(n, event)
+WHERE e.event IS NOT NULL
+ORDER BY e.n;
+`
+}
+
+func (db *pgDb) setRepaired(ctx context.Context, tx *sql.Tx, fileID, message string) error {
+	stmt, err := db.getPreparedStmt(tx, setRepairedQuery)
+	if err != nil {
+		return err
+	}
+
+	r, err := stmt.ExecContext(ctx, fileID, message)
+	if err != nil {
+		return fmt.Errorf("setRepaired error: %w", err)
+	}
+
+	rowsAffected, err := r.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("setRepaired error: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
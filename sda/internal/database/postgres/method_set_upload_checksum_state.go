@@ -0,0 +1,52 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+)
+
+const (
+	setUploadChecksumStateQuery          = "setUploadChecksumState"
+	deleteLaterUploadChecksumStatesQuery = "deleteLaterUploadChecksumStates"
+)
+
+func init() {
+	queries[setUploadChecksumStateQuery] = `
+INSERT INTO sda.upload_checksum_states(upload_id, part_number, bytes_uploaded, sha256_state, md5_state)
+VALUES($1, $2, $3, $4, $5)
+ON CONFLICT (upload_id, part_number) DO UPDATE SET
+bytes_uploaded = EXCLUDED.bytes_uploaded,
+sha256_state = EXCLUDED.sha256_state,
+md5_state = EXCLUDED.md5_state,
+updated_at = clock_timestamp();
+`
+	queries[deleteLaterUploadChecksumStatesQuery] = `
+DELETE FROM sda.upload_checksum_states
+WHERE upload_id = $1 AND part_number > $2;
+`
+}
+
+func (db *pgDb) setUploadChecksumState(ctx context.Context, tx *sql.Tx, state *database.UploadChecksumState) error {
+	stmt, err := db.getPreparedStmt(tx, setUploadChecksumStateQuery)
+	if err != nil {
+		return err
+	}
+	deleteStmt, err := db.getPreparedStmt(tx, deleteLaterUploadChecksumStatesQuery)
+	if err != nil {
+		return err
+	}
+
+	if _, err := stmt.ExecContext(ctx, state.UploadID, state.PartNumber, state.BytesUploaded, state.SHA256State, state.MD5State); err != nil {
+		return fmt.Errorf("setUploadChecksumState error: %w", err)
+	}
+
+	// The states of later parts continued from the previous state of this part
+	if _, err := deleteStmt.ExecContext(ctx, state.UploadID, state.PartNumber); err != nil {
+		return fmt.Errorf("deleteLaterUploadChecksumStates error: %w", err)
+	}
+
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/lib/pq"
 	"github.com/neicnordic/sensitive-data-archive/internal/database"
@@ -339,6 +340,146 @@ func (db *pgDb) SetBackedUp(ctx context.Context, location, path, fileID string)
 	return db.setBackedUp(ctx, nil, location, path, fileID)
 }
 
+func (db *pgDb) SetRepaired(ctx context.Context, fileID, message string) error {
+	return db.setRepaired(ctx, nil, fileID, message)
+}
+
 func (db *pgDb) GetFileIDInInbox(ctx context.Context, submissionUser, filePath string) (string, error) {
 	return db.getFileIDInInbox(ctx, nil, submissionUser, filePath)
 }
+
+func (db *pgDb) GetArchiveObject(ctx context.Context, checksum string, size int64) (*database.ArchiveObject, error) {
+	return db.getArchiveObject(ctx, nil, checksum, size)
+}
+
+func (db *pgDb) GetUploadedArchiveObject(ctx context.Context, fileID string) (*database.ArchiveObject, error) {
+	return db.getUploadedArchiveObject(ctx, nil, fileID)
+}
+
+func (db *pgDb) ReferenceArchiveObject(ctx context.Context, location, filePath string, size int64, checksum string) error {
+	return db.referenceArchiveObject(ctx, nil, location, filePath, size, checksum)
+}
+
+func (db *pgDb) DereferenceArchiveObject(ctx context.Context, location, filePath string) (bool, error) {
+	return db.dereferenceArchiveObject(ctx, nil, location, filePath)
+}
+
+func (db *pgDb) GetIngestCheckpoint(ctx context.Context, fileID string) (*database.IngestCheckpoint, error) {
+	return db.getIngestCheckpoint(ctx, nil, fileID)
+}
+
+func (db *pgDb) SetIngestCheckpoint(ctx context.Context, fileID string, checkpoint *database.IngestCheckpoint) error {
+	return db.setIngestCheckpoint(ctx, nil, fileID, checkpoint)
+}
+
+func (db *pgDb) DeleteIngestCheckpoint(ctx context.Context, fileID string) error {
+	return db.deleteIngestCheckpoint(ctx, nil, fileID)
+}
+
+func (db *pgDb) GetFilesToScrub(ctx context.Context, scrubbedBefore time.Time, limit int) ([]*database.ScrubFile, error) {
+	return db.getFilesToScrub(ctx, nil, scrubbedBefore, limit)
+}
+
+func (db *pgDb) SetFileScrubbed(ctx context.Context, fileID string, success bool, scrubError string) error {
+	return db.setFileScrubbed(ctx, nil, fileID, success, scrubError)
+}
+
+func (db *pgDb) SetArchiveLocation(ctx context.Context, fileID, location, filePath string) error {
+	return db.setArchiveLocation(ctx, nil, fileID, location, filePath)
+}
+
+func (db *pgDb) MigrateArchiveLocation(ctx context.Context, fileID, sourceLocation, location, filePath string) error {
+	return db.migrateArchiveLocation(ctx, nil, fileID, sourceLocation, location, filePath)
+}
+
+func (db *pgDb) GetMigratedFromLocation(ctx context.Context, fileID string) (string, error) {
+	return db.getMigratedFromLocation(ctx, nil, fileID)
+}
+
+func (db *pgDb) GetFileIDsInArchiveLocation(ctx context.Context, location, datasetID, user string) ([]string, error) {
+	return db.getFileIDsInArchiveLocation(ctx, nil, location, datasetID, user)
+}
+
+func (db *pgDb) IsArchivedObjectReferenced(ctx context.Context, location, filePath string) (bool, error) {
+	return db.isArchivedObjectReferenced(ctx, nil, location, filePath)
+}
+
+func (db *pgDb) AddUploadedChecksum(ctx context.Context, fileID, checksum, algorithm string) error {
+	return db.addUploadedChecksum(ctx, nil, fileID, checksum, algorithm)
+}
+
+func (db *pgDb) GetUploadChecksumState(ctx context.Context, uploadID string, partNumber int32) (*database.UploadChecksumState, error) {
+	return db.getUploadChecksumState(ctx, nil, uploadID, partNumber)
+}
+
+func (db *pgDb) SetUploadChecksumState(ctx context.Context, state *database.UploadChecksumState) error {
+	return db.setUploadChecksumState(ctx, nil, state)
+}
+
+func (db *pgDb) DeleteUploadChecksumStates(ctx context.Context, uploadID string) error {
+	return db.deleteUploadChecksumStates(ctx, nil, uploadID)
+}
+
+func (db *pgDb) GetInboxCopyOfFile(ctx context.Context, submissionUser, filePath string) (string, error) {
+	return db.getInboxCopyOfFile(ctx, nil, submissionUser, filePath)
+}
+
+func (db *pgDb) GetInboxQuota(ctx context.Context, submissionUser string) (*database.InboxQuota, error) {
+	return db.getInboxQuota(ctx, nil, submissionUser)
+}
+
+func (db *pgDb) SetInboxQuota(ctx context.Context, quota *database.InboxQuota) error {
+	return db.setInboxQuota(ctx, nil, quota)
+}
+
+func (db *pgDb) DeleteInboxQuota(ctx context.Context, submissionUser string) error {
+	return db.deleteInboxQuota(ctx, nil, submissionUser)
+}
+
+func (db *pgDb) GetInboxUsage(ctx context.Context, submissionUser, excludedFileID string) (*database.InboxUsage, error) {
+	return db.getInboxUsage(ctx, nil, submissionUser, excludedFileID)
+}
+
+func (db *pgDb) GetStaleInboxFiles(ctx context.Context, lastEvent string, before time.Time, afterFileID string, limit int) ([]*database.InboxFile, error) {
+	return db.getStaleInboxFiles(ctx, nil, lastEvent, before, afterFileID, limit)
+}
+
+func (db *pgDb) SetInboxExpiryWarned(ctx context.Context, fileID string) error {
+	return db.setInboxExpiryWarned(ctx, nil, fileID)
+}
+
+func (db *pgDb) DisableInboxFile(ctx context.Context, fileID, lastEvent string, lastEventAt time.Time, details string) (bool, error) {
+	return db.disableInboxFile(ctx, nil, fileID, lastEvent, lastEventAt, details)
+}
+
+func (db *pgDb) GetFileSyncState(ctx context.Context, accessionID, destination string) (*database.FileSyncState, error) {
+	return db.getFileSyncState(ctx, nil, accessionID, destination)
+}
+
+func (db *pgDb) SetFileSyncState(ctx context.Context, state *database.FileSyncState) error {
+	return db.setFileSyncState(ctx, nil, state)
+}
+
+func (db *pgDb) GetDatasetSyncStates(ctx context.Context, datasetID string) ([]*database.FileSyncState, error) {
+	return db.getDatasetSyncStates(ctx, nil, datasetID)
+}
+
+func (db *pgDb) GetUnconfirmedSyncFiles(ctx context.Context, destination string) ([]string, error) {
+	return db.getUnconfirmedSyncFiles(ctx, nil, destination)
+}
+
+func (db *pgDb) GetIngestionStatus(ctx context.Context, accessionID, user, filePath string) (*database.IngestionStatus, error) {
+	return db.getIngestionStatus(ctx, nil, accessionID, user, filePath)
+}
+
+func (db *pgDb) RegisterSyncAPIRequest(ctx context.Context, partner, requestID string, expiresAt, now time.Time) (bool, error) {
+	return db.registerSyncAPIRequest(ctx, nil, partner, requestID, expiresAt, now)
+}
+
+func (db *pgDb) DeleteSyncAPIRequests(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	return db.deleteSyncAPIRequests(ctx, nil, expiredBefore)
+}
+
+func (db *pgDb) ReleaseSyncAPIRequest(ctx context.Context, partner, requestID string) error {
+	return db.releaseSyncAPIRequest(ctx, nil, partner, requestID)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"time"
 
 	"github.com/neicnordic/sensitive-data-archive/internal/database"
 )
@@ -222,6 +223,146 @@ func (tx *pgTx) SetBackedUp(ctx context.Context, location, path, fileID string)
 	return tx.setBackedUp(ctx, tx.tx, location, path, fileID)
 }
 
+func (tx *pgTx) SetRepaired(ctx context.Context, fileID, message string) error {
+	return tx.setRepaired(ctx, tx.tx, fileID, message)
+}
+
 func (tx *pgTx) GetFileIDInInbox(ctx context.Context, submissionUser, filePath string) (string, error) {
 	return tx.getFileIDInInbox(ctx, tx.tx, submissionUser, filePath)
 }
+
+func (tx *pgTx) GetArchiveObject(ctx context.Context, checksum string, size int64) (*database.ArchiveObject, error) {
+	return tx.getArchiveObject(ctx, tx.tx, checksum, size)
+}
+
+func (tx *pgTx) GetUploadedArchiveObject(ctx context.Context, fileID string) (*database.ArchiveObject, error) {
+	return tx.getUploadedArchiveObject(ctx, tx.tx, fileID)
+}
+
+func (tx *pgTx) ReferenceArchiveObject(ctx context.Context, location, filePath string, size int64, checksum string) error {
+	return tx.referenceArchiveObject(ctx, tx.tx, location, filePath, size, checksum)
+}
+
+func (tx *pgTx) DereferenceArchiveObject(ctx context.Context, location, filePath string) (bool, error) {
+	return tx.dereferenceArchiveObject(ctx, tx.tx, location, filePath)
+}
+
+func (tx *pgTx) GetIngestCheckpoint(ctx context.Context, fileID string) (*database.IngestCheckpoint, error) {
+	return tx.getIngestCheckpoint(ctx, tx.tx, fileID)
+}
+
+func (tx *pgTx) SetIngestCheckpoint(ctx context.Context, fileID string, checkpoint *database.IngestCheckpoint) error {
+	return tx.setIngestCheckpoint(ctx, tx.tx, fileID, checkpoint)
+}
+
+func (tx *pgTx) DeleteIngestCheckpoint(ctx context.Context, fileID string) error {
+	return tx.deleteIngestCheckpoint(ctx, tx.tx, fileID)
+}
+
+func (tx *pgTx) GetFilesToScrub(ctx context.Context, scrubbedBefore time.Time, limit int) ([]*database.ScrubFile, error) {
+	return tx.getFilesToScrub(ctx, tx.tx, scrubbedBefore, limit)
+}
+
+func (tx *pgTx) SetFileScrubbed(ctx context.Context, fileID string, success bool, scrubError string) error {
+	return tx.setFileScrubbed(ctx, tx.tx, fileID, success, scrubError)
+}
+
+func (tx *pgTx) SetArchiveLocation(ctx context.Context, fileID, location, filePath string) error {
+	return tx.setArchiveLocation(ctx, tx.tx, fileID, location, filePath)
+}
+
+func (tx *pgTx) MigrateArchiveLocation(ctx context.Context, fileID, sourceLocation, location, filePath string) error {
+	return tx.migrateArchiveLocation(ctx, tx.tx, fileID, sourceLocation, location, filePath)
+}
+
+func (tx *pgTx) GetMigratedFromLocation(ctx context.Context, fileID string) (string, error) {
+	return tx.getMigratedFromLocation(ctx, tx.tx, fileID)
+}
+
+func (tx *pgTx) GetFileIDsInArchiveLocation(ctx context.Context, location, datasetID, user string) ([]string, error) {
+	return tx.getFileIDsInArchiveLocation(ctx, tx.tx, location, datasetID, user)
+}
+
+func (tx *pgTx) IsArchivedObjectReferenced(ctx context.Context, location, filePath string) (bool, error) {
+	return tx.isArchivedObjectReferenced(ctx, tx.tx, location, filePath)
+}
+
+func (tx *pgTx) AddUploadedChecksum(ctx context.Context, fileID, checksum, algorithm string) error {
+	return tx.addUploadedChecksum(ctx, tx.tx, fileID, checksum, algorithm)
+}
+
+func (tx *pgTx) GetUploadChecksumState(ctx context.Context, uploadID string, partNumber int32) (*database.UploadChecksumState, error) {
+	return tx.getUploadChecksumState(ctx, tx.tx, uploadID, partNumber)
+}
+
+func (tx *pgTx) SetUploadChecksumState(ctx context.Context, state *database.UploadChecksumState) error {
+	return tx.setUploadChecksumState(ctx, tx.tx, state)
+}
+
+func (tx *pgTx) DeleteUploadChecksumStates(ctx context.Context, uploadID string) error {
+	return tx.deleteUploadChecksumStates(ctx, tx.tx, uploadID)
+}
+
+func (tx *pgTx) GetInboxCopyOfFile(ctx context.Context, submissionUser, filePath string) (string, error) {
+	return tx.getInboxCopyOfFile(ctx, tx.tx, submissionUser, filePath)
+}
+
+func (tx *pgTx) GetInboxQuota(ctx context.Context, submissionUser string) (*database.InboxQuota, error) {
+	return tx.getInboxQuota(ctx, tx.tx, submissionUser)
+}
+
+func (tx *pgTx) SetInboxQuota(ctx context.Context, quota *database.InboxQuota) error {
+	return tx.setInboxQuota(ctx, tx.tx, quota)
+}
+
+func (tx *pgTx) DeleteInboxQuota(ctx context.Context, submissionUser string) error {
+	return tx.deleteInboxQuota(ctx, tx.tx, submissionUser)
+}
+
+func (tx *pgTx) GetInboxUsage(ctx context.Context, submissionUser, excludedFileID string) (*database.InboxUsage, error) {
+	return tx.getInboxUsage(ctx, tx.tx, submissionUser, excludedFileID)
+}
+
+func (tx *pgTx) GetStaleInboxFiles(ctx context.Context, lastEvent string, before time.Time, afterFileID string, limit int) ([]*database.InboxFile, error) {
+	return tx.getStaleInboxFiles(ctx, tx.tx, lastEvent, before, afterFileID, limit)
+}
+
+func (tx *pgTx) SetInboxExpiryWarned(ctx context.Context, fileID string) error {
+	return tx.setInboxExpiryWarned(ctx, tx.tx, fileID)
+}
+
+func (tx *pgTx) DisableInboxFile(ctx context.Context, fileID, lastEvent string, lastEventAt time.Time, details string) (bool, error) {
+	return tx.disableInboxFile(ctx, tx.tx, fileID, lastEvent, lastEventAt, details)
+}
+
+func (tx *pgTx) GetFileSyncState(ctx context.Context, accessionID, destination string) (*database.FileSyncState, error) {
+	return tx.getFileSyncState(ctx, tx.tx, accessionID, destination)
+}
+
+func (tx *pgTx) SetFileSyncState(ctx context.Context, state *database.FileSyncState) error {
+	return tx.setFileSyncState(ctx, tx.tx, state)
+}
+
+func (tx *pgTx) GetDatasetSyncStates(ctx context.Context, datasetID string) ([]*database.FileSyncState, error) {
+	return tx.getDatasetSyncStates(ctx, tx.tx, datasetID)
+}
+
+func (tx *pgTx) GetUnconfirmedSyncFiles(ctx context.Context, destination string) ([]string, error) {
+	return tx.getUnconfirmedSyncFiles(ctx, tx.tx, destination)
+}
+
+func (tx *pgTx) GetIngestionStatus(ctx context.Context, accessionID, user, filePath string) (*database.IngestionStatus, error) {
+	return tx.getIngestionStatus(ctx, tx.tx, accessionID, user, filePath)
+}
+
+func (tx *pgTx) RegisterSyncAPIRequest(ctx context.Context, partner, requestID string, expiresAt, now time.Time) (bool, error) {
+	return tx.registerSyncAPIRequest(ctx, tx.tx, partner, requestID, expiresAt, now)
+}
+
+func (tx *pgTx) DeleteSyncAPIRequests(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	return tx.deleteSyncAPIRequests(ctx, tx.tx, expiredBefore)
+}
+
+func (tx *pgTx) ReleaseSyncAPIRequest(ctx context.Context, partner, requestID string) error {
+	return tx.releaseSyncAPIRequest(ctx, tx.tx, partner, requestID)
+}
@@ -1,11 +1,16 @@
 package helper
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/lestrrat-go/jwx/v2/jws"
@@ -179,3 +184,58 @@ func (ts *HelperTest) TestUnanonymizeFilepath_oldMessage() {
 	newPath := UnanonymizeFilepath(filePath, userName)
 	assert.Equal(ts.T(), filePath, newPath)
 }
+
+func (ts *HelperTest) TestRateLimitedReader() {
+	content := bytes.Repeat([]byte("rate limited content"), 1000)
+	limiter := NewBandwidthLimiter(int64(len(content)))
+	// Drain the initial burst so reading the content takes a second
+	ts.True(limiter.AllowN(time.Now(), len(content)))
+
+	start := time.Now()
+	read, err := io.ReadAll(NewRateLimitedReader(context.TODO(), bytes.NewReader(content), limiter))
+	ts.NoError(err)
+	ts.Equal(content, read)
+	ts.GreaterOrEqual(time.Since(start), 900*time.Millisecond)
+}
+
+func (ts *HelperTest) TestVerifyingReader() {
+	content := bytes.Repeat([]byte("verified content"), 1000)
+	checksum := fmt.Sprintf("%x", sha256.Sum256(content))
+
+	reader := NewVerifyingReader(bytes.NewReader(content), int64(len(content)), checksum)
+	read, err := io.ReadAll(reader)
+	ts.NoError(err)
+	ts.NoError(reader.Err())
+	ts.Equal(content, read)
+
+	reader = NewVerifyingReader(bytes.NewReader(content[1:]), int64(len(content)), checksum)
+	_, err = io.ReadAll(reader)
+	ts.ErrorIs(err, ErrContentMismatch)
+	ts.ErrorContains(err, "read size: 15999 does not match expected size: 16000")
+	ts.ErrorIs(reader.Err(), ErrContentMismatch)
+
+	corrupted := bytes.Clone(content)
+	corrupted[100] ^= 0xff
+	reader = NewVerifyingReader(bytes.NewReader(corrupted), int64(len(content)), checksum)
+	_, err = io.ReadAll(reader)
+	ts.ErrorIs(err, ErrContentMismatch)
+	ts.ErrorContains(err, "does not match expected checksum: "+checksum)
+}
+
+func (ts *HelperTest) TestFormatUploadFilePath() {
+	unixPath := "a/b/c.c4gh"
+	testPath := "a\\b\\c.c4gh"
+	uploadPath, err := FormatUploadFilePath(testPath)
+	assert.NoError(ts.T(), err)
+	assert.Equal(ts.T(), unixPath, uploadPath)
+
+	// mixed "\" and "/"
+	weirdPath := `dq\sw:*?"<>|\t\s/df.c4gh`
+	_, err = FormatUploadFilePath(weirdPath)
+	assert.EqualError(ts.T(), err, "filepath contains mixed '\\' and '/' characters")
+
+	// no mixed "\" and "/" but not allowed
+	weirdPath = `dq\sw:*?"<>|\t\sdf!s'(a);w@4&f=+e$,g#[]d%.c4gh`
+	_, err = FormatUploadFilePath(weirdPath)
+	assert.EqualError(ts.T(), err, "filepath contains disallowed characters: :, *, ?, \", <, >, |, !, ', (, ), ;, @, &, =, +, $, ,, #, [, ], %")
+}
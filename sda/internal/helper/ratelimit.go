@@ -0,0 +1,44 @@
+package helper
+
+import (
+	"context"
+	"io"
+	"math"
+
+	"golang.org/x/time/rate"
+)
+
+// NewBandwidthLimiter creates a limiter of the rate in bytes per second, which allows reading up to one second worth of
+// data at once
+func NewBandwidthLimiter(bytesPerSecond int64) *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), int(min(bytesPerSecond, math.MaxInt32)))
+}
+
+// RateLimitedReader waits for the limiter to allow the amount of bytes read from the underlying reader
+type RateLimitedReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	limiter *rate.Limiter
+}
+
+// NewRateLimitedReader returns a reader which reads from the reader at the rate allowed by the limiter, the limiter can
+// be shared by multiple readers to limit their combined rate
+func NewRateLimitedReader(ctx context.Context, reader io.Reader, limiter *rate.Limiter) *RateLimitedReader {
+	return &RateLimitedReader{ctx: ctx, reader: reader, limiter: limiter}
+}
+
+func (r *RateLimitedReader) Read(p []byte) (int, error) {
+	// The limiter can not allow more than its burst at once
+	if len(p) > r.limiter.Burst() {
+		p = p[:r.limiter.Burst()]
+	}
+
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+
+	return n, err
+}
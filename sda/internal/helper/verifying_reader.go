@@ -0,0 +1,62 @@
+package helper
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// ErrContentMismatch is returned by a VerifyingReader when the content read does not match the expected size or
+// checksum
+var ErrContentMismatch = errors.New("content does not match")
+
+// VerifyingReader counts and hashes the content read, and fails the final read if the size or sha256 checksum of the
+// content does not match the expected, so that writing the content fails rather than storing mismatching content
+type VerifyingReader struct {
+	reader           io.Reader
+	hash             hash.Hash
+	size             int64
+	expectedSize     int64
+	expectedChecksum string
+	err              error
+}
+
+// NewVerifyingReader returns a reader which verifies the content read from the reader against the expected size and
+// hex encoded sha256 checksum
+func NewVerifyingReader(reader io.Reader, expectedSize int64, expectedChecksum string) *VerifyingReader {
+	return &VerifyingReader{
+		reader:           reader,
+		hash:             sha256.New(),
+		expectedSize:     expectedSize,
+		expectedChecksum: expectedChecksum,
+	}
+}
+
+func (r *VerifyingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.hash.Write(p[:n])
+	r.size += int64(n)
+
+	if errors.Is(err, io.EOF) {
+		if r.size != r.expectedSize {
+			r.err = fmt.Errorf("%w: read size: %d does not match expected size: %d", ErrContentMismatch, r.size, r.expectedSize)
+
+			return n, r.err
+		}
+		if checksum := fmt.Sprintf("%x", r.hash.Sum(nil)); checksum != r.expectedChecksum {
+			r.err = fmt.Errorf("%w: checksum: %s does not match expected checksum: %s", ErrContentMismatch, checksum, r.expectedChecksum)
+
+			return n, r.err
+		}
+	}
+
+	return n, err
+}
+
+// Err returns the mismatch found when the end of the content was read, nil if the content matched or has not been read
+// to the end
+func (r *VerifyingReader) Err() error {
+	return r.err
+}
@@ -0,0 +1,87 @@
+// Package inboxcheck holds the checks applied to uploads by both the s3inbox and the sftp inbox, so that files can
+// not bypass them by being uploaded through the other inbox.
+package inboxcheck
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/neicnordic/crypt4gh/keys"
+	"github.com/neicnordic/crypt4gh/model/headers"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/reencrypt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// MaxHeaderSize is the maximum size of the crypt4gh header at the start of an upload, headers are small so anything
+// larger is rejected without reading further
+const MaxHeaderSize = 64 * 1024
+
+// ErrInvalidHeader is returned when an upload does not start with a crypt4gh header encrypted with one of the active
+// archive keys
+var ErrInvalidHeader = errors.New("invalid crypt4gh header")
+
+// ValidateHeader parses the crypt4gh header from the start of the upload body and checks that one of its header
+// packets can be decrypted with an archive key which key hash is registered and not deprecated.
+// The archive keys are not available to the inboxes, the header is instead sent to the reencrypt service which
+// re-encrypts it for a throwaway key and reports the key hash of the archive key that decrypted it.
+// The returned reader replays the bytes consumed while parsing the header followed by the remainder of the body, so
+// that the upload can be forwarded unaltered.
+func ValidateHeader(ctx context.Context, reencryptClient reencrypt.ReencryptClient, db database.Database, body io.Reader) (io.Reader, error) {
+	var consumed bytes.Buffer
+	header, err := headers.ReadHeader(bufio.NewReader(io.LimitReader(io.TeeReader(body, &consumed), MaxHeaderSize)))
+	replay := io.MultiReader(&consumed, body)
+	if err != nil {
+		return replay, fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+	}
+
+	publicKey, _, err := keys.GenerateKeyPair()
+	if err != nil {
+		return replay, fmt.Errorf("failed to generate key pair: %v", err)
+	}
+
+	res, err := reencryptClient.ReencryptHeader(ctx, &reencrypt.ReencryptRequest{
+		Oldheader: header,
+		Publickey: base64.StdEncoding.EncodeToString(publicKey[:]),
+	})
+	switch {
+	// The reencrypt service answers with code 400 when none of its keys can decrypt the header
+	case status.Code(err) == codes.Code(400):
+		return replay, fmt.Errorf("%w: file is not encrypted with an archive key", ErrInvalidHeader)
+	case err != nil:
+		return replay, fmt.Errorf("failed to check header with the reencrypt service: %v", err)
+	}
+
+	activeKeyHashes, err := activeKeyHashes(ctx, db)
+	if err != nil {
+		return replay, err
+	}
+	if !activeKeyHashes[res.GetKeyhash()] {
+		return replay, fmt.Errorf("%w: file is not encrypted with an active archive key", ErrInvalidHeader)
+	}
+
+	return replay, nil
+}
+
+// activeKeyHashes returns the registered key hashes that have not been deprecated
+func activeKeyHashes(ctx context.Context, db database.Database) (map[string]bool, error) {
+	keyHashes, err := db.ListKeyHashes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list key hashes from database: %v", err)
+	}
+
+	active := make(map[string]bool, len(keyHashes))
+	for _, keyHash := range keyHashes {
+		if keyHash.DeprecatedAt == "" {
+			active[keyHash.Hash] = true
+		}
+	}
+
+	return active, nil
+}
@@ -0,0 +1,171 @@
+package inboxcheck
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/neicnordic/crypt4gh/keys"
+	"github.com/neicnordic/crypt4gh/model/headers"
+	"github.com/neicnordic/crypt4gh/streaming"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/reencrypt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type InboxCheckTests struct {
+	suite.Suite
+	publicKey [32]byte
+	db        *mockDatabase
+	reencrypt *mockReencryptClient
+}
+
+func TestInboxCheckTestSuite(t *testing.T) {
+	suite.Run(t, new(InboxCheckTests))
+}
+
+// mockDatabase implements the database functions used for validating headers and checking quotas, calling any other
+// function panics
+type mockDatabase struct {
+	database.Database
+	keyHashes []*database.C4ghKeyHash
+	quotas    map[string]*database.InboxQuota
+	usage     database.InboxUsage
+	// excludedFileID is the file left out of the last usage query
+	excludedFileID string
+}
+
+func (m *mockDatabase) ListKeyHashes(_ context.Context) ([]*database.C4ghKeyHash, error) {
+	return m.keyHashes, nil
+}
+
+func (m *mockDatabase) GetInboxQuota(_ context.Context, submissionUser string) (*database.InboxQuota, error) {
+	return m.quotas[submissionUser], nil
+}
+
+func (m *mockDatabase) GetInboxUsage(_ context.Context, _, excludedFileID string) (*database.InboxUsage, error) {
+	m.excludedFileID = excludedFileID
+
+	return &m.usage, nil
+}
+
+// mockReencryptClient re-encrypts headers with the archive keys like the reencrypt service does
+type mockReencryptClient struct {
+	archiveKeys []*[32]byte
+	err         error
+}
+
+func (m *mockReencryptClient) ReencryptHeader(_ context.Context, in *reencrypt.ReencryptRequest, _ ...grpc.CallOption) (*reencrypt.ReencryptResponse, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	publicKey, err := base64.StdEncoding.DecodeString(in.GetPublickey())
+	if err != nil || len(publicKey) != 32 {
+		return nil, status.Error(400, "bad public key")
+	}
+	for _, key := range m.archiveKeys {
+		newHeader, err := headers.ReEncryptHeader(in.GetOldheader(), *key, [][32]byte{[32]byte(publicKey)})
+		if err == nil {
+			archivePublicKey := keys.DerivePublicKey(*key)
+
+			return &reencrypt.ReencryptResponse{Header: newHeader, Keyhash: hex.EncodeToString(archivePublicKey[:])}, nil
+		}
+	}
+
+	return nil, status.Error(400, "header reencryption failed, no matching key available")
+}
+
+func (s *InboxCheckTests) SetupTest() {
+	publicKey, privateKey, err := keys.GenerateKeyPair()
+	assert.NoError(s.T(), err)
+	s.publicKey = publicKey
+
+	s.db = &mockDatabase{
+		keyHashes: []*database.C4ghKeyHash{{Hash: hex.EncodeToString(publicKey[:])}},
+		quotas:    make(map[string]*database.InboxQuota),
+	}
+	s.reencrypt = &mockReencryptClient{archiveKeys: []*[32]byte{&privateKey}}
+}
+
+// encrypt returns the content encrypted with crypt4gh for the recipient
+func (s *InboxCheckTests) encrypt(content []byte, recipient [32]byte) []byte {
+	_, privateKey, err := keys.GenerateKeyPair()
+	assert.NoError(s.T(), err)
+
+	var encrypted bytes.Buffer
+	writer, err := streaming.NewCrypt4GHWriter(&encrypted, privateKey, [][32]byte{recipient}, nil)
+	assert.NoError(s.T(), err)
+	_, err = writer.Write(content)
+	assert.NoError(s.T(), err)
+	assert.NoError(s.T(), writer.Close())
+
+	return encrypted.Bytes()
+}
+
+func (s *InboxCheckTests) TestValidateHeader() {
+	encrypted := s.encrypt(bytes.Repeat([]byte("content"), 100000), s.publicKey)
+
+	body, err := ValidateHeader(context.TODO(), s.reencrypt, s.db, bytes.NewReader(encrypted))
+	assert.NoError(s.T(), err)
+
+	// The whole upload is forwarded, including the parsed header
+	forwarded, err := io.ReadAll(body)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), encrypted, forwarded)
+}
+
+func (s *InboxCheckTests) TestValidateHeader_notCrypt4gh() {
+	_, err := ValidateHeader(context.TODO(), s.reencrypt, s.db, bytes.NewReader([]byte("this is not a crypt4gh file")))
+	assert.ErrorIs(s.T(), err, ErrInvalidHeader)
+
+	_, err = ValidateHeader(context.TODO(), s.reencrypt, s.db, http.NoBody)
+	assert.ErrorIs(s.T(), err, ErrInvalidHeader)
+}
+
+func (s *InboxCheckTests) TestValidateHeader_wrongKey() {
+	otherPublicKey, _, err := keys.GenerateKeyPair()
+	assert.NoError(s.T(), err)
+
+	_, err = ValidateHeader(context.TODO(), s.reencrypt, s.db, bytes.NewReader(s.encrypt([]byte("content"), otherPublicKey)))
+	assert.ErrorIs(s.T(), err, ErrInvalidHeader)
+}
+
+func (s *InboxCheckTests) TestValidateHeader_deprecatedKey() {
+	s.db.keyHashes[0].DeprecatedAt = "2024-01-01 00:00:00"
+
+	_, err := ValidateHeader(context.TODO(), s.reencrypt, s.db, bytes.NewReader(s.encrypt([]byte("content"), s.publicKey)))
+	assert.ErrorIs(s.T(), err, ErrInvalidHeader)
+}
+
+func (s *InboxCheckTests) TestValidateHeader_tooLarge() {
+	// A header claiming more packet data than the maximum header size is rejected without reading the whole body
+	header := []byte("crypt4gh\x01\x00\x00\x00\x01\x00\x00\x00\xff\xff\x0f\x00")
+	body := io.MultiReader(bytes.NewReader(header), bytes.NewReader(make([]byte, 2*MaxHeaderSize)))
+
+	_, err := ValidateHeader(context.TODO(), s.reencrypt, s.db, body)
+	assert.ErrorIs(s.T(), err, ErrInvalidHeader)
+}
+
+func (s *InboxCheckTests) TestValidateHeader_reencryptUnavailable() {
+	s.reencrypt.err = status.Error(codes.Unavailable, "connection refused")
+
+	_, err := ValidateHeader(context.TODO(), s.reencrypt, s.db, bytes.NewReader(s.encrypt([]byte("content"), s.publicKey)))
+	assert.Error(s.T(), err)
+	assert.NotErrorIs(s.T(), err, ErrInvalidHeader)
+}
+
+func (s *InboxCheckTests) TestValidateHeader_unregisteredKey() {
+	s.db.keyHashes = nil
+
+	_, err := ValidateHeader(context.TODO(), s.reencrypt, s.db, bytes.NewReader(s.encrypt([]byte("content"), s.publicKey)))
+	assert.ErrorIs(s.T(), err, ErrInvalidHeader)
+}
@@ -0,0 +1,66 @@
+package inboxcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+)
+
+// ErrQuotaExceeded is returned when an upload would exceed the quota of the user
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// CheckQuota returns ErrQuotaExceeded when the file uploaded by the user would exceed the quota of the user, size
+// returns the size of the uploaded file and is only called when the size of the inbox of the user is limited. The
+// default quota is the quota of users that do not have a quota of their own, and the file with id fileID, which is
+// replaced by the upload, is left out of the usage of the user
+func CheckQuota(ctx context.Context, db database.Database, defaultQuota database.InboxQuota, username, fileID string, size func() (int64, error)) error {
+	remaining, err := RemainingQuota(ctx, db, defaultQuota, username, fileID)
+	if err != nil || remaining < 0 {
+		return err
+	}
+
+	fileSize, err := size()
+	if err != nil {
+		return err
+	}
+	if fileSize > remaining {
+		return fmt.Errorf("%w: upload is %d bytes, user has %d bytes left", ErrQuotaExceeded, fileSize, remaining)
+	}
+
+	return nil
+}
+
+// RemainingQuota returns the size of the largest file the user can upload, -1 when the size of the inbox of the user
+// is not limited. ErrQuotaExceeded is returned when the user has reached the quota, as no file can be uploaded then.
+// The default quota and fileID are the same as for CheckQuota
+func RemainingQuota(ctx context.Context, db database.Database, defaultQuota database.InboxQuota, username, fileID string) (int64, error) {
+	quota, err := db.GetInboxQuota(ctx, username)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get quota of user from database: %v", err)
+	}
+	if quota == nil {
+		quota = &defaultQuota
+	}
+	if quota.MaxBytes == 0 && quota.MaxFiles == 0 {
+		return -1, nil
+	}
+
+	usage, err := db.GetInboxUsage(ctx, username, fileID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get inbox usage of user from database: %v", err)
+	}
+
+	if quota.MaxFiles > 0 && usage.Files >= quota.MaxFiles {
+		return 0, fmt.Errorf("%w: user has %d of %d files", ErrQuotaExceeded, usage.Files, quota.MaxFiles)
+	}
+	if quota.MaxBytes == 0 {
+		return -1, nil
+	}
+	if usage.Bytes >= quota.MaxBytes {
+		return 0, fmt.Errorf("%w: user has %d of %d bytes", ErrQuotaExceeded, usage.Bytes, quota.MaxBytes)
+	}
+
+	return quota.MaxBytes - usage.Bytes, nil
+}
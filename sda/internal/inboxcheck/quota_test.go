@@ -0,0 +1,64 @@
+package inboxcheck
+
+import (
+	"context"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/stretchr/testify/assert"
+)
+
+// sizeOf returns a function returning the size of an uploaded file
+func sizeOf(size int64) func() (int64, error) {
+	return func() (int64, error) {
+		return size, nil
+	}
+}
+
+func (s *InboxCheckTests) TestCheckQuota() {
+	defaultQuota := database.InboxQuota{MaxBytes: 1000, MaxFiles: 10}
+	s.db.usage = database.InboxUsage{Bytes: 900, Files: 9}
+
+	assert.NoError(s.T(), CheckQuota(context.TODO(), s.db, defaultQuota, "dummy", "", sizeOf(100)))
+	assert.ErrorIs(s.T(), CheckQuota(context.TODO(), s.db, defaultQuota, "dummy", "", sizeOf(101)), ErrQuotaExceeded)
+
+	// The file replaced by the upload is left out of the usage
+	assert.NoError(s.T(), CheckQuota(context.TODO(), s.db, defaultQuota, "dummy", "file-id", sizeOf(100)))
+	assert.Equal(s.T(), "file-id", s.db.excludedFileID)
+
+	// The size is not needed when the inbox of the user is not limited
+	noSize := func() (int64, error) {
+		s.FailNow("size should not be requested")
+
+		return 0, nil
+	}
+	assert.NoError(s.T(), CheckQuota(context.TODO(), s.db, database.InboxQuota{}, "dummy", "", noSize))
+}
+
+func (s *InboxCheckTests) TestRemainingQuota() {
+	s.db.usage = database.InboxUsage{Bytes: 900, Files: 9}
+
+	remaining, err := RemainingQuota(context.TODO(), s.db, database.InboxQuota{}, "dummy", "")
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(-1), remaining)
+
+	remaining, err = RemainingQuota(context.TODO(), s.db, database.InboxQuota{MaxBytes: 1000, MaxFiles: 10}, "dummy", "")
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(100), remaining)
+
+	// Only the number of files is limited
+	remaining, err = RemainingQuota(context.TODO(), s.db, database.InboxQuota{MaxFiles: 10}, "dummy", "")
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(-1), remaining)
+
+	_, err = RemainingQuota(context.TODO(), s.db, database.InboxQuota{MaxFiles: 9}, "dummy", "")
+	assert.ErrorIs(s.T(), err, ErrQuotaExceeded)
+
+	_, err = RemainingQuota(context.TODO(), s.db, database.InboxQuota{MaxBytes: 900}, "dummy", "")
+	assert.ErrorIs(s.T(), err, ErrQuotaExceeded)
+
+	// The quota of the user replaces the default quota
+	s.db.quotas["dummy"] = &database.InboxQuota{User: "dummy", MaxBytes: 2000}
+	remaining, err = RemainingQuota(context.TODO(), s.db, database.InboxQuota{MaxBytes: 900}, "dummy", "")
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(1100), remaining)
+}
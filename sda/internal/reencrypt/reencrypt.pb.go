@@ -85,13 +85,15 @@ func (x *ReencryptRequest) GetDataeditlist() []uint64 {
 	return nil
 }
 
-// The response message containing the re-encrypted header
+// The response message containing the re-encrypted header and the hash
+// of the public key matching the private key that decrypted the old header
 type ReencryptResponse struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Header []byte `protobuf:"bytes,1,opt,name=header,proto3" json:"header,omitempty"`
+	Header  []byte `protobuf:"bytes,1,opt,name=header,proto3" json:"header,omitempty"`
+	Keyhash string `protobuf:"bytes,2,opt,name=keyhash,proto3" json:"keyhash,omitempty"`
 }
 
 func (x *ReencryptResponse) Reset() {
@@ -133,6 +135,13 @@ func (x *ReencryptResponse) GetHeader() []byte {
 	return nil
 }
 
+func (x *ReencryptResponse) GetKeyhash() string {
+	if x != nil {
+		return x.Keyhash
+	}
+	return ""
+}
+
 var File_internal_reencrypt_reencrypt_proto protoreflect.FileDescriptor
 
 var file_internal_reencrypt_reencrypt_proto_rawDesc = []byte{
@@ -146,20 +155,22 @@ var file_internal_reencrypt_reencrypt_proto_rawDesc = []byte{
 	0x20, 0x01, 0x28, 0x0c, 0x52, 0x09, 0x6f, 0x6c, 0x64, 0x68, 0x65, 0x61, 0x64, 0x65, 0x72, 0x12,
 	0x22, 0x0a, 0x0c, 0x64, 0x61, 0x74, 0x61, 0x65, 0x64, 0x69, 0x74, 0x6c, 0x69, 0x73, 0x74, 0x18,
 	0x03, 0x20, 0x03, 0x28, 0x04, 0x52, 0x0c, 0x64, 0x61, 0x74, 0x61, 0x65, 0x64, 0x69, 0x74, 0x6c,
-	0x69, 0x73, 0x74, 0x22, 0x2b, 0x0a, 0x11, 0x52, 0x65, 0x65, 0x6e, 0x63, 0x72, 0x79, 0x70, 0x74,
+	0x69, 0x73, 0x74, 0x22, 0x45, 0x0a, 0x11, 0x52, 0x65, 0x65, 0x6e, 0x63, 0x72, 0x79, 0x70, 0x74,
 	0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x68, 0x65, 0x61, 0x64,
 	0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x68, 0x65, 0x61, 0x64, 0x65, 0x72,
-	0x32, 0x5b, 0x0a, 0x09, 0x52, 0x65, 0x65, 0x6e, 0x63, 0x72, 0x79, 0x70, 0x74, 0x12, 0x4e, 0x0a,
-	0x0f, 0x52, 0x65, 0x65, 0x6e, 0x63, 0x72, 0x79, 0x70, 0x74, 0x48, 0x65, 0x61, 0x64, 0x65, 0x72,
-	0x12, 0x1b, 0x2e, 0x72, 0x65, 0x65, 0x6e, 0x63, 0x72, 0x79, 0x70, 0x74, 0x2e, 0x52, 0x65, 0x65,
-	0x6e, 0x63, 0x72, 0x79, 0x70, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1c, 0x2e,
-	0x72, 0x65, 0x65, 0x6e, 0x63, 0x72, 0x79, 0x70, 0x74, 0x2e, 0x52, 0x65, 0x65, 0x6e, 0x63, 0x72,
-	0x79, 0x70, 0x74, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x42, 0x41, 0x5a,
-	0x3f, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6e, 0x65, 0x69, 0x63,
-	0x6e, 0x6f, 0x72, 0x64, 0x69, 0x63, 0x2f, 0x73, 0x65, 0x6e, 0x73, 0x69, 0x74, 0x69, 0x76, 0x65,
-	0x2d, 0x64, 0x61, 0x74, 0x61, 0x2d, 0x61, 0x72, 0x63, 0x68, 0x69, 0x76, 0x65, 0x2f, 0x69, 0x6e,
-	0x74, 0x65, 0x72, 0x6e, 0x61, 0x6c, 0x2f, 0x72, 0x65, 0x65, 0x6e, 0x63, 0x72, 0x79, 0x70, 0x74,
-	0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x12, 0x18, 0x0a, 0x07, 0x6b, 0x65, 0x79, 0x68, 0x61, 0x73, 0x68, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x07, 0x6b, 0x65, 0x79, 0x68, 0x61, 0x73, 0x68, 0x32, 0x5b, 0x0a, 0x09, 0x52, 0x65,
+	0x65, 0x6e, 0x63, 0x72, 0x79, 0x70, 0x74, 0x12, 0x4e, 0x0a, 0x0f, 0x52, 0x65, 0x65, 0x6e, 0x63,
+	0x72, 0x79, 0x70, 0x74, 0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x12, 0x1b, 0x2e, 0x72, 0x65, 0x65,
+	0x6e, 0x63, 0x72, 0x79, 0x70, 0x74, 0x2e, 0x52, 0x65, 0x65, 0x6e, 0x63, 0x72, 0x79, 0x70, 0x74,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1c, 0x2e, 0x72, 0x65, 0x65, 0x6e, 0x63, 0x72,
+	0x79, 0x70, 0x74, 0x2e, 0x52, 0x65, 0x65, 0x6e, 0x63, 0x72, 0x79, 0x70, 0x74, 0x52, 0x65, 0x73,
+	0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x42, 0x41, 0x5a, 0x3f, 0x67, 0x69, 0x74, 0x68, 0x75,
+	0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6e, 0x65, 0x69, 0x63, 0x6e, 0x6f, 0x72, 0x64, 0x69, 0x63,
+	0x2f, 0x73, 0x65, 0x6e, 0x73, 0x69, 0x74, 0x69, 0x76, 0x65, 0x2d, 0x64, 0x61, 0x74, 0x61, 0x2d,
+	0x61, 0x72, 0x63, 0x68, 0x69, 0x76, 0x65, 0x2f, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x6e, 0x61, 0x6c,
+	0x2f, 0x72, 0x65, 0x65, 0x6e, 0x63, 0x72, 0x79, 0x70, 0x74, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x33,
 }
 
 var (
@@ -11,12 +11,9 @@ import (
 	"google.golang.org/grpc/credentials/insecure"
 )
 
-// CallReencryptHeader re-encrypts the header of a file using the public key
-// provided and returns the new header. The function uses gRPC to
-// communicate with the re-encrypt service and handles TLS configuration
-// if needed. The function also handles the case where the CA certificate
-// is provided for secure communication.
-func CallReencryptHeader(oldHeader []byte, c4ghPubKey string, grpcConf config.Grpc) ([]byte, error) {
+// NewClientConn opens a gRPC channel to the re-encrypt service, using TLS
+// when client credentials are configured.
+func NewClientConn(grpcConf config.Grpc) (*grpc.ClientConn, error) {
 	var opts []grpc.DialOption
 	switch {
 	case grpcConf.ClientCreds != nil:
@@ -25,7 +22,16 @@ func CallReencryptHeader(oldHeader []byte, c4ghPubKey string, grpcConf config.Gr
 		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	}
 
-	conn, err := grpc.NewClient(fmt.Sprintf("%s:%d", grpcConf.Host, grpcConf.Port), opts...)
+	return grpc.NewClient(fmt.Sprintf("%s:%d", grpcConf.Host, grpcConf.Port), opts...)
+}
+
+// CallReencryptHeader re-encrypts the header of a file using the public key
+// provided and returns the new header. The function uses gRPC to
+// communicate with the re-encrypt service and handles TLS configuration
+// if needed. The function also handles the case where the CA certificate
+// is provided for secure communication.
+func CallReencryptHeader(oldHeader []byte, c4ghPubKey string, grpcConf config.Grpc) ([]byte, error) {
+	conn, err := NewClientConn(grpcConf)
 	if err != nil {
 		log.Errorf("failed to open a new gRPC channel, reason: %v", err)
 
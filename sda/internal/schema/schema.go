@@ -43,6 +43,8 @@ func getStructName(path string) any {
 		return new(DatasetMapping)
 	case "dataset-release":
 		return new(DatasetRelease)
+	case "inbox-expiry":
+		return new(InboxExpiry)
 	case "inbox-remove":
 		return new(InboxRemove)
 	case "inbox-rename":
@@ -65,10 +67,16 @@ func getStructName(path string) any {
 		return new(IngestionVerification)
 	case "file-sync":
 		return new(SyncDataset)
+	case "file-sync-status":
+		return new(SyncFileStatus)
 	case "metadata-sync":
 		return new(SyncMetadata)
 	case "rotate-key":
 		return new(KeyRotation)
+	case "repair-file":
+		return new(RepairFile)
+	case "migrate-file":
+		return new(MigrateFile)
 	default:
 		return ""
 	}
@@ -101,6 +109,12 @@ type InfoError struct {
 	OriginalMessage any    `json:"original-message"`
 }
 
+type InboxExpiry struct {
+	User      string `json:"user"`
+	FilePath  string `json:"filepath"`
+	ExpiresAt string `json:"expires_at"`
+}
+
 type InboxRemove struct {
 	User      string `json:"user"`
 	FilePath  string `json:"filepath"`
@@ -115,9 +129,12 @@ type InboxRename struct {
 }
 
 type InboxUpload struct {
-	User      string `json:"user"`
-	FilePath  string `json:"filepath"`
-	Operation string `json:"operation"`
+	User               string      `json:"user"`
+	FilePath           string      `json:"filepath"`
+	Operation          string      `json:"operation"`
+	FileSize           int64       `json:"filesize,omitempty"`
+	FileLastModified   int64       `json:"file_last_modified,omitempty"`
+	EncryptedChecksums []Checksums `json:"encrypted_checksums,omitempty"`
 }
 
 type IngestionAccession struct {
@@ -174,6 +191,18 @@ type DatasetFiles struct {
 	ShaSum   string `json:"sha256"`
 }
 
+type SyncFileStatus struct {
+	Files []SyncFile `json:"files"`
+}
+
+type SyncFile struct {
+	User      string `json:"user"`
+	FilePath  string `json:"filepath"`
+	FileID    string `json:"file_id"`
+	DatasetID string `json:"dataset_id"`
+	ShaSum    string `json:"sha256"`
+}
+
 type SyncMetadata struct {
 	DatasetID string `json:"dataset_id"`
 	Metadata  any    `json:"metadata"`
@@ -192,3 +221,14 @@ type KeyRotation struct {
 	Type   string `json:"type"`
 	FileID string `json:"file_id"`
 }
+
+type RepairFile struct {
+	Type   string `json:"type"`
+	FileID string `json:"file_id"`
+}
+
+type MigrateFile struct {
+	Type           string `json:"type"`
+	FileID         string `json:"file_id"`
+	SourceLocation string `json:"source_location"`
+}
@@ -146,6 +146,22 @@ func TestValidateJSONInboxUpload(t *testing.T) {
 	assert.Nil(t, ValidateJSON(fmt.Sprintf("%s/federated/inbox-upload.json", schemaPath), msg))
 	assert.Nil(t, ValidateJSON(fmt.Sprintf("%s/isolated/inbox-upload.json", schemaPath), msg))
 
+	okMsg = InboxUpload{
+		User:             "JohnDoe",
+		FilePath:         "path/to/file",
+		Operation:        "upload",
+		FileSize:         1024,
+		FileLastModified: 1700000000,
+		EncryptedChecksums: []Checksums{
+			{Type: "sha256", Value: "82e4e60e7beb3db2e06a00a079788f7d71f75b61a4b75f28c4c942703dabb6d6"},
+			{Type: "md5", Value: "7ac236b1a8dce2dac89e7cf45d2b48bd"},
+		},
+	}
+
+	msg, _ = json.Marshal(okMsg)
+	assert.Nil(t, ValidateJSON(fmt.Sprintf("%s/federated/inbox-upload.json", schemaPath), msg))
+	assert.Nil(t, ValidateJSON(fmt.Sprintf("%s/isolated/inbox-upload.json", schemaPath), msg))
+
 	badMsg := InboxUpload{
 		User:      "JohnDoe",
 		FilePath:  "/",
@@ -450,6 +466,35 @@ func TestValidateJSONBigpictureFileSync(t *testing.T) {
 	assert.Error(t, ValidateJSON(fmt.Sprintf("%s/bigpicture/file-sync.json", schemaPath), msg))
 }
 
+func TestValidateJSONBigpictureFileSyncStatus(t *testing.T) {
+	okMsg := SyncFileStatus{
+		Files: []SyncFile{
+			{
+				User:      "test.user@example.com",
+				FilePath:  "inbox/user/file1.c4gh",
+				FileID:    "5fe7b660-afea-4c3a-88a9-3daabf055ebb",
+				DatasetID: "cd532362-e06e-4460-8490-b9ce64b8d9e7",
+				ShaSum:    "82E4e60e7beb3db2e06A00a079788F7d71f75b61a4b75f28c4c942703dabb6d6",
+			},
+		},
+	}
+
+	msg, _ := json.Marshal(okMsg)
+	assert.Nil(t, ValidateJSON(fmt.Sprintf("%s/bigpicture/file-sync-status.json", schemaPath), msg))
+
+	// The dataset of the file is required
+	okMsg.Files[0].DatasetID = ""
+	msg, _ = json.Marshal(okMsg)
+	assert.Error(t, ValidateJSON(fmt.Sprintf("%s/bigpicture/file-sync-status.json", schemaPath), msg))
+
+	badMsg := SyncFileStatus{
+		Files: []SyncFile{{FileID: "5fe7b660-afea-4c3a-88a9-3daabf055ebb"}},
+	}
+
+	msg, _ = json.Marshal(badMsg)
+	assert.Error(t, ValidateJSON(fmt.Sprintf("%s/bigpicture/file-sync-status.json", schemaPath), msg))
+}
+
 func TestValidateJSONBigpictureMetadtaSync(t *testing.T) {
 	okMsg := SyncMetadata{
 		DatasetID: "cd532362-e06e-4460-8490-b9ce64b8d9e7",
@@ -487,3 +532,62 @@ func TestValidateJSONKeyRotation(t *testing.T) {
 	msg, _ = json.Marshal(badMsg)
 	assert.Error(t, ValidateJSON(fmt.Sprintf("%s/isolated/rotate-key.json", schemaPath), msg))
 }
+
+func TestValidateJSONRepairFile(t *testing.T) {
+	okMsg := RepairFile{
+		Type:   "repair",
+		FileID: "cd532362-e06e-4460-8490-b9ce64b8d9e7",
+	}
+
+	msg, _ := json.Marshal(okMsg)
+	assert.Nil(t, ValidateJSON(fmt.Sprintf("%s/isolated/repair-file.json", schemaPath), msg))
+	assert.Nil(t, ValidateJSON(fmt.Sprintf("%s/federated/repair-file.json", schemaPath), msg))
+
+	badMsg := RepairFile{
+		Type:   "repair",
+		FileID: "not-a-uuid",
+	}
+
+	msg, _ = json.Marshal(badMsg)
+	assert.Error(t, ValidateJSON(fmt.Sprintf("%s/isolated/repair-file.json", schemaPath), msg))
+}
+
+func TestValidateJSONMigrateFile(t *testing.T) {
+	okMsg := MigrateFile{
+		Type:           "migrate",
+		FileID:         "cd532362-e06e-4460-8490-b9ce64b8d9e7",
+		SourceLocation: "/archive",
+	}
+
+	msg, _ := json.Marshal(okMsg)
+	assert.Nil(t, ValidateJSON(fmt.Sprintf("%s/isolated/migrate-file.json", schemaPath), msg))
+	assert.Nil(t, ValidateJSON(fmt.Sprintf("%s/federated/migrate-file.json", schemaPath), msg))
+
+	badMsg := MigrateFile{
+		Type:   "migrate",
+		FileID: "cd532362-e06e-4460-8490-b9ce64b8d9e7",
+	}
+
+	msg, _ = json.Marshal(badMsg)
+	assert.Error(t, ValidateJSON(fmt.Sprintf("%s/isolated/migrate-file.json", schemaPath), msg))
+}
+
+func TestValidateJSONInboxExpiry(t *testing.T) {
+	okMsg := InboxExpiry{
+		User:      "JohnDoe",
+		FilePath:  "path/to/file.c4gh",
+		ExpiresAt: "2024-01-31T12:00:00Z",
+	}
+
+	msg, _ := json.Marshal(okMsg)
+	assert.Nil(t, ValidateJSON(fmt.Sprintf("%s/isolated/inbox-expiry.json", schemaPath), msg))
+	assert.Nil(t, ValidateJSON(fmt.Sprintf("%s/federated/inbox-expiry.json", schemaPath), msg))
+
+	badMsg := InboxExpiry{
+		User:     "JohnDoe",
+		FilePath: "path/to/file.c4gh",
+	}
+
+	msg, _ = json.Marshal(badMsg)
+	assert.Error(t, ValidateJSON(fmt.Sprintf("%s/isolated/inbox-expiry.json", schemaPath), msg))
+}
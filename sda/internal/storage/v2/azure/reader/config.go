@@ -0,0 +1,94 @@
+package reader
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/c2h5oh/datasize"
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/spf13/viper"
+)
+
+type endpointConfig struct {
+	AccountKey      string `mapstructure:"account_key"` // #nosec G117 -- needs to be exported for unmarshalling
+	AccountName     string `mapstructure:"account_name"`
+	ChunkSize       string `mapstructure:"chunk_size"`
+	chunkSizeBytes  uint64
+	ContainerPrefix string `mapstructure:"container_prefix"`
+	Endpoint        string `mapstructure:"endpoint"`
+
+	azureClient *azblob.Client // cached azure client for this endpoint, created by getAzureClient
+	clientLock  sync.Mutex     // guards the creation of the cached client
+}
+
+func loadConfig(backendName string) ([]*endpointConfig, error) {
+	var endpointConf []*endpointConfig
+
+	if err := viper.UnmarshalKey(
+		"storage."+backendName+".azure",
+		&endpointConf,
+		func(config *mapstructure.DecoderConfig) {
+			config.WeaklyTypedInput = true
+			config.ZeroFields = true
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	for _, e := range endpointConf {
+		switch {
+		case e.AccountName == "":
+			return nil, errors.New("missing required parameter: account_name")
+		case e.AccountKey == "":
+			return nil, errors.New("missing required parameter: account_key")
+		case e.ContainerPrefix == "":
+			return nil, errors.New("missing required parameter: container_prefix")
+		default:
+		}
+
+		if e.Endpoint == "" {
+			e.Endpoint = fmt.Sprintf("https://%s.blob.core.windows.net/", e.AccountName)
+		}
+
+		e.chunkSizeBytes = 50 * datasize.MB.Bytes()
+		if e.ChunkSize != "" {
+			byteSize, err := datasize.ParseString(e.ChunkSize)
+			if err != nil {
+				return nil, errors.New("could not parse chunk_size as a valid data size")
+			}
+			if byteSize < 5*datasize.MB {
+				return nil, errors.New("chunk_size can not be smaller than 5mb")
+			}
+			if byteSize > 1*datasize.GB {
+				return nil, errors.New("chunk_size can not be bigger than 1gb")
+			}
+			e.chunkSizeBytes = byteSize.Bytes()
+		}
+	}
+
+	return endpointConf, nil
+}
+
+func (endpointConf *endpointConfig) getAzureClient() (*azblob.Client, error) {
+	endpointConf.clientLock.Lock()
+	defer endpointConf.clientLock.Unlock()
+
+	if endpointConf.azureClient != nil {
+		return endpointConf.azureClient, nil
+	}
+
+	credential, err := azblob.NewSharedKeyCredential(endpointConf.AccountName, endpointConf.AccountKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create azure credential for account: %s, due to: %v", endpointConf.AccountName, err)
+	}
+
+	client, err := azblob.NewClientWithSharedKeyCredential(endpointConf.Endpoint, credential, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create azure client to endpoint: %s, due to: %v", endpointConf.Endpoint, err)
+	}
+	endpointConf.azureClient = client
+
+	return endpointConf.azureClient, nil
+}
@@ -0,0 +1,65 @@
+package reader
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+)
+
+func (reader *Reader) FindFile(ctx context.Context, filePath string) (string, error) {
+	for _, endpointConf := range reader.endpoints {
+		client, err := endpointConf.getAzureClient()
+		if err != nil {
+			return "", err
+		}
+
+		var containersWithPrefix []string
+		pager := client.NewListContainersPager(&azblob.ListContainersOptions{Prefix: &endpointConf.ContainerPrefix})
+		for pager.More() {
+			page, err := pager.NextPage(ctx)
+			if err != nil {
+				return "", err
+			}
+			for _, container := range page.ContainerItems {
+				if container.Name != nil {
+					containersWithPrefix = append(containersWithPrefix, *container.Name)
+				}
+			}
+		}
+		// Checked in the order the writer fills them
+		sortContainersNumerically(containersWithPrefix, endpointConf.ContainerPrefix)
+
+		for _, container := range containersWithPrefix {
+			if _, err := getFileSize(ctx, client, container, filePath); err != nil {
+				if errors.Is(err, storageerrors.ErrorFileNotFoundInLocation) {
+					continue
+				}
+
+				return "", err
+			}
+
+			return LocationScheme + endpointConf.AccountName + "/" + container, nil
+		}
+	}
+
+	return "", storageerrors.ErrorFileNotFoundInLocation
+}
+
+// sortContainersNumerically sorts containers in place by the integer suffix that follows prefix so `<prefix>10` comes
+// after `<prefix>9`, if a container name can't be parsed as `<prefix><number>` we fall back to lexical ordering
+func sortContainersNumerically(containers []string, prefix string) {
+	slices.SortFunc(containers, func(a, b string) int {
+		aInc, aErr := strconv.Atoi(strings.TrimPrefix(a, prefix))
+		bInc, bErr := strconv.Atoi(strings.TrimPrefix(b, prefix))
+		if aErr != nil || bErr != nil {
+			return strings.Compare(a, b)
+		}
+
+		return aInc - bInc
+	})
+}
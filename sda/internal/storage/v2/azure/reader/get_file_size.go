@@ -0,0 +1,42 @@
+package reader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+)
+
+// GetFileSize returns the size of a specific object
+func (reader *Reader) GetFileSize(ctx context.Context, location, filePath string) (int64, error) {
+	accountName, container, err := parseLocation(location)
+	if err != nil {
+		return 0, err
+	}
+
+	client, _, err := reader.getAzureClientForAccount(accountName)
+	if err != nil {
+		return 0, err
+	}
+
+	return getFileSize(ctx, client, container, filePath)
+}
+
+func getFileSize(ctx context.Context, client *azblob.Client, container, filePath string) (int64, error) {
+	properties, err := client.ServiceClient().NewContainerClient(container).NewBlobClient(filePath).GetProperties(ctx, nil)
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound, bloberror.ContainerNotFound) {
+			return 0, storageerrors.ErrorFileNotFoundInLocation
+		}
+
+		return 0, fmt.Errorf("failed to get properties of blob: %s, container: %s, due to: %v", filePath, container, err)
+	}
+	if properties.ContentLength == nil {
+		return 0, errors.New("blob properties has no content length")
+	}
+
+	return *properties.ContentLength, nil
+}
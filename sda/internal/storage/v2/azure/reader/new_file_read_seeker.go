@@ -0,0 +1,40 @@
+package reader
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/seekablereader"
+)
+
+func (reader *Reader) NewFileReadSeeker(ctx context.Context, location, filePath string) (io.ReadSeekCloser, error) {
+	accountName, container, err := parseLocation(location)
+	if err != nil {
+		return nil, err
+	}
+
+	client, endpointConf, err := reader.getAzureClientForAccount(accountName)
+	if err != nil {
+		return nil, err
+	}
+
+	objectSize, err := getFileSize(ctx, client, container, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Type conversation safe as chunkSizeBytes checked to be between 5mb and 1gb (in bytes)
+	//nolint:gosec // disable G115
+	return seekablereader.New(ctx, objectSize, int64(endpointConf.chunkSizeBytes), func(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+		r, err := client.DownloadStream(ctx, container, filePath, &azblob.DownloadStreamOptions{
+			Range: azblob.HTTPRange{Offset: offset, Count: length},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get blob: %s, container: %s, account: %s, due to: %v", filePath, container, accountName, err)
+		}
+
+		return r.Body, nil
+	}), nil
+}
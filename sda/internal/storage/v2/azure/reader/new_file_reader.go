@@ -0,0 +1,33 @@
+package reader
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+)
+
+func (reader *Reader) NewFileReader(ctx context.Context, location, filePath string) (io.ReadCloser, error) {
+	accountName, container, err := parseLocation(location)
+	if err != nil {
+		return nil, err
+	}
+
+	client, _, err := reader.getAzureClientForAccount(accountName)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := client.DownloadStream(ctx, container, filePath, nil)
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound, bloberror.ContainerNotFound) {
+			return nil, storageerrors.ErrorFileNotFoundInLocation
+		}
+
+		return nil, fmt.Errorf("failed to get blob: %s, container: %s, account: %s, due to: %v", filePath, container, accountName, err)
+	}
+
+	return r.Body, nil
+}
@@ -0,0 +1,84 @@
+package reader
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+)
+
+// LocationScheme is the prefix of all locations served by the azure storage implementation
+const LocationScheme = "az://"
+
+type Reader struct {
+	endpoints []*endpointConfig
+}
+
+func NewReader(ctx context.Context, backendName string) (*Reader, error) {
+	endPoints, err := loadConfig(backendName)
+	if err != nil {
+		return nil, err
+	}
+
+	backend := &Reader{
+		endpoints: endPoints,
+	}
+	// Verify endpoint connections
+	if err := backend.Ping(ctx); err != nil {
+		return nil, err
+	}
+	if len(backend.endpoints) == 0 {
+		return nil, storageerrors.ErrorNoValidLocations
+	}
+
+	return backend, nil
+}
+
+// Ping verifies all configured azure endpoints are reachable by listing the containers of the account
+func (reader *Reader) Ping(ctx context.Context) error {
+	for _, e := range reader.endpoints {
+		client, err := e.getAzureClient()
+		if err != nil {
+			return fmt.Errorf("failed to ping azure endpoint: %s, due to: %v", e.Endpoint, err)
+		}
+
+		pager := client.NewListContainersPager(&azblob.ListContainersOptions{Prefix: &e.ContainerPrefix})
+		if _, err := pager.NextPage(ctx); err != nil {
+			return fmt.Errorf("failed to ping azure endpoint: %s, due to: %v", e.Endpoint, err)
+		}
+	}
+
+	return nil
+}
+
+func (reader *Reader) getAzureClientForAccount(accountName string) (*azblob.Client, *endpointConfig, error) {
+	for _, e := range reader.endpoints {
+		if e.AccountName != accountName {
+			continue
+		}
+		client, err := e.getAzureClient()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return client, e, nil
+	}
+
+	return nil, nil, storageerrors.ErrorNoEndpointConfiguredForLocation
+}
+
+// parseLocation attempts to parse a location to an azure storage account, and a container
+// expected format of location is "az://${ACCOUNT_NAME}/${CONTAINER}"
+func parseLocation(location string) (string, string, error) {
+	if !strings.HasPrefix(location, LocationScheme) {
+		return "", "", storageerrors.ErrorInvalidLocation
+	}
+	accountName, container, found := strings.Cut(strings.TrimPrefix(location, LocationScheme), "/")
+	if !found || accountName == "" || container == "" || strings.Contains(container, "/") {
+		return "", "", storageerrors.ErrorInvalidLocation
+	}
+
+	return accountName, container, nil
+}
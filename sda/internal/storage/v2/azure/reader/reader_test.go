@@ -0,0 +1,227 @@
+package reader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/suite"
+)
+
+// Well known development account of the azurite emulator
+const (
+	azuriteAccountName = "devstoreaccount1"
+	azuriteAccountKey  = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw==" // #nosec G101 -- public emulator key
+)
+
+// ReaderTestSuite tests the reader against the azurite emulator
+type ReaderTestSuite struct {
+	suite.Suite
+	reader *Reader
+
+	pool            *dockertest.Pool
+	azurite         *dockertest.Resource
+	azuriteEndpoint string
+	bigContent      []byte
+}
+
+func TestReaderTestSuite(t *testing.T) {
+	suite.Run(t, new(ReaderTestSuite))
+}
+
+func (ts *ReaderTestSuite) SetupSuite() {
+	var err error
+	ts.pool, err = dockertest.NewPool("")
+	if err != nil {
+		ts.FailNow(fmt.Sprintf("could not construct docker pool: %v", err))
+	}
+	if err := ts.pool.Client.Ping(); err != nil {
+		ts.FailNow(fmt.Sprintf("could not connect to docker: %v", err))
+	}
+
+	ts.azurite, err = ts.pool.RunWithOptions(&dockertest.RunOptions{
+		Repository: "mcr.microsoft.com/azure-storage/azurite",
+		Tag:        "3.34.0",
+		Cmd:        []string{"azurite-blob", "--blobHost", "0.0.0.0", "--skipApiVersionCheck"},
+	}, func(config *docker.HostConfig) {
+		// set AutoRemove to true so that stopped container goes away by itself
+		config.AutoRemove = true
+		config.RestartPolicy = docker.RestartPolicy{
+			Name: "no",
+		}
+	})
+	if err != nil {
+		ts.FailNow(fmt.Sprintf("could not start azurite: %v", err))
+	}
+
+	ts.azuriteEndpoint = fmt.Sprintf("http://%s/%s/", ts.azurite.GetHostPort("10000/tcp"), azuriteAccountName)
+	credential, err := azblob.NewSharedKeyCredential(azuriteAccountName, azuriteAccountKey)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	client, err := azblob.NewClientWithSharedKeyCredential(ts.azuriteEndpoint, credential, nil)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	ts.pool.MaxWait = 2 * time.Minute
+	if err := ts.pool.Retry(func() error {
+		_, err := client.NewListContainersPager(nil).NextPage(context.TODO())
+
+		return err
+	}); err != nil {
+		_ = ts.pool.Purge(ts.azurite)
+		ts.FailNow(fmt.Sprintf("could not connect to azurite: %v", err))
+	}
+
+	ts.bigContent = append([]byte("This is a big file for testing seekable azure reader"), bytes.Repeat([]byte{'a'}, 6*1000*1000)...)
+	ts.bigContent = append(ts.bigContent, []byte("file is ending now")...)
+
+	for _, container := range []string{"archive-1", "archive-9", "archive-10", "other-1"} {
+		if _, err := client.CreateContainer(context.TODO(), container, nil); err != nil {
+			ts.FailNow(err.Error())
+		}
+	}
+	for _, blob := range []struct {
+		container, name string
+		content         []byte
+	}{
+		{"archive-1", "seekable_big_file.txt", ts.bigContent},
+		{"archive-9", "file.txt", []byte("file in container 9")},
+		{"archive-10", "file.txt", []byte("file in container 10")},
+		{"archive-10", "dir/file2.txt", []byte("file 2 in container 10")},
+		{"other-1", "other.txt", []byte("file with other prefix")},
+	} {
+		if _, err := client.UploadBuffer(context.TODO(), blob.container, blob.name, blob.content, nil); err != nil {
+			ts.FailNow(err.Error())
+		}
+	}
+
+	viper.Reset()
+	viper.Set("storage.test.azure", []map[string]any{
+		{
+			"endpoint":         ts.azuriteEndpoint,
+			"account_name":     azuriteAccountName,
+			"account_key":      azuriteAccountKey,
+			"container_prefix": "archive-",
+			"chunk_size":       "5mb",
+		},
+	})
+
+	ts.reader, err = NewReader(context.TODO(), "test")
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+}
+
+func (ts *ReaderTestSuite) TearDownSuite() {
+	viper.Reset()
+	if err := ts.pool.Purge(ts.azurite); err != nil {
+		ts.T().Logf("could not purge azurite: %v", err)
+	}
+}
+
+func (ts *ReaderTestSuite) location(container string) string {
+	return LocationScheme + azuriteAccountName + "/" + container
+}
+
+func (ts *ReaderTestSuite) TestNewFileReader() {
+	fileReader, err := ts.reader.NewFileReader(context.TODO(), ts.location("archive-10"), "dir/file2.txt")
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	content, err := io.ReadAll(fileReader)
+	ts.NoError(err)
+	ts.NoError(fileReader.Close())
+	ts.Equal("file 2 in container 10", string(content))
+}
+
+func (ts *ReaderTestSuite) TestNewFileReader_FileNotFound() {
+	_, err := ts.reader.NewFileReader(context.TODO(), ts.location("archive-9"), "not_exists.txt")
+	ts.ErrorIs(err, storageerrors.ErrorFileNotFoundInLocation)
+}
+
+func (ts *ReaderTestSuite) TestNewFileReader_InvalidLocation() {
+	_, err := ts.reader.NewFileReader(context.TODO(), "az://otheraccount/archive-1", "file.txt")
+	ts.ErrorIs(err, storageerrors.ErrorNoEndpointConfiguredForLocation)
+
+	_, err = ts.reader.NewFileReader(context.TODO(), "/archive-1", "file.txt")
+	ts.ErrorIs(err, storageerrors.ErrorInvalidLocation)
+}
+
+func (ts *ReaderTestSuite) TestGetFileSize() {
+	size, err := ts.reader.GetFileSize(context.TODO(), ts.location("archive-1"), "seekable_big_file.txt")
+	ts.NoError(err)
+	ts.Equal(int64(len(ts.bigContent)), size)
+
+	_, err = ts.reader.GetFileSize(context.TODO(), ts.location("archive-1"), "not_exists.txt")
+	ts.ErrorIs(err, storageerrors.ErrorFileNotFoundInLocation)
+}
+
+func (ts *ReaderTestSuite) TestFindFile() {
+	// Containers are checked in the order the writer fills them, not lexically
+	location, err := ts.reader.FindFile(context.TODO(), "file.txt")
+	ts.NoError(err)
+	ts.Equal(ts.location("archive-9"), location)
+
+	location, err = ts.reader.FindFile(context.TODO(), "dir/file2.txt")
+	ts.NoError(err)
+	ts.Equal(ts.location("archive-10"), location)
+}
+
+func (ts *ReaderTestSuite) TestFindFile_NotFound() {
+	_, err := ts.reader.FindFile(context.TODO(), "other.txt")
+	ts.ErrorIs(err, storageerrors.ErrorFileNotFoundInLocation)
+}
+
+func (ts *ReaderTestSuite) TestNewFileReadSeeker() {
+	fileSeekReader, err := ts.reader.NewFileReadSeeker(context.TODO(), ts.location("archive-1"), "seekable_big_file.txt")
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	defer fileSeekReader.Close()
+
+	start := make([]byte, 52)
+	_, err = io.ReadFull(fileSeekReader, start)
+	ts.NoError(err)
+	ts.Equal("This is a big file for testing seekable azure reader", string(start))
+
+	// The end of the file is in the second chunk
+	_, err = fileSeekReader.Seek(-18, io.SeekEnd)
+	ts.NoError(err)
+	end, err := io.ReadAll(fileSeekReader)
+	ts.NoError(err)
+	ts.Equal("file is ending now", string(end))
+}
+
+func (ts *ReaderTestSuite) TestNewFileReadSeeker_FileNotFound() {
+	_, err := ts.reader.NewFileReadSeeker(context.TODO(), ts.location("archive-1"), "not_exists.txt")
+	ts.ErrorIs(err, storageerrors.ErrorFileNotFoundInLocation)
+}
+
+func (ts *ReaderTestSuite) TestPing() {
+	ts.NoError(ts.reader.Ping(context.TODO()))
+}
+
+func (ts *ReaderTestSuite) TestPing_EndpointDown() {
+	reader := &Reader{endpoints: []*endpointConfig{{
+		AccountName:     azuriteAccountName,
+		AccountKey:      azuriteAccountKey,
+		ContainerPrefix: "archive-",
+		Endpoint:        "http://127.0.0.1:1/" + azuriteAccountName + "/",
+	}}}
+
+	// Requests to an unreachable endpoint are retried until the context is done
+	ctx, cancel := context.WithTimeout(context.TODO(), 2*time.Second)
+	defer cancel()
+	ts.Error(reader.Ping(ctx))
+}
@@ -0,0 +1,217 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/c2h5oh/datasize"
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/locationbroker"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	"github.com/spf13/viper"
+)
+
+type endpointConfig struct {
+	AccountKey      string `mapstructure:"account_key"` // #nosec G117 -- needs to be exported for unmarshalling
+	AccountName     string `mapstructure:"account_name"`
+	ChunkSize       string `mapstructure:"chunk_size"`
+	chunkSizeBytes  uint64
+	ContainerPrefix string `mapstructure:"container_prefix"`
+	Endpoint        string `mapstructure:"endpoint"`
+	MaxContainers   uint64 `mapstructure:"max_containers"`
+	MaxObjects      uint64 `mapstructure:"max_objects"`
+	MaxSize         string `mapstructure:"max_size"`
+	maxSizeBytes    uint64
+	WriterDisabled  bool `mapstructure:"writer_disabled"`
+
+	azureClient *azblob.Client // cached azure client for this endpoint, created by getAzureClient
+	clientLock  sync.Mutex     // guards the creation of the cached client
+}
+
+func loadConfig(backendName string) ([]*endpointConfig, error) {
+	var endpointConf []*endpointConfig
+
+	if err := viper.UnmarshalKey(
+		"storage."+backendName+".azure",
+		&endpointConf,
+		func(config *mapstructure.DecoderConfig) {
+			config.WeaklyTypedInput = true
+			config.ZeroFields = true
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	var enabledEndpoints []*endpointConfig
+	for _, e := range endpointConf {
+		if e.WriterDisabled {
+			continue
+		}
+		switch {
+		case e.AccountName == "":
+			return nil, errors.New("missing required parameter: account_name")
+		case e.AccountKey == "":
+			return nil, errors.New("missing required parameter: account_key")
+		case e.ContainerPrefix == "":
+			return nil, errors.New("missing required parameter: container_prefix")
+		default:
+		}
+
+		if e.Endpoint == "" {
+			e.Endpoint = fmt.Sprintf("https://%s.blob.core.windows.net/", e.AccountName)
+		}
+
+		e.chunkSizeBytes = 50 * datasize.MB.Bytes()
+		if e.ChunkSize != "" {
+			byteSize, err := datasize.ParseString(e.ChunkSize)
+			if err != nil {
+				return nil, errors.New("could not parse chunk_size as a valid data size")
+			}
+			if byteSize < 5*datasize.MB {
+				return nil, errors.New("chunk_size can not be smaller than 5mb")
+			}
+			if byteSize > 1*datasize.GB {
+				return nil, errors.New("chunk_size can not be bigger than 1gb")
+			}
+			e.chunkSizeBytes = byteSize.Bytes()
+		}
+		if e.MaxSize != "" {
+			byteSize, err := datasize.ParseString(e.MaxSize)
+			if err != nil {
+				return nil, errors.New("could not parse max_size as a valid data size")
+			}
+			e.maxSizeBytes = byteSize.Bytes()
+		}
+		if e.MaxContainers == 0 {
+			e.MaxContainers = 1
+		}
+		enabledEndpoints = append(enabledEndpoints, e)
+	}
+
+	return enabledEndpoints, nil
+}
+
+func (endpointConf *endpointConfig) getAzureClient() (*azblob.Client, error) {
+	endpointConf.clientLock.Lock()
+	defer endpointConf.clientLock.Unlock()
+
+	if endpointConf.azureClient != nil {
+		return endpointConf.azureClient, nil
+	}
+
+	credential, err := azblob.NewSharedKeyCredential(endpointConf.AccountName, endpointConf.AccountKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create azure credential for account: %s, due to: %v", endpointConf.AccountName, err)
+	}
+
+	client, err := azblob.NewClientWithSharedKeyCredential(endpointConf.Endpoint, credential, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create azure client to endpoint: %s, due to: %v", endpointConf.Endpoint, err)
+	}
+	endpointConf.azureClient = client
+
+	return endpointConf.azureClient, nil
+}
+
+func (endpointConf *endpointConfig) listContainersWithPrefix(ctx context.Context, client *azblob.Client) ([]string, error) {
+	var containersWithPrefix []string
+	pager := client.NewListContainersPager(&azblob.ListContainersOptions{Prefix: &endpointConf.ContainerPrefix})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list containers at endpoint: %s, due to %v", endpointConf.Endpoint, err)
+		}
+		for _, container := range page.ContainerItems {
+			if container.Name != nil {
+				containersWithPrefix = append(containersWithPrefix, *container.Name)
+			}
+		}
+	}
+
+	return containersWithPrefix, nil
+}
+
+func (endpointConf *endpointConfig) location(container string) string {
+	return LocationScheme + endpointConf.AccountName + "/" + container
+}
+
+func (endpointConf *endpointConfig) findActiveContainer(ctx context.Context, backendName string, locationBroker locationbroker.LocationBroker) (string, error) {
+	client, err := endpointConf.getAzureClient()
+	if err != nil {
+		return "", err
+	}
+
+	containersWithPrefix, err := endpointConf.listContainersWithPrefix(ctx, client)
+	if err != nil {
+		return "", err
+	}
+
+	if len(containersWithPrefix) == 0 {
+		activeContainer := endpointConf.ContainerPrefix + "1"
+		if _, err := client.CreateContainer(ctx, activeContainer, nil); err != nil {
+			return "", fmt.Errorf("failed to create azure container: %s at endpoint: %s, due to %v", activeContainer, endpointConf.Endpoint, err)
+		}
+
+		return activeContainer, nil
+	}
+
+	sortContainersNumerically(containersWithPrefix, endpointConf.ContainerPrefix)
+
+	// find first container with available object count and size
+	for _, container := range containersWithPrefix {
+		loc := endpointConf.location(container)
+		count, err := locationBroker.GetObjectCount(ctx, backendName, loc)
+		if err != nil {
+			return "", fmt.Errorf("failed to get object count of location %s, due to %v", loc, err)
+		}
+		if count >= endpointConf.MaxObjects && endpointConf.MaxObjects > 0 {
+			continue
+		}
+
+		size, err := locationBroker.GetSize(ctx, backendName, loc)
+		if err != nil {
+			return "", fmt.Errorf("failed to get size of location %s, due to %v", loc, err)
+		}
+		if size >= endpointConf.maxSizeBytes && endpointConf.maxSizeBytes > 0 {
+			continue
+		}
+
+		return container, nil
+	}
+
+	// All created containers are full, check if we should create new one after latest increment
+	if uint64(len(containersWithPrefix)) >= endpointConf.MaxContainers && endpointConf.MaxContainers > 0 {
+		return "", storageerrors.ErrorNoFreeBucket
+	}
+
+	currentInc, err := strconv.Atoi(strings.TrimPrefix(containersWithPrefix[len(containersWithPrefix)-1], endpointConf.ContainerPrefix))
+	if err != nil {
+		return "", fmt.Errorf("failed to generate next container increment after container %s, due to %v", containersWithPrefix[len(containersWithPrefix)-1], err)
+	}
+	activeContainer := fmt.Sprintf("%s%d", endpointConf.ContainerPrefix, currentInc+1)
+	if _, err := client.CreateContainer(ctx, activeContainer, nil); err != nil {
+		return "", fmt.Errorf("failed to create azure container: %s at endpoint: %s, due to %v", activeContainer, endpointConf.Endpoint, err)
+	}
+
+	return activeContainer, nil
+}
+
+// sortContainersNumerically sorts containers in place by the integer suffix that follows prefix so `<prefix>10` comes
+// after `<prefix>9`, if a container name can't be parsed as `<prefix><number>` we fall back to lexical ordering
+func sortContainersNumerically(containers []string, prefix string) {
+	slices.SortFunc(containers, func(a, b string) int {
+		aInc, aErr := strconv.Atoi(strings.TrimPrefix(a, prefix))
+		bInc, bErr := strconv.Atoi(strings.TrimPrefix(b, prefix))
+		if aErr != nil || bErr != nil {
+			return strings.Compare(a, b)
+		}
+
+		return aInc - bInc
+	})
+}
@@ -0,0 +1,25 @@
+package writer
+
+import (
+	"context"
+	"fmt"
+)
+
+// RemoveFile removes a blob from a container
+func (writer *Writer) RemoveFile(ctx context.Context, location, filePath string) error {
+	accountName, container, err := parseLocation(location)
+	if err != nil {
+		return err
+	}
+
+	client, err := getAzureClientForAccount(writer.configuredEndpoints, accountName)
+	if err != nil {
+		return err
+	}
+
+	if _, err := client.DeleteBlob(ctx, container, filePath, nil); err != nil {
+		return fmt.Errorf("failed to delete blob: %s, container: %s, account: %s, due to: %v", filePath, container, accountName, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+)
+
+func (writer *Writer) WriteFile(ctx context.Context, filePath string, fileContent io.Reader) (string, error) {
+	// Find endpoint / container that is to be used for writing
+	writer.Lock()
+	if writer.activeEndpoint == nil {
+		writer.Unlock()
+
+		return "", storageerrors.ErrorNoValidLocations
+	}
+	activeContainer, err := writer.activeEndpoint.findActiveContainer(ctx, writer.backendName, writer.locationBroker)
+	if err != nil && !errors.Is(err, storageerrors.ErrorNoFreeBucket) {
+		writer.Unlock()
+
+		return "", err
+	}
+	// Current active endpoint no longer has any free containers, roll over to next endpoint
+	if activeContainer == "" {
+		for _, endpointConf := range writer.configuredEndpoints {
+			// We dont need to evaluate the currently active endpoint as we know it doesnt have any active containers now
+			if endpointConf == writer.activeEndpoint {
+				continue
+			}
+
+			activeContainer, err = endpointConf.findActiveContainer(ctx, writer.backendName, writer.locationBroker)
+			if err != nil {
+				if errors.Is(err, storageerrors.ErrorNoFreeBucket) {
+					continue
+				}
+				writer.Unlock()
+
+				return "", err
+			}
+			writer.activeEndpoint = endpointConf
+
+			break
+		}
+	}
+	activeEndpoint := writer.activeEndpoint
+	writer.Unlock()
+
+	if activeContainer == "" {
+		return "", storageerrors.ErrorNoFreeBucket
+	}
+
+	client, err := activeEndpoint.getAzureClient()
+	if err != nil {
+		return "", err
+	}
+
+	// Blocks are only committed when the whole stream has been uploaded, so a failed upload leaves no partial blob
+	_, err = client.UploadStream(ctx, activeContainer, filePath, fileContent, &azblob.UploadStreamOptions{
+		// Type conversation safe as chunkSizeBytes checked to be between 5mb and 1gb (in bytes)
+		//nolint:gosec // disable G115
+		BlockSize: int64(activeEndpoint.chunkSizeBytes),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload blob: %s, container: %s, endpoint: %s, due to: %v", filePath, activeContainer, activeEndpoint.Endpoint, err)
+	}
+
+	return activeEndpoint.location(activeContainer), nil
+}
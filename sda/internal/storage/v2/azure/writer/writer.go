@@ -0,0 +1,137 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/locationbroker"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	log "github.com/sirupsen/logrus"
+)
+
+// LocationScheme is the prefix of all locations served by the azure storage implementation
+const LocationScheme = "az://"
+
+type Writer struct {
+	backendName         string
+	configuredEndpoints []*endpointConfig
+	activeEndpoint      *endpointConfig
+
+	locationBroker locationbroker.LocationBroker
+
+	sync.Mutex
+}
+
+// NewWriter initiates a storage backend
+func NewWriter(ctx context.Context, backendName string, locationBroker locationbroker.LocationBroker) (*Writer, error) {
+	endPointConf, err := loadConfig(backendName)
+	if err != nil {
+		return nil, err
+	}
+
+	if locationBroker == nil {
+		return nil, errors.New("locationBroker is required")
+	}
+
+	writer := &Writer{
+		backendName:    backendName,
+		locationBroker: locationBroker,
+	}
+	writer.locationBroker.RegisterSizeAndCountFinderFunc(backendName, func(location string) bool {
+		return strings.HasPrefix(location, LocationScheme)
+	}, findSizeAndObjectCountOfLocation(endPointConf))
+
+	// Verify endpointConfig connections
+	for _, e := range endPointConf {
+		_, err := e.findActiveContainer(ctx, backendName, writer.locationBroker)
+		if err != nil {
+			if errors.Is(err, storageerrors.ErrorNoFreeBucket) {
+				log.Warningf("azure: %s has no available container", e.Endpoint)
+				writer.configuredEndpoints = append(writer.configuredEndpoints, e)
+
+				continue
+			}
+
+			return nil, err
+		}
+		writer.configuredEndpoints = append(writer.configuredEndpoints, e)
+		// Set first active endpoint as current
+		if writer.activeEndpoint == nil {
+			writer.activeEndpoint = e
+		}
+	}
+
+	if len(writer.configuredEndpoints) == 0 {
+		return nil, storageerrors.ErrorNoValidLocations
+	}
+
+	return writer, nil
+}
+
+func getAzureClientForAccount(configuredEndpoints []*endpointConfig, accountName string) (*azblob.Client, error) {
+	for _, e := range configuredEndpoints {
+		if e.AccountName != accountName {
+			continue
+		}
+
+		return e.getAzureClient()
+	}
+
+	return nil, storageerrors.ErrorNoEndpointConfiguredForLocation
+}
+
+// parseLocation attempts to parse a location to an azure storage account, and a container
+// expected format of location is "az://${ACCOUNT_NAME}/${CONTAINER}"
+func parseLocation(location string) (string, string, error) {
+	if !strings.HasPrefix(location, LocationScheme) {
+		return "", "", storageerrors.ErrorInvalidLocation
+	}
+	accountName, container, found := strings.Cut(strings.TrimPrefix(location, LocationScheme), "/")
+	if !found || accountName == "" || container == "" || strings.Contains(container, "/") {
+		return "", "", storageerrors.ErrorInvalidLocation
+	}
+
+	return accountName, container, nil
+}
+
+// findSizeAndObjectCountOfLocation find the total size and total amount of objects in an azure container if we do
+// not store this information in the database
+func findSizeAndObjectCountOfLocation(configuredEndpoints []*endpointConfig) func(ctx context.Context, location string) (uint64, uint64, error) {
+	return func(ctx context.Context, location string) (uint64, uint64, error) {
+		accountName, container, err := parseLocation(location)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		client, err := getAzureClientForAccount(configuredEndpoints, accountName)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		var totalSize uint64
+		var totalObjects uint64
+
+		pager := client.NewListBlobsFlatPager(container, nil)
+		for pager.More() {
+			page, err := pager.NextPage(ctx)
+			if err != nil {
+				return 0, 0, err
+			}
+			if page.Segment == nil {
+				continue
+			}
+
+			for _, blob := range page.Segment.BlobItems {
+				totalObjects++
+				if blob.Properties != nil && blob.Properties.ContentLength != nil && *blob.Properties.ContentLength > 0 {
+					totalSize += uint64(*blob.Properties.ContentLength) // #nosec G115 -- ContentLength has been checked to be bigger than 0
+				}
+			}
+		}
+
+		return totalSize, totalObjects, nil
+	}
+}
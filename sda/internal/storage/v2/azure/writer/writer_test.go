@@ -0,0 +1,347 @@
+package writer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+// Well known development account of the azurite emulator
+const (
+	azuriteAccountName = "devstoreaccount1"
+	azuriteAccountKey  = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw==" // #nosec G101 -- public emulator key
+)
+
+func TestParseLocation(t *testing.T) {
+	account, container, err := parseLocation("az://sdaaccount/archive-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "sdaaccount", account)
+	assert.Equal(t, "archive-1", container)
+
+	for _, location := range []string{"", "az://", "az://sdaaccount", "az://sdaaccount/", "/archive", "gs://archive", "az://sdaaccount/archive/sub"} {
+		_, _, err := parseLocation(location)
+		assert.ErrorIs(t, err, storageerrors.ErrorInvalidLocation, location)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	viper.Set("storage.archive.azure", []map[string]any{
+		{
+			"account_name":     "sdaaccount",
+			"account_key":      "a2V5",
+			"container_prefix": "archive-",
+			"max_containers":   3,
+		},
+	})
+
+	endpoints, err := loadConfig("archive")
+	assert.NoError(t, err)
+	assert.Len(t, endpoints, 1)
+	assert.Equal(t, "https://sdaaccount.blob.core.windows.net/", endpoints[0].Endpoint)
+	assert.Equal(t, uint64(3), endpoints[0].MaxContainers)
+	assert.Equal(t, "az://sdaaccount/archive-2", endpoints[0].location("archive-2"))
+}
+
+func TestLoadConfig_InvalidChunkSize(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	viper.Set("storage.archive.azure", []map[string]any{
+		{
+			"account_name":     "sdaaccount",
+			"account_key":      "a2V5",
+			"container_prefix": "archive-",
+			"chunk_size":       "1MB",
+		},
+	})
+
+	_, err := loadConfig("archive")
+	assert.EqualError(t, err, "chunk_size can not be smaller than 5mb")
+}
+
+func TestSortContainersNumerically(t *testing.T) {
+	containers := []string{"archive-1", "archive-10", "archive-2"}
+	sortContainersNumerically(containers, "archive-")
+	assert.Equal(t, []string{"archive-1", "archive-2", "archive-10"}, containers)
+}
+
+type mockLocationBroker struct {
+	mock.Mock
+}
+
+func (m *mockLocationBroker) GetObjectCount(_ context.Context, _, location string) (uint64, error) {
+	args := m.Called(location)
+	count := args.Int(0)
+	if count < 0 {
+		count = 0
+	}
+
+	return uint64(count), args.Error(1)
+}
+
+func (m *mockLocationBroker) GetSize(_ context.Context, _, location string) (uint64, error) {
+	args := m.Called(location)
+	size := args.Int(0)
+	if size < 0 {
+		size = 0
+	}
+
+	return uint64(size), args.Error(1)
+}
+
+func (m *mockLocationBroker) RegisterSizeAndCountFinderFunc(_ string, _ func(string) bool, _ func(context.Context, string) (uint64, uint64, error)) {
+	m.Called()
+}
+
+// WriterTestSuite tests the writer against the azurite emulator
+type WriterTestSuite struct {
+	suite.Suite
+	writer *Writer
+
+	pool            *dockertest.Pool
+	azurite         *dockertest.Resource
+	azuriteEndpoint string
+	azureClient     *azblob.Client
+
+	locationBrokerMock *mockLocationBroker
+}
+
+func TestWriterTestSuite(t *testing.T) {
+	suite.Run(t, new(WriterTestSuite))
+}
+
+func (ts *WriterTestSuite) SetupSuite() {
+	var err error
+	ts.pool, err = dockertest.NewPool("")
+	if err != nil {
+		ts.FailNow(fmt.Sprintf("could not construct docker pool: %v", err))
+	}
+	if err := ts.pool.Client.Ping(); err != nil {
+		ts.FailNow(fmt.Sprintf("could not connect to docker: %v", err))
+	}
+
+	ts.azurite, err = ts.pool.RunWithOptions(&dockertest.RunOptions{
+		Repository: "mcr.microsoft.com/azure-storage/azurite",
+		Tag:        "3.34.0",
+		Cmd:        []string{"azurite-blob", "--blobHost", "0.0.0.0", "--skipApiVersionCheck"},
+	}, func(config *docker.HostConfig) {
+		// set AutoRemove to true so that stopped container goes away by itself
+		config.AutoRemove = true
+		config.RestartPolicy = docker.RestartPolicy{
+			Name: "no",
+		}
+	})
+	if err != nil {
+		ts.FailNow(fmt.Sprintf("could not start azurite: %v", err))
+	}
+
+	ts.azuriteEndpoint = fmt.Sprintf("http://%s/%s/", ts.azurite.GetHostPort("10000/tcp"), azuriteAccountName)
+	credential, err := azblob.NewSharedKeyCredential(azuriteAccountName, azuriteAccountKey)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.azureClient, err = azblob.NewClientWithSharedKeyCredential(ts.azuriteEndpoint, credential, nil)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	ts.pool.MaxWait = 2 * time.Minute
+	if err := ts.pool.Retry(func() error {
+		_, err := ts.azureClient.NewListContainersPager(nil).NextPage(context.TODO())
+
+		return err
+	}); err != nil {
+		_ = ts.pool.Purge(ts.azurite)
+		ts.FailNow(fmt.Sprintf("could not connect to azurite: %v", err))
+	}
+}
+
+func (ts *WriterTestSuite) TearDownSuite() {
+	if err := ts.pool.Purge(ts.azurite); err != nil {
+		ts.T().Logf("could not purge azurite: %v", err)
+	}
+}
+
+func (ts *WriterTestSuite) SetupTest() {
+	// Start every test from an empty account
+	pager := ts.azureClient.NewListContainersPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(context.TODO())
+		if err != nil {
+			ts.FailNow(err.Error())
+		}
+		for _, container := range page.ContainerItems {
+			if _, err := ts.azureClient.DeleteContainer(context.TODO(), *container.Name, nil); err != nil {
+				ts.FailNow(err.Error())
+			}
+		}
+	}
+	// Containers of other prefixes are to be ignored
+	ts.createContainer("other-1")
+
+	viper.Reset()
+	viper.Set("storage.test.azure", []map[string]any{
+		{
+			"endpoint":         ts.azuriteEndpoint,
+			"account_name":     azuriteAccountName,
+			"account_key":      azuriteAccountKey,
+			"container_prefix": "archive-",
+			"max_objects":      10,
+			"max_containers":   2,
+		},
+	})
+
+	ts.locationBrokerMock = &mockLocationBroker{}
+	ts.locationBrokerMock.On("RegisterSizeAndCountFinderFunc").Return().Once()
+	// The first container is created as there is none
+	var err error
+	ts.writer, err = NewWriter(context.TODO(), "test", ts.locationBrokerMock)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+}
+
+func (ts *WriterTestSuite) TearDownTest() {
+	viper.Reset()
+}
+
+func (ts *WriterTestSuite) createContainer(container string) {
+	if _, err := ts.azureClient.CreateContainer(context.TODO(), container, nil); err != nil {
+		ts.FailNow(err.Error())
+	}
+}
+
+func (ts *WriterTestSuite) uploadBlob(container, filePath, content string) {
+	if _, err := ts.azureClient.UploadBuffer(context.TODO(), container, filePath, []byte(content), nil); err != nil {
+		ts.FailNow(err.Error())
+	}
+}
+
+func (ts *WriterTestSuite) blobContent(container, filePath string) string {
+	resp, err := ts.azureClient.DownloadStream(context.TODO(), container, filePath, nil)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	return string(content)
+}
+
+func (ts *WriterTestSuite) blobNames(container, prefix string) []string {
+	var names []string
+	pager := ts.azureClient.NewListBlobsFlatPager(container, &azblob.ListBlobsFlatOptions{Prefix: &prefix})
+	for pager.More() {
+		page, err := pager.NextPage(context.TODO())
+		if err != nil {
+			ts.FailNow(err.Error())
+		}
+		for _, blob := range page.Segment.BlobItems {
+			names = append(names, *blob.Name)
+		}
+	}
+
+	return names
+}
+
+func (ts *WriterTestSuite) TestWriteFile() {
+	location := "az://" + azuriteAccountName + "/archive-1"
+	ts.locationBrokerMock.On("GetObjectCount", location).Return(0, nil).Once()
+	ts.locationBrokerMock.On("GetSize", location).Return(0, nil).Once()
+
+	writtenLocation, err := ts.writer.WriteFile(context.TODO(), "dir/test_file_1.txt", strings.NewReader("test file 1"))
+	ts.NoError(err)
+	ts.Equal(location, writtenLocation)
+	ts.Equal("test file 1", ts.blobContent("archive-1", "dir/test_file_1.txt"))
+}
+
+func (ts *WriterTestSuite) TestWriteFile_FirstContainerFull() {
+	ts.locationBrokerMock.On("GetObjectCount", "az://"+azuriteAccountName+"/archive-1").Return(10, nil).Once()
+
+	location, err := ts.writer.WriteFile(context.TODO(), "test_file_1.txt", strings.NewReader("test file 1"))
+	ts.NoError(err)
+	ts.Equal("az://"+azuriteAccountName+"/archive-2", location)
+	ts.Equal("test file 1", ts.blobContent("archive-2", "test_file_1.txt"))
+}
+
+func (ts *WriterTestSuite) TestWriteFile_NoFreeContainer() {
+	ts.createContainer("archive-2")
+	ts.locationBrokerMock.On("GetObjectCount", "az://"+azuriteAccountName+"/archive-1").Return(10, nil).Once()
+	ts.locationBrokerMock.On("GetObjectCount", "az://"+azuriteAccountName+"/archive-2").Return(10, nil).Once()
+
+	_, err := ts.writer.WriteFile(context.TODO(), "test_file_1.txt", strings.NewReader("test file 1"))
+	ts.ErrorIs(err, storageerrors.ErrorNoFreeBucket)
+}
+
+func (ts *WriterTestSuite) TestWriteAndRemoveFile_Concurrent() {
+	location := "az://" + azuriteAccountName + "/archive-1"
+	ts.locationBrokerMock.On("GetObjectCount", location).Return(0, nil)
+	ts.locationBrokerMock.On("GetSize", location).Return(0, nil)
+	for i := range 5 {
+		ts.uploadBlob("archive-1", fmt.Sprintf("file_to_be_removed_%d", i), "content")
+	}
+
+	// The endpoint client is created on first use, which is to be safe for concurrent use
+	for _, e := range ts.writer.configuredEndpoints {
+		e.azureClient = nil
+	}
+	wg := sync.WaitGroup{}
+	for i := range 5 {
+		wg.Go(func() {
+			_, err := ts.writer.WriteFile(context.TODO(), fmt.Sprintf("test_file_%d.txt", i), strings.NewReader("content"))
+			ts.NoError(err)
+		})
+		wg.Go(func() {
+			ts.NoError(ts.writer.RemoveFile(context.TODO(), location, fmt.Sprintf("file_to_be_removed_%d", i)))
+		})
+	}
+	wg.Wait()
+
+	ts.Len(ts.blobNames("archive-1", "test_file_"), 5)
+	ts.Empty(ts.blobNames("archive-1", "file_to_be_removed_"))
+}
+
+func (ts *WriterTestSuite) TestRemoveFile() {
+	ts.uploadBlob("archive-1", "file_to_be_removed", "file to be removed content")
+
+	ts.NoError(ts.writer.RemoveFile(context.TODO(), "az://"+azuriteAccountName+"/archive-1", "file_to_be_removed"))
+	ts.Empty(ts.blobNames("archive-1", "file_to_be_removed"), "file to be removed still exists")
+}
+
+func (ts *WriterTestSuite) TestRemoveFile_InvalidLocation() {
+	err := ts.writer.RemoveFile(context.TODO(), "az://otheraccount/archive-1", "file_to_be_removed")
+	ts.ErrorIs(err, storageerrors.ErrorNoEndpointConfiguredForLocation)
+
+	err = ts.writer.RemoveFile(context.TODO(), "gs://archive-1", "file_to_be_removed")
+	ts.ErrorIs(err, storageerrors.ErrorInvalidLocation)
+}
+
+func (ts *WriterTestSuite) TestFindSizeAndObjectCountOfLocation() {
+	ts.uploadBlob("archive-1", "file_1", "12345")
+	ts.uploadBlob("archive-1", "file_2", "1234567890")
+
+	size, count, err := findSizeAndObjectCountOfLocation(ts.writer.configuredEndpoints)(context.TODO(), "az://"+azuriteAccountName+"/archive-1")
+	ts.NoError(err)
+	ts.Equal(uint64(15), size)
+	ts.Equal(uint64(2), count)
+}
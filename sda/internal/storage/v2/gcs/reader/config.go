@@ -0,0 +1,101 @@
+package reader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"github.com/c2h5oh/datasize"
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/spf13/viper"
+	"google.golang.org/api/option"
+)
+
+type endpointConfig struct {
+	BucketPrefix          string `mapstructure:"bucket_prefix"`
+	ChunkSize             string `mapstructure:"chunk_size"`
+	chunkSizeBytes        uint64
+	CredentialsFile       string `mapstructure:"credentials_file"`
+	DisableAuthentication bool   `mapstructure:"disable_authentication"`
+	Endpoint              string `mapstructure:"endpoint"`
+	ProjectID             string `mapstructure:"project_id"`
+
+	gcsClient  *storage.Client // cached gcs client for this endpoint, created by getGCSClient
+	clientLock sync.Mutex      // guards the creation of the cached client
+}
+
+func loadConfig(backendName string) ([]*endpointConfig, error) {
+	var endpointConf []*endpointConfig
+
+	if err := viper.UnmarshalKey(
+		"storage."+backendName+".gcs",
+		&endpointConf,
+		func(config *mapstructure.DecoderConfig) {
+			config.WeaklyTypedInput = true
+			config.ZeroFields = true
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	for _, e := range endpointConf {
+		switch {
+		case e.BucketPrefix == "":
+			return nil, errors.New("missing required parameter: bucket_prefix")
+		case e.ProjectID == "":
+			return nil, errors.New("missing required parameter: project_id")
+		default:
+		}
+
+		e.chunkSizeBytes = 50 * datasize.MB.Bytes()
+		if e.ChunkSize != "" {
+			byteSize, err := datasize.ParseString(e.ChunkSize)
+			if err != nil {
+				return nil, errors.New("could not parse chunk_size as a valid data size")
+			}
+			if byteSize < 5*datasize.MB {
+				return nil, errors.New("chunk_size can not be smaller than 5mb")
+			}
+			if byteSize > 1*datasize.GB {
+				return nil, errors.New("chunk_size can not be bigger than 1gb")
+			}
+			e.chunkSizeBytes = byteSize.Bytes()
+		}
+	}
+
+	return endpointConf, nil
+}
+
+func (endpointConf *endpointConfig) getGCSClient(ctx context.Context) (*storage.Client, error) {
+	endpointConf.clientLock.Lock()
+	defer endpointConf.clientLock.Unlock()
+
+	if endpointConf.gcsClient != nil {
+		return endpointConf.gcsClient, nil
+	}
+
+	var opts []option.ClientOption
+	if endpointConf.Endpoint != "" {
+		// A custom endpoint, eg an emulator, is only expected to support the JSON API
+		opts = append(opts, option.WithEndpoint(strings.TrimSuffix(endpointConf.Endpoint, "/")+"/storage/v1/"), storage.WithJSONReads())
+	}
+	switch {
+	case endpointConf.DisableAuthentication:
+		opts = append(opts, option.WithoutAuthentication())
+	case endpointConf.CredentialsFile != "":
+		opts = append(opts, option.WithCredentialsFile(endpointConf.CredentialsFile))
+	default:
+		// Application default credentials are used
+	}
+
+	client, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gcs client, due to: %v", err)
+	}
+	endpointConf.gcsClient = client
+
+	return endpointConf.gcsClient, nil
+}
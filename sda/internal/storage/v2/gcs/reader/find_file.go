@@ -0,0 +1,68 @@
+package reader
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	"google.golang.org/api/iterator"
+)
+
+func (reader *Reader) FindFile(ctx context.Context, filePath string) (string, error) {
+	for _, endpointConf := range reader.endpoints {
+		client, err := endpointConf.getGCSClient(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		var bucketsWithPrefix []string
+		buckets := client.Buckets(ctx, endpointConf.ProjectID)
+		buckets.Prefix = endpointConf.BucketPrefix
+		for {
+			bucketAttrs, err := buckets.Next()
+			if errors.Is(err, iterator.Done) {
+				break
+			}
+			if err != nil {
+				return "", err
+			}
+			// Not all emulators filter by prefix
+			if strings.HasPrefix(bucketAttrs.Name, endpointConf.BucketPrefix) {
+				bucketsWithPrefix = append(bucketsWithPrefix, bucketAttrs.Name)
+			}
+		}
+		// Checked in the order the writer fills them
+		sortBucketsNumerically(bucketsWithPrefix, endpointConf.BucketPrefix)
+
+		for _, bucket := range bucketsWithPrefix {
+			if _, err := getFileSize(ctx, client, bucket, filePath); err != nil {
+				if errors.Is(err, storageerrors.ErrorFileNotFoundInLocation) {
+					continue
+				}
+
+				return "", err
+			}
+
+			return LocationScheme + bucket, nil
+		}
+	}
+
+	return "", storageerrors.ErrorFileNotFoundInLocation
+}
+
+// sortBucketsNumerically sorts buckets in place by the integer suffix that follows prefix so `<prefix>10` comes
+// after `<prefix>9`, if a bucket name can't be parsed as `<prefix><number>` we fall back to lexical ordering
+func sortBucketsNumerically(buckets []string, prefix string) {
+	slices.SortFunc(buckets, func(a, b string) int {
+		aInc, aErr := strconv.Atoi(strings.TrimPrefix(a, prefix))
+		bInc, bErr := strconv.Atoi(strings.TrimPrefix(b, prefix))
+		if aErr != nil || bErr != nil {
+			return strings.Compare(a, b)
+		}
+
+		return aInc - bInc
+	})
+}
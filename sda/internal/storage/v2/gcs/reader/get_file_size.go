@@ -0,0 +1,38 @@
+package reader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/storage"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+)
+
+// GetFileSize returns the size of a specific object
+func (reader *Reader) GetFileSize(ctx context.Context, location, filePath string) (int64, error) {
+	bucket, err := parseLocation(location)
+	if err != nil {
+		return 0, err
+	}
+
+	client, _, err := reader.getGCSClientForBucket(ctx, bucket)
+	if err != nil {
+		return 0, err
+	}
+
+	return getFileSize(ctx, client, bucket, filePath)
+}
+
+func getFileSize(ctx context.Context, client *storage.Client, bucket, filePath string) (int64, error) {
+	attrs, err := client.Bucket(bucket).Object(filePath).Attrs(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return 0, storageerrors.ErrorFileNotFoundInLocation
+		}
+
+		return 0, fmt.Errorf("failed to get attributes of object: %s, bucket: %s, due to: %v", filePath, bucket, err)
+	}
+
+	return attrs.Size, nil
+}
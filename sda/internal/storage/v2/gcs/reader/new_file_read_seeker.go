@@ -0,0 +1,39 @@
+package reader
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/seekablereader"
+)
+
+func (reader *Reader) NewFileReadSeeker(ctx context.Context, location, filePath string) (io.ReadSeekCloser, error) {
+	bucket, err := parseLocation(location)
+	if err != nil {
+		return nil, err
+	}
+
+	client, endpointConf, err := reader.getGCSClientForBucket(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	objectSize, err := getFileSize(ctx, client, bucket, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	object := client.Bucket(bucket).Object(filePath)
+
+	// Type conversation safe as chunkSizeBytes checked to be between 5mb and 1gb (in bytes)
+	//nolint:gosec // disable G115
+	return seekablereader.New(ctx, objectSize, int64(endpointConf.chunkSizeBytes), func(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+		r, err := object.NewRangeReader(ctx, offset, length)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get object: %s, bucket: %s, due to: %v", filePath, bucket, err)
+		}
+
+		return r, nil
+	}), nil
+}
@@ -0,0 +1,34 @@
+package reader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"cloud.google.com/go/storage"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+)
+
+func (reader *Reader) NewFileReader(ctx context.Context, location, filePath string) (io.ReadCloser, error) {
+	bucket, err := parseLocation(location)
+	if err != nil {
+		return nil, err
+	}
+
+	client, _, err := reader.getGCSClientForBucket(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := client.Bucket(bucket).Object(filePath).NewReader(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, storageerrors.ErrorFileNotFoundInLocation
+		}
+
+		return nil, fmt.Errorf("failed to get object: %s, bucket: %s, due to: %v", filePath, bucket, err)
+	}
+
+	return r, nil
+}
@@ -0,0 +1,88 @@
+package reader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	"google.golang.org/api/iterator"
+)
+
+// LocationScheme is the prefix of all locations served by the gcs storage implementation
+const LocationScheme = "gs://"
+
+type Reader struct {
+	endpoints []*endpointConfig
+}
+
+func NewReader(ctx context.Context, backendName string) (*Reader, error) {
+	endPoints, err := loadConfig(backendName)
+	if err != nil {
+		return nil, err
+	}
+
+	backend := &Reader{
+		endpoints: endPoints,
+	}
+	// Verify endpoint connections
+	if err := backend.Ping(ctx); err != nil {
+		return nil, err
+	}
+	if len(backend.endpoints) == 0 {
+		return nil, storageerrors.ErrorNoValidLocations
+	}
+
+	return backend, nil
+}
+
+// Ping verifies all configured gcs endpoints are reachable by listing the buckets of the project
+func (reader *Reader) Ping(ctx context.Context) error {
+	for _, e := range reader.endpoints {
+		client, err := e.getGCSClient(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to ping gcs project: %s, due to: %v", e.ProjectID, err)
+		}
+
+		buckets := client.Buckets(ctx, e.ProjectID)
+		buckets.Prefix = e.BucketPrefix
+		if _, err := buckets.Next(); err != nil && !errors.Is(err, iterator.Done) {
+			return fmt.Errorf("failed to ping gcs project: %s, due to: %v", e.ProjectID, err)
+		}
+	}
+
+	return nil
+}
+
+// getGCSClientForBucket returns the client of the endpoint whose bucket prefix matches the bucket
+func (reader *Reader) getGCSClientForBucket(ctx context.Context, bucket string) (*storage.Client, *endpointConfig, error) {
+	for _, e := range reader.endpoints {
+		if !strings.HasPrefix(bucket, e.BucketPrefix) {
+			continue
+		}
+		client, err := e.getGCSClient(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return client, e, nil
+	}
+
+	return nil, nil, storageerrors.ErrorNoEndpointConfiguredForLocation
+}
+
+// parseLocation attempts to parse a location to a gcs bucket
+// expected format of location is "gs://${BUCKET}"
+func parseLocation(location string) (string, error) {
+	if !strings.HasPrefix(location, LocationScheme) {
+		return "", storageerrors.ErrorInvalidLocation
+	}
+	bucket := strings.TrimPrefix(location, LocationScheme)
+	if bucket == "" || strings.Contains(bucket, "/") {
+		return "", storageerrors.ErrorInvalidLocation
+	}
+
+	return bucket, nil
+}
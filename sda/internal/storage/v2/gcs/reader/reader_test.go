@@ -0,0 +1,172 @@
+package reader
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/fsouza/fake-gcs-server/fakestorage"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/suite"
+)
+
+// ReaderTestSuite tests the reader against fake-gcs-server
+type ReaderTestSuite struct {
+	suite.Suite
+	reader *Reader
+
+	gcsServer  *fakestorage.Server
+	bigContent []byte
+}
+
+func TestReaderTestSuite(t *testing.T) {
+	suite.Run(t, new(ReaderTestSuite))
+}
+
+func (ts *ReaderTestSuite) SetupSuite() {
+	ts.bigContent = append([]byte("This is a big file for testing seekable gcs reader"), bytes.Repeat([]byte{'a'}, 6*1000*1000)...)
+	ts.bigContent = append(ts.bigContent, []byte("file is ending now")...)
+
+	object := func(bucket, name string, content []byte) fakestorage.Object {
+		return fakestorage.Object{ObjectAttrs: fakestorage.ObjectAttrs{BucketName: bucket, Name: name}, Content: content}
+	}
+
+	var err error
+	ts.gcsServer, err = fakestorage.NewServerWithOptions(fakestorage.Options{
+		Scheme: "http",
+		Host:   "127.0.0.1",
+		InitialObjects: []fakestorage.Object{
+			object("archive-1", "seekable_big_file.txt", ts.bigContent),
+			object("archive-9", "file.txt", []byte("file in bucket 9")),
+			object("archive-10", "file.txt", []byte("file in bucket 10")),
+			object("archive-10", "dir/file2.txt", []byte("file 2 in bucket 10")),
+			object("other-1", "other.txt", []byte("file with other prefix")),
+		},
+	})
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	viper.Reset()
+	viper.Set("storage.test.gcs", []map[string]any{
+		{
+			"endpoint":               ts.gcsServer.URL(),
+			"disable_authentication": true,
+			"project_id":             "sda",
+			"bucket_prefix":          "archive-",
+			"chunk_size":             "5mb",
+		},
+	})
+
+	ts.reader, err = NewReader(context.TODO(), "test")
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+}
+
+func (ts *ReaderTestSuite) TearDownSuite() {
+	ts.gcsServer.Stop()
+	viper.Reset()
+}
+
+func (ts *ReaderTestSuite) TestNewFileReader() {
+	fileReader, err := ts.reader.NewFileReader(context.TODO(), "gs://archive-10", "dir/file2.txt")
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	content, err := io.ReadAll(fileReader)
+	ts.NoError(err)
+	ts.NoError(fileReader.Close())
+	ts.Equal("file 2 in bucket 10", string(content))
+}
+
+func (ts *ReaderTestSuite) TestNewFileReader_FileNotFound() {
+	_, err := ts.reader.NewFileReader(context.TODO(), "gs://archive-9", "not_exists.txt")
+	ts.ErrorIs(err, storageerrors.ErrorFileNotFoundInLocation)
+}
+
+func (ts *ReaderTestSuite) TestNewFileReader_InvalidLocation() {
+	_, err := ts.reader.NewFileReader(context.TODO(), "gs://other-1", "other.txt")
+	ts.ErrorIs(err, storageerrors.ErrorNoEndpointConfiguredForLocation)
+
+	_, err = ts.reader.NewFileReader(context.TODO(), "/archive-1", "file.txt")
+	ts.ErrorIs(err, storageerrors.ErrorInvalidLocation)
+}
+
+func (ts *ReaderTestSuite) TestGetFileSize() {
+	size, err := ts.reader.GetFileSize(context.TODO(), "gs://archive-1", "seekable_big_file.txt")
+	ts.NoError(err)
+	ts.Equal(int64(len(ts.bigContent)), size)
+
+	_, err = ts.reader.GetFileSize(context.TODO(), "gs://archive-1", "not_exists.txt")
+	ts.ErrorIs(err, storageerrors.ErrorFileNotFoundInLocation)
+}
+
+func (ts *ReaderTestSuite) TestFindFile() {
+	// Buckets are checked in the order the writer fills them, not lexically
+	location, err := ts.reader.FindFile(context.TODO(), "file.txt")
+	ts.NoError(err)
+	ts.Equal("gs://archive-9", location)
+
+	location, err = ts.reader.FindFile(context.TODO(), "dir/file2.txt")
+	ts.NoError(err)
+	ts.Equal("gs://archive-10", location)
+}
+
+func (ts *ReaderTestSuite) TestFindFile_NotFound() {
+	_, err := ts.reader.FindFile(context.TODO(), "other.txt")
+	ts.ErrorIs(err, storageerrors.ErrorFileNotFoundInLocation)
+}
+
+func (ts *ReaderTestSuite) TestNewFileReadSeeker() {
+	fileSeekReader, err := ts.reader.NewFileReadSeeker(context.TODO(), "gs://archive-1", "seekable_big_file.txt")
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	defer fileSeekReader.Close()
+
+	start := make([]byte, 50)
+	_, err = io.ReadFull(fileSeekReader, start)
+	ts.NoError(err)
+	ts.Equal("This is a big file for testing seekable gcs reader", string(start))
+
+	// The end of the file is in the second chunk
+	_, err = fileSeekReader.Seek(-18, io.SeekEnd)
+	ts.NoError(err)
+	end, err := io.ReadAll(fileSeekReader)
+	ts.NoError(err)
+	ts.Equal("file is ending now", string(end))
+}
+
+func (ts *ReaderTestSuite) TestNewFileReadSeeker_FileNotFound() {
+	_, err := ts.reader.NewFileReadSeeker(context.TODO(), "gs://archive-1", "not_exists.txt")
+	ts.ErrorIs(err, storageerrors.ErrorFileNotFoundInLocation)
+}
+
+func (ts *ReaderTestSuite) TestPing() {
+	ts.NoError(ts.reader.Ping(context.TODO()))
+}
+
+func (ts *ReaderTestSuite) TestPing_EndpointDown() {
+	server, err := fakestorage.NewServerWithOptions(fakestorage.Options{Scheme: "http", Host: "127.0.0.1"})
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	reader := &Reader{endpoints: []*endpointConfig{{
+		BucketPrefix:          "archive-",
+		DisableAuthentication: true,
+		Endpoint:              server.URL(),
+		ProjectID:             "sda",
+	}}}
+	ts.NoError(reader.Ping(context.TODO()))
+
+	// Requests to an unreachable endpoint are retried until the context is done
+	server.Stop()
+	ctx, cancel := context.WithTimeout(context.TODO(), 2*time.Second)
+	defer cancel()
+	ts.Error(reader.Ping(ctx))
+}
@@ -0,0 +1,240 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"github.com/c2h5oh/datasize"
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/locationbroker"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	"github.com/spf13/viper"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+type endpointConfig struct {
+	BucketPrefix          string `mapstructure:"bucket_prefix"`
+	ChunkSize             string `mapstructure:"chunk_size"`
+	chunkSizeBytes        uint64
+	CredentialsFile       string `mapstructure:"credentials_file"`
+	DisableAuthentication bool   `mapstructure:"disable_authentication"`
+	Endpoint              string `mapstructure:"endpoint"`
+	Location              string `mapstructure:"location"`
+	MaxBuckets            uint64 `mapstructure:"max_buckets"`
+	MaxObjects            uint64 `mapstructure:"max_objects"`
+	MaxSize               string `mapstructure:"max_size"`
+	maxSizeBytes          uint64
+	ProjectID             string `mapstructure:"project_id"`
+	WriterDisabled        bool   `mapstructure:"writer_disabled"`
+
+	gcsClient  *storage.Client // cached gcs client for this endpoint, created by getGCSClient
+	clientLock sync.Mutex      // guards the creation of the cached client
+}
+
+func loadConfig(backendName string) ([]*endpointConfig, error) {
+	var endpointConf []*endpointConfig
+
+	if err := viper.UnmarshalKey(
+		"storage."+backendName+".gcs",
+		&endpointConf,
+		func(config *mapstructure.DecoderConfig) {
+			config.WeaklyTypedInput = true
+			config.ZeroFields = true
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	var enabledEndpoints []*endpointConfig
+	for _, e := range endpointConf {
+		if e.WriterDisabled {
+			continue
+		}
+		switch {
+		case e.BucketPrefix == "":
+			return nil, errors.New("missing required parameter: bucket_prefix")
+		case e.ProjectID == "":
+			return nil, errors.New("missing required parameter: project_id")
+		default:
+		}
+
+		e.chunkSizeBytes = 50 * datasize.MB.Bytes()
+		if e.ChunkSize != "" {
+			byteSize, err := datasize.ParseString(e.ChunkSize)
+			if err != nil {
+				return nil, errors.New("could not parse chunk_size as a valid data size")
+			}
+			if byteSize < 5*datasize.MB {
+				return nil, errors.New("chunk_size can not be smaller than 5mb")
+			}
+			if byteSize > 1*datasize.GB {
+				return nil, errors.New("chunk_size can not be bigger than 1gb")
+			}
+			e.chunkSizeBytes = byteSize.Bytes()
+		}
+		if e.MaxSize != "" {
+			byteSize, err := datasize.ParseString(e.MaxSize)
+			if err != nil {
+				return nil, errors.New("could not parse max_size as a valid data size")
+			}
+			e.maxSizeBytes = byteSize.Bytes()
+		}
+		if e.MaxBuckets == 0 {
+			e.MaxBuckets = 1
+		}
+		enabledEndpoints = append(enabledEndpoints, e)
+	}
+
+	return enabledEndpoints, nil
+}
+
+func (endpointConf *endpointConfig) getGCSClient(ctx context.Context) (*storage.Client, error) {
+	endpointConf.clientLock.Lock()
+	defer endpointConf.clientLock.Unlock()
+
+	if endpointConf.gcsClient != nil {
+		return endpointConf.gcsClient, nil
+	}
+
+	var opts []option.ClientOption
+	if endpointConf.Endpoint != "" {
+		// A custom endpoint, eg an emulator, is only expected to support the JSON API
+		opts = append(opts, option.WithEndpoint(strings.TrimSuffix(endpointConf.Endpoint, "/")+"/storage/v1/"), storage.WithJSONReads())
+	}
+	switch {
+	case endpointConf.DisableAuthentication:
+		opts = append(opts, option.WithoutAuthentication())
+	case endpointConf.CredentialsFile != "":
+		opts = append(opts, option.WithCredentialsFile(endpointConf.CredentialsFile))
+	default:
+		// Application default credentials are used
+	}
+
+	client, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gcs client, due to: %v", err)
+	}
+	endpointConf.gcsClient = client
+
+	return endpointConf.gcsClient, nil
+}
+
+func (endpointConf *endpointConfig) listBucketsWithPrefix(ctx context.Context) ([]string, error) {
+	client, err := endpointConf.getGCSClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var bucketsWithPrefix []string
+	buckets := client.Buckets(ctx, endpointConf.ProjectID)
+	buckets.Prefix = endpointConf.BucketPrefix
+	for {
+		bucketAttrs, err := buckets.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list gcs buckets of project: %s, due to %v", endpointConf.ProjectID, err)
+		}
+		// Not all emulators filter by prefix
+		if strings.HasPrefix(bucketAttrs.Name, endpointConf.BucketPrefix) {
+			bucketsWithPrefix = append(bucketsWithPrefix, bucketAttrs.Name)
+		}
+	}
+
+	return bucketsWithPrefix, nil
+}
+
+func (endpointConf *endpointConfig) createBucket(ctx context.Context, bucket string) error {
+	client, err := endpointConf.getGCSClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	var attrs *storage.BucketAttrs
+	if endpointConf.Location != "" {
+		attrs = &storage.BucketAttrs{Location: endpointConf.Location}
+	}
+	if err := client.Bucket(bucket).Create(ctx, endpointConf.ProjectID, attrs); err != nil {
+		return fmt.Errorf("failed to create gcs bucket: %s in project: %s, due to %v", bucket, endpointConf.ProjectID, err)
+	}
+
+	return nil
+}
+
+func (endpointConf *endpointConfig) findActiveBucket(ctx context.Context, backendName string, locationBroker locationbroker.LocationBroker) (string, error) {
+	bucketsWithPrefix, err := endpointConf.listBucketsWithPrefix(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if len(bucketsWithPrefix) == 0 {
+		activeBucket := endpointConf.BucketPrefix + "1"
+		if err := endpointConf.createBucket(ctx, activeBucket); err != nil {
+			return "", err
+		}
+
+		return activeBucket, nil
+	}
+
+	sortBucketsNumerically(bucketsWithPrefix, endpointConf.BucketPrefix)
+
+	// find first bucket with available object count and size
+	for _, bucket := range bucketsWithPrefix {
+		loc := LocationScheme + bucket
+		count, err := locationBroker.GetObjectCount(ctx, backendName, loc)
+		if err != nil {
+			return "", fmt.Errorf("failed to get object count of location %s, due to %v", loc, err)
+		}
+		if count >= endpointConf.MaxObjects && endpointConf.MaxObjects > 0 {
+			continue
+		}
+
+		size, err := locationBroker.GetSize(ctx, backendName, loc)
+		if err != nil {
+			return "", fmt.Errorf("failed to get size of location %s, due to %v", loc, err)
+		}
+		if size >= endpointConf.maxSizeBytes && endpointConf.maxSizeBytes > 0 {
+			continue
+		}
+
+		return bucket, nil
+	}
+
+	// All created buckets are full, check if we should create new one after latest increment
+	if uint64(len(bucketsWithPrefix)) >= endpointConf.MaxBuckets && endpointConf.MaxBuckets > 0 {
+		return "", storageerrors.ErrorNoFreeBucket
+	}
+
+	currentInc, err := strconv.Atoi(strings.TrimPrefix(bucketsWithPrefix[len(bucketsWithPrefix)-1], endpointConf.BucketPrefix))
+	if err != nil {
+		return "", fmt.Errorf("failed to generate next bucket increment after bucket %s, due to %v", bucketsWithPrefix[len(bucketsWithPrefix)-1], err)
+	}
+	activeBucket := fmt.Sprintf("%s%d", endpointConf.BucketPrefix, currentInc+1)
+	if err := endpointConf.createBucket(ctx, activeBucket); err != nil {
+		return "", err
+	}
+
+	return activeBucket, nil
+}
+
+// sortBucketsNumerically sorts buckets in place by the integer suffix that follows prefix so `<prefix>10` comes
+// after `<prefix>9`, if a bucket name can't be parsed as `<prefix><number>` we fall back to lexical ordering
+func sortBucketsNumerically(buckets []string, prefix string) {
+	slices.SortFunc(buckets, func(a, b string) int {
+		aInc, aErr := strconv.Atoi(strings.TrimPrefix(a, prefix))
+		bInc, bErr := strconv.Atoi(strings.TrimPrefix(b, prefix))
+		if aErr != nil || bErr != nil {
+			return strings.Compare(a, b)
+		}
+
+		return aInc - bInc
+	})
+}
@@ -0,0 +1,25 @@
+package writer
+
+import (
+	"context"
+	"fmt"
+)
+
+// RemoveFile removes an object from a bucket
+func (writer *Writer) RemoveFile(ctx context.Context, location, filePath string) error {
+	bucket, err := parseLocation(location)
+	if err != nil {
+		return err
+	}
+
+	client, err := getGCSClientForBucket(ctx, writer.configuredEndpoints, bucket)
+	if err != nil {
+		return err
+	}
+
+	if err := client.Bucket(bucket).Object(filePath).Delete(ctx); err != nil {
+		return fmt.Errorf("failed to delete object: %s, bucket: %s, due to: %v", filePath, bucket, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+)
+
+func (writer *Writer) WriteFile(ctx context.Context, filePath string, fileContent io.Reader) (string, error) {
+	// Find endpoint / bucket that is to be used for writing
+	writer.Lock()
+	if writer.activeEndpoint == nil {
+		writer.Unlock()
+
+		return "", storageerrors.ErrorNoValidLocations
+	}
+	activeBucket, err := writer.activeEndpoint.findActiveBucket(ctx, writer.backendName, writer.locationBroker)
+	if err != nil && !errors.Is(err, storageerrors.ErrorNoFreeBucket) {
+		writer.Unlock()
+
+		return "", err
+	}
+	// Current active endpoint no longer has any free buckets, roll over to next endpoint
+	if activeBucket == "" {
+		for _, endpointConf := range writer.configuredEndpoints {
+			// We dont need to evaluate the currently active endpoint as we know it doesnt have any active buckets now
+			if endpointConf == writer.activeEndpoint {
+				continue
+			}
+
+			activeBucket, err = endpointConf.findActiveBucket(ctx, writer.backendName, writer.locationBroker)
+			if err != nil {
+				if errors.Is(err, storageerrors.ErrorNoFreeBucket) {
+					continue
+				}
+				writer.Unlock()
+
+				return "", err
+			}
+			writer.activeEndpoint = endpointConf
+
+			break
+		}
+	}
+	activeEndpoint := writer.activeEndpoint
+	writer.Unlock()
+
+	if activeBucket == "" {
+		return "", storageerrors.ErrorNoFreeBucket
+	}
+
+	client, err := activeEndpoint.getGCSClient(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// Cancelling the context aborts the upload in case of failures, so no partial object is created
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	objectWriter := client.Bucket(activeBucket).Object(filePath).NewWriter(ctx)
+	// Type conversation safe as chunkSizeBytes checked to be between 5mb and 1gb (in bytes)
+	//nolint:gosec // disable G115
+	objectWriter.ChunkSize = int(activeEndpoint.chunkSizeBytes)
+
+	if _, err := io.Copy(objectWriter, fileContent); err != nil {
+		cancel()
+		_ = objectWriter.Close()
+
+		return "", fmt.Errorf("failed to upload object: %s, bucket: %s, due to: %v", filePath, activeBucket, err)
+	}
+	if err := objectWriter.Close(); err != nil {
+		return "", fmt.Errorf("failed to upload object: %s, bucket: %s, due to: %v", filePath, activeBucket, err)
+	}
+
+	return LocationScheme + activeBucket, nil
+}
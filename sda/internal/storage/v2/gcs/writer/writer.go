@@ -0,0 +1,136 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/locationbroker"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/iterator"
+)
+
+// LocationScheme is the prefix of all locations served by the gcs storage implementation
+const LocationScheme = "gs://"
+
+type Writer struct {
+	backendName         string
+	configuredEndpoints []*endpointConfig
+	activeEndpoint      *endpointConfig
+
+	locationBroker locationbroker.LocationBroker
+
+	sync.Mutex
+}
+
+// NewWriter initiates a storage backend
+func NewWriter(ctx context.Context, backendName string, locationBroker locationbroker.LocationBroker) (*Writer, error) {
+	endPointConf, err := loadConfig(backendName)
+	if err != nil {
+		return nil, err
+	}
+
+	if locationBroker == nil {
+		return nil, errors.New("locationBroker is required")
+	}
+
+	writer := &Writer{
+		backendName:    backendName,
+		locationBroker: locationBroker,
+	}
+	writer.locationBroker.RegisterSizeAndCountFinderFunc(backendName, func(location string) bool {
+		return strings.HasPrefix(location, LocationScheme)
+	}, findSizeAndObjectCountOfLocation(endPointConf))
+
+	// Verify endpointConfig connections
+	for _, e := range endPointConf {
+		_, err := e.findActiveBucket(ctx, backendName, writer.locationBroker)
+		if err != nil {
+			if errors.Is(err, storageerrors.ErrorNoFreeBucket) {
+				log.Warningf("gcs project: %s has no available bucket", e.ProjectID)
+				writer.configuredEndpoints = append(writer.configuredEndpoints, e)
+
+				continue
+			}
+
+			return nil, err
+		}
+		writer.configuredEndpoints = append(writer.configuredEndpoints, e)
+		// Set first active endpoint as current
+		if writer.activeEndpoint == nil {
+			writer.activeEndpoint = e
+		}
+	}
+
+	if len(writer.configuredEndpoints) == 0 {
+		return nil, storageerrors.ErrorNoValidLocations
+	}
+
+	return writer, nil
+}
+
+func getGCSClientForBucket(ctx context.Context, configuredEndpoints []*endpointConfig, bucket string) (*storage.Client, error) {
+	for _, e := range configuredEndpoints {
+		if !strings.HasPrefix(bucket, e.BucketPrefix) {
+			continue
+		}
+
+		return e.getGCSClient(ctx)
+	}
+
+	return nil, storageerrors.ErrorNoEndpointConfiguredForLocation
+}
+
+// parseLocation attempts to parse a location to a gcs bucket
+// expected format of location is "gs://${BUCKET}"
+func parseLocation(location string) (string, error) {
+	if !strings.HasPrefix(location, LocationScheme) {
+		return "", storageerrors.ErrorInvalidLocation
+	}
+	bucket := strings.TrimPrefix(location, LocationScheme)
+	if bucket == "" || strings.Contains(bucket, "/") {
+		return "", storageerrors.ErrorInvalidLocation
+	}
+
+	return bucket, nil
+}
+
+// findSizeAndObjectCountOfLocation find the total size and total amount of objects in a gcs bucket if we do not store
+// this information in the database
+func findSizeAndObjectCountOfLocation(configuredEndpoints []*endpointConfig) func(ctx context.Context, location string) (uint64, uint64, error) {
+	return func(ctx context.Context, location string) (uint64, uint64, error) {
+		bucket, err := parseLocation(location)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		client, err := getGCSClientForBucket(ctx, configuredEndpoints, bucket)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		var totalSize uint64
+		var totalObjects uint64
+
+		objects := client.Bucket(bucket).Objects(ctx, nil)
+		for {
+			objectAttrs, err := objects.Next()
+			if errors.Is(err, iterator.Done) {
+				break
+			}
+			if err != nil {
+				return 0, 0, err
+			}
+
+			totalObjects++
+			if objectAttrs.Size > 0 {
+				totalSize += uint64(objectAttrs.Size) // #nosec G115 -- objectAttrs.Size has been checked to be bigger than 0
+			}
+		}
+
+		return totalSize, totalObjects, nil
+	}
+}
@@ -0,0 +1,243 @@
+package writer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fsouza/fake-gcs-server/fakestorage"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestParseLocation(t *testing.T) {
+	bucket, err := parseLocation("gs://archive-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "archive-1", bucket)
+
+	for _, location := range []string{"", "gs://", "/archive", "http://s3:9000/archive", "gs://archive/sub"} {
+		_, err := parseLocation(location)
+		assert.ErrorIs(t, err, storageerrors.ErrorInvalidLocation, location)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	viper.Set("storage.archive.gcs", []map[string]any{
+		{
+			"bucket_prefix": "archive-",
+			"project_id":    "sda",
+			"max_size":      "10GB",
+		},
+		{
+			"bucket_prefix":   "reader-only-",
+			"project_id":      "sda",
+			"writer_disabled": true,
+		},
+	})
+
+	endpoints, err := loadConfig("archive")
+	assert.NoError(t, err)
+	assert.Len(t, endpoints, 1)
+	assert.Equal(t, "archive-", endpoints[0].BucketPrefix)
+	assert.Equal(t, uint64(1), endpoints[0].MaxBuckets)
+	assert.Equal(t, uint64(10*1024*1024*1024), endpoints[0].maxSizeBytes)
+	assert.Equal(t, uint64(50*1024*1024), endpoints[0].chunkSizeBytes)
+}
+
+func TestLoadConfig_MissingProjectID(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	viper.Set("storage.archive.gcs", []map[string]any{
+		{"bucket_prefix": "archive-"},
+	})
+
+	_, err := loadConfig("archive")
+	assert.EqualError(t, err, "missing required parameter: project_id")
+}
+
+type mockLocationBroker struct {
+	mock.Mock
+}
+
+func (m *mockLocationBroker) GetObjectCount(_ context.Context, _, location string) (uint64, error) {
+	args := m.Called(location)
+	count := args.Int(0)
+	if count < 0 {
+		count = 0
+	}
+	//nolint:gosec // disable G115
+	return uint64(count), args.Error(1)
+}
+
+func (m *mockLocationBroker) GetSize(_ context.Context, _, location string) (uint64, error) {
+	args := m.Called(location)
+	size := args.Int(0)
+	if size < 0 {
+		size = 0
+	}
+	//nolint:gosec // disable G115
+	return uint64(size), args.Error(1)
+}
+
+func (m *mockLocationBroker) RegisterSizeAndCountFinderFunc(_ string, _ func(string) bool, _ func(context.Context, string) (uint64, uint64, error)) {
+	_ = m.Called()
+}
+
+// WriterTestSuite tests the writer against fake-gcs-server
+type WriterTestSuite struct {
+	suite.Suite
+	writer *Writer
+
+	gcsServer          *fakestorage.Server
+	locationBrokerMock *mockLocationBroker
+}
+
+func TestWriterTestSuite(t *testing.T) {
+	suite.Run(t, new(WriterTestSuite))
+}
+
+func (ts *WriterTestSuite) SetupTest() {
+	var err error
+	ts.gcsServer, err = fakestorage.NewServerWithOptions(fakestorage.Options{Scheme: "http", Host: "127.0.0.1"})
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	// Buckets of other prefixes are to be ignored
+	ts.gcsServer.CreateBucket("other-1")
+
+	viper.Reset()
+	viper.Set("storage.test.gcs", []map[string]any{
+		{
+			"endpoint":               ts.gcsServer.URL(),
+			"disable_authentication": true,
+			"project_id":             "sda",
+			"bucket_prefix":          "archive-",
+			"max_objects":            10,
+			"max_buckets":            2,
+		},
+	})
+
+	ts.locationBrokerMock = &mockLocationBroker{}
+	ts.locationBrokerMock.On("RegisterSizeAndCountFinderFunc").Return().Once()
+	// The first bucket is created as there is none
+	ts.writer, err = NewWriter(context.TODO(), "test", ts.locationBrokerMock)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+}
+
+func (ts *WriterTestSuite) TearDownTest() {
+	ts.gcsServer.Stop()
+	viper.Reset()
+}
+
+func (ts *WriterTestSuite) objectContent(bucket, filePath string) string {
+	object, err := ts.gcsServer.GetObject(bucket, filePath)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	return string(object.Content)
+}
+
+func (ts *WriterTestSuite) TestWriteFile() {
+	ts.locationBrokerMock.On("GetObjectCount", "gs://archive-1").Return(0, nil).Once()
+	ts.locationBrokerMock.On("GetSize", "gs://archive-1").Return(0, nil).Once()
+
+	location, err := ts.writer.WriteFile(context.TODO(), "dir/test_file_1.txt", strings.NewReader("test file 1"))
+	ts.NoError(err)
+	ts.Equal("gs://archive-1", location)
+	ts.Equal("test file 1", ts.objectContent("archive-1", "dir/test_file_1.txt"))
+}
+
+func (ts *WriterTestSuite) TestWriteFile_FirstBucketFull() {
+	ts.locationBrokerMock.On("GetObjectCount", "gs://archive-1").Return(10, nil).Once()
+
+	location, err := ts.writer.WriteFile(context.TODO(), "test_file_1.txt", strings.NewReader("test file 1"))
+	ts.NoError(err)
+	ts.Equal("gs://archive-2", location)
+	ts.Equal("test file 1", ts.objectContent("archive-2", "test_file_1.txt"))
+}
+
+func (ts *WriterTestSuite) TestWriteFile_NoFreeBucket() {
+	ts.gcsServer.CreateBucket("archive-2")
+	ts.locationBrokerMock.On("GetObjectCount", "gs://archive-1").Return(10, nil).Once()
+	ts.locationBrokerMock.On("GetObjectCount", "gs://archive-2").Return(10, nil).Once()
+
+	_, err := ts.writer.WriteFile(context.TODO(), "test_file_1.txt", strings.NewReader("test file 1"))
+	ts.ErrorIs(err, storageerrors.ErrorNoFreeBucket)
+}
+
+func (ts *WriterTestSuite) TestWriteAndRemoveFile_Concurrent() {
+	ts.locationBrokerMock.On("GetObjectCount", "gs://archive-1").Return(0, nil)
+	ts.locationBrokerMock.On("GetSize", "gs://archive-1").Return(0, nil)
+	for i := range 5 {
+		ts.gcsServer.CreateObject(fakestorage.Object{
+			ObjectAttrs: fakestorage.ObjectAttrs{BucketName: "archive-1", Name: fmt.Sprintf("file_to_be_removed_%d", i)},
+			Content:     []byte("content"),
+		})
+	}
+
+	// The endpoint client is created on first use, which is to be safe for concurrent use
+	for _, e := range ts.writer.configuredEndpoints {
+		e.gcsClient = nil
+	}
+	wg := sync.WaitGroup{}
+	for i := range 5 {
+		wg.Go(func() {
+			_, err := ts.writer.WriteFile(context.TODO(), fmt.Sprintf("test_file_%d.txt", i), strings.NewReader("content"))
+			ts.NoError(err)
+		})
+		wg.Go(func() {
+			ts.NoError(ts.writer.RemoveFile(context.TODO(), "gs://archive-1", fmt.Sprintf("file_to_be_removed_%d", i)))
+		})
+	}
+	wg.Wait()
+
+	objects, _, err := ts.gcsServer.ListObjects("archive-1", "test_file_", "", false)
+	ts.NoError(err)
+	ts.Len(objects, 5)
+	objects, _, err = ts.gcsServer.ListObjects("archive-1", "file_to_be_removed_", "", false)
+	ts.NoError(err)
+	ts.Empty(objects)
+}
+
+func (ts *WriterTestSuite) TestRemoveFile() {
+	ts.gcsServer.CreateObject(fakestorage.Object{
+		ObjectAttrs: fakestorage.ObjectAttrs{BucketName: "archive-1", Name: "file_to_be_removed"},
+		Content:     []byte("file to be removed content"),
+	})
+
+	ts.NoError(ts.writer.RemoveFile(context.TODO(), "gs://archive-1", "file_to_be_removed"))
+
+	_, err := ts.gcsServer.GetObject("archive-1", "file_to_be_removed")
+	ts.Error(err, "file to be removed still exists")
+}
+
+func (ts *WriterTestSuite) TestRemoveFile_InvalidLocation() {
+	err := ts.writer.RemoveFile(context.TODO(), "gs://other-1", "file_to_be_removed")
+	ts.ErrorIs(err, storageerrors.ErrorNoEndpointConfiguredForLocation)
+}
+
+func (ts *WriterTestSuite) TestFindSizeAndObjectCountOfLocation() {
+	for name, content := range map[string]string{"file_1": "12345", "file_2": "1234567890"} {
+		ts.gcsServer.CreateObject(fakestorage.Object{
+			ObjectAttrs: fakestorage.ObjectAttrs{BucketName: "archive-1", Name: name},
+			Content:     []byte(content),
+		})
+	}
+
+	size, count, err := findSizeAndObjectCountOfLocation(ts.writer.configuredEndpoints)(context.TODO(), "gs://archive-1")
+	ts.NoError(err)
+	ts.Equal(uint64(15), size)
+	ts.Equal(uint64(2), count)
+}
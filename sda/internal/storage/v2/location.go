@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"strings"
+
+	azurewriter "github.com/neicnordic/sensitive-data-archive/internal/storage/v2/azure/writer"
+	gcswriter "github.com/neicnordic/sensitive-data-archive/internal/storage/v2/gcs/writer"
+)
+
+// Implementation is the storage implementation a location belongs to
+type Implementation string
+
+const (
+	ImplementationUnknown Implementation = ""
+	ImplementationPosix   Implementation = "posix"
+	ImplementationS3      Implementation = "s3"
+	ImplementationGCS     Implementation = "gcs"
+	ImplementationAzure   Implementation = "azure"
+)
+
+// ImplementationOfLocation decides which storage implementation a location belongs to by the scheme of the location:
+//   - posix locations are absolute paths, eg "/archive"
+//   - s3 locations are the endpoint followed by the bucket, eg "https://s3.example.com/archive1", as the scheme of an
+//     s3 endpoint is optional any other location is considered to be a s3 location
+//   - gcs locations are "gs://${BUCKET}"
+//   - azure locations are "az://${ACCOUNT_NAME}/${CONTAINER}"
+func ImplementationOfLocation(location string) Implementation {
+	switch {
+	case strings.HasPrefix(location, "/"):
+		return ImplementationPosix
+	case strings.HasPrefix(location, gcswriter.LocationScheme):
+		return ImplementationGCS
+	case strings.HasPrefix(location, azurewriter.LocationScheme):
+		return ImplementationAzure
+	case location == "":
+		return ImplementationUnknown
+	default:
+		return ImplementationS3
+	}
+}
@@ -0,0 +1,26 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImplementationOfLocation(t *testing.T) {
+	for _, test := range []struct {
+		location string
+		expected Implementation
+	}{
+		{location: "/archive", expected: ImplementationPosix},
+		{location: "https://s3.example.com/archive1", expected: ImplementationS3},
+		{location: "http://s3:9000/archive1", expected: ImplementationS3},
+		{location: "s3.example.com/archive1", expected: ImplementationS3},
+		{location: "gs://archive1", expected: ImplementationGCS},
+		{location: "az://account/archive1", expected: ImplementationAzure},
+		{location: "", expected: ImplementationUnknown},
+	} {
+		t.Run(test.location, func(t *testing.T) {
+			assert.Equal(t, test.expected, ImplementationOfLocation(test.location))
+		})
+	}
+}
@@ -214,6 +214,10 @@ func (m *mockDatabase) SetBackedUp(_ context.Context, _, _, _ string) error {
 	panic("function not expected to be called in unit tests")
 }
 
+func (m *mockDatabase) SetRepaired(_ context.Context, _, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
+
 func (m *mockDatabase) GetSizeAndObjectCountOfLocation(_ context.Context, location string) (uint64, uint64, error) {
 	args := m.Called(location)
 
@@ -341,3 +345,139 @@ func (ts *LocationBrokerTestSuite) TestGetObjectCount_WithDefaultFinderFunc() {
 	ts.NoError(err)
 	ts.Equal(uint64(987), size)
 }
+
+func (m *mockDatabase) GetArchiveObject(_ context.Context, _ string, _ int64) (*database.ArchiveObject, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) GetUploadedArchiveObject(_ context.Context, _ string) (*database.ArchiveObject, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) ReferenceArchiveObject(_ context.Context, _, _ string, _ int64, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) DereferenceArchiveObject(_ context.Context, _, _ string) (bool, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) GetIngestCheckpoint(_ context.Context, _ string) (*database.IngestCheckpoint, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) SetIngestCheckpoint(_ context.Context, _ string, _ *database.IngestCheckpoint) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) DeleteIngestCheckpoint(_ context.Context, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) GetFilesToScrub(_ context.Context, _ time.Time, _ int) ([]*database.ScrubFile, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) SetFileScrubbed(_ context.Context, _ string, _ bool, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) SetArchiveLocation(_ context.Context, _, _, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) MigrateArchiveLocation(_ context.Context, _, _, _, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) GetMigratedFromLocation(_ context.Context, _ string) (string, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) GetFileIDsInArchiveLocation(_ context.Context, _, _, _ string) ([]string, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) IsArchivedObjectReferenced(_ context.Context, _, _ string) (bool, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) AddUploadedChecksum(_ context.Context, _, _, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) GetUploadChecksumState(_ context.Context, _ string, _ int32) (*database.UploadChecksumState, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) SetUploadChecksumState(_ context.Context, _ *database.UploadChecksumState) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) DeleteUploadChecksumStates(_ context.Context, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) GetInboxCopyOfFile(_ context.Context, _, _ string) (string, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) GetInboxQuota(_ context.Context, _ string) (*database.InboxQuota, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) SetInboxQuota(_ context.Context, _ *database.InboxQuota) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) DeleteInboxQuota(_ context.Context, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) GetInboxUsage(_ context.Context, _, _ string) (*database.InboxUsage, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) GetStaleInboxFiles(_ context.Context, _ string, _ time.Time, _ string, _ int) ([]*database.InboxFile, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) SetInboxExpiryWarned(_ context.Context, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) DisableInboxFile(_ context.Context, _, _ string, _ time.Time, _ string) (bool, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) GetFileSyncState(_ context.Context, _, _ string) (*database.FileSyncState, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) SetFileSyncState(_ context.Context, _ *database.FileSyncState) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) GetDatasetSyncStates(_ context.Context, _ string) ([]*database.FileSyncState, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) GetUnconfirmedSyncFiles(_ context.Context, _ string) ([]string, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) GetIngestionStatus(_ context.Context, _, _, _ string) (*database.IngestionStatus, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) RegisterSyncAPIRequest(_ context.Context, _, _ string, _, _ time.Time) (bool, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) DeleteSyncAPIRequests(_ context.Context, _ time.Time) (int64, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *mockDatabase) ReleaseSyncAPIRequest(_ context.Context, _, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
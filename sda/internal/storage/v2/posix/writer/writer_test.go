@@ -11,6 +11,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/neicnordic/sensitive-data-archive/internal/database"
 	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/locationbroker"
@@ -549,6 +550,10 @@ func (m *notImplementedDatabase) SetBackedUp(_ context.Context, _, _, _ string)
 	panic("function not expected to be called in unit tests")
 }
 
+func (m *notImplementedDatabase) SetRepaired(_ context.Context, _, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
+
 func (m *notImplementedDatabase) GetSizeAndObjectCountOfLocation(_ context.Context, location string) (uint64, uint64, error) {
 	panic("function not expected to be called in unit tests")
 }
@@ -568,3 +573,139 @@ func (m *notImplementedDatabase) IsFileInDataset(_ context.Context, _ string) (b
 func (m *notImplementedDatabase) CancelFile(_ context.Context, _, _ string) error {
 	panic("function not expected to be called in unit tests")
 }
+
+func (m *notImplementedDatabase) GetArchiveObject(_ context.Context, _ string, _ int64) (*database.ArchiveObject, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) GetUploadedArchiveObject(_ context.Context, _ string) (*database.ArchiveObject, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) ReferenceArchiveObject(_ context.Context, _, _ string, _ int64, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) DereferenceArchiveObject(_ context.Context, _, _ string) (bool, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) GetIngestCheckpoint(_ context.Context, _ string) (*database.IngestCheckpoint, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) SetIngestCheckpoint(_ context.Context, _ string, _ *database.IngestCheckpoint) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) DeleteIngestCheckpoint(_ context.Context, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) GetFilesToScrub(_ context.Context, _ time.Time, _ int) ([]*database.ScrubFile, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) SetFileScrubbed(_ context.Context, _ string, _ bool, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) SetArchiveLocation(_ context.Context, _, _, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) MigrateArchiveLocation(_ context.Context, _, _, _, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) GetMigratedFromLocation(_ context.Context, _ string) (string, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) GetFileIDsInArchiveLocation(_ context.Context, _, _, _ string) ([]string, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) IsArchivedObjectReferenced(_ context.Context, _, _ string) (bool, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) AddUploadedChecksum(_ context.Context, _, _, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) GetUploadChecksumState(_ context.Context, _ string, _ int32) (*database.UploadChecksumState, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) SetUploadChecksumState(_ context.Context, _ *database.UploadChecksumState) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) DeleteUploadChecksumStates(_ context.Context, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) GetInboxCopyOfFile(_ context.Context, _, _ string) (string, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) GetInboxQuota(_ context.Context, _ string) (*database.InboxQuota, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) SetInboxQuota(_ context.Context, _ *database.InboxQuota) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) DeleteInboxQuota(_ context.Context, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) GetInboxUsage(_ context.Context, _, _ string) (*database.InboxUsage, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) GetStaleInboxFiles(_ context.Context, _ string, _ time.Time, _ string, _ int) ([]*database.InboxFile, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) SetInboxExpiryWarned(_ context.Context, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) DisableInboxFile(_ context.Context, _, _ string, _ time.Time, _ string) (bool, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) GetFileSyncState(_ context.Context, _, _ string) (*database.FileSyncState, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) SetFileSyncState(_ context.Context, _ *database.FileSyncState) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) GetDatasetSyncStates(_ context.Context, _ string) ([]*database.FileSyncState, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) GetUnconfirmedSyncFiles(_ context.Context, _ string) ([]string, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) GetIngestionStatus(_ context.Context, _, _, _ string) (*database.IngestionStatus, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) RegisterSyncAPIRequest(_ context.Context, _, _ string, _, _ time.Time) (bool, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) DeleteSyncAPIRequests(_ context.Context, _ time.Time) (int64, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) ReleaseSyncAPIRequest(_ context.Context, _, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
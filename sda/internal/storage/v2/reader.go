@@ -4,8 +4,9 @@ import (
 	"context"
 	"errors"
 	"io"
-	"strings"
 
+	azurereader "github.com/neicnordic/sensitive-data-archive/internal/storage/v2/azure/reader"
+	gcsreader "github.com/neicnordic/sensitive-data-archive/internal/storage/v2/gcs/reader"
 	posixreader "github.com/neicnordic/sensitive-data-archive/internal/storage/v2/posix/reader"
 	s3reader "github.com/neicnordic/sensitive-data-archive/internal/storage/v2/s3/reader"
 	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
@@ -26,6 +27,8 @@ type Reader interface {
 }
 
 type reader struct {
+	azureReader Reader
+	gcsReader   Reader
 	posixReader Reader
 	s3Reader    Reader
 }
@@ -37,70 +40,106 @@ func NewReader(ctx context.Context, backendName string) (Reader, error) {
 	if err != nil && !errors.Is(err, storageerrors.ErrorNoValidLocations) {
 		return nil, err
 	}
+	gcsReader, err := gcsreader.NewReader(ctx, backendName)
+	if err != nil && !errors.Is(err, storageerrors.ErrorNoValidLocations) {
+		return nil, err
+	}
+	azureReader, err := azurereader.NewReader(ctx, backendName)
+	if err != nil && !errors.Is(err, storageerrors.ErrorNoValidLocations) {
+		return nil, err
+	}
 	posixReader, err := posixreader.NewReader(backendName)
 	if err != nil && !errors.Is(err, storageerrors.ErrorNoValidLocations) {
 		return nil, err
 	}
 
-	if s3Reader == nil && posixReader == nil {
-		return nil, storageerrors.ErrorNoValidReader
+	// Assign the concrete readers only when set, to avoid storing typed nil pointers in the interface fields
+	if s3Reader != nil {
+		r.s3Reader = s3Reader
+	}
+	if gcsReader != nil {
+		r.gcsReader = gcsReader
+	}
+	if azureReader != nil {
+		r.azureReader = azureReader
 	}
 	if posixReader != nil {
 		r.posixReader = posixReader
 	}
-	if s3Reader != nil {
-		r.s3Reader = s3Reader
+	if len(r.readers()) == 0 {
+		return nil, storageerrors.ErrorNoValidReader
 	}
 
 	return r, nil
 }
 
-func (r *reader) NewFileReader(ctx context.Context, location, filePath string) (io.ReadCloser, error) {
-	if strings.HasPrefix(location, "/") && r.posixReader != nil {
-		return r.posixReader.NewFileReader(ctx, location, filePath)
+// readers returns all configured readers in the order in which they are searched by FindFile
+func (r *reader) readers() []Reader {
+	var readers []Reader
+	for _, configuredReader := range []Reader{r.s3Reader, r.gcsReader, r.azureReader, r.posixReader} {
+		if configuredReader != nil {
+			readers = append(readers, configuredReader)
+		}
 	}
 
-	if !strings.HasPrefix(location, "/") && r.s3Reader != nil {
-		return r.s3Reader.NewFileReader(ctx, location, filePath)
+	return readers
+}
+
+// readerForLocation returns the reader of the storage implementation the location belongs to, decided by the scheme
+// of the location
+func (r *reader) readerForLocation(location string) (Reader, error) {
+	var locationReader Reader
+	switch ImplementationOfLocation(location) {
+	case ImplementationPosix:
+		locationReader = r.posixReader
+	case ImplementationS3:
+		locationReader = r.s3Reader
+	case ImplementationGCS:
+		locationReader = r.gcsReader
+	case ImplementationAzure:
+		locationReader = r.azureReader
+	default:
+		return nil, storageerrors.ErrorInvalidLocation
+	}
+	if locationReader == nil {
+		return nil, storageerrors.ErrorNoValidReader
 	}
 
-	return nil, storageerrors.ErrorNoValidReader
+	return locationReader, nil
 }
 
-func (r *reader) NewFileReadSeeker(ctx context.Context, location, filePath string) (io.ReadSeekCloser, error) {
-	if strings.HasPrefix(location, "/") && r.posixReader != nil {
-		return r.posixReader.NewFileReadSeeker(ctx, location, filePath)
+func (r *reader) NewFileReader(ctx context.Context, location, filePath string) (io.ReadCloser, error) {
+	locationReader, err := r.readerForLocation(location)
+	if err != nil {
+		return nil, err
 	}
 
-	if !strings.HasPrefix(location, "/") && r.s3Reader != nil {
-		return r.s3Reader.NewFileReadSeeker(ctx, location, filePath)
+	return locationReader.NewFileReader(ctx, location, filePath)
+}
+
+func (r *reader) NewFileReadSeeker(ctx context.Context, location, filePath string) (io.ReadSeekCloser, error) {
+	locationReader, err := r.readerForLocation(location)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, storageerrors.ErrorNoValidReader
+	return locationReader.NewFileReadSeeker(ctx, location, filePath)
 }
 
 func (r *reader) GetFileSize(ctx context.Context, location, filePath string) (int64, error) {
-	if strings.HasPrefix(location, "/") && r.posixReader != nil {
-		return r.posixReader.GetFileSize(ctx, location, filePath)
+	locationReader, err := r.readerForLocation(location)
+	if err != nil {
+		return 0, err
 	}
 
-	if !strings.HasPrefix(location, "/") && r.s3Reader != nil {
-		return r.s3Reader.GetFileSize(ctx, location, filePath)
-	}
-
-	return 0, storageerrors.ErrorNoValidReader
+	return locationReader.GetFileSize(ctx, location, filePath)
 }
 
 func (r *reader) Ping(ctx context.Context) error {
 	var errs []error
 
-	if r.s3Reader != nil {
-		if err := r.s3Reader.Ping(ctx); err != nil {
-			errs = append(errs, err)
-		}
-	}
-	if r.posixReader != nil {
-		if err := r.posixReader.Ping(ctx); err != nil {
+	for _, configuredReader := range r.readers() {
+		if err := configuredReader.Ping(ctx); err != nil {
 			errs = append(errs, err)
 		}
 	}
@@ -109,17 +148,8 @@ func (r *reader) Ping(ctx context.Context) error {
 }
 
 func (r *reader) FindFile(ctx context.Context, filePath string) (string, error) {
-	if r.s3Reader != nil {
-		loc, err := r.s3Reader.FindFile(ctx, filePath)
-		if err != nil && !errors.Is(err, storageerrors.ErrorFileNotFoundInLocation) {
-			return "", err
-		}
-		if loc != "" {
-			return loc, nil
-		}
-	}
-	if r.posixReader != nil {
-		loc, err := r.posixReader.FindFile(ctx, filePath)
+	for _, configuredReader := range r.readers() {
+		loc, err := configuredReader.FindFile(ctx, filePath)
 		if err != nil && !errors.Is(err, storageerrors.ErrorFileNotFoundInLocation) {
 			return "", err
 		}
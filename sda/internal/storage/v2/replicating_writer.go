@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/locationbroker"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// ReplicatingWriter defines methods to write or delete a file in several storage backends at once
+type ReplicatingWriter interface {
+	// RemoveFile will remove the file at the file path from the given locations, keyed by the name of the storage
+	// backend the location belongs to
+	RemoveFile(ctx context.Context, locations map[string]string, filePath string) error
+	// WriteFile will write the file to the active location of every storage backend, and return the locations the file
+	// was written to keyed by the name of the storage backend
+	WriteFile(ctx context.Context, filePath string, fileContent io.Reader) (locations map[string]string, err error)
+}
+
+type replica struct {
+	backendName string
+	writer      Writer
+}
+
+type replicatingWriter struct {
+	// replicas are written to in the order of the storage backends, the first is the primary which always needs to
+	// be written to
+	replicas []replica
+	quorum   int
+}
+
+type replicaResult struct {
+	location string
+	err      error
+}
+
+// NewReplicatingWriter initialises a writer for each of the backendNames, and replicates every file to all of them.
+// As each storage backend has its own writer, files can be replicated between endpoints of the same storage
+// implementation, eg two s3 endpoints. The first backend is the primary which a write always needs to succeed for.
+// The amount of backends that need to succeed for a write to be successful is configured by
+// storage.${backendNames[0]}.replication.quorum, if not set all backends need to succeed.
+func NewReplicatingWriter(ctx context.Context, backendNames []string, locationBroker locationbroker.LocationBroker) (ReplicatingWriter, error) {
+	if len(backendNames) == 0 {
+		return nil, storageerrors.ErrorNoValidWriter
+	}
+
+	w := &replicatingWriter{}
+	for _, backendName := range backendNames {
+		if slices.ContainsFunc(w.replicas, func(r replica) bool { return r.backendName == backendName }) {
+			return nil, fmt.Errorf("storage backend: %s can only be replicated to once", backendName)
+		}
+
+		writer, err := NewWriter(ctx, backendName, locationBroker)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize writer of storage backend: %s, due to: %w", backendName, err)
+		}
+		w.replicas = append(w.replicas, replica{backendName: backendName, writer: writer})
+	}
+
+	var err error
+	w.quorum, err = writeQuorum(backendNames[0], len(w.replicas))
+	if err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// writeQuorum returns the configured write quorum of the primary backend, defaulting to all replicas
+func writeQuorum(backendName string, replicaCount int) (int, error) {
+	quorum := replicaCount
+	if quorumKey := "storage." + backendName + ".replication.quorum"; viper.IsSet(quorumKey) {
+		quorum = viper.GetInt(quorumKey)
+	}
+	switch {
+	case quorum > replicaCount:
+		return 0, storageerrors.ErrorInvalidWriteQuorum
+	case quorum < 1:
+		return 0, storageerrors.ErrorWriteQuorumTooSmall
+	}
+
+	return quorum, nil
+}
+
+func (w *replicatingWriter) WriteFile(ctx context.Context, filePath string, fileContent io.Reader) (map[string]string, error) {
+	pipeReaders := make([]*io.PipeReader, len(w.replicas))
+	pipeWriters := make([]*io.PipeWriter, len(w.replicas))
+	for i := range w.replicas {
+		pipeReaders[i], pipeWriters[i] = io.Pipe()
+	}
+
+	results := make([]replicaResult, len(w.replicas))
+	wg := sync.WaitGroup{}
+	for i, r := range w.replicas {
+		wg.Go(func() {
+			location, err := r.writer.WriteFile(ctx, filePath, pipeReaders[i])
+			// Close the reader side so the fan out does not block on a writer which stopped reading
+			if err != nil {
+				_ = pipeReaders[i].CloseWithError(err)
+			} else {
+				_ = pipeReaders[i].Close()
+			}
+			results[i] = replicaResult{location: location, err: err}
+		})
+	}
+
+	fanOutErrs, readErr := fanOut(fileContent, pipeWriters)
+	wg.Wait()
+
+	locations := make(map[string]string)
+	var errs []error
+	for i, result := range results {
+		backendName := w.replicas[i].backendName
+		switch {
+		case result.err != nil:
+			errs = append(errs, fmt.Errorf("storage backend: %s, %w", backendName, result.err))
+		case fanOutErrs[i] != nil:
+			// The writer returned before having received all the content
+			errs = append(errs, fmt.Errorf("failed to write all content to location: %s of storage backend: %s, due to: %v", result.location, backendName, fanOutErrs[i]))
+			w.removeReplicas(ctx, map[string]string{backendName: result.location}, filePath)
+		default:
+			locations[backendName] = result.location
+		}
+	}
+
+	if readErr != nil {
+		w.removeReplicas(ctx, locations, filePath)
+
+		return nil, fmt.Errorf("failed to read file content, due to: %v", readErr)
+	}
+
+	if _, ok := locations[w.replicas[0].backendName]; !ok || len(locations) < w.quorum {
+		w.removeReplicas(ctx, locations, filePath)
+
+		return nil, errors.Join(append([]error{storageerrors.ErrorWriteQuorumNotReached}, errs...)...)
+	}
+
+	for _, err := range errs {
+		log.Warningf("file: %s was not written to all replicas, due to: %v", filePath, err)
+	}
+
+	return locations, nil
+}
+
+func (w *replicatingWriter) RemoveFile(ctx context.Context, locations map[string]string, filePath string) error {
+	var errs []error
+	for backendName, location := range locations {
+		i := slices.IndexFunc(w.replicas, func(r replica) bool { return r.backendName == backendName })
+		if i == -1 {
+			errs = append(errs, fmt.Errorf("storage backend: %s, %w", backendName, storageerrors.ErrorNoValidWriter))
+
+			continue
+		}
+		if err := w.replicas[i].writer.RemoveFile(ctx, location, filePath); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// removeReplicas removes already written replicas after a failed write, errors are only logged
+// as the write itself has already failed
+func (w *replicatingWriter) removeReplicas(ctx context.Context, locations map[string]string, filePath string) {
+	if err := w.RemoveFile(ctx, locations, filePath); err != nil {
+		log.Errorf("failed to remove replicas of file: %s after failed write, due to: %v", filePath, err)
+	}
+}
+
+// fanOut copies the source to all destinations, a destination which fails to be written to will be skipped for the
+// remaining content. Returns the error for each destination, and any error from reading the source
+func fanOut(source io.Reader, destinations []*io.PipeWriter) ([]error, error) {
+	destinationErrs := make([]error, len(destinations))
+	buf := make([]byte, 32*1024)
+
+	for {
+		n, readErr := source.Read(buf)
+		if n > 0 {
+			written := 0
+			for i, destination := range destinations {
+				if destinationErrs[i] != nil {
+					continue
+				}
+				if _, err := destination.Write(buf[:n]); err != nil {
+					destinationErrs[i] = err
+
+					continue
+				}
+				written++
+			}
+			// No point in reading the rest of the source if no destination is left to write to
+			if written == 0 {
+				return destinationErrs, nil
+			}
+		}
+
+		switch {
+		case readErr == nil:
+			continue
+		case errors.Is(readErr, io.EOF):
+			for _, destination := range destinations {
+				_ = destination.Close()
+			}
+
+			return destinationErrs, nil
+		default:
+			for _, destination := range destinations {
+				_ = destination.CloseWithError(readErr)
+			}
+
+			return destinationErrs, readErr
+		}
+	}
+}
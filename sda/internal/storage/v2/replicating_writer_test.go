@@ -0,0 +1,226 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/suite"
+)
+
+type ReplicatingWriterTestSuite struct {
+	suite.Suite
+}
+
+func TestReplicatingWriterTestSuite(t *testing.T) {
+	suite.Run(t, new(ReplicatingWriterTestSuite))
+}
+
+// mockWriter stores written files in memory, and fails the write after failAfter bytes if failAfter >= 0
+type mockWriter struct {
+	location  string
+	failAfter int
+
+	files   map[string][]byte
+	removed []string
+	sync.Mutex
+}
+
+func newMockWriter(location string, failAfter int) *mockWriter {
+	return &mockWriter{
+		location:  location,
+		failAfter: failAfter,
+		files:     make(map[string][]byte),
+	}
+}
+
+func (m *mockWriter) WriteFile(_ context.Context, filePath string, fileContent io.Reader) (string, error) {
+	if m.failAfter >= 0 {
+		_, _ = io.CopyN(io.Discard, fileContent, int64(m.failAfter))
+
+		return "", errors.New("mock write error")
+	}
+
+	content, err := io.ReadAll(fileContent)
+	if err != nil {
+		return "", err
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.files[filePath] = content
+
+	return m.location, nil
+}
+
+func (m *mockWriter) RemoveFile(_ context.Context, location, filePath string) error {
+	if location != m.location {
+		return storageerrors.ErrorNoEndpointConfiguredForLocation
+	}
+	m.Lock()
+	defer m.Unlock()
+	delete(m.files, filePath)
+	m.removed = append(m.removed, filePath)
+
+	return nil
+}
+
+// newReplicatingWriter replicates to the archive and backup writers, with archive as the primary
+func (ts *ReplicatingWriterTestSuite) newReplicatingWriter(archiveWriter, backupWriter *mockWriter, quorum int) *replicatingWriter {
+	return &replicatingWriter{
+		replicas: []replica{
+			{backendName: "archive", writer: archiveWriter},
+			{backendName: "backup", writer: backupWriter},
+		},
+		quorum: quorum,
+	}
+}
+
+func (ts *ReplicatingWriterTestSuite) TestWriteFile() {
+	archiveWriter := newMockWriter("http://s3:9000/archive", -1)
+	backupWriter := newMockWriter("/backup", -1)
+	w := ts.newReplicatingWriter(archiveWriter, backupWriter, 2)
+
+	// Bigger than the fan out buffer to ensure content is copied in multiple chunks
+	content := bytes.Repeat([]byte("replicated content"), 10000)
+	locations, err := w.WriteFile(context.TODO(), "file.c4gh", bytes.NewReader(content))
+	ts.NoError(err)
+	ts.Equal(map[string]string{"archive": "http://s3:9000/archive", "backup": "/backup"}, locations)
+	ts.Equal(content, archiveWriter.files["file.c4gh"])
+	ts.Equal(content, backupWriter.files["file.c4gh"])
+}
+
+func (ts *ReplicatingWriterTestSuite) TestWriteFile_SameImplementation() {
+	// Both backends are s3, but at different endpoints
+	archiveWriter := newMockWriter("http://s3:9000/archive", -1)
+	backupWriter := newMockWriter("http://backup-s3:9000/backup", -1)
+	w := ts.newReplicatingWriter(archiveWriter, backupWriter, 2)
+
+	locations, err := w.WriteFile(context.TODO(), "file.c4gh", strings.NewReader("content"))
+	ts.NoError(err)
+	ts.Equal(map[string]string{"archive": "http://s3:9000/archive", "backup": "http://backup-s3:9000/backup"}, locations)
+
+	ts.NoError(w.RemoveFile(context.TODO(), locations, "file.c4gh"))
+	ts.Equal([]string{"file.c4gh"}, archiveWriter.removed)
+	ts.Equal([]string{"file.c4gh"}, backupWriter.removed)
+}
+
+func (ts *ReplicatingWriterTestSuite) TestWriteFile_QuorumNotReached() {
+	archiveWriter := newMockWriter("http://s3:9000/archive", -1)
+	backupWriter := newMockWriter("/backup", 10)
+	w := ts.newReplicatingWriter(archiveWriter, backupWriter, 2)
+
+	content := bytes.Repeat([]byte("replicated content"), 10000)
+	locations, err := w.WriteFile(context.TODO(), "file.c4gh", bytes.NewReader(content))
+	ts.ErrorIs(err, storageerrors.ErrorWriteQuorumNotReached)
+	ts.ErrorContains(err, "mock write error")
+	ts.Nil(locations)
+	ts.NotContains(archiveWriter.files, "file.c4gh")
+	ts.Equal([]string{"file.c4gh"}, archiveWriter.removed)
+}
+
+func (ts *ReplicatingWriterTestSuite) TestWriteFile_QuorumReached() {
+	archiveWriter := newMockWriter("http://s3:9000/archive", -1)
+	backupWriter := newMockWriter("/backup", 0)
+	w := ts.newReplicatingWriter(archiveWriter, backupWriter, 1)
+
+	content := bytes.Repeat([]byte("replicated content"), 10000)
+	locations, err := w.WriteFile(context.TODO(), "file.c4gh", bytes.NewReader(content))
+	ts.NoError(err)
+	ts.Equal(map[string]string{"archive": "http://s3:9000/archive"}, locations)
+	ts.Equal(content, archiveWriter.files["file.c4gh"])
+	ts.Empty(archiveWriter.removed)
+}
+
+func (ts *ReplicatingWriterTestSuite) TestWriteFile_PrimaryFailed() {
+	archiveWriter := newMockWriter("http://s3:9000/archive", 0)
+	backupWriter := newMockWriter("/backup", -1)
+	w := ts.newReplicatingWriter(archiveWriter, backupWriter, 1)
+
+	// The quorum is reached, but the file always needs to be written to the primary
+	locations, err := w.WriteFile(context.TODO(), "file.c4gh", strings.NewReader("content"))
+	ts.ErrorIs(err, storageerrors.ErrorWriteQuorumNotReached)
+	ts.ErrorContains(err, "storage backend: archive")
+	ts.Nil(locations)
+	ts.Empty(backupWriter.files)
+	ts.Equal([]string{"file.c4gh"}, backupWriter.removed)
+}
+
+func (ts *ReplicatingWriterTestSuite) TestWriteFile_FaultyContentReader() {
+	archiveWriter := newMockWriter("http://s3:9000/archive", -1)
+	backupWriter := newMockWriter("/backup", -1)
+	w := ts.newReplicatingWriter(archiveWriter, backupWriter, 1)
+
+	reader, writer := io.Pipe()
+	go func() {
+		_, _ = writer.Write([]byte("partial file content"))
+		_ = writer.CloseWithError(errors.New("mock read error"))
+	}()
+
+	locations, err := w.WriteFile(context.TODO(), "file.c4gh", reader)
+	ts.ErrorContains(err, "mock read error")
+	ts.Nil(locations)
+	ts.Empty(archiveWriter.files)
+	ts.Empty(backupWriter.files)
+}
+
+func (ts *ReplicatingWriterTestSuite) TestRemoveFile() {
+	archiveWriter := newMockWriter("http://s3:9000/archive", -1)
+	backupWriter := newMockWriter("/backup", -1)
+	w := ts.newReplicatingWriter(archiveWriter, backupWriter, 2)
+
+	_, err := w.WriteFile(context.TODO(), "file.c4gh", strings.NewReader("content"))
+	ts.NoError(err)
+
+	ts.NoError(w.RemoveFile(context.TODO(), map[string]string{"backup": "/backup", "archive": "http://s3:9000/archive"}, "file.c4gh"))
+	ts.Empty(archiveWriter.files)
+	ts.Empty(backupWriter.files)
+}
+
+func (ts *ReplicatingWriterTestSuite) TestRemoveFile_UnknownLocation() {
+	archiveWriter := newMockWriter("http://s3:9000/archive", -1)
+	backupWriter := newMockWriter("/backup", -1)
+	w := ts.newReplicatingWriter(archiveWriter, backupWriter, 2)
+
+	err := w.RemoveFile(context.TODO(), map[string]string{"backup": "/unknown", "archive": "http://s3:9000/archive"}, "file.c4gh")
+	ts.ErrorIs(err, storageerrors.ErrorNoEndpointConfiguredForLocation)
+	ts.Equal([]string{"file.c4gh"}, archiveWriter.removed)
+}
+
+func (ts *ReplicatingWriterTestSuite) TestRemoveFile_UnknownBackend() {
+	archiveWriter := newMockWriter("http://s3:9000/archive", -1)
+	backupWriter := newMockWriter("/backup", -1)
+	w := ts.newReplicatingWriter(archiveWriter, backupWriter, 2)
+
+	err := w.RemoveFile(context.TODO(), map[string]string{"other": "/other", "backup": "/backup"}, "file.c4gh")
+	ts.ErrorIs(err, storageerrors.ErrorNoValidWriter)
+	ts.Equal([]string{"file.c4gh"}, backupWriter.removed)
+	ts.Empty(archiveWriter.removed)
+}
+
+func (ts *ReplicatingWriterTestSuite) TestWriteQuorum() {
+	viper.Reset()
+	defer viper.Reset()
+
+	quorum, err := writeQuorum("archive", 2)
+	ts.NoError(err)
+	ts.Equal(2, quorum)
+
+	viper.Set("storage.archive.replication.quorum", 1)
+	quorum, err = writeQuorum("archive", 2)
+	ts.NoError(err)
+	ts.Equal(1, quorum)
+
+	viper.Set("storage.archive.replication.quorum", 3)
+	_, err = writeQuorum("archive", 2)
+	ts.ErrorIs(err, storageerrors.ErrorInvalidWriteQuorum)
+
+	viper.Set("storage.archive.replication.quorum", 0)
+	_, err = writeQuorum("archive", 2)
+	ts.ErrorIs(err, storageerrors.ErrorWriteQuorumTooSmall)
+}
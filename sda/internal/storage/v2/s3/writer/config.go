@@ -83,6 +83,7 @@ func loadConfig(backendName string) ([]*endpointConfig, error) {
 				return nil, errors.New("unsupported or no scheme in endpoint")
 			}
 
+			e.chunkSizeBytes = 50 * 1024 * 1024
 			if e.ChunkSize != "" {
 				byteSize, err := datasize.ParseString(e.ChunkSize)
 				if err != nil {
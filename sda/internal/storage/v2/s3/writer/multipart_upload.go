@@ -0,0 +1,212 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+)
+
+// StartUpload starts a multipart upload of the file to the active bucket, and returns the location and the upload id
+func (writer *Writer) StartUpload(ctx context.Context, filePath string) (string, string, error) {
+	activeEndpoint, activeBucket, err := writer.findActiveEndpointAndBucket(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	client, err := activeEndpoint.getS3Client(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	output, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(activeBucket),
+		Key:    aws.String(filePath),
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create multipart upload of object: %s, bucket: %s, endpoint: %s, due to: %v", filePath, activeBucket, activeEndpoint.Endpoint, err)
+	}
+
+	return activeEndpoint.Endpoint + "/" + activeBucket, aws.ToString(output.UploadId), nil
+}
+
+// maxUploadParts, and maxPartSize are the maximum amount of parts of a multipart upload, and the maximum size of a part
+const (
+	maxUploadParts = 10000
+	maxPartSize    = 5 * 1024 * 1024 * 1024
+)
+
+// PartSize returns the size of the parts to upload a file of fileSize to the location, all parts except the last need
+// to be of at least this size. The part size is the chunk size of the endpoint, unless bigger parts are needed for the
+// file to fit in the maximum amount of parts of a multipart upload
+func (writer *Writer) PartSize(location string, fileSize int64) (int64, error) {
+	endpoint, _, err := parseLocation(location)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, e := range writer.configuredEndpoints {
+		if e.Endpoint != endpoint {
+			continue
+		}
+
+		// Type conversation safe as chunkSizeBytes checked to be between 5mb and 1gb (in bytes)
+		//nolint:gosec // disable G115
+		partSize := max(int64(e.chunkSizeBytes), (fileSize+maxUploadParts-1)/maxUploadParts)
+		if partSize > maxPartSize {
+			return 0, fmt.Errorf("file of size: %d bytes, %w", fileSize, storageerrors.ErrorFileTooBigForUpload)
+		}
+
+		return partSize, nil
+	}
+
+	return 0, storageerrors.ErrorNoEndpointConfiguredForLocation
+}
+
+// UploadPart uploads a part of a multipart upload, uploading a part with the same part number again replaces the
+// previously uploaded part
+func (writer *Writer) UploadPart(ctx context.Context, location, filePath, uploadID string, partNumber int32, partContent io.ReadSeeker) error {
+	client, bucket, err := writer.clientAndBucketForLocation(ctx, location)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.UploadPart(ctx, &s3.UploadPartInput{
+		Body:       partContent,
+		Bucket:     aws.String(bucket),
+		Key:        aws.String(filePath),
+		PartNumber: aws.Int32(partNumber),
+		UploadId:   aws.String(uploadID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload part: %d of object: %s, location: %s, due to: %w", partNumber, filePath, location, uploadError(err))
+	}
+
+	return nil
+}
+
+// CompleteUpload completes a multipart upload from all the parts which have been uploaded
+func (writer *Writer) CompleteUpload(ctx context.Context, location, filePath, uploadID string) error {
+	client, bucket, err := writer.clientAndBucketForLocation(ctx, location)
+	if err != nil {
+		return err
+	}
+
+	// The parts are listed rather than tracked by the caller, as the upload could have been started by another process
+	var completedParts []types.CompletedPart
+	paginator := s3.NewListPartsPaginator(client, &s3.ListPartsInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(filePath),
+		UploadId: aws.String(uploadID),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list parts of object: %s, location: %s, due to: %w", filePath, location, uploadError(err))
+		}
+		for _, part := range page.Parts {
+			completedParts = append(completedParts, types.CompletedPart{
+				ETag:       part.ETag,
+				PartNumber: part.PartNumber,
+			})
+		}
+	}
+
+	_, err = client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucket),
+		Key:             aws.String(filePath),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completedParts},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload of object: %s, location: %s, due to: %w", filePath, location, uploadError(err))
+	}
+
+	return nil
+}
+
+// AbortUpload aborts a multipart upload and removes the parts which have been uploaded
+func (writer *Writer) AbortUpload(ctx context.Context, location, filePath, uploadID string) error {
+	client, bucket, err := writer.clientAndBucketForLocation(ctx, location)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(filePath),
+		UploadId: aws.String(uploadID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to abort multipart upload of object: %s, location: %s, due to: %w", filePath, location, uploadError(err))
+	}
+
+	return nil
+}
+
+// AbortIncompleteUploads aborts all multipart uploads of the object which were initiated before startedBefore, and
+// removes the parts which have been uploaded to them
+func (writer *Writer) AbortIncompleteUploads(ctx context.Context, location, filePath string, startedBefore time.Time) error {
+	client, bucket, err := writer.clientAndBucketForLocation(ctx, location)
+	if err != nil {
+		return err
+	}
+
+	input := &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(filePath),
+	}
+	for {
+		output, err := client.ListMultipartUploads(ctx, input)
+		if err != nil {
+			return fmt.Errorf("failed to list multipart uploads of object: %s, location: %s, due to: %v", filePath, location, err)
+		}
+
+		for _, upload := range output.Uploads {
+			// The prefix also matches other objects which key starts with the file path
+			if aws.ToString(upload.Key) != filePath || upload.Initiated == nil || !upload.Initiated.Before(startedBefore) {
+				continue
+			}
+			if err := writer.AbortUpload(ctx, location, filePath, aws.ToString(upload.UploadId)); err != nil && !errors.Is(err, storageerrors.ErrorUploadNotFound) {
+				return err
+			}
+		}
+
+		if !aws.ToBool(output.IsTruncated) {
+			return nil
+		}
+		input.KeyMarker = output.NextKeyMarker
+		input.UploadIdMarker = output.NextUploadIdMarker
+	}
+}
+
+func (writer *Writer) clientAndBucketForLocation(ctx context.Context, location string) (*s3.Client, string, error) {
+	endpoint, bucket, err := parseLocation(location)
+	if err != nil {
+		return nil, "", err
+	}
+
+	client, err := getS3ClientForEndpoint(ctx, writer.configuredEndpoints, endpoint)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return client, bucket, nil
+}
+
+// uploadError wraps no such upload errors with storageerrors.ErrorUploadNotFound
+func uploadError(err error) error {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchUpload" {
+		return fmt.Errorf("%w: %v", storageerrors.ErrorUploadNotFound, err)
+	}
+
+	return err
+}
@@ -12,13 +12,43 @@ import (
 )
 
 func (writer *Writer) WriteFile(ctx context.Context, filePath string, fileContent io.Reader) (string, error) {
-	// Find endpoint / bucket that is to be used for writing
+	activeEndpoint, activeBucket, err := writer.findActiveEndpointAndBucket(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	client, err := activeEndpoint.getS3Client(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	uploader := transfermanager.New(client, func(u *transfermanager.Options) {
+		// Type conversation safe as chunkSizeBytes checked to be between 5mb and 1gb (in bytes)
+		//nolint:gosec // disable G115
+		u.PartSizeBytes = int64(activeEndpoint.chunkSizeBytes)
+	})
+
+	_, err = uploader.UploadObject(ctx, &transfermanager.UploadObjectInput{
+		Body:   fileContent,
+		Bucket: aws.String(activeBucket),
+		Key:    aws.String(filePath),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload object: %s, bucket: %s, endpoint: %s, due to: %v", filePath, activeBucket, activeEndpoint.Endpoint, err)
+	}
+
+	return activeEndpoint.Endpoint + "/" + activeBucket, nil
+}
+
+// findActiveEndpointAndBucket finds the endpoint and bucket that is to be used for writing, rolling over to the next
+// endpoint if the current active endpoint no longer has any free buckets
+func (writer *Writer) findActiveEndpointAndBucket(ctx context.Context) (*endpointConfig, string, error) {
 	writer.Lock()
+	defer writer.Unlock()
+
 	activeBucket, err := writer.activeEndpoint.findActiveBucket(ctx, writer.backendName, writer.locationBroker)
 	if err != nil && !errors.Is(err, storageerrors.ErrorNoFreeBucket) {
-		writer.Unlock()
-
-		return "", err
+		return nil, "", err
 	}
 	// Current active endpoint no longer has any free buckets, roll over to next endpoint
 	if activeBucket == "" {
@@ -33,36 +63,14 @@ func (writer *Writer) WriteFile(ctx context.Context, filePath string, fileConten
 				if errors.Is(err, storageerrors.ErrorNoFreeBucket) {
 					continue
 				}
-				writer.Unlock()
 
-				return "", err
+				return nil, "", err
 			}
 			writer.activeEndpoint = endpointConf
 
 			break
 		}
 	}
-	writer.Unlock()
-
-	client, err := writer.activeEndpoint.getS3Client(ctx)
-	if err != nil {
-		return "", err
-	}
-
-	uploader := transfermanager.New(client, func(u *transfermanager.Options) {
-		// Type conversation safe as chunkSizeBytes checked to be between 5mb and 1gb (in bytes)
-		//nolint:gosec // disable G115
-		u.PartSizeBytes = int64(writer.activeEndpoint.chunkSizeBytes)
-	})
-
-	_, err = uploader.UploadObject(ctx, &transfermanager.UploadObjectInput{
-		Body:   fileContent,
-		Bucket: aws.String(activeBucket),
-		Key:    aws.String(filePath),
-	})
-	if err != nil {
-		return "", fmt.Errorf("failed to upload object: %s, bucket: %s, endpoint: %s, due to: %v", filePath, activeBucket, writer.activeEndpoint.Endpoint, err)
-	}
 
-	return writer.activeEndpoint.Endpoint + "/" + activeBucket, nil
+	return writer.activeEndpoint, activeBucket, nil
 }
@@ -42,7 +42,7 @@ func NewWriter(ctx context.Context, backendName string, locationBroker locationb
 		locationBroker: locationBroker,
 	}
 	writer.locationBroker.RegisterSizeAndCountFinderFunc(backendName, func(location string) bool {
-		return !strings.HasPrefix(location, "/")
+		return isS3Location(location)
 	}, findSizeAndObjectCountOfLocation(endPointConf))
 
 	// Verify endpointConfig connections
@@ -87,6 +87,17 @@ func getS3ClientForEndpoint(ctx context.Context, configuredEndpoints []*endpoint
 	return nil, storageerrors.ErrorNoEndpointConfiguredForLocation
 }
 
+// isS3Location checks if a location could belong to a s3 endpoint, i.e it is not a posix path and has either no
+// scheme, or a http(s) scheme, as the other storage implementations have their own scheme
+func isS3Location(location string) bool {
+	if strings.HasPrefix(location, "/") {
+		return false
+	}
+	scheme, _, found := strings.Cut(location, "://")
+
+	return !found || scheme == "http" || scheme == "https"
+}
+
 // parseLocation attempts to parse a location to a s3 endpoint, and a bucket
 // expected format of location is "${ENDPOINT}/${BUCKET}
 func parseLocation(location string) (string, string, error) {
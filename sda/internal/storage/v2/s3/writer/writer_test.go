@@ -12,6 +12,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/neicnordic/sensitive-data-archive/internal/database"
 	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/locationbroker"
@@ -61,10 +62,23 @@ func (m *mockLocationBroker) RegisterSizeAndCountFinderFunc(_ string, _ func(str
 type mockS3 struct {
 	server  *httptest.Server
 	buckets map[string]map[string]string // "bucket name" -> "file name" -> "content"
+	uploads map[string]map[int]string    // "upload id" -> "part number" -> "content"
+	starts  map[string]uploadStart       // "upload id" -> key and time the upload was initiated
+}
+
+type uploadStart struct {
+	key       string
+	initiated time.Time
 }
 
 func (m *mockS3) handler(w http.ResponseWriter, req *http.Request) {
 	switch {
+	case req.URL.Query().Has("uploads") && req.Method == "GET":
+		m.ListMultipartUploads(w, req)
+	case req.URL.Query().Has("uploads"):
+		m.CreateMultipartUpload(w, req)
+	case req.URL.Query().Has("uploadId"):
+		m.MultipartUpload(w, req)
 	case strings.HasSuffix(req.RequestURI, "PutObject"):
 		m.PutObject(w, req)
 	case strings.HasSuffix(req.RequestURI, "ListBuckets"):
@@ -162,6 +176,128 @@ func (m *mockS3) PutObject(w http.ResponseWriter, req *http.Request) {
 	m.buckets[bucket][fileName] = string(content)
 }
 
+func (m *mockS3) CreateMultipartUpload(w http.ResponseWriter, req *http.Request) {
+	bucket := strings.Split(req.URL.Path, "/")[1]
+	if _, ok := m.buckets[bucket]; !ok {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	uploadID := fmt.Sprintf("upload-%d", len(m.starts)+1)
+	m.uploads[uploadID] = make(map[int]string)
+	m.starts[uploadID] = uploadStart{key: strings.Split(req.URL.Path, "/")[2], initiated: time.Now()}
+
+	_, _ = w.Write([]byte(`
+<?xml version="1.0" encoding="UTF-8"?>
+<InitiateMultipartUploadResult>
+   <UploadId>` + uploadID + `</UploadId>
+</InitiateMultipartUploadResult>
+`))
+}
+
+func (m *mockS3) ListMultipartUploads(w http.ResponseWriter, req *http.Request) {
+	prefix := req.URL.Query().Get("prefix")
+
+	var b strings.Builder
+	_, _ = b.WriteString(`
+<?xml version="1.0" encoding="UTF-8"?>
+<ListMultipartUploadsResult>
+   <IsTruncated>false</IsTruncated>`)
+	for uploadID := range m.uploads {
+		start := m.starts[uploadID]
+		if !strings.HasPrefix(start.key, prefix) {
+			continue
+		}
+		_, _ = b.WriteString(`
+   <Upload>
+      <Key>` + start.key + `</Key>
+      <UploadId>` + uploadID + `</UploadId>
+      <Initiated>` + start.initiated.UTC().Format(time.RFC3339) + `</Initiated>
+   </Upload>`)
+	}
+	_, _ = b.WriteString(`
+</ListMultipartUploadsResult>
+`)
+	_, _ = w.Write([]byte(b.String()))
+}
+
+// MultipartUpload handles UploadPart, ListParts, CompleteMultipartUpload, and AbortMultipartUpload requests
+func (m *mockS3) MultipartUpload(w http.ResponseWriter, req *http.Request) {
+	bucket := strings.Split(req.URL.Path, "/")[1]
+	fileName := strings.Split(req.URL.Path, "/")[2]
+	uploadID := req.URL.Query().Get("uploadId")
+
+	parts, ok := m.uploads[uploadID]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`
+<?xml version="1.0" encoding="UTF-8"?>
+<Error>
+   <Code>NoSuchUpload</Code>
+   <Message>The specified upload does not exist.</Message>
+</Error>
+`))
+
+		return
+	}
+
+	switch req.Method {
+	case "PUT":
+		content, err := io.ReadAll(req.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+		partNumber, err := strconv.Atoi(req.URL.Query().Get("partNumber"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+		parts[partNumber] = string(content)
+		w.Header().Set("ETag", fmt.Sprintf("\"etag-%d\"", partNumber))
+	case "GET":
+		var b strings.Builder
+		_, _ = b.WriteString(`
+<?xml version="1.0" encoding="UTF-8"?>
+<ListPartsResult>
+   <IsTruncated>false</IsTruncated>`)
+		for partNumber, content := range parts {
+			_, _ = b.WriteString(`
+   <Part>
+      <PartNumber>` + strconv.Itoa(partNumber) + `</PartNumber>
+      <ETag>"etag-` + strconv.Itoa(partNumber) + `"</ETag>
+      <Size>` + strconv.Itoa(len(content)) + `</Size>
+   </Part>`)
+		}
+		_, _ = b.WriteString(`
+</ListPartsResult>
+`)
+		_, _ = w.Write([]byte(b.String()))
+	case "POST":
+		var content strings.Builder
+		for partNumber := 1; partNumber <= len(parts); partNumber++ {
+			_, _ = content.WriteString(parts[partNumber])
+		}
+		m.buckets[bucket][fileName] = content.String()
+		delete(m.uploads, uploadID)
+
+		_, _ = w.Write([]byte(`
+<?xml version="1.0" encoding="UTF-8"?>
+<CompleteMultipartUploadResult>
+   <Key>` + fileName + `</Key>
+</CompleteMultipartUploadResult>
+`))
+	case "DELETE":
+		delete(m.uploads, uploadID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
 func (m *mockS3) CreateBucket(w http.ResponseWriter, req *http.Request) {
 	bucket := strings.TrimPrefix(req.RequestURI, "/")
 
@@ -227,6 +363,10 @@ storage:
 func (ts *WriterTestSuite) SetupTest() {
 	ts.s3Mock1.buckets = map[string]map[string]string{}
 	ts.s3Mock2.buckets = map[string]map[string]string{}
+	ts.s3Mock1.uploads = map[string]map[int]string{}
+	ts.s3Mock2.uploads = map[string]map[int]string{}
+	ts.s3Mock1.starts = map[string]uploadStart{}
+	ts.s3Mock2.starts = map[string]uploadStart{}
 	ts.locationBrokerMock = &mockLocationBroker{}
 
 	var err error
@@ -365,6 +505,97 @@ func (ts *WriterTestSuite) TestWriteFile_NoMockLocationBroker_FirstBucketFull()
 	ts.Equal(fmt.Sprintf("%s/bucket_in_1-2", ts.s3Mock1.server.URL), location)
 }
 
+func (ts *WriterTestSuite) TestMultipartUpload() {
+	ts.locationBrokerMock.On("GetObjectCount", fmt.Sprintf("%s/bucket_in_1-1", ts.s3Mock1.server.URL)).Return(0, nil).Once()
+	ts.locationBrokerMock.On("GetSize", fmt.Sprintf("%s/bucket_in_1-1", ts.s3Mock1.server.URL)).Return(0, nil).Once()
+
+	location, uploadID, err := ts.writer.StartUpload(context.TODO(), "test_file_1.txt")
+	ts.NoError(err)
+	ts.Equal(fmt.Sprintf("%s/bucket_in_1-1", ts.s3Mock1.server.URL), location)
+
+	partSize, err := ts.writer.PartSize(location, 1024)
+	ts.NoError(err)
+	ts.Equal(int64(50*1024*1024), partSize)
+
+	ts.NoError(ts.writer.UploadPart(context.TODO(), location, "test_file_1.txt", uploadID, 1, strings.NewReader("first part, ")))
+	// Uploading the same part again replaces it
+	ts.NoError(ts.writer.UploadPart(context.TODO(), location, "test_file_1.txt", uploadID, 2, strings.NewReader("wrong part")))
+	ts.NoError(ts.writer.UploadPart(context.TODO(), location, "test_file_1.txt", uploadID, 2, strings.NewReader("second part")))
+	ts.NoError(ts.writer.CompleteUpload(context.TODO(), location, "test_file_1.txt", uploadID))
+
+	ts.Equal("first part, second part", ts.s3Mock1.buckets["bucket_in_1-1"]["test_file_1.txt"])
+	ts.Empty(ts.s3Mock1.uploads)
+}
+
+func (ts *WriterTestSuite) TestMultipartUpload_Abort() {
+	ts.locationBrokerMock.On("GetObjectCount", fmt.Sprintf("%s/bucket_in_1-1", ts.s3Mock1.server.URL)).Return(0, nil).Once()
+	ts.locationBrokerMock.On("GetSize", fmt.Sprintf("%s/bucket_in_1-1", ts.s3Mock1.server.URL)).Return(0, nil).Once()
+
+	location, uploadID, err := ts.writer.StartUpload(context.TODO(), "test_file_1.txt")
+	ts.NoError(err)
+	ts.NoError(ts.writer.UploadPart(context.TODO(), location, "test_file_1.txt", uploadID, 1, strings.NewReader("first part")))
+	ts.NoError(ts.writer.AbortUpload(context.TODO(), location, "test_file_1.txt", uploadID))
+
+	ts.Empty(ts.s3Mock1.uploads)
+	ts.NotContains(ts.s3Mock1.buckets["bucket_in_1-1"], "test_file_1.txt")
+}
+
+func (ts *WriterTestSuite) TestMultipartUpload_UploadNotFound() {
+	location := fmt.Sprintf("%s/bucket_in_1-1", ts.s3Mock1.server.URL)
+
+	err := ts.writer.UploadPart(context.TODO(), location, "test_file_1.txt", "not-existing-upload", 1, strings.NewReader("first part"))
+	ts.ErrorIs(err, storageerrors.ErrorUploadNotFound)
+
+	err = ts.writer.CompleteUpload(context.TODO(), location, "test_file_1.txt", "not-existing-upload")
+	ts.ErrorIs(err, storageerrors.ErrorUploadNotFound)
+}
+
+func (ts *WriterTestSuite) TestAbortIncompleteUploads() {
+	ts.locationBrokerMock.On("GetObjectCount", fmt.Sprintf("%s/bucket_in_1-1", ts.s3Mock1.server.URL)).Return(0, nil).Times(3)
+	ts.locationBrokerMock.On("GetSize", fmt.Sprintf("%s/bucket_in_1-1", ts.s3Mock1.server.URL)).Return(0, nil).Times(3)
+
+	location, _, err := ts.writer.StartUpload(context.TODO(), "test_file_1.txt")
+	ts.NoError(err)
+	_, _, err = ts.writer.StartUpload(context.TODO(), "test_file_1.txt")
+	ts.NoError(err)
+	_, otherUploadID, err := ts.writer.StartUpload(context.TODO(), "test_file_1.txt.c4gh")
+	ts.NoError(err)
+
+	// Uploads started after the given time are kept
+	ts.NoError(ts.writer.AbortIncompleteUploads(context.TODO(), location, "test_file_1.txt", time.Now().Add(-time.Hour)))
+	ts.Len(ts.s3Mock1.uploads, 3)
+
+	// Only uploads of the file are aborted, not those of other files with the file path as prefix
+	ts.NoError(ts.writer.AbortIncompleteUploads(context.TODO(), location, "test_file_1.txt", time.Now().Add(time.Second)))
+	ts.Len(ts.s3Mock1.uploads, 1)
+	ts.Contains(ts.s3Mock1.uploads, otherUploadID)
+}
+
+func (ts *WriterTestSuite) TestPartSize_BigFile() {
+	location := fmt.Sprintf("%s/bucket_in_1-1", ts.s3Mock1.server.URL)
+
+	// 50mb parts fit a file of up to 500000mb in the maximum amount of parts
+	partSize, err := ts.writer.PartSize(location, 10000*50*1024*1024)
+	ts.NoError(err)
+	ts.Equal(int64(50*1024*1024), partSize)
+
+	partSize, err = ts.writer.PartSize(location, 10000*50*1024*1024+1)
+	ts.NoError(err)
+	ts.Equal(int64(50*1024*1024+1), partSize)
+
+	partSize, err = ts.writer.PartSize(location, 1024*1024*1024*1024)
+	ts.NoError(err)
+	ts.LessOrEqual(int64(1024*1024*1024*1024), 10000*partSize)
+
+	_, err = ts.writer.PartSize(location, 10000*5*1024*1024*1024+1)
+	ts.ErrorIs(err, storageerrors.ErrorFileTooBigForUpload)
+}
+
+func (ts *WriterTestSuite) TestPartSize_InvalidLocation() {
+	_, err := ts.writer.PartSize("http://different_s3_url/bucket", 1024)
+	ts.ErrorIs(err, storageerrors.ErrorNoEndpointConfiguredForLocation)
+}
+
 type notImplementedDatabase struct {
 }
 
@@ -564,6 +795,10 @@ func (m *notImplementedDatabase) SetBackedUp(_ context.Context, _, _, _ string)
 	panic("function not expected to be called in unit tests")
 }
 
+func (m *notImplementedDatabase) SetRepaired(_ context.Context, _, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
+
 func (m *notImplementedDatabase) GetSizeAndObjectCountOfLocation(_ context.Context, location string) (uint64, uint64, error) {
 	panic("function not expected to be called in unit tests")
 }
@@ -583,3 +818,139 @@ func (m *notImplementedDatabase) IsFileInDataset(_ context.Context, _ string) (b
 func (m *notImplementedDatabase) CancelFile(_ context.Context, _, _ string) error {
 	panic("function not expected to be called in unit tests")
 }
+
+func (m *notImplementedDatabase) GetArchiveObject(_ context.Context, _ string, _ int64) (*database.ArchiveObject, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) GetUploadedArchiveObject(_ context.Context, _ string) (*database.ArchiveObject, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) ReferenceArchiveObject(_ context.Context, _, _ string, _ int64, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) DereferenceArchiveObject(_ context.Context, _, _ string) (bool, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) GetIngestCheckpoint(_ context.Context, _ string) (*database.IngestCheckpoint, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) SetIngestCheckpoint(_ context.Context, _ string, _ *database.IngestCheckpoint) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) DeleteIngestCheckpoint(_ context.Context, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) GetFilesToScrub(_ context.Context, _ time.Time, _ int) ([]*database.ScrubFile, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) SetFileScrubbed(_ context.Context, _ string, _ bool, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) SetArchiveLocation(_ context.Context, _, _, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) MigrateArchiveLocation(_ context.Context, _, _, _, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) GetMigratedFromLocation(_ context.Context, _ string) (string, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) GetFileIDsInArchiveLocation(_ context.Context, _, _, _ string) ([]string, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) IsArchivedObjectReferenced(_ context.Context, _, _ string) (bool, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) AddUploadedChecksum(_ context.Context, _, _, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) GetUploadChecksumState(_ context.Context, _ string, _ int32) (*database.UploadChecksumState, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) SetUploadChecksumState(_ context.Context, _ *database.UploadChecksumState) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) DeleteUploadChecksumStates(_ context.Context, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) GetInboxCopyOfFile(_ context.Context, _, _ string) (string, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) GetInboxQuota(_ context.Context, _ string) (*database.InboxQuota, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) SetInboxQuota(_ context.Context, _ *database.InboxQuota) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) DeleteInboxQuota(_ context.Context, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) GetInboxUsage(_ context.Context, _, _ string) (*database.InboxUsage, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) GetStaleInboxFiles(_ context.Context, _ string, _ time.Time, _ string, _ int) ([]*database.InboxFile, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) SetInboxExpiryWarned(_ context.Context, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) DisableInboxFile(_ context.Context, _, _ string, _ time.Time, _ string) (bool, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) GetFileSyncState(_ context.Context, _, _ string) (*database.FileSyncState, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) SetFileSyncState(_ context.Context, _ *database.FileSyncState) error {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) GetDatasetSyncStates(_ context.Context, _ string) ([]*database.FileSyncState, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) GetUnconfirmedSyncFiles(_ context.Context, _ string) ([]string, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) GetIngestionStatus(_ context.Context, _, _, _ string) (*database.IngestionStatus, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) RegisterSyncAPIRequest(_ context.Context, _, _ string, _, _ time.Time) (bool, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) DeleteSyncAPIRequests(_ context.Context, _ time.Time) (int64, error) {
+	panic("function not expected to be called in unit tests")
+}
+
+func (m *notImplementedDatabase) ReleaseSyncAPIRequest(_ context.Context, _, _ string) error {
+	panic("function not expected to be called in unit tests")
+}
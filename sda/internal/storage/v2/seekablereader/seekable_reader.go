@@ -0,0 +1,108 @@
+// Package seekablereader provides a read seeker for storage implementations which support ranged reads of objects
+package seekablereader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// RangeOpener opens a reader of length bytes of an object starting at offset
+type RangeOpener func(ctx context.Context, offset, length int64) (io.ReadCloser, error)
+
+// seekableReader keeps the last fetched chunk of the object in memory, and fetches a new chunk through the RangeOpener
+// when a read is outside the current chunk
+type seekableReader struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	openRange RangeOpener
+
+	objectSize    int64
+	chunkSize     int64
+	currentOffset int64
+
+	chunk      []byte
+	chunkStart int64
+}
+
+// New returns a read seeker of an object of objectSize bytes, which is fetched chunkSize bytes at a time
+func New(ctx context.Context, objectSize, chunkSize int64, openRange RangeOpener) io.ReadSeekCloser {
+	ctx, cancel := context.WithCancel(ctx)
+
+	return &seekableReader{
+		ctx:        ctx,
+		cancel:     cancel,
+		openRange:  openRange,
+		objectSize: objectSize,
+		chunkSize:  chunkSize,
+	}
+}
+
+func (r *seekableReader) Read(dst []byte) (int, error) {
+	if r.currentOffset >= r.objectSize {
+		return 0, io.EOF
+	}
+
+	if r.currentOffset < r.chunkStart || r.currentOffset >= r.chunkStart+int64(len(r.chunk)) {
+		if err := r.fetchChunk(r.currentOffset); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(dst, r.chunk[r.currentOffset-r.chunkStart:])
+	r.currentOffset += int64(n)
+
+	return n, nil
+}
+
+func (r *seekableReader) fetchChunk(offset int64) error {
+	length := min(r.chunkSize, r.objectSize-offset)
+
+	rangeReader, err := r.openRange(r.ctx, offset, length)
+	if err != nil {
+		return err
+	}
+	defer rangeReader.Close()
+
+	chunk := make([]byte, length)
+	if _, err := io.ReadFull(rangeReader, chunk); err != nil {
+		return fmt.Errorf("failed to read range %d-%d, due to: %v", offset, offset+length-1, err)
+	}
+
+	r.chunk = chunk
+	r.chunkStart = offset
+
+	return nil
+}
+
+func (r *seekableReader) Seek(offset int64, whence int) (int64, error) {
+	var newOffset int64
+	switch whence {
+	case io.SeekStart:
+		newOffset = offset
+	case io.SeekCurrent:
+		newOffset = r.currentOffset + offset
+	case io.SeekEnd:
+		newOffset = r.objectSize + offset
+	default:
+		return r.currentOffset, errors.New("bad whence")
+	}
+
+	if newOffset < 0 {
+		return r.currentOffset, fmt.Errorf("invalid offset %v, would be before start of object", newOffset)
+	}
+	if newOffset > r.objectSize {
+		return r.currentOffset, fmt.Errorf("invalid offset %v, beyond end of object (size %v)", newOffset, r.objectSize)
+	}
+	r.currentOffset = newOffset
+
+	return r.currentOffset, nil
+}
+
+func (r *seekableReader) Close() error {
+	r.cancel()
+	r.chunk = nil
+
+	return nil
+}
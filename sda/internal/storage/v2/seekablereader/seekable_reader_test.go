@@ -0,0 +1,92 @@
+package seekablereader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type SeekableReaderTestSuite struct {
+	suite.Suite
+	content     []byte
+	rangeOpens  int
+	rangeOpener RangeOpener
+}
+
+func TestSeekableReaderTestSuite(t *testing.T) {
+	suite.Run(t, new(SeekableReaderTestSuite))
+}
+
+func (ts *SeekableReaderTestSuite) SetupTest() {
+	ts.content = []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	ts.rangeOpens = 0
+	ts.rangeOpener = func(_ context.Context, offset, length int64) (io.ReadCloser, error) {
+		ts.rangeOpens++
+
+		return io.NopCloser(bytes.NewReader(ts.content[offset : offset+length])), nil
+	}
+}
+
+func (ts *SeekableReaderTestSuite) TestReadAll() {
+	r := New(context.TODO(), int64(len(ts.content)), 10, ts.rangeOpener)
+	defer r.Close()
+
+	content, err := io.ReadAll(r)
+	ts.NoError(err)
+	ts.Equal(ts.content, content)
+	ts.Equal(4, ts.rangeOpens)
+}
+
+func (ts *SeekableReaderTestSuite) TestSeekAndRead() {
+	r := New(context.TODO(), int64(len(ts.content)), 10, ts.rangeOpener)
+	defer r.Close()
+
+	offset, err := r.Seek(-6, io.SeekEnd)
+	ts.NoError(err)
+	ts.Equal(int64(30), offset)
+
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(r, buf)
+	ts.NoError(err)
+	ts.Equal("uvw", string(buf))
+
+	offset, err = r.Seek(-13, io.SeekCurrent)
+	ts.NoError(err)
+	ts.Equal(int64(20), offset)
+
+	_, err = io.ReadFull(r, buf)
+	ts.NoError(err)
+	ts.Equal("klm", string(buf))
+
+	_, err = r.Seek(21, io.SeekStart)
+	ts.NoError(err)
+	_, err = io.ReadFull(r, buf)
+	ts.NoError(err)
+	ts.Equal("lmn", string(buf))
+	// Last read was served from the chunk fetched by the previous read
+	ts.Equal(2, ts.rangeOpens)
+}
+
+func (ts *SeekableReaderTestSuite) TestSeek_InvalidOffset() {
+	r := New(context.TODO(), int64(len(ts.content)), 10, ts.rangeOpener)
+	defer r.Close()
+
+	_, err := r.Seek(-1, io.SeekStart)
+	ts.ErrorContains(err, "before start of object")
+	_, err = r.Seek(1, io.SeekEnd)
+	ts.ErrorContains(err, "beyond end of object")
+}
+
+func (ts *SeekableReaderTestSuite) TestRead_RangeOpenerError() {
+	r := New(context.TODO(), int64(len(ts.content)), 10, func(_ context.Context, _, _ int64) (io.ReadCloser, error) {
+		return nil, errors.New("mock error")
+	})
+	defer r.Close()
+
+	_, err := r.Read(make([]byte, 5))
+	ts.ErrorContains(err, "mock error")
+}
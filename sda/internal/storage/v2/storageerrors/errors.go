@@ -9,4 +9,9 @@ var ErrorNoFreeBucket = errors.New("no free bucket")
 var ErrorNoEndpointConfiguredForLocation = errors.New("no endpoint configured for location")
 var ErrorNoValidWriter = errors.New("no valid writer configured")
 var ErrorNoValidReader = errors.New("no valid reader configured")
-var ErrorMultipleWritersNotSupported = errors.New("s3 writer and posix writer cannot be used at the same time")
+var ErrorMultipleWritersNotSupported = errors.New("multiple storage implementation writers cannot be used at the same time")
+var ErrorInvalidWriteQuorum = errors.New("write quorum can not be bigger than the amount of configured writers")
+var ErrorWriteQuorumTooSmall = errors.New("write quorum needs to be at least 1")
+var ErrorWriteQuorumNotReached = errors.New("file was not written to enough locations to reach the write quorum")
+var ErrorFileTooBigForUpload = errors.New("file is too big to be uploaded in the maximum amount of parts")
+var ErrorUploadNotFound = errors.New("upload not found, it may have been completed or aborted")
@@ -4,7 +4,10 @@ import (
 	"context"
 	"errors"
 	"io"
+	"time"
 
+	azurewriter "github.com/neicnordic/sensitive-data-archive/internal/storage/v2/azure/writer"
+	gcswriter "github.com/neicnordic/sensitive-data-archive/internal/storage/v2/gcs/writer"
 	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/locationbroker"
 	posixwriter "github.com/neicnordic/sensitive-data-archive/internal/storage/v2/posix/writer"
 	s3writer "github.com/neicnordic/sensitive-data-archive/internal/storage/v2/s3/writer"
@@ -18,36 +21,110 @@ type Writer interface {
 	WriteFile(ctx context.Context, filePath string, fileContent io.Reader) (location string, err error)
 }
 
+// ResumableWriter defines methods to write a file in parts, such that an interrupted write can be resumed by uploading
+// the remaining parts to the same upload
+type ResumableWriter interface {
+	// StartUpload will start an upload of the file to the active location, and return the location and the id of the
+	// upload
+	StartUpload(ctx context.Context, filePath string) (location, uploadID string, err error)
+	// PartSize returns the size of the parts to upload a file of fileSize to the location, all parts except the last
+	// need to be of at least this size. The part size is big enough for the file to fit in the maximum amount of parts
+	PartSize(location string, fileSize int64) (int64, error)
+	// UploadPart will upload a part of the upload, parts are numbered from 1
+	UploadPart(ctx context.Context, location, filePath, uploadID string, partNumber int32, partContent io.ReadSeeker) error
+	// CompleteUpload will complete the upload from all the uploaded parts
+	CompleteUpload(ctx context.Context, location, filePath, uploadID string) error
+	// AbortUpload will abort the upload and remove all uploaded parts
+	AbortUpload(ctx context.Context, location, filePath, uploadID string) error
+}
+
+// UploadAborter defines methods to clean up uploads which were started but never completed, such as multipart uploads
+// that were abandoned by the client
+type UploadAborter interface {
+	// AbortIncompleteUploads will abort all uploads of the file at the location which were started before
+	// startedBefore, and remove their uploaded parts
+	AbortIncompleteUploads(ctx context.Context, location, filePath string, startedBefore time.Time) error
+}
+
 type writer struct {
 	writer Writer
 }
 
+// AsResumableWriter returns the writer as a ResumableWriter if its storage implementation supports resumable writes
+func AsResumableWriter(w Writer) (ResumableWriter, bool) {
+	if wrapped, ok := w.(*writer); ok {
+		w = wrapped.writer
+	}
+	resumableWriter, ok := w.(ResumableWriter)
+
+	return resumableWriter, ok
+}
+
+// AsUploadAborter returns the writer as an UploadAborter if its storage implementation keeps incomplete uploads
+func AsUploadAborter(w Writer) (UploadAborter, bool) {
+	if wrapped, ok := w.(*writer); ok {
+		w = wrapped.writer
+	}
+	uploadAborter, ok := w.(UploadAborter)
+
+	return uploadAborter, ok
+}
+
 func NewWriter(ctx context.Context, backendName string, locationBroker locationbroker.LocationBroker) (Writer, error) {
 	w := &writer{}
 
-	var err error
+	writers, err := newWriters(ctx, backendName, locationBroker)
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(writers) {
+	case 0:
+		return nil, storageerrors.ErrorNoValidWriter
+	case 1:
+		w.writer = writers[0]
+	default:
+		return nil, storageerrors.ErrorMultipleWritersNotSupported
+	}
+
+	return w, nil
+}
+
+// newWriters initialises the writers of all storage implementations configured for the backendName
+func newWriters(ctx context.Context, backendName string, locationBroker locationbroker.LocationBroker) ([]Writer, error) {
+	// Writers are only added when set, to avoid storing typed nil pointers in the interface
+	var writers []Writer
+
 	s3Writer, err := s3writer.NewWriter(ctx, backendName, locationBroker)
 	if err != nil && !errors.Is(err, storageerrors.ErrorNoValidLocations) {
 		return nil, err
 	}
-	posixWriter, err := posixwriter.NewWriter(ctx, backendName, locationBroker)
+	if s3Writer != nil {
+		writers = append(writers, s3Writer)
+	}
+	gcsWriter, err := gcswriter.NewWriter(ctx, backendName, locationBroker)
 	if err != nil && !errors.Is(err, storageerrors.ErrorNoValidLocations) {
 		return nil, err
 	}
-
-	if s3Writer != nil && posixWriter != nil {
-		return nil, storageerrors.ErrorMultipleWritersNotSupported
+	if gcsWriter != nil {
+		writers = append(writers, gcsWriter)
 	}
-	switch {
-	case s3Writer != nil:
-		w.writer = s3Writer
-	case posixWriter != nil:
-		w.writer = posixWriter
-	default:
-		return nil, storageerrors.ErrorNoValidWriter
+	azureWriter, err := azurewriter.NewWriter(ctx, backendName, locationBroker)
+	if err != nil && !errors.Is(err, storageerrors.ErrorNoValidLocations) {
+		return nil, err
+	}
+	if azureWriter != nil {
+		writers = append(writers, azureWriter)
+	}
+	posixWriter, err := posixwriter.NewWriter(ctx, backendName, locationBroker)
+	if err != nil && !errors.Is(err, storageerrors.ErrorNoValidLocations) {
+		return nil, err
+	}
+	if posixWriter != nil {
+		writers = append(writers, posixWriter)
 	}
 
-	return w, nil
+	return writers, nil
 }
 
 func (w *writer) RemoveFile(ctx context.Context, location, filePath string) error {
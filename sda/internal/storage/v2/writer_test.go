@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"testing"
+
+	s3writer "github.com/neicnordic/sensitive-data-archive/internal/storage/v2/s3/writer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAsResumableWriter(t *testing.T) {
+	_, ok := AsResumableWriter(&writer{writer: &s3writer.Writer{}})
+	assert.True(t, ok)
+
+	_, ok = AsResumableWriter(&s3writer.Writer{})
+	assert.True(t, ok)
+
+	_, ok = AsResumableWriter(&writer{writer: newMockWriter("/posix", -1)})
+	assert.False(t, ok)
+}
+
+func TestAsUploadAborter(t *testing.T) {
+	_, ok := AsUploadAborter(&writer{writer: &s3writer.Writer{}})
+	assert.True(t, ok)
+
+	_, ok = AsUploadAborter(&writer{writer: newMockWriter("/posix", -1)})
+	assert.False(t, ok)
+}
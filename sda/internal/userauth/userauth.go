@@ -44,21 +44,7 @@ func (u *ValidateFromToken) Authenticate(r *http.Request) (jwt.Token, error) {
 	}
 	switch {
 	case r.Header.Get("X-Amz-Security-Token") != "":
-		tokenStr := r.Header.Get("X-Amz-Security-Token")
-		if tokenStr == "" {
-			return nil, errors.New("no access token supplied")
-		}
-		token, err := jwt.Parse([]byte(tokenStr), jwt.WithKeySet(u.Keyset, jws.WithInferAlgorithmFromKey(true)), jwt.WithValidate(true))
-		if err != nil {
-			return nil, err
-		}
-
-		iss, err := url.ParseRequestURI(token.Issuer())
-		if err != nil || iss.Hostname() == "" {
-			return nil, fmt.Errorf("failed to get issuer from token (%v)", iss)
-		}
-
-		return token, nil
+		return u.ValidateToken(r.Header.Get("X-Amz-Security-Token"))
 
 	case r.Header.Get("Authorization") != "":
 		authStr := r.Header.Get("Authorization")
@@ -83,6 +69,27 @@ func (u *ValidateFromToken) Authenticate(r *http.Request) (jwt.Token, error) {
 	}
 }
 
+// ValidateToken verifies the signature of the token against the keyset, and that the token is valid and has an issuer
+func (u *ValidateFromToken) ValidateToken(tokenStr string) (jwt.Token, error) {
+	if u == nil {
+		return nil, errors.New("error validating token keyset")
+	}
+	if tokenStr == "" {
+		return nil, errors.New("no access token supplied")
+	}
+	token, err := jwt.Parse([]byte(tokenStr), jwt.WithKeySet(u.Keyset, jws.WithInferAlgorithmFromKey(true)), jwt.WithValidate(true))
+	if err != nil {
+		return nil, err
+	}
+
+	iss, err := url.ParseRequestURI(token.Issuer())
+	if err != nil || iss.Hostname() == "" {
+		return nil, fmt.Errorf("failed to get issuer from token (%v)", iss)
+	}
+
+	return token, nil
+}
+
 // Function for reading the ega key in []byte
 func (u *ValidateFromToken) ReadJwtPubKeyPath(jwtpubkeypath string) error {
 	err := filepath.Walk(jwtpubkeypath,